@@ -0,0 +1,170 @@
+// Package acoustid looks up recordings in the AcoustID web service by their
+// Chromaprint fingerprint, to name songs that aren't in the local library.
+//
+// Lookups need an application API key from https://acoustid.org, read from
+// the ACOUSTID_API_KEY environment variable. Without one, Default returns
+// nil and no lookups are made.
+package acoustid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"song-recognition/chromaprint"
+	"song-recognition/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the lookup endpoint of the AcoustID web service.
+const DefaultURL = "https://api.acoustid.org/v2/lookup"
+
+// httpTimeout bounds a single lookup.
+const httpTimeout = 10 * time.Second
+
+// Recording is a MusicBrainz recording a fingerprint was found to be.
+type Recording struct {
+	ID      string // MusicBrainz recording ID
+	Title   string
+	Artists []string
+	// Score is how well the fingerprint matched, from 0 to 1.
+	Score float64
+}
+
+// Client queries the AcoustID web service.
+type Client struct {
+	APIKey string
+	URL    string
+	Client *http.Client
+}
+
+// NewClient returns a client for the AcoustID web service using apiKey.
+func NewClient(apiKey string) *Client {
+	return &Client{APIKey: apiKey, URL: DefaultURL, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+// Default returns a client with the API key of the ACOUSTID_API_KEY
+// environment variable, or nil when it isn't set.
+func Default() *Client {
+	apiKey := utils.GetEnv("ACOUSTID_API_KEY")
+	if apiKey == "" {
+		return nil
+	}
+	return NewClient(apiKey)
+}
+
+// LookupAudio fingerprints the first chromaprint.DefaultLength seconds of
+// mono samples and looks them up.
+func (c *Client) LookupAudio(ctx context.Context, samples []float64, sampleRate int) ([]Recording, error) {
+	duration := float64(len(samples)) / float64(sampleRate)
+	if limit := chromaprint.DefaultLength * sampleRate; len(samples) > limit {
+		samples = samples[:limit]
+	}
+
+	fingerprint, err := chromaprint.Fingerprint(samples, sampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("error computing fingerprint: %v", err)
+	}
+	if len(fingerprint) == 0 {
+		return nil, nil
+	}
+
+	return c.Lookup(ctx, chromaprint.Encode(fingerprint), duration)
+}
+
+// lookupResponse is the part of a lookup response that is used.
+type lookupResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			ID      string `json:"id"`
+			Title   string `json:"title"`
+			Artists []struct {
+				Name string `json:"name"`
+			} `json:"artists"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// Lookup returns the recordings with metadata that a compressed fingerprint
+// of audio lasting duration seconds was found to be, best first.
+func (c *Client) Lookup(ctx context.Context, fingerprint string, duration float64) ([]Recording, error) {
+	form := url.Values{
+		"client":      {c.APIKey},
+		"meta":        {"recordings"},
+		"format":      {"json"},
+		"duration":    {strconv.Itoa(int(math.Round(duration)))},
+		"fingerprint": {fingerprint},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AcoustID response: %v", err)
+	}
+
+	var response lookupResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("AcoustID responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(body[:min(len(body), 512)]))
+		}
+		return nil, fmt.Errorf("invalid AcoustID response: %v", err)
+	}
+	if response.Status != "ok" {
+		return nil, fmt.Errorf("AcoustID lookup failed: %s", response.Error.Message)
+	}
+
+	// A recording can be found under several results, keep its best score
+	best := map[string]Recording{}
+	for _, result := range response.Results {
+		for _, r := range result.Recordings {
+			if r.Title == "" {
+				continue
+			}
+			if current, ok := best[r.ID]; ok && current.Score >= result.Score {
+				continue
+			}
+
+			recording := Recording{ID: r.ID, Title: r.Title, Score: result.Score}
+			for _, artist := range r.Artists {
+				recording.Artists = append(recording.Artists, artist.Name)
+			}
+			best[r.ID] = recording
+		}
+	}
+
+	recordings := make([]Recording, 0, len(best))
+	for _, recording := range best {
+		recordings = append(recordings, recording)
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		if recordings[i].Score != recordings[j].Score {
+			return recordings[i].Score > recordings[j].Score
+		}
+		return recordings[i].ID < recordings[j].ID
+	})
+
+	return recordings, nil
+}
@@ -0,0 +1,258 @@
+// Package artwork fetches the cover images of songs as they are saved and
+// keeps them under Dir, named after the song IDs.
+//
+// Covers come from the Cover Art Archive for songs whose MusicBrainz release
+// is known, or else from the iTunes Search API.
+package artwork
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Dir is the directory covers are stored in. The server serves it under
+// /art/.
+const Dir = "art"
+
+// Default URLs of the services covers are fetched from.
+const (
+	DefaultCoverArtURL = "https://coverartarchive.org"
+	DefaultITunesURL   = "https://itunes.apple.com/search"
+)
+
+// httpTimeout bounds a single request.
+const httpTimeout = 15 * time.Second
+
+// maxImageSize caps the size of a downloaded cover.
+const maxImageSize = 5 << 20
+
+// extensions are the file name extensions of the image types accepted.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Client fetches covers.
+type Client struct {
+	CoverArtURL string
+	ITunesURL   string
+	Client      *http.Client
+}
+
+// NewClient returns a client of the default services.
+func NewClient() *Client {
+	return &Client{CoverArtURL: DefaultCoverArtURL, ITunesURL: DefaultITunesURL, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns a client when covers are enabled in the config file, or
+// nil.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		if config.Get().Artwork.Enabled {
+			defaultClient = NewClient()
+		}
+	})
+	return defaultClient
+}
+
+// Store fetches the cover of a song with the configured client and saves it
+// under Dir. releaseID is the song's MusicBrainz release ID, if known. It
+// returns the path of the saved cover, or "" when covers are disabled, none
+// is found or fetching it fails; failures are logged.
+func Store(ctx context.Context, songID uint32, title, artist, releaseID string) string {
+	client := Default()
+	if client == nil {
+		return ""
+	}
+
+	logger := utils.GetLogger()
+	image, contentType, err := client.Fetch(ctx, title, artist, releaseID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to fetch cover", slog.String("title", title), slog.String("artist", artist), slog.Any("error", xerrors.New(err)))
+		return ""
+	}
+	if image == nil {
+		return ""
+	}
+
+	path, err := Save(songID, image, contentType)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to save cover", slog.Any("error", xerrors.New(err)))
+		return ""
+	}
+	return path
+}
+
+// Fetch returns the cover of a song and its content type, or nil if none is
+// found.
+func (c *Client) Fetch(ctx context.Context, title, artist, releaseID string) ([]byte, string, error) {
+	if releaseID != "" {
+		image, contentType, err := c.download(ctx, c.CoverArtURL+"/release/"+url.PathEscape(releaseID)+"/front-500")
+		if err != nil || image != nil {
+			return image, contentType, err
+		}
+	}
+
+	imageURL, err := c.searchITunes(ctx, title, artist)
+	if err != nil || imageURL == "" {
+		return nil, "", err
+	}
+	return c.download(ctx, imageURL)
+}
+
+// searchITunes returns the URL of the cover of the first iTunes track of
+// title by artist.
+func (c *Client) searchITunes(ctx context.Context, title, artist string) (string, error) {
+	params := url.Values{"term": {artist + " " + title}, "entity": {"song"}, "limit": {"10"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.ITunesURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("iTunes responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	var response struct {
+		Results []struct {
+			TrackName  string `json:"trackName"`
+			ArtistName string `json:"artistName"`
+			ArtworkURL string `json:"artworkUrl100"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("invalid iTunes response: %v", err)
+	}
+
+	// Searches match loosely, so only take a track by the same artist
+	for _, result := range response.Results {
+		if result.ArtworkURL != "" && strings.EqualFold(result.ArtistName, artist) &&
+			strings.HasPrefix(strings.ToLower(result.TrackName), strings.ToLower(title)) {
+			// The 100x100 URL serves any size up to the original
+			return strings.Replace(result.ArtworkURL, "100x100bb", "600x600bb", 1), nil
+		}
+	}
+	return "", nil
+}
+
+// download returns the image at imageURL and its content type, or nil if
+// there is none.
+func (c *Client) download(ctx context.Context, imageURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("cover responded with status %d", resp.StatusCode)
+	}
+
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download cover: %v", err)
+	}
+	if len(image) > maxImageSize {
+		return nil, "", fmt.Errorf("cover is larger than %d bytes", maxImageSize)
+	}
+
+	contentType := http.DetectContentType(image)
+	if _, ok := extensions[contentType]; !ok {
+		return nil, "", fmt.Errorf("cover has unsupported type %s", contentType)
+	}
+	return image, contentType, nil
+}
+
+// Save stores the cover of a song under Dir and returns its path.
+func Save(songID uint32, image []byte, contentType string) (string, error) {
+	ext, ok := extensions[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported cover type %s", contentType)
+	}
+	if err := utils.CreateFolder(Dir); err != nil {
+		return "", fmt.Errorf("failed to create %s directory: %v", Dir, err)
+	}
+
+	// Covers of other types must not shadow this one
+	if err := Remove(songID); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(Dir, strconv.FormatUint(uint64(songID), 10)+ext)
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		return "", fmt.Errorf("failed to write cover: %v", err)
+	}
+	return path, nil
+}
+
+// Path returns the path of the stored cover of a song, if it has one.
+func Path(songID uint32) (string, bool) {
+	for _, ext := range extensions {
+		path := filepath.Join(Dir, strconv.FormatUint(uint64(songID), 10)+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Remove deletes the stored cover of a song, if any.
+func Remove(songID uint32) error {
+	for _, ext := range extensions {
+		path := filepath.Join(Dir, strconv.FormatUint(uint64(songID), 10)+ext)
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove cover: %v", err)
+		}
+	}
+	return nil
+}
+
+// URL returns where the cover of a song is served: its stored cover, or else
+// the thumbnail of its YouTube video, or "" if it has neither.
+func URL(songID uint32, youTubeID string) string {
+	if path, ok := Path(songID); ok {
+		return "/art/" + filepath.Base(path)
+	}
+	if youTubeID != "" {
+		return "https://i.ytimg.com/vi/" + youTubeID + "/hqdefault.jpg"
+	}
+	return ""
+}
@@ -0,0 +1,403 @@
+// Package backup snapshots a running instance, its database and the audio
+// files and covers of its songs, to a single archive, and restores
+// instances from such archives.
+//
+// The database is copied first, in one transaction, and then the files of
+// the songs in the copy. Files are named after the checksums of songs and
+// stored before the songs point to them, so every file the copy refers to
+// is there to be archived, however much is saved meanwhile.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"song-recognition/artwork"
+	"song-recognition/db"
+	"song-recognition/storage"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format and Version identify backup archives. The version is raised when
+// a change to the layout would make older builds misread it.
+const (
+	Format  = "seek-tune-backup"
+	Version = 2
+)
+
+// Names of the entries of archives. The manifest comes first, then the
+// database and its shards under shardPrefix, then files under filesPrefix
+// and covers under coversPrefix.
+const (
+	manifestEntry = "manifest.json"
+	databaseEntry = "database"
+	shardPrefix   = "shards/"
+	filesPrefix   = "files/"
+	coversPrefix  = "art/"
+)
+
+// pageSize is the number of songs read from the database at a time.
+const pageSize = 500
+
+// Manifest describes a backup.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Database is the DB_TYPE of the database copy in the archive, or empty
+	// if the database has no snapshots and was left to its own tools.
+	Database string `json:"database,omitempty"`
+	// Shards are the DB_TYPEs of the copies of the shards of DB_SHARDS in
+	// the archive, in their order.
+	Shards []string `json:"shards,omitempty"`
+	Songs  int      `json:"songs"`
+}
+
+// Result is what a backup or restore holds besides its manifest.
+type Result struct {
+	Manifest Manifest
+	Files    int // audio files and previews
+	Covers   int
+	Missing  int // files the database refers to that weren't found
+}
+
+// Create writes a gzipped tar archive of the database of DB_TYPE and of
+// the audio files, previews and covers of its songs to w, without stopping
+// clients writing to them. Databases without snapshots aren't archived, only
+// the files of their songs; sharded databases are archived with all their
+// shards, or, if none has snapshots, not at all.
+func Create(ctx context.Context, w io.Writer) (Result, error) {
+	logger := utils.GetLogger()
+
+	result := Result{Manifest: Manifest{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+	}}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return result, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	tmpDir, err := os.MkdirTemp("", "seek-tune-backup")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Songs are listed from the copy, so the files archived are those of
+	// the database archived
+	songs := dbClient
+	snapshotPath := filepath.Join(tmpDir, databaseEntry)
+	var snapshot db.DBClient
+	if snapshotter, ok := dbClient.(db.Snapshotter); ok {
+		snapshot, err = snapshotter.Snapshot(ctx, snapshotPath)
+		if err != nil && !errors.Is(err, db.ErrNoSnapshots) {
+			return result, err
+		}
+	}
+	if snapshot != nil {
+		defer snapshot.Close()
+		songs = snapshot
+		result.Manifest.Database = db.DBtype
+		for _, url := range db.ShardURLs {
+			dbType, _, _ := db.ShardSnapshotPath(url)
+			result.Manifest.Shards = append(result.Manifest.Shards, dbType)
+		}
+	} else {
+		logger.WarnContext(ctx, "The database has no snapshots, back it up with its own tools", slog.String("database", db.DBtype))
+	}
+
+	result.Manifest.Songs, err = songs.TotalSongs(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error counting songs: %v", err)
+	}
+
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+
+	manifest, err := json.MarshalIndent(result.Manifest, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := writeEntry(archive, manifestEntry, manifest); err != nil {
+		return result, err
+	}
+
+	if result.Manifest.Database != "" {
+		if err := writeFile(archive, databaseEntry, snapshotPath); err != nil {
+			return result, err
+		}
+	}
+	for i := range result.Manifest.Shards {
+		if err := writeFile(archive, shardPrefix+strconv.Itoa(i), db.ShardSnapshotFile(snapshotPath, i)); err != nil {
+			return result, err
+		}
+	}
+
+	store := storage.Default()
+	for offset := 0; ; offset += pageSize {
+		page, err := songs.ListSongs(ctx, offset, pageSize)
+		if err != nil {
+			return result, fmt.Errorf("error listing songs: %v", err)
+		}
+
+		for _, song := range page {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+
+			for _, key := range []string{song.FileKey, song.PreviewKey} {
+				if key == "" {
+					continue
+				}
+				err := archiveStored(ctx, archive, store, key, tmpDir)
+				if errors.Is(err, storage.ErrNotFound) {
+					logger.WarnContext(ctx, "Song file missing from storage", slog.String("key", key))
+					result.Missing++
+					continue
+				}
+				if err != nil {
+					return result, fmt.Errorf("error archiving %s: %v", key, err)
+				}
+				result.Files++
+			}
+
+			if path, ok := artwork.Path(song.ID); ok {
+				if err := writeFile(archive, coversPrefix+filepath.Base(path), path); err != nil {
+					return result, fmt.Errorf("error archiving cover of song %d: %v", song.ID, err)
+				}
+				result.Covers++
+			}
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return result, err
+	}
+	return result, compressed.Close()
+}
+
+// writeEntry adds an entry holding content to archive.
+func writeEntry(archive *tar.Writer, name string, content []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := archive.Write(content)
+	return err
+}
+
+// writeFile adds the file at path to archive as name.
+func writeFile(archive *tar.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, file)
+	return err
+}
+
+// archiveStored adds the file stored under key to archive. Entries need
+// their size up front, which storages don't tell, so the file is spooled
+// to tmpDir first.
+func archiveStored(ctx context.Context, archive *tar.Writer, store storage.Storage, key, tmpDir string) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	spool, err := os.CreateTemp(tmpDir, "file")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+
+	_, err = io.Copy(spool, body)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return writeFile(archive, filesPrefix+key, spool.Name())
+}
+
+// Restore rebuilds an instance from an archive written by Create. The
+// database copy replaces the file of DB_TYPE, which must be the database
+// backed up, and the copies of shards those of DB_SHARDS, which must be
+// the shards backed up; a database already there is only replaced if force
+// is set.
+// The instance must be stopped meanwhile. Files are put in the configured
+// storage and covers in artwork.Dir.
+func Restore(ctx context.Context, r io.Reader, force bool) (Result, error) {
+	var result Result
+
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return result, fmt.Errorf("not a backup archive: %v", err)
+	}
+	defer compressed.Close()
+	archive := tar.NewReader(compressed)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != manifestEntry {
+		return result, fmt.Errorf("not a backup archive")
+	}
+	if err := json.NewDecoder(archive).Decode(&result.Manifest); err != nil {
+		return result, fmt.Errorf("error reading manifest: %v", err)
+	}
+	if result.Manifest.Format != Format {
+		return result, fmt.Errorf("not a backup archive")
+	}
+	if result.Manifest.Version > Version {
+		return result, fmt.Errorf("backup version %d is newer than the supported version %d", result.Manifest.Version, Version)
+	}
+
+	databasePath := ""
+	if result.Manifest.Database != "" {
+		if result.Manifest.Database != db.DBtype {
+			return result, fmt.Errorf("the backup holds a %s database, but DB_TYPE is %s", result.Manifest.Database, db.DBtype)
+		}
+		databasePath, _ = db.SnapshotPath(db.DBtype)
+		if _, err := os.Stat(databasePath); err == nil && !force {
+			return result, fmt.Errorf("%s already exists", databasePath)
+		}
+	}
+
+	if len(result.Manifest.Shards) != len(db.ShardURLs) && result.Manifest.Database != "" {
+		return result, fmt.Errorf("the backup holds %d shards, but DB_SHARDS has %d", len(result.Manifest.Shards), len(db.ShardURLs))
+	}
+	shardPaths := make([]string, len(result.Manifest.Shards))
+	for i, shardType := range result.Manifest.Shards {
+		dbType, path, ok := db.ShardSnapshotPath(db.ShardURLs[i])
+		if !ok || dbType != shardType {
+			return result, fmt.Errorf("the backup holds a %s database as shard %d, but DB_SHARDS has %s", shardType, i, db.ShardURLs[i])
+		}
+		if _, err := os.Stat(path); err == nil && !force {
+			return result, fmt.Errorf("%s already exists", path)
+		}
+		shardPaths[i] = path
+	}
+
+	store := storage.Default()
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading archive: %v", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		switch {
+		case header.Name == databaseEntry && databasePath != "":
+			if err := restoreDatabase(archive, databasePath); err != nil {
+				return result, fmt.Errorf("error restoring database: %v", err)
+			}
+		case strings.HasPrefix(header.Name, shardPrefix):
+			i, err := strconv.Atoi(strings.TrimPrefix(header.Name, shardPrefix))
+			if err != nil || i < 0 || i >= len(shardPaths) {
+				return result, fmt.Errorf("unexpected entry %s", header.Name)
+			}
+			if err := restoreDatabase(archive, shardPaths[i]); err != nil {
+				return result, fmt.Errorf("error restoring shard %d: %v", i, err)
+			}
+		case strings.HasPrefix(header.Name, filesPrefix):
+			key := strings.TrimPrefix(header.Name, filesPrefix)
+			if err := store.Put(ctx, key, archive, header.Size); err != nil {
+				return result, fmt.Errorf("error restoring %s: %v", key, err)
+			}
+			result.Files++
+		case strings.HasPrefix(header.Name, coversPrefix):
+			if err := restoreCover(archive, strings.TrimPrefix(header.Name, coversPrefix)); err != nil {
+				return result, fmt.Errorf("error restoring cover: %v", err)
+			}
+			result.Covers++
+		}
+	}
+}
+
+// restoreDatabase writes the database copy next to path first, so a failed
+// restore never leaves a truncated database in place. SQLite's journal
+// files belong to the database replaced and are removed.
+func restoreDatabase(r io.Reader, path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".restore"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for _, journal := range []string{path + "-wal", path + "-shm"} {
+		if err := os.Remove(journal); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// restoreCover writes the cover named name to artwork.Dir.
+func restoreCover(r io.Reader, name string) error {
+	path, err := utils.SafeJoin(artwork.Dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(artwork.Dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+// Package bandcamp reads the tracks of Bandcamp album pages and registers
+// them, since many independent artists only publish their music there.
+//
+// Album pages carry the album in a data-tralbum attribute, as JSON with the
+// title and artist of every track and a 128 kbit/s MP3 stream of those the
+// artist lets be streamed.
+package bandcamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds a single request.
+const httpTimeout = 30 * time.Second
+
+// maxPageSize caps the album pages read.
+const maxPageSize = 5 << 20
+
+// Album is a Bandcamp album.
+type Album struct {
+	Title       string
+	Artist      string
+	ReleaseYear int
+	Tracks      []Track
+}
+
+// Track is a track of an album.
+type Track struct {
+	Number int
+	Title  string
+	// Artist is that of the track, which differs from the album's on
+	// compilations.
+	Artist string
+	// Duration is in seconds.
+	Duration float64
+	// StreamURL is a 128 kbit/s MP3 of the track, valid for a day or so.
+	// It is empty for tracks that can't be streamed.
+	StreamURL string
+}
+
+// tralbum is the album JSON of an album page.
+type tralbum struct {
+	Artist  string `json:"artist"`
+	Current struct {
+		Title       string `json:"title"`
+		ReleaseDate string `json:"release_date"`
+	} `json:"current"`
+	AlbumReleaseDate string `json:"album_release_date"`
+	TrackInfo        []struct {
+		TrackNum int               `json:"track_num"`
+		Title    string            `json:"title"`
+		Artist   string            `json:"artist"`
+		Duration float64           `json:"duration"`
+		File     map[string]string `json:"file"`
+	} `json:"trackinfo"`
+}
+
+// variousArtists are the names compilations are credited to.
+var variousArtists = map[string]bool{"various artists": true, "various": true, "va": true, "v.a.": true}
+
+var tralbumPattern = regexp.MustCompile(`data-tralbum="([^"]*)"`)
+
+// releaseDatePattern finds the year of dates such as "02 Mar 2021 00:00:00 GMT".
+var releaseDatePattern = regexp.MustCompile(`\b(\d{4})\b`)
+
+// FetchAlbum reads the album at albumURL.
+func FetchAlbum(ctx context.Context, client *http.Client, albumURL string) (Album, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, albumURL, nil)
+	if err != nil {
+		return Album{}, fmt.Errorf("invalid album URL: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Album{}, fmt.Errorf("failed to fetch album page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Album{}, fmt.Errorf("album page responded with %s", resp.Status)
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return Album{}, fmt.Errorf("failed to read album page: %v", err)
+	}
+
+	return parseAlbum(page)
+}
+
+// parseAlbum reads the album of an album page.
+func parseAlbum(page []byte) (Album, error) {
+	match := tralbumPattern.FindSubmatch(page)
+	if match == nil {
+		return Album{}, errors.New("page holds no Bandcamp album")
+	}
+
+	var data tralbum
+	if err := json.Unmarshal([]byte(html.UnescapeString(string(match[1]))), &data); err != nil {
+		return Album{}, fmt.Errorf("failed to parse Bandcamp album: %v", err)
+	}
+
+	album := Album{Title: data.Current.Title, Artist: data.Artist}
+	releaseDate := data.AlbumReleaseDate
+	if releaseDate == "" {
+		releaseDate = data.Current.ReleaseDate
+	}
+	if year := releaseDatePattern.FindString(releaseDate); year != "" {
+		album.ReleaseYear, _ = strconv.Atoi(year)
+	}
+
+	for _, info := range data.TrackInfo {
+		track := Track{
+			Number:    info.TrackNum,
+			Title:     info.Title,
+			Artist:    info.Artist,
+			Duration:  info.Duration,
+			StreamURL: info.File["mp3-128"],
+		}
+		if track.Artist == "" && variousArtists[strings.ToLower(album.Artist)] {
+			// Compilations title their tracks "Artist - Title"
+			if artist, title, found := strings.Cut(track.Title, " - "); found {
+				track.Artist, track.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+			}
+		}
+		if track.Artist == "" {
+			track.Artist = album.Artist
+		}
+		album.Tracks = append(album.Tracks, track)
+	}
+
+	if len(album.Tracks) == 0 {
+		return Album{}, errors.New("Bandcamp album has no tracks")
+	}
+	return album, nil
+}
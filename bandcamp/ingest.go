@@ -0,0 +1,104 @@
+package bandcamp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"song-recognition/db"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"strconv"
+	"sync"
+)
+
+// IngestOptions tune IngestAlbum.
+type IngestOptions struct {
+	// Workers is the number of tracks processed at once.
+	Workers int
+}
+
+// IngestAlbum registers every track of the Bandcamp album at albumURL from
+// its stream, with the album's title and release year unless MusicBrainz
+// finds them. One result is returned per track, in album order; tracks
+// registered before are reported as such without downloading them again,
+// and those that can't be streamed fail.
+func IngestAlbum(ctx context.Context, albumURL string, opts IngestOptions) ([]song.BatchResult, error) {
+	album, err := FetchAlbum(ctx, &http.Client{Timeout: httpTimeout}, albumURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := song.NewPool(opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	results := make([]song.BatchResult, len(album.Tracks))
+	semaphore := make(chan struct{}, pool.Workers())
+	var wg sync.WaitGroup
+
+	for i, track := range album.Tracks {
+		results[i] = song.BatchResult{Index: i, Title: track.Title, Artist: track.Artist}
+
+		wg.Add(1)
+		go func(i int, track Track) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			response, err := ingestTrack(ctx, pool, dbClient, album, track)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Response = response
+		}(i, track)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// ingestTrack registers one track of album.
+func ingestTrack(ctx context.Context, pool *song.Pool, dbClient db.DBClient, album Album, track Track) (*song.ProcessResponse, error) {
+	existing, exists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(track.Title, track.Artist))
+	if err != nil {
+		return nil, fmt.Errorf("error checking song existence: %v", err)
+	}
+	if exists {
+		return &song.ProcessResponse{
+			Success:           true,
+			Message:           "Song already registered",
+			FingerprintID:     strconv.FormatUint(uint64(existing.ID), 10),
+			AlreadyRegistered: true,
+		}, nil
+	}
+
+	if track.StreamURL == "" {
+		return nil, fmt.Errorf("track %d of '%s' can't be streamed", track.Number, album.Title)
+	}
+
+	response, err := pool.Process(ctx, &song.SongInput{SongURL: track.StreamURL, Title: track.Title, Artist: track.Artist})
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.AlreadyRegistered {
+		song.SetAlbum(ctx, dbClient, response, album.Title, album.ReleaseYear)
+	}
+	return response, nil
+}
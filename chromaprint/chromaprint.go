@@ -0,0 +1,146 @@
+// Package chromaprint computes audio fingerprints in the format of
+// Chromaprint, the library behind fpcalc and the AcoustID database, so
+// songs can be looked up in and exchanged with tools of that ecosystem.
+//
+// It implements Chromaprint's default algorithm. Audio is resampled to
+// 11025 Hz and split into overlapping frames, and the energy of every frame
+// is folded into the 12 pitch classes. Sixteen classifiers, trained by the
+// Chromaprint authors, compare regions of the resulting chroma image and
+// contribute two bits each to a 32-bit value per frame.
+package chromaprint
+
+import (
+	"math"
+	"song-recognition/shazam"
+	"song-recognition/wav"
+)
+
+// Algorithm is the Chromaprint algorithm the fingerprints are computed with,
+// called TEST2 by Chromaprint and its default.
+const Algorithm = 1
+
+// DefaultLength is the number of seconds from the start of a song fpcalc
+// fingerprints by default, and what AcoustID lookups are made with.
+const DefaultLength = 120
+
+const (
+	sampleRate = 11025
+	frameSize  = 4096
+	frameStep  = frameSize / 3
+	minFreq    = 28
+	maxFreq    = 3520
+	// Chroma vectors quieter than this are silence and become zero.
+	silenceNorm = 0.01
+)
+
+// chromaFilter smooths the chroma image over time.
+var chromaFilter = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+// Fingerprint computes the Chromaprint fingerprint of mono samples: one
+// 32-bit value for every frame, about every 124 milliseconds, except the
+// last ones.
+func Fingerprint(samples []float64, rate int) ([]uint32, error) {
+	resampled, err := wav.Resample(samples, rate, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	image := chromaImage(resampled)
+	if len(image) < maxClassifierWidth {
+		return nil, nil
+	}
+
+	integral := newIntegralImage(image)
+	fingerprint := make([]uint32, len(image)-maxClassifierWidth+1)
+	for offset := range fingerprint {
+		var bits uint32
+		for _, c := range classifiers {
+			bits = bits<<2 | grayCodes[c.classify(integral, offset)]
+		}
+		fingerprint[offset] = bits
+	}
+
+	return fingerprint, nil
+}
+
+// chromaImage returns the smoothed, normalized chroma vectors of the frames
+// of samples at sampleRate.
+func chromaImage(samples []float64) [][12]float64 {
+	window := make([]float64, frameSize)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/(frameSize-1))
+	}
+
+	// Pitch class of every FFT bin used, and the bins they span
+	minIndex := max(1, int(math.Round(frameSize*minFreq/float64(sampleRate))))
+	maxIndex := min(frameSize/2, int(math.Round(frameSize*maxFreq/float64(sampleRate))))
+	notes := make([]int, maxIndex)
+	for i := minIndex; i < maxIndex; i++ {
+		freq := float64(i) * sampleRate / frameSize
+		octave := math.Log2(freq / (440.0 / 16))
+		notes[i] = int(12 * (octave - math.Floor(octave)))
+	}
+
+	var chroma [][12]float64
+	frame := make([]float64, frameSize)
+	for start := 0; start+frameSize <= len(samples); start += frameStep {
+		for i := range frame {
+			frame[i] = samples[start+i] * window[i]
+		}
+		spectrum := shazam.FFT(frame)
+
+		var features [12]float64
+		for i := minIndex; i < maxIndex; i++ {
+			re, im := real(spectrum[i]), imag(spectrum[i])
+			features[notes[i]] += re*re + im*im
+		}
+		chroma = append(chroma, features)
+	}
+
+	if len(chroma) < len(chromaFilter) {
+		return nil
+	}
+
+	image := make([][12]float64, len(chroma)-len(chromaFilter)+1)
+	for row := range image {
+		var norm float64
+		for note := range image[row] {
+			for j, coefficient := range chromaFilter {
+				image[row][note] += chroma[row+j][note] * coefficient
+			}
+			norm += image[row][note] * image[row][note]
+		}
+
+		norm = math.Sqrt(norm)
+		for note := range image[row] {
+			if norm < silenceNorm {
+				image[row][note] = 0
+			} else {
+				image[row][note] /= norm
+			}
+		}
+	}
+
+	return image
+}
+
+// integralImage holds the sums of the chroma image above and left of every
+// cell, so the sum of any rectangle takes four lookups.
+type integralImage [][13]float64
+
+func newIntegralImage(image [][12]float64) integralImage {
+	integral := make(integralImage, len(image)+1)
+	for row := range image {
+		for note := range image[row] {
+			integral[row+1][note+1] = image[row][note] + integral[row][note+1] +
+				integral[row+1][note] - integral[row][note]
+		}
+	}
+	return integral
+}
+
+// area returns the sum of rows r1 to r2 and notes c1 to c2, excluding r2
+// and c2.
+func (integral integralImage) area(r1, c1, r2, c2 int) float64 {
+	return integral[r2][c2] - integral[r1][c2] - integral[r2][c1] + integral[r1][c1]
+}
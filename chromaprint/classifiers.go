@@ -0,0 +1,85 @@
+package chromaprint
+
+import "math"
+
+// filter compares regions of the chroma image. It covers width frames from
+// the offset it is applied at and height pitch classes from y.
+type filter struct {
+	kind, y, height, width int
+}
+
+// classifier quantizes the response of its filter into one of four values
+// with three thresholds.
+type classifier struct {
+	filter     filter
+	thresholds [3]float64
+}
+
+// classifiers are those of Chromaprint's default algorithm.
+var classifiers = [16]classifier{
+	{filter{0, 4, 3, 15}, [3]float64{1.98215, 2.35817, 2.63523}},
+	{filter{4, 4, 6, 15}, [3]float64{-1.03809, -0.651211, -0.282167}},
+	{filter{1, 0, 4, 16}, [3]float64{-0.298702, 0.119262, 0.558497}},
+	{filter{3, 8, 2, 12}, [3]float64{-0.105439, 0.0153946, 0.135898}},
+	{filter{3, 4, 4, 8}, [3]float64{-0.142891, 0.0258736, 0.200632}},
+	{filter{4, 0, 3, 5}, [3]float64{-0.826319, -0.590612, -0.368214}},
+	{filter{1, 2, 2, 9}, [3]float64{-0.557409, -0.233035, 0.0534525}},
+	{filter{2, 7, 3, 4}, [3]float64{-0.0646826, 0.00620476, 0.0784847}},
+	{filter{2, 6, 2, 16}, [3]float64{-0.192387, -0.029699, 0.215855}},
+	{filter{2, 1, 3, 2}, [3]float64{-0.0397818, -0.00568076, 0.0292026}},
+	{filter{5, 10, 1, 15}, [3]float64{-0.53823, -0.369934, -0.190235}},
+	{filter{3, 6, 2, 10}, [3]float64{-0.124877, 0.0296483, 0.139239}},
+	{filter{2, 1, 1, 14}, [3]float64{-0.101475, 0.0225617, 0.231971}},
+	{filter{3, 5, 6, 4}, [3]float64{-0.0799915, -0.00729616, 0.063262}},
+	{filter{1, 9, 2, 12}, [3]float64{-0.272556, 0.019424, 0.302559}},
+	{filter{3, 4, 2, 14}, [3]float64{-0.164292, -0.0321188, 0.08463}},
+}
+
+// maxClassifierWidth is the number of frames the widest filter covers.
+const maxClassifierWidth = 16
+
+// grayCodes encode quantized values so neighbouring ones differ in one bit.
+var grayCodes = [4]uint32{0, 1, 3, 2}
+
+func (c classifier) classify(integral integralImage, offset int) int {
+	value := c.filter.apply(integral, offset)
+	switch {
+	case value < c.thresholds[0]:
+		return 0
+	case value < c.thresholds[1]:
+		return 1
+	case value < c.thresholds[2]:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// apply returns how much the first region of the filter outweighs the
+// second, on a logarithmic scale.
+func (f filter) apply(integral integralImage, x int) float64 {
+	y, w, h := f.y, f.width, f.height
+
+	var a, b float64
+	switch f.kind {
+	case 0: // the whole region
+		a = integral.area(x, y, x+w, y+h)
+	case 1: // upper against lower half of the pitch classes
+		a = integral.area(x, y+h/2, x+w, y+h)
+		b = integral.area(x, y, x+w, y+h/2)
+	case 2: // later against earlier half of the frames
+		a = integral.area(x+w/2, y, x+w, y+h)
+		b = integral.area(x, y, x+w/2, y+h)
+	case 3: // one pair of diagonal quarters against the other
+		a = integral.area(x, y+h/2, x+w/2, y+h) + integral.area(x+w/2, y, x+w, y+h/2)
+		b = integral.area(x, y, x+w/2, y+h/2) + integral.area(x+w/2, y+h/2, x+w, y+h)
+	case 4: // middle third of the pitch classes against the outer ones
+		a = integral.area(x, y+h/3, x+w, y+2*(h/3))
+		b = integral.area(x, y, x+w, y+h/3) + integral.area(x, y+2*(h/3), x+w, y+h)
+	case 5: // middle third of the frames against the outer ones
+		a = integral.area(x+w/3, y, x+2*(w/3), y+h)
+		b = integral.area(x, y, x+w/3, y+h) + integral.area(x+2*(w/3), y, x+w, y+h)
+	}
+
+	return math.Log1p(a) - math.Log1p(b)
+}
@@ -0,0 +1,69 @@
+package chromaprint
+
+import "encoding/base64"
+
+// Bits of the compressed format. Every set bit of a fingerprint value is
+// stored as its distance from the previous one, in three bits, with
+// distances of maxNormalBit or more continued in five more bits.
+const (
+	normalBits    = 3
+	maxNormalBit  = 1<<normalBits - 1
+	exceptionBits = 5
+)
+
+// Encode compresses a fingerprint the way Chromaprint does and returns it
+// in URL-safe base64, as printed by fpcalc and accepted by AcoustID.
+func Encode(fingerprint []uint32) string {
+	return base64.RawURLEncoding.EncodeToString(compress(fingerprint))
+}
+
+// compress packs the differences between consecutive fingerprint values
+// after a header of the algorithm and the number of values.
+func compress(fingerprint []uint32) []byte {
+	var distances []int
+	var previous uint32
+	for _, value := range fingerprint {
+		x := value ^ previous
+		previous = value
+
+		lastBit := 0
+		for bit := 1; x != 0; bit++ {
+			if x&1 != 0 {
+				distances = append(distances, bit-lastBit)
+				lastBit = bit
+			}
+			x >>= 1
+		}
+		distances = append(distances, 0)
+	}
+
+	length := len(fingerprint)
+	output := []byte{Algorithm, byte(length >> 16), byte(length >> 8), byte(length)}
+
+	var normal, exceptions bitWriter
+	for _, distance := range distances {
+		normal.write(min(distance, maxNormalBit), normalBits)
+		if distance >= maxNormalBit {
+			exceptions.write(distance-maxNormalBit, exceptionBits)
+		}
+	}
+
+	output = append(output, normal.bytes...)
+	return append(output, exceptions.bytes...)
+}
+
+// bitWriter packs values into bytes, low bits first.
+type bitWriter struct {
+	bytes []byte
+	used  int // bits used of the last byte
+}
+
+func (w *bitWriter) write(value, bits int) {
+	for i := 0; i < bits; i++ {
+		if w.used == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		w.bytes[len(w.bytes)-1] |= byte(value>>i&1) << w.used
+		w.used = (w.used + 1) % 8
+	}
+}
@@ -0,0 +1,87 @@
+// Package classify tags the audio of songs as they are saved, with labels
+// such as their genre, mood or whether they have vocals.
+//
+// The tagging is done by classifiers. Deployments can embed their own by
+// calling Register from an init function, or point the server at HTTP
+// classifiers in the config file. Every registered classifier runs on every
+// song.
+package classify
+
+import (
+	"context"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"sync"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Well-known tag names. Classifiers may set tags of any other name too.
+const (
+	Genre  = "genre"
+	Mood   = "mood"
+	Vocals = "vocals" // "vocal" or "instrumental"
+)
+
+// Tags are the labels of a song, keyed by name.
+type Tags map[string]string
+
+// Classifier tags the decoded mono samples of a song.
+type Classifier interface {
+	Classify(ctx context.Context, samples []float64, sampleRate int) (Tags, error)
+}
+
+type registered struct {
+	name       string
+	classifier Classifier
+}
+
+var (
+	mu          sync.RWMutex
+	classifiers []registered
+	configOnce  sync.Once
+)
+
+// Register adds a classifier under name. Classifiers run in the order they
+// were registered, and the tags of later ones win over earlier ones.
+func Register(name string, classifier Classifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	classifiers = append(classifiers, registered{name, classifier})
+}
+
+// registerConfigured registers the HTTP classifiers of the config file.
+func registerConfigured() {
+	for _, c := range config.Get().Classifiers {
+		Register(c.Name, NewHTTPClassifier(c.URL))
+	}
+}
+
+// Classify runs every classifier on samples and merges their tags. A
+// classifier that fails is logged and skipped, so a broken classifier
+// never keeps songs from being saved.
+func Classify(ctx context.Context, samples []float64, sampleRate int) Tags {
+	configOnce.Do(registerConfigured)
+	logger := utils.GetLogger()
+
+	mu.RLock()
+	current := append([]registered(nil), classifiers...)
+	mu.RUnlock()
+
+	tags := Tags{}
+	for _, c := range current {
+		classified, err := c.classifier.Classify(ctx, samples, sampleRate)
+		if err != nil {
+			logger.ErrorContext(ctx, "Classifier failed", slog.String("classifier", c.name), slog.Any("error", xerrors.New(err)))
+			continue
+		}
+		for name, value := range classified {
+			if name != "" && value != "" {
+				tags[name] = value
+			}
+		}
+	}
+
+	return tags
+}
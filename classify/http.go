@@ -0,0 +1,64 @@
+package classify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"song-recognition/utils"
+	"song-recognition/wav"
+	"time"
+)
+
+// httpTimeout bounds a single request to an HTTP classifier.
+const httpTimeout = time.Minute
+
+// HTTPClassifier sends the audio of songs to a classification service. The
+// service receives a POST of a mono 16-bit WAV file and responds with a
+// JSON object of tag names to values, such as {"genre": "jazz"}.
+type HTTPClassifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPClassifier returns a classifier posting to url.
+func NewHTTPClassifier(url string) *HTTPClassifier {
+	return &HTTPClassifier{URL: url, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+func (c *HTTPClassifier) Classify(ctx context.Context, samples []float64, sampleRate int) (Tags, error) {
+	pcm, err := utils.FloatsToBytes(samples, 16)
+	if err != nil {
+		return nil, fmt.Errorf("error converting samples to PCM: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := wav.WriteWav(&body, pcm, sampleRate, 1, 16); err != nil {
+		return nil, fmt.Errorf("error encoding WAV: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "audio/wav")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("classifier responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	var tags Tags
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("invalid classifier response: %v", err)
+	}
+	return tags, nil
+}
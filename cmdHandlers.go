@@ -1,23 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"fmt"
+	"image/png"
+	"io"
 	"log"
 	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"song-recognition/artwork"
+	"song-recognition/backup"
+	"song-recognition/bandcamp"
+	"song-recognition/config"
 	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/janitor"
+	"song-recognition/lastfm"
+	"song-recognition/monitor"
+	"song-recognition/previews"
+	"song-recognition/prune"
 	"song-recognition/shazam"
 	"song-recognition/song"
 	"song-recognition/spotify"
 	"song-recognition/utils"
+	"song-recognition/watch"
 	"song-recognition/wav"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	socketio "github.com/googollee/go-socket.io"
@@ -35,19 +53,24 @@ const (
 var yellow = color.New(color.FgYellow)
 
 func find(filePath string) {
-	wavInfo, err := wav.ReadWavInfo(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
-		yellow.Println("Error reading wave info:", err)
+		yellow.Println("Error opening file:", err)
 		return
 	}
+	defer file.Close()
 
-	samples, err := wav.WavBytesToSamples(wavInfo.Data)
+	// Stream the file so long recordings don't have to fit in memory
+	wavReader, err := wav.NewReader(bufio.NewReader(file))
 	if err != nil {
-		yellow.Println("Error converting to samples:", err)
+		yellow.Println("Error reading wave info:", err)
 		return
 	}
 
-	matches, searchDuration, err := shazam.FindMatches(samples, wavInfo.Duration, wavInfo.SampleRate)
+	opts := shazam.DefaultMatchOptions()
+	opts.TopN = 20
+
+	matches, searchDuration, err := shazam.FindMatchesFromReader(context.Background(), wavReader, wavReader.SampleRate, opts)
 	if err != nil {
 		yellow.Println("Error finding matches:", err)
 		return
@@ -78,26 +101,77 @@ func find(filePath string) {
 		topMatch.SongTitle, topMatch.SongArtist, topMatch.Score)
 }
 
+// tracklist prints the songs heard in a long recording, such as a DJ mix,
+// with the time each one starts and ends.
+func tracklist(filePath string, window, hop float64) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		yellow.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	wavReader, err := wav.NewReader(bufio.NewReader(file))
+	if err != nil {
+		yellow.Println("Error reading wave info:", err)
+		return
+	}
+
+	opts := shazam.TracklistOptions{Window: window, Hop: hop, Match: shazam.DefaultMatchOptions()}
+	entries, err := shazam.Tracklist(context.Background(), wavReader, wavReader.SampleRate, opts)
+	if err != nil {
+		yellow.Println("Error building tracklist:", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("\nNo songs recognized.")
+		return
+	}
+
+	fmt.Println("Tracklist:")
+	for _, entry := range entries {
+		fmt.Printf("\t%s - %s  %s by %s, score: %.2f\n",
+			shazam.FormatOffset(uint32(entry.Start*1000)), shazam.FormatOffset(uint32(entry.End*1000)),
+			entry.SongTitle, entry.SongArtist, entry.Score)
+	}
+}
+
+func monitorStream(name, streamURL string, window, hop float64) {
+	m := monitor.New(config.Stream{Name: name, URL: streamURL})
+	m.Window, m.Hop = window, hop
+	m.OnDetection = func(event monitor.Event) {
+		fmt.Printf("%s  [%s] %s by %s, score: %.2f\n",
+			event.DetectedAt.Format(time.RFC3339), event.Stream, event.SongTitle, event.SongArtist, event.Score)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Monitoring %s, press Ctrl+C to stop\n", streamURL)
+	m.Run(ctx)
+}
+
 func download(spotifyURL string) {
+	ctx := context.Background()
 
 	err := utils.CreateFolder(SONGS_DIR)
 	if err != nil {
 		err := xerrors.New(err)
 		logger := utils.GetLogger()
-		ctx := context.Background()
 		logMsg := fmt.Sprintf("failed to create directory %v", SONGS_DIR)
 		logger.ErrorContext(ctx, logMsg, slog.Any("error", err))
 	}
 
 	if strings.Contains(spotifyURL, "album") {
-		_, err := spotify.DlAlbum(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlAlbum(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
 	}
 
 	if strings.Contains(spotifyURL, "playlist") {
-		_, err := spotify.DlPlaylist(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlPlaylist(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
@@ -105,14 +179,147 @@ func download(spotifyURL string) {
 
 	if strings.Contains(spotifyURL, "track") {
 		fmt.Println("spotifyURL", spotifyURL)
-		_, err := spotify.DlSingleTrack(spotifyURL, SONGS_DIR)
+		_, err := spotify.DlSingleTrack(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			yellow.Println("Error: ", err)
 		}
 	}
 }
 
-func serve(protocol, port string) {
+func ingestSpotifyPlaylist(playlistURL, clientID, clientSecret string, opts spotify.IngestOptions) {
+	logger := utils.GetLogger()
+
+	if clientID == "" || clientSecret == "" {
+		fmt.Println("Spotify app credentials are required: set SPOTIFY_CLIENT_ID and SPOTIFY_CLIENT_SECRET, or pass -client-id and -client-secret")
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	api := spotify.NewWebAPI(clientID, clientSecret)
+	results, err := spotify.IngestPlaylist(ctx, api, playlistURL, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to ingest playlist", slog.Any("error", err))
+		return
+	}
+	printBatchResults(results)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	fmt.Printf("Ingested %d of %d tracks (%d failed)\n", len(results)-failed, len(results), failed)
+}
+
+func ingestBandcampAlbum(albumURL string, opts bandcamp.IngestOptions) {
+	logger := utils.GetLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results, err := bandcamp.IngestAlbum(ctx, albumURL, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to ingest album", slog.Any("error", err))
+		return
+	}
+	printBatchResults(results)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	fmt.Printf("Ingested %d of %d tracks (%d failed)\n", len(results)-failed, len(results), failed)
+}
+
+// lastFMLogin has a Last.fm user grant the configured API account access to
+// their profile and prints the session key to scrobble for them with.
+func lastFMLogin() {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	client := lastfm.Default()
+	if client == nil {
+		fmt.Println("Last.fm is not configured: set lastfm.api_key and lastfm.secret in the config file")
+		return
+	}
+
+	token, err := client.Token(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to start Last.fm login", slog.Any("error", err))
+		return
+	}
+
+	fmt.Println("Allow access to your Last.fm profile at", client.AuthTokenURL(token))
+	fmt.Print("then press Enter...")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+
+	sessionKey, user, err := client.Session(ctx, token)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to finish Last.fm login", slog.Any("error", err))
+		return
+	}
+	fmt.Printf("Session key of %s: %s\n", user, sessionKey)
+}
+
+func ingestPreviews(catalogName, query string, opts previews.IngestOptions) {
+	logger := utils.GetLogger()
+
+	catalog, err := previews.Lookup(catalogName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results, err := previews.Ingest(ctx, catalog, query, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to ingest previews", slog.Any("error", err))
+		return
+	}
+	printBatchResults(results)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	fmt.Printf("Ingested %d of %d previews (%d failed)\n", len(results)-failed, len(results), failed)
+}
+
+func ingestYouTubePlaylist(playlistURL string, opts spotify.YouTubeIngestOptions) {
+	logger := utils.GetLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results, err := spotify.IngestYouTubePlaylist(ctx, playlistURL, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to ingest playlist", slog.Any("error", err))
+		return
+	}
+	printBatchResults(results)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	fmt.Printf("Ingested %d of %d videos (%d failed)\n", len(results)-failed, len(results), failed)
+	if failed > 0 {
+		fmt.Println("Run the command again to retry the failed videos")
+	}
+}
+
+func serve(protocol, port, grpcPort string) {
 	protocol = strings.ToLower(protocol)
 	var allowOriginFunc = func(r *http.Request) bool {
 		return true
@@ -155,6 +362,46 @@ func serve(protocol, port string) {
 	}()
 	defer server.Close()
 
+	if cfg := config.Get().Bloom; cfg.Enabled {
+		db.EnableBloomFilter(cfg.FalsePositiveRate, time.Duration(cfg.RebuildMinutes)*time.Minute)
+	}
+	if cfg := config.Get().Postings; cfg.Enabled {
+		db.EnablePostingsCache(cfg.MaxCouples, time.Duration(cfg.TTLSeconds)*time.Second)
+	}
+	if cfg := config.Get().Index; cfg.Enabled {
+		index, err := db.OpenFingerprintIndex(cfg.Path)
+		if err != nil {
+			log.Printf("fingerprint index disabled: %v\n", err)
+		} else {
+			defer index.Close()
+			if index.Version() != shazam.FingerprintVersion() {
+				log.Printf("fingerprint index of version %s unused: the current version is %s, run build-index\n", index.Version(), shazam.FingerprintVersion())
+			}
+			shazam.UseFingerprintIndex(index)
+		}
+	}
+
+	pool, err := song.NewPool(runtime.NumCPU())
+	if err != nil {
+		log.Printf("job worker disabled: %v\n", err)
+	} else {
+		defer pool.Close()
+		go func() {
+			if err := song.RunJobWorker(context.Background(), pool); err != nil {
+				log.Printf("job worker stopped: %v\n", err)
+			}
+		}()
+	}
+
+	if grpcPort != "" {
+		go serveGRPC(grpcPort)
+	}
+
+	go monitor.RunConfigured(context.Background())
+	go watch.RunConfigured(context.Background())
+	go janitor.RunConfigured(context.Background())
+	go prune.RunConfigured(context.Background())
+
 	serveHTTPS := protocol == "https"
 
 	serveHTTP(server, serveHTTPS, port)
@@ -162,6 +409,7 @@ func serve(protocol, port string) {
 
 func serveHTTP(socketServer *socketio.Server, serveHTTPS bool, port string) {
 	http.Handle("/socket.io/", socketServer)
+	registerHTTPHandlers(http.DefaultServeMux)
 
 	if serveHTTPS {
 		httpsAddr := ":" + port
@@ -170,7 +418,7 @@ func serveHTTP(socketServer *socketio.Server, serveHTTPS bool, port string) {
 			TLSConfig: &tls.Config{
 				MinVersion: tls.VersionTLS12,
 			},
-			Handler: socketServer,
+			Handler: http.DefaultServeMux,
 		}
 
 		cert_key_default := "/etc/letsencrypt/live/localport.online/privkey.pem"
@@ -205,13 +453,43 @@ func erase(songsDir string) {
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
 	}
 
-	err = dbClient.DeleteCollection("fingerprints")
+	err = dbClient.DeleteCollection(ctx, "fingerprints")
 	if err != nil {
 		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
 	}
 
-	err = dbClient.DeleteCollection("songs")
+	err = dbClient.DeleteCollection(ctx, "songs")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "recognitions")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "detections")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "melodies")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "waveforms")
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
+	err = dbClient.DeleteCollection(ctx, "lsh_bands")
 	if err != nil {
 		msg := fmt.Sprintf("Error deleting collection: %v\n", err)
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
@@ -239,6 +517,12 @@ func erase(songsDir string) {
 		logger.ErrorContext(ctx, msg, slog.Any("error", err))
 	}
 
+	err = os.RemoveAll(artwork.Dir)
+	if err != nil {
+		msg := fmt.Sprintf("Error deleting covers: %v\n", err)
+		logger.ErrorContext(ctx, msg, slog.Any("error", err))
+	}
+
 	fmt.Println("Erase complete")
 }
 
@@ -309,7 +593,7 @@ func saveSong(filePath string, force bool) error {
 		return fmt.Errorf("no artist found in metadata")
 	}
 
-	err = spotify.ProcessAndSaveSong(filePath, track.Title, track.Artist, ytID)
+	err = spotify.ProcessAndSaveSong(context.Background(), filePath, track.Title, track.Artist, ytID)
 	if err != nil {
 		return fmt.Errorf("failed to process or save song: %v", err)
 	}
@@ -337,15 +621,505 @@ func processSongFromJSON(jsonPath string) {
 		return
 	}
 
-	// Process the song
-	response, err := song.ProcessSongJSON(jsonData)
+	// Process the song, or every song if the file holds an array
+	results, err := song.ProcessSongsJSON(ctx, jsonData)
 	if err != nil {
 		logger.ErrorContext(ctx, "Failed to process song", slog.Any("error", err))
 		return
 	}
 
-	// Print the response
-	fmt.Printf("Song processed successfully:\n")
-	fmt.Printf("  File path: %s\n", response.FilePath)
-	fmt.Printf("  Fingerprint ID: %s\n", response.FingerprintID)
+	printBatchResults(results)
+}
+
+// printBatchResults prints the response for each processed song.
+func printBatchResults(results []song.BatchResult) {
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("'%s' by '%s' failed: %s\n", result.Title, result.Artist, result.Error)
+			continue
+		}
+
+		fmt.Printf("'%s' by '%s' processed successfully:\n", result.Title, result.Artist)
+		fmt.Printf("  File path: %s\n", result.Response.FilePath)
+		fmt.Printf("  Fingerprint ID: %s\n", result.Response.FingerprintID)
+	}
+}
+
+func processSongFromFile(path string, input song.SongInput, workers int) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to stat path", slog.Any("error", err))
+		return
+	}
+
+	if fileInfo.IsDir() {
+		results, err := song.ProcessSongsFromDir(ctx, path, workers)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to process directory", slog.Any("error", err))
+			return
+		}
+		printBatchResults(results)
+		return
+	}
+
+	if input.Title == "" || input.Artist == "" {
+		guessed := song.MetadataFromFile(path)
+		if input.Title == "" {
+			input.Title = guessed.Title
+		}
+		if input.Artist == "" {
+			input.Artist = guessed.Artist
+		}
+	}
+
+	response, err := song.ProcessSongFromFile(ctx, path, &input)
+	if err != nil {
+		fmt.Printf("'%s' by '%s' failed: %s\n", input.Title, input.Artist, err)
+		return
+	}
+	printBatchResults([]song.BatchResult{{Title: input.Title, Artist: input.Artist, Response: response}})
+}
+
+func importCSV(csvPath, reportPath string, workers int) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	file, err := os.Open(csvPath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open CSV file", slog.Any("error", err))
+		return
+	}
+	defer file.Close()
+
+	results, err := song.ImportCSV(ctx, file, workers)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to import CSV", slog.Any("error", err))
+		return
+	}
+
+	if reportPath == "" {
+		reportPath = strings.TrimSuffix(csvPath, filepath.Ext(csvPath)) + "_report.csv"
+	}
+
+	report, err := os.Create(reportPath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create report file", slog.Any("error", err))
+		return
+	}
+	defer report.Close()
+
+	if err := song.WriteImportReport(report, results); err != nil {
+		logger.ErrorContext(ctx, "Failed to write report", slog.Any("error", err))
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	fmt.Printf("Imported %d of %d rows (%d failed, %d invalid)\n",
+		counts[song.ImportStatusOK], len(results),
+		counts[song.ImportStatusFailed], counts[song.ImportStatusInvalid])
+	fmt.Printf("Report written to %s\n", reportPath)
+}
+
+// manageJobs lists, requeues or purges dead-lettered jobs.
+func manageJobs(action string, jobIDs []string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	switch action {
+	case "dead":
+		jobs, err := song.ListDeadLetters(ctx)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to list dead-lettered jobs", slog.Any("error", err))
+			return
+		}
+
+		for _, job := range jobs {
+			fmt.Printf("%s  attempts=%d  failed=%s\n  %s\n",
+				job.ID, job.Attempts, job.UpdatedAt.Format(time.RFC3339), job.Error)
+		}
+		fmt.Printf("%d dead-lettered jobs\n", len(jobs))
+
+	case "requeue":
+		for _, jobID := range jobIDs {
+			requeued, err := song.RequeueDeadLetter(ctx, jobID)
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to requeue job", slog.Any("error", err))
+				continue
+			}
+			if !requeued {
+				fmt.Printf("%s is not a dead-lettered job\n", jobID)
+				continue
+			}
+			fmt.Printf("%s requeued\n", jobID)
+		}
+
+	case "purge":
+		purged, err := song.PurgeDeadLetters(ctx)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to purge dead-lettered jobs", slog.Any("error", err))
+			return
+		}
+		fmt.Printf("Purged %d dead-lettered jobs\n", purged)
+
+	default:
+		fmt.Println("Usage: main.go jobs <dead | requeue <job_id>... | purge>")
+		os.Exit(1)
+	}
+}
+
+// reindex re-fingerprints the songs stored in songsDir with the current
+// fingerprint settings, then reports the fingerprints left of other
+// versions, which belong to songs with no file there.
+func reindex(songsDir string, workers int) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	results, err := song.ReindexDir(ctx, songsDir, workers)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to reindex songs", slog.Any("error", err))
+		return
+	}
+	printBatchResults(results)
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating DB client", slog.Any("error", err))
+		return
+	}
+	defer dbClient.Close()
+
+	counts, err := dbClient.CountFingerprintsByVersion(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to count fingerprints", slog.Any("error", err))
+		return
+	}
+
+	current := shazam.FingerprintVersion()
+	for version, count := range counts {
+		if version != current {
+			fmt.Printf("%d fingerprints remain of version %s; save their songs again to rebuild them\n", count, version)
+		}
+	}
+	fmt.Println("Reindex complete")
+}
+
+// pruneFingerprints removes the fingerprints of the addresses more than
+// maxSongs songs share, or only counts them if dryRun is set.
+func pruneFingerprints(maxSongs int, dryRun bool) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating DB client", slog.Any("error", err))
+		return
+	}
+	defer dbClient.Close()
+
+	result, err := prune.Prune(ctx, dbClient, maxSongs, dryRun)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to prune fingerprints", slog.Any("error", err))
+	}
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	fmt.Printf("%s %d fingerprints at %d addresses shared by more than %d songs\n", verb, result.Fingerprints, result.Addresses, maxSongs)
+}
+
+func buildLSHIndex() {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	indexed, err := song.BuildLSHIndex(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to build LSH index", slog.Any("error", err))
+	}
+	fmt.Printf("Indexed %d songs\n", indexed)
+}
+
+func buildFingerprintIndex(path string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating DB client", slog.Any("error", err))
+		return
+	}
+	defer dbClient.Close()
+
+	songs, couples, err := db.WriteFingerprintIndex(ctx, dbClient, shazam.FingerprintVersion(), path)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to build fingerprint index", slog.Any("error", err))
+		return
+	}
+	fmt.Printf("Indexed %d fingerprints of %d songs in %s\n", couples, songs, path)
+}
+
+func moveFingerprintsToShards() {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	moved, err := db.MoveFingerprintsToShards(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to move fingerprints to shards", slog.Any("error", err))
+	}
+	fmt.Printf("Moved %d fingerprints to %d shards\n", moved, len(db.ShardURLs))
+}
+
+func exportChromaprints(songsDir string, length float64, outputPath string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	output := os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to create output file", slog.Any("error", err))
+			return
+		}
+		defer file.Close()
+		output = file
+	}
+
+	exported, err := song.ExportChromaprints(ctx, songsDir, length, output)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to export fingerprints", slog.Any("error", err))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Exported the Chromaprint fingerprints of %d songs\n", exported)
+}
+
+// exportCatalog writes the stored songs and their fingerprints to a
+// catalog file at outputPath, or stdout if empty. Paths ending in .gz are
+// compressed.
+func exportCatalog(outputPath string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	var output io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to create output file", slog.Any("error", err))
+			return
+		}
+		defer file.Close()
+		output = file
+
+		if strings.HasSuffix(outputPath, ".gz") {
+			compressed := gzip.NewWriter(file)
+			defer compressed.Close()
+			output = compressed
+		}
+	}
+
+	exported, err := song.ExportCatalog(ctx, output)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to export catalog", slog.Any("error", err))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d songs\n", exported)
+}
+
+// importCatalog stores the songs of the catalog file at inputPath, which is
+// decompressed if it ends in .gz.
+func importCatalog(inputPath string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	file, err := os.Open(inputPath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open catalog", slog.Any("error", err))
+		return
+	}
+	defer file.Close()
+
+	var input io.Reader = file
+	if strings.HasSuffix(inputPath, ".gz") {
+		compressed, err := gzip.NewReader(file)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to decompress catalog", slog.Any("error", err))
+			return
+		}
+		defer compressed.Close()
+		input = compressed
+	}
+
+	result, err := song.ImportCatalog(ctx, input)
+	fmt.Printf("Imported %d songs, skipped %d already stored\n", result.Imported, result.Skipped)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to import catalog", slog.Any("error", err))
+	}
+}
+
+// backupInstance writes a backup of the database and song files to
+// outputPath, or stdout if empty.
+func backupInstance(outputPath string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	output := os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to create output file", slog.Any("error", err))
+			return
+		}
+		defer file.Close()
+		output = file
+	}
+
+	result, err := backup.Create(ctx, output)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to back up", slog.Any("error", err))
+		if outputPath != "" {
+			os.Remove(outputPath)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %d songs with %d files and %d covers\n", result.Manifest.Songs, result.Files, result.Covers)
+	if result.Missing > 0 {
+		fmt.Fprintf(os.Stderr, "%d files were missing from storage\n", result.Missing)
+	}
+	if result.Manifest.Database == "" {
+		fmt.Fprintf(os.Stderr, "The %s database isn't part of the backup, back it up with its own tools\n", db.DBtype)
+	}
+}
+
+// restoreInstance restores the database and song files of the backup at
+// inputPath, replacing the database if force is set.
+func restoreInstance(inputPath string, force bool) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	file, err := os.Open(inputPath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open backup", slog.Any("error", err))
+		return
+	}
+	defer file.Close()
+
+	result, err := backup.Restore(ctx, file, force)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to restore backup", slog.Any("error", err))
+		return
+	}
+	fmt.Printf("Restored %d songs with %d files and %d covers from the backup of %s\n",
+		result.Manifest.Songs, result.Files, result.Covers, result.Manifest.CreatedAt.Local().Format(time.DateTime))
+}
+
+// renderSpectrogram draws the spectrogram of an audio file to a PNG image
+// at outputPath, next to the file if empty, marking the peaks fingerprints
+// are made of if peaks is set.
+func renderSpectrogram(filePath, outputPath string, peaks bool) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".png"
+	}
+
+	audio, err := decode.DecodeFile(ctx, filePath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to decode audio", slog.Any("error", err))
+		return
+	}
+
+	img, err := shazam.DrawSpectrogram(audio.Samples, audio.SampleRate, audio.Duration, peaks)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to draw spectrogram", slog.Any("error", err))
+		return
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create output file", slog.Any("error", err))
+		return
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		logger.ErrorContext(ctx, "Failed to write spectrogram", slog.Any("error", err))
+		return
+	}
+	fmt.Printf("Spectrogram saved to %s\n", outputPath)
+}
+
+// migrateSchema shows or changes the version of the schema of the SQL
+// database DB_TYPE selects.
+func migrateSchema(action string, args []string) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create DB client", slog.Any("error", err))
+		return
+	}
+	defer dbClient.Close()
+
+	migrator, ok := dbClient.(db.Migrator)
+	if !ok {
+		fmt.Printf("The %s database has no schema to migrate\n", db.DBtype)
+		return
+	}
+
+	migrations, err := migrator.Migrations(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to read migrations", slog.Any("error", err))
+		return
+	}
+	current, latest := 0, 0
+	for _, migration := range migrations {
+		if !migration.AppliedAt.IsZero() {
+			current = migration.Version
+		}
+		latest = migration.Version
+	}
+
+	var version int
+	switch action {
+	case "status":
+		for _, migration := range migrations {
+			applied := "pending"
+			if !migration.AppliedAt.IsZero() {
+				applied = "applied " + migration.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", migration.Version, migration.Name, applied)
+		}
+		fmt.Printf("Schema at version %d of %d\n", current, latest)
+		return
+	case "up":
+		version = latest
+	case "down":
+		version = max(current-1, 0)
+	case "to":
+		if len(args) < 1 {
+			fmt.Println("Usage: main.go migrate to <version>")
+			os.Exit(1)
+		}
+		version, err = strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Invalid version %q\n", args[0])
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("Usage: main.go migrate <status | up | down | to <version>>")
+		os.Exit(1)
+	}
+
+	if err := migrator.Migrate(ctx, version); err != nil {
+		logger.ErrorContext(ctx, "Failed to migrate schema", slog.Any("error", err))
+		return
+	}
+	fmt.Printf("Schema migrated from version %d to %d\n", current, version)
 }
@@ -0,0 +1,868 @@
+// Package config loads the server's tunable settings from a JSON file.
+//
+// The file is read from the path in the CONFIG_FILE environment variable,
+// or config.json in the working directory. A missing file leaves every
+// setting at its default.
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"song-recognition/utils"
+	"sync"
+)
+
+// Config holds every tunable setting.
+type Config struct {
+	Match       Match       `json:"match"`
+	Fingerprint Fingerprint `json:"fingerprint"`
+	Monitor     Monitor     `json:"monitor"`
+	// Classifiers tag the audio of every song saved.
+	Classifiers []Classifier `json:"classifiers"`
+	MusicBrainz MusicBrainz  `json:"musicbrainz"`
+	Artwork     Artwork      `json:"artwork"`
+	Preview     Preview      `json:"preview"`
+	Playback    Playback     `json:"playback"`
+	Lyrics      Lyrics       `json:"lyrics"`
+	Watch       Watch        `json:"watch"`
+	YtDlp       YtDlp        `json:"yt_dlp"`
+	SoundCloud  SoundCloud   `json:"soundcloud"`
+	LastFM      LastFM       `json:"lastfm"`
+	ITunes      ITunes       `json:"itunes"`
+	Download    Download     `json:"download"`
+	Timeouts    Timeouts     `json:"timeouts"`
+	Decode      Decode       `json:"decode"`
+	Storage     Storage      `json:"storage"`
+	Quotas      Quotas       `json:"quotas"`
+	Janitor     Janitor      `json:"janitor"`
+	Prune       Prune        `json:"prune"`
+	Bloom       Bloom        `json:"bloom"`
+	Postings    Postings     `json:"postings_cache"`
+	LSH         LSH          `json:"lsh"`
+	Index       Index        `json:"fingerprint_index"`
+}
+
+// Match tunes the results of recognition.
+type Match struct {
+	// MinScore is the score a candidate needs to be reported at all.
+	// Clips whose candidates all score lower get no match.
+	MinScore float64 `json:"min_score"`
+	// TopN is the default number of candidates returned.
+	TopN int `json:"top_n"`
+	// MaxStretch is how much faster or slower than the song clips may
+	// play, as a fraction. Zero only matches clips at the song's speed.
+	MaxStretch float64 `json:"max_stretch"`
+	// IDFWeighting weights every matched hash by how few songs share it,
+	// instead of counting all hashes alike.
+	IDFWeighting bool `json:"idf_weighting"`
+}
+
+// MaxStretchLimit caps Match.MaxStretch. Every half percent of stretch
+// costs another lookup of the clip.
+const MaxStretchLimit = 0.1
+
+// Peak picking methods of Fingerprint.
+const (
+	// PeakPickingAverage keeps the loudest bin of each of a few fixed
+	// bands that is louder than the average of them.
+	PeakPickingAverage = "average"
+	// PeakPickingLogBands picks peaks in logarithmic bands against each
+	// band's noise floor, which holds up better in noisy recordings.
+	PeakPickingLogBands = "log_bands"
+)
+
+// Fingerprint tunes how audio is fingerprinted. Songs must be fingerprinted
+// and recognized with the same settings, so changing them calls for saving
+// every song again.
+type Fingerprint struct {
+	PeakPicking string `json:"peak_picking"`
+	// MaxPeaksPerSecond keeps only the loudest peaks of every second.
+	// Zero keeps them all.
+	MaxPeaksPerSecond int `json:"max_peaks_per_second"`
+	// FanOut is the number of following peaks every peak is paired with,
+	// and TargetZoneMs how far after it they may be.
+	FanOut       int `json:"fan_out"`
+	TargetZoneMs int `json:"target_zone_ms"`
+	// FreqBits and DeltaBits set how many bits of a hash hold the
+	// frequency of each peak and the time between the two.
+	FreqBits  int `json:"freq_bits"`
+	DeltaBits int `json:"delta_bits"`
+	// Normalize sets how the loudness of audio is normalized before it is
+	// fingerprinted.
+	Normalize Normalize `json:"normalize"`
+}
+
+// Loudness normalization modes of Normalize.
+const (
+	// NormalizeR128 brings audio to an integrated loudness, measured the
+	// way EBU R128 does.
+	NormalizeR128 = "r128"
+	// NormalizePeak brings the loudest sample of audio to a level.
+	NormalizePeak = "peak"
+)
+
+// Normalize tunes the loudness normalization of the audio of clips, and
+// optionally of songs, before it is fingerprinted.
+type Normalize struct {
+	// Mode is NormalizeR128, NormalizePeak, or empty to leave audio as it
+	// is.
+	Mode string `json:"mode"`
+	// TargetLUFS is the loudness NormalizeR128 brings audio to, and
+	// TargetPeakDB the level in dBFS NormalizePeak brings it to.
+	TargetLUFS   float64 `json:"target_lufs"`
+	TargetPeakDB float64 `json:"target_peak_db"`
+	// Songs normalizes the songs saved too. It changes their fingerprints,
+	// so it calls for saving every song again.
+	Songs bool `json:"songs"`
+}
+
+// MaxFreqBits is the most bits the frequency of a peak takes up.
+const MaxFreqBits = 9
+
+// Monitor lists the live audio streams the server watches for songs.
+type Monitor struct {
+	Streams []Stream `json:"streams"`
+	// Window is the seconds of audio recognized at a time and Hop the
+	// seconds between the starts of consecutive windows.
+	Window float64 `json:"window"`
+	Hop    float64 `json:"hop"`
+}
+
+// Stream is an Icecast, HLS or plain HTTP audio stream.
+type Stream struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Classifier is an HTTP service that tags the audio of songs, such as with
+// their genre or mood.
+type Classifier struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// MusicBrainz tunes the lookup of album, release year and canonical artist
+// name of every song saved.
+type MusicBrainz struct {
+	Enabled bool `json:"enabled"`
+	// UserAgent identifies the server to MusicBrainz, which asks for an
+	// application name and a way to contact its operator.
+	UserAgent string `json:"user_agent"`
+	// MinScore is the search score, from 0 to 100, a recording found by
+	// title and artist needs to be taken for the song.
+	MinScore int `json:"min_score"`
+}
+
+// Artwork tunes the fetching of the cover image of every song saved.
+type Artwork struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Formats of Preview.
+const (
+	PreviewMP3  = "mp3"
+	PreviewOpus = "opus"
+	PreviewAAC  = "aac"
+)
+
+// Preview tunes the short clip cut from every song saved, so matches can
+// be listened to without fetching the whole WAV file.
+type Preview struct {
+	Enabled bool `json:"enabled"`
+	// OffsetSeconds is where in the song the clip starts. Songs too short
+	// for it have their clip cut from the end.
+	OffsetSeconds float64 `json:"offset_seconds"`
+	LengthSeconds float64 `json:"length_seconds"`
+	// Format is the codec the clip is encoded with.
+	Format string `json:"format"`
+	// BitrateKbps is the bitrate the clip is encoded at.
+	BitrateKbps int `json:"bitrate_kbps"`
+}
+
+// Playback tunes the transcoding of songs as they are played back.
+type Playback struct {
+	// BitrateKbps is the bitrate streams and HLS segments are encoded at.
+	BitrateKbps int `json:"bitrate_kbps"`
+	// SegmentSeconds is the length of HLS segments.
+	SegmentSeconds int `json:"segment_seconds"`
+}
+
+// Lyrics enables the built-in lyrics providers.
+type Lyrics struct {
+	// LRCLib looks up lyrics in LRCLIB, https://lrclib.net.
+	LRCLib bool `json:"lrclib"`
+}
+
+// Watch lists the YouTube channels the server polls for new uploads, which
+// are queued to be saved as songs.
+type Watch struct {
+	Channels []Channel `json:"channels"`
+	// IntervalMinutes is the time between two polls of every channel.
+	IntervalMinutes int `json:"interval_minutes"`
+	// StatePath is the file keeping the uploads already seen.
+	StatePath string `json:"state_path"`
+}
+
+// Channel is a YouTube channel, such as a record label's.
+type Channel struct {
+	// ID is the channel's ID, starting with "UC".
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Backfill queues the recent uploads found on the first poll of the
+	// channel too. Otherwise only those uploaded after it are.
+	Backfill bool `json:"backfill"`
+}
+
+// YtDlp tunes how song URLs of video and streaming sites are downloaded,
+// with yt-dlp, https://github.com/yt-dlp/yt-dlp.
+type YtDlp struct {
+	// Path is the yt-dlp binary, looked up on PATH unless it holds a
+	// slash.
+	Path string `json:"path"`
+	// Hosts are the sites whose song URLs are pages yt-dlp takes the
+	// audio from, rather than audio files. Their subdomains are included.
+	Hosts []string `json:"hosts"`
+}
+
+// SoundCloud tunes how the song URLs of SoundCloud tracks are resolved.
+type SoundCloud struct {
+	// ClientID is that of a SoundCloud app, to resolve tracks and their
+	// audio with the SoundCloud API. Without one, only their title and
+	// uploader are looked up, and the audio is downloaded with yt-dlp.
+	ClientID string `json:"client_id"`
+}
+
+// LastFM holds the credentials of the Last.fm API account songs are
+// scrobbled with, from https://www.last.fm/api/account/create. Scrobbling
+// is disabled without an APIKey.
+type LastFM struct {
+	APIKey string `json:"api_key"`
+	Secret string `json:"secret"`
+}
+
+// ITunes tunes the lookup of songs in the iTunes Search API, for their
+// canonical title and artist casing and their Apple Music store page.
+type ITunes struct {
+	Enabled bool `json:"enabled"`
+	// Country is the two letter code of the store looked up.
+	Country string `json:"country"`
+}
+
+// Download tunes how the audio files of song URLs are downloaded.
+type Download struct {
+	// Attempts is how many times a download failing with a 5xx or 429
+	// status, a timeout or a network error is tried before giving up.
+	Attempts int `json:"attempts"`
+	// RetryDelayMs is the wait before the first retry, doubling on every
+	// further one up to MaxRetryDelayMs. Up to 20% jitter is added.
+	RetryDelayMs    int `json:"retry_delay_ms"`
+	MaxRetryDelayMs int `json:"max_retry_delay_ms"`
+	// MaxBytes is the size of the largest audio file downloaded. Zero
+	// downloads files of any size.
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxConcurrent is the most song URLs downloaded at once, across all
+	// workers. Zero doesn't limit them.
+	MaxConcurrent int `json:"max_concurrent"`
+	// MaxBytesPerSecond caps the bandwidth that downloads share. Zero
+	// doesn't cap it.
+	MaxBytesPerSecond int64 `json:"max_bytes_per_second"`
+	// CacheDir keeps a copy of every file downloaded, if set. Files are
+	// then downloaded again only if the server reports them changed since,
+	// by their ETag or Last-Modified date. The least recently used copies
+	// are evicted once they take up more than CacheMaxBytes, unless it is
+	// zero.
+	CacheDir      string `json:"cache_dir"`
+	CacheMaxBytes int64  `json:"cache_max_bytes"`
+	// ProxyURL is the http, https or socks5 proxy downloads go through.
+	// Without one, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+	// variables are followed.
+	ProxyURL string `json:"proxy_url"`
+	// CAFile is a PEM bundle of certificate authorities trusted besides
+	// the system's, such as that of a proxy inspecting TLS traffic.
+	CAFile string `json:"ca_file"`
+	// MinTLSVersion is the oldest TLS version accepted, "1.2" by default.
+	MinTLSVersion string `json:"min_tls_version"`
+	// InsecureSkipVerify accepts any certificate. Only use it for testing.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+	// AllowedHosts, if set, are the only hosts downloaded from, including
+	// their subdomains. DeniedHosts are never downloaded from.
+	AllowedHosts []string `json:"allowed_hosts"`
+	DeniedHosts  []string `json:"denied_hosts"`
+	// AllowPrivateNetworks allows downloads from loopback, private and
+	// link-local addresses, such as those of cloud metadata services,
+	// which are refused by default.
+	AllowPrivateNetworks bool `json:"allow_private_networks"`
+	// Credentials are named profiles authenticating the downloads of the
+	// song URLs that ask for them.
+	Credentials map[string]Credential `json:"credentials"`
+}
+
+// Credential authenticates a download, with a bearer token, a username and
+// password or any other headers. Its values may reference environment
+// variables, as $NAME or ${NAME}, to keep secrets out of the config file.
+type Credential struct {
+	BearerToken string            `json:"bearer_token"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	Headers     map[string]string `json:"headers"`
+	// Hosts are the hosts, and their subdomains, the credential is sent
+	// to, including those redirected to. Empty sends it to the host of the
+	// song URL only.
+	Hosts []string `json:"hosts"`
+}
+
+// Timeouts bound the steps of saving a song, so a stuck source or decoder
+// doesn't hold up a worker forever. Zero leaves a step unbounded.
+type Timeouts struct {
+	// DownloadSeconds bounds every attempt at downloading a song's audio,
+	// with or without yt-dlp.
+	DownloadSeconds int `json:"download_seconds"`
+	// ConvertSeconds bounds probing and decoding the audio, with FFmpeg
+	// for the formats that aren't decoded natively.
+	ConvertSeconds int `json:"convert_seconds"`
+	// TotalSeconds bounds saving a song from start to finish.
+	TotalSeconds int `json:"total_seconds"`
+}
+
+// Decode limits the audio decoded natively, so a file claiming to be far
+// longer than it is can't exhaust the memory of the server.
+type Decode struct {
+	// MaxDurationSeconds is the longest audio decoded. Zero doesn't limit
+	// it.
+	MaxDurationSeconds int `json:"max_duration_seconds"`
+}
+
+// Storage backends.
+const (
+	// StorageLocal keeps the audio files of songs in a local directory.
+	StorageLocal = "local"
+	// StorageS3 keeps them in a bucket of S3 or an S3-compatible store.
+	StorageS3 = "s3"
+	// StorageGCS keeps them in a bucket of Google Cloud Storage.
+	StorageGCS = "gcs"
+	// StorageAzure keeps them in a container of Azure Blob Storage.
+	StorageAzure = "azure"
+)
+
+// Storage tunes where the audio files of songs are kept.
+type Storage struct {
+	Backend string `json:"backend"`
+	// Dir is the directory of the local backend.
+	Dir   string `json:"dir"`
+	S3    S3     `json:"s3"`
+	GCS   GCS    `json:"gcs"`
+	Azure Azure  `json:"azure"`
+}
+
+// S3 locates the bucket of the s3 backend. The keys may reference
+// environment variables, as $NAME or ${NAME}.
+type S3 struct {
+	// Endpoint is the URL of the store, such as http://localhost:9000 for
+	// MinIO, and that of the AWS region if empty.
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	Bucket   string `json:"bucket"`
+	// Prefix is prepended to the key of every file, such as "songs/".
+	Prefix          string `json:"prefix"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	// PathStyle addresses the bucket in the path of URLs rather than in
+	// their host, as MinIO expects.
+	PathStyle bool `json:"path_style"`
+}
+
+// GCS locates the bucket of the gcs backend.
+type GCS struct {
+	// Endpoint is the URL of Cloud Storage, overridable for emulators.
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// Prefix is prepended to the key of every file.
+	Prefix string `json:"prefix"`
+	// CredentialsFile is the JSON key of the service account the bucket
+	// is reached as, the file in GOOGLE_APPLICATION_CREDENTIALS if empty.
+	CredentialsFile string `json:"credentials_file"`
+}
+
+// Azure locates the container of the azure backend. AccountKey may
+// reference environment variables, as $NAME or ${NAME}.
+type Azure struct {
+	// Endpoint is the URL of the account's blob service, such as
+	// http://127.0.0.1:10000/devstoreaccount1 for Azurite, and
+	// https://<account>.blob.core.windows.net if empty.
+	Endpoint   string `json:"endpoint"`
+	Account    string `json:"account"`
+	AccountKey string `json:"account_key"`
+	Container  string `json:"container"`
+	// Prefix is prepended to the key of every file.
+	Prefix string `json:"prefix"`
+}
+
+// Quota policies.
+const (
+	// QuotaReject refuses new files once a directory is full.
+	QuotaReject = "reject"
+	// QuotaEvict removes the least recently used files to make room.
+	QuotaEvict = "evict"
+)
+
+// Quotas cap the size of the temporary directory and of the songs
+// directory of local storage.
+type Quotas struct {
+	Tmp   Quota `json:"tmp"`
+	Songs Quota `json:"songs"`
+}
+
+// Quota caps the size of a directory.
+type Quota struct {
+	// MaxBytes is the most the directory may hold. Zero doesn't cap it.
+	MaxBytes int64  `json:"max_bytes"`
+	Policy   string `json:"policy"`
+}
+
+// Janitor tunes the removal of the temporary files left behind by songs
+// that failed to save.
+type Janitor struct {
+	// IntervalMinutes is the time between two sweeps of the temporary
+	// directory. Zero disables them.
+	IntervalMinutes int `json:"interval_minutes"`
+	// MaxAgeMinutes is how long after they were last written files are
+	// removed.
+	MaxAgeMinutes int `json:"max_age_minutes"`
+}
+
+// Prune tunes the removal of the fingerprints of addresses so common that
+// they cost lookups without telling songs apart.
+type Prune struct {
+	// IntervalMinutes is the time between two prunes. Zero disables them.
+	IntervalMinutes int `json:"interval_minutes"`
+	// MaxSongs is the most songs an address may have fingerprints of
+	// before they are all removed.
+	MaxSongs int `json:"max_songs"`
+}
+
+// Bloom tunes the in-process Bloom filter of stored hashes that the server
+// checks before looking hashes up.
+type Bloom struct {
+	Enabled bool `json:"enabled"`
+	// FalsePositiveRate is the share of absent hashes the filter lets
+	// through to the database.
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	// RebuildMinutes is the time between two rebuilds of the filter, which
+	// pick up the songs other processes saved. Zero never rebuilds it.
+	RebuildMinutes int `json:"rebuild_minutes"`
+}
+
+// Postings tunes the in-process LRU cache of the songs stored at hashes
+// that the server looks hashes up in before the database.
+type Postings struct {
+	Enabled bool `json:"enabled"`
+	// MaxCouples is the most song and time pairs cached, counting hashes
+	// in no song as one.
+	MaxCouples int `json:"max_couples"`
+	// TTLSeconds is the time a hash stays cached, which bounds how long
+	// songs other processes save or delete go unseen. Zero keeps hashes
+	// until evicted.
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// LSH tunes the approximate LSH index, which narrows the songs clips are
+// scored against down to a few likely candidates.
+type LSH struct {
+	// Enabled indexes the songs saved and matches clips against the
+	// index. Songs saved before are indexed by the lsh-index command.
+	Enabled bool `json:"enabled"`
+	// Candidates is the most songs a clip is scored against.
+	Candidates int `json:"candidates"`
+	// Fallback looks every hash of a clip up when no candidate matches.
+	Fallback bool `json:"fallback"`
+}
+
+// Index points the server at a fingerprint index file, which it matches
+// clips against instead of the database.
+type Index struct {
+	Enabled bool `json:"enabled"`
+	// Path is the file the build-index command writes and the server
+	// reads.
+	Path string `json:"path"`
+}
+
+// TLSVersions maps the values of Download.MinTLSVersion to their
+// crypto/tls constants.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Default returns the settings used when no config file is present.
+func Default() Config {
+	return Config{
+		Match: Match{
+			MinScore:     0,
+			TopN:         10,
+			IDFWeighting: true,
+		},
+		Fingerprint: Fingerprint{
+			PeakPicking:  PeakPickingAverage,
+			FanOut:       5,
+			TargetZoneMs: 1<<14 - 1,
+			FreqBits:     MaxFreqBits,
+			DeltaBits:    14,
+			Normalize: Normalize{
+				TargetLUFS:   -14,
+				TargetPeakDB: -1,
+			},
+		},
+		Monitor: Monitor{
+			Window: 12,
+			Hop:    6,
+		},
+		MusicBrainz: MusicBrainz{
+			UserAgent: "seek-tune/1.0 ( https://github.com/adityaraj-09/seek-tune )",
+			MinScore:  90,
+		},
+		Preview: Preview{
+			OffsetSeconds: 30,
+			LengthSeconds: 30,
+			Format:        PreviewMP3,
+			BitrateKbps:   128,
+		},
+		Playback: Playback{
+			BitrateKbps:    128,
+			SegmentSeconds: 6,
+		},
+		Watch: Watch{
+			IntervalMinutes: 30,
+			StatePath:       "tmp/youtube-watch.json",
+		},
+		ITunes: ITunes{
+			Country: "us",
+		},
+		Timeouts: Timeouts{
+			DownloadSeconds: 600,
+			ConvertSeconds:  300,
+			TotalSeconds:    1800,
+		},
+		Decode: Decode{
+			MaxDurationSeconds: 3 * 60 * 60,
+		},
+		Download: Download{
+			Attempts:        3,
+			RetryDelayMs:    1000,
+			MaxRetryDelayMs: 30000,
+			MaxBytes:        512 << 20,
+			CacheMaxBytes:   2 << 30,
+			MinTLSVersion:   "1.2",
+		},
+		Storage: Storage{
+			Backend: StorageLocal,
+			Dir:     "songs",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+		Janitor: Janitor{
+			IntervalMinutes: 60,
+			MaxAgeMinutes:   360,
+		},
+		Prune: Prune{
+			MaxSongs: 1000,
+		},
+		Bloom: Bloom{
+			FalsePositiveRate: 0.01,
+			RebuildMinutes:    10,
+		},
+		Postings: Postings{
+			MaxCouples: 1000000,
+			TTLSeconds: 60,
+		},
+		LSH: LSH{
+			Candidates: 50,
+			Fallback:   true,
+		},
+		Index: Index{
+			Path: "fingerprints.idx",
+		},
+		Quotas: Quotas{
+			Tmp:   Quota{Policy: QuotaReject},
+			Songs: Quota{Policy: QuotaReject},
+		},
+		YtDlp: YtDlp{
+			Path: "yt-dlp",
+			Hosts: []string{
+				"youtube.com", "youtu.be", "soundcloud.com", "bandcamp.com",
+				"vimeo.com", "mixcloud.com", "dailymotion.com",
+			},
+		},
+	}
+}
+
+// Load reads a config file. Settings the file leaves out keep their
+// defaults.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+func (cfg Config) validate() error {
+	if cfg.Match.MinScore < 0 {
+		return errors.New("match.min_score can't be negative")
+	}
+	if cfg.Match.TopN < 0 {
+		return errors.New("match.top_n can't be negative")
+	}
+	if cfg.Match.MaxStretch < 0 || cfg.Match.MaxStretch > MaxStretchLimit {
+		return fmt.Errorf("match.max_stretch must be between 0 and %v", MaxStretchLimit)
+	}
+	if cfg.Fingerprint.PeakPicking != PeakPickingAverage && cfg.Fingerprint.PeakPicking != PeakPickingLogBands {
+		return fmt.Errorf("fingerprint.peak_picking must be %q or %q", PeakPickingAverage, PeakPickingLogBands)
+	}
+	if err := cfg.Fingerprint.validate(); err != nil {
+		return err
+	}
+	if cfg.Monitor.Window <= 0 || cfg.Monitor.Hop <= 0 || cfg.Monitor.Hop > cfg.Monitor.Window {
+		return errors.New("monitor.window and monitor.hop must be positive, with hop no longer than window")
+	}
+
+	names := make(map[string]bool)
+	for _, stream := range cfg.Monitor.Streams {
+		if stream.Name == "" || stream.URL == "" {
+			return errors.New("monitor.streams need a name and a url")
+		}
+		if names[stream.Name] {
+			return fmt.Errorf("monitor.streams has two streams named %q", stream.Name)
+		}
+		names[stream.Name] = true
+	}
+
+	for _, classifier := range cfg.Classifiers {
+		if classifier.Name == "" || classifier.URL == "" {
+			return errors.New("classifiers need a name and a url")
+		}
+	}
+
+	if cfg.MusicBrainz.Enabled && cfg.MusicBrainz.UserAgent == "" {
+		return errors.New("musicbrainz.user_agent can't be empty")
+	}
+	if cfg.MusicBrainz.MinScore < 0 || cfg.MusicBrainz.MinScore > 100 {
+		return errors.New("musicbrainz.min_score must be between 0 and 100")
+	}
+
+	if cfg.Preview.OffsetSeconds < 0 || cfg.Preview.LengthSeconds <= 0 {
+		return errors.New("preview.offset_seconds can't be negative and preview.length_seconds must be positive")
+	}
+	if cfg.Preview.Format != PreviewMP3 && cfg.Preview.Format != PreviewOpus && cfg.Preview.Format != PreviewAAC {
+		return fmt.Errorf("preview.format must be %q, %q or %q", PreviewMP3, PreviewOpus, PreviewAAC)
+	}
+	if cfg.Preview.BitrateKbps <= 0 {
+		return errors.New("preview.bitrate_kbps must be positive")
+	}
+
+	if cfg.Playback.BitrateKbps <= 0 || cfg.Playback.SegmentSeconds <= 0 {
+		return errors.New("playback.bitrate_kbps and playback.segment_seconds must be positive")
+	}
+
+	if cfg.Watch.IntervalMinutes <= 0 {
+		return errors.New("watch.interval_minutes must be positive")
+	}
+	if cfg.Watch.StatePath == "" {
+		return errors.New("watch.state_path can't be empty")
+	}
+	channels := make(map[string]bool)
+	for _, channel := range cfg.Watch.Channels {
+		if channel.ID == "" {
+			return errors.New("watch.channels need an id")
+		}
+		if channels[channel.ID] {
+			return fmt.Errorf("watch.channels lists channel %q twice", channel.ID)
+		}
+		channels[channel.ID] = true
+	}
+
+	if cfg.YtDlp.Path == "" {
+		return errors.New("yt_dlp.path can't be empty")
+	}
+	if len(cfg.ITunes.Country) != 2 {
+		return errors.New("itunes.country must be a two letter country code")
+	}
+	if cfg.Download.Attempts <= 0 {
+		return errors.New("download.attempts must be positive")
+	}
+	if cfg.Download.RetryDelayMs < 0 || cfg.Download.MaxRetryDelayMs < cfg.Download.RetryDelayMs {
+		return errors.New("download.retry_delay_ms can't be negative or above download.max_retry_delay_ms")
+	}
+	if cfg.Download.MaxBytes < 0 {
+		return errors.New("download.max_bytes can't be negative")
+	}
+	if cfg.Download.CacheMaxBytes < 0 {
+		return errors.New("download.cache_max_bytes can't be negative")
+	}
+	if cfg.Download.MaxConcurrent < 0 || cfg.Download.MaxBytesPerSecond < 0 {
+		return errors.New("download.max_concurrent and download.max_bytes_per_second can't be negative")
+	}
+	if cfg.Download.ProxyURL != "" {
+		proxy, err := url.Parse(cfg.Download.ProxyURL)
+		if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") {
+			return errors.New("download.proxy_url must be an http, https or socks5 URL")
+		}
+	}
+	if _, ok := TLSVersions[cfg.Download.MinTLSVersion]; !ok {
+		return errors.New("download.min_tls_version must be 1.0, 1.1, 1.2 or 1.3")
+	}
+	for name, credential := range cfg.Download.Credentials {
+		if credential.BearerToken != "" && credential.Username != "" {
+			return fmt.Errorf("download.credentials.%s can't have both a bearer_token and a username", name)
+		}
+	}
+	if cfg.Timeouts.DownloadSeconds < 0 || cfg.Timeouts.ConvertSeconds < 0 || cfg.Timeouts.TotalSeconds < 0 {
+		return errors.New("timeouts can't be negative")
+	}
+	if cfg.Decode.MaxDurationSeconds < 0 {
+		return errors.New("decode.max_duration_seconds can't be negative")
+	}
+	switch cfg.Storage.Backend {
+	case StorageLocal:
+		if cfg.Storage.Dir == "" {
+			return errors.New("storage.dir can't be empty")
+		}
+	case StorageS3:
+		if cfg.Storage.S3.Bucket == "" || cfg.Storage.S3.AccessKeyID == "" || cfg.Storage.S3.SecretAccessKey == "" {
+			return errors.New("storage.s3 needs a bucket, an access_key_id and a secret_access_key")
+		}
+	case StorageGCS:
+		if cfg.Storage.GCS.Bucket == "" {
+			return errors.New("storage.gcs.bucket can't be empty")
+		}
+	case StorageAzure:
+		if cfg.Storage.Azure.Account == "" || cfg.Storage.Azure.AccountKey == "" || cfg.Storage.Azure.Container == "" {
+			return errors.New("storage.azure needs an account, an account_key and a container")
+		}
+	default:
+		return fmt.Errorf("storage.backend must be %q, %q, %q or %q", StorageLocal, StorageS3, StorageGCS, StorageAzure)
+	}
+	for name, endpoint := range map[string]string{"s3": cfg.Storage.S3.Endpoint, "gcs": cfg.Storage.GCS.Endpoint, "azure": cfg.Storage.Azure.Endpoint} {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("storage.%s.endpoint must be an http or https URL", name)
+		}
+	}
+	for name, quota := range map[string]Quota{"tmp": cfg.Quotas.Tmp, "songs": cfg.Quotas.Songs} {
+		if quota.MaxBytes < 0 {
+			return fmt.Errorf("quotas.%s.max_bytes can't be negative", name)
+		}
+		if quota.Policy != QuotaReject && quota.Policy != QuotaEvict {
+			return fmt.Errorf("quotas.%s.policy must be %q or %q", name, QuotaReject, QuotaEvict)
+		}
+	}
+	if cfg.Janitor.IntervalMinutes < 0 {
+		return errors.New("janitor.interval_minutes can't be negative")
+	}
+	// Files of songs still being saved must not be swept
+	if cfg.Janitor.MaxAgeMinutes <= 0 || (cfg.Timeouts.TotalSeconds > 0 && cfg.Janitor.MaxAgeMinutes*60 <= cfg.Timeouts.TotalSeconds) {
+		return errors.New("janitor.max_age_minutes must be positive and longer than timeouts.total_seconds")
+	}
+	if cfg.Prune.IntervalMinutes < 0 {
+		return errors.New("prune.interval_minutes can't be negative")
+	}
+	if cfg.Prune.MaxSongs < 1 {
+		return errors.New("prune.max_songs must be at least 1")
+	}
+	if cfg.Bloom.FalsePositiveRate <= 0 || cfg.Bloom.FalsePositiveRate >= 1 {
+		return errors.New("bloom.false_positive_rate must be between 0 and 1")
+	}
+	if cfg.Bloom.RebuildMinutes < 0 {
+		return errors.New("bloom.rebuild_minutes can't be negative")
+	}
+	if cfg.Postings.MaxCouples < 1 {
+		return errors.New("postings_cache.max_couples must be at least 1")
+	}
+	if cfg.Postings.TTLSeconds < 0 {
+		return errors.New("postings_cache.ttl_seconds can't be negative")
+	}
+	if cfg.LSH.Candidates < 1 {
+		return errors.New("lsh.candidates must be at least 1")
+	}
+	if cfg.Index.Path == "" {
+		return errors.New("fingerprint_index.path is required")
+	}
+	if cfg.LastFM.APIKey != "" && cfg.LastFM.Secret == "" {
+		return errors.New("lastfm.secret is required with lastfm.api_key")
+	}
+	return nil
+}
+
+var (
+	loadOnce sync.Once
+	current  Config
+)
+
+// Get returns the settings of the config file, loading it on first use. A
+// config file that exists but can't be loaded is fatal, so a typo doesn't
+// silently revert settings to their defaults.
+func Get() Config {
+	loadOnce.Do(func() {
+		path := utils.GetEnv("CONFIG_FILE", "config.json")
+
+		cfg, err := Load(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			cfg = Default()
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		current = cfg
+	})
+	return current
+}
+
+func (cfg Fingerprint) validate() error {
+	if cfg.MaxPeaksPerSecond < 0 {
+		return errors.New("fingerprint.max_peaks_per_second can't be negative")
+	}
+	if cfg.FanOut <= 0 {
+		return errors.New("fingerprint.fan_out must be positive")
+	}
+	if cfg.FreqBits <= 0 || cfg.FreqBits > MaxFreqBits {
+		return fmt.Errorf("fingerprint.freq_bits must be between 1 and %d", MaxFreqBits)
+	}
+	if cfg.DeltaBits <= 0 || 2*cfg.FreqBits+cfg.DeltaBits > 32 {
+		return errors.New("fingerprint.delta_bits must be positive and leave room for both frequencies in 32 bits")
+	}
+	if cfg.TargetZoneMs <= 0 || cfg.TargetZoneMs >= 1<<cfg.DeltaBits {
+		return fmt.Errorf("fingerprint.target_zone_ms must be positive and fit in fingerprint.delta_bits, below %d", 1<<cfg.DeltaBits)
+	}
+	switch cfg.Normalize.Mode {
+	case "", NormalizeR128, NormalizePeak:
+	default:
+		return fmt.Errorf("fingerprint.normalize.mode must be %q, %q or empty", NormalizeR128, NormalizePeak)
+	}
+	if cfg.Normalize.TargetLUFS < -70 || cfg.Normalize.TargetLUFS > 0 {
+		return errors.New("fingerprint.normalize.target_lufs must be between -70 and 0")
+	}
+	if cfg.Normalize.TargetPeakDB > 0 {
+		return errors.New("fingerprint.normalize.target_peak_db can't be positive")
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bloomFilter is a Bloom filter of fingerprint addresses. Its bits are set
+// and tested atomically, so it needs no lock.
+type bloomFilter struct {
+	bits   []atomic.Uint64
+	hashes int
+}
+
+// newBloomFilter returns a filter sized for capacity addresses to test
+// positive for absent ones at falsePositiveRate.
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / float64(capacity) * math.Ln2))
+	return &bloomFilter{
+		bits:   make([]atomic.Uint64, (int(bits)+63)/64),
+		hashes: max(hashes, 1),
+	}
+}
+
+// locations calls fn with the bits of address, derived from two halves of
+// one 64-bit hash by double hashing.
+func (f *bloomFilter) locations(address uint32, fn func(word int, bit uint64) bool) {
+	// splitmix64 spreads the address over all 64 bits
+	hash := uint64(address) + 0x9e3779b97f4a7c15
+	hash = (hash ^ hash>>30) * 0xbf58476d1ce4e5b9
+	hash = (hash ^ hash>>27) * 0x94d049bb133111eb
+	hash ^= hash >> 31
+
+	size := uint64(len(f.bits)) * 64
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := 0; i < f.hashes; i++ {
+		location := (h1 + uint64(i)*h2) % size
+		if !fn(int(location/64), 1<<(location%64)) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(address uint32) {
+	f.locations(address, func(word int, bit uint64) bool {
+		for {
+			old := f.bits[word].Load()
+			if old&bit != 0 || f.bits[word].CompareAndSwap(old, old|bit) {
+				return true
+			}
+		}
+	})
+}
+
+// has reports whether address may have been added. It never reports false
+// for an address that was.
+func (f *bloomFilter) has(address uint32) bool {
+	found := true
+	f.locations(address, func(word int, bit uint64) bool {
+		found = f.bits[word].Load()&bit != 0
+		return found
+	})
+	return found
+}
+
+// bloomVersion is the filter of the addresses of a fingerprint version.
+type bloomVersion struct {
+	filter atomic.Pointer[bloomFilter] // nil until first built
+	next   atomic.Pointer[bloomFilter] // being built, if one is
+}
+
+// add adds the addresses of fingerprints to the filter and to the one being
+// built, which may have scanned past them already.
+func (v *bloomVersion) add(fingerprints map[uint32]models.Couple) {
+	for _, filter := range []*bloomFilter{v.filter.Load(), v.next.Load()} {
+		if filter == nil {
+			continue
+		}
+		for address := range fingerprints {
+			filter.add(address)
+		}
+	}
+}
+
+// bloomIndex holds the filters of this process, one per fingerprint
+// version looked up.
+type bloomIndex struct {
+	falsePositiveRate float64
+	rebuildInterval   time.Duration
+
+	mu       sync.Mutex
+	versions map[string]*bloomVersion
+}
+
+var bloom *bloomIndex // nil unless EnableBloomFilter was called
+
+// EnableBloomFilter makes the clients NewDBClient returns from now on keep
+// an in-process Bloom filter of the stored addresses of every fingerprint
+// version they look up, and skip the addresses it rules out instead of
+// querying the database for them. A clip that matches nothing then mostly
+// costs no lookups at all.
+//
+// A version's filter is built in the background the first time it is
+// looked up, with lookups going to the database until it is ready, and
+// rebuilt every rebuildInterval. The fingerprints clients of this process
+// store are added to it at once, but those stored by other processes are
+// only seen once it is rebuilt; a zero rebuildInterval never rebuilds it.
+// Addresses absent from the database test positive at about
+// falsePositiveRate.
+func EnableBloomFilter(falsePositiveRate float64, rebuildInterval time.Duration) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	bloom = &bloomIndex{
+		falsePositiveRate: falsePositiveRate,
+		rebuildInterval:   rebuildInterval,
+		versions:          map[string]*bloomVersion{},
+	}
+}
+
+// version returns the filters of a fingerprint version, starting to build
+// them on first use.
+func (index *bloomIndex) version(version string) *bloomVersion {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	v, ok := index.versions[version]
+	if !ok {
+		v = &bloomVersion{}
+		index.versions[version] = v
+		go index.maintain(version, v)
+	}
+	return v
+}
+
+// maintain builds the filter of a version, then rebuilds it every
+// rebuildInterval. A filter that fails to build keeps the last one.
+func (index *bloomIndex) maintain(version string, v *bloomVersion) {
+	logger := utils.GetLogger()
+	ctx := context.Background()
+
+	for {
+		start := time.Now()
+		addresses, err := index.build(ctx, version, v)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error building Bloom filter", slog.String("version", version), slog.Any("error", err))
+		} else {
+			logger.InfoContext(ctx, "Built Bloom filter", slog.String("version", version),
+				slog.Int("addresses", addresses), slog.Duration("took", time.Since(start)))
+		}
+
+		if index.rebuildInterval == 0 && err == nil {
+			return
+		}
+		// Filters that are never rebuilt still retry failed builds
+		time.Sleep(max(index.rebuildInterval, time.Minute))
+	}
+}
+
+// build fills a new filter of a version from the database, and its shards
+// if it has any, with a client of its own, the clients looking up being
+// closed by their owners at any time, and swaps it in. It returns the
+// number of addresses added.
+func (index *bloomIndex) build(ctx context.Context, version string, v *bloomVersion) (int, error) {
+	client, err := connect()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	counts, err := client.CountFingerprintsByVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Room for the songs saved until the next rebuild
+	capacity := counts[version] + counts[version]/2 + 1024
+	filter := newBloomFilter(capacity, index.falsePositiveRate)
+	v.next.Store(filter)
+	defer v.next.Store(nil)
+
+	var addresses int
+	err = client.EachAddress(ctx, version, func(address uint32) {
+		filter.add(address)
+		addresses++
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	v.filter.Store(filter)
+	return addresses, nil
+}
+
+// bloomClient checks the filters of bloom before looking addresses up and
+// adds the addresses it stores to them.
+type bloomClient struct {
+	DBClient
+	index *bloomIndex
+}
+
+// GetCouples only looks up the addresses the filter of version doesn't rule
+// out, or all of them while it is being built.
+func (c bloomClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	filter := c.index.version(version).filter.Load()
+	if filter == nil {
+		return c.DBClient.GetCouples(ctx, addresses, version)
+	}
+
+	candidates := make([]uint32, 0, len(addresses))
+	for _, address := range addresses {
+		if filter.has(address) {
+			candidates = append(candidates, address)
+		}
+	}
+	if len(candidates) == 0 {
+		return map[uint32][]models.Couple{}, nil
+	}
+	return c.DBClient.GetCouples(ctx, candidates, version)
+}
+
+// StoreFingerprints adds the addresses to the filter before storing them,
+// so no lookup can miss them once they are stored, and again after, for
+// the filter of a rebuild that scanned the database in between. Failing to
+// store them only leaves false positives behind.
+func (c bloomClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	v := c.index.version(version)
+	v.add(fingerprints)
+	defer v.add(fingerprints)
+	return c.DBClient.StoreFingerprints(ctx, fingerprints, version)
+}
+
+// ReplaceFingerprints adds the addresses to the filter like
+// StoreFingerprints.
+func (c bloomClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	v := c.index.version(version)
+	v.add(fingerprints)
+	defer v.add(fingerprints)
+	return c.DBClient.ReplaceFingerprints(ctx, songID, fingerprints, version)
+}
@@ -0,0 +1,1357 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltPath is the file of the embedded database used when DB_TYPE is
+// "bolt".
+var BoltPath = utils.GetEnv("BOLT_PATH", "db.bolt")
+
+// Buckets of the embedded database.
+var (
+	boltSongs        = []byte("songs")
+	boltSongKeys     = []byte("song_keys")      // song key -> song ID
+	boltSongYTIDs    = []byte("song_ytids")     // YouTube ID -> song ID
+	boltSongSums     = []byte("song_checksums") // checksum -> song ID
+	boltFingerprints = []byte("fingerprints")   // version -> address -> postings
+	boltSongPostings = []byte("song_postings")  // song ID + version -> addresses
+	boltIdempotency  = []byte("idempotency_keys")
+	boltJobs         = []byte("jobs")
+	boltRecognitions = []byte("recognitions")
+	boltDetections   = []byte("detections")
+	boltMelodies     = []byte("melodies")
+	boltWaveforms    = []byte("waveforms")
+	boltLSHBands     = []byte("lsh_bands")      // version -> band + song ID -> nothing
+	boltSongLSHBands = []byte("song_lsh_bands") // song ID + version -> bands
+)
+
+// boltCollections maps the collections DeleteCollection is given to the
+// buckets that hold them.
+var boltCollections = map[string][][]byte{
+	"songs":            {boltSongs, boltSongKeys, boltSongYTIDs, boltSongSums},
+	"fingerprints":     {boltFingerprints, boltSongPostings},
+	"idempotency_keys": {boltIdempotency},
+	"jobs":             {boltJobs},
+	"recognitions":     {boltRecognitions},
+	"detections":       {boltDetections},
+	"melodies":         {boltMelodies},
+	"waveforms":        {boltWaveforms},
+	"lsh_bands":        {boltLSHBands, boltSongLSHBands},
+}
+
+// BoltClient stores the library in a single file with bbolt, for devices
+// that can't run a database server. Fingerprints are stored as postings
+// lists: under each fingerprint version and address, the packed song ID
+// and anchor time of every couple found there, so a lookup reads one value
+// per address.
+type BoltClient struct {
+	db   *bolt.DB
+	path string
+}
+
+// boltFile is a database file opened by clients of this process. A bolt
+// file can only be opened once at a time, so clients share it.
+type boltFile struct {
+	db      *bolt.DB
+	clients int
+}
+
+var (
+	boltFilesMu sync.Mutex
+	boltFiles   = map[string]*boltFile{}
+)
+
+func NewBoltClient(path string) (*BoltClient, error) {
+	boltFilesMu.Lock()
+	defer boltFilesMu.Unlock()
+
+	file, ok := boltFiles[path]
+	if !ok {
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %v", path, err)
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, buckets := range boltCollections {
+				for _, bucket := range buckets {
+					if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+						return fmt.Errorf("error creating %s bucket: %v", bucket, err)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		file = &boltFile{db: db}
+		boltFiles[path] = file
+	}
+	file.clients++
+
+	return &BoltClient{db: file.db, path: path}, nil
+}
+
+// newBoltDefault opens the file at BoltPath, creating its directory if
+// missing.
+func newBoltDefault() (DBClient, error) {
+	if dir := filepath.Dir(BoltPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %v", err)
+		}
+	}
+	return NewBoltClient(BoltPath)
+}
+
+// Snapshot copies the file to path in a read transaction, which writers
+// don't wait for.
+func (db *BoltClient) Snapshot(ctx context.Context, path string) (DBClient, error) {
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error copying database: %v", err)
+	}
+	return NewBoltClient(path)
+}
+
+// Close closes the file once no other client of this process uses it.
+func (db *BoltClient) Close() error {
+	boltFilesMu.Lock()
+	defer boltFilesMu.Unlock()
+
+	file, ok := boltFiles[db.path]
+	if !ok || file.db != db.db {
+		return nil
+	}
+	file.clients--
+	if file.clients > 0 {
+		return nil
+	}
+	delete(boltFiles, db.path)
+	return file.db.Close()
+}
+
+// boltID encodes a song ID or fingerprint address as a key. Keys are big
+// endian, so songs are listed in ID order.
+func boltID(id uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, id)
+	return key
+}
+
+// posting packs a couple as it is stored in a postings list.
+func posting(couple models.Couple) []byte {
+	packed := make([]byte, 8)
+	binary.BigEndian.PutUint32(packed, couple.SongID)
+	binary.BigEndian.PutUint32(packed[4:], couple.AnchorTimeMs)
+	return packed
+}
+
+// unpackPostings decodes a postings list.
+func unpackPostings(postings []byte) []models.Couple {
+	couples := make([]models.Couple, 0, len(postings)/8)
+	for i := 0; i+8 <= len(postings); i += 8 {
+		couples = append(couples, models.Couple{
+			SongID:       binary.BigEndian.Uint32(postings[i:]),
+			AnchorTimeMs: binary.BigEndian.Uint32(postings[i+4:]),
+		})
+	}
+	return couples
+}
+
+func (db *BoltClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return putFingerprints(tx, fingerprints, version)
+	})
+	if err != nil {
+		return fmt.Errorf("error storing fingerprints: %v", err)
+	}
+	return nil
+}
+
+// putFingerprints adds the couples of fingerprints to the postings of their
+// address, skipping those already there, and records the addresses under
+// the songs so they can be deleted.
+func putFingerprints(tx *bolt.Tx, fingerprints map[uint32]models.Couple, version string) error {
+	postings, err := tx.Bucket(boltFingerprints).CreateBucketIfNotExists([]byte(version))
+	if err != nil {
+		return err
+	}
+
+	// Writing in key order keeps the tree's pages filled
+	addresses := make([]uint32, 0, len(fingerprints))
+	for address := range fingerprints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	added := map[uint32][]byte{} // song ID -> addresses
+	for _, address := range addresses {
+		couple := fingerprints[address]
+		key := boltID(address)
+		packed := posting(couple)
+
+		existing := postings.Get(key)
+		if hasPosting(existing, packed) {
+			continue
+		}
+		value := make([]byte, 0, len(existing)+len(packed))
+		value = append(append(value, existing...), packed...)
+		if err := postings.Put(key, value); err != nil {
+			return err
+		}
+		added[couple.SongID] = append(added[couple.SongID], key...)
+	}
+
+	songPostings := tx.Bucket(boltSongPostings)
+	for songID, keys := range added {
+		key := append(boltID(songID), version...)
+		existing := songPostings.Get(key)
+		value := make([]byte, 0, len(existing)+len(keys))
+		value = append(append(value, existing...), keys...)
+		if err := songPostings.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasPosting(postings, packed []byte) bool {
+	for i := 0; i+8 <= len(postings); i += 8 {
+		if bytes.Equal(postings[i:i+8], packed) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteFingerprints removes the couples of songID from every postings
+// list that has them.
+func deleteFingerprints(tx *bolt.Tx, songID uint32) error {
+	songPostings := tx.Bucket(boltSongPostings)
+	prefix := boltID(songID)
+
+	// Values are copied before the postings change under them
+	type entry struct{ key, addresses []byte }
+	var entries []entry
+	c := songPostings.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		entries = append(entries, entry{bytes.Clone(k), bytes.Clone(v)})
+	}
+
+	for _, entry := range entries {
+		version := entry.key[len(prefix):]
+		if postings := tx.Bucket(boltFingerprints).Bucket(version); postings != nil {
+			for i := 0; i+4 <= len(entry.addresses); i += 4 {
+				address := entry.addresses[i : i+4]
+				kept := withoutSong(postings.Get(address), songID)
+				var err error
+				if len(kept) == 0 {
+					err = postings.Delete(address)
+				} else {
+					err = postings.Put(address, kept)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if err := songPostings.Delete(entry.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutSong returns a copy of postings without the couples of songID.
+func withoutSong(postings []byte, songID uint32) []byte {
+	kept := make([]byte, 0, len(postings))
+	for i := 0; i+8 <= len(postings); i += 8 {
+		if binary.BigEndian.Uint32(postings[i:]) != songID {
+			kept = append(kept, postings[i:i+8]...)
+		}
+	}
+	return kept
+}
+
+// GetCouples returns the couples stored under each of addresses with the
+// given fingerprint version.
+func (db *BoltClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	couples := make(map[uint32][]models.Couple)
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(boltFingerprints).Bucket([]byte(version))
+		if postings == nil {
+			return nil
+		}
+		for _, address := range addresses {
+			if value := postings.Get(boltID(address)); value != nil {
+				couples[address] = unpackPostings(value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	return couples, nil
+}
+
+func (db *BoltClient) TotalSongs(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltSongs).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// boltSong is how a song is stored, with the key it is looked up by.
+type boltSong struct {
+	Song
+	Key string
+}
+
+func (db *BoltClient) RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error) {
+	songID := utils.GenerateUniqueID()
+	songKey := utils.GenerateSongKey(songTitle, songArtist)
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltSongKeys).Get([]byte(songKey)) != nil {
+			return fmt.Errorf("song with ytID or key already exists: %s", songKey)
+		}
+
+		song := boltSong{Song: Song{ID: songID, Title: songTitle, Artist: songArtist, YouTubeID: ytID, Checksum: checksum}, Key: songKey}
+		if err := putSong(tx, song); err != nil {
+			return fmt.Errorf("failed to register song: %v", err)
+		}
+
+		id := boltID(songID)
+		indexes := []struct {
+			bucket []byte
+			value  string
+		}{{boltSongKeys, songKey}, {boltSongYTIDs, ytID}, {boltSongSums, checksum}}
+		for _, index := range indexes {
+			if index.value == "" {
+				continue
+			}
+			if err := tx.Bucket(index.bucket).Put([]byte(index.value), id); err != nil {
+				return fmt.Errorf("failed to register song: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return songID, nil
+}
+
+func putSong(tx *bolt.Tx, song boltSong) error {
+	encoded, err := json.Marshal(song)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltSongs).Put(boltID(song.ID), encoded)
+}
+
+func getSong(tx *bolt.Tx, id []byte) (boltSong, bool, error) {
+	encoded := tx.Bucket(boltSongs).Get(id)
+	if encoded == nil {
+		return boltSong{}, false, nil
+	}
+	var song boltSong
+	if err := json.Unmarshal(encoded, &song); err != nil {
+		return boltSong{}, false, err
+	}
+	return song, true, nil
+}
+
+// GetSong retrieves a song by filter key
+func (db *BoltClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
+	index, ok := map[string][]byte{"ytID": boltSongYTIDs, "key": boltSongKeys, "checksum": boltSongSums}[filterKey]
+	if filterKey != "id" && !ok {
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
+
+	var song boltSong
+	var exists bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		var id []byte
+		if filterKey == "id" {
+			songID, ok := value.(uint32)
+			if !ok {
+				return fmt.Errorf("invalid song ID %v", value)
+			}
+			id = boltID(songID)
+		} else {
+			id = tx.Bucket(index).Get([]byte(fmt.Sprint(value)))
+			if id == nil {
+				return nil
+			}
+		}
+
+		var err error
+		song, exists, err = getSong(tx, id)
+		return err
+	})
+	if err != nil {
+		return Song{}, false, fmt.Errorf("failed to retrieve song: %v", err)
+	}
+
+	return song.Song, exists, nil
+}
+
+func (db *BoltClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *BoltClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *BoltClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+func (db *BoltClient) GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error) {
+	return db.GetSong(ctx, "checksum", checksum)
+}
+
+// updateSong applies update to a stored song. Songs that don't exist are
+// left alone, as an UPDATE would.
+func (db *BoltClient) updateSong(songID uint32, update func(song *Song)) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		song, exists, err := getSong(tx, boltID(songID))
+		if err != nil || !exists {
+			return err
+		}
+		update(&song.Song)
+		return putSong(tx, song)
+	})
+}
+
+// SetSongTempo stores the tempo of a song in beats per minute.
+func (db *BoltClient) SetSongTempo(ctx context.Context, songID uint32, bpm float64) error {
+	err := db.updateSong(songID, func(song *Song) { song.Tempo = bpm })
+	if err != nil {
+		return fmt.Errorf("failed to set song tempo: %v", err)
+	}
+	return nil
+}
+
+// SetSongMusicalKey stores the key a song is in.
+func (db *BoltClient) SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error {
+	err := db.updateSong(songID, func(song *Song) { song.MusicalKey = musicalKey })
+	if err != nil {
+		return fmt.Errorf("failed to set song key: %v", err)
+	}
+	return nil
+}
+
+// SetSongTags replaces the tags of a song.
+func (db *BoltClient) SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error {
+	err := db.updateSong(songID, func(song *Song) { song.Tags = tags })
+	if err != nil {
+		return fmt.Errorf("failed to set song tags: %v", err)
+	}
+	return nil
+}
+
+// SetSongMetadata stores what is known of a song beyond its audio.
+func (db *BoltClient) SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error {
+	err := db.updateSong(songID, func(song *Song) {
+		for _, field := range []struct {
+			value string
+			to    *string
+		}{
+			{metadata.Title, &song.Title},
+			{metadata.Artist, &song.Artist},
+			{metadata.Album, &song.Album},
+			{metadata.MusicBrainzID, &song.MusicBrainzID},
+			{metadata.MusicBrainzReleaseID, &song.MusicBrainzReleaseID},
+			{metadata.StoreURL, &song.StoreURL},
+		} {
+			if field.value != "" {
+				*field.to = field.value
+			}
+		}
+		if metadata.ReleaseYear != 0 {
+			song.ReleaseYear = metadata.ReleaseYear
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set song metadata: %v", err)
+	}
+	return nil
+}
+
+// SetSongAudio stores what probing a song's audio measured.
+func (db *BoltClient) SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error {
+	err := db.updateSong(songID, func(song *Song) {
+		song.Codec, song.Duration, song.Bitrate = audio.Codec, audio.Duration, audio.Bitrate
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set song audio: %v", err)
+	}
+	return nil
+}
+
+// SetSongFile stores the key a song's WAV file is stored under.
+func (db *BoltClient) SetSongFile(ctx context.Context, songID uint32, fileKey string) error {
+	err := db.updateSong(songID, func(song *Song) { song.FileKey = fileKey })
+	if err != nil {
+		return fmt.Errorf("failed to set song file: %v", err)
+	}
+	return nil
+}
+
+// SetSongPreview stores the key a song's preview clip is stored under.
+func (db *BoltClient) SetSongPreview(ctx context.Context, songID uint32, previewKey string) error {
+	err := db.updateSong(songID, func(song *Song) { song.PreviewKey = previewKey })
+	if err != nil {
+		return fmt.Errorf("failed to set song preview: %v", err)
+	}
+	return nil
+}
+
+// DeleteSongByID deletes a song by ID
+func (db *BoltClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		id := boltID(songID)
+		song, exists, err := getSong(tx, id)
+		if err != nil || !exists {
+			return err
+		}
+
+		// An index entry is only removed if it still points at this song
+		indexes := []struct {
+			bucket []byte
+			value  string
+		}{{boltSongKeys, song.Key}, {boltSongYTIDs, song.YouTubeID}, {boltSongSums, song.Checksum}}
+		for _, index := range indexes {
+			bucket := tx.Bucket(index.bucket)
+			if index.value != "" && bytes.Equal(bucket.Get([]byte(index.value)), id) {
+				if err := bucket.Delete([]byte(index.value)); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(boltSongs).Delete(id)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete song: %v", err)
+	}
+	return nil
+}
+
+// DeleteFingerprintsBySongID deletes every fingerprint of a song
+func (db *BoltClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return deleteFingerprints(tx, songID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	return nil
+}
+
+// ReplaceFingerprints swaps the fingerprints of a song for new ones in one
+// transaction, so lookups see either the old or the new fingerprints.
+func (db *BoltClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteFingerprints(tx, songID); err != nil {
+			return err
+		}
+		return putFingerprints(tx, fingerprints, version)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace fingerprints: %v", err)
+	}
+	return nil
+}
+
+// DeleteCollection empties the buckets of a collection.
+func (db *BoltClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	buckets, ok := boltCollections[collectionName]
+	if !ok {
+		return nil
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if err := tx.DeleteBucket(bucket); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting collection: %v", err)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey records key as pending. If the key was already used,
+// its existing record is returned and claimed is false.
+func (db *BoltClient) ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	var claimed bool
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltIdempotency)
+		if existing := bucket.Get([]byte(key)); existing != nil {
+			return json.Unmarshal(existing, &record)
+		}
+
+		record = IdempotencyRecord{Key: key, Status: IdempotencyPending, CreatedAt: time.Now()}
+		claimed = true
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), encoded)
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+
+	return record, claimed, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request made with key.
+func (db *BoltClient) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltIdempotency)
+		existing := bucket.Get([]byte(key))
+		if existing == nil {
+			return nil
+		}
+
+		var record IdempotencyRecord
+		if err := json.Unmarshal(existing, &record); err != nil {
+			return err
+		}
+		record.Status = IdempotencyDone
+		record.Response = response
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried.
+func (db *BoltClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltIdempotency).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+func putJob(tx *bolt.Tx, job Job) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltJobs).Put([]byte(job.ID), encoded)
+}
+
+// eachJob calls fn with every stored job.
+func eachJob(tx *bolt.Tx, fn func(job Job)) error {
+	return tx.Bucket(boltJobs).ForEach(func(_, encoded []byte) error {
+		var job Job
+		if err := json.Unmarshal(encoded, &job); err != nil {
+			return err
+		}
+		fn(job)
+		return nil
+	})
+}
+
+func (db *BoltClient) EnqueueJob(ctx context.Context, job Job) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltJobs).Get([]byte(job.ID)) != nil {
+			return fmt.Errorf("job %s already exists", job.ID)
+		}
+		return putJob(tx, job)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return nil
+}
+
+func (db *BoltClient) GetJob(ctx context.Context, jobID string) (Job, bool, error) {
+	var job Job
+	var exists bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(boltJobs).Get([]byte(jobID))
+		if encoded == nil {
+			return nil
+		}
+		exists = true
+		return json.Unmarshal(encoded, &job)
+	})
+	if err != nil {
+		return Job{}, false, fmt.Errorf("failed to retrieve job: %v", err)
+	}
+	return job, exists, nil
+}
+
+// ClaimNextJob marks the oldest queued job that is due as processing and
+// returns it.
+func (db *BoltClient) ClaimNextJob(ctx context.Context) (Job, bool, error) {
+	var next Job
+	var found bool
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		err := eachJob(tx, func(job Job) {
+			if job.Status != JobQueued || job.NextRunAt.After(now) {
+				return
+			}
+			if !found || job.CreatedAt.Before(next.CreatedAt) {
+				next, found = job, true
+			}
+		})
+		if err != nil || !found {
+			return err
+		}
+
+		next.Status = JobProcessing
+		next.UpdatedAt = now
+		return putJob(tx, next)
+	})
+	if err != nil {
+		return Job{}, false, fmt.Errorf("failed to claim job: %v", err)
+	}
+
+	return next, found, nil
+}
+
+func (db *BoltClient) UpdateJob(ctx context.Context, job Job) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		encoded := tx.Bucket(boltJobs).Get([]byte(job.ID))
+		if encoded == nil {
+			return nil
+		}
+
+		var stored Job
+		if err := json.Unmarshal(encoded, &stored); err != nil {
+			return err
+		}
+		stored.Status, stored.Result, stored.Error = job.Status, job.Result, job.Error
+		stored.Attempts, stored.NextRunAt, stored.UpdatedAt = job.Attempts, job.NextRunAt, job.UpdatedAt
+		return putJob(tx, stored)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	return nil
+}
+
+// RequeueProcessingJobs puts jobs left processing by a stopped worker back
+// in the queue.
+func (db *BoltClient) RequeueProcessingJobs(ctx context.Context) (int, error) {
+	var requeued int
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		var jobs []Job
+		err := eachJob(tx, func(job Job) {
+			if job.Status == JobProcessing {
+				jobs = append(jobs, job)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, job := range jobs {
+			job.Status = JobQueued
+			job.UpdatedAt = now
+			if err := putJob(tx, job); err != nil {
+				return err
+			}
+		}
+		requeued = len(jobs)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+	return requeued, nil
+}
+
+// ListJobs returns up to limit jobs with the given status, oldest first.
+func (db *BoltClient) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	var jobs []Job
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return eachJob(tx, func(job Job) {
+			if job.Status == status {
+				jobs = append(jobs, job)
+			}
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// DeleteJobs deletes every job with the given status.
+func (db *BoltClient) DeleteJobs(ctx context.Context, status string) (int, error) {
+	var deleted int
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		var ids []string
+		err := eachJob(tx, func(job Job) {
+			if job.Status == status {
+				ids = append(ids, job.ID)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := tx.Bucket(boltJobs).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		deleted = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+	return deleted, nil
+}
+
+// ListSongs returns up to limit songs ordered by ID, skipping the first
+// offset.
+func (db *BoltClient) ListSongs(ctx context.Context, offset, limit int) ([]Song, error) {
+	return db.listSongs(func(Song) bool { return true }, offset, limit)
+}
+
+// ListSongsByMusicalKey is ListSongs for the songs in musicalKey.
+func (db *BoltClient) ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error) {
+	return db.listSongs(func(song Song) bool { return song.MusicalKey == musicalKey }, offset, limit)
+}
+
+func (db *BoltClient) listSongs(match func(Song) bool, offset, limit int) ([]Song, error) {
+	var songs []Song
+	err := db.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltSongs).Cursor()
+		for k, encoded := c.First(); k != nil && len(songs) < limit; k, encoded = c.Next() {
+			var song boltSong
+			if err := json.Unmarshal(encoded, &song); err != nil {
+				return err
+			}
+			if !match(song.Song) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			songs = append(songs, song.Song)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+	return songs, nil
+}
+
+func (db *BoltClient) TotalFingerprints(ctx context.Context) (int, error) {
+	counts, err := db.CountFingerprintsByVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
+
+// CountFingerprints returns the number of fingerprints stored for a song.
+func (db *BoltClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.db.View(func(tx *bolt.Tx) error {
+		prefix := boltID(songID)
+		c := tx.Bucket(boltSongPostings).Cursor()
+		for k, addresses := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, addresses = c.Next() {
+			count += len(addresses) / 4
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	return count, nil
+}
+
+// ListFingerprints returns every fingerprint stored for a song.
+func (db *BoltClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	var fingerprints []Fingerprint
+	err := db.db.View(func(tx *bolt.Tx) error {
+		prefix := boltID(songID)
+		c := tx.Bucket(boltSongPostings).Cursor()
+		for k, addresses := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, addresses = c.Next() {
+			version := k[len(prefix):]
+			postings := tx.Bucket(boltFingerprints).Bucket(version)
+			if postings == nil {
+				continue
+			}
+			for i := 0; i+4 <= len(addresses); i += 4 {
+				address := binary.BigEndian.Uint32(addresses[i:])
+				for _, couple := range unpackPostings(postings.Get(addresses[i : i+4])) {
+					if couple.SongID == songID {
+						fingerprints = append(fingerprints, Fingerprint{address, couple.AnchorTimeMs, string(version)})
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+	return fingerprints, nil
+}
+
+// CommonAddresses returns the addresses of a fingerprint version that more
+// than maxSongs songs have fingerprints at.
+func (db *BoltClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	var addresses []uint32
+	err := db.db.View(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(boltFingerprints).Bucket([]byte(version))
+		if postings == nil {
+			return nil
+		}
+		return postings.ForEach(func(address, value []byte) error {
+			if len(value)/8 <= maxSongs {
+				return nil
+			}
+			songs := map[uint32]bool{}
+			for i := 0; i+8 <= len(value); i += 8 {
+				songs[binary.BigEndian.Uint32(value[i:])] = true
+			}
+			if len(songs) > maxSongs {
+				addresses = append(addresses, binary.BigEndian.Uint32(address))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	return addresses, nil
+}
+
+// EachAddress calls fn with every address of a fingerprint version.
+func (db *BoltClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	err := db.db.View(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(boltFingerprints).Bucket([]byte(version))
+		if postings == nil {
+			return nil
+		}
+		return postings.ForEach(func(address, _ []byte) error {
+			fn(binary.BigEndian.Uint32(address))
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	return nil
+}
+
+// DeleteAddresses deletes the fingerprints of a version stored at
+// addresses, and returns how many there were. The addresses are also
+// dropped from the lists of the songs that had them.
+func (db *BoltClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	var deleted int
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(boltFingerprints).Bucket([]byte(version))
+		if postings == nil {
+			return nil
+		}
+
+		pruned := map[uint32]map[uint32]bool{} // song ID -> addresses
+		for _, address := range addresses {
+			key := boltID(address)
+			value := postings.Get(key)
+			for i := 0; i+8 <= len(value); i += 8 {
+				songID := binary.BigEndian.Uint32(value[i:])
+				if pruned[songID] == nil {
+					pruned[songID] = map[uint32]bool{}
+				}
+				pruned[songID][address] = true
+			}
+			deleted += len(value) / 8
+			if err := postings.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		songPostings := tx.Bucket(boltSongPostings)
+		for songID, gone := range pruned {
+			key := append(boltID(songID), version...)
+			existing := songPostings.Get(key)
+			kept := make([]byte, 0, len(existing))
+			for i := 0; i+4 <= len(existing); i += 4 {
+				if !gone[binary.BigEndian.Uint32(existing[i:])] {
+					kept = append(kept, existing[i:i+4]...)
+				}
+			}
+			var err error
+			if len(kept) == 0 {
+				err = songPostings.Delete(key)
+			} else {
+				err = songPostings.Put(key, kept)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting fingerprints: %v", err)
+	}
+	return deleted, nil
+}
+
+// CountFingerprintsByVersion returns the number of fingerprints stored of
+// every fingerprint version.
+func (db *BoltClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := db.db.View(func(tx *bolt.Tx) error {
+		fingerprints := tx.Bucket(boltFingerprints)
+		return fingerprints.ForEach(func(version, _ []byte) error {
+			return fingerprints.Bucket(version).ForEach(func(_, postings []byte) error {
+				counts[string(version)] += len(postings) / 8
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	return counts, nil
+}
+
+// appendRecord stores value under the next key of a bucket kept in the
+// order records were made.
+func (db *BoltClient) appendRecord(bucket []byte, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return db.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bucket)
+		sequence, err := records.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		return records.Put(key, encoded)
+	})
+}
+
+// latestRecords calls decode with the records of bucket, newest first,
+// until it returns false.
+func (db *BoltClient) latestRecords(bucket []byte, decode func(encoded []byte) (bool, error)) error {
+	return db.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, encoded := c.Last(); k != nil; k, encoded = c.Prev() {
+			more, err := decode(encoded)
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *BoltClient) RecordRecognition(ctx context.Context, recognition Recognition) error {
+	if err := db.appendRecord(boltRecognitions, recognition); err != nil {
+		return fmt.Errorf("failed to record recognition: %v", err)
+	}
+	return nil
+}
+
+// ListRecognitions returns the latest limit recognitions, newest first.
+func (db *BoltClient) ListRecognitions(ctx context.Context, limit int) ([]Recognition, error) {
+	var recognitions []Recognition
+	err := db.latestRecords(boltRecognitions, func(encoded []byte) (bool, error) {
+		if len(recognitions) == limit {
+			return false, nil
+		}
+		var recognition Recognition
+		if err := json.Unmarshal(encoded, &recognition); err != nil {
+			return false, err
+		}
+		recognitions = append(recognitions, recognition)
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+	return recognitions, nil
+}
+
+// CountRecognitions returns how many times a song has been recognized.
+func (db *BoltClient) CountRecognitions(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.latestRecords(boltRecognitions, func(encoded []byte) (bool, error) {
+		var recognition Recognition
+		if err := json.Unmarshal(encoded, &recognition); err != nil {
+			return false, err
+		}
+		if recognition.SongID == songID {
+			count++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting recognitions: %v", err)
+	}
+	return count, nil
+}
+
+func (db *BoltClient) RecordDetection(ctx context.Context, detection Detection) error {
+	if err := db.appendRecord(boltDetections, detection); err != nil {
+		return fmt.Errorf("failed to record detection: %v", err)
+	}
+	return nil
+}
+
+// ListDetections returns the latest limit detections on stream, or on all
+// streams if stream is empty, newest first.
+func (db *BoltClient) ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error) {
+	var detections []Detection
+	err := db.latestRecords(boltDetections, func(encoded []byte) (bool, error) {
+		if len(detections) == limit {
+			return false, nil
+		}
+		var detection Detection
+		if err := json.Unmarshal(encoded, &detection); err != nil {
+			return false, err
+		}
+		if stream == "" || detection.Stream == stream {
+			detection.DetectedAt = detection.DetectedAt.UTC()
+			detections = append(detections, detection)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+	return detections, nil
+}
+
+// putBlob stores value under a song in bucket, or deletes the song's value
+// if value is nil.
+func (db *BoltClient) putBlob(bucket []byte, songID uint32, value []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		if value == nil {
+			return tx.Bucket(bucket).Delete(boltID(songID))
+		}
+		return tx.Bucket(bucket).Put(boltID(songID), value)
+	})
+}
+
+// StoreMelody saves the pitch contour of a song, replacing any it had.
+func (db *BoltClient) StoreMelody(ctx context.Context, songID uint32, contour []byte) error {
+	if err := db.putBlob(boltMelodies, songID, bytes.Clone(contour)); err != nil {
+		return fmt.Errorf("failed to store melody: %v", err)
+	}
+	return nil
+}
+
+// ListMelodies returns the pitch contour of every song that has one, keyed
+// by song ID.
+func (db *BoltClient) ListMelodies(ctx context.Context) (map[uint32][]byte, error) {
+	melodies := map[uint32][]byte{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMelodies).ForEach(func(k, contour []byte) error {
+			melodies[binary.BigEndian.Uint32(k)] = bytes.Clone(contour)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+	return melodies, nil
+}
+
+func (db *BoltClient) DeleteMelody(ctx context.Context, songID uint32) error {
+	if err := db.putBlob(boltMelodies, songID, nil); err != nil {
+		return fmt.Errorf("failed to delete melody: %v", err)
+	}
+	return nil
+}
+
+// StoreWaveform saves the amplitude envelope of a song, replacing any it
+// had.
+func (db *BoltClient) StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error {
+	if err := db.putBlob(boltWaveforms, songID, bytes.Clone(waveform)); err != nil {
+		return fmt.Errorf("failed to store waveform: %v", err)
+	}
+	return nil
+}
+
+// GetWaveform returns the amplitude envelope of a song, if it has one.
+func (db *BoltClient) GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error) {
+	var waveform []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		waveform = bytes.Clone(tx.Bucket(boltWaveforms).Get(boltID(songID)))
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get waveform: %v", err)
+	}
+	return waveform, waveform != nil, nil
+}
+
+func (db *BoltClient) DeleteWaveform(ctx context.Context, songID uint32) error {
+	if err := db.putBlob(boltWaveforms, songID, nil); err != nil {
+		return fmt.Errorf("failed to delete waveform: %v", err)
+	}
+	return nil
+}
+
+// StoreLSHBands saves the LSH bands of a song's fingerprints of a version,
+// replacing those it had.
+func (db *BoltClient) StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteLSHBands(tx, songID, version); err != nil {
+			return err
+		}
+
+		index, err := tx.Bucket(boltLSHBands).CreateBucketIfNotExists([]byte(version))
+		if err != nil {
+			return err
+		}
+		packed := make([]byte, 0, 8*len(bands))
+		for _, band := range bands {
+			packed = binary.BigEndian.AppendUint64(packed, band)
+			if err := index.Put(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64(nil, band), songID), nil); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltSongLSHBands).Put(append(boltID(songID), version...), packed)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store lsh bands: %v", err)
+	}
+	return nil
+}
+
+// LSHCandidates returns the songs with the most of bands among their LSH
+// bands of a version, at most limit of them, most first. A limit of zero
+// returns them all.
+func (db *BoltClient) LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error) {
+	counts := map[uint32]int{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(boltLSHBands).Bucket([]byte(version))
+		if index == nil {
+			return nil
+		}
+		c := index.Cursor()
+		for _, band := range bands {
+			prefix := binary.BigEndian.AppendUint64(nil, band)
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				counts[binary.BigEndian.Uint32(k[8:])]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up lsh bands: %v", err)
+	}
+	return topCandidates(counts, limit), nil
+}
+
+// DeleteLSHBands deletes every LSH band of a song.
+func (db *BoltClient) DeleteLSHBands(ctx context.Context, songID uint32) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return deleteLSHBands(tx, songID, "")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete lsh bands: %v", err)
+	}
+	return nil
+}
+
+// deleteLSHBands deletes the LSH bands of a song of a version, or of every
+// version if version is empty, within tx.
+func deleteLSHBands(tx *bolt.Tx, songID uint32, version string) error {
+	songBands := tx.Bucket(boltSongLSHBands)
+
+	var keys [][]byte
+	prefix := boltID(songID)
+	c := songBands.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if version == "" || string(k[len(prefix):]) == version {
+			keys = append(keys, bytes.Clone(k))
+		}
+	}
+
+	for _, k := range keys {
+		if index := tx.Bucket(boltLSHBands).Bucket(k[len(prefix):]); index != nil {
+			packed := songBands.Get(k)
+			for i := 0; i+8 <= len(packed); i += 8 {
+				key := binary.BigEndian.AppendUint32(bytes.Clone(packed[i:i+8]), songID)
+				if err := index.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := songBands.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,54 +1,301 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"song-recognition/models"
 	"song-recognition/utils"
+	"sync"
+	"time"
 )
 
+// Store is the part of a database the ingestion pipeline and the matcher
+// need: registering songs, storing their fingerprints, looking both up and
+// deleting them again. Another database only has to implement it to have
+// songs ingested into it and clips matched against it; the pipeline keeps
+// a song's other details only in stores that are a whole DBClient.
+type Store interface {
+	RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error)
+	GetSongByID(ctx context.Context, songID uint32) (Song, bool, error)
+	GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error)
+	TotalSongs(ctx context.Context) (int, error)
+	DeleteSongByID(ctx context.Context, songID uint32) error
+	StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error
+	GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error)
+	DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error
+}
+
 type DBClient interface {
+	Store
 	Close() error
-	StoreFingerprints(fingerprints map[uint32]models.Couple) error
-	GetCouples(addresses []uint32) (map[uint32][]models.Couple, error)
-	TotalSongs() (int, error)
-	RegisterSong(songTitle, songArtist, ytID string) (uint32, error)
-	GetSong(filterKey string, value interface{}) (Song, bool, error)
-	GetSongByID(songID uint32) (Song, bool, error)
-	GetSongByYTID(ytID string) (Song, bool, error)
-	GetSongByKey(key string) (Song, bool, error)
-	DeleteSongByID(songID uint32) error
-	DeleteCollection(collectionName string) error
+	GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error)
+	GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error)
+	GetSongByKey(ctx context.Context, key string) (Song, bool, error)
+	SetSongTempo(ctx context.Context, songID uint32, bpm float64) error
+	SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error
+	SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error
+	SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error
+	SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error
+	SetSongFile(ctx context.Context, songID uint32, fileKey string) error
+	SetSongPreview(ctx context.Context, songID uint32, previewKey string) error
+	ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error
+	DeleteCollection(ctx context.Context, collectionName string) error
+	ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	EnqueueJob(ctx context.Context, job Job) error
+	GetJob(ctx context.Context, jobID string) (Job, bool, error)
+	ClaimNextJob(ctx context.Context) (Job, bool, error)
+	UpdateJob(ctx context.Context, job Job) error
+	RequeueProcessingJobs(ctx context.Context) (int, error)
+	ListJobs(ctx context.Context, status string, limit int) ([]Job, error)
+	DeleteJobs(ctx context.Context, status string) (int, error)
+	ListSongs(ctx context.Context, offset, limit int) ([]Song, error)
+	ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error)
+	TotalFingerprints(ctx context.Context) (int, error)
+	CountFingerprintsByVersion(ctx context.Context) (map[string]int, error)
+	CountFingerprints(ctx context.Context, songID uint32) (int, error)
+	ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error)
+	CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error)
+	EachAddress(ctx context.Context, version string, fn func(address uint32)) error
+	DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error)
+	RecordRecognition(ctx context.Context, recognition Recognition) error
+	ListRecognitions(ctx context.Context, limit int) ([]Recognition, error)
+	CountRecognitions(ctx context.Context, songID uint32) (int, error)
+	RecordDetection(ctx context.Context, detection Detection) error
+	ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error)
+	StoreMelody(ctx context.Context, songID uint32, contour []byte) error
+	ListMelodies(ctx context.Context) (map[uint32][]byte, error)
+	DeleteMelody(ctx context.Context, songID uint32) error
+	StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error
+	GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error)
+	DeleteWaveform(ctx context.Context, songID uint32) error
+	StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error
+	LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error)
+	DeleteLSHBands(ctx context.Context, songID uint32) error
 }
 
+// LegacyFingerprintVersion is the version of fingerprints stored before
+// versions were. They were all made with the default settings, which
+// shazam.FingerprintVersion gives this version.
+const LegacyFingerprintVersion = "v1:average:0:5:16383:9:14"
+
 type Song struct {
+	ID        uint32
 	Title     string
 	Artist    string
 	YouTubeID string
+	Checksum  string  // SHA-256 of the song's decoded PCM, if known
+	Tempo     float64 // beats per minute, or zero if unknown
+	// MusicalKey is the key the song is in, such as "A minor", if known. It
+	// is unrelated to the title and artist key songs are looked up by.
+	MusicalKey string
+	// Tags are the labels classifiers gave the song, such as its genre.
+	Tags map[string]string
+	// Album and ReleaseYear are those of the song's first release, if known.
+	Album       string
+	ReleaseYear int
+	// MusicBrainzID is the MusicBrainz recording ID of the song, if known,
+	// and MusicBrainzReleaseID the ID of the release Album is.
+	MusicBrainzID        string
+	MusicBrainzReleaseID string
+	// Codec, Duration and Bitrate describe the audio the song was
+	// registered from, if known.
+	Codec    string
+	Duration float64 // seconds
+	Bitrate  int     // average bits per second
+	// StoreURL is the page of the song on a music store, if known.
+	StoreURL string
+	// FileKey is the key the song's WAV file is stored under, named after
+	// its checksum. It is empty for songs stored before files were, under
+	// their title and artist.
+	FileKey string
+	// PreviewKey is the key the song's compressed preview clip is stored
+	// under, next to its file, if one was cut.
+	PreviewKey string
+}
+
+// Fingerprint is a couple of a song with the address it is stored under.
+type Fingerprint struct {
+	Address      uint32
+	AnchorTimeMs uint32
+	Version      string
+}
+
+// SongAudio is what probing the audio a song was registered from measured.
+type SongAudio struct {
+	Codec    string
+	Duration float64
+	Bitrate  int
+}
+
+// SongMetadata is what catalogues such as MusicBrainz know of a song.
+// SetSongMetadata leaves a song's fields alone where it has none.
+type SongMetadata struct {
+	// Title and Artist are the canonical title and name of the song's
+	// artist. They replace those the song was registered with, but not the
+	// key it is looked up by.
+	Title                string
+	Artist               string
+	Album                string
+	ReleaseYear          int
+	MusicBrainzID        string
+	MusicBrainzReleaseID string
+	StoreURL             string
+}
+
+// Idempotency key states.
+const (
+	IdempotencyPending = "pending"
+	IdempotencyDone    = "done"
+)
+
+// IdempotencyRecord is the stored state of a request made with an
+// idempotency key. Response holds the encoded result once Status is done.
+type IdempotencyRecord struct {
+	Key       string
+	Status    string
+	Response  []byte
+	CreatedAt time.Time
+}
+
+// Job states.
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed" // dead-lettered: out of retries or not retryable
+)
+
+// Job is a persisted unit of asynchronous work. Payload and Result are
+// opaque to the database and encoded by the package that owns the job.
+type Job struct {
+	ID        string
+	Status    string
+	Payload   []byte
+	Result    []byte
+	Error     string
+	Attempts  int       // number of times the job has been run
+	NextRunAt time.Time // a queued job isn't claimed before this time
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
-var DBtype = utils.GetEnv("DB_TYPE", "sqlite") // Can be "sqlite" or "mongo"
+// Recognition is one entry of the recognition history.
+type Recognition struct {
+	SongID    uint32 // best match, or 0 if nothing matched
+	Score     float64
+	OffsetMs  uint32 // where in the song the clip was found
+	CreatedAt time.Time
+}
+
+// Detection is a song heard on a monitored stream.
+type Detection struct {
+	Stream     string // name of the stream
+	SongID     uint32
+	Score      float64
+	OffsetMs   uint32    // where in the song the stream was when it was detected
+	DetectedAt time.Time // UTC
+}
+
+var DBtype = utils.GetEnv("DB_TYPE", "sqlite") // "sqlite", "bolt", "postgres", "mongo", "memory" or a registered backend
+
+// Backend connects a new client of a database.
+type Backend func() (DBClient, error)
 
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{
+		"bolt":     newBoltDefault,
+		"memory":   newProcessMemory,
+		"mongo":    newMongoFromEnv,
+		"postgres": newPostgresDefault,
+		"sqlite":   newSQLiteDefault,
+	}
+)
+
+// Register makes backend the database used when DB_TYPE is name, replacing
+// the backend registered under name, if any. Backends of other databases
+// are registered from an init function, so the server, the pipeline and
+// the commands use them without changes.
+func Register(name string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = backend
+}
+
+// NewDBClient connects a client of the backend DB_TYPE selects, keeping
+// fingerprints in the shards of DB_SHARDS if it is set, checking the Bloom
+// filter before lookups once EnableBloomFilter was called, and the postings
+// cache before that once EnablePostingsCache was.
 func NewDBClient() (DBClient, error) {
-	switch DBtype {
-	case "mongo":
-		var (
-			dbUsername = utils.GetEnv("DB_USER")
-			dbPassword = utils.GetEnv("DB_PASS")
-			dbName     = utils.GetEnv("DB_NAME")
-			dbHost     = utils.GetEnv("DB_HOST")
-			dbPort     = utils.GetEnv("DB_PORT")
-
-			dbUri = "mongodb://" + dbUsername + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName
-		)
-		if dbUsername == "" || dbPassword == "" {
-			dbUri = "mongodb://localhost:27017"
-		}
-		return NewMongoClient(dbUri)
+	backendsMu.RLock()
+	index, cache := bloom, postings
+	backendsMu.RUnlock()
 
-	case "sqlite":
-		return NewSQLiteClient("db.sqlite3")
+	client, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		client = bloomClient{DBClient: client, index: index}
+	}
+	if cache != nil {
+		client = postingsClient{DBClient: client, cache: cache}
+	}
+	return client, nil
+}
 
-	default:
+// connect connects a client of the backend DB_TYPE selects, keeping
+// fingerprints in the shards of DB_SHARDS if it is set, without the
+// in-process filters and caches in front of it.
+func connect() (DBClient, error) {
+	backendsMu.RLock()
+	backend, ok := backends[DBtype]
+	backendsMu.RUnlock()
+	if !ok {
 		return nil, fmt.Errorf("unsupported database type: %s", DBtype)
 	}
+
+	client, err := backend()
+	if err != nil {
+		return nil, err
+	}
+	if len(ShardURLs) > 0 {
+		return newShardedClient(client, ShardURLs)
+	}
+	return client, nil
+}
+
+// newMongoFromEnv connects to the MongoDB of the DB_* environment
+// variables, or to a local one without credentials.
+func newMongoFromEnv() (DBClient, error) {
+	var (
+		dbUsername = utils.GetEnv("DB_USER")
+		dbPassword = utils.GetEnv("DB_PASS")
+		dbName     = utils.GetEnv("DB_NAME")
+		dbHost     = utils.GetEnv("DB_HOST")
+		dbPort     = utils.GetEnv("DB_PORT")
+
+		dbUri = "mongodb://" + dbUsername + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName
+	)
+	if dbUsername == "" || dbPassword == "" {
+		dbUri = "mongodb://localhost:27017"
+	}
+	return NewMongoClient(dbUri)
+}
+
+// SQLitePath is the file of the SQLite database, created on first use.
+var SQLitePath = utils.GetEnv("DB_PATH", "db.sqlite3")
+
+// newSQLiteDefault opens the SQLite database at SQLitePath.
+func newSQLiteDefault() (DBClient, error) {
+	if dir := filepath.Dir(SQLitePath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %v", err)
+		}
+	}
+	return NewSQLiteClient(sqliteFileURI(SQLitePath, sqliteShared))
 }
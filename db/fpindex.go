@@ -0,0 +1,329 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"song-recognition/models"
+	"sort"
+)
+
+// A fingerprint index file holds the fingerprints of one version and the
+// songs they belong to, laid out to be looked up where it is mapped in
+// memory. Every integer is little-endian:
+//
+//	magic        8 bytes, fingerprintIndexMagic
+//	header       version length, addresses, couples and songs, uint32 each
+//	version      the fingerprint version
+//	addresses    sorted addresses, uint32 each
+//	postings     index of the first couple of every address, and the number
+//	             of couples after the last, uint64 each
+//	couples      anchor time and song ID, uint32 each, by song and time
+//	song IDs     sorted song IDs, uint32 each
+//	song offsets offset of every song's record in the records, and their
+//	             length after the last, uint64 each
+//	records      songs encoded as JSON
+const fingerprintIndexMagic = "SEEKIDX1"
+
+// fingerprintIndexPageSize is the number of songs read from the database
+// at a time while building an index.
+const fingerprintIndexPageSize = 500
+
+// FingerprintIndex is a read-only fingerprint index file mapped in memory.
+// Its lookups read the file directly, without a database, and are safe
+// for concurrent use until it is closed.
+type FingerprintIndex struct {
+	release func() error // unmaps the file
+
+	version   string
+	addresses []byte // sorted uint32 addresses
+	postings  []byte
+	couples   []byte
+	songIDs   []byte
+	songs     []byte // offsets of the records
+	records   []byte
+}
+
+// WriteFingerprintIndex writes the fingerprints of version the database
+// has, and every song, to a fingerprint index file at path, replacing the
+// file there once it is complete. A server that has the old file open
+// keeps reading it. It returns the number of songs and couples written.
+func WriteFingerprintIndex(ctx context.Context, dbClient DBClient, version, path string) (int, int, error) {
+	type entry struct{ address, anchorTimeMs, songID uint32 }
+
+	var (
+		entries []entry
+		songs   []Song
+	)
+	for offset := 0; ; offset += fingerprintIndexPageSize {
+		page, err := dbClient.ListSongs(ctx, offset, fingerprintIndexPageSize)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error listing songs: %v", err)
+		}
+
+		for _, song := range page {
+			if err := ctx.Err(); err != nil {
+				return 0, 0, err
+			}
+			fingerprints, err := dbClient.ListFingerprints(ctx, song.ID)
+			if err != nil {
+				return 0, 0, fmt.Errorf("error listing fingerprints of song %d: %v", song.ID, err)
+			}
+			for _, fingerprint := range fingerprints {
+				if fingerprint.Version == version {
+					entries = append(entries, entry{fingerprint.Address, fingerprint.AnchorTimeMs, song.ID})
+				}
+			}
+			songs = append(songs, song)
+		}
+
+		if len(page) < fingerprintIndexPageSize {
+			break
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.address != b.address {
+			return a.address < b.address
+		}
+		if a.songID != b.songID {
+			return a.songID < b.songID
+		}
+		return a.anchorTimeMs < b.anchorTimeMs
+	})
+	sort.Slice(songs, func(i, j int) bool { return songs[i].ID < songs[j].ID })
+
+	var addresses []uint32
+	var postings []uint64
+	for i, e := range entries {
+		if i == 0 || e.address != entries[i-1].address {
+			addresses = append(addresses, e.address)
+			postings = append(postings, uint64(i))
+		}
+	}
+	postings = append(postings, uint64(len(entries)))
+
+	var records []byte
+	songOffsets := make([]uint64, 0, len(songs)+1)
+	for _, song := range songs {
+		record, err := json.Marshal(song)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error encoding song %d: %v", song.ID, err)
+		}
+		songOffsets = append(songOffsets, uint64(len(records)))
+		records = append(records, record...)
+	}
+	songOffsets = append(songOffsets, uint64(len(records)))
+
+	if uint64(len(entries)) > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("%d couples are too many for an index file", len(entries))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error creating index file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	le := binary.LittleEndian
+	w.WriteString(fingerprintIndexMagic)
+	for _, n := range []int{len(version), len(addresses), len(entries), len(songs)} {
+		w.Write(le.AppendUint32(nil, uint32(n)))
+	}
+	w.WriteString(version)
+	for _, address := range addresses {
+		w.Write(le.AppendUint32(nil, address))
+	}
+	for _, posting := range postings {
+		w.Write(le.AppendUint64(nil, posting))
+	}
+	for _, e := range entries {
+		w.Write(le.AppendUint32(le.AppendUint32(nil, e.anchorTimeMs), e.songID))
+	}
+	for _, song := range songs {
+		w.Write(le.AppendUint32(nil, song.ID))
+	}
+	for _, offset := range songOffsets {
+		w.Write(le.AppendUint64(nil, offset))
+	}
+	w.Write(records)
+
+	if err := w.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("error writing index file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, 0, fmt.Errorf("error writing index file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, 0, fmt.Errorf("error writing index file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, 0, fmt.Errorf("error replacing index file: %v", err)
+	}
+	return len(songs), len(entries), nil
+}
+
+// OpenFingerprintIndex maps the fingerprint index file at path in memory.
+func OpenFingerprintIndex(path string) (*FingerprintIndex, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening index file: %v", err)
+	}
+
+	index, err := parseFingerprintIndex(data)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("error reading index file %s: %v", path, err)
+	}
+	index.release = release
+	return index, nil
+}
+
+var errCorruptIndex = errors.New("not a fingerprint index file, or a truncated or damaged one")
+
+// parseFingerprintIndex slices data into the sections of an index file,
+// checking they fit in it and every offset is in its section, so lookups
+// needn't check them. It reads the whole file to do so.
+func parseFingerprintIndex(data []byte) (*FingerprintIndex, error) {
+	const headerSize = len(fingerprintIndexMagic) + 16
+	if len(data) < headerSize || string(data[:len(fingerprintIndexMagic)]) != fingerprintIndexMagic {
+		return nil, errCorruptIndex
+	}
+
+	le := binary.LittleEndian
+	header := data[len(fingerprintIndexMagic):headerSize]
+	versionLen := uint64(le.Uint32(header))
+	addresses := uint64(le.Uint32(header[4:]))
+	couples := uint64(le.Uint32(header[8:]))
+	songs := uint64(le.Uint32(header[12:]))
+
+	rest := data[headerSize:]
+	section := func(size uint64) []byte {
+		if rest == nil || size > uint64(len(rest)) {
+			rest = nil
+			return nil
+		}
+		s := rest[:size:size]
+		rest = rest[size:]
+		return s
+	}
+
+	index := &FingerprintIndex{}
+	index.version = string(section(versionLen))
+	index.addresses = section(addresses * 4)
+	index.postings = section((addresses + 1) * 8)
+	index.couples = section(couples * 8)
+	index.songIDs = section(songs * 4)
+	index.songs = section((songs + 1) * 8)
+	if rest == nil {
+		return nil, errCorruptIndex
+	}
+	index.records = rest
+
+	// Every address has couples and every song a record, so offsets only
+	// increase, from the start of their section to its end
+	if !increasing(index.addresses, 4, math.MaxUint32) || !increasing(index.songIDs, 4, math.MaxUint32) ||
+		!increasing(index.postings, 8, couples) || !increasing(index.songs, 8, uint64(len(rest))) {
+		return nil, errCorruptIndex
+	}
+	if le.Uint64(index.postings) != 0 || le.Uint64(index.postings[addresses*8:]) != couples ||
+		le.Uint64(index.songs) != 0 || le.Uint64(index.songs[songs*8:]) != uint64(len(rest)) {
+		return nil, errCorruptIndex
+	}
+	return index, nil
+}
+
+// increasing reports whether the integers of size bytes of section only
+// increase, up to limit.
+func increasing(section []byte, size int, limit uint64) bool {
+	read := binary.LittleEndian.Uint64
+	if size == 4 {
+		read = func(b []byte) uint64 { return uint64(binary.LittleEndian.Uint32(b)) }
+	}
+
+	for i := 0; i < len(section); i += size {
+		n := read(section[i:])
+		if n > limit || (i > 0 && n <= read(section[i-size:])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close unmaps the file. The index mustn't be used after.
+func (index *FingerprintIndex) Close() error {
+	return index.release()
+}
+
+// Version returns the fingerprint version of the index.
+func (index *FingerprintIndex) Version() string {
+	return index.version
+}
+
+// search returns the position of n among the sorted uint32s of section,
+// and whether it is there.
+func search(section []byte, n uint32) (int, bool) {
+	count := len(section) / 4
+	i := sort.Search(count, func(i int) bool {
+		return binary.LittleEndian.Uint32(section[i*4:]) >= n
+	})
+	return i, i < count && binary.LittleEndian.Uint32(section[i*4:]) == n
+}
+
+// GetCouples returns the couples of the index at addresses, which must be
+// of its version, like DBClient.GetCouples.
+func (index *FingerprintIndex) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	if version != index.version {
+		return nil, fmt.Errorf("index holds fingerprints of version %s, not %s", index.version, version)
+	}
+
+	le := binary.LittleEndian
+	couples := make(map[uint32][]models.Couple)
+	for _, address := range addresses {
+		i, ok := search(index.addresses, address)
+		if !ok {
+			continue
+		}
+		start, end := le.Uint64(index.postings[i*8:]), le.Uint64(index.postings[(i+1)*8:])
+		found := make([]models.Couple, 0, end-start)
+		for c := start; c < end; c++ {
+			found = append(found, models.Couple{
+				AnchorTimeMs: le.Uint32(index.couples[c*8:]),
+				SongID:       le.Uint32(index.couples[c*8+4:]),
+			})
+		}
+		couples[address] = found
+	}
+	return couples, nil
+}
+
+// TotalSongs returns the number of songs in the index.
+func (index *FingerprintIndex) TotalSongs(ctx context.Context) (int, error) {
+	return len(index.songIDs) / 4, nil
+}
+
+// GetSongByID returns a song of the index as it was when the index was
+// built.
+func (index *FingerprintIndex) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	i, ok := search(index.songIDs, songID)
+	if !ok {
+		return Song{}, false, nil
+	}
+
+	le := binary.LittleEndian
+	start, end := le.Uint64(index.songs[i*8:]), le.Uint64(index.songs[(i+1)*8:])
+	var song Song
+	if err := json.Unmarshal(index.records[start:end], &song); err != nil {
+		return Song{}, false, fmt.Errorf("error decoding song %d: %v", songID, err)
+	}
+	return song, true, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"song-recognition/models"
+	"testing"
+)
+
+// writeTestIndex writes the index of two songs and returns its bytes.
+func writeTestIndex(t *testing.T) []byte {
+	t.Helper()
+	ctx := context.Background()
+	client := NewMemoryClient()
+
+	for i, addresses := range [][]uint32{{1, 2, 3}, {2, 4}} {
+		songID, err := client.RegisterSong(ctx, string(rune('a'+i)), "artist", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints := map[uint32]models.Couple{}
+		for _, address := range addresses {
+			fingerprints[address] = models.Couple{AnchorTimeMs: address * 10, SongID: songID}
+		}
+		if err := client.StoreFingerprints(ctx, fingerprints, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "fingerprints.idx")
+	if _, _, err := WriteFingerprintIndex(ctx, client, "test", path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseFingerprintIndexRejectsBadOffsets(t *testing.T) {
+	data := writeTestIndex(t)
+	if _, err := parseFingerprintIndex(data); err != nil {
+		t.Fatalf("valid index rejected: %v", err)
+	}
+
+	// Sections of the index written: 4 addresses, 5 postings, 5 couples,
+	// 2 song IDs and 3 song offsets
+	const header = len(fingerprintIndexMagic) + 16
+	versionEnd := header + len("test")
+	postings := versionEnd + 4*4
+	songIDs := postings + 5*8 + 5*8
+	songOffsets := songIDs + 2*4
+
+	le := binary.LittleEndian
+	tests := []struct {
+		name    string
+		corrupt func(data []byte)
+	}{
+		{"truncated", nil},
+		{"addresses out of order", func(data []byte) { le.PutUint32(data[versionEnd:], 9) }},
+		{"postings decreasing", func(data []byte) { le.PutUint64(data[postings+8:], 4) }},
+		{"posting past the couples", func(data []byte) { le.PutUint64(data[postings+3*8:], 99) }},
+		{"first posting not at the start", func(data []byte) { le.PutUint64(data[postings:], 1) }},
+		{"song IDs out of order", func(data []byte) { le.PutUint32(data[songIDs:], math.MaxUint32) }},
+		{"song offsets decreasing", func(data []byte) { le.PutUint64(data[songOffsets+8:], 0) }},
+		{"song offset past the records", func(data []byte) { le.PutUint64(data[songOffsets+8:], 1<<40) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := append([]byte(nil), data...)
+			if tt.corrupt == nil {
+				corrupted = corrupted[:len(corrupted)-1]
+			} else {
+				tt.corrupt(corrupted)
+			}
+			if _, err := parseFingerprintIndex(corrupted); err == nil {
+				t.Error("corrupted index accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// createLSHBandsTable creates the table of the LSH bands of songs. Its
+// statements run unchanged on SQLite and PostgreSQL, which store the bands
+// as signed 64-bit integers.
+func createLSHBandsTable(tx *sql.Tx) error {
+	statements := []struct{ name, query string }{
+		{"lsh bands table", `
+        CREATE TABLE IF NOT EXISTS lsh_bands (
+            band BIGINT NOT NULL,
+            songID BIGINT NOT NULL,
+            version TEXT NOT NULL
+        )`},
+		{"lsh bands index", "CREATE INDEX IF NOT EXISTS idx_lsh_bands_band ON lsh_bands (version, band)"},
+		{"lsh bands songID index", "CREATE INDEX IF NOT EXISTS idx_lsh_bands_songID ON lsh_bands (songID)"},
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query); err != nil {
+			return fmt.Errorf("error creating %s: %s", statement.name, err)
+		}
+	}
+	return nil
+}
+
+// topCandidates returns the songs of counts with the most bands in common
+// with a clip, at most limit of them, most first. Ties go to the lower ID,
+// so lookups are repeatable.
+func topCandidates(counts map[uint32]int, limit int) []uint32 {
+	songIDs := make([]uint32, 0, len(counts))
+	for songID := range counts {
+		songIDs = append(songIDs, songID)
+	}
+	sort.Slice(songIDs, func(i, j int) bool {
+		if counts[songIDs[i]] != counts[songIDs[j]] {
+			return counts[songIDs[i]] > counts[songIDs[j]]
+		}
+		return songIDs[i] < songIDs[j]
+	})
+
+	if limit > 0 && len(songIDs) > limit {
+		songIDs = songIDs[:limit]
+	}
+	return songIDs
+}
@@ -0,0 +1,788 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryClient keeps the library in memory, for programs embedding the
+// recognizer and for tests, which then need no database at all. Clients of
+// NewMemoryClient each have a library of their own; the "memory" backend
+// shares one across the process, lost when it exits.
+type MemoryClient struct {
+	*memoryStore
+}
+
+type memoryStore struct {
+	mu           sync.RWMutex
+	songs        map[uint32]Song
+	songKeys     map[uint32]string                     // song ID -> key
+	fingerprints map[string]map[uint32][]models.Couple // version -> address -> couples
+	idempotency  map[string]IdempotencyRecord
+	jobs         map[string]Job
+	recognitions []Recognition
+	detections   []Detection
+	melodies     map[uint32][]byte
+	waveforms    map[uint32][]byte
+	lshBands     map[uint32]map[string][]uint64 // song ID -> version -> bands
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		songs:        map[uint32]Song{},
+		songKeys:     map[uint32]string{},
+		fingerprints: map[string]map[uint32][]models.Couple{},
+		idempotency:  map[string]IdempotencyRecord{},
+		jobs:         map[string]Job{},
+		melodies:     map[uint32][]byte{},
+		waveforms:    map[uint32][]byte{},
+		lshBands:     map[uint32]map[string][]uint64{},
+	}
+}
+
+// NewMemoryClient returns a client of a new, empty library.
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{newMemoryStore()}
+}
+
+var processMemory = newMemoryStore()
+
+func newProcessMemory() (DBClient, error) {
+	return &MemoryClient{processMemory}, nil
+}
+
+// Close does nothing: the library lives as long as the client.
+func (db *MemoryClient) Close() error {
+	return nil
+}
+
+func (db *MemoryClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.putFingerprints(fingerprints, version)
+	return nil
+}
+
+// putFingerprints adds the couples of fingerprints to their address,
+// skipping those already there, as the primary key of the SQL tables does.
+func (db *MemoryClient) putFingerprints(fingerprints map[uint32]models.Couple, version string) {
+	addresses, ok := db.fingerprints[version]
+	if !ok {
+		addresses = map[uint32][]models.Couple{}
+		db.fingerprints[version] = addresses
+	}
+	for address, couple := range fingerprints {
+		if !slices.Contains(addresses[address], couple) {
+			addresses[address] = append(addresses[address], couple)
+		}
+	}
+}
+
+// deleteFingerprints removes every couple of songID.
+func (db *MemoryClient) deleteFingerprints(songID uint32) {
+	for _, addresses := range db.fingerprints {
+		for address, couples := range addresses {
+			kept := slices.DeleteFunc(couples, func(couple models.Couple) bool { return couple.SongID == songID })
+			if len(kept) == 0 {
+				delete(addresses, address)
+			} else {
+				addresses[address] = kept
+			}
+		}
+	}
+}
+
+// GetCouples returns the couples stored under each of addresses with the
+// given fingerprint version.
+func (db *MemoryClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	couples := make(map[uint32][]models.Couple)
+	for _, address := range addresses {
+		if stored, ok := db.fingerprints[version][address]; ok {
+			couples[address] = slices.Clone(stored)
+		}
+	}
+	return couples, nil
+}
+
+func (db *MemoryClient) TotalSongs(ctx context.Context) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return len(db.songs), nil
+}
+
+func (db *MemoryClient) RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	songKey := utils.GenerateSongKey(songTitle, songArtist)
+	for _, key := range db.songKeys {
+		if key == songKey {
+			return 0, fmt.Errorf("song with ytID or key already exists: %s", songKey)
+		}
+	}
+
+	songID := utils.GenerateUniqueID()
+	db.songs[songID] = Song{ID: songID, Title: songTitle, Artist: songArtist, YouTubeID: ytID, Checksum: checksum}
+	db.songKeys[songID] = songKey
+	return songID, nil
+}
+
+// GetSong retrieves a song by filter key
+func (db *MemoryClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
+	var match func(song Song) bool
+	switch filterKey {
+	case "id":
+		match = func(song Song) bool { return song.ID == value }
+	case "ytID":
+		match = func(song Song) bool { return song.YouTubeID == value }
+	case "key":
+		match = func(song Song) bool { return db.songKeys[song.ID] == value }
+	case "checksum":
+		match = func(song Song) bool { return song.Checksum == value }
+	default:
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if songID, ok := value.(uint32); ok && filterKey == "id" {
+		song, exists := db.songs[songID]
+		return copySong(song), exists, nil
+	}
+	for _, song := range db.sortedSongs() {
+		if match(song) {
+			return copySong(song), true, nil
+		}
+	}
+	return Song{}, false, nil
+}
+
+// sortedSongs returns the songs in ID order, the order SQLite lists them in.
+func (db *MemoryClient) sortedSongs() []Song {
+	songs := make([]Song, 0, len(db.songs))
+	for _, song := range db.songs {
+		songs = append(songs, song)
+	}
+	sort.Slice(songs, func(i, j int) bool { return songs[i].ID < songs[j].ID })
+	return songs
+}
+
+// copySong returns song with tags the caller can change.
+func copySong(song Song) Song {
+	song.Tags = maps.Clone(song.Tags)
+	return song
+}
+
+func (db *MemoryClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *MemoryClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *MemoryClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+func (db *MemoryClient) GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error) {
+	return db.GetSong(ctx, "checksum", checksum)
+}
+
+// updateSong applies update to a stored song. Songs that don't exist are
+// left alone, as an UPDATE would.
+func (db *MemoryClient) updateSong(songID uint32, update func(song *Song)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if song, ok := db.songs[songID]; ok {
+		update(&song)
+		db.songs[songID] = song
+	}
+	return nil
+}
+
+// SetSongTempo stores the tempo of a song in beats per minute.
+func (db *MemoryClient) SetSongTempo(ctx context.Context, songID uint32, bpm float64) error {
+	return db.updateSong(songID, func(song *Song) { song.Tempo = bpm })
+}
+
+// SetSongMusicalKey stores the key a song is in.
+func (db *MemoryClient) SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error {
+	return db.updateSong(songID, func(song *Song) { song.MusicalKey = musicalKey })
+}
+
+// SetSongTags replaces the tags of a song.
+func (db *MemoryClient) SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error {
+	return db.updateSong(songID, func(song *Song) { song.Tags = maps.Clone(tags) })
+}
+
+// SetSongMetadata stores what is known of a song beyond its audio.
+func (db *MemoryClient) SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error {
+	return db.updateSong(songID, func(song *Song) {
+		for _, field := range []struct {
+			value string
+			to    *string
+		}{
+			{metadata.Title, &song.Title},
+			{metadata.Artist, &song.Artist},
+			{metadata.Album, &song.Album},
+			{metadata.MusicBrainzID, &song.MusicBrainzID},
+			{metadata.MusicBrainzReleaseID, &song.MusicBrainzReleaseID},
+			{metadata.StoreURL, &song.StoreURL},
+		} {
+			if field.value != "" {
+				*field.to = field.value
+			}
+		}
+		if metadata.ReleaseYear != 0 {
+			song.ReleaseYear = metadata.ReleaseYear
+		}
+	})
+}
+
+// SetSongAudio stores what probing a song's audio measured.
+func (db *MemoryClient) SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error {
+	return db.updateSong(songID, func(song *Song) {
+		song.Codec, song.Duration, song.Bitrate = audio.Codec, audio.Duration, audio.Bitrate
+	})
+}
+
+// SetSongFile stores the key a song's WAV file is stored under.
+func (db *MemoryClient) SetSongFile(ctx context.Context, songID uint32, fileKey string) error {
+	return db.updateSong(songID, func(song *Song) { song.FileKey = fileKey })
+}
+
+// SetSongPreview stores the key a song's preview clip is stored under.
+func (db *MemoryClient) SetSongPreview(ctx context.Context, songID uint32, previewKey string) error {
+	return db.updateSong(songID, func(song *Song) { song.PreviewKey = previewKey })
+}
+
+// DeleteSongByID deletes a song by ID
+func (db *MemoryClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.songs, songID)
+	delete(db.songKeys, songID)
+	return nil
+}
+
+// DeleteFingerprintsBySongID deletes every fingerprint of a song
+func (db *MemoryClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.deleteFingerprints(songID)
+	return nil
+}
+
+// ReplaceFingerprints swaps the fingerprints of a song for new ones under
+// one lock, so lookups see either the old or the new fingerprints.
+func (db *MemoryClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.deleteFingerprints(songID)
+	db.putFingerprints(fingerprints, version)
+	return nil
+}
+
+// DeleteCollection empties a collection.
+func (db *MemoryClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch collectionName {
+	case "songs":
+		db.songs = map[uint32]Song{}
+		db.songKeys = map[uint32]string{}
+	case "fingerprints":
+		db.fingerprints = map[string]map[uint32][]models.Couple{}
+	case "idempotency_keys":
+		db.idempotency = map[string]IdempotencyRecord{}
+	case "jobs":
+		db.jobs = map[string]Job{}
+	case "recognitions":
+		db.recognitions = nil
+	case "detections":
+		db.detections = nil
+	case "melodies":
+		db.melodies = map[uint32][]byte{}
+	case "waveforms":
+		db.waveforms = map[uint32][]byte{}
+	case "lsh_bands":
+		db.lshBands = map[uint32]map[string][]uint64{}
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey records key as pending. If the key was already used,
+// its existing record is returned and claimed is false.
+func (db *MemoryClient) ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if record, ok := db.idempotency[key]; ok {
+		record.Response = bytes.Clone(record.Response)
+		return record, false, nil
+	}
+	record := IdempotencyRecord{Key: key, Status: IdempotencyPending, CreatedAt: time.Now()}
+	db.idempotency[key] = record
+	return record, true, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request made with key.
+func (db *MemoryClient) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if record, ok := db.idempotency[key]; ok {
+		record.Status = IdempotencyDone
+		record.Response = bytes.Clone(response)
+		db.idempotency[key] = record
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried.
+func (db *MemoryClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.idempotency, key)
+	return nil
+}
+
+// copyJob returns job with payload and result the caller can change.
+func copyJob(job Job) Job {
+	job.Payload = bytes.Clone(job.Payload)
+	job.Result = bytes.Clone(job.Result)
+	return job
+}
+
+func (db *MemoryClient) EnqueueJob(ctx context.Context, job Job) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.jobs[job.ID]; ok {
+		return fmt.Errorf("failed to enqueue job: job %s already exists", job.ID)
+	}
+	db.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+func (db *MemoryClient) GetJob(ctx context.Context, jobID string) (Job, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	job, ok := db.jobs[jobID]
+	return copyJob(job), ok, nil
+}
+
+// ClaimNextJob marks the oldest queued job that is due as processing and
+// returns it.
+func (db *MemoryClient) ClaimNextJob(ctx context.Context) (Job, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	var next Job
+	var found bool
+	for _, job := range db.jobs {
+		if job.Status != JobQueued || job.NextRunAt.After(now) {
+			continue
+		}
+		if !found || job.CreatedAt.Before(next.CreatedAt) {
+			next, found = job, true
+		}
+	}
+	if !found {
+		return Job{}, false, nil
+	}
+
+	next.Status = JobProcessing
+	next.UpdatedAt = now
+	db.jobs[next.ID] = next
+	return copyJob(next), true, nil
+}
+
+func (db *MemoryClient) UpdateJob(ctx context.Context, job Job) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.jobs[job.ID]
+	if !ok {
+		return nil
+	}
+	stored.Status, stored.Result, stored.Error = job.Status, bytes.Clone(job.Result), job.Error
+	stored.Attempts, stored.NextRunAt, stored.UpdatedAt = job.Attempts, job.NextRunAt, job.UpdatedAt
+	db.jobs[job.ID] = stored
+	return nil
+}
+
+// RequeueProcessingJobs puts jobs left processing by a stopped worker back
+// in the queue.
+func (db *MemoryClient) RequeueProcessingJobs(ctx context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var requeued int
+	now := time.Now()
+	for id, job := range db.jobs {
+		if job.Status == JobProcessing {
+			job.Status = JobQueued
+			job.UpdatedAt = now
+			db.jobs[id] = job
+			requeued++
+		}
+	}
+	return requeued, nil
+}
+
+// ListJobs returns up to limit jobs with the given status, oldest first.
+func (db *MemoryClient) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var jobs []Job
+	for _, job := range db.jobs {
+		if job.Status == status {
+			jobs = append(jobs, copyJob(job))
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// DeleteJobs deletes every job with the given status.
+func (db *MemoryClient) DeleteJobs(ctx context.Context, status string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var deleted int
+	for id, job := range db.jobs {
+		if job.Status == status {
+			delete(db.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ListSongs returns up to limit songs ordered by ID, skipping the first
+// offset.
+func (db *MemoryClient) ListSongs(ctx context.Context, offset, limit int) ([]Song, error) {
+	return db.listSongs(func(Song) bool { return true }, offset, limit), nil
+}
+
+// ListSongsByMusicalKey is ListSongs for the songs in musicalKey.
+func (db *MemoryClient) ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error) {
+	return db.listSongs(func(song Song) bool { return song.MusicalKey == musicalKey }, offset, limit), nil
+}
+
+func (db *MemoryClient) listSongs(match func(Song) bool, offset, limit int) []Song {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var songs []Song
+	for _, song := range db.sortedSongs() {
+		if len(songs) == limit {
+			break
+		}
+		if !match(song) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		songs = append(songs, copySong(song))
+	}
+	return songs
+}
+
+func (db *MemoryClient) TotalFingerprints(ctx context.Context) (int, error) {
+	counts, _ := db.CountFingerprintsByVersion(ctx)
+
+	var total int
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
+
+// CountFingerprints returns the number of fingerprints stored for a song.
+func (db *MemoryClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var count int
+	for _, addresses := range db.fingerprints {
+		for _, couples := range addresses {
+			for _, couple := range couples {
+				if couple.SongID == songID {
+					count++
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// ListFingerprints returns every fingerprint stored for a song.
+func (db *MemoryClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var fingerprints []Fingerprint
+	for version, addresses := range db.fingerprints {
+		for address, couples := range addresses {
+			for _, couple := range couples {
+				if couple.SongID == songID {
+					fingerprints = append(fingerprints, Fingerprint{address, couple.AnchorTimeMs, version})
+				}
+			}
+		}
+	}
+	return fingerprints, nil
+}
+
+// CommonAddresses returns the addresses of a fingerprint version that more
+// than maxSongs songs have fingerprints at.
+func (db *MemoryClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var addresses []uint32
+	for address, couples := range db.fingerprints[version] {
+		if len(couples) <= maxSongs {
+			continue
+		}
+		songs := map[uint32]bool{}
+		for _, couple := range couples {
+			songs[couple.SongID] = true
+		}
+		if len(songs) > maxSongs {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// EachAddress calls fn with every address of a fingerprint version.
+func (db *MemoryClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for address := range db.fingerprints[version] {
+		fn(address)
+	}
+	return nil
+}
+
+// DeleteAddresses deletes the fingerprints of a version stored at
+// addresses, and returns how many there were.
+func (db *MemoryClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var deleted int
+	for _, address := range addresses {
+		deleted += len(db.fingerprints[version][address])
+		delete(db.fingerprints[version], address)
+	}
+	return deleted, nil
+}
+
+// CountFingerprintsByVersion returns the number of fingerprints stored of
+// every fingerprint version.
+func (db *MemoryClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for version, addresses := range db.fingerprints {
+		for _, couples := range addresses {
+			counts[version] += len(couples)
+		}
+	}
+	return counts, nil
+}
+
+func (db *MemoryClient) RecordRecognition(ctx context.Context, recognition Recognition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.recognitions = append(db.recognitions, recognition)
+	return nil
+}
+
+// ListRecognitions returns the latest limit recognitions, newest first.
+func (db *MemoryClient) ListRecognitions(ctx context.Context, limit int) ([]Recognition, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var recognitions []Recognition
+	for i := len(db.recognitions) - 1; i >= 0 && len(recognitions) < limit; i-- {
+		recognitions = append(recognitions, db.recognitions[i])
+	}
+	return recognitions, nil
+}
+
+// CountRecognitions returns how many times a song has been recognized.
+func (db *MemoryClient) CountRecognitions(ctx context.Context, songID uint32) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var count int
+	for _, recognition := range db.recognitions {
+		if recognition.SongID == songID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *MemoryClient) RecordDetection(ctx context.Context, detection Detection) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	detection.DetectedAt = detection.DetectedAt.UTC()
+	db.detections = append(db.detections, detection)
+	return nil
+}
+
+// ListDetections returns the latest limit detections on stream, or on all
+// streams if stream is empty, newest first.
+func (db *MemoryClient) ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var detections []Detection
+	for i := len(db.detections) - 1; i >= 0 && len(detections) < limit; i-- {
+		if stream == "" || db.detections[i].Stream == stream {
+			detections = append(detections, db.detections[i])
+		}
+	}
+	return detections, nil
+}
+
+// StoreMelody saves the pitch contour of a song, replacing any it had.
+func (db *MemoryClient) StoreMelody(ctx context.Context, songID uint32, contour []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.melodies[songID] = bytes.Clone(contour)
+	return nil
+}
+
+// ListMelodies returns the pitch contour of every song that has one, keyed
+// by song ID.
+func (db *MemoryClient) ListMelodies(ctx context.Context) (map[uint32][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	melodies := make(map[uint32][]byte, len(db.melodies))
+	for songID, contour := range db.melodies {
+		melodies[songID] = bytes.Clone(contour)
+	}
+	return melodies, nil
+}
+
+func (db *MemoryClient) DeleteMelody(ctx context.Context, songID uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.melodies, songID)
+	return nil
+}
+
+// StoreWaveform saves the amplitude envelope of a song, replacing any it
+// had.
+func (db *MemoryClient) StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.waveforms[songID] = bytes.Clone(waveform)
+	return nil
+}
+
+// GetWaveform returns the amplitude envelope of a song, if it has one.
+func (db *MemoryClient) GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	waveform, ok := db.waveforms[songID]
+	return bytes.Clone(waveform), ok, nil
+}
+
+func (db *MemoryClient) DeleteWaveform(ctx context.Context, songID uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.waveforms, songID)
+	return nil
+}
+
+// StoreLSHBands saves the LSH bands of a song's fingerprints of a version,
+// replacing those it had.
+func (db *MemoryClient) StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.lshBands[songID] == nil {
+		db.lshBands[songID] = map[string][]uint64{}
+	}
+	db.lshBands[songID][version] = slices.Clone(bands)
+	return nil
+}
+
+// LSHCandidates returns the songs with the most of bands among their LSH
+// bands of a version, at most limit of them, most first. A limit of zero
+// returns them all.
+func (db *MemoryClient) LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	wanted := make(map[uint64]bool, len(bands))
+	for _, band := range bands {
+		wanted[band] = true
+	}
+
+	counts := map[uint32]int{}
+	for songID, versions := range db.lshBands {
+		for _, band := range versions[version] {
+			if wanted[band] {
+				counts[songID]++
+			}
+		}
+	}
+	return topCandidates(counts, limit), nil
+}
+
+// DeleteLSHBands deletes every LSH band of a song.
+func (db *MemoryClient) DeleteLSHBands(ctx context.Context, songID uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.lshBands, songID)
+	return nil
+}
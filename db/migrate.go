@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"song-recognition/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration is one versioned change of a SQL schema. Up applies it and
+// Down reverts it, each in a transaction of its own together with the
+// record of the schema's version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus is a migration of a database and when it was applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // zero if the migration isn't applied
+}
+
+// Migrator is implemented by the clients of databases with a versioned
+// schema. The others store documents and have no schema to migrate.
+type Migrator interface {
+	// Migrations returns every migration known or applied, oldest first.
+	Migrations(ctx context.Context) ([]MigrationStatus, error)
+	// Migrate applies or reverts migrations until the schema is at
+	// version.
+	Migrate(ctx context.Context, version int) error
+}
+
+// AutoMigrate makes SQL clients apply the migrations their database lacks
+// when they connect. Without it, schemas only change with the migrate
+// command.
+var AutoMigrate = utils.GetEnv("DB_AUTO_MIGRATE", "true") != "false"
+
+// schemaMigrationsTable records the applied migrations. Its statements run
+// unchanged on SQLite and PostgreSQL.
+const schemaMigrationsTable = `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        appliedAt BIGINT NOT NULL
+    )`
+
+// migrateMu keeps the clients of this process from migrating at once.
+// Every migration also checks, in its transaction, that it isn't applied
+// yet, which covers other processes.
+var migrateMu sync.Mutex
+
+// latestVersion returns the version of the last of migrations.
+func latestVersion(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// schemaVersion returns the version of the last migration applied to db.
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	if _, err := db.ExecContext(ctx, schemaMigrationsTable); err != nil {
+		return 0, fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return int(version.Int64), nil
+}
+
+// migrate applies or reverts migrations, which are sorted by version, until
+// the schema of db is at version.
+func migrate(ctx context.Context, db *sql.DB, migrations []Migration, version int) error {
+	if version < 0 || version > latestVersion(migrations) {
+		return fmt.Errorf("unknown schema version %d, the latest is %d", version, latestVersion(migrations))
+	}
+
+	migrateMu.Lock()
+	defer migrateMu.Unlock()
+
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current || migration.Version > version {
+			continue
+		}
+		if err := applyMigration(ctx, db, migration, true); err != nil {
+			return err
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version > current || migration.Version <= version {
+			continue
+		}
+		if err := applyMigration(ctx, db, migration, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration runs migration up or down in a transaction that also
+// records it, unless another process already did.
+func applyMigration(ctx context.Context, db *sql.DB, migration Migration, up bool) error {
+	direction := "applying"
+	if !up {
+		direction = "reverting"
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	var applied int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", migration.Version).Scan(&applied)
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if (applied == 1) == up {
+		return nil
+	}
+
+	if up {
+		err = migration.Up(tx)
+	} else {
+		err = migration.Down(tx)
+	}
+	if err != nil {
+		return fmt.Errorf("error %s migration %d (%s): %v", direction, migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, appliedAt) VALUES ($1, $2, $3)",
+			migration.Version, migration.Name, time.Now().Unix())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %v", migration.Version, err)
+	}
+
+	return tx.Commit()
+}
+
+// migrationStatus returns migrations, and those applied to db that this
+// build doesn't know, with when they were applied.
+func migrationStatus(ctx context.Context, db *sql.DB, migrations []Migration) ([]MigrationStatus, error) {
+	if _, err := schemaVersion(ctx, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+	defer rows.Close()
+
+	statuses := map[int]MigrationStatus{}
+	for _, migration := range migrations {
+		statuses[migration.Version] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+	}
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt int64
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning migration: %v", err)
+		}
+		status.AppliedAt = time.Unix(appliedAt, 0)
+		statuses[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+
+	list := make([]MigrationStatus, 0, len(statuses))
+	for _, status := range statuses {
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// dropTables returns the Down of a migration creating tables.
+func dropTables(tables ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, table := range tables {
+			if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
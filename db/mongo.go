@@ -7,15 +7,17 @@ import (
 	"song-recognition/models"
 	"song-recognition/utils"
 	"strings"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MongoClient struct {
 	client *mongo.Client
+	uri    string
 }
 
 func NewMongoClient(uri string) (*MongoClient, error) {
@@ -24,7 +26,11 @@ func NewMongoClient(uri string) (*MongoClient, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to MongoDB: %s", err)
 	}
-	return &MongoClient{client: client}, nil
+	if err := prepareFingerprints(context.Background(), client, uri); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return &MongoClient{client: client, uri: uri}, nil
 }
 
 func (db *MongoClient) Close() error {
@@ -34,74 +40,173 @@ func (db *MongoClient) Close() error {
 	return nil
 }
 
-func (db *MongoClient) StoreFingerprints(fingerprints map[uint32]models.Couple) error {
-	collection := db.client.Database("song-recognition").Collection("fingerprints")
+// mongoCouple is a document of the fingerprints collection: one couple
+// stored under an address. Generation tells the couples ReplaceFingerprints
+// stores apart from those it replaces.
+type mongoCouple struct {
+	Address      uint32 `bson:"address"`
+	AnchorTimeMs uint32 `bson:"anchorTimeMs"`
+	SongID       uint32 `bson:"songID"`
+	Version      string `bson:"version"`
+	Generation   int64  `bson:"generation,omitempty"`
+}
 
-	for address, couple := range fingerprints {
-		filter := bson.M{"_id": address}
-		update := bson.M{
-			"$push": bson.M{
-				"couples": bson.M{
-					"anchorTimeMs": couple.AnchorTimeMs,
-					"songID":       couple.SongID,
-				},
-			},
-		}
-		opts := options.Update().SetUpsert(true)
-
-		_, err := collection.UpdateOne(context.Background(), filter, update, opts)
-		if err != nil {
-			return fmt.Errorf("error upserting document: %s", err)
+// mongoFingerprintIndexes index the fingerprints collection. The first,
+// unique, index answers GetCouples from the index alone; the second finds
+// the couples of a song to delete or count them.
+var mongoFingerprintIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "address", Value: 1}, {Key: "version", Value: 1},
+			{Key: "songID", Value: 1}, {Key: "anchorTimeMs", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	},
+	{Keys: bson.D{{Key: "songID", Value: 1}}},
+}
+
+var (
+	mongoFingerprintsMu    sync.Mutex
+	mongoFingerprintsReady = map[string]bool{}
+)
+
+// prepareFingerprints creates the indexes of the fingerprints collection of
+// the server at uri and moves the fingerprints stored in the layout that
+// came before, one document per address holding an array of couples, to
+// one document per couple. It does so once per process and server.
+func prepareFingerprints(ctx context.Context, client *mongo.Client, uri string) error {
+	mongoFingerprintsMu.Lock()
+	defer mongoFingerprintsMu.Unlock()
+	if mongoFingerprintsReady[uri] {
+		return nil
+	}
+
+	collection := client.Database("song-recognition").Collection("fingerprints")
+	if _, err := collection.Indexes().CreateMany(ctx, mongoFingerprintIndexes); err != nil {
+		return fmt.Errorf("failed to create fingerprint indexes: %v", err)
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"couples": bson.M{"$exists": true}})
+	if err != nil {
+		return fmt.Errorf("failed to read legacy fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var legacy struct {
+			Address uint32 `bson:"_id"`
+			Couples []struct {
+				AnchorTimeMs uint32 `bson:"anchorTimeMs"`
+				SongID       uint32 `bson:"songID"`
+				Version      string `bson:"version"`
+			} `bson:"couples"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return fmt.Errorf("failed to decode legacy fingerprints: %v", err)
+		}
+
+		documents := make([]interface{}, 0, len(legacy.Couples))
+		for _, couple := range legacy.Couples {
+			// Couples stored before versions were have none
+			version := couple.Version
+			if version == "" {
+				version = LegacyFingerprintVersion
+			}
+			documents = append(documents, mongoCouple{
+				Address: legacy.Address, AnchorTimeMs: couple.AnchorTimeMs, SongID: couple.SongID, Version: version,
+			})
+		}
+		if err := insertCouples(ctx, collection, documents); err != nil {
+			return err
+		}
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": legacy.Address}); err != nil {
+			return fmt.Errorf("failed to delete legacy fingerprints: %v", err)
 		}
 	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read legacy fingerprints: %v", err)
+	}
 
+	mongoFingerprintsReady[uri] = true
 	return nil
 }
 
-func (db *MongoClient) GetCouples(addresses []uint32) (map[uint32][]models.Couple, error) {
-	collection := db.client.Database("song-recognition").Collection("fingerprints")
+func (db *MongoClient) fingerprintsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("fingerprints")
+}
 
-	couples := make(map[uint32][]models.Couple)
+// StoreFingerprints inserts the fingerprints in unordered batches, leaving
+// alone the couples that are already stored.
+func (db *MongoClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	documents := make([]interface{}, 0, len(fingerprints))
+	for address, couple := range fingerprints {
+		documents = append(documents, mongoCouple{
+			Address: address, AnchorTimeMs: couple.AnchorTimeMs, SongID: couple.SongID, Version: version,
+		})
+	}
 
-	for _, address := range addresses {
-		// Find the document corresponding to the address
-		var result bson.M
-		err := collection.FindOne(context.Background(), bson.M{"_id": address}).Decode(&result)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				continue
+	return insertCouples(ctx, db.fingerprintsCollection(), documents)
+}
+
+// insertCouples inserts documents into the fingerprints collection,
+// ignoring those the unique index rejects as already stored.
+func insertCouples(ctx context.Context, collection *mongo.Collection, documents []interface{}) error {
+	if len(documents) == 0 {
+		return nil
+	}
+
+	_, err := collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code != 11000 {
+				return fmt.Errorf("error inserting fingerprints: %v", err)
 			}
-			return nil, fmt.Errorf("error retrieving document for address %d: %s", address, err)
 		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting fingerprints: %v", err)
+	}
+	return nil
+}
 
-		// Extract couples from the document and append them to the couples map
-		var docCouples []models.Couple
-		couplesList, ok := result["couples"].(primitive.A)
-		if !ok {
-			return nil, fmt.Errorf("couples field in document for address %d is not valid", address)
-		}
+// GetCouples returns the couples stored under each of addresses with the
+// given fingerprint version, in one query covered by the address index.
+func (db *MongoClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	couples := make(map[uint32][]models.Couple)
+	if len(addresses) == 0 {
+		return couples, nil
+	}
 
-		for _, item := range couplesList {
-			itemMap, ok := item.(primitive.M)
-			if !ok {
-				return nil, fmt.Errorf("invalid couple format in document for address %d", address)
-			}
+	filter := bson.M{"address": bson.M{"$in": addresses}, "version": version}
+	projection := bson.M{"_id": 0, "address": 1, "songID": 1, "anchorTimeMs": 1}
+	cursor, err := db.fingerprintsCollection().Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving couples: %v", err)
+	}
+	defer cursor.Close(ctx)
 
-			couple := models.Couple{
-				AnchorTimeMs: uint32(itemMap["anchorTimeMs"].(int64)),
-				SongID:       uint32(itemMap["songID"].(int64)),
-			}
-			docCouples = append(docCouples, couple)
+	for cursor.Next(ctx) {
+		var document mongoCouple
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("error decoding couple: %v", err)
 		}
-		couples[address] = docCouples
+		couples[document.Address] = append(couples[document.Address], models.Couple{
+			AnchorTimeMs: document.AnchorTimeMs,
+			SongID:       document.SongID,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving couples: %v", err)
 	}
 
 	return couples, nil
 }
 
-func (db *MongoClient) TotalSongs() (int, error) {
+func (db *MongoClient) TotalSongs(ctx context.Context) (int, error) {
 	existingSongsCollection := db.client.Database("song-recognition").Collection("songs")
-	total, err := existingSongsCollection.CountDocuments(context.Background(), bson.D{})
+	total, err := existingSongsCollection.CountDocuments(ctx, bson.D{})
 	if err != nil {
 		return 0, err
 	}
@@ -109,23 +214,36 @@ func (db *MongoClient) TotalSongs() (int, error) {
 	return int(total), nil
 }
 
-func (db *MongoClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
+func (db *MongoClient) RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error) {
 	existingSongsCollection := db.client.Database("song-recognition").Collection("songs")
 
 	// Create a compound unique index on ytID and key, if it doesn't already exist
 	indexModel := mongo.IndexModel{
-		Keys:    bson.D{{"ytID", 1}, {"key", 1}},
+		Keys:    bson.D{{Key: "ytID", Value: 1}, {Key: "key", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}
-	_, err := existingSongsCollection.Indexes().CreateOne(context.Background(), indexModel)
+	_, err := existingSongsCollection.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create unique index: %v", err)
 	}
 
+	checksumIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "checksum", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}
+	_, err = existingSongsCollection.Indexes().CreateOne(ctx, checksumIndex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create checksum index: %v", err)
+	}
+
 	// Attempt to insert the song with ytID and key
 	songID := utils.GenerateUniqueID()
 	key := utils.GenerateSongKey(songTitle, songArtist)
-	_, err = existingSongsCollection.InsertOne(context.Background(), bson.M{"_id": songID, "key": key, "ytID": ytID})
+	document := bson.M{"_id": songID, "key": key, "ytID": ytID}
+	if checksum != "" {
+		document["checksum"] = checksum
+	}
+	_, err = existingSongsCollection.InsertOne(ctx, document)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return 0, fmt.Errorf("song with ytID or key already exists: %v", err)
@@ -137,9 +255,9 @@ func (db *MongoClient) RegisterSong(songTitle, songArtist, ytID string) (uint32,
 	return songID, nil
 }
 
-var mongofilterKeys = "_id | ytID | key"
+var mongofilterKeys = "_id | ytID | key | checksum"
 
-func (db *MongoClient) GetSong(filterKey string, value interface{}) (s Song, songExists bool, e error) {
+func (db *MongoClient) GetSong(ctx context.Context, filterKey string, value interface{}) (s Song, songExists bool, e error) {
 	if !strings.Contains(mongofilterKeys, filterKey) {
 		return Song{}, false, errors.New("invalid filter key")
 	}
@@ -149,7 +267,7 @@ func (db *MongoClient) GetSong(filterKey string, value interface{}) (s Song, son
 
 	filter := bson.M{filterKey: value}
 
-	err := songsCollection.FindOne(context.Background(), filter).Decode(&song)
+	err := songsCollection.FindOne(ctx, filter).Decode(&song)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return Song{}, false, nil
@@ -157,33 +275,210 @@ func (db *MongoClient) GetSong(filterKey string, value interface{}) (s Song, son
 		return Song{}, false, fmt.Errorf("failed to retrieve song: %v", err)
 	}
 
+	return songFromDocument(song), true, nil
+}
+
+// songFromDocument converts a document of the songs collection to a Song.
+func songFromDocument(song bson.M) Song {
 	ytID := song["ytID"].(string)
 	title := strings.Split(song["key"].(string), "---")[0]
 	artist := strings.Split(song["key"].(string), "---")[1]
+	checksum, _ := song["checksum"].(string)
+	tempo, _ := song["tempo"].(float64)
+	musicalKey, _ := song["musicalKey"].(string)
+	album, _ := song["album"].(string)
+	mbid, _ := song["mbid"].(string)
+	releaseMbid, _ := song["releaseMbid"].(string)
+	codec, _ := song["codec"].(string)
+	duration, _ := song["duration"].(float64)
+	storeURL, _ := song["storeUrl"].(string)
+	fileKey, _ := song["fileKey"].(string)
+	previewKey, _ := song["previewKey"].(string)
 
-	songInstance := Song{title, artist, ytID}
+	// A canonical title and artist from SetSongMetadata win over the key's
+	if canonical, ok := song["title"].(string); ok && canonical != "" {
+		title = canonical
+	}
+	if canonical, ok := song["artist"].(string); ok && canonical != "" {
+		artist = canonical
+	}
 
-	return songInstance, true, nil
+	var releaseYear int
+	switch year := song["releaseYear"].(type) {
+	case int32:
+		releaseYear = int(year)
+	case int64:
+		releaseYear = int(year)
+	}
+
+	var bitrate int
+	switch rate := song["bitrate"].(type) {
+	case int32:
+		bitrate = int(rate)
+	case int64:
+		bitrate = int(rate)
+	}
+
+	var tags map[string]string
+	switch document := song["tags"].(type) {
+	case bson.M:
+		tags = make(map[string]string, len(document))
+		for name, value := range document {
+			tags[name], _ = value.(string)
+		}
+	case bson.D:
+		tags = make(map[string]string, len(document))
+		for _, element := range document {
+			tags[element.Key], _ = element.Value.(string)
+		}
+	}
+
+	var songID uint32
+	switch id := song["_id"].(type) {
+	case int32:
+		songID = uint32(id)
+	case int64:
+		songID = uint32(id)
+	}
+
+	return Song{ID: songID, Title: title, Artist: artist, YouTubeID: ytID, Checksum: checksum, Tempo: tempo, MusicalKey: musicalKey, Tags: tags,
+		Album: album, ReleaseYear: releaseYear, MusicBrainzID: mbid, MusicBrainzReleaseID: releaseMbid,
+		Codec: codec, Duration: duration, Bitrate: bitrate, StoreURL: storeURL, FileKey: fileKey, PreviewKey: previewKey}
+}
+
+func (db *MongoClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "_id", songID)
+}
+
+func (db *MongoClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *MongoClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+func (db *MongoClient) GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error) {
+	return db.GetSong(ctx, "checksum", checksum)
 }
 
-func (db *MongoClient) GetSongByID(songID uint32) (Song, bool, error) {
-	return db.GetSong("_id", songID)
+// SetSongTempo stores the tempo of a song in beats per minute.
+func (db *MongoClient) SetSongTempo(ctx context.Context, songID uint32, bpm float64) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": bson.M{"tempo": bpm}})
+	if err != nil {
+		return fmt.Errorf("failed to set song tempo: %v", err)
+	}
+
+	return nil
 }
 
-func (db *MongoClient) GetSongByYTID(ytID string) (Song, bool, error) {
-	return db.GetSong("ytID", ytID)
+// SetSongMusicalKey stores the key a song is in.
+func (db *MongoClient) SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": bson.M{"musicalKey": musicalKey}})
+	if err != nil {
+		return fmt.Errorf("failed to set song key: %v", err)
+	}
+
+	return nil
 }
 
-func (db *MongoClient) GetSongByKey(key string) (Song, bool, error) {
-	return db.GetSong("key", key)
+// SetSongTags replaces the tags of a song.
+func (db *MongoClient) SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": bson.M{"tags": tags}})
+	if err != nil {
+		return fmt.Errorf("failed to set song tags: %v", err)
+	}
+
+	return nil
 }
 
-func (db *MongoClient) DeleteSongByID(songID uint32) error {
+// SetSongMetadata stores what is known of a song beyond its audio.
+func (db *MongoClient) SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	fields := bson.M{}
+	if metadata.Title != "" {
+		fields["title"] = metadata.Title
+	}
+	if metadata.Artist != "" {
+		fields["artist"] = metadata.Artist
+	}
+	if metadata.Album != "" {
+		fields["album"] = metadata.Album
+	}
+	if metadata.ReleaseYear != 0 {
+		fields["releaseYear"] = metadata.ReleaseYear
+	}
+	if metadata.MusicBrainzID != "" {
+		fields["mbid"] = metadata.MusicBrainzID
+	}
+	if metadata.MusicBrainzReleaseID != "" {
+		fields["releaseMbid"] = metadata.MusicBrainzReleaseID
+	}
+	if metadata.StoreURL != "" {
+		fields["storeUrl"] = metadata.StoreURL
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": fields})
+	if err != nil {
+		return fmt.Errorf("failed to set song metadata: %v", err)
+	}
+
+	return nil
+}
+
+// SetSongAudio stores what probing a song's audio measured.
+func (db *MongoClient) SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	fields := bson.M{"codec": audio.Codec, "duration": audio.Duration, "bitrate": audio.Bitrate}
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": fields})
+	if err != nil {
+		return fmt.Errorf("failed to set song audio: %v", err)
+	}
+
+	return nil
+}
+
+// SetSongFile stores the key a song's WAV file is stored under.
+func (db *MongoClient) SetSongFile(ctx context.Context, songID uint32, fileKey string) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": bson.M{"fileKey": fileKey}})
+	if err != nil {
+		return fmt.Errorf("failed to set song file: %v", err)
+	}
+
+	return nil
+}
+
+// SetSongPreview stores the key a song's preview clip is stored under.
+func (db *MongoClient) SetSongPreview(ctx context.Context, songID uint32, previewKey string) error {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	_, err := songsCollection.UpdateOne(ctx, bson.M{"_id": songID}, bson.M{"$set": bson.M{"previewKey": previewKey}})
+	if err != nil {
+		return fmt.Errorf("failed to set song preview: %v", err)
+	}
+
+	return nil
+}
+
+func (db *MongoClient) DeleteSongByID(ctx context.Context, songID uint32) error {
 	songsCollection := db.client.Database("song-recognition").Collection("songs")
 
 	filter := bson.M{"_id": songID}
 
-	_, err := songsCollection.DeleteOne(context.Background(), filter)
+	_, err := songsCollection.DeleteOne(ctx, filter)
 	if err != nil {
 		return fmt.Errorf("failed to delete song: %v", err)
 	}
@@ -191,11 +486,694 @@ func (db *MongoClient) DeleteSongByID(songID uint32) error {
 	return nil
 }
 
-func (db *MongoClient) DeleteCollection(collectionName string) error {
+func (db *MongoClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	_, err := db.fingerprintsCollection().DeleteMany(ctx, bson.M{"songID": songID})
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	return nil
+}
+
+// ReplaceFingerprints swaps the fingerprints of a song for new ones. The new
+// couples are tagged with a generation and stored before the older ones
+// are deleted, so the song never goes without fingerprints. In between it
+// has both, which only inflates its score.
+func (db *MongoClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	collection := db.fingerprintsCollection()
+	generation := time.Now().UnixNano()
+
+	// Couples the song already has are tagged rather than inserted again,
+	// or the unique index would reject them and they would be deleted
+	writes := make([]mongo.WriteModel, 0, len(fingerprints))
+	for address, couple := range fingerprints {
+		filter := bson.M{"address": address, "version": version, "songID": couple.SongID, "anchorTimeMs": couple.AnchorTimeMs}
+		update := bson.M{"$set": bson.M{"generation": generation}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	if len(writes) > 0 {
+		_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return fmt.Errorf("error upserting fingerprints: %v", err)
+		}
+	}
+
+	_, err := collection.DeleteMany(ctx, bson.M{"songID": songID, "generation": bson.M{"$ne": generation}})
+	if err != nil {
+		return fmt.Errorf("failed to delete old fingerprints: %v", err)
+	}
+
+	return nil
+}
+
+func (db *MongoClient) DeleteCollection(ctx context.Context, collectionName string) error {
 	collection := db.client.Database("song-recognition").Collection(collectionName)
-	err := collection.Drop(context.Background())
+	err := collection.Drop(ctx)
 	if err != nil {
 		return fmt.Errorf("error deleting collection: %v", err)
 	}
+	if collectionName == "fingerprints" {
+		// Its indexes went with it
+		mongoFingerprintsMu.Lock()
+		delete(mongoFingerprintsReady, db.uri)
+		mongoFingerprintsMu.Unlock()
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey records key as pending. If the key was already used,
+// its existing record is returned and claimed is false.
+func (db *MongoClient) ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error) {
+	collection := db.client.Database("song-recognition").Collection("idempotency_keys")
+
+	now := time.Now()
+	_, err := collection.InsertOne(ctx, bson.M{"_id": key, "status": IdempotencyPending, "createdAt": now})
+	if err == nil {
+		return IdempotencyRecord{Key: key, Status: IdempotencyPending, CreatedAt: now}, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+
+	var document struct {
+		Status    string    `bson:"status"`
+		Response  []byte    `bson:"response"`
+		CreatedAt time.Time `bson:"createdAt"`
+	}
+	err = collection.FindOne(ctx, bson.M{"_id": key}).Decode(&document)
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to retrieve idempotency key: %v", err)
+	}
+
+	record := IdempotencyRecord{
+		Key:       key,
+		Status:    document.Status,
+		Response:  document.Response,
+		CreatedAt: document.CreatedAt,
+	}
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request made with key.
+func (db *MongoClient) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	collection := db.client.Database("song-recognition").Collection("idempotency_keys")
+
+	update := bson.M{"$set": bson.M{"status": IdempotencyDone, "response": response}}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": key}, update)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried.
+func (db *MongoClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	collection := db.client.Database("song-recognition").Collection("idempotency_keys")
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+// mongoJob is the document form of a Job.
+type mongoJob struct {
+	ID        string    `bson:"_id"`
+	Status    string    `bson:"status"`
+	Payload   []byte    `bson:"payload"`
+	Result    []byte    `bson:"result"`
+	Error     string    `bson:"error"`
+	Attempts  int       `bson:"attempts"`
+	NextRunAt time.Time `bson:"nextRunAt"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func (db *MongoClient) jobsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("jobs")
+}
+
+func (db *MongoClient) EnqueueJob(ctx context.Context, job Job) error {
+	_, err := db.jobsCollection().InsertOne(ctx, mongoJob(job))
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return nil
+}
+
+func (db *MongoClient) GetJob(ctx context.Context, jobID string) (Job, bool, error) {
+	var document mongoJob
+	err := db.jobsCollection().FindOne(ctx, bson.M{"_id": jobID}).Decode(&document)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return Job{}, false, nil
+		}
+		return Job{}, false, fmt.Errorf("failed to retrieve job: %v", err)
+	}
+	return Job(document), true, nil
+}
+
+// ClaimNextJob marks the oldest queued job that is due as processing and
+// returns it.
+func (db *MongoClient) ClaimNextJob(ctx context.Context) (Job, bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status": JobQueued,
+		"$or": bson.A{
+			bson.M{"nextRunAt": bson.M{"$lte": now}},
+			bson.M{"nextRunAt": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"status": JobProcessing, "updatedAt": now}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var document mongoJob
+	err := db.jobsCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return Job{}, false, nil
+		}
+		return Job{}, false, fmt.Errorf("failed to claim job: %v", err)
+	}
+	return Job(document), true, nil
+}
+
+func (db *MongoClient) UpdateJob(ctx context.Context, job Job) error {
+	update := bson.M{"$set": bson.M{
+		"status":    job.Status,
+		"result":    job.Result,
+		"error":     job.Error,
+		"attempts":  job.Attempts,
+		"nextRunAt": job.NextRunAt,
+		"updatedAt": job.UpdatedAt,
+	}}
+	_, err := db.jobsCollection().UpdateOne(ctx, bson.M{"_id": job.ID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	return nil
+}
+
+// RequeueProcessingJobs puts jobs left processing by a stopped worker back
+// in the queue.
+func (db *MongoClient) RequeueProcessingJobs(ctx context.Context) (int, error) {
+	update := bson.M{"$set": bson.M{"status": JobQueued, "updatedAt": time.Now()}}
+	result, err := db.jobsCollection().UpdateMany(ctx, bson.M{"status": JobProcessing}, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// ListJobs returns up to limit jobs with the given status, oldest first.
+func (db *MongoClient) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := db.jobsCollection().Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []Job
+	for cursor.Next(ctx) {
+		var document mongoJob
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to decode job: %v", err)
+		}
+		jobs = append(jobs, Job(document))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// DeleteJobs deletes every job with the given status.
+func (db *MongoClient) DeleteJobs(ctx context.Context, status string) (int, error) {
+	result, err := db.jobsCollection().DeleteMany(ctx, bson.M{"status": status})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+// ListSongs returns up to limit songs in the order they were registered,
+// skipping the first offset.
+func (db *MongoClient) ListSongs(ctx context.Context, offset, limit int) ([]Song, error) {
+	return db.listSongs(ctx, bson.M{}, offset, limit)
+}
+
+// ListSongsByMusicalKey is ListSongs for the songs in musicalKey.
+func (db *MongoClient) ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error) {
+	return db.listSongs(ctx, bson.M{"musicalKey": musicalKey}, offset, limit)
+}
+
+func (db *MongoClient) listSongs(ctx context.Context, filter bson.M, offset, limit int) ([]Song, error) {
+	songsCollection := db.client.Database("song-recognition").Collection("songs")
+
+	opts := options.Find().SetSort(bson.D{{Key: "$natural", Value: 1}}).SetSkip(int64(offset)).SetLimit(int64(limit))
+	cursor, err := songsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var songs []Song
+	for cursor.Next(ctx) {
+		var document bson.M
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to decode song: %v", err)
+		}
+		songs = append(songs, songFromDocument(document))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return songs, nil
+}
+
+func (db *MongoClient) TotalFingerprints(ctx context.Context) (int, error) {
+	return db.countCouples(ctx, bson.M{})
+}
+
+// CountFingerprints returns the number of fingerprints stored for a song.
+func (db *MongoClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	return db.countCouples(ctx, bson.M{"songID": songID})
+}
+
+// ListFingerprints returns every fingerprint stored for a song.
+func (db *MongoClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	cursor, err := db.fingerprintsCollection().Find(ctx, bson.M{"songID": songID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var fingerprints []Fingerprint
+	for cursor.Next(ctx) {
+		var document mongoCouple
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("error decoding fingerprint: %v", err)
+		}
+		fingerprints = append(fingerprints, Fingerprint{
+			Address: document.Address, AnchorTimeMs: document.AnchorTimeMs, Version: document.Version,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+
+	return fingerprints, nil
+}
+
+// CommonAddresses returns the addresses of a fingerprint version that more
+// than maxSongs songs have fingerprints at.
+func (db *MongoClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"version": version}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"address": "$address", "songID": "$songID"}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_id.address", "songs": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"songs": bson.M{"$gt": maxSongs}}}},
+	}
+	cursor, err := db.fingerprintsCollection().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var addresses []uint32
+	for cursor.Next(ctx) {
+		var result struct {
+			Address uint32 `bson:"_id"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, fmt.Errorf("error decoding address: %v", err)
+		}
+		addresses = append(addresses, result.Address)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	return addresses, nil
+}
+
+// EachAddress calls fn with the address of every couple of a fingerprint
+// version, so with an address once for every couple stored there.
+func (db *MongoClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	projection := options.Find().SetProjection(bson.M{"address": 1, "_id": 0})
+	cursor, err := db.fingerprintsCollection().Find(ctx, bson.M{"version": version}, projection)
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document struct {
+			Address uint32 `bson:"address"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return fmt.Errorf("error decoding address: %v", err)
+		}
+		fn(document.Address)
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	return nil
+}
+
+// DeleteAddresses deletes the fingerprints of a version stored at
+// addresses, and returns how many there were.
+func (db *MongoClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	result, err := db.fingerprintsCollection().DeleteMany(ctx, bson.M{"version": version, "address": bson.M{"$in": addresses}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+// CountFingerprintsByVersion returns the number of fingerprints stored of
+// every fingerprint version.
+func (db *MongoClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$version",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := db.fingerprintsCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	counts := make(map[string]int)
+	for cursor.Next(ctx) {
+		var result struct {
+			Version string `bson:"_id"`
+			Count   int    `bson:"count"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, fmt.Errorf("error counting fingerprints: %v", err)
+		}
+		counts[result.Version] = result.Count
+	}
+	return counts, cursor.Err()
+}
+
+// countCouples counts the couples of the fingerprints collection that match
+// filter.
+func (db *MongoClient) countCouples(ctx context.Context, filter bson.M) (int, error) {
+	count, err := db.fingerprintsCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %v", err)
+	}
+	return int(count), nil
+}
+
+// mongoRecognition is the document form of a Recognition.
+type mongoRecognition struct {
+	SongID    uint32    `bson:"songID"`
+	Score     float64   `bson:"score"`
+	OffsetMs  uint32    `bson:"offsetMs"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func (db *MongoClient) recognitionsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("recognitions")
+}
+
+func (db *MongoClient) RecordRecognition(ctx context.Context, recognition Recognition) error {
+	_, err := db.recognitionsCollection().InsertOne(ctx, mongoRecognition(recognition))
+	if err != nil {
+		return fmt.Errorf("failed to record recognition: %v", err)
+	}
+	return nil
+}
+
+// ListRecognitions returns the latest limit recognitions, newest first.
+func (db *MongoClient) ListRecognitions(ctx context.Context, limit int) ([]Recognition, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := db.recognitionsCollection().Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var recognitions []Recognition
+	for cursor.Next(ctx) {
+		var document mongoRecognition
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to decode recognition: %v", err)
+		}
+		recognitions = append(recognitions, Recognition(document))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+
+	return recognitions, nil
+}
+
+// CountRecognitions returns how many times a song has been recognized.
+func (db *MongoClient) CountRecognitions(ctx context.Context, songID uint32) (int, error) {
+	count, err := db.recognitionsCollection().CountDocuments(ctx, bson.M{"songID": songID})
+	if err != nil {
+		return 0, fmt.Errorf("error counting recognitions: %v", err)
+	}
+	return int(count), nil
+}
+
+// mongoDetection is the document form of a Detection.
+type mongoDetection struct {
+	Stream     string    `bson:"stream"`
+	SongID     uint32    `bson:"songID"`
+	Score      float64   `bson:"score"`
+	OffsetMs   uint32    `bson:"offsetMs"`
+	DetectedAt time.Time `bson:"detectedAt"`
+}
+
+func (db *MongoClient) detectionsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("detections")
+}
+
+func (db *MongoClient) RecordDetection(ctx context.Context, detection Detection) error {
+	_, err := db.detectionsCollection().InsertOne(ctx, mongoDetection(detection))
+	if err != nil {
+		return fmt.Errorf("failed to record detection: %v", err)
+	}
+	return nil
+}
+
+// ListDetections returns the latest limit detections on stream, or on all
+// streams if stream is empty, newest first.
+func (db *MongoClient) ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error) {
+	filter := bson.M{}
+	if stream != "" {
+		filter["stream"] = stream
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "detectedAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := db.detectionsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var detections []Detection
+	for cursor.Next(ctx) {
+		var document mongoDetection
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to decode detection: %v", err)
+		}
+		document.DetectedAt = document.DetectedAt.UTC()
+		detections = append(detections, Detection(document))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+
+	return detections, nil
+}
+
+// mongoMelody is the document form of a song's pitch contour.
+type mongoMelody struct {
+	SongID  uint32 `bson:"_id"`
+	Contour []byte `bson:"contour"`
+}
+
+func (db *MongoClient) melodiesCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("melodies")
+}
+
+// StoreMelody saves the pitch contour of a song, replacing any it had.
+func (db *MongoClient) StoreMelody(ctx context.Context, songID uint32, contour []byte) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.melodiesCollection().ReplaceOne(ctx, bson.M{"_id": songID}, mongoMelody{songID, contour}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to store melody: %v", err)
+	}
+	return nil
+}
+
+// ListMelodies returns the pitch contour of every song that has one, keyed
+// by song ID.
+func (db *MongoClient) ListMelodies(ctx context.Context) (map[uint32][]byte, error) {
+	cursor, err := db.melodiesCollection().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	melodies := map[uint32][]byte{}
+	for cursor.Next(ctx) {
+		var document mongoMelody
+		if err := cursor.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to decode melody: %v", err)
+		}
+		melodies[document.SongID] = document.Contour
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+
+	return melodies, nil
+}
+
+func (db *MongoClient) DeleteMelody(ctx context.Context, songID uint32) error {
+	_, err := db.melodiesCollection().DeleteOne(ctx, bson.M{"_id": songID})
+	if err != nil {
+		return fmt.Errorf("failed to delete melody: %v", err)
+	}
+	return nil
+}
+
+// mongoWaveform is the document form of a song's amplitude envelope.
+type mongoWaveform struct {
+	SongID uint32 `bson:"_id"`
+	Peaks  []byte `bson:"peaks"`
+}
+
+func (db *MongoClient) waveformsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("waveforms")
+}
+
+// StoreWaveform saves the amplitude envelope of a song, replacing any it
+// had.
+func (db *MongoClient) StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := db.waveformsCollection().ReplaceOne(ctx, bson.M{"_id": songID}, mongoWaveform{songID, waveform}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to store waveform: %v", err)
+	}
+	return nil
+}
+
+// GetWaveform returns the amplitude envelope of a song, if it has one.
+func (db *MongoClient) GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error) {
+	var document mongoWaveform
+	err := db.waveformsCollection().FindOne(ctx, bson.M{"_id": songID}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get waveform: %v", err)
+	}
+	return document.Peaks, true, nil
+}
+
+func (db *MongoClient) DeleteWaveform(ctx context.Context, songID uint32) error {
+	_, err := db.waveformsCollection().DeleteOne(ctx, bson.M{"_id": songID})
+	if err != nil {
+		return fmt.Errorf("failed to delete waveform: %v", err)
+	}
+	return nil
+}
+
+// mongoLSHBands is the document form of the LSH bands of a song's
+// fingerprints of a version.
+type mongoLSHBands struct {
+	SongID  uint32  `bson:"songID"`
+	Version string  `bson:"version"`
+	Bands   []int64 `bson:"bands"`
+}
+
+// mongoLSHBandsIndex finds the songs having any of a version's bands.
+var mongoLSHBandsIndex = mongo.IndexModel{Keys: bson.D{{Key: "version", Value: 1}, {Key: "bands", Value: 1}}}
+
+func (db *MongoClient) lshBandsCollection() *mongo.Collection {
+	return db.client.Database("song-recognition").Collection("lsh_bands")
+}
+
+// StoreLSHBands saves the LSH bands of a song's fingerprints of a version,
+// replacing those it had.
+func (db *MongoClient) StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error {
+	collection := db.lshBandsCollection()
+	// Creating an index that exists does nothing
+	if _, err := collection.Indexes().CreateOne(ctx, mongoLSHBandsIndex); err != nil {
+		return fmt.Errorf("failed to create lsh bands index: %v", err)
+	}
+
+	document := mongoLSHBands{SongID: songID, Version: version, Bands: make([]int64, len(bands))}
+	for i, band := range bands {
+		document.Bands[i] = int64(band)
+	}
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"songID": songID, "version": version}, document, opts)
+	if err != nil {
+		return fmt.Errorf("failed to store lsh bands: %v", err)
+	}
+	return nil
+}
+
+// LSHCandidates returns the songs with the most of bands among their LSH
+// bands of a version, at most limit of them, most first. A limit of zero
+// returns them all.
+func (db *MongoClient) LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error) {
+	keys := make([]int64, len(bands))
+	for i, band := range bands {
+		keys[i] = int64(band)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"version": version, "bands": bson.M{"$in": keys}}}},
+		{{Key: "$project", Value: bson.M{"songID": 1, "shared": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$bands", keys}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared", Value: -1}, {Key: "songID", Value: 1}}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	cursor, err := db.lshBandsCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var songIDs []uint32
+	for cursor.Next(ctx) {
+		var result struct {
+			SongID uint32 `bson:"songID"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, fmt.Errorf("error decoding lsh candidate: %v", err)
+		}
+		songIDs = append(songIDs, result.SongID)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	return songIDs, nil
+}
+
+// DeleteLSHBands deletes every LSH band of a song.
+func (db *MongoClient) DeleteLSHBands(ctx context.Context, songID uint32) error {
+	_, err := db.lshBandsCollection().DeleteMany(ctx, bson.M{"songID": songID})
+	if err != nil {
+		return fmt.Errorf("failed to delete lsh bands: %v", err)
+	}
 	return nil
 }
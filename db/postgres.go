@@ -0,0 +1,946 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresClient stores the library in PostgreSQL, with the same tables and
+// columns as SQLiteClient. Fingerprints are written with COPY, which is
+// orders of magnitude faster than inserting them one row at a time.
+type PostgresClient struct {
+	db *sql.DB
+}
+
+// PostgresURL is the connection string of the PostgreSQL database used when
+// DB_TYPE is "postgres".
+var PostgresURL = utils.GetEnv("DATABASE_URL", "postgres://localhost:5432/seek_tune?sslmode=disable")
+
+func NewPostgresClient(dataSourceName string) (*PostgresClient, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %s", err)
+	}
+
+	if AutoMigrate {
+		err = migrate(context.Background(), db, postgresMigrations, latestVersion(postgresMigrations))
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error migrating database: %s", err)
+		}
+	}
+
+	return &PostgresClient{db: db}, nil
+}
+
+func newPostgresDefault() (DBClient, error) {
+	return NewPostgresClient(PostgresURL)
+}
+
+// postgresMigrations are the versions of the PostgreSQL schema, oldest
+// first.
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      createPostgresTables,
+		Down:    dropTables("songs", "fingerprints", "idempotency_keys", "jobs", "recognitions", "detections", "melodies", "waveforms"),
+	},
+	{
+		Version: 2,
+		Name:    "lsh bands",
+		Up:      createLSHBandsTable,
+		Down:    dropTables("lsh_bands"),
+	},
+}
+
+// Migrations returns the migrations of the schema and when they were
+// applied.
+func (db *PostgresClient) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	return migrationStatus(ctx, db.db, postgresMigrations)
+}
+
+// Migrate applies or reverts migrations until the schema is at version.
+func (db *PostgresClient) Migrate(ctx context.Context, version int) error {
+	return migrate(ctx, db.db, postgresMigrations, version)
+}
+
+// createPostgresTables creates the required tables and indexes if they
+// don't exist. Times are stored as Unix nanoseconds, as in SQLite.
+func createPostgresTables(db *sql.Tx) error {
+	statements := []struct{ name, query string }{
+		{"songs table", `
+        CREATE TABLE IF NOT EXISTS songs (
+            id BIGINT PRIMARY KEY,
+            title TEXT NOT NULL,
+            artist TEXT NOT NULL,
+            ytID TEXT,
+            key TEXT NOT NULL UNIQUE,
+            checksum TEXT,
+            tempo DOUBLE PRECISION,
+            musicalKey TEXT,
+            tags TEXT,
+            album TEXT,
+            releaseYear INTEGER,
+            mbid TEXT,
+            releaseMbid TEXT,
+            codec TEXT,
+            duration DOUBLE PRECISION,
+            bitrate INTEGER,
+            storeUrl TEXT,
+            fileKey TEXT,
+            previewKey TEXT
+        )`},
+		{"fingerprints table", `
+        CREATE TABLE IF NOT EXISTS fingerprints (
+            address BIGINT NOT NULL,
+            anchorTimeMs BIGINT NOT NULL,
+            songID BIGINT NOT NULL,
+            version TEXT NOT NULL,
+            PRIMARY KEY (address, anchorTimeMs, songID)
+        )`},
+		{"idempotency keys table", `
+        CREATE TABLE IF NOT EXISTS idempotency_keys (
+            key TEXT PRIMARY KEY,
+            status TEXT NOT NULL,
+            response BYTEA,
+            createdAt BIGINT NOT NULL
+        )`},
+		{"jobs table", `
+        CREATE TABLE IF NOT EXISTS jobs (
+            id TEXT PRIMARY KEY,
+            status TEXT NOT NULL,
+            payload BYTEA,
+            result BYTEA,
+            error TEXT NOT NULL DEFAULT '',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            nextRunAt BIGINT NOT NULL DEFAULT 0,
+            createdAt BIGINT NOT NULL,
+            updatedAt BIGINT NOT NULL
+        )`},
+		{"recognitions table", `
+        CREATE TABLE IF NOT EXISTS recognitions (
+            id BIGSERIAL PRIMARY KEY,
+            songID BIGINT NOT NULL,
+            score DOUBLE PRECISION NOT NULL,
+            offsetMs BIGINT NOT NULL,
+            createdAt BIGINT NOT NULL
+        )`},
+		{"detections table", `
+        CREATE TABLE IF NOT EXISTS detections (
+            id BIGSERIAL PRIMARY KEY,
+            stream TEXT NOT NULL,
+            songID BIGINT NOT NULL,
+            score DOUBLE PRECISION NOT NULL,
+            offsetMs BIGINT NOT NULL,
+            detectedAt BIGINT NOT NULL
+        )`},
+		{"melodies table", `
+        CREATE TABLE IF NOT EXISTS melodies (
+            songID BIGINT PRIMARY KEY,
+            contour BYTEA NOT NULL
+        )`},
+		{"waveforms table", `
+        CREATE TABLE IF NOT EXISTS waveforms (
+            songID BIGINT PRIMARY KEY,
+            peaks BYTEA NOT NULL
+        )`},
+		{"recognitions index", "CREATE INDEX IF NOT EXISTS idx_recognitions_songID ON recognitions (songID)"},
+		{"detections index", "CREATE INDEX IF NOT EXISTS idx_detections_stream ON detections (stream)"},
+		{"jobs index", "CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs (status, createdAt)"},
+		{"musical key index", "CREATE INDEX IF NOT EXISTS idx_songs_musicalKey ON songs (musicalKey)"},
+		{"checksum index", "CREATE INDEX IF NOT EXISTS idx_songs_checksum ON songs (checksum)"},
+		{"fingerprints songID index", "CREATE INDEX IF NOT EXISTS idx_fingerprints_songID ON fingerprints (songID)"},
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement.query); err != nil {
+			return fmt.Errorf("error creating %s: %s", statement.name, err)
+		}
+	}
+	return nil
+}
+
+func (db *PostgresClient) Close() error {
+	if db.db != nil {
+		return db.db.Close()
+	}
+	return nil
+}
+
+// StoreFingerprints copies the fingerprints into a temporary table and
+// merges them into the fingerprints table in one statement, replacing the
+// fingerprints already stored at the same address, time and song. COPY
+// can't skip conflicting rows itself.
+func (db *PostgresClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if err := copyFingerprints(ctx, tx, fingerprints, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// copyFingerprints stores fingerprints within tx.
+func copyFingerprints(ctx context.Context, tx *sql.Tx, fingerprints map[uint32]models.Couple, version string) error {
+	_, err := tx.ExecContext(ctx, `
+        CREATE TEMPORARY TABLE fingerprints_copy
+        (LIKE fingerprints INCLUDING DEFAULTS) ON COMMIT DROP`)
+	if err != nil {
+		return fmt.Errorf("error creating copy table: %s", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("fingerprints_copy", "address", "anchortimems", "songid", "version"))
+	if err != nil {
+		return fmt.Errorf("error preparing copy: %s", err)
+	}
+	for address, couple := range fingerprints {
+		if _, err := stmt.ExecContext(ctx, address, couple.AnchorTimeMs, couple.SongID, version); err != nil {
+			stmt.Close()
+			return fmt.Errorf("error copying fingerprint: %s", err)
+		}
+	}
+	// The final Exec flushes the rows buffered by COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("error copying fingerprints: %s", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("error copying fingerprints: %s", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO fingerprints (address, anchorTimeMs, songID, version)
+        SELECT address, anchorTimeMs, songID, version FROM fingerprints_copy
+        ON CONFLICT (address, anchorTimeMs, songID) DO UPDATE SET version = EXCLUDED.version`)
+	if err != nil {
+		return fmt.Errorf("error merging fingerprints: %s", err)
+	}
+	return nil
+}
+
+// GetCouples returns the couples stored under each of addresses with the
+// given fingerprint version.
+func (db *PostgresClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	keys := make(pq.Int64Array, len(addresses))
+	for i, address := range addresses {
+		keys[i] = int64(address)
+	}
+
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT address, anchorTimeMs, songID FROM fingerprints WHERE address = ANY($1) AND version = $2", keys, version)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	defer rows.Close()
+
+	couples := make(map[uint32][]models.Couple)
+	for rows.Next() {
+		var address uint32
+		var couple models.Couple
+		if err := rows.Scan(&address, &couple.AnchorTimeMs, &couple.SongID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		couples[address] = append(couples[address], couple)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+
+	return couples, nil
+}
+
+func (db *PostgresClient) TotalSongs(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM songs").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting songs: %s", err)
+	}
+	return count, nil
+}
+
+func (db *PostgresClient) RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error) {
+	songID := utils.GenerateUniqueID()
+	songKey := utils.GenerateSongKey(songTitle, songArtist)
+	var songChecksum sql.NullString
+	if checksum != "" {
+		songChecksum = sql.NullString{String: checksum, Valid: true}
+	}
+
+	_, err := db.db.ExecContext(ctx, "INSERT INTO songs (id, title, artist, ytID, key, checksum) VALUES ($1, $2, $3, $4, $5, $6)",
+		songID, songTitle, songArtist, ytID, songKey, songChecksum)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Class() == "23" {
+			return 0, fmt.Errorf("song with ytID or key already exists: %v", err)
+		}
+		return 0, fmt.Errorf("failed to register song: %v", err)
+	}
+
+	return songID, nil
+}
+
+const postgresSongColumns = "id, title, artist, ytID, checksum, tempo, musicalKey, tags, album, releaseYear, mbid, releaseMbid, codec, duration, bitrate, storeUrl, fileKey, previewKey"
+
+// GetSong retrieves a song by filter key
+func (db *PostgresClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
+	if !strings.Contains(sqlitefilterKeys, filterKey) {
+		return Song{}, false, fmt.Errorf("invalid filter key")
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM songs WHERE %s = $1", postgresSongColumns, filterKey)
+
+	song, err := scanSong(db.db.QueryRowContext(ctx, query, value))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Song{}, false, nil
+		}
+		return Song{}, false, fmt.Errorf("failed to retrieve song: %s", err)
+	}
+
+	return song, true, nil
+}
+
+func (db *PostgresClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *PostgresClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *PostgresClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+func (db *PostgresClient) GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error) {
+	return db.GetSong(ctx, "checksum", checksum)
+}
+
+// SetSongTempo stores the tempo of a song in beats per minute.
+func (db *PostgresClient) SetSongTempo(ctx context.Context, songID uint32, bpm float64) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET tempo = $1 WHERE id = $2", bpm, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song tempo: %v", err)
+	}
+	return nil
+}
+
+// SetSongMusicalKey stores the key a song is in.
+func (db *PostgresClient) SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET musicalKey = $1 WHERE id = $2", musicalKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song key: %v", err)
+	}
+	return nil
+}
+
+// SetSongTags replaces the tags of a song.
+func (db *PostgresClient) SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error {
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags: %v", err)
+	}
+
+	_, err = db.db.ExecContext(ctx, "UPDATE songs SET tags = $1 WHERE id = $2", string(encoded), songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song tags: %v", err)
+	}
+	return nil
+}
+
+// SetSongMetadata stores what is known of a song beyond its audio.
+func (db *PostgresClient) SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error {
+	_, err := db.db.ExecContext(ctx, `UPDATE songs SET
+        title = COALESCE(NULLIF($1, ''), title),
+        artist = COALESCE(NULLIF($2, ''), artist),
+        album = COALESCE(NULLIF($3, ''), album),
+        releaseYear = COALESCE(NULLIF($4::integer, 0), releaseYear),
+        mbid = COALESCE(NULLIF($5, ''), mbid),
+        releaseMbid = COALESCE(NULLIF($6, ''), releaseMbid),
+        storeUrl = COALESCE(NULLIF($7, ''), storeUrl)
+        WHERE id = $8`,
+		metadata.Title, metadata.Artist, metadata.Album, metadata.ReleaseYear, metadata.MusicBrainzID, metadata.MusicBrainzReleaseID,
+		metadata.StoreURL, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song metadata: %v", err)
+	}
+	return nil
+}
+
+// SetSongAudio stores what probing a song's audio measured.
+func (db *PostgresClient) SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET codec = $1, duration = $2, bitrate = $3 WHERE id = $4",
+		audio.Codec, audio.Duration, audio.Bitrate, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song audio: %v", err)
+	}
+	return nil
+}
+
+// SetSongFile stores the key a song's WAV file is stored under.
+func (db *PostgresClient) SetSongFile(ctx context.Context, songID uint32, fileKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET fileKey = $1 WHERE id = $2", fileKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song file: %v", err)
+	}
+	return nil
+}
+
+// SetSongPreview stores the key a song's preview clip is stored under.
+func (db *PostgresClient) SetSongPreview(ctx context.Context, songID uint32, previewKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET previewKey = $1 WHERE id = $2", previewKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song preview: %v", err)
+	}
+	return nil
+}
+
+// DeleteSongByID deletes a song by ID
+func (db *PostgresClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM songs WHERE id = $1", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete song: %v", err)
+	}
+	return nil
+}
+
+// DeleteFingerprintsBySongID deletes every fingerprint of a song
+func (db *PostgresClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = $1", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	return nil
+}
+
+// ReplaceFingerprints swaps the fingerprints of a song for new ones in one
+// transaction, so lookups see either the old or the new fingerprints.
+func (db *PostgresClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = $1", songID); err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+
+	if err := copyFingerprints(ctx, tx, fingerprints, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCollection deletes every row of a collection (table). The table
+// itself stays, as the migrations recorded it.
+func (db *PostgresClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	_, err := db.db.ExecContext(ctx, fmt.Sprintf("TRUNCATE %s", pq.QuoteIdentifier(collectionName)))
+	if err != nil {
+		return fmt.Errorf("error deleting collection: %v", err)
+	}
+	return nil
+}
+
+// ClaimIdempotencyKey records key as pending. If the key was already used,
+// its existing record is returned and claimed is false.
+func (db *PostgresClient) ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error) {
+	now := time.Now()
+	result, err := db.db.ExecContext(ctx,
+		"INSERT INTO idempotency_keys (key, status, createdAt) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING",
+		key, IdempotencyPending, now.Unix())
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+	if inserted == 1 {
+		return IdempotencyRecord{Key: key, Status: IdempotencyPending, CreatedAt: now}, true, nil
+	}
+
+	record := IdempotencyRecord{Key: key}
+	var createdAt int64
+	err = db.db.QueryRowContext(ctx, "SELECT status, response, createdAt FROM idempotency_keys WHERE key = $1", key).
+		Scan(&record.Status, &record.Response, &createdAt)
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to retrieve idempotency key: %v", err)
+	}
+	record.CreatedAt = time.Unix(createdAt, 0)
+
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request made with key.
+func (db *PostgresClient) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE idempotency_keys SET status = $1, response = $2 WHERE key = $3",
+		IdempotencyDone, response, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried.
+func (db *PostgresClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1", key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresClient) EnqueueJob(ctx context.Context, job Job) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO jobs ("+sqliteJobColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		job.ID, job.Status, job.Payload, job.Result, job.Error, job.Attempts, unixNano(job.NextRunAt),
+		job.CreatedAt.UnixNano(), job.UpdatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return nil
+}
+
+func (db *PostgresClient) GetJob(ctx context.Context, jobID string) (Job, bool, error) {
+	row := db.db.QueryRowContext(ctx, "SELECT "+sqliteJobColumns+" FROM jobs WHERE id = $1", jobID)
+	return scanJob(row)
+}
+
+// ClaimNextJob marks the oldest queued job that is due as processing and
+// returns it. Workers claiming at the same time skip each other's job
+// rather than wait for it.
+func (db *PostgresClient) ClaimNextJob(ctx context.Context) (Job, bool, error) {
+	now := time.Now().UnixNano()
+	row := db.db.QueryRowContext(ctx, `
+        UPDATE jobs SET status = $1, updatedAt = $2
+        WHERE id = (SELECT id FROM jobs WHERE status = $3 AND nextRunAt <= $2 ORDER BY createdAt LIMIT 1 FOR UPDATE SKIP LOCKED)
+        RETURNING `+sqliteJobColumns,
+		JobProcessing, now, JobQueued)
+	return scanJob(row)
+}
+
+func (db *PostgresClient) UpdateJob(ctx context.Context, job Job) error {
+	_, err := db.db.ExecContext(ctx,
+		"UPDATE jobs SET status = $1, result = $2, error = $3, attempts = $4, nextRunAt = $5, updatedAt = $6 WHERE id = $7",
+		job.Status, job.Result, job.Error, job.Attempts, unixNano(job.NextRunAt), job.UpdatedAt.UnixNano(), job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	return nil
+}
+
+// RequeueProcessingJobs puts jobs left processing by a stopped worker back
+// in the queue.
+func (db *PostgresClient) RequeueProcessingJobs(ctx context.Context) (int, error) {
+	result, err := db.db.ExecContext(ctx, "UPDATE jobs SET status = $1, updatedAt = $2 WHERE status = $3",
+		JobQueued, time.Now().UnixNano(), JobProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+	return int(requeued), nil
+}
+
+// ListJobs returns up to limit jobs with the given status, oldest first.
+func (db *PostgresClient) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT "+sqliteJobColumns+" FROM jobs WHERE status = $1 ORDER BY createdAt LIMIT $2", status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, _, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// DeleteJobs deletes every job with the given status.
+func (db *PostgresClient) DeleteJobs(ctx context.Context, status string) (int, error) {
+	result, err := db.db.ExecContext(ctx, "DELETE FROM jobs WHERE status = $1", status)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+	return int(deleted), nil
+}
+
+// ListSongs returns up to limit songs ordered by ID, skipping the first
+// offset.
+func (db *PostgresClient) ListSongs(ctx context.Context, offset, limit int) ([]Song, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT "+postgresSongColumns+" FROM songs ORDER BY id LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return scanSongs(rows)
+}
+
+// ListSongsByMusicalKey is ListSongs for the songs in musicalKey.
+func (db *PostgresClient) ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT "+postgresSongColumns+" FROM songs WHERE musicalKey = $1 ORDER BY id LIMIT $2 OFFSET $3",
+		musicalKey, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return scanSongs(rows)
+}
+
+func (db *PostgresClient) TotalFingerprints(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fingerprints").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	return count, nil
+}
+
+// CountFingerprints returns the number of fingerprints stored for a song.
+func (db *PostgresClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fingerprints WHERE songID = $1", songID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	return count, nil
+}
+
+// ListFingerprints returns every fingerprint stored for a song.
+func (db *PostgresClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT address, anchorTimeMs, version FROM fingerprints WHERE songID = $1", songID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+	return scanFingerprints(rows)
+}
+
+// CommonAddresses returns the addresses of a fingerprint version that more
+// than maxSongs songs have fingerprints at.
+func (db *PostgresClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT address FROM fingerprints WHERE version = $1 GROUP BY address HAVING COUNT(DISTINCT songID) > $2", version, maxSongs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	return scanAddresses(rows)
+}
+
+// EachAddress calls fn with every address of a fingerprint version.
+func (db *PostgresClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	rows, err := db.db.QueryContext(ctx, "SELECT DISTINCT address FROM fingerprints WHERE version = $1", version)
+	if err != nil {
+		return fmt.Errorf("error querying database: %s", err)
+	}
+	return eachAddress(rows, fn)
+}
+
+// DeleteAddresses deletes the fingerprints of a version stored at
+// addresses, and returns how many there were.
+func (db *PostgresClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	keys := make(pq.Int64Array, len(addresses))
+	for i, address := range addresses {
+		keys[i] = int64(address)
+	}
+
+	result, err := db.db.ExecContext(ctx, "DELETE FROM fingerprints WHERE address = ANY($1) AND version = $2", keys, version)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting fingerprints: %s", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return int(deleted), nil
+}
+
+// CountFingerprintsByVersion returns the number of fingerprints stored of
+// every fingerprint version.
+func (db *PostgresClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT version, COUNT(*) FROM fingerprints GROUP BY version")
+	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var version string
+		var count int
+		if err := rows.Scan(&version, &count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		counts[version] = count
+	}
+	return counts, rows.Err()
+}
+
+func (db *PostgresClient) RecordRecognition(ctx context.Context, recognition Recognition) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO recognitions (songID, score, offsetMs, createdAt) VALUES ($1, $2, $3, $4)",
+		recognition.SongID, recognition.Score, recognition.OffsetMs, unixNano(recognition.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record recognition: %v", err)
+	}
+	return nil
+}
+
+// ListRecognitions returns the latest limit recognitions, newest first.
+func (db *PostgresClient) ListRecognitions(ctx context.Context, limit int) ([]Recognition, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT songID, score, offsetMs, createdAt FROM recognitions ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+	defer rows.Close()
+
+	var recognitions []Recognition
+	for rows.Next() {
+		var recognition Recognition
+		var createdAt int64
+		if err := rows.Scan(&recognition.SongID, &recognition.Score, &recognition.OffsetMs, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning recognition: %v", err)
+		}
+		recognition.CreatedAt = time.Unix(0, createdAt)
+		recognitions = append(recognitions, recognition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+
+	return recognitions, nil
+}
+
+// CountRecognitions returns how many times a song has been recognized.
+func (db *PostgresClient) CountRecognitions(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recognitions WHERE songID = $1", songID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting recognitions: %s", err)
+	}
+	return count, nil
+}
+
+func (db *PostgresClient) RecordDetection(ctx context.Context, detection Detection) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO detections (stream, songID, score, offsetMs, detectedAt) VALUES ($1, $2, $3, $4, $5)",
+		detection.Stream, detection.SongID, detection.Score, detection.OffsetMs, unixNano(detection.DetectedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record detection: %v", err)
+	}
+	return nil
+}
+
+// ListDetections returns the latest limit detections on stream, or on all
+// streams if stream is empty, newest first.
+func (db *PostgresClient) ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error) {
+	query := "SELECT stream, songID, score, offsetMs, detectedAt FROM detections"
+	args := []interface{}{}
+	if stream != "" {
+		args = append(args, stream)
+		query += fmt.Sprintf(" WHERE stream = $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+	defer rows.Close()
+
+	var detections []Detection
+	for rows.Next() {
+		var detection Detection
+		var detectedAt int64
+		if err := rows.Scan(&detection.Stream, &detection.SongID, &detection.Score, &detection.OffsetMs, &detectedAt); err != nil {
+			return nil, fmt.Errorf("error scanning detection: %v", err)
+		}
+		detection.DetectedAt = time.Unix(0, detectedAt).UTC()
+		detections = append(detections, detection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+
+	return detections, nil
+}
+
+// StoreMelody saves the pitch contour of a song, replacing any it had.
+func (db *PostgresClient) StoreMelody(ctx context.Context, songID uint32, contour []byte) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO melodies (songID, contour) VALUES ($1, $2) ON CONFLICT (songID) DO UPDATE SET contour = EXCLUDED.contour",
+		songID, contour)
+	if err != nil {
+		return fmt.Errorf("failed to store melody: %v", err)
+	}
+	return nil
+}
+
+// ListMelodies returns the pitch contour of every song that has one, keyed
+// by song ID.
+func (db *PostgresClient) ListMelodies(ctx context.Context) (map[uint32][]byte, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT songID, contour FROM melodies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+	defer rows.Close()
+
+	melodies := map[uint32][]byte{}
+	for rows.Next() {
+		var songID uint32
+		var contour []byte
+		if err := rows.Scan(&songID, &contour); err != nil {
+			return nil, fmt.Errorf("error scanning melody: %v", err)
+		}
+		melodies[songID] = contour
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+
+	return melodies, nil
+}
+
+func (db *PostgresClient) DeleteMelody(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM melodies WHERE songID = $1", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete melody: %v", err)
+	}
+	return nil
+}
+
+// StoreWaveform saves the amplitude envelope of a song, replacing any it
+// had.
+func (db *PostgresClient) StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO waveforms (songID, peaks) VALUES ($1, $2) ON CONFLICT (songID) DO UPDATE SET peaks = EXCLUDED.peaks",
+		songID, waveform)
+	if err != nil {
+		return fmt.Errorf("failed to store waveform: %v", err)
+	}
+	return nil
+}
+
+// GetWaveform returns the amplitude envelope of a song, if it has one.
+func (db *PostgresClient) GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error) {
+	var waveform []byte
+	err := db.db.QueryRowContext(ctx, "SELECT peaks FROM waveforms WHERE songID = $1", songID).Scan(&waveform)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get waveform: %v", err)
+	}
+	return waveform, true, nil
+}
+
+func (db *PostgresClient) DeleteWaveform(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM waveforms WHERE songID = $1", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete waveform: %v", err)
+	}
+	return nil
+}
+
+// StoreLSHBands saves the LSH bands of a song's fingerprints of a version,
+// replacing those it had.
+func (db *PostgresClient) StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM lsh_bands WHERE songID = $1 AND version = $2", songID, version)
+	if err != nil {
+		return fmt.Errorf("failed to store lsh bands: %v", err)
+	}
+
+	keys := make(pq.Int64Array, len(bands))
+	for i, band := range bands {
+		keys[i] = int64(band)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO lsh_bands (band, songID, version) SELECT UNNEST($1::BIGINT[]), $2, $3", keys, songID, version)
+	if err != nil {
+		return fmt.Errorf("failed to store lsh bands: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// LSHCandidates returns the songs with the most of bands among their LSH
+// bands of a version, at most limit of them, most first. A limit of zero
+// returns them all.
+func (db *PostgresClient) LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error) {
+	keys := make(pq.Int64Array, len(bands))
+	for i, band := range bands {
+		keys[i] = int64(band)
+	}
+
+	rows, err := db.db.QueryContext(ctx, `
+        SELECT songID FROM lsh_bands WHERE band = ANY($1) AND version = $2
+        GROUP BY songID ORDER BY COUNT(*) DESC, songID LIMIT NULLIF($3, 0)`, keys, version, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	defer rows.Close()
+
+	var songIDs []uint32
+	for rows.Next() {
+		var songID uint32
+		if err := rows.Scan(&songID); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		songIDs = append(songIDs, songID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	return songIDs, nil
+}
+
+// DeleteLSHBands deletes every LSH band of a song.
+func (db *PostgresClient) DeleteLSHBands(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM lsh_bands WHERE songID = $1", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete lsh bands: %v", err)
+	}
+	return nil
+}
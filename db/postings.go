@@ -0,0 +1,212 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"song-recognition/models"
+	"sync"
+	"time"
+)
+
+// postingsKey is an address of a fingerprint version.
+type postingsKey struct {
+	version string
+	address uint32
+}
+
+// postingsEntry is the cached postings of an address: the couples stored
+// there when it was looked up.
+type postingsEntry struct {
+	key       postingsKey
+	couples   []models.Couple
+	expiresAt time.Time
+}
+
+// size is what an entry counts for against the cache's capacity. Addresses
+// with no couples count for one.
+func (e *postingsEntry) size() int {
+	return max(len(e.couples), 1)
+}
+
+// postingsCache is a cache of the postings looked up last.
+type postingsCache struct {
+	maxCouples int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[postingsKey]*list.Element // of *postingsEntry
+	recent  *list.List                    // most recently used first
+	couples int
+	// generation changes whenever entries are invalidated, so lookups
+	// that raced with a write don't cache what it changed
+	generation uint64
+}
+
+var postings *postingsCache // nil unless EnablePostingsCache was called
+
+// EnablePostingsCache makes the clients NewDBClient returns from now on
+// share an in-process LRU cache of the couples stored at the addresses they
+// look up, so the hashes of songs recognized over and over are answered
+// from memory. The cache holds up to maxCouples couples, and entries expire
+// ttl after they were looked up. Writes through clients of this process
+// invalidate the entries they change; those of other processes are seen
+// once the entries expire, and a zero ttl keeps entries until evicted.
+func EnablePostingsCache(maxCouples int, ttl time.Duration) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	postings = &postingsCache{
+		maxCouples: maxCouples,
+		ttl:        ttl,
+		entries:    map[postingsKey]*list.Element{},
+		recent:     list.New(),
+	}
+}
+
+// get returns the cached couples of the addresses of version it has, the
+// addresses it doesn't, and the generation to cache those with.
+func (c *postingsCache) get(addresses []uint32, version string) (map[uint32][]models.Couple, []uint32, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	hits := make(map[uint32][]models.Couple)
+	var misses []uint32
+	for _, address := range addresses {
+		element, ok := c.entries[postingsKey{version, address}]
+		if !ok {
+			misses = append(misses, address)
+			continue
+		}
+		entry := element.Value.(*postingsEntry)
+		if c.ttl > 0 && now.After(entry.expiresAt) {
+			c.remove(element)
+			misses = append(misses, address)
+			continue
+		}
+		c.recent.MoveToFront(element)
+		if len(entry.couples) > 0 {
+			hits[address] = entry.couples
+		}
+	}
+	return hits, misses, c.generation
+}
+
+// put caches the couples looked up at addresses, unless entries were
+// invalidated since generation, then evicts the least recently used
+// entries over capacity.
+func (c *postingsCache) put(addresses []uint32, version string, couples map[uint32][]models.Couple, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	expiresAt := time.Now().Add(c.ttl)
+	for _, address := range addresses {
+		key := postingsKey{version, address}
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+		// Clipped, so callers appending to them don't write into the cache
+		entry := &postingsEntry{key: key, couples: slices.Clip(couples[address]), expiresAt: expiresAt}
+		if entry.size() > c.maxCouples {
+			continue
+		}
+		c.entries[key] = c.recent.PushFront(entry)
+		c.couples += entry.size()
+	}
+
+	for c.couples > c.maxCouples {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops the entry of element.
+func (c *postingsCache) remove(element *list.Element) {
+	entry := c.recent.Remove(element).(*postingsEntry)
+	delete(c.entries, entry.key)
+	c.couples -= entry.size()
+}
+
+// invalidate drops the entries of addresses of version.
+func (c *postingsCache) invalidate(addresses map[uint32]models.Couple, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for address := range addresses {
+		if element, ok := c.entries[postingsKey{version, address}]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// purge drops every entry, for writes that don't tell which addresses
+// they change.
+func (c *postingsCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = map[postingsKey]*list.Element{}
+	c.recent.Init()
+	c.couples = 0
+}
+
+// postingsClient answers lookups from the postings cache and invalidates
+// the entries its writes change.
+type postingsClient struct {
+	DBClient
+	cache *postingsCache
+}
+
+// GetCouples only looks up the addresses the cache doesn't have.
+func (c postingsClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	couples, misses, generation := c.cache.get(addresses, version)
+	if len(misses) == 0 {
+		return couples, nil
+	}
+
+	looked, err := c.DBClient.GetCouples(ctx, misses, version)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(misses, version, looked, generation)
+
+	for address, stored := range looked {
+		couples[address] = stored
+	}
+	return couples, nil
+}
+
+func (c postingsClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	defer c.cache.invalidate(fingerprints, version)
+	return c.DBClient.StoreFingerprints(ctx, fingerprints, version)
+}
+
+func (c postingsClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	defer c.cache.purge()
+	return c.DBClient.ReplaceFingerprints(ctx, songID, fingerprints, version)
+}
+
+func (c postingsClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	defer c.cache.purge()
+	return c.DBClient.DeleteFingerprintsBySongID(ctx, songID)
+}
+
+func (c postingsClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	defer c.cache.purge()
+	return c.DBClient.DeleteSongByID(ctx, songID)
+}
+
+func (c postingsClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	defer c.cache.purge()
+	return c.DBClient.DeleteAddresses(ctx, version, addresses)
+}
+
+func (c postingsClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	defer c.cache.purge()
+	return c.DBClient.DeleteCollection(ctx, collectionName)
+}
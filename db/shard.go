@@ -0,0 +1,471 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/models"
+	"song-recognition/utils"
+	"strings"
+	"sync"
+)
+
+// ShardURLs are the databases fingerprints are spread across, from the
+// comma-separated DB_SHARDS, or none to keep them with the songs. Each is
+// a postgres:// or mongodb:// connection string, or sqlite:<path> or
+// bolt:<path>.
+var ShardURLs = splitShardURLs(utils.GetEnv("DB_SHARDS"))
+
+func splitShardURLs(urls string) []string {
+	var shards []string
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			shards = append(shards, url)
+		}
+	}
+	return shards
+}
+
+// openShard connects to the database of a shard URL.
+func openShard(url string) (DBClient, error) {
+	switch {
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		client, err := NewPostgresClient(url)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case strings.HasPrefix(url, "mongodb://"), strings.HasPrefix(url, "mongodb+srv://"):
+		client, err := NewMongoClient(url)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case strings.HasPrefix(url, "sqlite:"):
+		client, err := NewSQLiteClient(sqliteFileURI(strings.TrimPrefix(url, "sqlite:"), sqliteShared))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case strings.HasPrefix(url, "bolt:"):
+		client, err := NewBoltClient(strings.TrimPrefix(url, "bolt:"))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	return nil, fmt.Errorf("unsupported shard: %s", url)
+}
+
+// shardedClient keeps the fingerprints of the database it wraps in shards,
+// every address in the one its prefix falls in, and everything else in the
+// database itself. A lookup queries the shards holding its addresses at
+// the same time, and merges their couples, so no database has to hold
+// every fingerprint of a catalog.
+type shardedClient struct {
+	DBClient
+	shards []DBClient
+}
+
+// newShardedClient connects to the shards of urls, closing primary if it
+// can't.
+func newShardedClient(primary DBClient, urls []string) (shardedClient, error) {
+	c := shardedClient{DBClient: primary}
+	for i, url := range urls {
+		shard, err := openShard(url)
+		if err != nil {
+			c.Close()
+			return shardedClient{}, fmt.Errorf("error connecting to shard %d: %v", i, err)
+		}
+		c.shards = append(c.shards, shard)
+	}
+	return c, nil
+}
+
+// shardOf returns the shard of address among n: addresses are split into
+// n ranges of equal size, by their high bits.
+func shardOf(address uint32, n int) int {
+	return int(uint64(address) * uint64(n) >> 32)
+}
+
+// eachShard calls fn with every shard at the same time, returning the
+// first error.
+func (c shardedClient) eachShard(fn func(i int, shard DBClient) error) error {
+	errs := make([]error, len(c.shards))
+	var wg sync.WaitGroup
+	for i, shard := range c.shards {
+		wg.Add(1)
+		go func(i int, shard DBClient) {
+			defer wg.Done()
+			errs[i] = fn(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// splitFingerprints returns the fingerprints of every shard.
+func (c shardedClient) splitFingerprints(fingerprints map[uint32]models.Couple) []map[uint32]models.Couple {
+	split := make([]map[uint32]models.Couple, len(c.shards))
+	for i := range split {
+		split[i] = map[uint32]models.Couple{}
+	}
+	for address, couple := range fingerprints {
+		split[shardOf(address, len(c.shards))][address] = couple
+	}
+	return split
+}
+
+// splitAddresses returns the addresses of every shard.
+func (c shardedClient) splitAddresses(addresses []uint32) [][]uint32 {
+	split := make([][]uint32, len(c.shards))
+	for _, address := range addresses {
+		i := shardOf(address, len(c.shards))
+		split[i] = append(split[i], address)
+	}
+	return split
+}
+
+func (c shardedClient) Close() error {
+	err := c.DBClient.Close()
+	for _, shard := range c.shards {
+		if shardErr := shard.Close(); err == nil {
+			err = shardErr
+		}
+	}
+	return err
+}
+
+func (c shardedClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	split := c.splitFingerprints(fingerprints)
+	return c.eachShard(func(i int, shard DBClient) error {
+		if len(split[i]) == 0 {
+			return nil
+		}
+		return shard.StoreFingerprints(ctx, split[i], version)
+	})
+}
+
+func (c shardedClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
+	split := c.splitAddresses(addresses)
+
+	var mu sync.Mutex
+	couples := make(map[uint32][]models.Couple)
+	err := c.eachShard(func(i int, shard DBClient) error {
+		if len(split[i]) == 0 {
+			return nil
+		}
+		found, err := shard.GetCouples(ctx, split[i], version)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for address, addressCouples := range found {
+			couples[address] = addressCouples
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return couples, nil
+}
+
+// ReplaceFingerprints replaces the fingerprints of a song on every shard,
+// each in a single step of its own. A song whose replacement fails on some
+// shards is left with old fingerprints on those.
+func (c shardedClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	split := c.splitFingerprints(fingerprints)
+	return c.eachShard(func(i int, shard DBClient) error {
+		if len(split[i]) == 0 {
+			return shard.DeleteFingerprintsBySongID(ctx, songID)
+		}
+		return shard.ReplaceFingerprints(ctx, songID, split[i], version)
+	})
+}
+
+func (c shardedClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	return c.eachShard(func(i int, shard DBClient) error {
+		return shard.DeleteFingerprintsBySongID(ctx, songID)
+	})
+}
+
+func (c shardedClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	if err := c.DeleteFingerprintsBySongID(ctx, songID); err != nil {
+		return err
+	}
+	return c.DBClient.DeleteSongByID(ctx, songID)
+}
+
+func (c shardedClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	if collectionName == "fingerprints" {
+		err := c.eachShard(func(i int, shard DBClient) error {
+			return shard.DeleteCollection(ctx, collectionName)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.DBClient.DeleteCollection(ctx, collectionName)
+}
+
+func (c shardedClient) TotalFingerprints(ctx context.Context) (int, error) {
+	counts := make([]int, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		counts[i], err = shard.TotalFingerprints(ctx)
+		return err
+	})
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+func (c shardedClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	counts := make([]map[string]int, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		counts[i], err = shard.CountFingerprintsByVersion(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := map[string]int{}
+	for _, shardCounts := range counts {
+		for version, count := range shardCounts {
+			total[version] += count
+		}
+	}
+	return total, nil
+}
+
+func (c shardedClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	counts := make([]int, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		counts[i], err = shard.CountFingerprints(ctx, songID)
+		return err
+	})
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, err
+}
+
+func (c shardedClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	found := make([][]Fingerprint, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		found[i], err = shard.ListFingerprints(ctx, songID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints []Fingerprint
+	for _, shardFingerprints := range found {
+		fingerprints = append(fingerprints, shardFingerprints...)
+	}
+	return fingerprints, nil
+}
+
+// CommonAddresses merges those of every shard. No address is on two, so
+// each counts all the songs at its addresses.
+func (c shardedClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	found := make([][]uint32, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		found[i], err = shard.CommonAddresses(ctx, version, maxSongs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []uint32
+	for _, shardAddresses := range found {
+		addresses = append(addresses, shardAddresses...)
+	}
+	return addresses, nil
+}
+
+// EachAddress calls fn with the addresses of one shard after the other.
+func (c shardedClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	for i, shard := range c.shards {
+		if err := shard.EachAddress(ctx, version, fn); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (c shardedClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	split := c.splitAddresses(addresses)
+	deleted := make([]int, len(c.shards))
+	err := c.eachShard(func(i int, shard DBClient) (err error) {
+		if len(split[i]) == 0 {
+			return nil
+		}
+		deleted[i], err = shard.DeleteAddresses(ctx, version, split[i])
+		return err
+	})
+	total := 0
+	for _, count := range deleted {
+		total += count
+	}
+	return total, err
+}
+
+// Migrations returns the migrations of the database wrapped, if it has a
+// schema. Migrate applies them to the shards too.
+func (c shardedClient) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	migrator, ok := c.DBClient.(Migrator)
+	if !ok {
+		return nil, fmt.Errorf("the %s database has no schema", DBtype)
+	}
+	return migrator.Migrations(ctx)
+}
+
+func (c shardedClient) Migrate(ctx context.Context, version int) error {
+	if migrator, ok := c.DBClient.(Migrator); ok {
+		if err := migrator.Migrate(ctx, version); err != nil {
+			return err
+		}
+	}
+	for i, shard := range c.shards {
+		if migrator, ok := shard.(Migrator); ok {
+			if err := migrator.Migrate(ctx, version); err != nil {
+				return fmt.Errorf("shard %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Snapshot copies the database wrapped to path and every shard i to
+// ShardSnapshotFile(path, i), and returns a client of the copies. Each is
+// copied in a transaction of its own, shards last, so the fingerprints of
+// songs saved meanwhile may be in the copies without their songs. It
+// returns ErrNoSnapshots if none of them has snapshots, and fails if only
+// some have.
+func (c shardedClient) Snapshot(ctx context.Context, path string) (DBClient, error) {
+	var missing []string
+	snapshotter, ok := c.DBClient.(Snapshotter)
+	if !ok {
+		missing = append(missing, "the "+DBtype+" database")
+	}
+	for i, shard := range c.shards {
+		if _, ok := shard.(Snapshotter); !ok {
+			missing = append(missing, fmt.Sprintf("shard %d", i))
+		}
+	}
+	if len(missing) == len(c.shards)+1 {
+		return nil, ErrNoSnapshots
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("only some databases have snapshots, not %s", strings.Join(missing, ", "))
+	}
+
+	primary, err := snapshotter.Snapshot(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := shardedClient{DBClient: primary}
+	for i, shard := range c.shards {
+		shardSnapshot, err := shard.(Snapshotter).Snapshot(ctx, ShardSnapshotFile(path, i))
+		if err != nil {
+			snapshot.Close()
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		snapshot.shards = append(snapshot.shards, shardSnapshot)
+	}
+	return snapshot, nil
+}
+
+// shardMovePageSize is the number of songs read from the database at a
+// time while moving fingerprints to shards.
+const shardMovePageSize = 500
+
+// MoveFingerprintsToShards moves the fingerprints the database DB_TYPE
+// selects holds itself to the shards of DB_SHARDS, one song at a time, so
+// they are matched once sharding is turned on. It returns the number of
+// fingerprints moved.
+func MoveFingerprintsToShards(ctx context.Context) (int, error) {
+	if len(ShardURLs) == 0 {
+		return 0, fmt.Errorf("DB_SHARDS is not set")
+	}
+
+	// The fingerprints of a song are deleted from the database once stored
+	// in the shards, which would delete those stored in it as a shard
+	for _, url := range ShardURLs {
+		if (DBtype == "sqlite" && url == "sqlite:"+SQLitePath) || (DBtype == "bolt" && url == "bolt:"+BoltPath) ||
+			(DBtype == "postgres" && url == PostgresURL) {
+			return 0, fmt.Errorf("shard %s is the %s database itself", url, DBtype)
+		}
+	}
+
+	backendsMu.RLock()
+	backend, ok := backends[DBtype]
+	backendsMu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("unsupported database type: %s", DBtype)
+	}
+	primary, err := backend()
+	if err != nil {
+		return 0, err
+	}
+	c, err := newShardedClient(primary, ShardURLs)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	moved := 0
+	for offset := 0; ; offset += shardMovePageSize {
+		songs, err := primary.ListSongs(ctx, offset, shardMovePageSize)
+		if err != nil {
+			return moved, fmt.Errorf("error listing songs: %v", err)
+		}
+
+		for _, song := range songs {
+			if err := ctx.Err(); err != nil {
+				return moved, err
+			}
+			fingerprints, err := primary.ListFingerprints(ctx, song.ID)
+			if err != nil {
+				return moved, fmt.Errorf("error listing fingerprints of song %d: %v", song.ID, err)
+			}
+
+			versions := map[string]map[uint32]models.Couple{}
+			for _, fingerprint := range fingerprints {
+				if versions[fingerprint.Version] == nil {
+					versions[fingerprint.Version] = map[uint32]models.Couple{}
+				}
+				versions[fingerprint.Version][fingerprint.Address] = models.Couple{AnchorTimeMs: fingerprint.AnchorTimeMs, SongID: song.ID}
+			}
+			for version, couples := range versions {
+				if err := c.StoreFingerprints(ctx, couples, version); err != nil {
+					return moved, fmt.Errorf("error storing fingerprints of song %d: %v", song.ID, err)
+				}
+			}
+			// Only deleted once stored, so a move that stops half way can
+			// be run again
+			if err := primary.DeleteFingerprintsBySongID(ctx, song.ID); err != nil {
+				return moved, fmt.Errorf("error deleting fingerprints of song %d: %v", song.ID, err)
+			}
+			moved += len(fingerprints)
+		}
+
+		if len(songs) < shardMovePageSize {
+			return moved, nil
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"song-recognition/models"
+	"testing"
+)
+
+// useShards points DB_TYPE and DB_SHARDS at an in-memory database and
+// shards in temporary files until the test ends.
+func useShards(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+
+	dbType, shardURLs := DBtype, ShardURLs
+	t.Cleanup(func() { DBtype, ShardURLs = dbType, shardURLs })
+
+	DBtype = "memory"
+	ShardURLs = []string{
+		"sqlite:" + filepath.Join(dir, "shard0.db"),
+		"bolt:" + filepath.Join(dir, "shard1.db"),
+	}
+}
+
+func TestBloomFilterWithShards(t *testing.T) {
+	useShards(t)
+	ctx := context.Background()
+	const version = "test"
+
+	client, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Addresses at both ends, so both shards hold some
+	fingerprints := map[uint32]models.Couple{
+		0x00000001: {AnchorTimeMs: 10, SongID: 1},
+		0x40000000: {AnchorTimeMs: 20, SongID: 1},
+		0x80000000: {AnchorTimeMs: 30, SongID: 1},
+		0xfffffffe: {AnchorTimeMs: 40, SongID: 1},
+	}
+	if err := client.StoreFingerprints(ctx, fingerprints, version); err != nil {
+		t.Fatal(err)
+	}
+
+	index := &bloomIndex{falsePositiveRate: 0.01, versions: map[string]*bloomVersion{}}
+	v := &bloomVersion{}
+	index.versions[version] = v
+	added, err := index.build(ctx, version, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != len(fingerprints) {
+		t.Fatalf("filter built with %d addresses, want %d", added, len(fingerprints))
+	}
+
+	filtered := bloomClient{DBClient: client, index: index}
+	addresses := []uint32{0x00000001, 0x40000000, 0x80000000, 0xfffffffe, 0x12345678}
+	couples, err := filtered.GetCouples(ctx, addresses, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for address, couple := range fingerprints {
+		found := couples[address]
+		if len(found) != 1 || found[0] != couple {
+			t.Errorf("couples at %#x = %v, want [%v]", address, found, couple)
+		}
+	}
+	if found := couples[0x12345678]; len(found) != 0 {
+		t.Errorf("couples at an address never stored = %v", found)
+	}
+}
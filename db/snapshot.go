@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Snapshotter is implemented by the clients of databases kept in a single
+// file, which can be copied while other clients write to it. Servers such
+// as PostgreSQL and MongoDB are backed up with their own tools.
+type Snapshotter interface {
+	// Snapshot writes a consistent copy of the database to path, which
+	// mustn't exist, and returns a client of the copy.
+	Snapshot(ctx context.Context, path string) (DBClient, error)
+}
+
+// ErrNoSnapshots is returned by the Snapshot of a sharded database none of
+// whose databases has snapshots, which are all left to their own tools.
+var ErrNoSnapshots = errors.New("no database has snapshots")
+
+// SnapshotPath returns the file the database of dbType is kept in, for the
+// backends whose clients are Snapshotters.
+func SnapshotPath(dbType string) (string, bool) {
+	switch dbType {
+	case "sqlite":
+		return SQLitePath, true
+	case "bolt":
+		return BoltPath, true
+	}
+	return "", false
+}
+
+// ShardSnapshotPath returns the backend and the file of the shard at url,
+// for the shards whose clients are Snapshotters.
+func ShardSnapshotPath(url string) (string, string, bool) {
+	for _, dbType := range []string{"sqlite", "bolt"} {
+		if path, ok := strings.CutPrefix(url, dbType+":"); ok {
+			return dbType, path, true
+		}
+	}
+	return "", "", false
+}
+
+// ShardSnapshotFile returns where the snapshot of a sharded database at
+// path keeps the copy of shard i.
+func ShardSnapshotFile(path string, i int) string {
+	return fmt.Sprintf("%s.shard%d", path, i)
+}
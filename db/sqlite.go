@@ -1,15 +1,34 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"song-recognition/models"
 	"song-recognition/utils"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteShared are the parameters of databases written from several
+// connections at once, as concurrent ingestion does: WAL lets readers
+// proceed during writes and the busy timeout makes writers wait for the
+// lock instead of failing.
+const sqliteShared = "_busy_timeout=5000&_journal_mode=WAL"
+
+// sqliteFileURI returns the URI NewSQLiteClient opens the database file at
+// path with, with the parameters of query. The path is escaped, so a '?',
+// '#' or '%' in it is part of the file name instead of starting the
+// parameters.
+func sqliteFileURI(path, query string) string {
+	uri := url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: query}
+	return uri.String()
+}
+
 type SQLiteClient struct {
 	db *sql.DB
 }
@@ -20,23 +39,77 @@ func NewSQLiteClient(dataSourceName string) (*SQLiteClient, error) {
 		return nil, fmt.Errorf("error connecting to SQLite: %s", err)
 	}
 
-	err = createTables(db)
-	if err != nil {
-		return nil, fmt.Errorf("error creating tables: %s", err)
+	if AutoMigrate {
+		err = migrate(context.Background(), db, sqliteMigrations, latestVersion(sqliteMigrations))
+		if err != nil {
+			return nil, fmt.Errorf("error migrating database: %s", err)
+		}
 	}
 
 	return &SQLiteClient{db: db}, nil
 }
 
-// createTables creates the required tables if they don't exist
-func createTables(db *sql.DB) error {
+// sqliteMigrations are the versions of the SQLite schema, oldest first.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      createTables,
+		Down:    dropTables("songs", "fingerprints", "idempotency_keys", "jobs", "recognitions", "detections", "melodies", "waveforms"),
+	},
+	{
+		Version: 2,
+		Name:    "lsh bands",
+		Up:      createLSHBandsTable,
+		Down:    dropTables("lsh_bands"),
+	},
+}
+
+// Migrations returns the migrations of the schema and when they were
+// applied.
+func (db *SQLiteClient) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	return migrationStatus(ctx, db.db, sqliteMigrations)
+}
+
+// Migrate applies or reverts migrations until the schema is at version.
+func (db *SQLiteClient) Migrate(ctx context.Context, version int) error {
+	return migrate(ctx, db.db, sqliteMigrations, version)
+}
+
+// Snapshot copies the database to path with VACUUM INTO, which reads it in
+// a single transaction while writers go on.
+func (db *SQLiteClient) Snapshot(ctx context.Context, path string) (DBClient, error) {
+	if _, err := db.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return nil, fmt.Errorf("error copying database: %v", err)
+	}
+	return NewSQLiteClient(sqliteFileURI(path, ""))
+}
+
+// createTables creates the required tables if they don't exist. Databases
+// created before migrations were versioned get the columns and indexes
+// they lack.
+func createTables(db *sql.Tx) error {
 	createSongsTable := `
     CREATE TABLE IF NOT EXISTS songs (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         title TEXT NOT NULL,
         artist TEXT NOT NULL,
         ytID TEXT,
-        key TEXT NOT NULL UNIQUE
+        key TEXT NOT NULL UNIQUE,
+        checksum TEXT,
+        tempo REAL,
+        musicalKey TEXT,
+        tags TEXT,
+        album TEXT,
+        releaseYear INTEGER,
+        mbid TEXT,
+        releaseMbid TEXT,
+        codec TEXT,
+        duration REAL,
+        bitrate INTEGER,
+        storeUrl TEXT,
+        fileKey TEXT,
+        previewKey TEXT
     );
     `
 
@@ -45,8 +118,67 @@ func createTables(db *sql.DB) error {
         address INTEGER NOT NULL,
         anchorTimeMs INTEGER NOT NULL,
         songID INTEGER NOT NULL,
+        version TEXT NOT NULL,
         PRIMARY KEY (address, anchorTimeMs, songID)
     );
+    `
+
+	createIdempotencyTable := `
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+        key TEXT PRIMARY KEY,
+        status TEXT NOT NULL,
+        response BLOB,
+        createdAt INTEGER NOT NULL
+    );
+    `
+
+	createJobsTable := `
+    CREATE TABLE IF NOT EXISTS jobs (
+        id TEXT PRIMARY KEY,
+        status TEXT NOT NULL,
+        payload BLOB,
+        result BLOB,
+        error TEXT NOT NULL DEFAULT '',
+        attempts INTEGER NOT NULL DEFAULT 0,
+        nextRunAt INTEGER NOT NULL DEFAULT 0,
+        createdAt INTEGER NOT NULL,
+        updatedAt INTEGER NOT NULL
+    );
+    `
+
+	createRecognitionsTable := `
+    CREATE TABLE IF NOT EXISTS recognitions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        songID INTEGER NOT NULL,
+        score REAL NOT NULL,
+        offsetMs INTEGER NOT NULL,
+        createdAt INTEGER NOT NULL
+    );
+    `
+
+	createDetectionsTable := `
+    CREATE TABLE IF NOT EXISTS detections (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        stream TEXT NOT NULL,
+        songID INTEGER NOT NULL,
+        score REAL NOT NULL,
+        offsetMs INTEGER NOT NULL,
+        detectedAt INTEGER NOT NULL
+    );
+    `
+
+	createMelodiesTable := `
+    CREATE TABLE IF NOT EXISTS melodies (
+        songID INTEGER PRIMARY KEY,
+        contour BLOB NOT NULL
+    );
+    `
+
+	createWaveformsTable := `
+    CREATE TABLE IF NOT EXISTS waveforms (
+        songID INTEGER PRIMARY KEY,
+        peaks BLOB NOT NULL
+    );
     `
 
 	_, err := db.Exec(createSongsTable)
@@ -59,6 +191,141 @@ func createTables(db *sql.DB) error {
 		return fmt.Errorf("error creating fingerprints table: %s", err)
 	}
 
+	_, err = db.Exec(createIdempotencyTable)
+	if err != nil {
+		return fmt.Errorf("error creating idempotency keys table: %s", err)
+	}
+
+	_, err = db.Exec(createJobsTable)
+	if err != nil {
+		return fmt.Errorf("error creating jobs table: %s", err)
+	}
+
+	_, err = db.Exec(createRecognitionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating recognitions table: %s", err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_recognitions_songID ON recognitions (songID)")
+	if err != nil {
+		return fmt.Errorf("error creating recognitions index: %s", err)
+	}
+
+	_, err = db.Exec(createDetectionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating detections table: %s", err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_detections_stream ON detections (stream)")
+	if err != nil {
+		return fmt.Errorf("error creating detections index: %s", err)
+	}
+
+	_, err = db.Exec(createMelodiesTable)
+	if err != nil {
+		return fmt.Errorf("error creating melodies table: %s", err)
+	}
+
+	_, err = db.Exec(createWaveformsTable)
+	if err != nil {
+		return fmt.Errorf("error creating waveforms table: %s", err)
+	}
+
+	for _, column := range []string{"attempts", "nextRunAt"} {
+		err = addColumnIfMissing(db, "jobs", column, "INTEGER NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs (status, createdAt)")
+	if err != nil {
+		return fmt.Errorf("error creating jobs index: %s", err)
+	}
+
+	// Databases created before checksums were stored lack the column
+	err = addColumnIfMissing(db, "songs", "checksum", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	err = addColumnIfMissing(db, "songs", "tempo", "REAL")
+	if err != nil {
+		return err
+	}
+
+	err = addColumnIfMissing(db, "songs", "musicalKey", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_songs_musicalKey ON songs (musicalKey)")
+	if err != nil {
+		return fmt.Errorf("error creating musical key index: %s", err)
+	}
+
+	err = addColumnIfMissing(db, "songs", "tags", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"album TEXT", "releaseYear INTEGER", "mbid TEXT", "releaseMbid TEXT", "codec TEXT", "duration REAL", "bitrate INTEGER", "storeUrl TEXT", "fileKey TEXT", "previewKey TEXT"} {
+		name, columnType, _ := strings.Cut(column, " ")
+		err = addColumnIfMissing(db, "songs", name, columnType)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_songs_checksum ON songs (checksum)")
+	if err != nil {
+		return fmt.Errorf("error creating checksum index: %s", err)
+	}
+
+	// Fingerprints stored before versions were get the legacy version
+	err = addColumnIfMissing(db, "fingerprints", "version", fmt.Sprintf("TEXT NOT NULL DEFAULT '%s'", LegacyFingerprintVersion))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_fingerprints_songID ON fingerprints (songID)")
+	if err != nil {
+		return fmt.Errorf("error creating fingerprints songID index: %s", err)
+	}
+
+	return nil
+}
+
+// addColumnIfMissing adds column to table unless the table already has it.
+func addColumnIfMissing(db *sql.Tx, table, column, columnType string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading %s columns: %s", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, ctype      string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("error scanning %s columns: %s", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s columns: %s", table, err)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	if err != nil {
+		return fmt.Errorf("error adding %s.%s column: %s", table, column, err)
+	}
+
 	return nil
 }
 
@@ -69,13 +336,13 @@ func (db *SQLiteClient) Close() error {
 	return nil
 }
 
-func (db *SQLiteClient) StoreFingerprints(fingerprints map[uint32]models.Couple) error {
-	tx, err := db.db.Begin()
+func (db *SQLiteClient) StoreFingerprints(ctx context.Context, fingerprints map[uint32]models.Couple, version string) error {
+	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %s", err)
 	}
 
-	stmt, err := tx.Prepare("INSERT OR REPLACE INTO fingerprints (address, anchorTimeMs, songID) VALUES (?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO fingerprints (address, anchorTimeMs, songID, version) VALUES (?, ?, ?, ?)")
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("error preparing statement: %s", err)
@@ -83,7 +350,7 @@ func (db *SQLiteClient) StoreFingerprints(fingerprints map[uint32]models.Couple)
 	defer stmt.Close()
 
 	for address, couple := range fingerprints {
-		if _, err := stmt.Exec(address, couple.AnchorTimeMs, couple.SongID); err != nil {
+		if _, err := stmt.ExecContext(ctx, address, couple.AnchorTimeMs, couple.SongID, version); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("error executing statement: %s", err)
 		}
@@ -92,11 +359,13 @@ func (db *SQLiteClient) StoreFingerprints(fingerprints map[uint32]models.Couple)
 	return tx.Commit()
 }
 
-func (db *SQLiteClient) GetCouples(addresses []uint32) (map[uint32][]models.Couple, error) {
+// GetCouples returns the couples stored under each of addresses with the
+// given fingerprint version.
+func (db *SQLiteClient) GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error) {
 	couples := make(map[uint32][]models.Couple)
 
 	for _, address := range addresses {
-		rows, err := db.db.Query("SELECT anchorTimeMs, songID FROM fingerprints WHERE address = ?", address)
+		rows, err := db.db.QueryContext(ctx, "SELECT anchorTimeMs, songID FROM fingerprints WHERE address = ? AND version = ?", address, version)
 		if err != nil {
 			return nil, fmt.Errorf("error querying database: %s", err)
 		}
@@ -116,22 +385,22 @@ func (db *SQLiteClient) GetCouples(addresses []uint32) (map[uint32][]models.Coup
 	return couples, nil
 }
 
-func (db *SQLiteClient) TotalSongs() (int, error) {
+func (db *SQLiteClient) TotalSongs(ctx context.Context) (int, error) {
 	var count int
-	err := db.db.QueryRow("SELECT COUNT(*) FROM songs").Scan(&count)
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM songs").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("error counting songs: %s", err)
 	}
 	return count, nil
 }
 
-func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32, error) {
-	tx, err := db.db.Begin()
+func (db *SQLiteClient) RegisterSong(ctx context.Context, songTitle, songArtist, ytID, checksum string) (uint32, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("error starting transaction: %s", err)
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO songs (id, title, artist, ytID, key) VALUES (?, ?, ?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO songs (id, title, artist, ytID, key, checksum) VALUES (?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		tx.Rollback()
 		return 0, fmt.Errorf("error preparing statement: %s", err)
@@ -140,7 +409,12 @@ func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32
 
 	songID := utils.GenerateUniqueID()
 	songKey := utils.GenerateSongKey(songTitle, songArtist)
-	if _, err := stmt.Exec(songID, songTitle, songArtist, ytID, songKey); err != nil {
+	var songChecksum sql.NullString
+	if checksum != "" {
+		songChecksum = sql.NullString{String: checksum, Valid: true}
+	}
+
+	if _, err := stmt.ExecContext(ctx, songID, songTitle, songArtist, ytID, songKey, songChecksum); err != nil {
 		tx.Rollback()
 		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
 			return 0, fmt.Errorf("song with ytID or key already exists: %v", err)
@@ -151,21 +425,20 @@ func (db *SQLiteClient) RegisterSong(songTitle, songArtist, ytID string) (uint32
 	return songID, tx.Commit()
 }
 
-var sqlitefilterKeys = "id | ytID | key"
+var sqlitefilterKeys = "id | ytID | key | checksum"
 
 // GetSong retrieves a song by filter key
-func (s *SQLiteClient) GetSong(filterKey string, value interface{}) (Song, bool, error) {
+func (s *SQLiteClient) GetSong(ctx context.Context, filterKey string, value interface{}) (Song, bool, error) {
 
 	if !strings.Contains(sqlitefilterKeys, filterKey) {
 		return Song{}, false, fmt.Errorf("invalid filter key")
 	}
 
-	query := fmt.Sprintf("SELECT title, artist, ytID FROM songs WHERE %s = ?", filterKey)
+	query := fmt.Sprintf("SELECT id, title, artist, ytID, checksum, tempo, musicalKey, tags, album, releaseYear, mbid, releaseMbid, codec, duration, bitrate, storeUrl, fileKey, previewKey FROM songs WHERE %s = ?", filterKey)
 
-	row := s.db.QueryRow(query, value)
+	row := s.db.QueryRowContext(ctx, query, value)
 
-	var song Song
-	err := row.Scan(&song.Title, &song.Artist, &song.YouTubeID)
+	song, err := scanSong(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return Song{}, false, nil
@@ -176,32 +449,780 @@ func (s *SQLiteClient) GetSong(filterKey string, value interface{}) (Song, bool,
 	return song, true, nil
 }
 
-func (db *SQLiteClient) GetSongByID(songID uint32) (Song, bool, error) {
-	return db.GetSong("id", songID)
+// scanSong reads a row of id, title, artist, ytID, checksum, tempo,
+// musicalKey, tags, album, releaseYear, mbid, releaseMbid, codec, duration,
+// bitrate, storeUrl, fileKey and previewKey.
+func scanSong(row interface{ Scan(dest ...any) error }) (Song, error) {
+	var song Song
+	var ytID, checksum, musicalKey, tags, album, mbid, releaseMbid, codec, storeURL, fileKey, previewKey sql.NullString
+	var tempo, duration sql.NullFloat64
+	var releaseYear, bitrate sql.NullInt64
+	if err := row.Scan(&song.ID, &song.Title, &song.Artist, &ytID, &checksum, &tempo, &musicalKey, &tags,
+		&album, &releaseYear, &mbid, &releaseMbid, &codec, &duration, &bitrate, &storeURL, &fileKey, &previewKey); err != nil {
+		return Song{}, err
+	}
+	song.YouTubeID = ytID.String
+	song.Checksum = checksum.String
+	song.Tempo = tempo.Float64
+	song.MusicalKey = musicalKey.String
+	song.Album = album.String
+	song.ReleaseYear = int(releaseYear.Int64)
+	song.MusicBrainzID = mbid.String
+	song.MusicBrainzReleaseID = releaseMbid.String
+	song.Codec = codec.String
+	song.Duration = duration.Float64
+	song.Bitrate = int(bitrate.Int64)
+	song.StoreURL = storeURL.String
+	song.FileKey = fileKey.String
+	song.PreviewKey = previewKey.String
+	if tags.Valid {
+		if err := json.Unmarshal([]byte(tags.String), &song.Tags); err != nil {
+			return Song{}, fmt.Errorf("invalid tags: %v", err)
+		}
+	}
+	return song, nil
+}
+
+func (db *SQLiteClient) GetSongByID(ctx context.Context, songID uint32) (Song, bool, error) {
+	return db.GetSong(ctx, "id", songID)
+}
+
+func (db *SQLiteClient) GetSongByYTID(ctx context.Context, ytID string) (Song, bool, error) {
+	return db.GetSong(ctx, "ytID", ytID)
+}
+
+func (db *SQLiteClient) GetSongByKey(ctx context.Context, key string) (Song, bool, error) {
+	return db.GetSong(ctx, "key", key)
+}
+
+func (db *SQLiteClient) GetSongByChecksum(ctx context.Context, checksum string) (Song, bool, error) {
+	return db.GetSong(ctx, "checksum", checksum)
+}
+
+// SetSongTempo stores the tempo of a song in beats per minute.
+func (db *SQLiteClient) SetSongTempo(ctx context.Context, songID uint32, bpm float64) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET tempo = ? WHERE id = ?", bpm, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song tempo: %v", err)
+	}
+	return nil
+}
+
+// SetSongMusicalKey stores the key a song is in.
+func (db *SQLiteClient) SetSongMusicalKey(ctx context.Context, songID uint32, musicalKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET musicalKey = ? WHERE id = ?", musicalKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song key: %v", err)
+	}
+	return nil
+}
+
+// SetSongTags replaces the tags of a song.
+func (db *SQLiteClient) SetSongTags(ctx context.Context, songID uint32, tags map[string]string) error {
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags: %v", err)
+	}
+
+	_, err = db.db.ExecContext(ctx, "UPDATE songs SET tags = ? WHERE id = ?", string(encoded), songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song tags: %v", err)
+	}
+	return nil
+}
+
+// SetSongMetadata stores what is known of a song beyond its audio.
+func (db *SQLiteClient) SetSongMetadata(ctx context.Context, songID uint32, metadata SongMetadata) error {
+	_, err := db.db.ExecContext(ctx, `UPDATE songs SET
+        title = COALESCE(NULLIF(?, ''), title),
+        artist = COALESCE(NULLIF(?, ''), artist),
+        album = COALESCE(NULLIF(?, ''), album),
+        releaseYear = COALESCE(NULLIF(?, 0), releaseYear),
+        mbid = COALESCE(NULLIF(?, ''), mbid),
+        releaseMbid = COALESCE(NULLIF(?, ''), releaseMbid),
+        storeUrl = COALESCE(NULLIF(?, ''), storeUrl)
+        WHERE id = ?`,
+		metadata.Title, metadata.Artist, metadata.Album, metadata.ReleaseYear, metadata.MusicBrainzID, metadata.MusicBrainzReleaseID,
+		metadata.StoreURL, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song metadata: %v", err)
+	}
+	return nil
 }
 
-func (db *SQLiteClient) GetSongByYTID(ytID string) (Song, bool, error) {
-	return db.GetSong("ytID", ytID)
+// SetSongAudio stores what probing a song's audio measured.
+func (db *SQLiteClient) SetSongAudio(ctx context.Context, songID uint32, audio SongAudio) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET codec = ?, duration = ?, bitrate = ? WHERE id = ?",
+		audio.Codec, audio.Duration, audio.Bitrate, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song audio: %v", err)
+	}
+	return nil
+}
+
+// SetSongFile stores the key a song's WAV file is stored under.
+func (db *SQLiteClient) SetSongFile(ctx context.Context, songID uint32, fileKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET fileKey = ? WHERE id = ?", fileKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song file: %v", err)
+	}
+	return nil
 }
 
-func (db *SQLiteClient) GetSongByKey(key string) (Song, bool, error) {
-	return db.GetSong("key", key)
+// SetSongPreview stores the key a song's preview clip is stored under.
+func (db *SQLiteClient) SetSongPreview(ctx context.Context, songID uint32, previewKey string) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE songs SET previewKey = ? WHERE id = ?", previewKey, songID)
+	if err != nil {
+		return fmt.Errorf("failed to set song preview: %v", err)
+	}
+	return nil
 }
 
 // DeleteSongByID deletes a song by ID
-func (db *SQLiteClient) DeleteSongByID(songID uint32) error {
-	_, err := db.db.Exec("DELETE FROM songs WHERE id = ?", songID)
+func (db *SQLiteClient) DeleteSongByID(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM songs WHERE id = ?", songID)
 	if err != nil {
 		return fmt.Errorf("failed to delete song: %v", err)
 	}
 	return nil
 }
 
-// DeleteCollection deletes a collection (table) from the database
-func (db *SQLiteClient) DeleteCollection(collectionName string) error {
-	_, err := db.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", collectionName))
+// DeleteFingerprintsBySongID deletes every fingerprint of a song
+func (db *SQLiteClient) DeleteFingerprintsBySongID(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+	return nil
+}
+
+// ReplaceFingerprints swaps the fingerprints of a song for new ones in one
+// transaction, so lookups see either the old or the new fingerprints.
+func (db *SQLiteClient) ReplaceFingerprints(ctx context.Context, songID uint32, fingerprints map[uint32]models.Couple, version string) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fingerprints WHERE songID = ?", songID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete fingerprints: %v", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO fingerprints (address, anchorTimeMs, songID, version) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error preparing statement: %s", err)
+	}
+	defer stmt.Close()
+
+	for address, couple := range fingerprints {
+		if _, err := stmt.ExecContext(ctx, address, couple.AnchorTimeMs, couple.SongID, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error executing statement: %s", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCollection deletes every row of a collection (table). The table
+// itself stays, as the migrations recorded it.
+func (db *SQLiteClient) DeleteCollection(ctx context.Context, collectionName string) error {
+	_, err := db.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", collectionName))
 	if err != nil {
 		return fmt.Errorf("error deleting collection: %v", err)
 	}
 	return nil
 }
+
+// ClaimIdempotencyKey records key as pending. If the key was already used,
+// its existing record is returned and claimed is false.
+func (db *SQLiteClient) ClaimIdempotencyKey(ctx context.Context, key string) (IdempotencyRecord, bool, error) {
+	now := time.Now()
+	result, err := db.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO idempotency_keys (key, status, createdAt) VALUES (?, ?, ?)",
+		key, IdempotencyPending, now.Unix())
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+	if inserted == 1 {
+		return IdempotencyRecord{Key: key, Status: IdempotencyPending, CreatedAt: now}, true, nil
+	}
+
+	record := IdempotencyRecord{Key: key}
+	var createdAt int64
+	err = db.db.QueryRowContext(ctx, "SELECT status, response, createdAt FROM idempotency_keys WHERE key = ?", key).
+		Scan(&record.Status, &record.Response, &createdAt)
+	if err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("failed to retrieve idempotency key: %v", err)
+	}
+	record.CreatedAt = time.Unix(createdAt, 0)
+
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request made with key.
+func (db *SQLiteClient) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	_, err := db.db.ExecContext(ctx, "UPDATE idempotency_keys SET status = ?, response = ? WHERE key = ?",
+		IdempotencyDone, response, key)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried.
+func (db *SQLiteClient) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = ?", key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+func (db *SQLiteClient) EnqueueJob(ctx context.Context, job Job) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO jobs (id, status, payload, result, error, attempts, nextRunAt, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		job.ID, job.Status, job.Payload, job.Result, job.Error, job.Attempts, unixNano(job.NextRunAt),
+		job.CreatedAt.UnixNano(), job.UpdatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %v", err)
+	}
+	return nil
+}
+
+const sqliteJobColumns = "id, status, payload, result, error, attempts, nextRunAt, createdAt, updatedAt"
+
+// scanJob reads a row selected with sqliteJobColumns.
+func scanJob(row interface{ Scan(dest ...any) error }) (Job, bool, error) {
+	var job Job
+	var nextRunAt, createdAt, updatedAt int64
+	err := row.Scan(&job.ID, &job.Status, &job.Payload, &job.Result, &job.Error, &job.Attempts,
+		&nextRunAt, &createdAt, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Job{}, false, nil
+		}
+		return Job{}, false, fmt.Errorf("failed to retrieve job: %v", err)
+	}
+	if nextRunAt != 0 {
+		job.NextRunAt = time.Unix(0, nextRunAt)
+	}
+	job.CreatedAt = time.Unix(0, createdAt)
+	job.UpdatedAt = time.Unix(0, updatedAt)
+
+	return job, true, nil
+}
+
+// unixNano stores the zero time as 0 rather than a large negative number.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func (db *SQLiteClient) GetJob(ctx context.Context, jobID string) (Job, bool, error) {
+	row := db.db.QueryRowContext(ctx, "SELECT "+sqliteJobColumns+" FROM jobs WHERE id = ?", jobID)
+	return scanJob(row)
+}
+
+// ClaimNextJob marks the oldest queued job that is due as processing and
+// returns it.
+func (db *SQLiteClient) ClaimNextJob(ctx context.Context) (Job, bool, error) {
+	now := time.Now().UnixNano()
+	row := db.db.QueryRowContext(ctx, `
+        UPDATE jobs SET status = ?, updatedAt = ?
+        WHERE id = (SELECT id FROM jobs WHERE status = ? AND nextRunAt <= ? ORDER BY createdAt LIMIT 1)
+        RETURNING `+sqliteJobColumns,
+		JobProcessing, now, JobQueued, now)
+	return scanJob(row)
+}
+
+func (db *SQLiteClient) UpdateJob(ctx context.Context, job Job) error {
+	_, err := db.db.ExecContext(ctx,
+		"UPDATE jobs SET status = ?, result = ?, error = ?, attempts = ?, nextRunAt = ?, updatedAt = ? WHERE id = ?",
+		job.Status, job.Result, job.Error, job.Attempts, unixNano(job.NextRunAt), job.UpdatedAt.UnixNano(), job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	return nil
+}
+
+// RequeueProcessingJobs puts jobs left processing by a stopped worker back
+// in the queue.
+func (db *SQLiteClient) RequeueProcessingJobs(ctx context.Context) (int, error) {
+	result, err := db.db.ExecContext(ctx, "UPDATE jobs SET status = ?, updatedAt = ? WHERE status = ?",
+		JobQueued, time.Now().UnixNano(), JobProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %v", err)
+	}
+	return int(requeued), nil
+}
+
+// ListJobs returns up to limit jobs with the given status, oldest first.
+func (db *SQLiteClient) ListJobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT "+sqliteJobColumns+" FROM jobs WHERE status = ? ORDER BY createdAt LIMIT ?", status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, _, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// DeleteJobs deletes every job with the given status.
+func (db *SQLiteClient) DeleteJobs(ctx context.Context, status string) (int, error) {
+	result, err := db.db.ExecContext(ctx, "DELETE FROM jobs WHERE status = ?", status)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %v", err)
+	}
+	return int(deleted), nil
+}
+
+// ListSongs returns up to limit songs in the order they were registered,
+// skipping the first offset.
+func (db *SQLiteClient) ListSongs(ctx context.Context, offset, limit int) ([]Song, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT id, title, artist, ytID, checksum, tempo, musicalKey, tags, album, releaseYear, mbid, releaseMbid, codec, duration, bitrate, storeUrl, fileKey, previewKey FROM songs ORDER BY rowid LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return scanSongs(rows)
+}
+
+// ListSongsByMusicalKey is ListSongs for the songs in musicalKey.
+func (db *SQLiteClient) ListSongsByMusicalKey(ctx context.Context, musicalKey string, offset, limit int) ([]Song, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT id, title, artist, ytID, checksum, tempo, musicalKey, tags, album, releaseYear, mbid, releaseMbid, codec, duration, bitrate, storeUrl, fileKey, previewKey FROM songs WHERE musicalKey = ? ORDER BY rowid LIMIT ? OFFSET ?",
+		musicalKey, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return scanSongs(rows)
+}
+
+func scanSongs(rows *sql.Rows) ([]Song, error) {
+	defer rows.Close()
+
+	var songs []Song
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning song: %v", err)
+		}
+		songs = append(songs, song)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list songs: %v", err)
+	}
+
+	return songs, nil
+}
+
+func (db *SQLiteClient) TotalFingerprints(ctx context.Context) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fingerprints").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	return count, nil
+}
+
+// CountFingerprints returns the number of fingerprints stored for a song.
+func (db *SQLiteClient) CountFingerprints(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM fingerprints WHERE songID = ?", songID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	return count, nil
+}
+
+// ListFingerprints returns every fingerprint stored for a song.
+func (db *SQLiteClient) ListFingerprints(ctx context.Context, songID uint32) ([]Fingerprint, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT address, anchorTimeMs, version FROM fingerprints WHERE songID = ?", songID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+	return scanFingerprints(rows)
+}
+
+func scanFingerprints(rows *sql.Rows) ([]Fingerprint, error) {
+	defer rows.Close()
+
+	var fingerprints []Fingerprint
+	for rows.Next() {
+		var fingerprint Fingerprint
+		if err := rows.Scan(&fingerprint.Address, &fingerprint.AnchorTimeMs, &fingerprint.Version); err != nil {
+			return nil, fmt.Errorf("error scanning fingerprint: %v", err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list fingerprints: %v", err)
+	}
+
+	return fingerprints, nil
+}
+
+// CommonAddresses returns the addresses of a fingerprint version that more
+// than maxSongs songs have fingerprints at.
+func (db *SQLiteClient) CommonAddresses(ctx context.Context, version string, maxSongs int) ([]uint32, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT address FROM fingerprints WHERE version = ? GROUP BY address HAVING COUNT(DISTINCT songID) > ?", version, maxSongs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	return scanAddresses(rows)
+}
+
+func scanAddresses(rows *sql.Rows) ([]uint32, error) {
+	defer rows.Close()
+
+	var addresses []uint32
+	for rows.Next() {
+		var address uint32
+		if err := rows.Scan(&address); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		addresses = append(addresses, address)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %s", err)
+	}
+	return addresses, nil
+}
+
+// EachAddress calls fn with every address of a fingerprint version.
+func (db *SQLiteClient) EachAddress(ctx context.Context, version string, fn func(address uint32)) error {
+	rows, err := db.db.QueryContext(ctx, "SELECT DISTINCT address FROM fingerprints WHERE version = ?", version)
+	if err != nil {
+		return fmt.Errorf("error querying database: %s", err)
+	}
+	return eachAddress(rows, fn)
+}
+
+func eachAddress(rows *sql.Rows, fn func(address uint32)) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var address uint32
+		if err := rows.Scan(&address); err != nil {
+			return fmt.Errorf("error scanning row: %s", err)
+		}
+		fn(address)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying database: %s", err)
+	}
+	return nil
+}
+
+// DeleteAddresses deletes the fingerprints of a version stored at
+// addresses, and returns how many there were.
+func (db *SQLiteClient) DeleteAddresses(ctx context.Context, version string, addresses []uint32) (int, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM fingerprints WHERE address = ? AND version = ?")
+	if err != nil {
+		return 0, fmt.Errorf("error preparing statement: %s", err)
+	}
+	defer stmt.Close()
+
+	var deleted int64
+	for _, address := range addresses {
+		result, err := stmt.ExecContext(ctx, address, version)
+		if err != nil {
+			return 0, fmt.Errorf("error deleting fingerprints: %s", err)
+		}
+		count, _ := result.RowsAffected()
+		deleted += count
+	}
+
+	return int(deleted), tx.Commit()
+}
+
+// CountFingerprintsByVersion returns the number of fingerprints stored of
+// every fingerprint version.
+func (db *SQLiteClient) CountFingerprintsByVersion(ctx context.Context) (map[string]int, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT version, COUNT(*) FROM fingerprints GROUP BY version")
+	if err != nil {
+		return nil, fmt.Errorf("error counting fingerprints: %s", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var version string
+		var count int
+		if err := rows.Scan(&version, &count); err != nil {
+			return nil, fmt.Errorf("error scanning row: %s", err)
+		}
+		counts[version] = count
+	}
+	return counts, rows.Err()
+}
+
+func (db *SQLiteClient) RecordRecognition(ctx context.Context, recognition Recognition) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO recognitions (songID, score, offsetMs, createdAt) VALUES (?, ?, ?, ?)",
+		recognition.SongID, recognition.Score, recognition.OffsetMs, unixNano(recognition.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record recognition: %v", err)
+	}
+	return nil
+}
+
+// ListRecognitions returns the latest limit recognitions, newest first.
+func (db *SQLiteClient) ListRecognitions(ctx context.Context, limit int) ([]Recognition, error) {
+	rows, err := db.db.QueryContext(ctx,
+		"SELECT songID, score, offsetMs, createdAt FROM recognitions ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+	defer rows.Close()
+
+	var recognitions []Recognition
+	for rows.Next() {
+		var recognition Recognition
+		var createdAt int64
+		if err := rows.Scan(&recognition.SongID, &recognition.Score, &recognition.OffsetMs, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning recognition: %v", err)
+		}
+		recognition.CreatedAt = time.Unix(0, createdAt)
+		recognitions = append(recognitions, recognition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list recognitions: %v", err)
+	}
+
+	return recognitions, nil
+}
+
+// CountRecognitions returns how many times a song has been recognized.
+func (db *SQLiteClient) CountRecognitions(ctx context.Context, songID uint32) (int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recognitions WHERE songID = ?", songID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting recognitions: %s", err)
+	}
+	return count, nil
+}
+
+func (db *SQLiteClient) RecordDetection(ctx context.Context, detection Detection) error {
+	_, err := db.db.ExecContext(ctx,
+		"INSERT INTO detections (stream, songID, score, offsetMs, detectedAt) VALUES (?, ?, ?, ?, ?)",
+		detection.Stream, detection.SongID, detection.Score, detection.OffsetMs, unixNano(detection.DetectedAt))
+	if err != nil {
+		return fmt.Errorf("failed to record detection: %v", err)
+	}
+	return nil
+}
+
+// ListDetections returns the latest limit detections on stream, or on all
+// streams if stream is empty, newest first.
+func (db *SQLiteClient) ListDetections(ctx context.Context, stream string, limit int) ([]Detection, error) {
+	query := "SELECT stream, songID, score, offsetMs, detectedAt FROM detections"
+	args := []interface{}{}
+	if stream != "" {
+		query += " WHERE stream = ?"
+		args = append(args, stream)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+	defer rows.Close()
+
+	var detections []Detection
+	for rows.Next() {
+		var detection Detection
+		var detectedAt int64
+		if err := rows.Scan(&detection.Stream, &detection.SongID, &detection.Score, &detection.OffsetMs, &detectedAt); err != nil {
+			return nil, fmt.Errorf("error scanning detection: %v", err)
+		}
+		detection.DetectedAt = time.Unix(0, detectedAt).UTC()
+		detections = append(detections, detection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list detections: %v", err)
+	}
+
+	return detections, nil
+}
+
+// StoreMelody saves the pitch contour of a song, replacing any it had.
+func (db *SQLiteClient) StoreMelody(ctx context.Context, songID uint32, contour []byte) error {
+	_, err := db.db.ExecContext(ctx, "INSERT OR REPLACE INTO melodies (songID, contour) VALUES (?, ?)", songID, contour)
+	if err != nil {
+		return fmt.Errorf("failed to store melody: %v", err)
+	}
+	return nil
+}
+
+// ListMelodies returns the pitch contour of every song that has one, keyed
+// by song ID.
+func (db *SQLiteClient) ListMelodies(ctx context.Context) (map[uint32][]byte, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT songID, contour FROM melodies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+	defer rows.Close()
+
+	melodies := map[uint32][]byte{}
+	for rows.Next() {
+		var songID uint32
+		var contour []byte
+		if err := rows.Scan(&songID, &contour); err != nil {
+			return nil, fmt.Errorf("error scanning melody: %v", err)
+		}
+		melodies[songID] = contour
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list melodies: %v", err)
+	}
+
+	return melodies, nil
+}
+
+func (db *SQLiteClient) DeleteMelody(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM melodies WHERE songID = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete melody: %v", err)
+	}
+	return nil
+}
+
+// StoreWaveform saves the amplitude envelope of a song, replacing any it
+// had.
+func (db *SQLiteClient) StoreWaveform(ctx context.Context, songID uint32, waveform []byte) error {
+	_, err := db.db.ExecContext(ctx, "INSERT OR REPLACE INTO waveforms (songID, peaks) VALUES (?, ?)", songID, waveform)
+	if err != nil {
+		return fmt.Errorf("failed to store waveform: %v", err)
+	}
+	return nil
+}
+
+// GetWaveform returns the amplitude envelope of a song, if it has one.
+func (db *SQLiteClient) GetWaveform(ctx context.Context, songID uint32) ([]byte, bool, error) {
+	var waveform []byte
+	err := db.db.QueryRowContext(ctx, "SELECT peaks FROM waveforms WHERE songID = ?", songID).Scan(&waveform)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get waveform: %v", err)
+	}
+	return waveform, true, nil
+}
+
+func (db *SQLiteClient) DeleteWaveform(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM waveforms WHERE songID = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete waveform: %v", err)
+	}
+	return nil
+}
+
+// StoreLSHBands saves the LSH bands of a song's fingerprints of a version,
+// replacing those it had.
+func (db *SQLiteClient) StoreLSHBands(ctx context.Context, songID uint32, version string, bands []uint64) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM lsh_bands WHERE songID = ? AND version = ?", songID, version)
+	if err != nil {
+		return fmt.Errorf("failed to store lsh bands: %v", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO lsh_bands (band, songID, version) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("error preparing statement: %s", err)
+	}
+	defer stmt.Close()
+
+	for _, band := range bands {
+		if _, err := stmt.ExecContext(ctx, int64(band), songID, version); err != nil {
+			return fmt.Errorf("failed to store lsh bands: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LSHCandidates returns the songs with the most of bands among their LSH
+// bands of a version, at most limit of them, most first. A limit of zero
+// returns them all.
+func (db *SQLiteClient) LSHCandidates(ctx context.Context, version string, bands []uint64, limit int) ([]uint32, error) {
+	counts := map[uint32]int{}
+	for _, band := range bands {
+		rows, err := db.db.QueryContext(ctx, "SELECT songID FROM lsh_bands WHERE band = ? AND version = ?", int64(band), version)
+		if err != nil {
+			return nil, fmt.Errorf("error querying database: %s", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var songID uint32
+			if err := rows.Scan(&songID); err != nil {
+				return nil, fmt.Errorf("error scanning row: %s", err)
+			}
+			counts[songID]++
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error querying database: %s", err)
+		}
+	}
+	return topCandidates(counts, limit), nil
+}
+
+// DeleteLSHBands deletes every LSH band of a song.
+func (db *SQLiteClient) DeleteLSHBands(ctx context.Context, songID uint32) error {
+	_, err := db.db.ExecContext(ctx, "DELETE FROM lsh_bands WHERE songID = ?", songID)
+	if err != nil {
+		return fmt.Errorf("failed to delete lsh bands: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package decode turns audio files into the mono float64 samples used by
+// the fingerprinting pipeline. Formats with a native Go decoder are decoded
+// in-process; anything else is handed to FFmpeg when it is installed.
+package decode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/wav"
+)
+
+// ErrTooLong is returned for audio longer than decode.max_duration_seconds
+// of the config file.
+var ErrTooLong = errors.New("audio is longer than the maximum duration decoded")
+
+// maxSamples returns the most samples of audio at sampleRate that are
+// decoded, or 0 if the duration isn't limited.
+func maxSamples(sampleRate int) int {
+	return config.Get().Decode.MaxDurationSeconds * sampleRate
+}
+
+// Audio holds decoded, mono PCM samples scaled to the range [-1, 1].
+type Audio struct {
+	Samples    []float64
+	SampleRate int
+	Channels   int // number of channels in the source before downmixing
+	Duration   float64
+}
+
+// DecodeFile decodes the audio file at path. The format is identified from
+// the file's magic bytes, falling back to its extension. If the native
+// decoder fails, or there is none for the format, FFmpeg is used as a
+// fallback when it is available on PATH.
+func DecodeFile(ctx context.Context, path string) (*Audio, error) {
+	format, err := SniffFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatUnknown {
+		format = FormatFromExt(filepath.Ext(path))
+	}
+
+	var audio *Audio
+
+	switch format {
+	case FormatMP3:
+		audio, err = decodeFileWith(path, DecodeMP3)
+	case FormatWAV:
+		audio, err = decodeWAVFile(path)
+	case FormatFLAC:
+		audio, err = decodeFileWith(path, DecodeFLAC)
+	case FormatOGG:
+		audio, err = decodeFileWith(path, DecodeOGG)
+	case FormatOpus:
+		err = errNoNativeOpus
+	case FormatMatroska, FormatWebM:
+		audio, err = decodeFileWith(path, DecodeMatroska)
+	case FormatMP4:
+		err = probeMP4Audio(path)
+		if err != errNeedsFFmpeg {
+			return nil, fmt.Errorf("failed to decode %s: %v", filepath.Base(path), err)
+		}
+	case FormatAAC:
+		err = errNeedsFFmpeg // raw ADTS streams have no container to probe
+	default:
+		err = fmt.Errorf("no native decoder for %q files", filepath.Ext(path))
+	}
+
+	if err == nil {
+		return toStandardRate(audio)
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	if !FFmpegAvailable() {
+		return nil, fmt.Errorf("failed to decode %s: %v", filepath.Base(path), err)
+	}
+
+	audio, ffmpegErr := decodeWithFFmpeg(ctx, path)
+	if ffmpegErr != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v (ffmpeg fallback: %v)", filepath.Base(path), err, ffmpegErr)
+	}
+
+	return audio, nil
+}
+
+// decodeFileWith opens path and decodes it with decodeFn.
+func decodeFileWith(path string, decodeFn func(io.Reader) (*Audio, error)) (*Audio, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodeFn(f)
+}
+
+// toStandardRate resamples audio to the rate the fingerprinting pipeline expects.
+func toStandardRate(audio *Audio) (*Audio, error) {
+	if audio.SampleRate == wav.StandardSampleRate {
+		return audio, nil
+	}
+
+	samples, err := wav.Resample(audio.Samples, audio.SampleRate, wav.StandardSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resample audio: %v", err)
+	}
+
+	return newAudio(samples, wav.StandardSampleRate, audio.Channels), nil
+}
+
+// newAudio builds an Audio value from mono samples.
+func newAudio(samples []float64, sampleRate, channels int) *Audio {
+	return &Audio{
+		Samples:    samples,
+		SampleRate: sampleRate,
+		Channels:   channels,
+		Duration:   float64(len(samples)) / float64(sampleRate),
+	}
+}
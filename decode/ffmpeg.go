@@ -0,0 +1,98 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"song-recognition/wav"
+)
+
+// FFmpegAvailable reports whether the ffmpeg binary can be found on PATH.
+func FFmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// decodeWithFFmpeg asks FFmpeg to write raw mono s16le PCM to stdout.
+func decodeWithFFmpeg(ctx context.Context, path string) (*Audio, error) {
+	return runFFmpeg(ctx, path, nil)
+}
+
+// decodeStreamWithFFmpeg pipes r into FFmpeg's stdin and decodes it.
+func decodeStreamWithFFmpeg(ctx context.Context, r io.Reader) (*Audio, error) {
+	return runFFmpeg(ctx, "pipe:0", r)
+}
+
+// decodeSpooledWithFFmpeg writes r to a temporary file for FFmpeg to
+// decode. MP4 files often keep their index at the end, which FFmpeg can't
+// seek to when reading from a pipe.
+func decodeSpooledWithFFmpeg(ctx context.Context, r io.Reader, format Format) (*Audio, error) {
+	path, err := spool(r, format)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	return decodeWithFFmpeg(ctx, path)
+}
+
+// spool writes r to a temporary file with the extension of format and
+// returns its path. The caller removes it.
+func spool(r io.Reader, format Format) (string, error) {
+	f, err := os.CreateTemp("", "seektune-*"+format.Ext())
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to read audio stream: %v", err)
+	}
+
+	return f.Name(), nil
+}
+
+// ffmpegArgs has FFmpeg decode the audio of input to raw mono s16le PCM on
+// stdout, ignoring any video.
+func ffmpegArgs(input string) []string {
+	return []string{
+		"-v", "error",
+		"-i", input,
+		"-vn",
+		"-f", "s16le",
+		"-acodec", "pcm_s16le",
+		"-ar", fmt.Sprint(wav.StandardSampleRate),
+		"-ac", "1",
+		"pipe:1",
+	}
+}
+
+func runFFmpeg(ctx context.Context, input string, stdin io.Reader) (*Audio, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(input)...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v, output %v", err, stderr.String())
+	}
+
+	pcm := stdout.Bytes()
+	if len(pcm)%2 != 0 {
+		pcm = pcm[:len(pcm)-1]
+	}
+
+	samples, err := wav.WavBytesToSamples(pcm)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAudio(samples, wav.StandardSampleRate, 1), nil
+}
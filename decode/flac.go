@@ -0,0 +1,65 @@
+package decode
+
+import (
+	"fmt"
+	"io"
+	"song-recognition/wav"
+
+	"github.com/mewkiz/flac"
+)
+
+// flacPreallocSamples caps the samples allocated up front, 10 minutes at
+// 44.1 kHz.
+const flacPreallocSamples = 10 * 60 * 44100
+
+// DecodeFLAC decodes a FLAC stream using a pure Go decoder.
+func DecodeFLAC(r io.Reader) (*Audio, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FLAC stream: %v", err)
+	}
+	defer stream.Close()
+
+	info := stream.Info
+	if info.BitsPerSample == 0 || info.NChannels == 0 {
+		return nil, fmt.Errorf("invalid FLAC stream info")
+	}
+
+	limit := maxSamples(int(info.SampleRate))
+	if limit > 0 && info.NSamples > uint64(limit) {
+		return nil, fmt.Errorf("%w: FLAC stream of %d samples", ErrTooLong, info.NSamples)
+	}
+
+	channels := int(info.NChannels)
+	scale := float64(int64(1) << (info.BitsPerSample - 1))
+
+	// FLAC uses the same channel order as WAVE_FORMAT_EXTENSIBLE
+	weights := wav.ChannelWeights(channels, 0)
+
+	// The sample count of STREAMINFO is only trusted for a few minutes of
+	// audio; longer streams grow as they are decoded
+	samples := make([]float64, 0, min(info.NSamples, flacPreallocSamples))
+	for {
+		frame, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode FLAC frame: %v", err)
+		}
+
+		// Downmix each inter-channel sample to mono
+		for i := 0; i < int(frame.BlockSize); i++ {
+			var sum float64
+			for c, subframe := range frame.Subframes {
+				sum += weights[c] * float64(subframe.Samples[i])
+			}
+			samples = append(samples, sum/scale)
+		}
+		if limit > 0 && len(samples) > limit {
+			return nil, fmt.Errorf("%w: FLAC stream longer than %d samples", ErrTooLong, limit)
+		}
+	}
+
+	return newAudio(samples, int(info.SampleRate), channels), nil
+}
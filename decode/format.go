@@ -0,0 +1,164 @@
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// Format identifies an audio container or codec.
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatWAV     Format = "wav"
+	FormatMP3     Format = "mp3"
+	FormatFLAC    Format = "flac"
+	FormatOGG     Format = "ogg"
+	FormatOpus    Format = "opus"
+	FormatMP4     Format = "m4a"
+	FormatAAC     Format = "aac"
+	// Video containers; only their audio track is decoded
+	FormatMatroska Format = "mkv"
+	FormatWebM     Format = "webm"
+)
+
+// ErrUnknownFormat is returned when content can't be identified as audio.
+var ErrUnknownFormat = errors.New("unrecognized audio format")
+
+// SniffLen is the number of leading bytes Sniff needs to identify a format.
+const SniffLen = 512
+
+// Ext returns the conventional file extension for the format, including the dot.
+func (f Format) Ext() string {
+	if f == FormatUnknown {
+		return ""
+	}
+	return "." + string(f)
+}
+
+// Sniff identifies the audio format from the leading bytes of a file using
+// the magic numbers of each container.
+func Sniff(header []byte) Format {
+	switch {
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return FormatWAV
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(header, []byte("OggS")):
+		// The identification header of the first logical stream follows the page header
+		if bytes.Contains(header[:min(len(header), 128)], []byte("OpusHead")) {
+			return FormatOpus
+		}
+		return FormatOGG
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return FormatMP4
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// The EBML header names the document type
+		if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+			return FormatWebM
+		}
+		return FormatMatroska
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return FormatMP3
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xF6 == 0xF0:
+		// ADTS sync word with layer bits set to 00
+		return FormatAAC
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0:
+		// MPEG audio frame sync with a non-reserved layer
+		return FormatMP3
+	}
+
+	return FormatUnknown
+}
+
+// SniffFile reads the start of the file at path and identifies its format.
+func SniffFile(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, SniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, err
+	}
+
+	return Sniff(header[:n]), nil
+}
+
+// FormatFromContentType maps an HTTP Content-Type to an audio format.
+func FormatFromContentType(contentType string) Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatUnknown
+	}
+
+	switch mediaType {
+	case "audio/wav", "audio/wave", "audio/x-wav", "audio/vnd.wave":
+		return FormatWAV
+	case "audio/mpeg", "audio/mp3":
+		return FormatMP3
+	case "audio/flac", "audio/x-flac":
+		return FormatFLAC
+	case "audio/ogg", "audio/vorbis", "application/ogg":
+		return FormatOGG
+	case "audio/opus":
+		return FormatOpus
+	case "audio/mp4", "audio/x-m4a", "audio/m4a", "video/mp4", "video/quicktime", "video/x-m4v":
+		return FormatMP4
+	case "video/x-matroska", "audio/x-matroska":
+		return FormatMatroska
+	case "video/webm", "audio/webm":
+		return FormatWebM
+	case "audio/aac", "audio/aacp", "audio/x-aac":
+		return FormatAAC
+	}
+
+	return FormatUnknown
+}
+
+// IsNonAudioContentType reports whether the Content-Type clearly describes
+// something other than audio, such as an HTML error page.
+func IsNonAudioContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasPrefix(mediaType, "image/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml"
+}
+
+// FormatFromExt maps a file extension (with or without the dot) to a format.
+func FormatFromExt(ext string) Format {
+	switch strings.TrimPrefix(strings.ToLower(ext), ".") {
+	case "wav", "wave":
+		return FormatWAV
+	case "mp3":
+		return FormatMP3
+	case "flac":
+		return FormatFLAC
+	case "ogg", "oga":
+		return FormatOGG
+	case "opus":
+		return FormatOpus
+	case "m4a", "mp4", "m4v", "mov":
+		return FormatMP4
+	case "mkv", "mka":
+		return FormatMatroska
+	case "webm":
+		return FormatWebM
+	case "aac":
+		return FormatAAC
+	}
+
+	return FormatUnknown
+}
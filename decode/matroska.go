@@ -0,0 +1,556 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"song-recognition/wav"
+
+	"github.com/jfreymuth/vorbis"
+)
+
+// EBML element IDs of the parts of a Matroska/WebM file we read. IDs keep
+// their length marker bits, as in the specification.
+const (
+	mkvEBML              = 0x1A45DFA3
+	mkvSegment           = 0x18538067
+	mkvTracks            = 0x1654AE6B
+	mkvTrackEntry        = 0xAE
+	mkvTrackNumber       = 0xD7
+	mkvTrackType         = 0x83
+	mkvCodecID           = 0x86
+	mkvCodecPrivate      = 0x63A2
+	mkvContentEncodings  = 0x6D80
+	mkvAudio             = 0xE1
+	mkvSamplingFrequency = 0xB5
+	mkvChannels          = 0x9F
+	mkvBitDepth          = 0x6264
+	mkvCluster           = 0x1F43B675
+	mkvBlockGroup        = 0xA0
+	mkvBlock             = 0xA1
+	mkvSimpleBlock       = 0xA3
+)
+
+const mkvTrackTypeAudio = 2
+
+// mkvMaxElementSize caps the size of the elements held in memory, so a
+// corrupt size can't trigger a huge allocation. Media data is streamed
+// block by block and blocks are far smaller.
+const mkvMaxElementSize = 16 << 20
+
+// mkvTrack describes a track declared in a Matroska container.
+type mkvTrack struct {
+	Number       uint64
+	Type         uint64
+	Codec        string // codec ID, e.g. "A_VORBIS" or "V_VP9"
+	CodecPrivate []byte
+	SampleRate   int
+	Channels     int
+	BitDepth     int
+	Encoded      bool // frames are compressed or encrypted by the container
+}
+
+// mkvReader reads the EBML element stream of a Matroska file. Master
+// elements holding the parts we need are entered rather than skipped, so
+// their children are read in line; this also handles the unknown-size
+// segments and clusters of live streams.
+type mkvReader struct {
+	r *bufio.Reader
+}
+
+// readVint reads a variable-length EBML integer. With keepMarker set the
+// length marker bit is kept, as it is for element IDs. It also reports
+// whether all value bits are set, which marks an unknown size.
+func (m *mkvReader) readVint(keepMarker bool) (uint64, bool, error) {
+	first, err := m.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, false, errors.New("invalid EBML variable-length integer")
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= 0xFF >> length
+	}
+	allOnes := value == 0xFF>>length
+
+	for i := 1; i < length; i++ {
+		b, err := m.r.ReadByte()
+		if err != nil {
+			return 0, false, noEOF(err)
+		}
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	return value, allOnes, nil
+}
+
+// next reads the header of the next element. Unknown sizes are reported
+// as -1.
+func (m *mkvReader) next() (uint64, int64, error) {
+	id, _, err := m.readVint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, unknown, err := m.readVint(false)
+	if err != nil {
+		return 0, 0, noEOF(err)
+	}
+	if unknown {
+		return id, -1, nil
+	}
+	if size > math.MaxInt64 {
+		return 0, 0, errors.New("invalid EBML element size")
+	}
+
+	return id, int64(size), nil
+}
+
+// body reads the body of an element of the given size.
+func (m *mkvReader) body(size int64) ([]byte, error) {
+	if size < 0 || size > mkvMaxElementSize {
+		return nil, fmt.Errorf("invalid EBML element size %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(m.r, data); err != nil {
+		return nil, noEOF(err)
+	}
+	return data, nil
+}
+
+// noEOF turns an EOF in the middle of an element into ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (m *mkvReader) skip(size int64) error {
+	if size < 0 {
+		return errors.New("element of unknown size can't be skipped")
+	}
+	if _, err := m.r.Discard(int(size)); err != nil {
+		return noEOF(err)
+	}
+	return nil
+}
+
+// DecodeMatroska decodes the first audio track of a Matroska (MKV, MKA) or
+// WebM file, as found in video files. Vorbis, MP3 and PCM tracks are
+// decoded natively. Opus and AAC, the most common codecs besides those,
+// have no pure Go decoder and are left to FFmpeg.
+func DecodeMatroska(r io.Reader) (*Audio, error) {
+	m := &mkvReader{r: bufio.NewReader(r)}
+
+	id, size, err := m.next()
+	if err != nil || id != mkvEBML {
+		return nil, errors.New("not a Matroska container")
+	}
+	if err := m.skip(size); err != nil {
+		return nil, err
+	}
+
+	var (
+		tracks []mkvTrack
+		audio  *mkvTrack // the track being decoded, once clusters start
+		sink   *mkvSink
+	)
+
+	for {
+		id, size, err := m.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Matroska container: %v", err)
+		}
+
+		var track *mkvTrack // the track whose entry is being read
+		if n := len(tracks); n > 0 {
+			track = &tracks[n-1]
+		}
+
+		switch id {
+		case mkvSegment, mkvTracks, mkvAudio, mkvBlockGroup:
+			// Read the children in line
+
+		case mkvTrackEntry:
+			tracks = append(tracks, mkvTrack{Channels: 1})
+
+		case mkvCluster:
+			if audio != nil {
+				break
+			}
+			for i := range tracks {
+				if tracks[i].Type == mkvTrackTypeAudio {
+					audio = &tracks[i]
+					break
+				}
+			}
+			if audio == nil {
+				return nil, errors.New("Matroska container has no audio track")
+			}
+			if audio.Encoded {
+				return nil, errors.New("Matroska audio track uses content encoding, which isn't supported natively")
+			}
+			if sink, err = newMKVSink(audio); err != nil {
+				return nil, err
+			}
+
+		case mkvSimpleBlock, mkvBlock:
+			// Skip the blocks of other tracks, such as video, unread
+			head, _ := m.r.Peek(int(min(size, 8)))
+			number, _, err := parseVint(head)
+			if sink == nil || err != nil || number != sink.track.Number {
+				if err := m.skip(size); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			data, err := m.body(size)
+			if err != nil {
+				return nil, err
+			}
+			if err := sink.block(data); err != nil {
+				return nil, err
+			}
+
+		case mkvTrackNumber, mkvTrackType, mkvChannels, mkvBitDepth:
+			data, err := m.body(size)
+			if err != nil {
+				return nil, err
+			}
+			if track == nil || len(data) > 8 {
+				continue
+			}
+			var value uint64
+			for _, b := range data {
+				value = value<<8 | uint64(b)
+			}
+			switch id {
+			case mkvTrackNumber:
+				track.Number = value
+			case mkvTrackType:
+				track.Type = value
+			case mkvChannels:
+				track.Channels = int(value)
+			case mkvBitDepth:
+				track.BitDepth = int(value)
+			}
+
+		case mkvSamplingFrequency:
+			data, err := m.body(size)
+			if err != nil {
+				return nil, err
+			}
+			if track == nil {
+				continue
+			}
+			switch len(data) {
+			case 4:
+				track.SampleRate = int(math.Float32frombits(binary.BigEndian.Uint32(data)))
+			case 8:
+				track.SampleRate = int(math.Float64frombits(binary.BigEndian.Uint64(data)))
+			}
+
+		case mkvCodecID, mkvCodecPrivate:
+			data, err := m.body(size)
+			if err != nil {
+				return nil, err
+			}
+			if track == nil {
+				continue
+			}
+			if id == mkvCodecID {
+				track.Codec = string(bytes.TrimRight(data, "\x00"))
+			} else {
+				track.CodecPrivate = data
+			}
+
+		case mkvContentEncodings:
+			if track != nil {
+				track.Encoded = true
+			}
+			if err := m.skip(size); err != nil {
+				return nil, err
+			}
+
+		default:
+			if err := m.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if sink == nil {
+		return nil, errors.New("Matroska container has no audio data")
+	}
+
+	return sink.audio()
+}
+
+// mkvSink decodes the frames of one audio track as its blocks are read.
+type mkvSink struct {
+	track   *mkvTrack
+	frame   func(frame []byte) error
+	samples []float64 // decoded mono samples
+	mp3     bytes.Buffer
+	rate    int
+}
+
+func newMKVSink(track *mkvTrack) (*mkvSink, error) {
+	s := &mkvSink{track: track, rate: track.SampleRate}
+
+	switch track.Codec {
+	case "A_VORBIS":
+		decoder, err := newMKVVorbisDecoder(track.CodecPrivate)
+		if err != nil {
+			return nil, err
+		}
+		s.rate = decoder.SampleRate()
+		channels := decoder.Channels()
+		buffer := make([]float32, decoder.BufferSize())
+		interleaved := make([]float64, len(buffer))
+
+		s.frame = func(frame []byte) error {
+			out, err := decoder.DecodeInto(frame, buffer)
+			if err != nil {
+				return fmt.Errorf("failed to decode Vorbis: %v", err)
+			}
+			for i, v := range out {
+				interleaved[i] = float64(v)
+			}
+			s.samples = append(s.samples, wav.Downmix(interleaved[:len(out)], channels)...)
+			return nil
+		}
+
+	case "A_MPEG/L3":
+		// MP3 frames are self-delimiting, so the track is decoded as one
+		// MP3 stream at the end
+		s.frame = func(frame []byte) error {
+			s.mp3.Write(frame)
+			return nil
+		}
+
+	case "A_PCM/INT/LIT", "A_PCM/FLOAT/IEEE":
+		format := wav.WaveFormatPCM
+		if track.Codec == "A_PCM/FLOAT/IEEE" {
+			format = wav.WaveFormatIEEEFloat
+		}
+		if track.BitDepth == 0 || track.Channels <= 0 {
+			return nil, errors.New("Matroska PCM track has no bit depth or channel count")
+		}
+		frameSize := track.BitDepth / 8 * track.Channels
+
+		s.frame = func(frame []byte) error {
+			interleaved, err := wav.BytesToSamples(frame[:len(frame)-len(frame)%frameSize], track.BitDepth, format)
+			if err != nil {
+				return err
+			}
+			s.samples = append(s.samples, wav.Downmix(interleaved, track.Channels)...)
+			return nil
+		}
+
+	default:
+		return nil, fmt.Errorf("no native decoder for Matroska %s audio", track.Codec)
+	}
+
+	return s, nil
+}
+
+// newMKVVorbisDecoder reads the three Vorbis headers from a track's codec
+// private data, where they are stored Xiph-laced.
+func newMKVVorbisDecoder(private []byte) (*vorbis.Decoder, error) {
+	headers, err := xiphLace(private)
+	if err != nil || len(headers) != 3 {
+		return nil, errors.New("invalid Vorbis headers in Matroska track")
+	}
+
+	var decoder vorbis.Decoder
+	for _, header := range headers {
+		if err := decoder.ReadHeader(header); err != nil {
+			return nil, fmt.Errorf("invalid Vorbis headers in Matroska track: %v", err)
+		}
+	}
+	return &decoder, nil
+}
+
+// xiphLace splits data made of a packet count minus one, the sizes of all
+// packets but the last in Xiph lacing, and the packets themselves.
+func xiphLace(data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty Xiph lacing")
+	}
+
+	count := int(data[0]) + 1
+	pos := 1
+
+	sizes := make([]int, count)
+	total := 0
+	for i := 0; i < count-1; i++ {
+		for {
+			if pos >= len(data) {
+				return nil, errors.New("truncated Xiph lacing")
+			}
+			b := data[pos]
+			pos++
+			sizes[i] += int(b)
+			if b != 0xFF {
+				break
+			}
+		}
+		total += sizes[i]
+	}
+
+	if pos+total > len(data) {
+		return nil, errors.New("truncated Xiph lacing")
+	}
+	sizes[count-1] = len(data) - pos - total
+
+	packets := make([][]byte, count)
+	for i, size := range sizes {
+		packets[i] = data[pos : pos+size]
+		pos += size
+	}
+	return packets, nil
+}
+
+// block decodes the frames of a SimpleBlock or Block of the sink's track.
+func (s *mkvSink) block(data []byte) error {
+	_, length, err := parseVint(data)
+	if err != nil {
+		return errors.New("invalid Matroska block")
+	}
+
+	// track number, timecode(2), flags(1)
+	if length+3 > len(data) {
+		return errors.New("invalid Matroska block")
+	}
+	flags := data[length+2]
+	payload := data[length+3:]
+
+	frames, err := unlace(payload, flags&0x06)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := s.frame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audio returns the samples decoded from all blocks.
+func (s *mkvSink) audio() (*Audio, error) {
+	if s.mp3.Len() > 0 {
+		return DecodeMP3(&s.mp3)
+	}
+	if s.rate <= 0 {
+		return nil, errors.New("Matroska audio track has no sampling frequency")
+	}
+	return newAudio(s.samples, s.rate, s.track.Channels), nil
+}
+
+// parseVint parses a variable-length EBML integer without its length
+// marker from the start of data, returning it and its length in bytes.
+func parseVint(data []byte) (uint64, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, errors.New("invalid EBML variable-length integer")
+	}
+
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > len(data) {
+		return 0, 0, errors.New("truncated EBML variable-length integer")
+	}
+
+	value := uint64(data[0] & (0xFF >> length))
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+// unlace splits the payload of a block into frames according to its
+// lacing: none, Xiph, fixed-size or EBML.
+func unlace(payload []byte, lacing byte) ([][]byte, error) {
+	switch lacing {
+	case 0x00:
+		return [][]byte{payload}, nil
+	case 0x02:
+		return xiphLace(payload)
+	}
+
+	invalid := errors.New("invalid Matroska block lacing")
+	if len(payload) == 0 {
+		return nil, invalid
+	}
+	count := int(payload[0]) + 1
+	data := payload[1:]
+
+	sizes := make([]int, count)
+	if lacing == 0x04 {
+		if len(data)%count != 0 {
+			return nil, invalid
+		}
+		for i := range sizes {
+			sizes[i] = len(data) / count
+		}
+	} else {
+		// EBML lacing: the first size is a vint and the others are signed
+		// differences to the size before them
+		total := 0
+		for i := 0; i < count-1; i++ {
+			value, length, err := parseVint(data)
+			if err != nil {
+				return nil, invalid
+			}
+			data = data[length:]
+
+			if i == 0 {
+				sizes[i] = int(value)
+			} else {
+				bias := int64(1)<<(7*length-1) - 1
+				sizes[i] = sizes[i-1] + int(int64(value)-bias)
+			}
+			if sizes[i] < 0 {
+				return nil, invalid
+			}
+			total += sizes[i]
+		}
+		if total > len(data) {
+			return nil, invalid
+		}
+		sizes[count-1] = len(data) - total
+	}
+
+	frames := make([][]byte, count)
+	pos := 0
+	for i, size := range sizes {
+		if pos+size > len(data) {
+			return nil, invalid
+		}
+		frames[i] = data[pos : pos+size]
+		pos += size
+	}
+	return frames, nil
+}
@@ -0,0 +1,34 @@
+package decode
+
+import (
+	"fmt"
+	"io"
+	"song-recognition/wav"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// DecodeMP3 decodes an MP3 stream using a pure Go decoder.
+func DecodeMP3(r io.Reader) (*Audio, error) {
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MP3 decoder: %v", err)
+	}
+
+	// go-mp3 always produces 16-bit little-endian stereo
+	pcm, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MP3: %v", err)
+	}
+
+	if len(pcm)%4 != 0 {
+		pcm = pcm[:len(pcm)-len(pcm)%4]
+	}
+
+	samples, err := wav.WavBytesToSamples(pcm)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAudio(wav.Downmix(samples, 2), decoder.SampleRate(), 2), nil
+}
@@ -0,0 +1,208 @@
+package decode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MP4Track describes a single track found in an MP4/M4A container.
+type MP4Track struct {
+	Handler    string // "soun" for audio tracks, "vide" for video tracks
+	Codec      string // sample entry type, e.g. "mp4a"
+	SampleRate int
+	Channels   int
+	Duration   float64
+}
+
+var errNotMP4 = errors.New("not an MP4 container")
+
+// ParseMP4 walks the box structure of an MP4/M4A container and returns
+// the tracks it declares. Media data is not decoded.
+func ParseMP4(r io.ReadSeeker) ([]MP4Track, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &mp4Parser{r: r, current: -1}
+	if err := p.walk(0, end); err != nil {
+		return nil, err
+	}
+
+	if !p.sawFtyp {
+		return nil, errNotMP4
+	}
+
+	return p.tracks, nil
+}
+
+type mp4Parser struct {
+	r       io.ReadSeeker
+	tracks  []MP4Track
+	current int // index of the track being parsed, -1 outside a trak box
+	sawFtyp bool
+}
+
+func (p *mp4Parser) walk(start, end int64) error {
+	offset := start
+	for offset+8 <= end {
+		if _, err := p.r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		var header [8]byte
+		if _, err := io.ReadFull(p.r, header[:]); err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // box extends to the end of its parent
+			size = end - offset
+		case 1: // 64-bit size follows the type
+			var largeSize [8]byte
+			if _, err := io.ReadFull(p.r, largeSize[:]); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(largeSize[:]))
+			headerSize = 16
+		}
+
+		if size < headerSize || offset+size > end {
+			if offset == start && start == 0 {
+				return errNotMP4
+			}
+			return fmt.Errorf("invalid size for %q box", boxType)
+		}
+
+		bodyStart, bodyEnd := offset+headerSize, offset+size
+
+		var err error
+		switch boxType {
+		case "ftyp":
+			p.sawFtyp = true
+		case "moov", "mdia", "minf", "stbl":
+			err = p.walk(bodyStart, bodyEnd)
+		case "trak":
+			p.tracks = append(p.tracks, MP4Track{})
+			p.current = len(p.tracks) - 1
+			err = p.walk(bodyStart, bodyEnd)
+			p.current = -1
+		case "mdhd", "hdlr", "stsd":
+			if p.current >= 0 {
+				err = p.parseTrackBox(boxType, bodyEnd-bodyStart)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		offset += size
+	}
+
+	return nil
+}
+
+// parseTrackBox parses the track-level boxes we care about. The reader is
+// positioned at the start of the box body.
+func (p *mp4Parser) parseTrackBox(boxType string, bodySize int64) error {
+	if bodySize > 1<<20 {
+		bodySize = 1 << 20
+	}
+
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return err
+	}
+
+	track := &p.tracks[p.current]
+
+	switch boxType {
+	case "mdhd":
+		// version(1) flags(3), then times whose width depends on the version
+		if len(body) < 24 {
+			return errors.New("truncated mdhd box")
+		}
+		var timescale, duration uint64
+		if body[0] == 1 {
+			if len(body) < 36 {
+				return errors.New("truncated mdhd box")
+			}
+			timescale = uint64(binary.BigEndian.Uint32(body[20:24]))
+			duration = binary.BigEndian.Uint64(body[24:32])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(body[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+		}
+		if timescale > 0 {
+			track.Duration = float64(duration) / float64(timescale)
+		}
+
+	case "hdlr":
+		// version/flags(4) pre_defined(4) handler_type(4)
+		if len(body) < 12 {
+			return errors.New("truncated hdlr box")
+		}
+		track.Handler = string(body[8:12])
+
+	case "stsd":
+		// version/flags(4) entry_count(4), first entry: size(4) type(4) ...
+		if len(body) < 16 {
+			return errors.New("truncated stsd box")
+		}
+		entry := body[8:]
+		track.Codec = string(entry[4:8])
+
+		// Audio sample entry: reserved(6) data_ref(2) version(2) revision(2)
+		// vendor(4) channels(2) sample_size(2) compression(2) packet(2) rate(4)
+		if track.Handler == "soun" && len(entry) >= 36 {
+			track.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+			track.SampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+		}
+	}
+
+	return nil
+}
+
+// AudioTrack returns the first audio track in tracks.
+func AudioTrack(tracks []MP4Track) (MP4Track, bool) {
+	for _, track := range tracks {
+		if track.Handler == "soun" {
+			return track, true
+		}
+	}
+	return MP4Track{}, false
+}
+
+// probeMP4Audio checks that the file is an MP4 container with an audio
+// track, which is all a video file needs to be fingerprinted. There is no
+// pure Go decoder for the codecs found in MP4 files, such as AAC, so
+// decoding itself is left to FFmpeg.
+func probeMP4Audio(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tracks, err := ParseMP4(f)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := AudioTrack(tracks); !ok {
+		return errors.New("MP4 container has no audio track")
+	}
+
+	return errNeedsFFmpeg
+}
+
+// errNeedsFFmpeg marks formats that are recognized but can only be decoded
+// by FFmpeg.
+var errNeedsFFmpeg = errors.New("M4A/AAC audio can only be decoded with ffmpeg")
@@ -0,0 +1,39 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"song-recognition/wav"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// errNoNativeOpus is returned for Ogg Opus streams, which have no pure Go
+// decoder; DecodeFile falls back to FFmpeg for them.
+var errNoNativeOpus = errors.New("audio of Ogg Opus streams can only be decoded with ffmpeg")
+
+// DecodeOGG decodes an Ogg Vorbis stream using a pure Go decoder.
+func DecodeOGG(r io.Reader) (*Audio, error) {
+	br := bufio.NewReader(r)
+
+	// The first Ogg page carries the codec identification header
+	head, _ := br.Peek(64)
+	if bytes.Contains(head, []byte("OpusHead")) {
+		return nil, errNoNativeOpus
+	}
+
+	data, format, err := oggvorbis.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Ogg Vorbis: %v", err)
+	}
+
+	interleaved := make([]float64, len(data))
+	for i, v := range data {
+		interleaved[i] = float64(v)
+	}
+
+	return newAudio(wav.Downmix(interleaved, format.Channels), format.SampleRate, format.Channels), nil
+}
@@ -0,0 +1,176 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"song-recognition/wav"
+	"strconv"
+)
+
+// ErrNotAudio is returned for files that hold no audio, such as an HTML
+// error page saved in place of a song.
+var ErrNotAudio = errors.New("file holds no audio")
+
+// Probe describes the encoded audio of a file. ProbeFile fills in what can
+// be learnt without decoding it and Measure the rest.
+type Probe struct {
+	Format   Format
+	Codec    string  // such as "mp3" or "pcm_s16le"
+	Size     int64   // of the file, in bytes
+	Duration float64 // seconds, zero until known
+	Bitrate  int     // average bits per second, zero until known
+}
+
+// nativeCodecs name the codec of every format probed without ffprobe.
+var nativeCodecs = map[Format]string{
+	FormatWAV:  "pcm",
+	FormatMP3:  "mp3",
+	FormatFLAC: "flac",
+	FormatOGG:  "vorbis",
+	FormatOpus: "opus",
+	FormatMP4:  "aac",
+	FormatAAC:  "aac",
+}
+
+// FFprobeAvailable reports whether the ffprobe binary can be found on PATH.
+func FFprobeAvailable() bool {
+	_, err := exec.LookPath("ffprobe")
+	return err == nil
+}
+
+// ProbeFile checks that the file at path holds audio before it is decoded.
+// ffprobe is asked when it is installed, so any file FFmpeg can decode is
+// accepted; otherwise the format must be one of those Sniff knows. Errors
+// for files that aren't audio wrap ErrNotAudio.
+func ProbeFile(ctx context.Context, path string) (Probe, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Probe{}, err
+	}
+	if stat.Size() == 0 {
+		return Probe{}, fmt.Errorf("%w: file is empty", ErrNotAudio)
+	}
+
+	format, err := SniffFile(path)
+	if err != nil {
+		return Probe{}, err
+	}
+
+	probe := Probe{Format: format, Size: stat.Size()}
+
+	if FFprobeAvailable() {
+		err = probeWithFFprobe(ctx, path, &probe)
+	} else {
+		err = probeNatively(path, &probe)
+	}
+	if err != nil {
+		return Probe{}, err
+	}
+
+	return probe, nil
+}
+
+// ProbeData describes data, a whole audio file held in memory, from its
+// format. Codec is empty for formats Sniff doesn't know, which are left to
+// the decoder to reject.
+func ProbeData(data []byte) Probe {
+	format := Sniff(data[:min(len(data), SniffLen)])
+	return Probe{Format: format, Codec: nativeCodecs[format], Size: int64(len(data))}
+}
+
+// probeNatively fills in probe from the format of the file at path.
+func probeNatively(path string, probe *Probe) error {
+	switch probe.Format {
+	case FormatUnknown:
+		return fmt.Errorf("%w: %v", ErrNotAudio, ErrUnknownFormat)
+	case FormatMP4:
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		tracks, err := ParseMP4(f)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrNotAudio, err)
+		}
+		track, ok := AudioTrack(tracks)
+		if !ok {
+			return fmt.Errorf("%w: MP4 container has no audio track", ErrNotAudio)
+		}
+		probe.Duration = track.Duration
+	}
+
+	probe.Codec = nativeCodecs[probe.Format]
+	if probe.Duration > 0 {
+		probe.Bitrate = int(float64(probe.Size*8) / probe.Duration)
+	}
+	return nil
+}
+
+// probeWithFFprobe fills in probe from the first audio stream ffprobe finds
+// in the file at path.
+func probeWithFFprobe(ctx context.Context, path string, probe *Probe) error {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("%w: ffprobe can't read it: %v", ErrNotAudio, err)
+	}
+
+	var metadata wav.FFmpegMetadata
+	if err := json.Unmarshal(out.Bytes(), &metadata); err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	for _, stream := range metadata.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+
+		probe.Codec = stream.CodecName
+		probe.Duration = parseFloat(stream.Duration)
+		if probe.Duration == 0 {
+			probe.Duration = parseFloat(metadata.Format.Duration)
+		}
+		probe.Bitrate = int(parseFloat(stream.BitRate))
+		if probe.Bitrate == 0 {
+			probe.Bitrate = int(parseFloat(metadata.Format.BitRate))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: no audio stream", ErrNotAudio)
+}
+
+// parseFloat parses a number printed by ffprobe, zero if it printed none.
+func parseFloat(s string) float64 {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// Measure completes probe with audio decoded from the file it describes.
+// The decoded duration is the accurate one, and the bitrate is averaged over
+// it unless the probe found one.
+func (probe *Probe) Measure(audio *Audio) error {
+	if len(audio.Samples) == 0 || audio.Duration <= 0 {
+		return fmt.Errorf("%w: decoded to no samples", ErrNotAudio)
+	}
+
+	probe.Duration = audio.Duration
+	if probe.Bitrate == 0 && probe.Size > 0 {
+		probe.Bitrate = int(float64(probe.Size*8) / probe.Duration)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"song-recognition/wav"
+)
+
+// Decode decodes an audio stream without touching the filesystem. The format
+// is identified from the stream's magic bytes. Formats without a native
+// decoder are piped through FFmpeg when it is available on PATH; since the
+// stream can only be read once, a failing native decoder is not retried,
+// except for video containers, which are buffered first.
+func Decode(ctx context.Context, r io.Reader) (*Audio, error) {
+	br := bufio.NewReaderSize(r, SniffLen)
+
+	header, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read audio stream: %v", err)
+	}
+	if len(header) == 0 {
+		return nil, fmt.Errorf("audio stream is empty")
+	}
+
+	format := Sniff(header)
+
+	var audio *Audio
+
+	switch format {
+	case FormatMP3:
+		audio, err = DecodeMP3(br)
+	case FormatWAV:
+		audio, err = DecodeWAV(br)
+	case FormatFLAC:
+		audio, err = DecodeFLAC(br)
+	case FormatOGG:
+		audio, err = DecodeOGG(br)
+	case FormatMatroska, FormatWebM:
+		// Held in memory so that audio without a native decoder, such as
+		// Opus, can still be handed to FFmpeg
+		data, readErr := io.ReadAll(br)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read audio stream: %v", readErr)
+		}
+		audio, err = DecodeMatroska(bytes.NewReader(data))
+		if err != nil && FFmpegAvailable() {
+			return decodeStreamWithFFmpeg(ctx, bytes.NewReader(data))
+		}
+	case FormatMP4:
+		if !FFmpegAvailable() {
+			return nil, fmt.Errorf("no native decoder for %s streams", format)
+		}
+		return decodeSpooledWithFFmpeg(ctx, br, format)
+	default:
+		if !FFmpegAvailable() {
+			if format == FormatUnknown {
+				return nil, ErrUnknownFormat
+			}
+			return nil, fmt.Errorf("no native decoder for %s streams", format)
+		}
+
+		return decodeStreamWithFFmpeg(ctx, br)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return toStandardRate(audio)
+}
+
+// DecodeClip decodes a short clip from r like Decode, but stops with
+// ErrTooLong as soon as more than maxSeconds of audio has been decoded, so
+// a long recording is rejected before it is held in memory.
+func DecodeClip(ctx context.Context, r io.Reader, maxSeconds int) (*Audio, error) {
+	br := bufio.NewReaderSize(r, SniffLen)
+	header, _ := br.Peek(SniffLen)
+
+	var stream *Stream
+	var err error
+
+	switch format := Sniff(header); {
+	case (format == FormatMatroska || format == FormatWebM) && !FFmpegAvailable():
+		// Only decoded natively from memory, which the caller bounds
+		audio, err := Decode(ctx, br)
+		if err == nil && audio.Duration > float64(maxSeconds) {
+			return nil, fmt.Errorf("%w: clip is %.1fs long", ErrTooLong, audio.Duration)
+		}
+		return audio, err
+	case format == FormatMP4 && FFmpegAvailable():
+		path, spoolErr := spool(br, format)
+		if spoolErr != nil {
+			return nil, spoolErr
+		}
+		defer os.Remove(path)
+		stream, err = NewFFmpegStream(ctx, path)
+	default:
+		stream, err = NewStream(ctx, br, FormatUnknown)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	limit := maxSeconds * wav.StandardSampleRate
+	var samples []float64
+	chunk := make([]float64, streamBlockFrames)
+	for {
+		n, err := stream.ReadSamples(chunk)
+		samples = append(samples, chunk[:n]...)
+		if len(samples) > limit {
+			return nil, fmt.Errorf("%w: clip is longer than %ds", ErrTooLong, maxSeconds)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return newAudio(samples, wav.StandardSampleRate, 1), nil
+}
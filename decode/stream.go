@@ -0,0 +1,321 @@
+package decode
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"song-recognition/wav"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+)
+
+// streamBlockFrames is the number of frames a Stream decodes at a time.
+const streamBlockFrames = 8192
+
+// Stream decodes audio of unbounded length, such as an internet radio
+// station, a block at a time. Its samples are mono at
+// wav.StandardSampleRate; audio at another rate is resampled as it is
+// decoded.
+type Stream struct {
+	// Format is the format the stream was decoded as, or FormatUnknown if
+	// it was handed to FFmpeg unidentified.
+	Format Format
+
+	sampleRate int                       // rate of the source
+	next       func() ([]float64, error) // decodes the next block of mono samples at sampleRate
+	resampler  *wav.Resampler            // converts sampleRate to wav.StandardSampleRate
+	close      func() error
+	pending    []float64
+	err        error // error that ended the stream
+}
+
+// NewStream starts decoding r. The format is identified from the stream's
+// magic bytes, falling back to hint, which is typically derived from the
+// stream's Content-Type. MP3, Ogg Vorbis, FLAC and WAV streams are decoded
+// natively; anything else is piped through FFmpeg when it is available on
+// PATH.
+func NewStream(ctx context.Context, r io.Reader, hint Format) (*Stream, error) {
+	br := bufio.NewReaderSize(r, SniffLen)
+
+	header, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read audio stream: %v", err)
+	}
+	if len(header) == 0 {
+		return nil, fmt.Errorf("audio stream is empty")
+	}
+
+	format := Sniff(header)
+	if format == FormatUnknown {
+		format = hint
+	}
+
+	switch format {
+	case FormatMP3:
+		return newMP3Stream(br)
+	case FormatOGG:
+		return newOGGStream(br)
+	case FormatFLAC:
+		return newFLACStream(br)
+	case FormatWAV:
+		return newWAVStream(br)
+	}
+
+	if !FFmpegAvailable() {
+		if format == FormatUnknown {
+			return nil, ErrUnknownFormat
+		}
+		return nil, fmt.Errorf("no native decoder for %s streams", format)
+	}
+
+	stream, err := newFFmpegStream(ctx, "pipe:0", br)
+	if err != nil {
+		return nil, err
+	}
+	stream.Format = format
+	return stream, nil
+}
+
+// NewFFmpegStream has FFmpeg open and decode input, which may be any URL
+// FFmpeg understands, such as an HLS playlist.
+func NewFFmpegStream(ctx context.Context, input string) (*Stream, error) {
+	if !FFmpegAvailable() {
+		return nil, fmt.Errorf("ffmpeg is required to decode %s", input)
+	}
+	return newFFmpegStream(ctx, input, nil)
+}
+
+// ReadSamples fills dst with mono samples scaled to [-1, 1]. It returns the
+// number of samples read and io.EOF once the stream ends.
+func (s *Stream) ReadSamples(dst []float64) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		block, err := s.next()
+		if s.sampleRate != wav.StandardSampleRate {
+			if s.resampler == nil {
+				var resampleErr error
+				s.resampler, resampleErr = wav.NewResampler(s.sampleRate, wav.StandardSampleRate)
+				if resampleErr != nil {
+					return 0, fmt.Errorf("failed to resample audio: %v", resampleErr)
+				}
+			}
+			block = s.resampler.Resample(block)
+			if err == io.EOF {
+				block = append(block, s.resampler.Flush()...)
+			}
+		}
+		s.pending, s.err = block, err
+	}
+
+	n := copy(dst, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close stops decoding. It doesn't close the reader passed to NewStream.
+func (s *Stream) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+func newMP3Stream(r io.Reader) (*Stream, error) {
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MP3 decoder: %v", err)
+	}
+
+	// go-mp3 always produces 16-bit little-endian stereo
+	buf := make([]byte, streamBlockFrames*4)
+
+	return &Stream{
+		Format:     FormatMP3,
+		sampleRate: decoder.SampleRate(),
+		next: func() ([]float64, error) {
+			n, err := io.ReadFull(decoder, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to decode MP3: %v", err)
+			}
+
+			samples, convErr := wav.WavBytesToSamples(buf[:n-n%4])
+			if convErr != nil {
+				return nil, convErr
+			}
+			return wav.Downmix(samples, 2), err
+		},
+	}, nil
+}
+
+func newOGGStream(r io.Reader) (*Stream, error) {
+	reader, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Ogg Vorbis: %v", err)
+	}
+
+	channels := reader.Channels()
+	buf := make([]float32, streamBlockFrames*channels)
+	interleaved := make([]float64, len(buf))
+
+	return &Stream{
+		Format:     FormatOGG,
+		sampleRate: reader.SampleRate(),
+		next: func() ([]float64, error) {
+			n, err := reader.Read(buf)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to decode Ogg Vorbis: %v", err)
+			}
+
+			n -= n % channels
+			for i, v := range buf[:n] {
+				interleaved[i] = float64(v)
+			}
+			return wav.Downmix(interleaved[:n], channels), err
+		},
+	}, nil
+}
+
+func newFLACStream(r io.Reader) (*Stream, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FLAC stream: %v", err)
+	}
+
+	info := stream.Info
+	if info.BitsPerSample == 0 || info.NChannels == 0 {
+		return nil, fmt.Errorf("invalid FLAC stream info")
+	}
+
+	scale := float64(int64(1) << (info.BitsPerSample - 1))
+	weights := wav.ChannelWeights(int(info.NChannels), 0)
+	var buf []float64
+
+	return &Stream{
+		Format:     FormatFLAC,
+		sampleRate: int(info.SampleRate),
+		next: func() ([]float64, error) {
+			frame, err := stream.ParseNext()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode FLAC frame: %v", err)
+			}
+
+			buf = buf[:0]
+			for i := 0; i < int(frame.BlockSize); i++ {
+				var sum float64
+				for c, subframe := range frame.Subframes {
+					sum += weights[c] * float64(subframe.Samples[i])
+				}
+				buf = append(buf, sum/scale)
+			}
+			return buf, nil
+		},
+		close: stream.Close,
+	}, nil
+}
+
+func newWAVStream(r io.Reader) (*Stream, error) {
+	reader, err := wav.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]float64, streamBlockFrames)
+
+	return &Stream{
+		Format:     FormatWAV,
+		sampleRate: reader.SampleRate,
+		next: func() ([]float64, error) {
+			n, err := reader.ReadSamples(buf)
+			return buf[:n], err
+		},
+	}, nil
+}
+
+// newFFmpegStream runs FFmpeg on input until the stream ends or is closed,
+// reading its raw mono s16le PCM output as it is produced.
+func newFFmpegStream(ctx context.Context, input string, stdin io.Reader) (*Stream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(input)...)
+	cmd.Stdin = stdin
+
+	var stderr limitedBuffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	buf := make([]byte, streamBlockFrames*2)
+	exited := false
+
+	return &Stream{
+		sampleRate: wav.StandardSampleRate,
+		next: func() ([]float64, error) {
+			n, err := io.ReadFull(stdout, buf)
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				exited = true
+				if waitErr := cmd.Wait(); waitErr != nil && ctx.Err() == nil {
+					err = fmt.Errorf("ffmpeg failed: %v, output %v", waitErr, stderr.String())
+				} else {
+					err = io.EOF
+				}
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+
+			samples, convErr := wav.WavBytesToSamples(buf[:n-n%2])
+			if convErr != nil {
+				return nil, convErr
+			}
+			return samples, err
+		},
+		close: func() error {
+			cancel()
+			if !exited {
+				exited = true
+				cmd.Wait() // reap the killed process
+			}
+			return nil
+		},
+	}, nil
+}
+
+// limitedBuffer keeps the first few KB written to it, so that a
+// long-running FFmpeg can't grow its captured stderr without bound.
+type limitedBuffer struct {
+	data []byte
+}
+
+const limitedBufferSize = 4 << 10
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := limitedBufferSize - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return string(b.data)
+}
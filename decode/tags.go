@@ -0,0 +1,207 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tags are the descriptive fields embedded in an audio file.
+type Tags struct {
+	Title  string
+	Artist string
+	Album  string
+}
+
+// Empty reports whether none of the tags are set.
+func (t Tags) Empty() bool {
+	return t == Tags{}
+}
+
+// ReadTags reads the ID3 tags of an MP3 file: an ID3v2 tag at its start,
+// or else an ID3v1 tag at its end. Files without tags give empty Tags.
+func ReadTags(r io.ReadSeeker) (Tags, error) {
+	tags, err := readID3v2(r)
+	if err != nil || !tags.Empty() {
+		return tags, err
+	}
+	return readID3v1(r)
+}
+
+// ReadFileTags is ReadTags for the file at path.
+func ReadFileTags(path string) (Tags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer file.Close()
+	return ReadTags(file)
+}
+
+// id3v2Frames maps the IDs of the frames read, in ID3v2.3 and later and in
+// ID3v2.2, to the tag they hold.
+var id3v2Frames = map[string]func(*Tags) *string{
+	"TIT2": func(t *Tags) *string { return &t.Title },
+	"TPE1": func(t *Tags) *string { return &t.Artist },
+	"TALB": func(t *Tags) *string { return &t.Album },
+	"TT2":  func(t *Tags) *string { return &t.Title },
+	"TP1":  func(t *Tags) *string { return &t.Artist },
+	"TAL":  func(t *Tags) *string { return &t.Album },
+}
+
+func readID3v2(r io.ReadSeeker) (Tags, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Tags{}, err
+	}
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "ID3" {
+		return Tags{}, nil
+	}
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return Tags{}, nil
+	}
+
+	data := make([]byte, synchsafe(header[6:10]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Tags{}, nil
+	}
+	if flags&0x80 != 0 && version < 4 {
+		data = unsynchronise(data)
+	}
+	if flags&0x40 != 0 && version > 2 && len(data) >= 4 {
+		// Skip the extended header, whose size field only counts itself
+		// from ID3v2.4 on
+		size := int(binary.BigEndian.Uint32(data))
+		if version == 4 {
+			size = synchsafe(data[:4])
+		} else {
+			size += 4
+		}
+		data = data[min(size, len(data)):]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var tags Tags
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+
+		var size int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			size = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		case 4:
+			size = synchsafe(data[4:8])
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		}
+		if size < 0 || headerLen+size > len(data) {
+			break
+		}
+		frame := data[headerLen : headerLen+size]
+		data = data[headerLen+size:]
+
+		field, ok := id3v2Frames[id]
+		if !ok {
+			continue
+		}
+		if version == 4 {
+			if frameFlags&0x0002 != 0 {
+				frame = unsynchronise(frame)
+			}
+			if frameFlags&0x0001 != 0 && len(frame) >= 4 {
+				frame = frame[4:] // data length indicator
+			}
+		} else if version == 3 && frameFlags&0x00C0 != 0 {
+			continue // compressed or encrypted
+		}
+
+		if value := decodeID3Text(frame); value != "" && *field(&tags) == "" {
+			*field(&tags) = value
+		}
+	}
+
+	return tags, nil
+}
+
+func readID3v1(r io.ReadSeeker) (Tags, error) {
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		return Tags{}, nil
+	}
+
+	tag := make([]byte, 128)
+	if _, err := io.ReadFull(r, tag); err != nil || string(tag[:3]) != "TAG" {
+		return Tags{}, nil
+	}
+
+	field := func(b []byte) string {
+		return strings.TrimSpace(latin1(bytes.TrimRight(b, "\x00")))
+	}
+	return Tags{Title: field(tag[3:33]), Artist: field(tag[33:63]), Album: field(tag[63:93])}, nil
+}
+
+// synchsafe decodes a 4-byte integer whose bytes hold 7 bits each.
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// unsynchronise undoes the 0xFF 0x00 escaping of ID3 unsynchronisation.
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// decodeID3Text decodes a text frame: an encoding byte, then the text. Of
+// frames holding several values, the first is returned.
+func decodeID3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+
+	var text string
+	switch encoding, b := frame[0], frame[1:]; encoding {
+	case 0: // ISO-8859-1
+		text = latin1(b)
+	case 1, 2: // UTF-16 with a byte order mark, UTF-16BE
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if b[0] == 0xFF && b[1] == 0xFE || b[0] == 0xFE && b[1] == 0xFF {
+				b = b[2:]
+			}
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = order.Uint16(b[2*i:])
+		}
+		text = string(utf16.Decode(units))
+	case 3: // UTF-8
+		text = string(b)
+	default:
+		return ""
+	}
+
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+// latin1 decodes ISO-8859-1 text.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,35 @@
+package decode
+
+import (
+	"io"
+	"song-recognition/wav"
+)
+
+// decodeWAVFile decodes the WAV file at path as it is read, so that only
+// its mono samples are held in memory.
+func decodeWAVFile(path string) (*Audio, error) {
+	return decodeFileWith(path, DecodeWAV)
+}
+
+// DecodeWAV decodes a WAV stream, downmixing it to mono as it is read.
+func DecodeWAV(r io.Reader) (*Audio, error) {
+	reader, err := wav.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []float64
+	chunk := make([]float64, reader.SampleRate)
+	for {
+		n, err := reader.ReadSamples(chunk)
+		samples = append(samples, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newAudio(samples, reader.SampleRate, reader.Channels), nil
+}
@@ -6,13 +6,24 @@ require (
 	github.com/buger/jsonparser v1.1.1
 	github.com/fatih/color v1.16.0
 	github.com/googollee/go-socket.io v1.7.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/graphql-go/graphql v0.8.1
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/jfreymuth/oggvorbis v1.0.5
+	github.com/jfreymuth/vorbis v1.0.2
 	github.com/kkdai/youtube/v2 v2.10.1
+	github.com/lib/pq v1.12.3
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/mdobak/go-xerrors v0.3.1
+	github.com/mewkiz/flac v1.0.12
 	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/gjson v1.17.1
+	go.etcd.io/bbolt v1.3.10
 	go.mongodb.org/mongo-driver v1.14.0
 	gonum.org/v1/gonum v0.14.0
 	google.golang.org/api v0.166.0
+	google.golang.org/grpc v1.61.1
+	google.golang.org/protobuf v1.32.0
 )
 
 require (
@@ -36,11 +47,11 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.12.1 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
 	github.com/klauspost/compress v1.17.6 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	github.com/mewkiz/pkg v0.0.0-20230226050401-4010bf0fec14 // indirect
 	github.com/mjibson/go-dsp v0.0.0-20180508042940-11479a337f12 // indirect
 	github.com/montanaflynn/stats v0.7.1 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
@@ -63,7 +74,5 @@ require (
 	golang.org/x/text v0.15.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240213162025-012b6fc9bca9 // indirect
-	google.golang.org/grpc v1.61.1 // indirect
-	google.golang.org/protobuf v1.32.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,514 @@
+// Package graph serves a GraphQL view of the song library: songs with
+// their fingerprint and match counts, library stats and the recognition
+// history.
+package graph
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/artwork"
+	"song-recognition/db"
+	"song-recognition/quota"
+	"song-recognition/shazam"
+	"sort"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// MaxLimit caps the number of items a single list field returns.
+const MaxLimit = 100
+
+// Request is a GraphQL query as posted by clients.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type clientKey struct{}
+
+// Execute runs a GraphQL request against the library. All fields of the
+// request are resolved with a single database client.
+func Execute(ctx context.Context, req Request) *graphql.Result {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(fmt.Errorf("error creating DB client: %v", err))}
+	}
+	defer dbClient.Close()
+
+	return graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        context.WithValue(ctx, clientKey{}, dbClient),
+	})
+}
+
+// client returns the database client of the request being resolved.
+func client(p graphql.ResolveParams) db.DBClient {
+	return p.Context.Value(clientKey{}).(db.DBClient)
+}
+
+// artworkURL returns the cover image of a song: its stored cover, or its
+// YouTube thumbnail when the song came from YouTube.
+func artworkURL(song db.Song) interface{} {
+	if url := artwork.URL(song.ID, song.YouTubeID); url != "" {
+		return url
+	}
+	return nil
+}
+
+// limitArg reads a "limit" argument, clamped to MaxLimit.
+func limitArg(p graphql.ResolveParams) int {
+	limit, _ := p.Args["limit"].(int)
+	if limit <= 0 || limit > MaxLimit {
+		limit = MaxLimit
+	}
+	return limit
+}
+
+// tag is a name and value of Song.Tags.
+type tag struct {
+	Name, Value string
+}
+
+var tagType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Tag",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(tag).Name, nil
+			},
+		},
+		"value": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(tag).Value, nil
+			},
+		},
+	},
+})
+
+var songType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Song",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.ID),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return strconv.FormatUint(uint64(p.Source.(db.Song).ID), 10), nil
+			},
+		},
+		"title": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(db.Song).Title, nil
+			},
+		},
+		"artist": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(db.Song).Artist, nil
+			},
+		},
+		"youtubeId": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if ytID := p.Source.(db.Song).YouTubeID; ytID != "" {
+					return ytID, nil
+				}
+				return nil, nil
+			},
+		},
+		"artwork": &graphql.Field{
+			Type:        graphql.String,
+			Description: "URL of the song's cover image, if it has one.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return artworkURL(p.Source.(db.Song)), nil
+			},
+		},
+		"audio": &graphql.Field{
+			Type:        graphql.String,
+			Description: "URL the song's stored audio can be played back from, if it has any.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if song := p.Source.(db.Song); song.FileKey != "" {
+					return fmt.Sprintf("/songs/%d/audio", song.ID), nil
+				}
+				return nil, nil
+			},
+		},
+		"preview": &graphql.Field{
+			Type:        graphql.String,
+			Description: "URL of the song's preview clip, if one was cut.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if song := p.Source.(db.Song); song.PreviewKey != "" {
+					return fmt.Sprintf("/songs/%d/preview", song.ID), nil
+				}
+				return nil, nil
+			},
+		},
+		"waveform": &graphql.Field{
+			Type:        graphql.NewList(graphql.Float),
+			Description: "Amplitude envelope of the song, peak amplitudes from 0 to 1, if it has one.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				waveform, exists, err := client(p).GetWaveform(p.Context, p.Source.(db.Song).ID)
+				if err != nil || !exists {
+					return nil, err
+				}
+				return shazam.Amplitudes(waveform), nil
+			},
+		},
+		"tempo": &graphql.Field{
+			Type:        graphql.Float,
+			Description: "Tempo of the song in beats per minute, if it has a steady beat.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if tempo := p.Source.(db.Song).Tempo; tempo > 0 {
+					return tempo, nil
+				}
+				return nil, nil
+			},
+		},
+		"musicalKey": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Key the song is in, such as \"A minor\", if it has a clear one.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if musicalKey := p.Source.(db.Song).MusicalKey; musicalKey != "" {
+					return musicalKey, nil
+				}
+				return nil, nil
+			},
+		},
+		"album": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Album the song was first released on, if known.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if album := p.Source.(db.Song).Album; album != "" {
+					return album, nil
+				}
+				return nil, nil
+			},
+		},
+		"releaseYear": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "Year the song was first released, if known.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if year := p.Source.(db.Song).ReleaseYear; year > 0 {
+					return year, nil
+				}
+				return nil, nil
+			},
+		},
+		"musicBrainzId": &graphql.Field{
+			Type:        graphql.String,
+			Description: "MusicBrainz recording ID of the song, if known.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if id := p.Source.(db.Song).MusicBrainzID; id != "" {
+					return id, nil
+				}
+				return nil, nil
+			},
+		},
+		"storeUrl": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Page of the song in the iTunes store, if known.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if storeURL := p.Source.(db.Song).StoreURL; storeURL != "" {
+					return storeURL, nil
+				}
+				return nil, nil
+			},
+		},
+		"duration": &graphql.Field{
+			Type:        graphql.Float,
+			Description: "Length of the song's audio in seconds, if measured.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if duration := p.Source.(db.Song).Duration; duration > 0 {
+					return duration, nil
+				}
+				return nil, nil
+			},
+		},
+		"bitrate": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "Average bitrate of the audio the song was registered from, in bits per second, if measured.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if bitrate := p.Source.(db.Song).Bitrate; bitrate > 0 {
+					return bitrate, nil
+				}
+				return nil, nil
+			},
+		},
+		"codec": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Codec of the audio the song was registered from, such as \"mp3\", if known.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if codec := p.Source.(db.Song).Codec; codec != "" {
+					return codec, nil
+				}
+				return nil, nil
+			},
+		},
+		"tags": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(tagType))),
+			Description: "Labels classifiers gave the song, such as its genre or mood, sorted by name.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				tags := p.Source.(db.Song).Tags
+				names := make([]string, 0, len(tags))
+				for name := range tags {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				list := make([]tag, len(names))
+				for i, name := range names {
+					list[i] = tag{name, tags[name]}
+				}
+				return list, nil
+			},
+		},
+		"fingerprintCount": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return client(p).CountFingerprints(p.Context, p.Source.(db.Song).ID)
+			},
+		},
+		"matchCount": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Int),
+			Description: "Number of times the song has been recognized.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return client(p).CountRecognitions(p.Context, p.Source.(db.Song).ID)
+			},
+		},
+	},
+})
+
+var recognitionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Recognition",
+	Fields: graphql.Fields{
+		"song": &graphql.Field{
+			Type:        songType,
+			Description: "The best match, or null if nothing matched or the song was deleted since.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				songID := p.Source.(db.Recognition).SongID
+				if songID == 0 {
+					return nil, nil
+				}
+				song, exists, err := client(p).GetSongByID(p.Context, songID)
+				if err != nil || !exists {
+					return nil, err
+				}
+				return song, nil
+			},
+		},
+		"score": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Float),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(db.Recognition).Score, nil
+			},
+		},
+		"offsetMs": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return int(p.Source.(db.Recognition).OffsetMs), nil
+			},
+		},
+		"recognizedAt": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.DateTime),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(db.Recognition).CreatedAt, nil
+			},
+		},
+	},
+})
+
+// fingerprintVersion counts the fingerprints of one fingerprint version.
+type fingerprintVersion struct {
+	Version      string
+	Fingerprints int
+}
+
+var fingerprintVersionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "FingerprintVersion",
+	Fields: graphql.Fields{
+		"version": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(fingerprintVersion).Version, nil
+			},
+		},
+		"fingerprints": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(fingerprintVersion).Fingerprints, nil
+			},
+		},
+		"current": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Boolean),
+			Description: "Whether clips are fingerprinted with this version. Fingerprints of other versions never match.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(fingerprintVersion).Version == shazam.FingerprintVersion(), nil
+			},
+		},
+	},
+})
+
+// Sizes are Floats, since GraphQL Ints are 32-bit.
+var diskUsageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "DiskUsage",
+	Fields: graphql.Fields{
+		"dir": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(quota.Usage).Dir, nil
+			},
+		},
+		"usedBytes": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Float),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return float64(p.Source.(quota.Usage).UsedBytes), nil
+			},
+		},
+		"maxBytes": &graphql.Field{
+			Type:        graphql.Float,
+			Description: "The quota of the directory, or null if it has none.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if usage := p.Source.(quota.Usage); usage.MaxBytes > 0 {
+					return float64(usage.MaxBytes), nil
+				}
+				return nil, nil
+			},
+		},
+		"policy": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "What happens once the directory is full: reject or evict.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(quota.Usage).Policy, nil
+			},
+		},
+	},
+})
+
+var statsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Stats",
+	Fields: graphql.Fields{
+		"totalSongs": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return client(p).TotalSongs(p.Context)
+			},
+		},
+		"totalFingerprints": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return client(p).TotalFingerprints(p.Context)
+			},
+		},
+		"fingerprintVersions": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(fingerprintVersionType))),
+			Description: "Number of fingerprints stored of every fingerprint version, sorted by version.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				counts, err := client(p).CountFingerprintsByVersion(p.Context)
+				if err != nil {
+					return nil, err
+				}
+
+				versions := make([]fingerprintVersion, 0, len(counts))
+				for version, count := range counts {
+					versions = append(versions, fingerprintVersion{version, count})
+				}
+				sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+				return versions, nil
+			},
+		},
+		"disk": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(diskUsageType))),
+			Description: "Size of the temporary directory and, when songs are stored locally, of the songs directory.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return quota.Current()
+			},
+		},
+	},
+})
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"songs": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(songType))),
+			Args: graphql.FieldConfigArgument{
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: MaxLimit},
+				"musicalKey": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Only list the songs in this key, such as \"F# major\".",
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				offset, _ := p.Args["offset"].(int)
+				if offset < 0 {
+					offset = 0
+				}
+
+				var songs []db.Song
+				var err error
+				if musicalKey, ok := p.Args["musicalKey"].(string); ok {
+					songs, err = client(p).ListSongsByMusicalKey(p.Context, musicalKey, offset, limitArg(p))
+				} else {
+					songs, err = client(p).ListSongs(p.Context, offset, limitArg(p))
+				}
+				if songs == nil {
+					songs = []db.Song{}
+				}
+				return songs, err
+			},
+		},
+		"song": &graphql.Field{
+			Type: songType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				songID, err := strconv.ParseUint(p.Args["id"].(string), 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid song ID: %v", p.Args["id"])
+				}
+				song, exists, err := client(p).GetSongByID(p.Context, uint32(songID))
+				if err != nil || !exists {
+					return nil, err
+				}
+				return song, nil
+			},
+		},
+		"stats": &graphql.Field{
+			Type: graphql.NewNonNull(statsType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return struct{}{}, nil
+			},
+		},
+		"recognitions": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(recognitionType))),
+			Description: "The recognition history, newest first.",
+			Args: graphql.FieldConfigArgument{
+				"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: MaxLimit},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				recognitions, err := client(p).ListRecognitions(p.Context, limitArg(p))
+				if recognitions == nil {
+					recognitions = []db.Recognition{}
+				}
+				return recognitions, err
+			},
+		},
+	},
+})
+
+var schema = func() graphql.Schema {
+	s, err := graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+	}
+	return s
+}()
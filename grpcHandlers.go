@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"song-recognition/decode"
+	"song-recognition/quota"
+	"song-recognition/seektunepb"
+	"song-recognition/shazam"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"song-recognition/wav"
+
+	"github.com/mdobak/go-xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements the SeekTune gRPC service on top of the song and
+// shazam packages.
+type grpcServer struct {
+	seektunepb.UnimplementedSeekTuneServer
+}
+
+// serveGRPC serves the SeekTune gRPC service on port until it fails.
+func serveGRPC(port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("gRPC server listen: %v", err)
+	}
+
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxUploadSize))
+	seektunepb.RegisterSeekTuneServer(server, &grpcServer{})
+
+	log.Printf("Starting gRPC server on port %v", port)
+	if err := server.Serve(listener); err != nil {
+		log.Fatalf("gRPC server Serve: %v", err)
+	}
+}
+
+func (s *grpcServer) RegisterSong(ctx context.Context, req *seektunepb.RegisterSongRequest) (*seektunepb.RegisterSongResponse, error) {
+	logger := utils.GetLogger()
+
+	input := song.SongInput{
+		Title:          req.GetTitle(),
+		Artist:         req.GetArtist(),
+		YoutubeID:      req.GetYoutubeId(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+	}
+	var response *song.ProcessResponse
+	var err error
+	switch source := req.GetSource().(type) {
+	case *seektunepb.RegisterSongRequest_SongUrl:
+		input.SongURL = source.SongUrl
+		response, err = song.ProcessSong(ctx, &input)
+	case *seektunepb.RegisterSongRequest_Audio:
+		response, err = registerUpload(ctx, bytes.NewReader(source.Audio), int64(len(source.Audio)), "", &input)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "either song_url or audio is required")
+	}
+
+	switch {
+	case errors.Is(err, song.ErrRequestInProgress):
+		return nil, status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, quota.ErrFull):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case song.IsTransient(err):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		logger.ErrorContext(ctx, "Failed to register song", slog.Any("error", xerrors.New(err)))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &seektunepb.RegisterSongResponse{
+		SongId:            response.FingerprintID,
+		FilePath:          response.FilePath,
+		AlreadyRegistered: response.AlreadyRegistered,
+		Message:           response.Message,
+	}, nil
+}
+
+func (s *grpcServer) RecognizeClip(ctx context.Context, req *seektunepb.RecognizeClipRequest) (*seektunepb.RecognizeClipResponse, error) {
+	logger := utils.GetLogger()
+
+	if len(req.GetAudio()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "audio is required")
+	}
+
+	audio, err := decode.Decode(ctx, bytes.NewReader(req.GetAudio()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to decode audio: %v", err)
+	}
+
+	opts := shazam.DefaultMatchOptions()
+	if req.GetMaxMatches() > 0 {
+		opts.TopN = int(req.GetMaxMatches())
+	}
+	if req.MinScore != nil {
+		if req.GetMinScore() < 0 {
+			return nil, status.Error(codes.InvalidArgument, "min_score can't be negative")
+		}
+		opts.MinScore = req.GetMinScore()
+	}
+
+	matches, searchDuration, err := shazam.FindMatches(ctx, audio.Samples, audio.Duration, audio.SampleRate, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+		return nil, status.Error(codes.Internal, "failed to get matches")
+	}
+
+	recordRecognition(ctx, matches)
+
+	return &seektunepb.RecognizeClipResponse{
+		Matches:      grpcMatches(matches),
+		SearchTimeMs: searchDuration.Milliseconds(),
+	}, nil
+}
+
+func (s *grpcServer) StreamRecognize(stream seektunepb.SeekTune_StreamRecognizeServer) error {
+	logger := utils.GetLogger()
+	ctx := stream.Context()
+
+	var recognizer *shazam.LiveRecognizer
+	bitsPerSample, audioFormat := 16, wav.WaveFormatPCM
+
+	// report sends the result of a window and reports whether the stream
+	// should go on
+	report := func(result *shazam.LiveResult) (bool, error) {
+		if result == nil {
+			return true, nil
+		}
+		if result.Best != nil {
+			recordRecognition(ctx, result.Matches)
+			return false, stream.Send(&seektunepb.RecognitionEvent{
+				Type:            seektunepb.RecognitionEvent_MATCH,
+				Match:           grpcMatch(*result.Best),
+				DurationSeconds: result.Duration,
+			})
+		}
+
+		return true, stream.Send(&seektunepb.RecognitionEvent{
+			Type:            seektunepb.RecognitionEvent_CANDIDATES,
+			Matches:         grpcMatches(result.Matches),
+			DurationSeconds: result.Duration,
+		})
+	}
+
+	noMatch := func(duration float64) error {
+		recordRecognition(ctx, nil)
+		return stream.Send(&seektunepb.RecognitionEvent{
+			Type:            seektunepb.RecognitionEvent_NO_MATCH,
+			DurationSeconds: duration,
+		})
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			if recognizer == nil {
+				return noMatch(0)
+			}
+
+			result, err := recognizer.Flush(ctx)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+				return status.Error(codes.Internal, "failed to get matches")
+			}
+			if goOn, err := report(result); !goOn || err != nil {
+				return err
+			}
+			return noMatch(result.Duration)
+		}
+		if err != nil {
+			return err
+		}
+
+		if recognizer == nil {
+			sampleRate := int(chunk.GetSampleRate())
+			if sampleRate == 0 {
+				sampleRate = wav.StandardSampleRate
+			}
+			recognizer, err = shazam.NewLiveRecognizer(sampleRate)
+			if err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			recognizer.Options.TopN = liveCandidates
+
+			switch chunk.GetFormat() {
+			case seektunepb.SampleFormat_SAMPLE_FORMAT_S16LE:
+			case seektunepb.SampleFormat_SAMPLE_FORMAT_F32LE:
+				bitsPerSample, audioFormat = 32, wav.WaveFormatIEEEFloat
+			default:
+				return status.Error(codes.InvalidArgument, "unsupported sample format")
+			}
+		}
+
+		if len(chunk.GetPcm())%(bitsPerSample/8) != 0 {
+			return status.Error(codes.InvalidArgument, "audio chunk is not a whole number of samples")
+		}
+
+		samples, err := wav.BytesToSamples(chunk.GetPcm(), bitsPerSample, audioFormat)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		result, err := recognizer.Feed(ctx, samples)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+			return status.Error(codes.Internal, "failed to get matches")
+		}
+		if goOn, err := report(result); !goOn || err != nil {
+			return err
+		}
+
+		if recognizer.Done() {
+			return noMatch(result.Duration)
+		}
+	}
+}
+
+func grpcMatch(match shazam.Match) *seektunepb.Match {
+	return &seektunepb.Match{
+		SongId:        match.SongID,
+		Title:         match.SongTitle,
+		Artist:        match.SongArtist,
+		YoutubeId:     match.YouTubeID,
+		TimestampMs:   match.Timestamp,
+		Score:         match.Score,
+		MatchedHashes: int32(match.MatchedHashes),
+	}
+}
+
+func grpcMatches(matches []shazam.Match) []*seektunepb.Match {
+	converted := make([]*seektunepb.Match, len(matches))
+	for i, match := range matches {
+		converted[i] = grpcMatch(match)
+	}
+	return converted
+}
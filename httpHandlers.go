@@ -0,0 +1,1085 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"song-recognition/acoustid"
+	"song-recognition/artwork"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/graph"
+	"song-recognition/itunes"
+	"song-recognition/lastfm"
+	"song-recognition/lyrics"
+	"song-recognition/monitor"
+	"song-recognition/playback"
+	"song-recognition/quota"
+	"song-recognition/shazam"
+	"song-recognition/song"
+	"song-recognition/storage"
+	"song-recognition/utils"
+	"song-recognition/webhook"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// maxUploadSize caps the size of multipart audio uploads.
+const maxUploadSize = 50 << 20
+
+func registerHTTPHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/api/songs", handleSongUpload)
+	mux.HandleFunc("/api/recognize", handleRecognizeUpload)
+	mux.HandleFunc("/recognize", handleRecognizeClip)
+	mux.HandleFunc("/spectrogram", handleClipSpectrogram)
+	mux.HandleFunc("/jobs", handleJobSubmit)
+	mux.HandleFunc("/jobs/", handleJobStatus)
+	mux.HandleFunc("/songs/", handleSongs)
+	mux.HandleFunc("/ws/recognize", handleLiveRecognition)
+	mux.HandleFunc("/graphql", handleGraphQL)
+	mux.HandleFunc("/api/detections", handleDetections)
+	mux.HandleFunc("/monitor/events", handleMonitorEvents)
+	mux.Handle("/art/", http.StripPrefix("/art/", http.FileServer(filesOnly{http.Dir(artwork.Dir)})))
+}
+
+// filesOnly is a file system whose directories don't exist, so a file
+// server of it serves files without listing the directories they are in.
+type filesOnly struct {
+	http.FileSystem
+}
+
+func (fsys filesOnly) Open(name string) (http.File, error) {
+	file, err := fsys.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+// maxGraphQLRequestSize caps the body of GraphQL requests.
+const maxGraphQLRequestSize = 1 << 20
+
+// handleGraphQL runs a GraphQL query over the song library, posted as JSON
+// or passed in the "query" parameter of a GET request.
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graph.Request
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid variables: %v", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, maxGraphQLRequestSize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if req.Query == "" {
+		writeJSONError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, graph.Execute(r.Context(), req))
+}
+
+// maxJobRequestSize caps the JSON body of job submissions, which may carry
+// inline audio.
+const maxJobRequestSize = 16 << 20
+
+// handleJobSubmit queues a JSON SongInput for asynchronous processing and
+// responds with the job ID straight away.
+func handleJobSubmit(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var input song.SongInput
+	body := http.MaxBytesReader(w, r.Body, maxJobRequestSize)
+	if err := json.NewDecoder(body).Decode(&input); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	jobID, err := song.EnqueueSong(ctx, &input)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to enqueue job", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+jobID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": jobID, "status": db.JobQueued})
+}
+
+// handleJobStatus serves GET /jobs/{id}.
+func handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	jobID := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if jobID == "" || strings.Contains(jobID, "/") {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	status, exists, err := song.GetJobStatus(ctx, jobID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get job", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get job")
+		return
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleSongs routes GET /songs/{jobID}/events, GET /songs/{id}/audio,
+// GET /songs/{id}/preview, GET /songs/{id}/waveform,
+// GET /songs/{id}/spectrogram.png, GET /songs/{id}/stream and the HLS
+// playlist and segments under GET /songs/{id}/hls/.
+func handleSongs(w http.ResponseWriter, r *http.Request) {
+	_, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/songs/"), "/")
+	switch {
+	case file == "events":
+		handleJobEvents(w, r)
+	case file == "audio", file == "preview":
+		handleSongAudio(w, r)
+	case file == "waveform":
+		handleSongWaveform(w, r)
+	case file == "spectrogram.png":
+		handleSongSpectrogram(w, r)
+	case file == "stream", strings.HasPrefix(file, "hls/"):
+		handleSongPlayback(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// songAudioURLExpiry is how long the signed URLs songs are served from
+// remote storage with stay valid.
+const songAudioURLExpiry = time.Hour
+
+// handleSongAudio serves GET /songs/{id}/audio, the stored WAV file of a
+// song, and GET /songs/{id}/preview, its preview clip, with Range and
+// conditional requests supported for seeking. Songs kept in a bucket are
+// redirected to a signed URL of their file.
+func handleSongAudio(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	registered, file, ok := storedSong(w, r)
+	if !ok {
+		return
+	}
+
+	key := registered.FileKey
+	if file == "preview" {
+		key = registered.PreviewKey
+	}
+	if key == "" {
+		writeJSONError(w, http.StatusNotFound, "song has no stored "+file)
+		return
+	}
+
+	store := storage.Default()
+	signedURL, err := store.SignedURL(ctx, key, songAudioURLExpiry)
+	if err == nil {
+		http.Redirect(w, r, signedURL, http.StatusFound)
+		return
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		logger.ErrorContext(ctx, "Failed to sign song audio URL", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song audio")
+		return
+	}
+
+	stored, err := store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "song audio not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open song audio", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song audio")
+		return
+	}
+	defer stored.Close()
+
+	content, ok := stored.(io.ReadSeeker)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "song audio isn't seekable")
+		return
+	}
+	var modTime time.Time
+	if stater, ok := stored.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := stater.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+
+	// Files are named after their audio, so they never change
+	w.Header().Set("Content-Type", song.ContentType(key))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, path.Base(key), modTime, content)
+}
+
+// storedSong returns the song of a GET or HEAD request for
+// /songs/{id}/{file}, and the file asked for, or writes the error response
+// and returns false.
+func storedSong(w http.ResponseWriter, r *http.Request) (db.Song, string, bool) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return db.Song{}, "", false
+	}
+
+	id, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/songs/"), "/")
+	songID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "song not found")
+		return db.Song{}, "", false
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create DB client", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song")
+		return db.Song{}, "", false
+	}
+	registered, exists, err := dbClient.GetSongByID(ctx, uint32(songID))
+	dbClient.Close()
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get song", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song")
+		return db.Song{}, "", false
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "song not found")
+		return db.Song{}, "", false
+	}
+	return registered, file, true
+}
+
+// handleSongWaveform serves GET /songs/{id}/waveform, the amplitude
+// envelope of a song stored when it was saved, as shazam.WaveformPoints
+// peak amplitudes from 0 to 1.
+func handleSongWaveform(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, _ := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/songs/"), "/waveform")
+	songID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "song not found")
+		return
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create DB client", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get waveform")
+		return
+	}
+	defer dbClient.Close()
+
+	waveform, exists, err := dbClient.GetWaveform(ctx, uint32(songID))
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get waveform", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get waveform")
+		return
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "song has no waveform")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"song_id": songID,
+		"points":  shazam.Amplitudes(waveform),
+	})
+}
+
+// handleSongSpectrogram serves GET /songs/{id}/spectrogram.png, the
+// spectrogram of the stored audio of a song, with the peaks its
+// fingerprints are made of marked on it if "peaks" is set.
+func handleSongSpectrogram(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	registered, _, ok := storedSong(w, r)
+	if !ok {
+		return
+	}
+	if registered.FileKey == "" {
+		writeJSONError(w, http.StatusNotFound, "song has no stored audio")
+		return
+	}
+
+	stored, err := storage.Default().Get(ctx, registered.FileKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "song audio not found")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to open song audio", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song audio")
+		return
+	}
+	defer stored.Close()
+
+	audio, err := decode.Decode(ctx, stored)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to decode song audio", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to decode song audio")
+		return
+	}
+	writeSpectrogram(w, r, audio)
+}
+
+// handleClipSpectrogram serves POST /spectrogram, the spectrogram of a clip
+// sent like to /recognize and as long at most, with the peaks a lookup of
+// it would use marked on it if "peaks" is set.
+func handleClipSpectrogram(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var clip io.Reader = http.MaxBytesReader(w, r.Body, maxClipSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ok := readUpload(w, r, maxClipSize)
+		if !ok {
+			return
+		}
+		defer file.Close()
+		clip = file
+	}
+
+	audio, err := decode.DecodeClip(ctx, clip, maxClipSeconds)
+	if errors.Is(err, decode.ErrTooLong) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("clip is longer than the limit of %ds", maxClipSeconds))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("failed to decode audio: %v", err))
+		return
+	}
+	writeSpectrogram(w, r, audio)
+}
+
+// writeSpectrogram answers r with the spectrogram of audio as a PNG image.
+func writeSpectrogram(w http.ResponseWriter, r *http.Request, audio *decode.Audio) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	var peaks bool
+	if value := r.URL.Query().Get("peaks"); value != "" {
+		var err error
+		if peaks, err = strconv.ParseBool(value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "peaks must be true or false")
+			return
+		}
+	}
+
+	img, err := shazam.DrawSpectrogram(audio.Samples, audio.SampleRate, audio.Duration, peaks)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to draw spectrogram", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to draw spectrogram")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, img); err != nil {
+		logger.ErrorContext(ctx, "Failed to write spectrogram", slog.Any("error", xerrors.New(err)))
+	}
+}
+
+// handleSongPlayback serves the stored audio of a song transcoded as it is
+// played back: GET /songs/{id}/stream?format=aac|opus, the whole song as a
+// compressed stream, and GET /songs/{id}/hls/index.m3u8, an HLS playlist
+// of the song with its AAC segments under GET /songs/{id}/hls/{n}.ts.
+func handleSongPlayback(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	registered, file, ok := storedSong(w, r)
+	if !ok {
+		return
+	}
+	if registered.FileKey == "" {
+		writeJSONError(w, http.StatusNotFound, "song has no stored audio")
+		return
+	}
+
+	if file == "hls/index.m3u8" {
+		if registered.Duration <= 0 {
+			writeJSONError(w, http.StatusNotFound, "song duration unknown")
+			return
+		}
+		w.Header().Set("Content-Type", playback.PlaylistContentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		io.WriteString(w, playback.Playlist(registered.Duration))
+		return
+	}
+
+	index := -1
+	if name, ok := strings.CutPrefix(file, "hls/"); ok {
+		digits, ok := strings.CutSuffix(name, ".ts")
+		segment, err := strconv.Atoi(digits)
+		if !ok || err != nil || segment < 0 || segment >= playback.Segments(registered.Duration) {
+			writeJSONError(w, http.StatusNotFound, "segment not found")
+			return
+		}
+		index = segment
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = playback.AAC
+	}
+	if index < 0 && playback.ContentType(format) == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("format must be %q or %q", playback.AAC, playback.Opus))
+		return
+	}
+	if !decode.FFmpegAvailable() {
+		writeJSONError(w, http.StatusNotImplemented, "transcoding requires ffmpeg")
+		return
+	}
+
+	source, err := playback.Source(ctx, storage.Default(), registered.FileKey)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to locate song audio", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get song audio")
+		return
+	}
+
+	// Segments are short, so they are encoded whole before answering, and
+	// a failure still gets an error status
+	if index >= 0 {
+		segment, err := playback.Segment(ctx, source, index)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to transcode song segment", slog.Any("error", xerrors.New(err)))
+			writeJSONError(w, http.StatusInternalServerError, "failed to transcode song audio")
+			return
+		}
+		w.Header().Set("Content-Type", playback.SegmentContentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("Content-Length", strconv.Itoa(len(segment)))
+		if r.Method != http.MethodHead {
+			w.Write(segment)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", playback.ContentType(format))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := playback.Stream(ctx, w, source, format); err != nil && ctx.Err() == nil {
+		// Too late for an error status once audio was sent
+		logger.ErrorContext(ctx, "Failed to transcode song audio", slog.Any("error", xerrors.New(err)))
+	}
+}
+
+// maxDetections caps the number of detections handleDetections returns.
+const maxDetections = 100
+
+// handleDetections serves GET /api/detections, the latest songs heard on
+// the monitored streams, newest first. The "stream" parameter narrows
+// them to one stream and "limit" caps how many are returned.
+func handleDetections(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	limit := maxDetections
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxDetections)
+	}
+
+	detections, err := monitor.Recent(ctx, r.URL.Query().Get("stream"), limit)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to list detections", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to list detections")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"detections": detections})
+}
+
+// handleSongUpload registers a song from a multipart/form-data upload with
+// an audio "file" part and optional "title", "artist" and "youtube_id"
+// fields. A missing title or artist is read from the file's ID3 tags.
+// Retries carrying the same Idempotency-Key header (or "idempotency_key"
+// field) get the response of the original upload.
+func handleSongUpload(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	file, header, ok := readUpload(w, r, maxUploadSize)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	input := song.SongInput{
+		Title:          r.FormValue("title"),
+		Artist:         r.FormValue("artist"),
+		YoutubeID:      r.FormValue("youtube_id"),
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+	}
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = r.FormValue("idempotency_key")
+	}
+
+	response, err := registerUpload(ctx, file, header.Size, filepath.Ext(header.Filename), &input)
+	if errors.Is(err, song.ErrRequestInProgress) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, quota.ErrFull) {
+		writeJSONError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to process upload", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	status := http.StatusCreated
+	if response.AlreadyRegistered {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, response)
+}
+
+// registerUpload stores an uploaded audio file of size bytes under tmp, so
+// the processor can keep a copy of it, and registers it as input. ext is the
+// file name extension of the upload, if known.
+func registerUpload(ctx context.Context, r io.Reader, size int64, ext string, input *song.SongInput) (*song.ProcessResponse, error) {
+	if err := quota.MakeRoom(ctx, quota.TmpDir, config.Get().Quotas.Tmp, size); err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp("tmp", "upload-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = io.Copy(tmpFile, r)
+	tmpFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store upload: %v", err)
+	}
+
+	return song.ProcessIdempotent(ctx, input.IdempotencyKey, func() (*song.ProcessResponse, error) {
+		return song.ProcessSongFromFile(ctx, tmpFile.Name(), input)
+	})
+}
+
+// handleRecognizeUpload matches a multipart audio "file" upload against the
+// fingerprint database. An optional "callback_url" field also gets the
+// matches delivered as a signed webhook, and a "lastfm_session" field has
+// the best match scrobbled for that Last.fm user.
+func handleRecognizeUpload(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	file, _, ok := readUpload(w, r, maxUploadSize)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	callbackURL := r.FormValue("callback_url")
+	if callbackURL != "" {
+		if err := webhook.ValidateURL(callbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	opts, err := parseMatchOptions(r.FormValue, shazam.DefaultMatchOptions().TopN)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	audio, err := decode.Decode(ctx, file)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("failed to decode audio: %v", err))
+		return
+	}
+
+	matches, searchDuration, err := shazam.FindMatches(ctx, audio.Samples, audio.Duration, audio.SampleRate, opts)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get matches")
+		return
+	}
+
+	recordRecognition(ctx, matches)
+
+	if callbackURL != "" {
+		notifyMatch(ctx, callbackURL, matches)
+	}
+	if len(matches) > 0 {
+		best := matches[0]
+		scrobbleMatch(ctx, r.FormValue("lastfm_session"), best.SongTitle, best.SongArtist, best.Timestamp, audio.Duration)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"matches":     matches,
+		"search_time": searchDuration.String(),
+	})
+}
+
+// maxClipSeconds is the longest clip accepted by the /recognize endpoint.
+const maxClipSeconds = 15
+
+// maxClipSize caps the request body of the /recognize endpoint.
+const maxClipSize = 10 << 20
+
+// clipCandidates is the default number of candidates returned by the
+// /recognize endpoint.
+const clipCandidates = 5
+
+// clipMatch is a song a clip may have been recognized as.
+type clipMatch struct {
+	SongID        uint32  `json:"song_id"`
+	Title         string  `json:"title"`
+	Artist        string  `json:"artist"`
+	YouTubeID     string  `json:"youtube_id,omitempty"`
+	Score         float64 `json:"score"`
+	MatchedHashes int     `json:"matched_hashes"`
+	OffsetMs      uint32  `json:"offset_ms"` // where in the song the clip starts
+	Offset        string  `json:"offset"`    // OffsetMs as m:ss
+	ArtworkURL    string  `json:"artwork_url,omitempty"`
+	PreviewURL    string  `json:"preview_url,omitempty"`
+	StoreURL      string  `json:"store_url,omitempty"`
+
+	Diagnostics *shazam.Diagnostics `json:"diagnostics,omitempty"`
+	Lyrics      *clipLyrics         `json:"lyrics,omitempty"`
+
+	// External candidates come from AcoustID rather than the library. They
+	// have no song ID or offset, and their score is AcoustID's, from 0 to 1.
+	External    bool   `json:"external,omitempty"`
+	RecordingID string `json:"recording_id,omitempty"` // MusicBrainz recording ID
+}
+
+// clipLyrics are the lyrics of the song a clip was recognized as.
+type clipLyrics struct {
+	// Snippet is the lines sung where the clip starts, when the provider
+	// knows their timing, or else the first lines.
+	Snippet string `json:"snippet"`
+	URL     string `json:"url,omitempty"`
+	Source  string `json:"source"` // the provider the lyrics came from
+}
+
+// findLyrics returns the lyrics of a matched song, or nil if no provider
+// has them.
+func findLyrics(ctx context.Context, match clipMatch) *clipLyrics {
+	found, source, ok := lyrics.Find(ctx, match.Title, match.Artist)
+	if !ok {
+		return nil
+	}
+
+	offset := time.Duration(match.OffsetMs) * time.Millisecond
+	return &clipLyrics{Snippet: found.Snippet(offset), URL: found.URL, Source: source}
+}
+
+// linkStore fills in the store page of a matched song, and the store's
+// casing of its title and artist, when store lookups are enabled. Songs of
+// the library keep what was found, so they are only looked up once.
+func linkStore(ctx context.Context, match *clipMatch) {
+	if itunes.Default() == nil {
+		return
+	}
+
+	if match.External {
+		if metadata, found := itunes.Metadata(ctx, match.Title, match.Artist); found {
+			match.Title, match.Artist, match.StoreURL = metadata.Title, metadata.Artist, metadata.StoreURL
+		}
+		return
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return
+	}
+	defer dbClient.Close()
+
+	registered, exists, err := dbClient.GetSongByID(ctx, match.SongID)
+	if err != nil || !exists {
+		return
+	}
+	registered = itunes.Link(ctx, dbClient, registered)
+	match.Title, match.Artist, match.StoreURL = registered.Title, registered.Artist, registered.StoreURL
+}
+
+func newClipMatch(match shazam.Match) clipMatch {
+	return clipMatch{
+		SongID:        match.SongID,
+		Title:         match.SongTitle,
+		Artist:        match.SongArtist,
+		YouTubeID:     match.YouTubeID,
+		Score:         match.Score,
+		MatchedHashes: match.MatchedHashes,
+		OffsetMs:      match.Timestamp,
+		Offset:        shazam.FormatOffset(match.Timestamp),
+		ArtworkURL:    artwork.URL(match.SongID, match.YouTubeID),
+		PreviewURL:    songPreviewURL(match.SongID, match.PreviewKey),
+		Diagnostics:   match.Diagnostics,
+	}
+}
+
+// songPreviewURL returns the URL the preview clip of a song is served at,
+// or "" if it has none.
+func songPreviewURL(songID uint32, previewKey string) string {
+	if previewKey == "" {
+		return ""
+	}
+	return fmt.Sprintf("/songs/%d/preview", songID)
+}
+
+func newExternalClipMatch(recording acoustid.Recording) clipMatch {
+	return clipMatch{
+		Title:       recording.Title,
+		Artist:      strings.Join(recording.Artists, ", "),
+		Score:       recording.Score,
+		External:    true,
+		RecordingID: recording.ID,
+	}
+}
+
+// acoustidClient looks up clips the library has no match for, when an
+// AcoustID API key is set.
+var acoustidClient = acoustid.Default()
+
+// lookupExternal returns the AcoustID candidates for a clip, at most topN.
+// Failed lookups are logged and return none.
+func lookupExternal(ctx context.Context, audio *decode.Audio, topN int) []clipMatch {
+	recordings, err := acoustidClient.LookupAudio(ctx, audio.Samples, audio.SampleRate)
+	if err != nil {
+		utils.GetLogger().ErrorContext(ctx, "AcoustID lookup failed.", slog.Any("error", xerrors.New(err)))
+		return nil
+	}
+
+	if topN > 0 && len(recordings) > topN {
+		recordings = recordings[:topN]
+	}
+	candidates := make([]clipMatch, len(recordings))
+	for i, recording := range recordings {
+		candidates[i] = newExternalClipMatch(recording)
+	}
+	return candidates
+}
+
+// handleRecognizeClip identifies a clip of at most maxClipSeconds and
+// responds with the best matching song, or a null match, and the ranked
+// candidates. The clip is sent either as the raw request body or as the
+// "file" part of a multipart form. The "top_n" query parameter sets the
+// number of candidates and "min_score" the score they need; "max_stretch"
+// also matches the clip sped up or slowed down by up to that fraction, and
+// "explain=true" adds match diagnostics. "mode=humming" matches the melody
+// of a hummed or whistled clip instead of its recording. Recordings that
+// match no song are looked up in AcoustID, when configured, and its
+// candidates returned marked external. The best match carries its lyrics
+// when a lyrics provider is configured and its iTunes store page when store
+// lookups are, and is scrobbled for the Last.fm user whose session key is
+// the "lastfm_session" parameter, if any.
+func handleRecognizeClip(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	opts, err := parseMatchOptions(r.URL.Query().Get, clipCandidates)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var humming bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "audio":
+	case "humming":
+		// Melody scores have a scale of their own
+		humming = true
+		if r.URL.Query().Get("min_score") == "" {
+			opts.MinScore = shazam.DefaultMelodyMinScore
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown mode %q", mode))
+		return
+	}
+
+	var clip io.Reader = http.MaxBytesReader(w, r.Body, maxClipSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ok := readUpload(w, r, maxClipSize)
+		if !ok {
+			return
+		}
+		defer file.Close()
+		clip = file
+	}
+
+	audio, err := decode.DecodeClip(ctx, clip, maxClipSeconds)
+	if errors.Is(err, decode.ErrTooLong) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("clip is longer than the limit of %ds", maxClipSeconds))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("failed to decode audio: %v", err))
+		return
+	}
+
+	var matches []shazam.Match
+	var searchDuration time.Duration
+	if humming {
+		matches, searchDuration, err = shazam.FindMelodyMatches(ctx, audio.Samples, audio.SampleRate, opts)
+	} else {
+		matches, searchDuration, err = shazam.FindMatches(ctx, audio.Samples, audio.Duration, audio.SampleRate, opts)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get matches")
+		return
+	}
+
+	recordRecognition(ctx, matches)
+
+	var match *clipMatch
+	candidates := make([]clipMatch, len(matches))
+	for i, m := range matches {
+		candidates[i] = newClipMatch(m)
+	}
+	if len(candidates) == 0 && !humming && acoustidClient != nil {
+		candidates = lookupExternal(ctx, audio, opts.TopN)
+	}
+	if len(candidates) > 0 {
+		match = &candidates[0]
+		linkStore(ctx, match)
+		if !humming && lyrics.Enabled() {
+			match.Lyrics = findLyrics(ctx, *match)
+		}
+		if !humming {
+			scrobbleMatch(ctx, r.URL.Query().Get("lastfm_session"), match.Title, match.Artist, match.OffsetMs, audio.Duration)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"match":       match,
+		"candidates":  candidates,
+		"search_time": searchDuration.String(),
+	})
+}
+
+// parseMatchOptions reads the "top_n", "min_score", "max_stretch" and
+// "explain" parameters of a recognition request with get. top_n defaults to
+// defaultTopN, and min_score and max_stretch to the configured values.
+func parseMatchOptions(get func(string) string, defaultTopN int) (shazam.MatchOptions, error) {
+	opts := shazam.DefaultMatchOptions()
+	opts.TopN = defaultTopN
+
+	if value := get("top_n"); value != "" {
+		topN, err := strconv.Atoi(value)
+		if err != nil || topN <= 0 {
+			return opts, fmt.Errorf("top_n must be a positive integer")
+		}
+		opts.TopN = topN
+	}
+
+	if value := get("min_score"); value != "" {
+		minScore, err := strconv.ParseFloat(value, 64)
+		if err != nil || minScore < 0 {
+			return opts, fmt.Errorf("min_score must be a non-negative number")
+		}
+		opts.MinScore = minScore
+	}
+
+	if value := get("max_stretch"); value != "" {
+		maxStretch, err := strconv.ParseFloat(value, 64)
+		if err != nil || maxStretch < 0 || maxStretch > config.MaxStretchLimit {
+			return opts, fmt.Errorf("max_stretch must be a number between 0 and %v", config.MaxStretchLimit)
+		}
+		opts.MaxStretch = maxStretch
+	}
+
+	if value := get("idf_weighting"); value != "" {
+		idfWeighting, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("idf_weighting must be a boolean")
+		}
+		opts.IDFWeighting = idfWeighting
+	}
+
+	if value := get("lsh_candidates"); value != "" {
+		candidates, err := strconv.Atoi(value)
+		if err != nil || candidates < 0 {
+			return opts, fmt.Errorf("lsh_candidates must be a non-negative integer")
+		}
+		opts.LSHCandidates = candidates
+	}
+
+	if value := get("explain"); value != "" {
+		explain, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("explain must be a boolean")
+		}
+		opts.Explain = explain
+	}
+
+	return opts, nil
+}
+
+// recordRecognition adds the outcome of a recognition to the history. A
+// failure to record it doesn't fail the recognition.
+func recordRecognition(ctx context.Context, matches []shazam.Match) {
+	if err := shazam.RecordRecognition(ctx, matches); err != nil {
+		logger := utils.GetLogger()
+		logger.ErrorContext(ctx, "failed to record recognition.", slog.Any("error", xerrors.New(err)))
+	}
+}
+
+// scrobbleMatch scrobbles a song recognized in a clip of clipSeconds, which
+// starts offsetMs into the song, for the Last.fm user with sessionKey. It
+// does nothing without a session key or when scrobbling is disabled.
+func scrobbleMatch(ctx context.Context, sessionKey, title, artist string, offsetMs uint32, clipSeconds float64) {
+	if sessionKey == "" {
+		return
+	}
+
+	// The clip ends about now, and the song started before it
+	started := time.Now().Add(-time.Duration(clipSeconds*float64(time.Second)) - time.Duration(offsetMs)*time.Millisecond)
+	lastfm.ScrobbleInBackground(ctx, sessionKey, lastfm.Scrobble{Artist: artist, Track: title, Timestamp: started})
+}
+
+// notifyMatch POSTs recognition results to callbackURL in the background.
+func notifyMatch(ctx context.Context, callbackURL string, matches []shazam.Match) {
+	logger := utils.GetLogger()
+
+	event := map[string]interface{}{
+		"event":     "match.completed",
+		"matches":   matches,
+		"timestamp": time.Now().UTC(),
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := webhook.Send(ctx, callbackURL, event); err != nil {
+			logger.ErrorContext(ctx, "Error delivering webhook", slog.String("url", callbackURL), slog.Any("error", err))
+		}
+	}()
+}
+
+// readUpload parses a multipart form of at most limit bytes and returns its
+// "file" part. On failure it writes the error response and returns false.
+func readUpload(w http.ResponseWriter, r *http.Request, limit int64) (multipart.File, *multipart.FileHeader, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	if err := r.ParseMultipartForm(limit); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid multipart upload: %v", err))
+		return nil, nil, false
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "missing audio file part \"file\"")
+		return nil, nil, false
+	}
+
+	return file, header, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger := utils.GetLogger()
+		logger.ErrorContext(context.Background(), "failed to write response.", slog.Any("error", xerrors.New(err)))
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
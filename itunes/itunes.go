@@ -0,0 +1,197 @@
+// Package itunes looks up songs in the iTunes Search API, for the casing
+// Apple gives their title and artist and the page they are sold on in the
+// Apple Music and iTunes stores.
+package itunes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// DefaultURL is the base URL of the iTunes Search API.
+const DefaultURL = "https://itunes.apple.com"
+
+// MaxLimit is the most results a search returns.
+const MaxLimit = 200
+
+// httpTimeout bounds a single request.
+const httpTimeout = 10 * time.Second
+
+// findLimit is the number of results Find picks a song from.
+const findLimit = 10
+
+// Track is a song of the iTunes store.
+type Track struct {
+	ID          int64
+	Title       string
+	Artist      string
+	Album       string
+	ReleaseYear int
+	// PreviewURL is a 30 second AAC preview of the song.
+	PreviewURL string
+	// StoreURL is the song's page in the store.
+	StoreURL string
+}
+
+// Client searches the iTunes Search API, which needs no credentials.
+type Client struct {
+	// URL is that of Apple, and overridable for tests.
+	URL string
+	// Country is the two letter code of the store searched.
+	Country string
+	Client  *http.Client
+}
+
+// NewClient returns a client that searches the store of country.
+func NewClient(country string) *Client {
+	return &Client{URL: DefaultURL, Country: country, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns the client configured in the config file, or nil when
+// lookups are disabled.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		if cfg := config.Get().ITunes; cfg.Enabled {
+			defaultClient = NewClient(cfg.Country)
+		}
+	})
+	return defaultClient
+}
+
+// Search returns up to limit songs matching term, best first.
+func (c *Client) Search(ctx context.Context, term string, limit int) ([]Track, error) {
+	params := url.Values{
+		"term":    {term},
+		"media":   {"music"},
+		"entity":  {"song"},
+		"country": {c.Country},
+		"limit":   {strconv.Itoa(min(limit, MaxLimit))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("iTunes responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	var response struct {
+		Results []struct {
+			TrackID        int64  `json:"trackId"`
+			TrackName      string `json:"trackName"`
+			ArtistName     string `json:"artistName"`
+			CollectionName string `json:"collectionName"`
+			PreviewURL     string `json:"previewUrl"`
+			TrackViewURL   string `json:"trackViewUrl"`
+			ReleaseDate    string `json:"releaseDate"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid iTunes response: %v", err)
+	}
+
+	tracks := make([]Track, len(response.Results))
+	for i, result := range response.Results {
+		tracks[i] = Track{
+			ID:         result.TrackID,
+			Title:      result.TrackName,
+			Artist:     result.ArtistName,
+			Album:      result.CollectionName,
+			PreviewURL: result.PreviewURL,
+			StoreURL:   result.TrackViewURL,
+		}
+		// Release dates are timestamps, such as 2019-03-01T12:00:00Z
+		if len(result.ReleaseDate) >= 4 {
+			tracks[i].ReleaseYear, _ = strconv.Atoi(result.ReleaseDate[:4])
+		}
+	}
+	return tracks, nil
+}
+
+// Find returns the song titled title by artist, ignoring case. It reports
+// false if the store has no such song.
+func (c *Client) Find(ctx context.Context, title, artist string) (Track, bool, error) {
+	tracks, err := c.Search(ctx, artist+" "+title, findLimit)
+	if err != nil {
+		return Track{}, false, err
+	}
+
+	// Searches match loosely, so only take the same song by the same artist
+	for _, track := range tracks {
+		if strings.EqualFold(track.Title, title) && strings.EqualFold(track.Artist, artist) {
+			return track, true, nil
+		}
+	}
+	return Track{}, false, nil
+}
+
+// Metadata looks up a song with the configured client. The title and artist
+// it returns are those of the store, which only differ from the song's in
+// their case, and StoreURL its page in the store. It reports false when
+// lookups are disabled, fail or find no song; failures are logged.
+func Metadata(ctx context.Context, title, artist string) (db.SongMetadata, bool) {
+	client := Default()
+	if client == nil {
+		return db.SongMetadata{}, false
+	}
+
+	track, found, err := client.Find(ctx, title, artist)
+	if err != nil {
+		utils.GetLogger().ErrorContext(ctx, "iTunes lookup failed",
+			slog.String("title", title), slog.String("artist", artist), slog.Any("error", xerrors.New(err)))
+		return db.SongMetadata{}, false
+	}
+	if !found {
+		return db.SongMetadata{}, false
+	}
+
+	return db.SongMetadata{Title: track.Title, Artist: track.Artist, StoreURL: track.StoreURL}, true
+}
+
+// Link returns song with its store page, looking the song up and storing
+// what the store knows of it if it has none yet.
+func Link(ctx context.Context, dbClient db.DBClient, song db.Song) db.Song {
+	if song.StoreURL != "" {
+		return song
+	}
+
+	metadata, found := Metadata(ctx, song.Title, song.Artist)
+	if !found {
+		return song
+	}
+	if err := dbClient.SetSongMetadata(ctx, song.ID, metadata); err != nil {
+		utils.GetLogger().ErrorContext(ctx, "Error storing iTunes metadata", slog.Any("error", xerrors.New(err)))
+		return song
+	}
+
+	song.Title, song.Artist, song.StoreURL = metadata.Title, metadata.Artist, metadata.StoreURL
+	return song
+}
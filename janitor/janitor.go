@@ -0,0 +1,121 @@
+// Package janitor removes the temporary files that songs which failed to
+// save leave behind, such as downloads and converted WAV files, once they
+// are old enough that no running pipeline can still need them.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/quota"
+	"song-recognition/utils"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Result is what a sweep removed.
+type Result struct {
+	Files int
+	Bytes int64
+}
+
+// RunConfigured sweeps the temporary directory at the interval of the
+// config file until ctx is done. It returns at once if the janitor is
+// disabled.
+func RunConfigured(ctx context.Context) {
+	cfg := config.Get().Janitor
+	if cfg.IntervalMinutes == 0 {
+		return
+	}
+	Run(ctx, time.Duration(cfg.IntervalMinutes)*time.Minute, time.Duration(cfg.MaxAgeMinutes)*time.Minute)
+}
+
+// Run sweeps the temporary directory every interval, starting now, until
+// ctx is done, logging what every sweep reclaimed.
+func Run(ctx context.Context, interval, maxAge time.Duration) error {
+	logger := utils.GetLogger()
+
+	for {
+		result, err := Sweep(ctx, quota.TmpDir, maxAge)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error sweeping temporary files", slog.Any("error", xerrors.New(err)))
+		}
+		if result.Files > 0 {
+			logger.InfoContext(ctx, "Removed stale temporary files", slog.Int("files", result.Files), slog.Int64("bytes", result.Bytes))
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Sweep removes the files under dir last modified over maxAge ago, and the
+// directories they leave empty. The state files kept there, with a .json
+// extension, are left alone, as are the resumable downloads and the
+// download cache, which expire on their own. Files that can't be removed
+// are logged and skipped.
+func Sweep(ctx context.Context, dir string, maxAge time.Duration) (Result, error) {
+	logger := utils.GetLogger()
+	var result Result
+
+	skip := map[string]bool{filepath.Join(dir, "partial"): true}
+	if cacheDir := config.Get().Download.CacheDir; cacheDir != "" {
+		skip[filepath.Clean(cacheDir)] = true
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() {
+			if skip[path] {
+				return filepath.SkipDir
+			}
+			// Directories are judged by their age before the sweep
+			// removes anything from them
+			if info, err := entry.Info(); err == nil && path != dir && info.ModTime().Before(cutoff) {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			logger.WarnContext(ctx, "Failed to remove stale temporary file", slog.String("path", path), slog.Any("error", xerrors.New(err)))
+			return nil
+		}
+		result.Files++
+		result.Bytes += info.Size()
+		return nil
+	})
+
+	// Deepest first, so parents emptied by their children go too.
+	// os.Remove only removes empty directories.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return result, err
+}
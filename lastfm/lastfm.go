@@ -0,0 +1,227 @@
+// Package lastfm scrobbles songs to the Last.fm listening history of users,
+// so the songs recognized for them show up there.
+//
+// Requests are signed with the key and secret of a Last.fm API account, and
+// scrobbles are made on behalf of users with the session keys they granted
+// it.
+package lastfm
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// DefaultURL is the root of the Last.fm API.
+const DefaultURL = "https://ws.audioscrobbler.com/2.0/"
+
+// AuthURL is the page users grant an API account access to their profile
+// on, given its api_key and a token.
+const AuthURL = "https://www.last.fm/api/auth/"
+
+// httpTimeout bounds a single request.
+const httpTimeout = 10 * time.Second
+
+// Scrobble is a song a user listened to.
+type Scrobble struct {
+	Artist string
+	Track  string
+	Album  string
+	// Timestamp is when the user started listening to the song.
+	Timestamp time.Time
+	// Duration is the length of the song, zero if unknown.
+	Duration time.Duration
+}
+
+// Client calls the Last.fm API as an API account.
+type Client struct {
+	APIKey string
+	Secret string
+	// URL is that of the Last.fm API, and overridable for tests.
+	URL    string
+	Client *http.Client
+}
+
+// NewClient returns a client of the API account with apiKey and secret.
+func NewClient(apiKey, secret string) *Client {
+	return &Client{APIKey: apiKey, Secret: secret, URL: DefaultURL, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns the client configured in the config file, or nil when
+// scrobbling is disabled.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		if cfg := config.Get().LastFM; cfg.APIKey != "" {
+			defaultClient = NewClient(cfg.APIKey, cfg.Secret)
+		}
+	})
+	return defaultClient
+}
+
+// Scrobble adds scrobble to the listening history of the user with
+// sessionKey.
+func (c *Client) Scrobble(ctx context.Context, sessionKey string, scrobble Scrobble) error {
+	params := url.Values{
+		"method":    {"track.scrobble"},
+		"sk":        {sessionKey},
+		"artist":    {scrobble.Artist},
+		"track":     {scrobble.Track},
+		"timestamp": {strconv.FormatInt(scrobble.Timestamp.Unix(), 10)},
+	}
+	if scrobble.Album != "" {
+		params.Set("album", scrobble.Album)
+	}
+	if scrobble.Duration > 0 {
+		params.Set("duration", strconv.Itoa(int(scrobble.Duration.Seconds())))
+	}
+
+	var response struct {
+		Scrobbles struct {
+			Attr struct {
+				Ignored int `json:"ignored"`
+			} `json:"@attr"`
+		} `json:"scrobbles"`
+	}
+	if err := c.call(ctx, http.MethodPost, params, &response); err != nil {
+		return fmt.Errorf("failed to scrobble: %v", err)
+	}
+	if response.Scrobbles.Attr.Ignored > 0 {
+		return fmt.Errorf("Last.fm ignored the scrobble of '%s' by '%s'", scrobble.Track, scrobble.Artist)
+	}
+	return nil
+}
+
+// Token returns a token for a user to authorize at AuthTokenURL, before
+// Session exchanges it for their session key.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := c.call(ctx, http.MethodGet, url.Values{"method": {"auth.getToken"}}, &response); err != nil {
+		return "", fmt.Errorf("failed to get token: %v", err)
+	}
+	return response.Token, nil
+}
+
+// AuthTokenURL returns the page a user authorizes token on.
+func (c *Client) AuthTokenURL(token string) string {
+	return AuthURL + "?" + url.Values{"api_key": {c.APIKey}, "token": {token}}.Encode()
+}
+
+// Session returns the session key and user name of the user who authorized
+// token. Session keys don't expire.
+func (c *Client) Session(ctx context.Context, token string) (string, string, error) {
+	var response struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	params := url.Values{"method": {"auth.getSession"}, "token": {token}}
+	if err := c.call(ctx, http.MethodGet, params, &response); err != nil {
+		return "", "", fmt.Errorf("failed to get session: %v", err)
+	}
+	return response.Session.Key, response.Session.Name, nil
+}
+
+// call makes the signed API call of params and decodes its JSON response
+// into v.
+func (c *Client) call(ctx context.Context, method string, params url.Values, v any) error {
+	params.Set("api_key", c.APIKey)
+	params.Set("api_sig", c.signature(params))
+	params.Set("format", "json")
+
+	var req *http.Request
+	var err error
+	if method == http.MethodPost {
+		req, err = http.NewRequestWithContext(ctx, method, c.URL, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, c.URL+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("invalid Last.fm response (status %d): %v", resp.StatusCode, err)
+	}
+	if err := json.Unmarshal(raw, &body); err == nil && body.Error != 0 {
+		return fmt.Errorf("Last.fm error %d: %s", body.Error, body.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Last.fm responded with status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// signature signs params as Last.fm asks: the MD5 of every name and value,
+// sorted by name, followed by the secret.
+func (c *Client) signature(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+	b.WriteString(c.Secret)
+
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ScrobbleInBackground scrobbles with the configured client for the user
+// with sessionKey, without waiting for Last.fm. It does nothing when
+// scrobbling is disabled, and failures are logged.
+func ScrobbleInBackground(ctx context.Context, sessionKey string, scrobble Scrobble) {
+	client := Default()
+	if client == nil || sessionKey == "" {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := client.Scrobble(ctx, sessionKey, scrobble); err != nil {
+			utils.GetLogger().ErrorContext(ctx, "Last.fm scrobble failed",
+				slog.String("title", scrobble.Track), slog.String("artist", scrobble.Artist), slog.Any("error", xerrors.New(err)))
+		}
+	}()
+}
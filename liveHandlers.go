@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"song-recognition/shazam"
+	"song-recognition/utils"
+	"song-recognition/wav"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mdobak/go-xerrors"
+)
+
+// liveCandidates is the number of candidates sent in progress events.
+const liveCandidates = 5
+
+// liveReadTimeout closes live sessions whose client stops sending audio.
+const liveReadTimeout = 30 * time.Second
+
+var liveUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// liveEvent is a message pushed to live recognition clients. Type is
+// "candidates" after every analysed window, then "match" once a candidate
+// is confident, or "no_match" when the clip ends without one.
+type liveEvent struct {
+	Type     string         `json:"type"`
+	Match    *shazam.Match  `json:"match,omitempty"`
+	Matches  []shazam.Match `json:"matches,omitempty"`
+	Duration float64        `json:"duration"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// handleLiveRecognition serves the /ws/recognize WebSocket. The client
+// streams mono PCM from a microphone as binary messages, in the format
+// given by the query parameters:
+//
+//	sample_rate     samples per second (default 44100)
+//	format          "s16" for 16-bit little-endian integers (default) or "f32"
+//	                for 32-bit little-endian floats, as produced by Web Audio
+//	lastfm_session  session key of a Last.fm user to scrobble the match for
+//
+// A text message "end" marks the end of the clip. The server pushes a
+// "match" event as soon as a match is confident and closes the session.
+func handleLiveRecognition(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	sampleRate := wav.StandardSampleRate
+	if rate := r.URL.Query().Get("sample_rate"); rate != "" {
+		parsed, err := strconv.Atoi(rate)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid sample_rate")
+			return
+		}
+		sampleRate = parsed
+	}
+
+	bitsPerSample, audioFormat := 16, wav.WaveFormatPCM
+	switch r.URL.Query().Get("format") {
+	case "", "s16":
+	case "f32":
+		bitsPerSample, audioFormat = 32, wav.WaveFormatIEEEFloat
+	default:
+		writeJSONError(w, http.StatusBadRequest, "format must be s16 or f32")
+		return
+	}
+
+	recognizer, err := shazam.NewLiveRecognizer(sampleRate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	recognizer.Options.TopN = liveCandidates
+
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has already replied to the client
+	}
+	defer conn.Close()
+
+	// send pushes an event and reports whether the session should go on
+	send := func(event liveEvent) bool {
+		if err := conn.WriteJSON(event); err != nil {
+			return false
+		}
+		return event.Type == "candidates"
+	}
+
+	report := func(result *shazam.LiveResult) bool {
+		if result == nil {
+			return true
+		}
+		if result.Best != nil {
+			recordRecognition(ctx, result.Matches)
+			scrobbleMatch(ctx, r.URL.Query().Get("lastfm_session"), result.Best.SongTitle, result.Best.SongArtist, result.Best.Timestamp, result.Duration)
+			return send(liveEvent{Type: "match", Match: result.Best, Duration: result.Duration})
+		}
+
+		return send(liveEvent{Type: "candidates", Matches: result.Matches, Duration: result.Duration})
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(liveReadTimeout))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.InfoContext(ctx, "live recognition session ended", slog.Any("error", err))
+			}
+			return
+		}
+
+		if messageType == websocket.TextMessage {
+			if string(data) != "end" {
+				continue
+			}
+
+			result, err := recognizer.Flush(ctx)
+			if err != nil {
+				logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+				send(liveEvent{Type: "error", Error: "failed to get matches"})
+				return
+			}
+			if report(result) {
+				recordRecognition(ctx, nil)
+				send(liveEvent{Type: "no_match", Duration: result.Duration})
+			}
+			return
+		}
+
+		if len(data)%(bitsPerSample/8) != 0 {
+			send(liveEvent{Type: "error", Error: "audio message is not a whole number of samples"})
+			return
+		}
+
+		samples, err := wav.BytesToSamples(data, bitsPerSample, audioFormat)
+		if err != nil {
+			send(liveEvent{Type: "error", Error: err.Error()})
+			return
+		}
+
+		result, err := recognizer.Feed(ctx, samples)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", xerrors.New(err)))
+			send(liveEvent{Type: "error", Error: "failed to get matches"})
+			return
+		}
+		if !report(result) {
+			return
+		}
+
+		if recognizer.Done() {
+			recordRecognition(ctx, nil)
+			send(liveEvent{Type: "no_match", Duration: result.Duration})
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package lyrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLRCLibURL is the lookup endpoint of LRCLIB.
+const DefaultLRCLibURL = "https://lrclib.net/api/get"
+
+// httpTimeout bounds a single lookup.
+const httpTimeout = 10 * time.Second
+
+// LRCLib looks up lyrics in LRCLIB (https://lrclib.net), a free database of
+// lyrics, many of them synced.
+type LRCLib struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewLRCLib returns a provider asking LRCLIB.
+func NewLRCLib() *LRCLib {
+	return &LRCLib{URL: DefaultLRCLibURL, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+func (p *LRCLib) Lyrics(ctx context.Context, title, artist string) (Lyrics, bool, error) {
+	params := url.Values{"track_name": {title}, "artist_name": {artist}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+"?"+params.Encode(), nil)
+	if err != nil {
+		return Lyrics{}, false, err
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return Lyrics{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Lyrics{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Lyrics{}, false, fmt.Errorf("LRCLIB responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	var response struct {
+		Instrumental bool   `json:"instrumental"`
+		PlainLyrics  string `json:"plainLyrics"`
+		SyncedLyrics string `json:"syncedLyrics"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return Lyrics{}, false, fmt.Errorf("invalid LRCLIB response: %v", err)
+	}
+	if response.Instrumental || response.PlainLyrics == "" && response.SyncedLyrics == "" {
+		return Lyrics{}, false, nil
+	}
+
+	return Lyrics{
+		Lines: parseLRC(response.SyncedLyrics),
+		Text:  response.PlainLyrics,
+	}, true, nil
+}
+
+// parseLRC reads lyrics in the LRC format, where every line starts with
+// the time it is sung at, such as "[01:23.45] words". Lines without a time
+// are dropped.
+func parseLRC(lrc string) []Line {
+	var lines []Line
+
+	scanner := bufio.NewScanner(strings.NewReader(lrc))
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "[")
+		if !ok {
+			continue
+		}
+		stamp, text, ok := strings.Cut(line, "]")
+		if !ok {
+			continue
+		}
+		minutes, seconds, ok := strings.Cut(stamp, ":")
+		if !ok {
+			continue
+		}
+
+		m, err := strconv.Atoi(minutes)
+		if err != nil {
+			continue
+		}
+		s, err := strconv.ParseFloat(seconds, 64)
+		if err != nil {
+			continue
+		}
+
+		lines = append(lines, Line{
+			Time: time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)),
+			Text: strings.TrimSpace(text),
+		})
+	}
+
+	return lines
+}
@@ -0,0 +1,139 @@
+// Package lyrics looks up the lyrics of recognized songs, so matches can
+// show the lines being sung.
+//
+// Lyrics come from providers. Deployments can embed their own by calling
+// Register from an init function, or enable the built-in LRCLIB provider in
+// the config file. Providers are asked in the order they were registered
+// until one has the song.
+package lyrics
+
+import (
+	"context"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Lyrics are the words of a song.
+type Lyrics struct {
+	// Lines are the lyrics with the time each line is sung at, if the
+	// provider knows them.
+	Lines []Line
+	// Text is the plain lyrics, one line per line.
+	Text string
+	// URL is a page showing the lyrics, if the provider has one.
+	URL string
+}
+
+// Line is a line of lyrics and when it is sung.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+// Provider looks up the lyrics of songs. It reports false for songs it
+// doesn't know.
+type Provider interface {
+	Lyrics(ctx context.Context, title, artist string) (Lyrics, bool, error)
+}
+
+type registered struct {
+	name     string
+	provider Provider
+}
+
+var (
+	mu         sync.RWMutex
+	providers  []registered
+	configOnce sync.Once
+)
+
+// Register adds a provider under name, asked after the ones registered
+// before it.
+func Register(name string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers = append(providers, registered{name, provider})
+}
+
+// registerConfigured registers the built-in providers enabled in the config
+// file.
+func registerConfigured() {
+	if config.Get().Lyrics.LRCLib {
+		Register("lrclib", NewLRCLib())
+	}
+}
+
+// Enabled reports whether any provider is registered.
+func Enabled() bool {
+	configOnce.Do(registerConfigured)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(providers) > 0
+}
+
+// Find returns the lyrics of a song from the first provider that has them
+// and the name of that provider. A provider that fails is logged and
+// skipped.
+func Find(ctx context.Context, title, artist string) (Lyrics, string, bool) {
+	configOnce.Do(registerConfigured)
+	logger := utils.GetLogger()
+
+	mu.RLock()
+	current := append([]registered(nil), providers...)
+	mu.RUnlock()
+
+	for _, p := range current {
+		lyrics, found, err := p.provider.Lyrics(ctx, title, artist)
+		if err != nil {
+			logger.ErrorContext(ctx, "Lyrics provider failed", slog.String("provider", p.name), slog.Any("error", xerrors.New(err)))
+			continue
+		}
+		if found {
+			return lyrics, p.name, true
+		}
+	}
+
+	return Lyrics{}, "", false
+}
+
+// snippetLines is the number of lines in a snippet.
+const snippetLines = 2
+
+// Snippet returns a few lines of the lyrics: those sung from offset into the
+// song when their timing is known, or else the first ones.
+func (l Lyrics) Snippet(offset time.Duration) string {
+	var lines []string
+	if len(l.Lines) > 0 {
+		// Start at the line being sung at offset
+		start := 0
+		for i, line := range l.Lines {
+			if line.Time > offset {
+				break
+			}
+			start = i
+		}
+		for _, line := range l.Lines[start:] {
+			lines = append(lines, line.Text)
+		}
+	} else {
+		lines = strings.Split(l.Text, "\n")
+	}
+
+	var snippet []string
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			snippet = append(snippet, line)
+		}
+		if len(snippet) == snippetLines {
+			break
+		}
+	}
+	return strings.Join(snippet, "\n")
+}
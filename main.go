@@ -6,7 +6,16 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"runtime"
+	"song-recognition/bandcamp"
+	"song-recognition/chromaprint"
+	"song-recognition/config"
+	"song-recognition/previews"
+	"song-recognition/shazam"
+	"song-recognition/song"
+	"song-recognition/spotify"
 	"song-recognition/utils"
+	"strings"
 
 	"github.com/mdobak/go-xerrors"
 )
@@ -30,7 +39,7 @@ func main() {
 	}
 
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'find', 'download', 'erase', 'save', 'process-json', or 'serve' subcommands")
+		fmt.Println("Expected 'find', 'tracklist', 'monitor', 'download', 'ingest-spotify', 'ingest-youtube', 'ingest-previews', 'ingest-bandcamp', 'lastfm-login', 'erase', 'reindex', 'prune', 'lsh-index', 'build-index', 'shard', 'export-chromaprint', 'export-catalog', 'import-catalog', 'spectrogram', 'save', 'process-json', 'process-file', 'import-csv', 'jobs', 'migrate', 'backup', 'restore', or 'serve' subcommands")
 		os.Exit(1)
 	}
 
@@ -42,6 +51,31 @@ func main() {
 		}
 		filePath := os.Args[2]
 		find(filePath)
+	case "tracklist":
+		tracklistCmd := flag.NewFlagSet("tracklist", flag.ExitOnError)
+		window := tracklistCmd.Float64("window", shazam.DefaultTracklistWindow, "seconds of audio recognized at a time")
+		hop := tracklistCmd.Float64("hop", shazam.DefaultTracklistHop, "seconds between the starts of consecutive windows")
+		tracklistCmd.Parse(os.Args[2:])
+		if tracklistCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go tracklist [-window S] [-hop S] <path_to_wav_file>")
+			os.Exit(1)
+		}
+		tracklist(tracklistCmd.Arg(0), *window, *hop)
+	case "monitor":
+		monitorCmd := flag.NewFlagSet("monitor", flag.ExitOnError)
+		name := monitorCmd.String("name", "", "name the detections are recorded under (default: the stream URL)")
+		window := monitorCmd.Float64("window", shazam.DefaultTracklistWindow, "seconds of audio recognized at a time")
+		hop := monitorCmd.Float64("hop", shazam.DefaultTracklistHop, "seconds between the starts of consecutive windows")
+		monitorCmd.Parse(os.Args[2:])
+		if monitorCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go monitor [-name N] [-window S] [-hop S] <stream_url>")
+			os.Exit(1)
+		}
+		streamName := *name
+		if streamName == "" {
+			streamName = monitorCmd.Arg(0)
+		}
+		monitorStream(streamName, monitorCmd.Arg(0), *window, *hop)
 	case "download":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: main.go download <spotify_url>")
@@ -49,14 +83,132 @@ func main() {
 		}
 		url := os.Args[2]
 		download(url)
+	case "ingest-spotify":
+		ingestCmd := flag.NewFlagSet("ingest-spotify", flag.ExitOnError)
+		workers := ingestCmd.Int("workers", runtime.NumCPU(), "number of tracks to process concurrently")
+		previews := ingestCmd.Bool("previews", false, "register Spotify's 30 second previews instead of matching tracks on YouTube")
+		clientID := ingestCmd.String("client-id", os.Getenv("SPOTIFY_CLIENT_ID"), "Spotify app client ID")
+		clientSecret := ingestCmd.String("client-secret", os.Getenv("SPOTIFY_CLIENT_SECRET"), "Spotify app client secret")
+		ingestCmd.Parse(os.Args[2:])
+		if ingestCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go ingest-spotify [-workers N] [-previews] [-client-id ID] [-client-secret S] <playlist_url>")
+			os.Exit(1)
+		}
+		ingestSpotifyPlaylist(ingestCmd.Arg(0), *clientID, *clientSecret, spotify.IngestOptions{Workers: *workers, Previews: *previews})
+	case "ingest-youtube":
+		ingestCmd := flag.NewFlagSet("ingest-youtube", flag.ExitOnError)
+		workers := ingestCmd.Int("workers", runtime.NumCPU(), "number of videos to process concurrently")
+		state := ingestCmd.String("state", "", "file the progress is saved to (default: tmp/youtube-<playlist_id>.json)")
+		ingestCmd.Parse(os.Args[2:])
+		if ingestCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go ingest-youtube [-workers N] [-state <path>] <playlist_url>")
+			os.Exit(1)
+		}
+		ingestYouTubePlaylist(ingestCmd.Arg(0), spotify.YouTubeIngestOptions{Workers: *workers, StatePath: *state})
+	case "ingest-previews":
+		ingestCmd := flag.NewFlagSet("ingest-previews", flag.ExitOnError)
+		catalog := ingestCmd.String("catalog", "deezer", "catalog the previews are taken from: "+strings.Join(previews.Names(), " or "))
+		limit := ingestCmd.Int("limit", 50, "most tracks to register")
+		workers := ingestCmd.Int("workers", runtime.NumCPU(), "number of previews to process concurrently")
+		ingestCmd.Parse(os.Args[2:])
+		if *limit <= 0 {
+			fmt.Println("Usage: main.go ingest-previews [-catalog deezer|itunes] [-limit N] [-workers N] [search terms]")
+			os.Exit(1)
+		}
+		ingestPreviews(*catalog, strings.Join(ingestCmd.Args(), " "), previews.IngestOptions{Workers: *workers, Limit: *limit})
+	case "ingest-bandcamp":
+		ingestCmd := flag.NewFlagSet("ingest-bandcamp", flag.ExitOnError)
+		workers := ingestCmd.Int("workers", runtime.NumCPU(), "number of tracks to process concurrently")
+		ingestCmd.Parse(os.Args[2:])
+		if ingestCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go ingest-bandcamp [-workers N] <album_url>")
+			os.Exit(1)
+		}
+		ingestBandcampAlbum(ingestCmd.Arg(0), bandcamp.IngestOptions{Workers: *workers})
+	case "lastfm-login":
+		lastFMLogin()
 	case "serve":
 		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
 		protocol := serveCmd.String("proto", "http", "Protocol to use (http or https)")
 		port := serveCmd.String("p", "5000", "Port to use")
+		grpcPort := serveCmd.String("grpc-port", "50051", "Port for the gRPC API (empty to disable)")
 		serveCmd.Parse(os.Args[2:])
-		serve(*protocol, *port)
+		serve(*protocol, *port, *grpcPort)
 	case "erase":
 		erase(SONGS_DIR)
+	case "reindex":
+		reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+		workers := reindexCmd.Int("workers", runtime.NumCPU(), "number of songs to reindex concurrently")
+		reindexCmd.Parse(os.Args[2:])
+		dir := SONGS_DIR
+		if reindexCmd.NArg() > 0 {
+			dir = reindexCmd.Arg(0)
+		}
+		reindex(dir, *workers)
+	case "prune":
+		pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+		maxSongs := pruneCmd.Int("max-songs", config.Get().Prune.MaxSongs, "most songs an address may have fingerprints of")
+		dryRun := pruneCmd.Bool("dry-run", false, "count the fingerprints to prune without removing them")
+		pruneCmd.Parse(os.Args[2:])
+		if *maxSongs < 1 {
+			fmt.Println("-max-songs must be at least 1")
+			os.Exit(1)
+		}
+		pruneFingerprints(*maxSongs, *dryRun)
+	case "lsh-index":
+		buildLSHIndex()
+	case "build-index":
+		indexCmd := flag.NewFlagSet("build-index", flag.ExitOnError)
+		output := indexCmd.String("o", config.Get().Index.Path, "file to write the index to")
+		indexCmd.Parse(os.Args[2:])
+		buildFingerprintIndex(*output)
+	case "shard":
+		moveFingerprintsToShards()
+	case "export-chromaprint":
+		exportCmd := flag.NewFlagSet("export-chromaprint", flag.ExitOnError)
+		length := exportCmd.Float64("length", chromaprint.DefaultLength, "seconds of every song to fingerprint (0 for all)")
+		output := exportCmd.String("o", "", "file to write the fingerprints to instead of stdout")
+		exportCmd.Parse(os.Args[2:])
+		dir := SONGS_DIR
+		if exportCmd.NArg() > 0 {
+			dir = exportCmd.Arg(0)
+		}
+		exportChromaprints(dir, *length, *output)
+	case "export-catalog":
+		exportCmd := flag.NewFlagSet("export-catalog", flag.ExitOnError)
+		output := exportCmd.String("o", "", "file to write the catalog to instead of stdout (compressed if it ends in .gz)")
+		exportCmd.Parse(os.Args[2:])
+		exportCatalog(*output)
+	case "import-catalog":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: main.go import-catalog <path_to_catalog_file>")
+			os.Exit(1)
+		}
+		importCatalog(os.Args[2])
+	case "backup":
+		backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+		output := backupCmd.String("o", "", "file to write the backup to instead of stdout")
+		backupCmd.Parse(os.Args[2:])
+		backupInstance(*output)
+	case "restore":
+		restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+		force := restoreCmd.Bool("force", false, "replace the database if there is one")
+		restoreCmd.Parse(os.Args[2:])
+		if restoreCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go restore [-force] <path_to_backup>")
+			os.Exit(1)
+		}
+		restoreInstance(restoreCmd.Arg(0), *force)
+	case "spectrogram":
+		spectrogramCmd := flag.NewFlagSet("spectrogram", flag.ExitOnError)
+		peaks := spectrogramCmd.Bool("peaks", false, "mark the peaks fingerprints are made of")
+		output := spectrogramCmd.String("o", "", "path of the PNG image (default: <audio_file>.png)")
+		spectrogramCmd.Parse(os.Args[2:])
+		if spectrogramCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go spectrogram [-peaks] [-o <path>] <path_to_audio_file>")
+			os.Exit(1)
+		}
+		renderSpectrogram(spectrogramCmd.Arg(0), *output, *peaks)
 	case "save":
 		indexCmd := flag.NewFlagSet("save", flag.ExitOnError)
 		force := indexCmd.Bool("force", false, "save song with or without YouTube ID")
@@ -75,8 +227,43 @@ func main() {
 		}
 		jsonPath := os.Args[2]
 		processSongFromJSON(jsonPath)
+	case "process-file":
+		fileCmd := flag.NewFlagSet("process-file", flag.ExitOnError)
+		title := fileCmd.String("title", "", "song title (default: read from tags or the file name)")
+		artist := fileCmd.String("artist", "", "song artist (default: read from tags or the file name)")
+		ytID := fileCmd.String("youtube-id", "", "YouTube ID of the song")
+		workers := fileCmd.Int("workers", runtime.NumCPU(), "number of files to process concurrently")
+		fileCmd.Parse(os.Args[2:])
+		if fileCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go process-file [-title T] [-artist A] [-youtube-id ID] [-workers N] <path_to_audio_file_or_dir>")
+			os.Exit(1)
+		}
+		input := song.SongInput{Title: *title, Artist: *artist, YoutubeID: *ytID}
+		processSongFromFile(fileCmd.Arg(0), input, *workers)
+	case "import-csv":
+		importCmd := flag.NewFlagSet("import-csv", flag.ExitOnError)
+		workers := importCmd.Int("workers", runtime.NumCPU(), "number of songs to process concurrently")
+		report := importCmd.String("report", "", "path of the results report (default: <csv>_report.csv)")
+		importCmd.Parse(os.Args[2:])
+		if importCmd.NArg() < 1 {
+			fmt.Println("Usage: main.go import-csv [-workers N] [-report <path>] <path_to_csv_file>")
+			os.Exit(1)
+		}
+		importCSV(importCmd.Arg(0), *report, *workers)
+	case "jobs":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: main.go jobs <dead | requeue <job_id>... | purge>")
+			os.Exit(1)
+		}
+		manageJobs(os.Args[2], os.Args[3:])
+	case "migrate":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: main.go migrate <status | up | down | to <version>>")
+			os.Exit(1)
+		}
+		migrateSchema(os.Args[2], os.Args[3:])
 	default:
-		fmt.Println("Expected 'find', 'download', 'erase', 'save', 'process-json', or 'serve' subcommands")
+		fmt.Println("Expected 'find', 'tracklist', 'monitor', 'download', 'ingest-spotify', 'ingest-youtube', 'ingest-previews', 'ingest-bandcamp', 'lastfm-login', 'erase', 'reindex', 'prune', 'lsh-index', 'build-index', 'shard', 'export-chromaprint', 'export-catalog', 'import-catalog', 'spectrogram', 'save', 'process-json', 'process-file', 'import-csv', 'jobs', 'migrate', 'backup', 'restore', or 'serve' subcommands")
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a detection as published on the event feed.
+type Event struct {
+	Stream     string    `json:"stream"`
+	SongID     uint32    `json:"song_id"`
+	SongTitle  string    `json:"song_title"`
+	SongArtist string    `json:"song_artist"`
+	YouTubeID  string    `json:"youtube_id,omitempty"`
+	Score      float64   `json:"score"`
+	OffsetMs   uint32    `json:"offset_ms"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// further detections are dropped for it.
+const eventBuffer = 32
+
+// eventBroker fans out the detections of the monitors running in this
+// process to subscribers.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+var events = &eventBroker{subscribers: make(map[chan Event]struct{})}
+
+// Subscribe returns a channel receiving every detection made by the
+// monitors in this process from now on. Call the returned function to
+// unsubscribe; it closes the channel.
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	events.mu.Lock()
+	events.subscribers[ch] = struct{}{}
+	events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			events.mu.Lock()
+			delete(events.subscribers, ch)
+			events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish delivers event without blocking the monitor: subscribers whose
+// buffer is full miss it.
+func (b *eventBroker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package monitor watches live audio streams, such as internet radio
+// stations, and records every song heard on them. Each detection is stored
+// in the database with its UTC time and published to the subscribers of
+// the event feed.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/shazam"
+	"song-recognition/utils"
+	"song-recognition/wav"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// Reconnection policy of a monitor whose stream fails or ends. The delay
+// doubles on every further failure and starts over once audio comes
+// through again.
+const (
+	reconnectBaseDelay = 2 * time.Second
+	reconnectMaxDelay  = time.Minute
+)
+
+// stallTimeout is how long a stream may go without delivering audio before
+// the monitor reconnects.
+const stallTimeout = 30 * time.Second
+
+// Monitor recognizes the audio of a stream in overlapping windows and
+// records a detection whenever a song starts being heard.
+type Monitor struct {
+	Stream config.Stream
+	// Window is the seconds of audio recognized at a time and Hop the
+	// seconds between the starts of consecutive windows.
+	Window float64
+	Hop    float64
+	// Options applies to the recognition of each window.
+	Options shazam.MatchOptions
+	// OnDetection, if set, is called with every detection after it has been
+	// recorded.
+	OnDetection func(Event)
+}
+
+// New returns a monitor of stream using the default window, hop and match
+// options.
+func New(stream config.Stream) *Monitor {
+	return &Monitor{
+		Stream:  stream,
+		Window:  shazam.DefaultTracklistWindow,
+		Hop:     shazam.DefaultTracklistHop,
+		Options: shazam.DefaultMatchOptions(),
+	}
+}
+
+// RunConfigured runs a monitor of every stream in the config file until ctx
+// is done.
+func RunConfigured(ctx context.Context) {
+	cfg := config.Get().Monitor
+
+	var wg sync.WaitGroup
+	for _, stream := range cfg.Streams {
+		m := New(stream)
+		m.Window, m.Hop = cfg.Window, cfg.Hop
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Run monitors the stream until ctx is done, reconnecting whenever the
+// stream fails or ends.
+func (m *Monitor) Run(ctx context.Context) error {
+	logger := utils.GetLogger()
+	delay := reconnectBaseDelay
+
+	for {
+		logger.InfoContext(ctx, "Monitoring stream", slog.String("stream", m.Stream.Name), slog.String("url", m.Stream.URL))
+
+		heard, err := m.watch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if heard {
+			delay = reconnectBaseDelay
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "Stream failed", slog.String("stream", m.Stream.Name), slog.Any("error", xerrors.New(err)))
+		} else {
+			logger.InfoContext(ctx, "Stream ended", slog.String("stream", m.Stream.Name))
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// watch monitors a single connection to the stream until it fails or ends.
+// It reports whether any audio came through.
+func (m *Monitor) watch(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	src, err := open(ctx, m.Stream.URL)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	stalled := errors.New("stream stalled")
+	watchdog := time.AfterFunc(stallTimeout, func() { cancel(stalled) })
+	defer watchdog.Stop()
+
+	reader := &watchedReader{r: src, watchdog: watchdog}
+
+	var (
+		current db.Detection // the song being heard, if any
+		lastEnd float64      // seconds into the connection it was last heard
+	)
+
+	opts := shazam.TracklistOptions{Window: m.Window, Hop: m.Hop, Match: m.Options}
+	err = shazam.RecognizeWindows(ctx, reader, wav.StandardSampleRate, opts, func(match shazam.Match, start, end float64) error {
+		// Windows in a row matching the same song are one play of it
+		samePlay := current.SongID == match.SongID && start <= lastEnd+m.Hop
+		lastEnd = end
+		if samePlay {
+			return nil
+		}
+
+		current = db.Detection{
+			Stream:     m.Stream.Name,
+			SongID:     match.SongID,
+			Score:      match.Score,
+			OffsetMs:   match.Timestamp,
+			DetectedAt: time.Now().UTC(),
+		}
+		m.detect(ctx, current, match)
+		return nil
+	})
+
+	if cause := context.Cause(ctx); cause == stalled {
+		return reader.heard, cause
+	}
+	return reader.heard, err
+}
+
+// detect records a detection and publishes it. A failure to record it
+// doesn't stop the monitor.
+func (m *Monitor) detect(ctx context.Context, detection db.Detection, match shazam.Match) {
+	logger := utils.GetLogger()
+
+	if err := recordDetection(ctx, detection); err != nil {
+		logger.ErrorContext(ctx, "failed to record detection.", slog.Any("error", xerrors.New(err)))
+	}
+
+	event := Event{
+		Stream:     detection.Stream,
+		SongID:     detection.SongID,
+		SongTitle:  match.SongTitle,
+		SongArtist: match.SongArtist,
+		YouTubeID:  match.YouTubeID,
+		Score:      detection.Score,
+		OffsetMs:   detection.OffsetMs,
+		DetectedAt: detection.DetectedAt,
+	}
+	events.publish(event)
+
+	if m.OnDetection != nil {
+		m.OnDetection(event)
+	}
+}
+
+func recordDetection(ctx context.Context, detection db.Detection) error {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return dbClient.RecordDetection(ctx, detection)
+}
+
+// watchedReader resets a watchdog every time samples come through.
+type watchedReader struct {
+	r        shazam.SampleReader
+	watchdog *time.Timer
+	heard    bool
+}
+
+func (r *watchedReader) ReadSamples(dst []float64) (int, error) {
+	n, err := r.r.ReadSamples(dst)
+	if n > 0 {
+		r.heard = true
+		r.watchdog.Reset(stallTimeout)
+	}
+	return n, err
+}
+
+// Recent returns the latest limit detections on stream, or on all streams
+// if stream is empty, newest first.
+func Recent(ctx context.Context, stream string, limit int) ([]Event, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	detections, err := dbClient.ListDetections(ctx, stream, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	recent := make([]Event, 0, len(detections))
+	for _, detection := range detections {
+		event := Event{
+			Stream:     detection.Stream,
+			SongID:     detection.SongID,
+			Score:      detection.Score,
+			OffsetMs:   detection.OffsetMs,
+			DetectedAt: detection.DetectedAt,
+		}
+
+		// Songs deleted since keep their detections, without a title
+		song, exists, err := dbClient.GetSongByID(ctx, detection.SongID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			event.SongTitle, event.SongArtist, event.YouTubeID = song.Title, song.Artist, song.YouTubeID
+		}
+
+		recent = append(recent, event)
+	}
+
+	return recent, nil
+}
@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"song-recognition/decode"
+	"strings"
+	"time"
+)
+
+// connectTimeout bounds how long a stream may take to start responding.
+const connectTimeout = 15 * time.Second
+
+// httpClient has no overall timeout, since streams don't end.
+var httpClient = func() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = connectTimeout
+	return &http.Client{Transport: transport}
+}()
+
+// source is a connected stream being decoded.
+type source struct {
+	*decode.Stream
+	close func() error
+}
+
+func (s *source) Close() error {
+	return s.close()
+}
+
+// open connects to streamURL and starts decoding it. Icecast and plain HTTP
+// streams are fetched directly; HLS playlists are handed to FFmpeg, which
+// follows the playlist's segments.
+func open(ctx context.Context, streamURL string) (*source, error) {
+	parsed, err := url.Parse(streamURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid stream URL: %s", streamURL)
+	}
+
+	if path.Ext(parsed.Path) == ".m3u8" {
+		return openHLS(ctx, streamURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream URL: %v", err)
+	}
+	req.Header.Set("User-Agent", "seek-tune")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("stream responded with %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if isHLSContentType(contentType) {
+		resp.Body.Close()
+		return openHLS(ctx, streamURL)
+	}
+	if decode.IsNonAudioContentType(contentType) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("stream is not audio: %s", contentType)
+	}
+
+	stream, err := decode.NewStream(ctx, resp.Body, decode.FormatFromContentType(contentType))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return &source{Stream: stream, close: func() error {
+		stream.Close()
+		return resp.Body.Close()
+	}}, nil
+}
+
+func openHLS(ctx context.Context, streamURL string) (*source, error) {
+	stream, err := decode.NewFFmpegStream(ctx, streamURL)
+	if err != nil {
+		return nil, err
+	}
+	return &source{Stream: stream, close: stream.Close}, nil
+}
+
+func isHLSContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == "application/vnd.apple.mpegurl" || mediaType == "application/x-mpegurl" || mediaType == "audio/mpegurl" || mediaType == "audio/x-mpegurl"
+}
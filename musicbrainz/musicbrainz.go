@@ -0,0 +1,286 @@
+// Package musicbrainz looks up recordings in the MusicBrainz database, to
+// fill in the album, release year and canonical artist name of songs.
+//
+// MusicBrainz allows about one request per second from every client, so
+// requests of a client are spaced out.
+package musicbrainz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// DefaultURL is the base URL of the MusicBrainz web service.
+const DefaultURL = "https://musicbrainz.org/ws/2"
+
+// httpTimeout bounds a single request.
+const httpTimeout = 10 * time.Second
+
+// requestInterval is the least time between the requests of a client.
+const requestInterval = time.Second
+
+// searchLimit is the number of recordings a search returns.
+const searchLimit = 5
+
+// Recording is a MusicBrainz recording.
+type Recording struct {
+	ID    string
+	Title string
+	// Artist is the canonical names of the credited artists, joined as
+	// credited, such as "Simon & Garfunkel".
+	Artist string
+	// Album and ReleaseYear are those of the recording's first official
+	// release, if known, and ReleaseID the MusicBrainz ID of Album.
+	Album       string
+	ReleaseYear int
+	ReleaseID   string
+	// Score is how well a searched recording fits the search, from 0 to
+	// 100. Recordings looked up by ID score 100.
+	Score int
+}
+
+// Client queries the MusicBrainz web service.
+type Client struct {
+	URL       string
+	UserAgent string
+	Client    *http.Client
+
+	mu          sync.Mutex
+	lastRequest time.Time
+}
+
+// NewClient returns a client for the MusicBrainz web service that
+// identifies itself with userAgent.
+func NewClient(userAgent string) *Client {
+	return &Client{URL: DefaultURL, UserAgent: userAgent, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns the client configured in the config file, shared so its
+// requests are spaced out together, or nil when lookups are disabled.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		if cfg := config.Get().MusicBrainz; cfg.Enabled {
+			defaultClient = NewClient(cfg.UserAgent)
+		}
+	})
+	return defaultClient
+}
+
+// Metadata looks up a song with the configured client and returns what
+// MusicBrainz knows of it. recordingID, if known, is used instead of title
+// and artist. It reports false when lookups are disabled, fail or find no
+// recording; failures are logged.
+func Metadata(ctx context.Context, title, artist, recordingID string) (db.SongMetadata, bool) {
+	client := Default()
+	if client == nil {
+		return db.SongMetadata{}, false
+	}
+
+	recording, found, err := client.Find(ctx, title, artist, recordingID, config.Get().MusicBrainz.MinScore)
+	if err != nil {
+		utils.GetLogger().ErrorContext(ctx, "MusicBrainz lookup failed",
+			slog.String("title", title), slog.String("artist", artist), slog.Any("error", xerrors.New(err)))
+		return db.SongMetadata{}, false
+	}
+	if !found {
+		return db.SongMetadata{}, false
+	}
+
+	return db.SongMetadata{
+		Artist:               recording.Artist,
+		Album:                recording.Album,
+		ReleaseYear:          recording.ReleaseYear,
+		MusicBrainzID:        recording.ID,
+		MusicBrainzReleaseID: recording.ReleaseID,
+	}, true
+}
+
+// Find returns the recording with ID recordingID, or, without one, the best
+// recording found by title and artist that scores at least minScore.
+func (c *Client) Find(ctx context.Context, title, artist, recordingID string, minScore int) (Recording, bool, error) {
+	if recordingID != "" {
+		return c.LookupRecording(ctx, recordingID)
+	}
+
+	recordings, err := c.Search(ctx, title, artist)
+	if err != nil {
+		return Recording{}, false, err
+	}
+	if len(recordings) == 0 || recordings[0].Score < minScore {
+		return Recording{}, false, nil
+	}
+	return recordings[0], true, nil
+}
+
+// Search returns the recordings of title by artist, best first.
+func (c *Client) Search(ctx context.Context, title, artist string) ([]Recording, error) {
+	query := fmt.Sprintf("recording:%s AND artist:%s", quote(title), quote(artist))
+	params := url.Values{"query": {query}, "limit": {strconv.Itoa(searchLimit)}}
+
+	var response struct {
+		Recordings []recording `json:"recordings"`
+	}
+	if _, err := c.get(ctx, "recording", params, &response); err != nil {
+		return nil, err
+	}
+
+	recordings := make([]Recording, len(response.Recordings))
+	for i, r := range response.Recordings {
+		recordings[i] = r.toRecording()
+	}
+	return recordings, nil
+}
+
+// LookupRecording returns the recording with a MusicBrainz ID.
+func (c *Client) LookupRecording(ctx context.Context, id string) (Recording, bool, error) {
+	var response recording
+	found, err := c.get(ctx, "recording/"+url.PathEscape(id), url.Values{"inc": {"artists releases"}}, &response)
+	if err != nil || !found {
+		return Recording{}, false, err
+	}
+
+	response.Score = 100
+	return response.toRecording(), true, nil
+}
+
+// get requests a resource in JSON and decodes it into v. It reports false
+// if there is no such resource.
+func (c *Client) get(ctx context.Context, resource string, params url.Values, v any) (bool, error) {
+	params.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/"+resource+"?"+params.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	if err := c.wait(ctx); err != nil {
+		return false, err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("MusicBrainz responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("invalid MusicBrainz response: %v", err)
+	}
+	return true, nil
+}
+
+// wait blocks until requestInterval has passed since the last request.
+func (c *Client) wait(ctx context.Context) error {
+	c.mu.Lock()
+	next := c.lastRequest.Add(requestInterval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.lastRequest = next
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// quote makes a phrase of a Lucene search term.
+func quote(term string) string {
+	term = strings.ReplaceAll(term, `\`, `\\`)
+	return `"` + strings.ReplaceAll(term, `"`, `\"`) + `"`
+}
+
+// recording is a recording in a MusicBrainz response.
+type recording struct {
+	ID               string `json:"id"`
+	Score            int    `json:"score"`
+	Title            string `json:"title"`
+	FirstReleaseDate string `json:"first-release-date"`
+	ArtistCredit     []struct {
+		Name       string `json:"name"`
+		JoinPhrase string `json:"joinphrase"`
+		Artist     struct {
+			Name string `json:"name"`
+		} `json:"artist"`
+	} `json:"artist-credit"`
+	Releases []struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Date   string `json:"date"`
+		Status string `json:"status"`
+	} `json:"releases"`
+}
+
+func (r recording) toRecording() Recording {
+	recording := Recording{ID: r.ID, Title: r.Title, Score: r.Score}
+
+	var artist strings.Builder
+	for _, credit := range r.ArtistCredit {
+		name := credit.Artist.Name
+		if name == "" {
+			name = credit.Name
+		}
+		artist.WriteString(name + credit.JoinPhrase)
+	}
+	recording.Artist = artist.String()
+
+	// Dates are "YYYY", "YYYY-MM" or "YYYY-MM-DD", so they sort as text.
+	// Unofficial releases, such as bootlegs, only count without others.
+	var firstDate string
+	firstOfficial := false
+	for _, release := range r.Releases {
+		official := release.Status == "Official"
+		if release.Date == "" || firstOfficial && !official {
+			continue
+		}
+		if firstDate == "" || official && !firstOfficial || release.Date < firstDate {
+			firstDate, firstOfficial = release.Date, official
+			recording.Album, recording.ReleaseID = release.Title, release.ID
+		}
+	}
+
+	if r.FirstReleaseDate != "" {
+		firstDate = r.FirstReleaseDate
+	}
+	if len(firstDate) >= 4 {
+		recording.ReleaseYear, _ = strconv.Atoi(firstDate[:4])
+	}
+
+	return recording
+}
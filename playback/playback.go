@@ -0,0 +1,150 @@
+// Package playback transcodes the stored WAV files of songs with FFmpeg as
+// they are played back, to a compressed stream or to the segments of an HLS
+// playlist, so clients don't download uncompressed audio.
+package playback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"song-recognition/config"
+	"song-recognition/storage"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of Stream.
+const (
+	AAC  = "aac"
+	Opus = "opus"
+)
+
+// format is how FFmpeg encodes a format of Stream.
+type format struct {
+	encoder     string
+	muxer       string
+	contentType string
+}
+
+var formats = map[string]format{
+	AAC:  {encoder: "aac", muxer: "adts", contentType: "audio/aac"},
+	Opus: {encoder: "libopus", muxer: "ogg", contentType: "audio/ogg"},
+}
+
+// SegmentContentType is the content type of HLS segments.
+const SegmentContentType = "video/mp2t"
+
+// PlaylistContentType is the content type of HLS playlists.
+const PlaylistContentType = "application/vnd.apple.mpegurl"
+
+// sourceExpiry is how long FFmpeg has to open the signed URL of a file
+// stored in a bucket.
+const sourceExpiry = time.Hour
+
+// ContentType returns the content type of a stream of format, or "" if
+// format isn't one.
+func ContentType(format string) string {
+	return formats[format].contentType
+}
+
+// Source returns what FFmpeg reads the file stored under key from: its path
+// in local storage, or else a signed URL of it.
+func Source(ctx context.Context, store storage.Storage, key string) (string, error) {
+	if local, ok := store.(*storage.Local); ok {
+		return utils.SafeJoin(local.Dir, key)
+	}
+	return store.SignedURL(ctx, key, sourceExpiry)
+}
+
+// Stream transcodes the audio of source to format and writes it to w as it
+// is encoded.
+func Stream(ctx context.Context, w io.Writer, source, format string) error {
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	args := []string{"-i", source}
+	args = append(args, encodeArgs(f.encoder, f.muxer)...)
+	return transcode(ctx, w, args)
+}
+
+// Playlist returns the HLS playlist of a song of duration seconds, split in
+// segments named "<index>.ts" relative to the playlist.
+func Playlist(duration float64) string {
+	segment := float64(config.Get().Playback.SegmentSeconds)
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(segment))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i := 0; i < Segments(duration); i++ {
+		length := math.Min(segment, duration-float64(i)*segment)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts\n", length, i)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// Segments returns the number of HLS segments of a song of duration
+// seconds.
+func Segments(duration float64) int {
+	return int(math.Ceil(duration / float64(config.Get().Playback.SegmentSeconds)))
+}
+
+// Segment returns the HLS segment of source at index, AAC audio in an
+// MPEG-TS stream timestamped from where the segment starts in the song, so
+// players join the segments seamlessly.
+func Segment(ctx context.Context, source string, index int) ([]byte, error) {
+	segment := config.Get().Playback.SegmentSeconds
+	start := strconv.Itoa(index * segment)
+
+	args := []string{"-ss", start, "-t", strconv.Itoa(segment), "-i", source, "-output_ts_offset", start}
+	args = append(args, encodeArgs("aac", "mpegts")...)
+
+	var out bytes.Buffer
+	if err := transcode(ctx, &out, args); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// encodeArgs are the arguments of FFmpeg encoding audio to stdout with
+// encoder and muxer, at the configured bitrate.
+func encodeArgs(encoder, muxer string) []string {
+	return []string{
+		"-vn",
+		"-c:a", encoder,
+		"-b:a", strconv.Itoa(config.Get().Playback.BitrateKbps) + "k",
+		"-f", muxer,
+		"pipe:1",
+	}
+}
+
+// transcode runs FFmpeg with args, writing its output to w.
+func transcode(ctx context.Context, w io.Writer, args []string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner", "-loglevel", "error"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("ffmpeg failed: %v, output %v", err, stderr.String())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package previews
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DefaultDeezerURL is the base URL of the Deezer API.
+const DefaultDeezerURL = "https://api.deezer.com"
+
+// deezerPageSize is the most tracks Deezer returns at a time.
+const deezerPageSize = 100
+
+// Deezer searches the Deezer API, which needs no credentials.
+type Deezer struct {
+	// URL is that of Deezer, and overridable for tests.
+	URL    string
+	Client *http.Client
+}
+
+// NewDeezer returns a client of the Deezer API.
+func NewDeezer() *Deezer {
+	return &Deezer{URL: DefaultDeezerURL, Client: &http.Client{Timeout: httpTimeout}}
+}
+
+// deezerPage is a page of tracks of the Deezer API.
+type deezerPage struct {
+	Data []struct {
+		Title   string `json:"title"`
+		Preview string `json:"preview"`
+		Artist  struct {
+			Name string `json:"name"`
+		} `json:"artist"`
+		Album struct {
+			Title string `json:"title"`
+		} `json:"album"`
+	} `json:"data"`
+	Next  string `json:"next"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Search returns the tracks Deezer finds for query, or its chart of popular
+// tracks if query is empty. Tracks without a preview are left out.
+func (d *Deezer) Search(ctx context.Context, query string, limit int) ([]Preview, error) {
+	params := url.Values{"limit": {strconv.Itoa(min(limit, deezerPageSize))}}
+	next := d.URL + "/chart/0/tracks?" + params.Encode()
+	if query != "" {
+		params.Set("q", query)
+		next = d.URL + "/search?" + params.Encode()
+	}
+
+	var tracks []Preview
+	for next != "" && len(tracks) < limit {
+		var page deezerPage
+		if err := getJSON(ctx, d.Client, next, &page); err != nil {
+			return nil, fmt.Errorf("error searching Deezer: %v", err)
+		}
+		// Deezer reports errors with a 200 status
+		if page.Error != nil {
+			return nil, fmt.Errorf("error searching Deezer: %s", page.Error.Message)
+		}
+		if len(page.Data) == 0 {
+			break
+		}
+
+		for _, track := range page.Data {
+			if track.Preview == "" || len(tracks) == limit {
+				continue
+			}
+			tracks = append(tracks, Preview{
+				Title:  track.Title,
+				Artist: track.Artist.Name,
+				Album:  track.Album.Title,
+				URL:    track.Preview,
+			})
+		}
+
+		next = page.Next
+	}
+
+	return tracks, nil
+}
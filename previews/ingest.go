@@ -0,0 +1,100 @@
+package previews
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/db"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"strconv"
+	"sync"
+)
+
+// IngestOptions tune Ingest.
+type IngestOptions struct {
+	// Workers is the number of previews processed at once.
+	Workers int
+	// Limit is the most tracks registered.
+	Limit int
+}
+
+// Ingest registers the preview of every track catalog finds for query,
+// with the album and release year the catalog has unless MusicBrainz finds
+// them. One result is returned per track, best match first; tracks
+// registered before are reported as such without downloading them again.
+func Ingest(ctx context.Context, catalog Catalog, query string, opts IngestOptions) ([]song.BatchResult, error) {
+	tracks, err := catalog.Search(ctx, query, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := song.NewPool(opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	results := make([]song.BatchResult, len(tracks))
+	semaphore := make(chan struct{}, pool.Workers())
+	var wg sync.WaitGroup
+
+	for i := range tracks {
+		results[i] = song.BatchResult{Index: i, Title: tracks[i].Title, Artist: tracks[i].Artist}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			response, err := ingestPreview(ctx, pool, dbClient, tracks[i])
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Response = response
+		}(i)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// ingestPreview registers the preview of one track.
+func ingestPreview(ctx context.Context, pool *song.Pool, dbClient db.DBClient, track Preview) (*song.ProcessResponse, error) {
+	existing, exists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(track.Title, track.Artist))
+	if err != nil {
+		return nil, fmt.Errorf("error checking song existence: %v", err)
+	}
+	if exists {
+		return &song.ProcessResponse{
+			Success:           true,
+			Message:           "Song already registered",
+			FingerprintID:     strconv.FormatUint(uint64(existing.ID), 10),
+			AlreadyRegistered: true,
+		}, nil
+	}
+
+	response, err := pool.Process(ctx, &song.SongInput{SongURL: track.URL, Title: track.Title, Artist: track.Artist})
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.AlreadyRegistered {
+		song.SetAlbum(ctx, dbClient, response, track.Album, track.ReleaseYear)
+	}
+	return response, nil
+}
@@ -0,0 +1,49 @@
+package previews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"song-recognition/config"
+	"song-recognition/itunes"
+)
+
+// ITunes searches the iTunes Search API, which needs no credentials.
+type ITunes struct {
+	Client *itunes.Client
+}
+
+// NewITunes returns a catalog of the iTunes store of the country set in
+// the config file.
+func NewITunes() *ITunes {
+	return &ITunes{Client: itunes.NewClient(config.Get().ITunes.Country)}
+}
+
+// Search returns the songs the iTunes Search API finds for query, at most
+// 200. It has no chart, so query can't be empty.
+func (it *ITunes) Search(ctx context.Context, query string, limit int) ([]Preview, error) {
+	if query == "" {
+		return nil, errors.New("iTunes needs a search query")
+	}
+
+	results, err := it.Client.Search(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching iTunes: %v", err)
+	}
+
+	var tracks []Preview
+	for _, result := range results {
+		if result.PreviewURL == "" {
+			continue
+		}
+		tracks = append(tracks, Preview{
+			Title:       result.Title,
+			Artist:      result.Artist,
+			Album:       result.Album,
+			ReleaseYear: result.ReleaseYear,
+			URL:         result.PreviewURL,
+		})
+	}
+
+	return tracks, nil
+}
@@ -0,0 +1,86 @@
+// Package previews registers songs from the official 30 second previews of
+// music catalogs, the Deezer API and the iTunes Search API, to bootstrap a
+// library of recognizable songs without their full tracks.
+package previews
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds a single request.
+const httpTimeout = 15 * time.Second
+
+// Preview is a track of a catalog with its preview.
+type Preview struct {
+	Title       string
+	Artist      string
+	Album       string
+	ReleaseYear int
+	// URL is that of the 30 second preview, an MP3 or AAC file.
+	URL string
+}
+
+// Catalog finds tracks with previews.
+type Catalog interface {
+	// Search returns up to limit tracks matching query, best first. An
+	// empty query lists the catalog's most popular tracks, if it can.
+	Search(ctx context.Context, query string, limit int) ([]Preview, error)
+}
+
+// catalogs are the catalogs by name.
+var catalogs = map[string]func() Catalog{
+	"deezer": func() Catalog { return NewDeezer() },
+	"itunes": func() Catalog { return NewITunes() },
+}
+
+// Lookup returns the catalog called name, "deezer" or "itunes".
+func Lookup(name string) (Catalog, error) {
+	newCatalog, ok := catalogs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown preview catalog %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return newCatalog(), nil
+}
+
+// Names lists the names of the catalogs.
+func Names() []string {
+	names := make([]string, 0, len(catalogs))
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getJSON decodes the JSON response to a GET of endpoint into v.
+func getJSON(ctx context.Context, client *http.Client, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("received status code %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
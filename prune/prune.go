@@ -0,0 +1,126 @@
+// Package prune removes the fingerprints of addresses shared by so many
+// songs that they tell none of them apart. Such addresses, often hashes of
+// silence or of a steady tone, return thousands of couples to every clip
+// that has them, costing lookups and matching time for no gain in score.
+//
+// A pruned address can fill up again as songs are saved, so pruning is run
+// at an interval.
+package prune
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// batchSize is the number of addresses deleted at a time.
+const batchSize = 1000
+
+// Result is what a prune removed, or would remove.
+type Result struct {
+	Addresses    int
+	Fingerprints int
+}
+
+// RunConfigured prunes at the interval of the config file until ctx is
+// done. It returns at once if pruning is disabled.
+func RunConfigured(ctx context.Context) {
+	cfg := config.Get().Prune
+	if cfg.IntervalMinutes == 0 {
+		return
+	}
+	Run(ctx, time.Duration(cfg.IntervalMinutes)*time.Minute, cfg.MaxSongs)
+}
+
+// Run prunes every interval, starting now, until ctx is done, logging what
+// every prune removed.
+func Run(ctx context.Context, interval time.Duration, maxSongs int) error {
+	logger := utils.GetLogger()
+
+	for {
+		result, err := pruneDB(ctx, maxSongs)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error pruning fingerprints", slog.Any("error", xerrors.New(err)))
+		}
+		if result.Addresses > 0 {
+			logger.InfoContext(ctx, "Pruned common fingerprints", slog.Int("addresses", result.Addresses), slog.Int("fingerprints", result.Fingerprints))
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pruneDB prunes the database of DB_TYPE.
+func pruneDB(ctx context.Context, maxSongs int) (Result, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return Prune(ctx, dbClient, maxSongs, false)
+}
+
+// Prune removes the fingerprints of every address that more than maxSongs
+// songs have fingerprints at, in every fingerprint version. With dryRun it
+// only counts them.
+func Prune(ctx context.Context, dbClient db.DBClient, maxSongs int, dryRun bool) (Result, error) {
+	var result Result
+
+	versions, err := dbClient.CountFingerprintsByVersion(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for version := range versions {
+		addresses, err := dbClient.CommonAddresses(ctx, version, maxSongs)
+		if err != nil {
+			return result, err
+		}
+
+		for start := 0; start < len(addresses); start += batchSize {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			batch := addresses[start:min(start+batchSize, len(addresses))]
+
+			var count int
+			if dryRun {
+				count, err = countCouples(ctx, dbClient, version, batch)
+			} else {
+				count, err = dbClient.DeleteAddresses(ctx, version, batch)
+			}
+			if err != nil {
+				return result, err
+			}
+			result.Addresses += len(batch)
+			result.Fingerprints += count
+		}
+	}
+
+	return result, nil
+}
+
+// countCouples returns the number of couples of a version at addresses.
+func countCouples(ctx context.Context, dbClient db.DBClient, version string, addresses []uint32) (int, error) {
+	couples, err := dbClient.GetCouples(ctx, addresses, version)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, stored := range couples {
+		count += len(stored)
+	}
+	return count, nil
+}
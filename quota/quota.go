@@ -0,0 +1,145 @@
+// Package quota caps the size of the directories the server writes files
+// to, the temporary directory and the local songs directory. Once one is
+// full, new files are either refused or make room by evicting the least
+// recently used files, as its quota's policy says.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"sort"
+	"sync"
+)
+
+// TmpDir is the directory of temporary files, such as downloads.
+const TmpDir = "tmp"
+
+// ErrFull is returned by MakeRoom when a directory has no room left.
+var ErrFull = errors.New("directory quota exceeded")
+
+// Usage is the size of a directory against its quota.
+type Usage struct {
+	Dir       string
+	UsedBytes int64
+	// MaxBytes is zero for directories without a cap.
+	MaxBytes int64
+	Policy   string
+}
+
+// mu keeps evictions from racing each other.
+var mu sync.Mutex
+
+// MakeRoom checks that dir can take size more bytes under quota, evicting
+// its least recently modified files to make room if the quota's policy is
+// to evict, and failing with ErrFull otherwise. Files being written are
+// the most recently modified, so they are evicted last. Room isn't held
+// for the caller, so files written at the same time may overshoot the
+// quota a little.
+func MakeRoom(ctx context.Context, dir string, quota config.Quota, size int64) error {
+	if quota.MaxBytes <= 0 {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	files, used, err := listFiles(dir)
+	if err != nil {
+		return fmt.Errorf("failed to measure %s: %v", dir, err)
+	}
+	if used+size <= quota.MaxBytes {
+		return nil
+	}
+	if quota.Policy != config.QuotaEvict || size > quota.MaxBytes {
+		return fmt.Errorf("%w: %s holds %d of its %d bytes, with no room for %d more", ErrFull, dir, used, quota.MaxBytes, size)
+	}
+
+	logger := utils.GetLogger()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, file := range files {
+		if used+size <= quota.MaxBytes {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			logger.WarnContext(ctx, "Failed to evict file", slog.String("path", file.path), slog.Any("error", err))
+			continue
+		}
+		logger.InfoContext(ctx, "Evicted file to stay within quota", slog.String("path", file.path), slog.Int64("bytes", file.info.Size()))
+		used -= file.info.Size()
+	}
+	if used+size > quota.MaxBytes {
+		return fmt.Errorf("%w: %s holds %d of its %d bytes after evicting, with no room for %d more", ErrFull, dir, used, quota.MaxBytes, size)
+	}
+	return nil
+}
+
+// Measure returns the usage of dir against quota. A directory that doesn't
+// exist yet holds nothing.
+func Measure(dir string, quota config.Quota) (Usage, error) {
+	_, used, err := listFiles(dir)
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to measure %s: %v", dir, err)
+	}
+	return Usage{Dir: dir, UsedBytes: used, MaxBytes: quota.MaxBytes, Policy: quota.Policy}, nil
+}
+
+// Current returns the usage of the temporary directory and, when songs are
+// stored locally, of the songs directory.
+func Current() ([]Usage, error) {
+	cfg := config.Get()
+
+	tmp, err := Measure(TmpDir, cfg.Quotas.Tmp)
+	if err != nil {
+		return nil, err
+	}
+	usages := []Usage{tmp}
+
+	if cfg.Storage.Backend == config.StorageLocal {
+		songs, err := Measure(cfg.Storage.Dir, cfg.Quotas.Songs)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, songs)
+	}
+	return usages, nil
+}
+
+type file struct {
+	path string
+	info fs.FileInfo
+}
+
+// listFiles returns the regular files under dir and their total size.
+func listFiles(dir string) ([]file, int64, error) {
+	var files []file
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			return nil
+		}
+		files = append(files, file{path: path, info: info})
+		size += info.Size()
+		return nil
+	})
+	return files, size, err
+}
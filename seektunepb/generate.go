@@ -0,0 +1,5 @@
+// Package seektunepb holds the protobuf messages and gRPC stubs of the
+// SeekTune service, generated from seektune.proto.
+package seektunepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative seektune.proto
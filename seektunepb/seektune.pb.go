@@ -0,0 +1,930 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.32.0
+// 	protoc        (unknown)
+// source: seektune.proto
+
+package seektunepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SampleFormat int32
+
+const (
+	// 16-bit little-endian signed integers.
+	SampleFormat_SAMPLE_FORMAT_S16LE SampleFormat = 0
+	// 32-bit little-endian floats, as produced by Web Audio.
+	SampleFormat_SAMPLE_FORMAT_F32LE SampleFormat = 1
+)
+
+// Enum value maps for SampleFormat.
+var (
+	SampleFormat_name = map[int32]string{
+		0: "SAMPLE_FORMAT_S16LE",
+		1: "SAMPLE_FORMAT_F32LE",
+	}
+	SampleFormat_value = map[string]int32{
+		"SAMPLE_FORMAT_S16LE": 0,
+		"SAMPLE_FORMAT_F32LE": 1,
+	}
+)
+
+func (x SampleFormat) Enum() *SampleFormat {
+	p := new(SampleFormat)
+	*p = x
+	return p
+}
+
+func (x SampleFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SampleFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_seektune_proto_enumTypes[0].Descriptor()
+}
+
+func (SampleFormat) Type() protoreflect.EnumType {
+	return &file_seektune_proto_enumTypes[0]
+}
+
+func (x SampleFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SampleFormat.Descriptor instead.
+func (SampleFormat) EnumDescriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{0}
+}
+
+type RecognitionEvent_Type int32
+
+const (
+	RecognitionEvent_CANDIDATES RecognitionEvent_Type = 0
+	RecognitionEvent_MATCH      RecognitionEvent_Type = 1
+	RecognitionEvent_NO_MATCH   RecognitionEvent_Type = 2
+)
+
+// Enum value maps for RecognitionEvent_Type.
+var (
+	RecognitionEvent_Type_name = map[int32]string{
+		0: "CANDIDATES",
+		1: "MATCH",
+		2: "NO_MATCH",
+	}
+	RecognitionEvent_Type_value = map[string]int32{
+		"CANDIDATES": 0,
+		"MATCH":      1,
+		"NO_MATCH":   2,
+	}
+)
+
+func (x RecognitionEvent_Type) Enum() *RecognitionEvent_Type {
+	p := new(RecognitionEvent_Type)
+	*p = x
+	return p
+}
+
+func (x RecognitionEvent_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RecognitionEvent_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_seektune_proto_enumTypes[1].Descriptor()
+}
+
+func (RecognitionEvent_Type) Type() protoreflect.EnumType {
+	return &file_seektune_proto_enumTypes[1]
+}
+
+func (x RecognitionEvent_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RecognitionEvent_Type.Descriptor instead.
+func (RecognitionEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{6, 0}
+}
+
+type RegisterSongRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// title and artist default to the ID3 tags of the audio, if it has them.
+	Title     string `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Artist    string `protobuf:"bytes,2,opt,name=artist,proto3" json:"artist,omitempty"`
+	YoutubeId string `protobuf:"bytes,3,opt,name=youtube_id,json=youtubeId,proto3" json:"youtube_id,omitempty"`
+	// Types that are assignable to Source:
+	//	*RegisterSongRequest_SongUrl
+	//	*RegisterSongRequest_Audio
+	Source isRegisterSongRequest_Source `protobuf_oneof:"source"`
+	// idempotency_key makes retries of the same request return the original
+	// response instead of registering the song twice.
+	IdempotencyKey string `protobuf:"bytes,6,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+}
+
+func (x *RegisterSongRequest) Reset() {
+	*x = RegisterSongRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegisterSongRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterSongRequest) ProtoMessage() {}
+
+func (x *RegisterSongRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterSongRequest.ProtoReflect.Descriptor instead.
+func (*RegisterSongRequest) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RegisterSongRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *RegisterSongRequest) GetArtist() string {
+	if x != nil {
+		return x.Artist
+	}
+	return ""
+}
+
+func (x *RegisterSongRequest) GetYoutubeId() string {
+	if x != nil {
+		return x.YoutubeId
+	}
+	return ""
+}
+
+func (m *RegisterSongRequest) GetSource() isRegisterSongRequest_Source {
+	if m != nil {
+		return m.Source
+	}
+	return nil
+}
+
+func (x *RegisterSongRequest) GetSongUrl() string {
+	if x, ok := x.GetSource().(*RegisterSongRequest_SongUrl); ok {
+		return x.SongUrl
+	}
+	return ""
+}
+
+func (x *RegisterSongRequest) GetAudio() []byte {
+	if x, ok := x.GetSource().(*RegisterSongRequest_Audio); ok {
+		return x.Audio
+	}
+	return nil
+}
+
+func (x *RegisterSongRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+type isRegisterSongRequest_Source interface {
+	isRegisterSongRequest_Source()
+}
+
+type RegisterSongRequest_SongUrl struct {
+	// song_url is a direct http(s) link to an audio file.
+	SongUrl string `protobuf:"bytes,4,opt,name=song_url,json=songUrl,proto3,oneof"`
+}
+
+type RegisterSongRequest_Audio struct {
+	// audio is an encoded audio file (WAV, MP3, FLAC, OGG...).
+	Audio []byte `protobuf:"bytes,5,opt,name=audio,proto3,oneof"`
+}
+
+func (*RegisterSongRequest_SongUrl) isRegisterSongRequest_Source() {}
+
+func (*RegisterSongRequest_Audio) isRegisterSongRequest_Source() {}
+
+type RegisterSongResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SongId            string `protobuf:"bytes,1,opt,name=song_id,json=songId,proto3" json:"song_id,omitempty"`
+	FilePath          string `protobuf:"bytes,2,opt,name=file_path,json=filePath,proto3" json:"file_path,omitempty"`
+	AlreadyRegistered bool   `protobuf:"varint,3,opt,name=already_registered,json=alreadyRegistered,proto3" json:"already_registered,omitempty"`
+	Message           string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *RegisterSongResponse) Reset() {
+	*x = RegisterSongResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegisterSongResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterSongResponse) ProtoMessage() {}
+
+func (x *RegisterSongResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterSongResponse.ProtoReflect.Descriptor instead.
+func (*RegisterSongResponse) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RegisterSongResponse) GetSongId() string {
+	if x != nil {
+		return x.SongId
+	}
+	return ""
+}
+
+func (x *RegisterSongResponse) GetFilePath() string {
+	if x != nil {
+		return x.FilePath
+	}
+	return ""
+}
+
+func (x *RegisterSongResponse) GetAlreadyRegistered() bool {
+	if x != nil {
+		return x.AlreadyRegistered
+	}
+	return false
+}
+
+func (x *RegisterSongResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type RecognizeClipRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// audio is an encoded audio file (WAV, MP3, FLAC, OGG...).
+	Audio []byte `protobuf:"bytes,1,opt,name=audio,proto3" json:"audio,omitempty"`
+	// max_matches caps the number of matches returned; 0 means the
+	// configured default.
+	MaxMatches int32 `protobuf:"varint,2,opt,name=max_matches,json=maxMatches,proto3" json:"max_matches,omitempty"`
+	// min_score overrides the configured score a match needs.
+	MinScore *float64 `protobuf:"fixed64,3,opt,name=min_score,json=minScore,proto3,oneof" json:"min_score,omitempty"`
+}
+
+func (x *RecognizeClipRequest) Reset() {
+	*x = RecognizeClipRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RecognizeClipRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognizeClipRequest) ProtoMessage() {}
+
+func (x *RecognizeClipRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognizeClipRequest.ProtoReflect.Descriptor instead.
+func (*RecognizeClipRequest) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RecognizeClipRequest) GetAudio() []byte {
+	if x != nil {
+		return x.Audio
+	}
+	return nil
+}
+
+func (x *RecognizeClipRequest) GetMaxMatches() int32 {
+	if x != nil {
+		return x.MaxMatches
+	}
+	return 0
+}
+
+func (x *RecognizeClipRequest) GetMinScore() float64 {
+	if x != nil && x.MinScore != nil {
+		return *x.MinScore
+	}
+	return 0
+}
+
+type RecognizeClipResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Matches      []*Match `protobuf:"bytes,1,rep,name=matches,proto3" json:"matches,omitempty"`
+	SearchTimeMs int64    `protobuf:"varint,2,opt,name=search_time_ms,json=searchTimeMs,proto3" json:"search_time_ms,omitempty"`
+}
+
+func (x *RecognizeClipResponse) Reset() {
+	*x = RecognizeClipResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RecognizeClipResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognizeClipResponse) ProtoMessage() {}
+
+func (x *RecognizeClipResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognizeClipResponse.ProtoReflect.Descriptor instead.
+func (*RecognizeClipResponse) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RecognizeClipResponse) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+func (x *RecognizeClipResponse) GetSearchTimeMs() int64 {
+	if x != nil {
+		return x.SearchTimeMs
+	}
+	return 0
+}
+
+type Match struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SongId    uint32 `protobuf:"varint,1,opt,name=song_id,json=songId,proto3" json:"song_id,omitempty"`
+	Title     string `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Artist    string `protobuf:"bytes,3,opt,name=artist,proto3" json:"artist,omitempty"`
+	YoutubeId string `protobuf:"bytes,4,opt,name=youtube_id,json=youtubeId,proto3" json:"youtube_id,omitempty"`
+	// timestamp_ms is where in the song the clip starts, found by aligning
+	// the anchor times of the matching hashes.
+	TimestampMs uint32  `protobuf:"varint,5,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	Score       float64 `protobuf:"fixed64,6,opt,name=score,proto3" json:"score,omitempty"`
+	// matched_hashes is the number of clip hashes found in the song.
+	MatchedHashes int32 `protobuf:"varint,7,opt,name=matched_hashes,json=matchedHashes,proto3" json:"matched_hashes,omitempty"`
+}
+
+func (x *Match) Reset() {
+	*x = Match{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Match) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Match) ProtoMessage() {}
+
+func (x *Match) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Match.ProtoReflect.Descriptor instead.
+func (*Match) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Match) GetSongId() uint32 {
+	if x != nil {
+		return x.SongId
+	}
+	return 0
+}
+
+func (x *Match) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Match) GetArtist() string {
+	if x != nil {
+		return x.Artist
+	}
+	return ""
+}
+
+func (x *Match) GetYoutubeId() string {
+	if x != nil {
+		return x.YoutubeId
+	}
+	return ""
+}
+
+func (x *Match) GetTimestampMs() uint32 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *Match) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Match) GetMatchedHashes() int32 {
+	if x != nil {
+		return x.MatchedHashes
+	}
+	return 0
+}
+
+// AudioChunk carries mono PCM. sample_rate and format are read from the
+// first chunk of a stream; sample_rate defaults to 44100.
+type AudioChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SampleRate int32        `protobuf:"varint,1,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	Format     SampleFormat `protobuf:"varint,2,opt,name=format,proto3,enum=seektune.v1.SampleFormat" json:"format,omitempty"`
+	Pcm        []byte       `protobuf:"bytes,3,opt,name=pcm,proto3" json:"pcm,omitempty"`
+}
+
+func (x *AudioChunk) Reset() {
+	*x = AudioChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AudioChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AudioChunk) ProtoMessage() {}
+
+func (x *AudioChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AudioChunk.ProtoReflect.Descriptor instead.
+func (*AudioChunk) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AudioChunk) GetSampleRate() int32 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *AudioChunk) GetFormat() SampleFormat {
+	if x != nil {
+		return x.Format
+	}
+	return SampleFormat_SAMPLE_FORMAT_S16LE
+}
+
+func (x *AudioChunk) GetPcm() []byte {
+	if x != nil {
+		return x.Pcm
+	}
+	return nil
+}
+
+type RecognitionEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type RecognitionEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=seektune.v1.RecognitionEvent_Type" json:"type,omitempty"`
+	// matches holds the best candidates so far for CANDIDATES events.
+	Matches []*Match `protobuf:"bytes,2,rep,name=matches,proto3" json:"matches,omitempty"`
+	// match is the recognized song for MATCH events.
+	Match *Match `protobuf:"bytes,3,opt,name=match,proto3" json:"match,omitempty"`
+	// duration_seconds is how much audio has been heard so far.
+	DurationSeconds float64 `protobuf:"fixed64,4,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+}
+
+func (x *RecognitionEvent) Reset() {
+	*x = RecognitionEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_seektune_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RecognitionEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognitionEvent) ProtoMessage() {}
+
+func (x *RecognitionEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_seektune_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognitionEvent.ProtoReflect.Descriptor instead.
+func (*RecognitionEvent) Descriptor() ([]byte, []int) {
+	return file_seektune_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RecognitionEvent) GetType() RecognitionEvent_Type {
+	if x != nil {
+		return x.Type
+	}
+	return RecognitionEvent_CANDIDATES
+}
+
+func (x *RecognitionEvent) GetMatches() []*Match {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+func (x *RecognitionEvent) GetMatch() *Match {
+	if x != nil {
+		return x.Match
+	}
+	return nil
+}
+
+func (x *RecognitionEvent) GetDurationSeconds() float64 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+var File_seektune_proto protoreflect.FileDescriptor
+
+var file_seektune_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x0b, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x22, 0xca, 0x01,
+	0x0a, 0x13, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x53, 0x6f, 0x6e, 0x67, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x61,
+	0x72, 0x74, 0x69, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x61, 0x72, 0x74,
+	0x69, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x79, 0x6f, 0x75, 0x74, 0x75, 0x62, 0x65, 0x5f, 0x69,
+	0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x79, 0x6f, 0x75, 0x74, 0x75, 0x62, 0x65,
+	0x49, 0x64, 0x12, 0x1b, 0x0a, 0x08, 0x73, 0x6f, 0x6e, 0x67, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x07, 0x73, 0x6f, 0x6e, 0x67, 0x55, 0x72, 0x6c, 0x12,
+	0x16, 0x0a, 0x05, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00,
+	0x52, 0x05, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x12, 0x27, 0x0a, 0x0f, 0x69, 0x64, 0x65, 0x6d, 0x70,
+	0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0e, 0x69, 0x64, 0x65, 0x6d, 0x70, 0x6f, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x4b, 0x65, 0x79,
+	0x42, 0x08, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x22, 0x95, 0x01, 0x0a, 0x14, 0x52,
+	0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x53, 0x6f, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x6f, 0x6e, 0x67, 0x5f, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x6f, 0x6e, 0x67, 0x49, 0x64, 0x12, 0x1b, 0x0a, 0x09,
+	0x66, 0x69, 0x6c, 0x65, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x66, 0x69, 0x6c, 0x65, 0x50, 0x61, 0x74, 0x68, 0x12, 0x2d, 0x0a, 0x12, 0x61, 0x6c, 0x72,
+	0x65, 0x61, 0x64, 0x79, 0x5f, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x65, 0x64, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x61, 0x6c, 0x72, 0x65, 0x61, 0x64, 0x79, 0x52, 0x65,
+	0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x22, 0x7d, 0x0a, 0x14, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x7a, 0x65, 0x43,
+	0x6c, 0x69, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x75,
+	0x64, 0x69, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x61, 0x75, 0x64, 0x69, 0x6f,
+	0x12, 0x1f, 0x0a, 0x0b, 0x6d, 0x61, 0x78, 0x5f, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a, 0x6d, 0x61, 0x78, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x65,
+	0x73, 0x12, 0x20, 0x0a, 0x09, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x08, 0x6d, 0x69, 0x6e, 0x53, 0x63, 0x6f, 0x72, 0x65,
+	0x88, 0x01, 0x01, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x63, 0x6f, 0x72,
+	0x65, 0x22, 0x6b, 0x0a, 0x15, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x7a, 0x65, 0x43, 0x6c,
+	0x69, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2c, 0x0a, 0x07, 0x6d, 0x61,
+	0x74, 0x63, 0x68, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x73, 0x65,
+	0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x52,
+	0x07, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x12, 0x24, 0x0a, 0x0e, 0x73, 0x65, 0x61, 0x72,
+	0x63, 0x68, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0c, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x54, 0x69, 0x6d, 0x65, 0x4d, 0x73, 0x22, 0xcd,
+	0x01, 0x0a, 0x05, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x6f, 0x6e, 0x67,
+	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x73, 0x6f, 0x6e, 0x67, 0x49,
+	0x64, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x61, 0x72, 0x74, 0x69, 0x73,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x61, 0x72, 0x74, 0x69, 0x73, 0x74, 0x12,
+	0x1d, 0x0a, 0x0a, 0x79, 0x6f, 0x75, 0x74, 0x75, 0x62, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x79, 0x6f, 0x75, 0x74, 0x75, 0x62, 0x65, 0x49, 0x64, 0x12, 0x21,
+	0x0a, 0x0c, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x5f, 0x6d, 0x73, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x4d,
+	0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x6d, 0x61, 0x74, 0x63, 0x68,
+	0x65, 0x64, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x0d, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x72,
+	0x0a, 0x0a, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x1f, 0x0a, 0x0b,
+	0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0a, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x61, 0x74, 0x65, 0x12, 0x31, 0x0a,
+	0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e,
+	0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74,
+	0x12, 0x10, 0x0a, 0x03, 0x70, 0x63, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x70,
+	0x63, 0x6d, 0x22, 0xfe, 0x01, 0x0a, 0x10, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x36, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x22, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65,
+	0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12,
+	0x2c, 0x0a, 0x07, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x12, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x4d,
+	0x61, 0x74, 0x63, 0x68, 0x52, 0x07, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x73, 0x12, 0x28, 0x0a,
+	0x05, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x73,
+	0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x61, 0x74, 0x63, 0x68,
+	0x52, 0x05, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x12, 0x29, 0x0a, 0x10, 0x64, 0x75, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x0f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x63, 0x6f, 0x6e,
+	0x64, 0x73, 0x22, 0x2f, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0e, 0x0a, 0x0a, 0x43, 0x41,
+	0x4e, 0x44, 0x49, 0x44, 0x41, 0x54, 0x45, 0x53, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x4d, 0x41,
+	0x54, 0x43, 0x48, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x4e, 0x4f, 0x5f, 0x4d, 0x41, 0x54, 0x43,
+	0x48, 0x10, 0x02, 0x2a, 0x40, 0x0a, 0x0c, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72,
+	0x6d, 0x61, 0x74, 0x12, 0x17, 0x0a, 0x13, 0x53, 0x41, 0x4d, 0x50, 0x4c, 0x45, 0x5f, 0x46, 0x4f,
+	0x52, 0x4d, 0x41, 0x54, 0x5f, 0x53, 0x31, 0x36, 0x4c, 0x45, 0x10, 0x00, 0x12, 0x17, 0x0a, 0x13,
+	0x53, 0x41, 0x4d, 0x50, 0x4c, 0x45, 0x5f, 0x46, 0x4f, 0x52, 0x4d, 0x41, 0x54, 0x5f, 0x46, 0x33,
+	0x32, 0x4c, 0x45, 0x10, 0x01, 0x32, 0x86, 0x02, 0x0a, 0x08, 0x53, 0x65, 0x65, 0x6b, 0x54, 0x75,
+	0x6e, 0x65, 0x12, 0x53, 0x0a, 0x0c, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x53, 0x6f,
+	0x6e, 0x67, 0x12, 0x20, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31,
+	0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x53, 0x6f, 0x6e, 0x67, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e,
+	0x76, 0x31, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x53, 0x6f, 0x6e, 0x67, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x56, 0x0a, 0x0d, 0x52, 0x65, 0x63, 0x6f, 0x67,
+	0x6e, 0x69, 0x7a, 0x65, 0x43, 0x6c, 0x69, 0x70, 0x12, 0x21, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74,
+	0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x7a, 0x65,
+	0x43, 0x6c, 0x69, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x73, 0x65,
+	0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e,
+	0x69, 0x7a, 0x65, 0x43, 0x6c, 0x69, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x4d, 0x0a, 0x0f, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69,
+	0x7a, 0x65, 0x12, 0x17, 0x2e, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31,
+	0x2e, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x1a, 0x1d, 0x2e, 0x73, 0x65,
+	0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x67, 0x6e,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x28, 0x01, 0x30, 0x01, 0x42, 0x1d,
+	0x5a, 0x1b, 0x73, 0x6f, 0x6e, 0x67, 0x2d, 0x72, 0x65, 0x63, 0x6f, 0x67, 0x6e, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x2f, 0x73, 0x65, 0x65, 0x6b, 0x74, 0x75, 0x6e, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_seektune_proto_rawDescOnce sync.Once
+	file_seektune_proto_rawDescData = file_seektune_proto_rawDesc
+)
+
+func file_seektune_proto_rawDescGZIP() []byte {
+	file_seektune_proto_rawDescOnce.Do(func() {
+		file_seektune_proto_rawDescData = protoimpl.X.CompressGZIP(file_seektune_proto_rawDescData)
+	})
+	return file_seektune_proto_rawDescData
+}
+
+var file_seektune_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_seektune_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_seektune_proto_goTypes = []interface{}{
+	(SampleFormat)(0),             // 0: seektune.v1.SampleFormat
+	(RecognitionEvent_Type)(0),    // 1: seektune.v1.RecognitionEvent.Type
+	(*RegisterSongRequest)(nil),   // 2: seektune.v1.RegisterSongRequest
+	(*RegisterSongResponse)(nil),  // 3: seektune.v1.RegisterSongResponse
+	(*RecognizeClipRequest)(nil),  // 4: seektune.v1.RecognizeClipRequest
+	(*RecognizeClipResponse)(nil), // 5: seektune.v1.RecognizeClipResponse
+	(*Match)(nil),                 // 6: seektune.v1.Match
+	(*AudioChunk)(nil),            // 7: seektune.v1.AudioChunk
+	(*RecognitionEvent)(nil),      // 8: seektune.v1.RecognitionEvent
+}
+var file_seektune_proto_depIdxs = []int32{
+	6, // 0: seektune.v1.RecognizeClipResponse.matches:type_name -> seektune.v1.Match
+	0, // 1: seektune.v1.AudioChunk.format:type_name -> seektune.v1.SampleFormat
+	1, // 2: seektune.v1.RecognitionEvent.type:type_name -> seektune.v1.RecognitionEvent.Type
+	6, // 3: seektune.v1.RecognitionEvent.matches:type_name -> seektune.v1.Match
+	6, // 4: seektune.v1.RecognitionEvent.match:type_name -> seektune.v1.Match
+	2, // 5: seektune.v1.SeekTune.RegisterSong:input_type -> seektune.v1.RegisterSongRequest
+	4, // 6: seektune.v1.SeekTune.RecognizeClip:input_type -> seektune.v1.RecognizeClipRequest
+	7, // 7: seektune.v1.SeekTune.StreamRecognize:input_type -> seektune.v1.AudioChunk
+	3, // 8: seektune.v1.SeekTune.RegisterSong:output_type -> seektune.v1.RegisterSongResponse
+	5, // 9: seektune.v1.SeekTune.RecognizeClip:output_type -> seektune.v1.RecognizeClipResponse
+	8, // 10: seektune.v1.SeekTune.StreamRecognize:output_type -> seektune.v1.RecognitionEvent
+	8, // [8:11] is the sub-list for method output_type
+	5, // [5:8] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_seektune_proto_init() }
+func file_seektune_proto_init() {
+	if File_seektune_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_seektune_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RegisterSongRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RegisterSongResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RecognizeClipRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RecognizeClipResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Match); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AudioChunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_seektune_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RecognitionEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_seektune_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*RegisterSongRequest_SongUrl)(nil),
+		(*RegisterSongRequest_Audio)(nil),
+	}
+	file_seektune_proto_msgTypes[2].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_seektune_proto_rawDesc,
+			NumEnums:      2,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_seektune_proto_goTypes,
+		DependencyIndexes: file_seektune_proto_depIdxs,
+		EnumInfos:         file_seektune_proto_enumTypes,
+		MessageInfos:      file_seektune_proto_msgTypes,
+	}.Build()
+	File_seektune_proto = out.File
+	file_seektune_proto_rawDesc = nil
+	file_seektune_proto_goTypes = nil
+	file_seektune_proto_depIdxs = nil
+}
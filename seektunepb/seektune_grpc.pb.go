@@ -0,0 +1,228 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: seektune.proto
+
+package seektunepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	SeekTune_RegisterSong_FullMethodName    = "/seektune.v1.SeekTune/RegisterSong"
+	SeekTune_RecognizeClip_FullMethodName   = "/seektune.v1.SeekTune/RecognizeClip"
+	SeekTune_StreamRecognize_FullMethodName = "/seektune.v1.SeekTune/StreamRecognize"
+)
+
+// SeekTuneClient is the client API for SeekTune service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SeekTuneClient interface {
+	// RegisterSong fingerprints a song and stores it in the database.
+	RegisterSong(ctx context.Context, in *RegisterSongRequest, opts ...grpc.CallOption) (*RegisterSongResponse, error)
+	// RecognizeClip matches a complete recording.
+	RecognizeClip(ctx context.Context, in *RecognizeClipRequest, opts ...grpc.CallOption) (*RecognizeClipResponse, error)
+	// StreamRecognize matches raw PCM as it is recorded. The server sends
+	// candidates after every analysed window and finishes the stream with a
+	// MATCH event as soon as a match is confident, or a NO_MATCH event once
+	// the client closes its side without one.
+	StreamRecognize(ctx context.Context, opts ...grpc.CallOption) (SeekTune_StreamRecognizeClient, error)
+}
+
+type seekTuneClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSeekTuneClient(cc grpc.ClientConnInterface) SeekTuneClient {
+	return &seekTuneClient{cc}
+}
+
+func (c *seekTuneClient) RegisterSong(ctx context.Context, in *RegisterSongRequest, opts ...grpc.CallOption) (*RegisterSongResponse, error) {
+	out := new(RegisterSongResponse)
+	err := c.cc.Invoke(ctx, SeekTune_RegisterSong_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *seekTuneClient) RecognizeClip(ctx context.Context, in *RecognizeClipRequest, opts ...grpc.CallOption) (*RecognizeClipResponse, error) {
+	out := new(RecognizeClipResponse)
+	err := c.cc.Invoke(ctx, SeekTune_RecognizeClip_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *seekTuneClient) StreamRecognize(ctx context.Context, opts ...grpc.CallOption) (SeekTune_StreamRecognizeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SeekTune_ServiceDesc.Streams[0], SeekTune_StreamRecognize_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &seekTuneStreamRecognizeClient{stream}
+	return x, nil
+}
+
+type SeekTune_StreamRecognizeClient interface {
+	Send(*AudioChunk) error
+	Recv() (*RecognitionEvent, error)
+	grpc.ClientStream
+}
+
+type seekTuneStreamRecognizeClient struct {
+	grpc.ClientStream
+}
+
+func (x *seekTuneStreamRecognizeClient) Send(m *AudioChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *seekTuneStreamRecognizeClient) Recv() (*RecognitionEvent, error) {
+	m := new(RecognitionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SeekTuneServer is the server API for SeekTune service.
+// All implementations must embed UnimplementedSeekTuneServer
+// for forward compatibility
+type SeekTuneServer interface {
+	// RegisterSong fingerprints a song and stores it in the database.
+	RegisterSong(context.Context, *RegisterSongRequest) (*RegisterSongResponse, error)
+	// RecognizeClip matches a complete recording.
+	RecognizeClip(context.Context, *RecognizeClipRequest) (*RecognizeClipResponse, error)
+	// StreamRecognize matches raw PCM as it is recorded. The server sends
+	// candidates after every analysed window and finishes the stream with a
+	// MATCH event as soon as a match is confident, or a NO_MATCH event once
+	// the client closes its side without one.
+	StreamRecognize(SeekTune_StreamRecognizeServer) error
+	mustEmbedUnimplementedSeekTuneServer()
+}
+
+// UnimplementedSeekTuneServer must be embedded to have forward compatible implementations.
+type UnimplementedSeekTuneServer struct {
+}
+
+func (UnimplementedSeekTuneServer) RegisterSong(context.Context, *RegisterSongRequest) (*RegisterSongResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterSong not implemented")
+}
+func (UnimplementedSeekTuneServer) RecognizeClip(context.Context, *RecognizeClipRequest) (*RecognizeClipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecognizeClip not implemented")
+}
+func (UnimplementedSeekTuneServer) StreamRecognize(SeekTune_StreamRecognizeServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamRecognize not implemented")
+}
+func (UnimplementedSeekTuneServer) mustEmbedUnimplementedSeekTuneServer() {}
+
+// UnsafeSeekTuneServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SeekTuneServer will
+// result in compilation errors.
+type UnsafeSeekTuneServer interface {
+	mustEmbedUnimplementedSeekTuneServer()
+}
+
+func RegisterSeekTuneServer(s grpc.ServiceRegistrar, srv SeekTuneServer) {
+	s.RegisterService(&SeekTune_ServiceDesc, srv)
+}
+
+func _SeekTune_RegisterSong_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterSongRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SeekTuneServer).RegisterSong(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SeekTune_RegisterSong_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SeekTuneServer).RegisterSong(ctx, req.(*RegisterSongRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SeekTune_RecognizeClip_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecognizeClipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SeekTuneServer).RecognizeClip(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SeekTune_RecognizeClip_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SeekTuneServer).RecognizeClip(ctx, req.(*RecognizeClipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SeekTune_StreamRecognize_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SeekTuneServer).StreamRecognize(&seekTuneStreamRecognizeServer{stream})
+}
+
+type SeekTune_StreamRecognizeServer interface {
+	Send(*RecognitionEvent) error
+	Recv() (*AudioChunk, error)
+	grpc.ServerStream
+}
+
+type seekTuneStreamRecognizeServer struct {
+	grpc.ServerStream
+}
+
+func (x *seekTuneStreamRecognizeServer) Send(m *RecognitionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *seekTuneStreamRecognizeServer) Recv() (*AudioChunk, error) {
+	m := new(AudioChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SeekTune_ServiceDesc is the grpc.ServiceDesc for SeekTune service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SeekTune_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "seektune.v1.SeekTune",
+	HandlerType: (*SeekTuneServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterSong",
+			Handler:    _SeekTune_RegisterSong_Handler,
+		},
+		{
+			MethodName: "RecognizeClip",
+			Handler:    _SeekTune_RecognizeClip_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRecognize",
+			Handler:       _SeekTune_StreamRecognize_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "seektune.proto",
+}
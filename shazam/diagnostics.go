@@ -0,0 +1,63 @@
+package shazam
+
+import "sort"
+
+// DiagnosticsBinMs is the width of the bins of Diagnostics.Histogram.
+const DiagnosticsBinMs = 100
+
+// Diagnostics explains how a candidate was scored, to help debug why a
+// known song failed to match.
+type Diagnostics struct {
+	ClipHashes    int     `json:"clip_hashes"`    // hashes in the clip's fingerprint
+	HashesHit     int     `json:"hashes_hit"`     // clip hashes found among the song's fingerprints
+	Coverage      float64 `json:"coverage"`       // HashesHit / ClipHashes
+	AlignedHashes int     `json:"aligned_hashes"` // matched hashes agreeing with the reported offset
+	// Histogram counts the matched hashes by their offset dbTime -
+	// sampleTime. A true match shows as one tall bin; a false one is flat.
+	Histogram []HistogramBin `json:"histogram"`
+	// Speed is how fast the clip plays relative to the song, 1 unless it
+	// matched best sped up or slowed down under MatchOptions.MaxStretch.
+	Speed float64 `json:"speed"`
+}
+
+// HistogramBin counts the matched hashes whose offset falls within
+// DiagnosticsBinMs from OffsetMs.
+type HistogramBin struct {
+	OffsetMs int64 `json:"offset_ms"`
+	Count    int   `json:"count"`
+}
+
+// explain builds the diagnostics of a candidate from its matching
+// (sampleTime, dbTime) pairs.
+func explain(times [][2]uint32, hashesHit, clipHashes, alignedHashes int) *Diagnostics {
+	counts := make(map[int64]int)
+	for _, t := range times {
+		offset := int64(t[1]) - int64(t[0])
+		bin := offset / DiagnosticsBinMs
+		if offset < 0 && offset%DiagnosticsBinMs != 0 {
+			bin-- // round toward negative infinity so every bin is equally wide
+		}
+		counts[bin*DiagnosticsBinMs]++
+	}
+
+	histogram := make([]HistogramBin, 0, len(counts))
+	for offset, count := range counts {
+		histogram = append(histogram, HistogramBin{OffsetMs: offset, Count: count})
+	}
+	sort.Slice(histogram, func(i, j int) bool {
+		return histogram[i].OffsetMs < histogram[j].OffsetMs
+	})
+
+	diagnostics := &Diagnostics{
+		ClipHashes:    clipHashes,
+		HashesHit:     hashesHit,
+		AlignedHashes: alignedHashes,
+		Histogram:     histogram,
+		Speed:         1,
+	}
+	if clipHashes > 0 {
+		diagnostics.Coverage = float64(hashesHit) / float64(clipHashes)
+	}
+
+	return diagnostics
+}
@@ -1,29 +1,105 @@
 package shazam
 
 import (
+	"fmt"
+	"song-recognition/config"
 	"song-recognition/models"
 )
 
-const (
-	maxFreqBits    = 9
-	maxDeltaBits   = 14
-	targetZoneSize = 5
-)
+// fingerprintAlgorithm is raised whenever fingerprints change in a way
+// FingerprintConfig doesn't capture.
+const fingerprintAlgorithm = 1
+
+// FingerprintConfig tunes how audio is fingerprinted, trading the size of
+// the database for accuracy. Songs must be fingerprinted and recognized
+// with the same settings.
+type FingerprintConfig struct {
+	// PeakPicking is config.PeakPickingAverage or config.PeakPickingLogBands.
+	PeakPicking string
+	// MaxPeaksPerSecond keeps only the loudest peaks of every second of
+	// audio. Zero keeps them all.
+	MaxPeaksPerSecond int
+	// FanOut is the number of following peaks every anchor peak is paired
+	// with, and TargetZoneMs how far after the anchor they may be.
+	FanOut       int
+	TargetZoneMs int
+	// FreqBits and DeltaBits set how a pair is packed into an address:
+	// the frequencies of both peaks, coarsened to FreqBits each, then the
+	// milliseconds between them in DeltaBits.
+	FreqBits  int
+	DeltaBits int
+	// Normalize is how the loudness of clips is normalized before they
+	// are fingerprinted, config.NormalizeR128, config.NormalizePeak or
+	// empty for not at all, and NormalizeTarget the loudness or level they
+	// are brought to. NormalizeSongs normalizes songs the same way.
+	Normalize       string
+	NormalizeTarget float64
+	NormalizeSongs  bool
+}
+
+// Version identifies the fingerprints made with cfg. It is stored with
+// every fingerprint, and clips are only matched against fingerprints of
+// their own version.
+func (cfg FingerprintConfig) Version() string {
+	version := fmt.Sprintf("v%d:%s:%d:%d:%d:%d:%d", fingerprintAlgorithm, cfg.PeakPicking,
+		cfg.MaxPeaksPerSecond, cfg.FanOut, cfg.TargetZoneMs, cfg.FreqBits, cfg.DeltaBits)
+	// Clips are normalized on the fly, so only normalized songs make
+	// fingerprints of their own
+	if cfg.NormalizeSongs && cfg.Normalize != "" {
+		version += fmt.Sprintf(":%s%g", cfg.Normalize, cfg.NormalizeTarget)
+	}
+	return version
+}
+
+// FingerprintVersion returns the version of the fingerprints made with the
+// current settings.
+func FingerprintVersion() string {
+	return currentFingerprintConfig().Version()
+}
+
+// fingerprintConfigOf converts the fingerprint settings of a config file.
+func fingerprintConfigOf(cfg config.Fingerprint) FingerprintConfig {
+	target := cfg.Normalize.TargetLUFS
+	if cfg.Normalize.Mode == config.NormalizePeak {
+		target = cfg.Normalize.TargetPeakDB
+	}
+	return FingerprintConfig{
+		PeakPicking:       cfg.PeakPicking,
+		MaxPeaksPerSecond: cfg.MaxPeaksPerSecond,
+		FanOut:            cfg.FanOut,
+		TargetZoneMs:      cfg.TargetZoneMs,
+		FreqBits:          cfg.FreqBits,
+		DeltaBits:         cfg.DeltaBits,
+		Normalize:         cfg.Normalize.Mode,
+		NormalizeTarget:   target,
+		NormalizeSongs:    cfg.Normalize.Songs,
+	}
+}
+
+// currentFingerprintConfig returns the settings fingerprints are made with.
+// Builds that can read the config file override it.
+var currentFingerprintConfig = func() FingerprintConfig {
+	return fingerprintConfigOf(config.Default().Fingerprint)
+}
 
 // Fingerprint generates fingerprints from a list of peaks and stores them in an array.
 // Each fingerprint consists of an address and a couple.
 // The address is a hash. The couple contains the anchor time and the song ID.
 func Fingerprint(peaks []Peak, songID uint32) map[uint32]models.Couple {
+	cfg := currentFingerprintConfig()
 	fingerprints := map[uint32]models.Couple{}
 
 	for i, anchor := range peaks {
-		for j := i + 1; j < len(peaks) && j <= i+targetZoneSize; j++ {
+		for j := i + 1; j < len(peaks) && j <= i+cfg.FanOut; j++ {
 			target := peaks[j]
+			if (target.Time-anchor.Time)*1000 > float64(cfg.TargetZoneMs) {
+				continue
+			}
 
-			address := createAddress(anchor, target)
+			address := createAddress(anchor, target, cfg)
 			anchorTimeMs := uint32(anchor.Time * 1000)
 
-			fingerprints[address] = models.Couple{anchorTimeMs, songID}
+			fingerprints[address] = models.Couple{AnchorTimeMs: anchorTimeMs, SongID: songID}
 		}
 	}
 
@@ -34,13 +110,14 @@ func Fingerprint(peaks []Peak, songID uint32) map[uint32]models.Couple {
 // The address is a 32-bit integer where certain bits represent the frequency of
 // the anchor and target points, and other bits represent the time difference (delta time)
 // between them. This function combines these components into a single address (a hash).
-func createAddress(anchor, target Peak) uint32 {
-	anchorFreq := int(real(anchor.Freq))
-	targetFreq := int(real(target.Freq))
+func createAddress(anchor, target Peak, cfg FingerprintConfig) uint32 {
+	// Fewer frequency bits put neighbouring bins in the same address
+	anchorFreq := int(real(anchor.Freq)) >> (config.MaxFreqBits - cfg.FreqBits)
+	targetFreq := int(real(target.Freq)) >> (config.MaxFreqBits - cfg.FreqBits)
 	deltaMs := uint32((target.Time - anchor.Time) * 1000)
 
 	// Combine the frequency of the anchor, target, and delta time into a 32-bit address
-	address := uint32(anchorFreq<<23) | uint32(targetFreq<<14) | deltaMs
+	address := uint32(anchorFreq<<(cfg.FreqBits+cfg.DeltaBits)) | uint32(targetFreq<<cfg.DeltaBits) | deltaMs
 
 	return address
 }
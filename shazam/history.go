@@ -0,0 +1,29 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"song-recognition/db"
+	"time"
+)
+
+// RecordRecognition adds the outcome of a recognition to the history: the
+// best of matches, or a miss if there are none.
+func RecordRecognition(ctx context.Context, matches []Match) error {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	recognition := db.Recognition{CreatedAt: time.Now()}
+	if len(matches) > 0 {
+		recognition.SongID = matches[0].SongID
+		recognition.Score = matches[0].Score
+		recognition.OffsetMs = matches[0].Timestamp
+	}
+
+	return dbClient.RecordRecognition(ctx, recognition)
+}
@@ -0,0 +1,93 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"sort"
+	"time"
+)
+
+// DefaultMelodyMinScore is the score melody matches need unless asked
+// otherwise. Unrelated melodies tend to score 70 to 85.
+const DefaultMelodyMinScore = 85
+
+// minHummedPoints is the fewest voiced contour points a hummed sample needs
+// for its melody to be matched, about two seconds of humming.
+const minHummedPoints = 14
+
+// FindMelodyMatches finds the songs whose melody the audio sample hums or
+// whistles. Candidates are scored from 0 to 100 by how closely the sample's
+// pitch contour follows the song's, in whatever key and tempo it was hummed.
+// Only songs saved with a melody contour can be found.
+func FindMelodyMatches(ctx context.Context, audioSample []float64, sampleRate int, opts MatchOptions) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+	logger := utils.GetLogger()
+
+	contour, err := MelodyContour(audioSample, sampleRate)
+	if err != nil {
+		return nil, time.Since(startTime), fmt.Errorf("failed to get melody of samples: %v", err)
+	}
+
+	query := melodyPoints(contour)
+	if len(query) < minHummedPoints {
+		return nil, time.Since(startTime), nil
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+	defer dbClient.Close()
+
+	melodies, err := dbClient.ListMelodies(ctx)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
+
+	var matchList []Match
+	for songID, songContour := range melodies {
+		if err := ctx.Err(); err != nil {
+			return nil, time.Since(startTime), err
+		}
+
+		points := melodyPoints(songContour)
+		cost, start := alignMelody(query, points)
+		if math.IsInf(cost, 1) {
+			continue
+		}
+
+		score := 100 * (1 - cost/melodyMaxCost)
+		if score <= 0 || score < opts.MinScore {
+			continue
+		}
+
+		song, songExists, err := dbClient.GetSongByID(ctx, songID)
+		if !songExists {
+			logger.Info(fmt.Sprintf("song with ID (%v) doesn't exist", songID))
+			continue
+		}
+		if err != nil {
+			logger.Info(fmt.Sprintf("failed to get song by ID (%v): %v", songID, err))
+			continue
+		}
+
+		timestamp := uint32(float64(points[start].frame) * MelodyFrameMs)
+		matchList = append(matchList, Match{songID, song.Title, song.Artist, song.YouTubeID, song.PreviewKey, timestamp, score, 0, nil})
+	}
+
+	sort.Slice(matchList, func(i, j int) bool {
+		return matchList[i].Score > matchList[j].Score
+	})
+
+	if opts.TopN > 0 && len(matchList) > opts.TopN {
+		matchList = matchList[:opts.TopN]
+	}
+
+	return matchList, time.Since(startTime), nil
+}
@@ -9,6 +9,81 @@ import (
 	"os"
 )
 
+// spectrogramRange is the range of loudness, in decibels below the loudest
+// bin, drawn by RenderSpectrogram. Quieter bins are black.
+const spectrogramRange = 80
+
+// peakColor marks peaks on the images of RenderSpectrogram.
+var peakColor = color.RGBA{R: 255, A: 255}
+
+// DrawSpectrogram computes the spectrogram of audio of duration seconds
+// and draws it with RenderSpectrogram, marking the peaks fingerprints are
+// made of if withPeaks is set.
+func DrawSpectrogram(samples []float64, sampleRate int, duration float64, withPeaks bool) (*image.RGBA, error) {
+	spectrogram, err := Spectrogram(samples, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var peaks []Peak
+	if withPeaks {
+		peaks = ExtractPeaks(spectrogram, duration)
+	}
+	return RenderSpectrogram(spectrogram, peaks, duration), nil
+}
+
+// RenderSpectrogram draws a spectrogram of audio of duration seconds as a
+// grayscale heat map, a column per window with time running left to right
+// and a row per frequency bin up to the Nyquist frequency, low frequencies
+// at the bottom. Loudness is drawn on a decibel scale so quiet detail
+// shows. peaks are marked on it in red.
+func RenderSpectrogram(spectrogram [][]complex128, peaks []Peak, duration float64) *image.RGBA {
+	width := len(spectrogram)
+	height := 0
+	if width > 0 {
+		// The upper half of the bins mirrors the lower half
+		height = len(spectrogram[0]) / 2
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == 0 || height == 0 {
+		return img
+	}
+
+	maxMagnitude := 0.0
+	for _, window := range spectrogram {
+		for _, bin := range window[:height] {
+			maxMagnitude = math.Max(maxMagnitude, cmplx.Abs(bin))
+		}
+	}
+
+	for x, window := range spectrogram {
+		for bin, value := range window[:height] {
+			var intensity uint8
+			if magnitude := cmplx.Abs(value); magnitude > 0 && maxMagnitude > 0 {
+				db := 20 * math.Log10(magnitude/maxMagnitude)
+				intensity = uint8(255 * math.Max(0, 1+db/spectrogramRange))
+			}
+			img.SetRGBA(x, height-1-bin, color.RGBA{R: intensity, G: intensity, B: intensity, A: 255})
+		}
+	}
+
+	windowDuration := duration / float64(width)
+	for _, peak := range peaks {
+		x := int(peak.Time / windowDuration)
+		y := height - 1 - peak.bin
+		// A 3x3 dot, clipped to the image
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				if image.Pt(x+dx, y+dy).In(img.Rect) {
+					img.SetRGBA(x+dx, y+dy, peakColor)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
 // ConvertSpectrogramToImage converts a spectrogram to a heat map image
 func SpectrogramToImage(spectrogram [][]complex128, outputPath string) error {
 	numWindows := len(spectrogram)
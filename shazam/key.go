@@ -0,0 +1,102 @@
+package shazam
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Krumhansl-Kessler key profiles: how well each pitch class, counted up
+// from the tonic, fits a major or minor key.
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+var pitchClasses = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Frequencies the chromagram is built from. Lower bins are too far apart
+// to tell neighbouring notes apart.
+const (
+	minChromaFreq = 100.0
+	maxChromaFreq = maxFreq
+	// Songs whose pitch classes correlate less with every key profile have
+	// no clear key.
+	minKeyCorrelation = 0.5
+)
+
+// Key estimates the musical key of a song, such as "A minor" or "F# major",
+// from its spectrogram, as returned by Spectrogram for audio at sampleRate.
+// Keys are named with sharps. It returns an empty string if the song has no
+// clear key.
+func Key(spectrogram [][]complex128, sampleRate int) string {
+	return chromaKey(chromagram(spectrogram, sampleRate))
+}
+
+// chromaKey picks the key whose profile best fits the energy of each pitch
+// class of a song.
+func chromaKey(chroma [12]float64) string {
+	best, bestCorrelation := "", 0.0
+	for tonic := range pitchClasses {
+		for _, mode := range []struct {
+			name    string
+			profile [12]float64
+		}{{"major", majorProfile}, {"minor", minorProfile}} {
+			var rotated [12]float64
+			for i := range rotated {
+				rotated[(tonic+i)%12] = mode.profile[i]
+			}
+
+			if r := correlation(chroma[:], rotated[:]); r > bestCorrelation {
+				best, bestCorrelation = pitchClasses[tonic]+" "+mode.name, r
+			}
+		}
+	}
+
+	if bestCorrelation < minKeyCorrelation {
+		return ""
+	}
+	return best
+}
+
+// chromagram sums the energy of every pitch class over the whole song.
+func chromagram(spectrogram [][]complex128, sampleRate int) [12]float64 {
+	var chroma [12]float64
+	binHz := float64(sampleRate/dspRatio) / freqBinSize
+
+	for _, window := range spectrogram {
+		for k := 1; k < freqBinSize/2; k++ {
+			freq := float64(k) * binHz
+			if freq < minChromaFreq || freq > maxChromaFreq {
+				continue
+			}
+
+			// Pitch class of the bin, 0 being C
+			note := int(math.Round(12*math.Log2(freq/440))) + 69
+			chroma[note%12] += cmplx.Abs(window[k])
+		}
+	}
+
+	return chroma
+}
+
+// correlation returns the Pearson correlation of x and y.
+func correlation(x, y []float64) float64 {
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(len(x))
+	meanY /= float64(len(y))
+
+	var cov, varX, varY float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		varX += (x[i] - meanX) * (x[i] - meanX)
+		varY += (y[i] - meanY) * (y[i] - meanY)
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
@@ -0,0 +1,184 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/utils"
+	"song-recognition/wav"
+)
+
+// Defaults for LiveRecognizer.
+const (
+	liveWindowSeconds    = 2
+	DefaultLiveMinScore  = 40
+	DefaultLiveMinMargin = 2
+	DefaultLiveMaxLength = 30
+)
+
+// LiveResult is the state of a live recognition after a window of audio
+// has been fingerprinted.
+type LiveResult struct {
+	Matches  []Match // candidates, best first
+	Best     *Match  // set once the best candidate is confident enough
+	Duration float64 // seconds of audio heard so far
+}
+
+// LiveRecognizer fingerprints audio as it arrives, a short window at a
+// time, and re-runs matching over everything heard so far after every
+// window. This lets a caller stop as soon as a match is certain instead of
+// waiting for a fixed-length clip.
+type LiveRecognizer struct {
+	// MinScore is the score the best candidate needs to be confident.
+	MinScore float64
+	// MinMargin is how many times the runner-up's score the best
+	// candidate's score must be.
+	MinMargin float64
+	// MaxLength is the number of seconds of audio after which Done
+	// reports true.
+	MaxLength float64
+	// Options tunes the candidate list of each result.
+	Options MatchOptions
+
+	sampleRate  int
+	pending     []float64 // samples at sampleRate not yet fingerprinted
+	heard       float64   // seconds fingerprinted so far
+	carry       []Peak
+	songID      uint32
+	fingerprint map[uint32]uint32
+}
+
+// NewLiveRecognizer returns a recognizer for mono audio at sampleRate,
+// which is resampled to the rate of the fingerprint database as needed.
+func NewLiveRecognizer(sampleRate int) (*LiveRecognizer, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	return &LiveRecognizer{
+		MinScore:    DefaultLiveMinScore,
+		MinMargin:   DefaultLiveMinMargin,
+		MaxLength:   DefaultLiveMaxLength,
+		Options:     DefaultMatchOptions(),
+		sampleRate:  sampleRate,
+		songID:      utils.GenerateUniqueID(),
+		fingerprint: make(map[uint32]uint32),
+	}, nil
+}
+
+// Feed adds samples to the recognizer. Each time a full window has
+// accumulated it is fingerprinted and matched, and the result is returned;
+// otherwise the result is nil.
+func (r *LiveRecognizer) Feed(ctx context.Context, samples []float64) (*LiveResult, error) {
+	r.pending = append(r.pending, samples...)
+
+	window := liveWindowSeconds * r.sampleRate
+	if len(r.pending) < window {
+		return nil, nil
+	}
+
+	var result *LiveResult
+	for len(r.pending) >= window {
+		var err error
+		result, err = r.process(ctx, r.pending[:window])
+		if err != nil {
+			return nil, err
+		}
+		r.pending = r.pending[window:]
+	}
+
+	// Don't hold on to the backing array of everything fed so far
+	r.pending = append([]float64(nil), r.pending...)
+
+	return result, nil
+}
+
+// Flush fingerprints and matches whatever audio is still pending.
+func (r *LiveRecognizer) Flush(ctx context.Context) (*LiveResult, error) {
+	samples := r.pending
+	r.pending = nil
+	return r.process(ctx, samples)
+}
+
+// Done reports whether MaxLength seconds of audio have been heard.
+func (r *LiveRecognizer) Done() bool {
+	return r.MaxLength > 0 && r.heard >= r.MaxLength
+}
+
+func (r *LiveRecognizer) process(ctx context.Context, samples []float64) (*LiveResult, error) {
+	if len(samples) > 0 {
+		if err := r.addWindow(samples); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := r.Options
+	if opts.TopN == 1 {
+		opts.TopN = 2 // the runner-up is needed to judge confidence
+	}
+
+	matches, _, err := FindMatchesFGP(ctx, r.fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LiveResult{Matches: matches, Duration: r.heard}
+	if r.confident(matches) {
+		result.Best = &matches[0]
+	}
+
+	return result, nil
+}
+
+// addWindow fingerprints a window of samples at the recognizer's rate.
+func (r *LiveRecognizer) addWindow(samples []float64) error {
+	if r.sampleRate != wav.StandardSampleRate {
+		resampled, err := wav.Resample(samples, r.sampleRate, wav.StandardSampleRate)
+		if err != nil {
+			return fmt.Errorf("failed to resample audio: %v", err)
+		}
+		samples = resampled
+	}
+	// Windows are normalized one at a time, as the clip is still being heard
+	samples = NormalizeClip(samples, wav.StandardSampleRate)
+
+	duration := float64(len(samples)) / float64(wav.StandardSampleRate)
+	spectrogram, err := Spectrogram(samples, wav.StandardSampleRate)
+	if err != nil {
+		return fmt.Errorf("failed to get spectrogram of samples: %v", err)
+	}
+
+	peaks := ExtractPeaks(spectrogram, duration)
+	for i := range peaks {
+		peaks[i].Time += r.heard
+	}
+	r.heard += duration
+
+	// Carry the last peaks over so pairs spanning windows aren't lost
+	peaks = append(r.carry, peaks...)
+	if fanOut := currentFingerprintConfig().FanOut; len(peaks) > fanOut {
+		r.carry = append([]Peak(nil), peaks[len(peaks)-fanOut:]...)
+	} else {
+		r.carry = append([]Peak(nil), peaks...)
+	}
+
+	for address, couple := range Fingerprint(peaks, r.songID) {
+		r.fingerprint[address] = couple.AnchorTimeMs
+	}
+
+	return nil
+}
+
+// confident reports whether the best of matches clears MinScore and beats
+// the runner-up by MinMargin.
+func (r *LiveRecognizer) confident(matches []Match) bool {
+	if len(matches) == 0 || matches[0].Score < r.MinScore {
+		return false
+	}
+	if len(matches) == 1 {
+		return true
+	}
+	return matches[0].Score >= r.MinMargin*matches[1].Score
+}
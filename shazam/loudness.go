@@ -0,0 +1,170 @@
+package shazam
+
+import (
+	"math"
+	"song-recognition/config"
+)
+
+// Gating of the integrated loudness of EBU R128 (ITU-R BS.1770): the
+// loudness of overlapping blocks is measured, and blocks quieter than an
+// absolute floor, or than the average of the others by a margin, are
+// left out, so silence and quiet passages don't lower it.
+const (
+	loudnessBlockMs      = 400
+	loudnessStepMs       = 100
+	loudnessAbsoluteGate = -70.0 // LUFS
+	loudnessRelativeGate = -10.0 // LU below the loudness of ungated blocks
+)
+
+// biquad is a second-order IIR filter, with a0 normalized to 1.
+type biquad struct{ b0, b1, b2, a1, a2 float64 }
+
+// kWeighting returns the two stages of the K-weighting filter at
+// sampleRate: a high shelf modelling the head, then a high-pass. Their
+// coefficients are derived for any rate from the analog prototypes
+// BS.1770 specifies at 48 kHz.
+func kWeighting(sampleRate int) [2]biquad {
+	fs := float64(sampleRate)
+
+	// High shelf of about +4 dB above 1.5 kHz
+	k := math.Tan(math.Pi * 1681.974450955533 / fs)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// High-pass at 38 Hz
+	k = math.Tan(math.Pi * 38.13547087602444 / fs)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return [2]biquad{shelf, highPass}
+}
+
+// Loudness returns the integrated loudness of mono samples in LUFS, as
+// EBU R128 measures it, or -Inf for audio shorter than a block or too
+// quiet to measure.
+func Loudness(samples []float64, sampleRate int) float64 {
+	blockSize := sampleRate * loudnessBlockMs / 1000
+	stepSize := sampleRate * loudnessStepMs / 1000
+	if blockSize < 1 || stepSize < 1 || len(samples) < blockSize {
+		return math.Inf(-1)
+	}
+
+	// Squares of the K-weighted samples, summed up to each sample
+	sums := make([]float64, len(samples)+1)
+	filters := kWeighting(sampleRate)
+	var state [2][4]float64 // x[n-1], x[n-2], y[n-1], y[n-2] of each stage
+	for i, x := range samples {
+		for s, f := range filters {
+			st := &state[s]
+			y := f.b0*x + f.b1*st[0] + f.b2*st[1] - f.a1*st[2] - f.a2*st[3]
+			st[1], st[0] = st[0], x
+			st[3], st[2] = st[2], y
+			x = y
+		}
+		sums[i+1] = sums[i] + x*x
+	}
+
+	blockLoudness := func(power float64) float64 {
+		return -0.691 + 10*math.Log10(power)
+	}
+
+	var powers []float64
+	for start := 0; start+blockSize <= len(samples); start += stepSize {
+		power := (sums[start+blockSize] - sums[start]) / float64(blockSize)
+		if blockLoudness(power) > loudnessAbsoluteGate {
+			powers = append(powers, power)
+		}
+	}
+	if len(powers) == 0 {
+		return math.Inf(-1)
+	}
+
+	mean := func(powers []float64) float64 {
+		var sum float64
+		for _, power := range powers {
+			sum += power
+		}
+		return sum / float64(len(powers))
+	}
+
+	gate := blockLoudness(mean(powers)) + loudnessRelativeGate
+	gated := powers[:0]
+	for _, power := range powers {
+		if blockLoudness(power) > gate {
+			gated = append(gated, power)
+		}
+	}
+	return blockLoudness(mean(gated))
+}
+
+// peakLevel returns the level of the loudest sample in dBFS.
+func peakLevel(samples []float64) float64 {
+	var peak float64
+	for _, sample := range samples {
+		peak = max(peak, math.Abs(sample))
+	}
+	return 20 * math.Log10(peak)
+}
+
+// normalize returns a copy of samples brought to target with mode, a
+// loudness in LUFS for config.NormalizeR128 and a level in dBFS for
+// config.NormalizePeak. Samples that can't be measured, such as silence,
+// and other modes are returned as they are. Samples are floats, so the
+// gain may take them past full scale.
+func normalize(samples []float64, sampleRate int, mode string, target float64) []float64 {
+	var level float64
+	switch mode {
+	case config.NormalizeR128:
+		level = Loudness(samples, sampleRate)
+	case config.NormalizePeak:
+		level = peakLevel(samples)
+	default:
+		return samples
+	}
+	if math.IsInf(level, -1) {
+		return samples
+	}
+
+	gain := math.Pow(10, (target-level)/20)
+	normalized := make([]float64, len(samples))
+	for i, sample := range samples {
+		normalized[i] = sample * gain
+	}
+	return normalized
+}
+
+// NormalizeClip returns the samples of a clip normalized the way the
+// current settings say, to be fingerprinted. The average peak picking
+// hashes the value of the spectrum at peaks, which grows with the level of
+// the audio, so a clip much quieter or louder than its song shares few
+// hashes with it.
+func NormalizeClip(samples []float64, sampleRate int) []float64 {
+	cfg := currentFingerprintConfig()
+	return normalize(samples, sampleRate, cfg.Normalize, cfg.NormalizeTarget)
+}
+
+// NormalizeSong is NormalizeClip for the samples of a song, which are only
+// normalized if the settings normalize songs too.
+func NormalizeSong(samples []float64, sampleRate int) []float64 {
+	cfg := currentFingerprintConfig()
+	if !cfg.NormalizeSongs {
+		return samples
+	}
+	return normalize(samples, sampleRate, cfg.Normalize, cfg.NormalizeTarget)
+}
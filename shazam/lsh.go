@@ -0,0 +1,151 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"song-recognition/db"
+	"song-recognition/models"
+	"sort"
+)
+
+// The LSH index narrows the songs a clip is scored against to those likely
+// to match it. Fingerprints are cut into overlapping windows of time, the
+// set of coarse addresses of every window is summed up by MinHash, the
+// minimum of each of lshBands*lshRows hash functions over it, and every
+// lshRows of those minima are hashed together into a band. Windows sharing
+// many addresses share bands, so a clip shares bands with the windows of
+// the song it was recorded from. Addresses are coarsened first, as a clip
+// only has about a tenth of its addresses exactly in common with the song;
+// neighbouring frequencies and times between peaks share a coarse address.
+// Songs are indexed and clips looked up with the same windows and hashes;
+// changing them takes reindexing every song.
+const (
+	lshWindowMs     = 10000
+	lshStepMs       = 5000
+	lshBands        = 64
+	lshRows         = 1
+	lshMinAddresses = 16 // fewer make windows of silence that match anything
+	lshDeltaBits    = 4  // low bits of the time between peaks dropped
+)
+
+// LSHBands returns the bands of the LSH index of fingerprints, each once.
+func LSHBands(fingerprints []db.Fingerprint) []uint64 {
+	mask := lshMask(currentFingerprintConfig())
+
+	windows := map[uint32]map[uint32]bool{} // window -> coarse addresses
+	for _, fingerprint := range fingerprints {
+		// Every time falls in two windows, starting at the step it falls in
+		// and the step before
+		step := fingerprint.AnchorTimeMs / lshStepMs
+		for window := step - min(step, lshWindowMs/lshStepMs-1); window <= step; window++ {
+			if windows[window] == nil {
+				windows[window] = map[uint32]bool{}
+			}
+			windows[window][fingerprint.Address&mask] = true
+		}
+	}
+
+	seen := map[uint64]bool{}
+	var bands []uint64
+	for _, addresses := range windows {
+		if len(addresses) < lshMinAddresses {
+			continue
+		}
+
+		var minima [lshBands * lshRows]uint64
+		for i := range minima {
+			minima[i] = math.MaxUint64
+		}
+		for address := range addresses {
+			for i := range minima {
+				minima[i] = min(minima[i], mix64(uint64(address)<<8|uint64(i)))
+			}
+		}
+
+		for band := 0; band < lshBands; band++ {
+			key := mix64(uint64(band))
+			for _, minimum := range minima[band*lshRows : (band+1)*lshRows] {
+				key = mix64(key ^ minimum)
+			}
+			if !seen[key] {
+				seen[key] = true
+				bands = append(bands, key)
+			}
+		}
+	}
+
+	sort.Slice(bands, func(i, j int) bool { return bands[i] < bands[j] })
+	return bands
+}
+
+// lshMask returns the mask coarsening the addresses made with cfg: it
+// drops the lowest bit of both frequencies and the lowest lshDeltaBits of
+// the time between the peaks.
+func lshMask(cfg FingerprintConfig) uint32 {
+	anchorBit := uint32(1) << (cfg.FreqBits + cfg.DeltaBits)
+	targetBit := uint32(1) << cfg.DeltaBits
+	deltaBits := uint32(1)<<min(lshDeltaBits, cfg.DeltaBits) - 1
+	return ^(anchorBit | targetBit | deltaBits)
+}
+
+// mix64 is the finalizer of splitmix64, spreading x over all 64 bits.
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// IndexLSH stores the bands of the fingerprints of the current version a
+// song has, replacing those it had, so approximate lookups can find it.
+func IndexLSH(ctx context.Context, dbClient db.DBClient, songID uint32) error {
+	fingerprints, err := dbClient.ListFingerprints(ctx, songID)
+	if err != nil {
+		return fmt.Errorf("error listing fingerprints: %v", err)
+	}
+
+	version := FingerprintVersion()
+	current := fingerprints[:0]
+	for _, fingerprint := range fingerprints {
+		if fingerprint.Version == version {
+			current = append(current, fingerprint)
+		}
+	}
+
+	return dbClient.StoreLSHBands(ctx, songID, version, LSHBands(current))
+}
+
+// lshCouples returns the couples at the addresses of a sample that the
+// songs sharing the most LSH bands with it have, at most candidates songs.
+// Only the fingerprints of those songs are read, instead of every couple
+// stored at the addresses.
+func lshCouples(ctx context.Context, dbClient db.DBClient, sampleFingerprint map[uint32]uint32, candidates int) (map[uint32][]models.Couple, error) {
+	sample := make([]db.Fingerprint, 0, len(sampleFingerprint))
+	for address, anchorTimeMs := range sampleFingerprint {
+		sample = append(sample, db.Fingerprint{Address: address, AnchorTimeMs: anchorTimeMs})
+	}
+
+	version := FingerprintVersion()
+	songIDs, err := dbClient.LSHCandidates(ctx, version, LSHBands(sample), candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	couples := map[uint32][]models.Couple{}
+	for _, songID := range songIDs {
+		fingerprints, err := dbClient.ListFingerprints(ctx, songID)
+		if err != nil {
+			return nil, err
+		}
+		for _, fingerprint := range fingerprints {
+			if _, ok := sampleFingerprint[fingerprint.Address]; ok && fingerprint.Version == version {
+				couples[fingerprint.Address] = append(couples[fingerprint.Address], models.Couple{AnchorTimeMs: fingerprint.AnchorTimeMs, SongID: songID})
+			}
+		}
+	}
+	return couples, nil
+}
@@ -0,0 +1,239 @@
+package shazam
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"song-recognition/wav"
+	"sort"
+)
+
+// Pitch contour analysis. Audio is downsampled like for the spectrogram and
+// cut into overlapping frames, each reduced to the pitch of its strongest
+// melody line.
+const (
+	melodyFrameSize = 2048
+	melodyHopSize   = 512
+	melodyHarmonics = 8
+	// Pitches tracked, as MIDI note numbers: G2 to C6 covers both sung and
+	// whistled melodies.
+	melodyMinNote = 43
+	melodyMaxNote = 84
+	// Frames quieter than this fraction of the loudest frame are unvoiced.
+	melodySilence = 0.05
+	// Frames whose best pitch doesn't stand out this much from the average
+	// one are unvoiced.
+	melodyMinSalience = 1.5
+)
+
+// MelodyFrameMs is the duration of audio covered by each value of a pitch
+// contour, in milliseconds.
+const MelodyFrameMs = melodyHopSize * 1000 / float64(wav.StandardSampleRate/dspRatio)
+
+// MelodyContour estimates the pitch of the melody over time. Every byte
+// covers MelodyFrameMs of audio and holds the pitch in half semitones (twice
+// the MIDI note number), or zero where no melody is heard.
+func MelodyContour(samples []float64, sampleRate int) ([]byte, error) {
+	// Contours of audio at other rates would have frames of other lengths
+	if sampleRate != wav.StandardSampleRate {
+		resampled, err := wav.Resample(samples, sampleRate, wav.StandardSampleRate)
+		if err != nil {
+			return nil, err
+		}
+		samples, sampleRate = resampled, wav.StandardSampleRate
+	}
+
+	filtered := LowPassFilter(maxFreq, float64(sampleRate), samples)
+	downsampled, err := Downsample(filtered, sampleRate, sampleRate/dspRatio)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't downsample audio sample: %v", err)
+	}
+	rate := float64(sampleRate / dspRatio)
+
+	window := make([]float64, melodyFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(melodyFrameSize-1))
+	}
+
+	numFrames := 0
+	if len(downsampled) >= melodyFrameSize {
+		numFrames = (len(downsampled)-melodyFrameSize)/melodyHopSize + 1
+	}
+
+	pitches := make([]float64, numFrames)
+	energies := make([]float64, numFrames)
+	maxEnergy := 0.0
+
+	frame := make([]float64, melodyFrameSize)
+	magnitudes := make([]float64, melodyFrameSize/2)
+	for i := 0; i < numFrames; i++ {
+		start := i * melodyHopSize
+		energy := 0.0
+		for j := range frame {
+			frame[j] = downsampled[start+j] * window[j]
+			energy += downsampled[start+j] * downsampled[start+j]
+		}
+		energies[i] = energy
+		maxEnergy = math.Max(maxEnergy, energy)
+
+		spectrum := FFT(frame)
+		for k := range magnitudes {
+			// Compress the dynamic range so loud low harmonics don't drown
+			// out the rest
+			magnitudes[k] = math.Log1p(cmplx.Abs(spectrum[k]))
+		}
+		pitches[i] = framePitch(magnitudes, rate)
+	}
+
+	contour := make([]byte, numFrames)
+	for i, pitch := range pitches {
+		if pitch == 0 || energies[i] < melodySilence*maxEnergy {
+			continue
+		}
+		contour[i] = byte(math.Round(pitch * 2))
+	}
+
+	return contour, nil
+}
+
+// framePitch picks the pitch whose harmonics carry the most energy by
+// harmonic summation, and returns it as a MIDI note number, or zero if no
+// pitch stands out.
+func framePitch(magnitudes []float64, rate float64) float64 {
+	binHz := rate / melodyFrameSize
+
+	best, bestNote, total, count := 0.0, 0.0, 0.0, 0
+	for halfNote := melodyMinNote * 2; halfNote <= melodyMaxNote*2; halfNote++ {
+		note := float64(halfNote) / 2
+		f0 := 440 * math.Pow(2, (note-69)/12)
+
+		salience, weight := 0.0, 1.0
+		for h := 1; h <= melodyHarmonics; h++ {
+			freq := f0 * float64(h)
+			if freq >= maxFreq {
+				break
+			}
+			salience += weight * interpolate(magnitudes, freq/binHz)
+			weight *= 0.8
+		}
+
+		total += salience
+		count++
+		if salience > best {
+			best, bestNote = salience, note
+		}
+	}
+
+	if count == 0 || best < melodyMinSalience*total/float64(count) {
+		return 0
+	}
+	return bestNote
+}
+
+// Contour matching. Contours are smoothed and thinned out before matching,
+// and the cost of a step is the distance between two pitches ignoring
+// octaves, capped so a couple of wrong notes don't sink a match.
+const (
+	melodyMedianSize = 5
+	melodyThinning   = 3
+	melodyMaxCost    = 3.0 // semitones
+	melodyWarpCost   = 0.5
+)
+
+// melodyPoint is a voiced point of a thinned out contour.
+type melodyPoint struct {
+	pitch float64 // MIDI note number
+	frame int     // index into the contour it came from
+}
+
+// melodyPoints smooths contour with a median filter and keeps the pitch of
+// every melodyThinning'th frame that is voiced.
+func melodyPoints(contour []byte) []melodyPoint {
+	var points []melodyPoint
+	window := make([]float64, 0, melodyMedianSize)
+
+	for i := 0; i < len(contour); i += melodyThinning {
+		if contour[i] == 0 {
+			continue
+		}
+
+		window = window[:0]
+		for j := max(0, i-melodyMedianSize/2); j <= min(len(contour)-1, i+melodyMedianSize/2); j++ {
+			if contour[j] != 0 {
+				window = append(window, float64(contour[j])/2)
+			}
+		}
+		sort.Float64s(window)
+
+		points = append(points, melodyPoint{pitch: window[len(window)/2], frame: i})
+	}
+
+	return points
+}
+
+// pitchDistance is the distance in semitones between two pitches ignoring
+// octaves, capped at melodyMaxCost.
+func pitchDistance(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 12)
+	return math.Min(math.Min(d, 12-d), melodyMaxCost)
+}
+
+// alignMelody finds where in song the query melody fits best, in any key and
+// at up to twice or half the tempo, by subsequence dynamic time warping.
+// Tempo changes cost melodyWarpCost per point. It returns the average cost
+// per query point, from zero to melodyMaxCost, and the song point the
+// alignment starts at.
+func alignMelody(query, song []melodyPoint) (float64, int) {
+	bestCost, bestStart := math.Inf(1), 0
+	if len(query) == 0 || len(song) == 0 {
+		return bestCost, bestStart
+	}
+
+	// cost[i][j] is the cheapest alignment of query[:i+1] ending at
+	// song[j]; start[i][j] is the song point it starts at. Only the last
+	// three rows are kept.
+	var cost, start [3][]float64
+	for r := range cost {
+		cost[r] = make([]float64, len(song))
+		start[r] = make([]float64, len(song))
+	}
+
+	// Keys are tried a semitone apart over an octave
+	for shift := 0.0; shift < 12; shift++ {
+		for i := range query {
+			cur, prev, prev2 := i%3, (i+2)%3, (i+1)%3
+			for j := range song {
+				local := pitchDistance(query[i].pitch+shift, song[j].pitch)
+				if i == 0 {
+					// The alignment may start anywhere in the song
+					cost[cur][j], start[cur][j] = local, float64(j)
+					continue
+				}
+
+				// Steps of one point in both, or two in one of them at a
+				// cost, so the alignment doesn't pick its notes at will
+				c, s := math.Inf(1), 0.0
+				if j >= 1 && cost[prev][j-1] < c {
+					c, s = cost[prev][j-1], start[prev][j-1]
+				}
+				if j >= 2 && cost[prev][j-2]+melodyWarpCost < c {
+					c, s = cost[prev][j-2]+melodyWarpCost, start[prev][j-2]
+				}
+				if i >= 2 && j >= 1 && cost[prev2][j-1]+melodyWarpCost < c {
+					c, s = cost[prev2][j-1]+melodyWarpCost, start[prev2][j-1]
+				}
+				cost[cur][j], start[cur][j] = c+local, s
+			}
+		}
+
+		// The alignment may end anywhere in the song too
+		last := (len(query) - 1) % 3
+		for j := range song {
+			if cost[last][j] < bestCost {
+				bestCost, bestStart = cost[last][j], int(start[last][j])
+			}
+		}
+	}
+
+	return bestCost / float64(len(query)), bestStart
+}
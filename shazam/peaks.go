@@ -0,0 +1,150 @@
+package shazam
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// Band-limited peak picking. The spectrum is split into bands of equal
+// width on a logarithmic scale, and every band picks its peaks on its own,
+// against its own noise floor, so loud noise in some bands can't crowd out
+// the peaks of the others.
+const (
+	logBandCount   = 12
+	logBandMinBin  = 4 // about 43 Hz
+	logBandMaxBin  = freqBinSize / 2
+	noiseFloorSpan = 22 // windows on each side, about two seconds
+	// Peaks must be this much louder than their band's noise floor.
+	peakFloorRatio = 1.25
+)
+
+// logBands are the bin ranges of the bands, from low to high frequencies.
+var logBands = func() []struct{ min, max int } {
+	bands := make([]struct{ min, max int }, 0, logBandCount)
+	ratio := float64(logBandMaxBin) / logBandMinBin
+
+	low := logBandMinBin
+	for i := 1; i <= logBandCount; i++ {
+		high := int(math.Round(logBandMinBin * math.Pow(ratio, float64(i)/logBandCount)))
+		if high <= low {
+			high = low + 1
+		}
+		bands = append(bands, struct{ min, max int }{low, high})
+		low = high
+	}
+	return bands
+}()
+
+// ExtractBandPeaks extracts the peaks of a spectrogram like ExtractPeaks,
+// but picks them band by band on a logarithmic frequency scale, which
+// keeps background noise from dominating. A band yields a peak in a window
+// when its loudest bin is louder than in the windows next to it and above
+// the band's average level over the surrounding seconds.
+//
+// The peaks carry the index of their frequency bin rather than its value,
+// so their hashes don't depend on how the windows of a clip line up with
+// those of the song.
+func ExtractBandPeaks(spectrogram [][]complex128, audioDuration float64) []Peak {
+	if len(spectrogram) < 1 {
+		return []Peak{}
+	}
+
+	type bandMax struct {
+		magnitude float64
+		freq      complex128
+		freqIdx   int
+	}
+
+	// Loudest bin of every band in every window
+	maxes := make([][]bandMax, len(spectrogram))
+	for binIdx, bin := range spectrogram {
+		maxes[binIdx] = make([]bandMax, len(logBands))
+		for b, band := range logBands {
+			for idx := band.min; idx < band.max && idx < len(bin); idx++ {
+				if magnitude := cmplx.Abs(bin[idx]); magnitude > maxes[binIdx][b].magnitude {
+					maxes[binIdx][b] = bandMax{magnitude, bin[idx], idx}
+				}
+			}
+		}
+	}
+
+	// Running sums give each band's average level around a window
+	sums := make([][]float64, len(spectrogram)+1)
+	sums[0] = make([]float64, len(logBands))
+	for binIdx := range spectrogram {
+		sums[binIdx+1] = make([]float64, len(logBands))
+		for b := range logBands {
+			sums[binIdx+1][b] = sums[binIdx][b] + maxes[binIdx][b].magnitude
+		}
+	}
+
+	var peaks []Peak
+	binDuration := audioDuration / float64(len(spectrogram))
+
+	for binIdx, bin := range spectrogram {
+		from := max(0, binIdx-noiseFloorSpan)
+		to := min(len(spectrogram), binIdx+noiseFloorSpan+1)
+
+		for b := range logBands {
+			value := maxes[binIdx][b]
+			if value.magnitude == 0 {
+				continue
+			}
+			if binIdx > 0 && maxes[binIdx-1][b].magnitude > value.magnitude {
+				continue
+			}
+			if binIdx+1 < len(spectrogram) && maxes[binIdx+1][b].magnitude > value.magnitude {
+				continue
+			}
+
+			floor := (sums[to][b] - sums[from][b]) / float64(to-from)
+			if value.magnitude < peakFloorRatio*floor {
+				continue
+			}
+
+			peakTimeInBin := float64(value.freqIdx) * binDuration / float64(len(bin))
+			peakTime := float64(binIdx)*binDuration + peakTimeInBin
+
+			peaks = append(peaks, Peak{Time: peakTime, Freq: complex(float64(value.freqIdx), 0), magnitude: value.magnitude, bin: value.freqIdx})
+		}
+	}
+
+	return peaks
+}
+
+// thinPeaks keeps the maxPerSecond loudest peaks of every second, in their
+// order. Zero keeps every peak.
+func thinPeaks(peaks []Peak, maxPerSecond int) []Peak {
+	if maxPerSecond <= 0 {
+		return peaks
+	}
+
+	var thinned []Peak
+	for start := 0; start < len(peaks); {
+		end := start
+		for end < len(peaks) && int(peaks[end].Time) == int(peaks[start].Time) {
+			end++
+		}
+
+		second := peaks[start:end]
+		if len(second) > maxPerSecond {
+			loudest := append([]Peak(nil), second...)
+			sort.SliceStable(loudest, func(i, j int) bool { return loudest[i].magnitude > loudest[j].magnitude })
+			threshold := loudest[maxPerSecond-1].magnitude
+
+			kept := 0
+			for _, peak := range second {
+				if peak.magnitude > threshold || (peak.magnitude == threshold && kept < maxPerSecond) {
+					thinned = append(thinned, peak)
+					kept++
+				}
+			}
+		} else {
+			thinned = append(thinned, second...)
+		}
+		start = end
+	}
+
+	return thinned
+}
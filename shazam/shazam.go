@@ -4,79 +4,311 @@
 package shazam
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"song-recognition/config"
 	"song-recognition/db"
+	"song-recognition/models"
 	"song-recognition/utils"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
 type Match struct {
-	SongID     uint32
-	SongTitle  string
-	SongArtist string
-	YouTubeID  string
-	Timestamp  uint32
-	Score      float64
+	SongID        uint32
+	SongTitle     string
+	SongArtist    string
+	YouTubeID     string
+	PreviewKey    string // key of the song's preview clip, if it has one
+	Timestamp     uint32 // where in the song the sample starts, in milliseconds
+	Score         float64
+	MatchedHashes int // sample hashes found among the song's fingerprints
+	// Diagnostics is set when MatchOptions.Explain is.
+	Diagnostics *Diagnostics `json:",omitempty"`
+}
+
+// MatchOptions tunes the candidate list returned by the FindMatches
+// functions.
+type MatchOptions struct {
+	// TopN caps the number of candidates returned, best first. Zero
+	// returns every song with at least one matching hash.
+	TopN int
+	// MinScore drops candidates scoring lower, so a clip that matches
+	// nothing well enough gets no match instead of a weak one.
+	MinScore float64
+	// Explain attaches Diagnostics to every candidate.
+	Explain bool
+	// MaxStretch also matches clips as if they had been sped up or slowed
+	// down by up to this fraction, such as 0.05 for 5%, the way radio
+	// stations speed up tracks. Every half percent costs another lookup.
+	// Only FindMatches honours it.
+	MaxStretch float64
+	// IDFWeighting weights every matched hash by its inverse document
+	// frequency, the rarer among songs the heavier, so hashes common to
+	// songs of similar sound count for less. Hashes no other song has
+	// weigh 1, as all hashes do without it.
+	IDFWeighting bool
+	// LSHCandidates scores clips against only the songs sharing the most
+	// bands of the LSH index with them, at most this many, reading their
+	// fingerprints instead of every couple stored at the clip's hashes.
+	// Songs not in the index aren't found, and IDF weights only count the
+	// candidates. Zero looks every hash up.
+	LSHCandidates int
+	// LSHFallback looks every hash up after all when no LSH candidate
+	// scores MinScore.
+	LSHFallback bool
+	// Store is the database clips are looked up in, or nil to connect to
+	// the one DB_TYPE selects for every lookup. The LSH index is only used
+	// in stores that are a whole db.DBClient. The caller keeps ownership
+	// of Store and closes it when done.
+	Store db.Store
+}
+
+func init() {
+	currentFingerprintConfig = func() FingerprintConfig {
+		return fingerprintConfigOf(config.Get().Fingerprint)
+	}
+}
+
+// DefaultMatchOptions returns the match options set in the config file.
+func DefaultMatchOptions() MatchOptions {
+	cfg := config.Get()
+	opts := MatchOptions{
+		TopN:         cfg.Match.TopN,
+		MinScore:     cfg.Match.MinScore,
+		MaxStretch:   cfg.Match.MaxStretch,
+		IDFWeighting: cfg.Match.IDFWeighting,
+		LSHFallback:  cfg.LSH.Fallback,
+	}
+	if cfg.LSH.Enabled {
+		opts.LSHCandidates = cfg.LSH.Candidates
+	}
+	return opts
 }
 
 // FindMatches analyzes the audio sample to find matching songs in the database.
-func FindMatches(audioSample []float64, audioDuration float64, sampleRate int) ([]Match, time.Duration, error) {
+func FindMatches(ctx context.Context, audioSample []float64, audioDuration float64, sampleRate int, opts MatchOptions) ([]Match, time.Duration, error) {
 	startTime := time.Now()
+	audioSample = NormalizeClip(audioSample, sampleRate)
 
-	spectrogram, err := Spectrogram(audioSample, sampleRate)
-	if err != nil {
-		return nil, time.Since(startTime), fmt.Errorf("failed to get spectrogram of samples: %v", err)
+	// Every speed is matched on its own, and songs keep their best match
+	best := map[uint32]Match{}
+	for _, speed := range stretchSpeeds(opts.MaxStretch) {
+		samples, duration := audioSample, audioDuration
+		if speed != 1 {
+			var err error
+			if samples, err = unstretch(audioSample, sampleRate, speed); err != nil {
+				return nil, time.Since(startTime), fmt.Errorf("failed to unstretch samples: %v", err)
+			}
+			duration = audioDuration * speed
+		}
+
+		spectrogram, err := Spectrogram(samples, sampleRate)
+		if err != nil {
+			return nil, time.Since(startTime), fmt.Errorf("failed to get spectrogram of samples: %v", err)
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, time.Since(startTime), err
+		}
+
+		peaks := ExtractPeaks(spectrogram, duration)
+		sampleFingerprint := Fingerprint(peaks, utils.GenerateUniqueID())
+
+		sampleFingerprintMap := make(map[uint32]uint32)
+		for address, couple := range sampleFingerprint {
+			sampleFingerprintMap[address] = couple.AnchorTimeMs
+		}
+
+		matches, _, err := FindMatchesFGP(ctx, sampleFingerprintMap, opts)
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+
+		for _, match := range matches {
+			if match.Diagnostics != nil {
+				match.Diagnostics.Speed = speed
+			}
+			if current, ok := best[match.SongID]; !ok || ranksBefore(match, current) {
+				best[match.SongID] = match
+			}
+		}
+	}
+
+	matchList := make([]Match, 0, len(best))
+	for _, match := range best {
+		matchList = append(matchList, match)
+	}
+	sortMatches(matchList)
+
+	// Every speed returned its TopN, so the best TopN overall are among them
+	if opts.TopN > 0 && len(matchList) > opts.TopN {
+		matchList = matchList[:opts.TopN]
 	}
 
-	peaks := ExtractPeaks(spectrogram, audioDuration)
-	sampleFingerprint := Fingerprint(peaks, utils.GenerateUniqueID())
+	return matchList, time.Since(startTime), nil
+}
+
+// FindMatchesFromReader fingerprints a sample stream incrementally and
+// finds matching songs, keeping only one chunk of samples in memory. With
+// opts.MaxStretch set, or clips normalized, the whole stream is read into
+// memory and matched with FindMatches instead.
+func FindMatchesFromReader(ctx context.Context, r SampleReader, sampleRate int, opts MatchOptions) ([]Match, time.Duration, error) {
+	startTime := time.Now()
+
+	if opts.MaxStretch > 0 || currentFingerprintConfig().Normalize != "" {
+		samples, err := readAll(r, sampleRate)
+		if err != nil {
+			return nil, time.Since(startTime), fmt.Errorf("failed to read samples: %v", err)
+		}
+		matches, _, err := FindMatches(ctx, samples, float64(len(samples))/float64(sampleRate), sampleRate, opts)
+		return matches, time.Since(startTime), err
+	}
 
 	sampleFingerprintMap := make(map[uint32]uint32)
-	for address, couple := range sampleFingerprint {
-		sampleFingerprintMap[address] = couple.AnchorTimeMs
+	_, err := FingerprintStream(r, sampleRate, utils.GenerateUniqueID(), func(fingerprints map[uint32]models.Couple) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for address, couple := range fingerprints {
+			sampleFingerprintMap[address] = couple.AnchorTimeMs
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, time.Since(startTime), fmt.Errorf("failed to fingerprint samples: %v", err)
 	}
 
-	matches, _, err := FindMatchesFGP(sampleFingerprintMap)
+	matches, _, err := FindMatchesFGP(ctx, sampleFingerprintMap, opts)
+	if err != nil {
+		return nil, time.Since(startTime), err
+	}
 
 	return matches, time.Since(startTime), nil
 }
 
-// FindMatchesFGP uses the sample fingerprint to find matching songs in the database.
-func FindMatchesFGP(sampleFingerprint map[uint32]uint32) ([]Match, time.Duration, error) {
+// matchSource is where the couples at a sample's addresses, and the songs
+// they belong to, are read from: the database or a fingerprint index file.
+type matchSource interface {
+	GetCouples(ctx context.Context, addresses []uint32, version string) (map[uint32][]models.Couple, error)
+	TotalSongs(ctx context.Context) (int, error)
+	GetSongByID(ctx context.Context, songID uint32) (db.Song, bool, error)
+}
+
+var fingerprintIndex atomic.Pointer[db.FingerprintIndex]
+
+// UseFingerprintIndex makes the FindMatches functions look clips up in
+// index instead of the database, as long as its fingerprints are of the
+// current version, or in the database again if index is nil. The index
+// must stay open while it is used.
+func UseFingerprintIndex(index *db.FingerprintIndex) {
+	fingerprintIndex.Store(index)
+}
+
+// FindMatchesFGP uses the sample fingerprint to find matching songs in the
+// database, or in the fingerprint index file in use. Candidates are ranked
+// by score, then by matched hashes.
+func FindMatchesFGP(ctx context.Context, sampleFingerprint map[uint32]uint32, opts MatchOptions) ([]Match, time.Duration, error) {
 	startTime := time.Now()
-	logger := utils.GetLogger()
 
 	addresses := make([]uint32, 0, len(sampleFingerprint))
 	for address := range sampleFingerprint {
 		addresses = append(addresses, address)
 	}
 
-	db, err := db.NewDBClient()
-	if err != nil {
-		return nil, time.Since(startTime), err
+	if index := fingerprintIndex.Load(); index != nil && index.Version() == FingerprintVersion() {
+		m, err := index.GetCouples(ctx, addresses, index.Version())
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+		matchList, err := scoreCouples(ctx, index, sampleFingerprint, m, opts)
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+		return rankMatches(matchList, opts), time.Since(startTime), nil
 	}
-	defer db.Close()
 
-	m, err := db.GetCouples(addresses)
-	if err != nil {
-		return nil, time.Since(startTime), err
+	store := opts.Store
+	if store == nil {
+		dbClient, err := db.NewDBClient()
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+		defer dbClient.Close()
+		store = dbClient
+	}
+
+	var matchList []Match
+	dbClient, indexed := store.(db.DBClient)
+	if opts.LSHCandidates > 0 && indexed {
+		m, err := lshCouples(ctx, dbClient, sampleFingerprint, opts.LSHCandidates)
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+		if matchList, err = scoreCouples(ctx, store, sampleFingerprint, m, opts); err != nil {
+			return nil, time.Since(startTime), err
+		}
+	}
+
+	if opts.LSHCandidates == 0 || !indexed || (len(matchList) == 0 && opts.LSHFallback) {
+		// Fingerprints made with other settings would match by accident
+		m, err := store.GetCouples(ctx, addresses, FingerprintVersion())
+		if err != nil {
+			return nil, time.Since(startTime), err
+		}
+		if matchList, err = scoreCouples(ctx, store, sampleFingerprint, m, opts); err != nil {
+			return nil, time.Since(startTime), err
+		}
+	}
+
+	return rankMatches(matchList, opts), time.Since(startTime), nil
+}
+
+// rankMatches sorts candidates best first, keeping the opts.TopN best.
+func rankMatches(matchList []Match, opts MatchOptions) []Match {
+	sortMatches(matchList)
+
+	if opts.TopN > 0 && len(matchList) > opts.TopN {
+		matchList = matchList[:opts.TopN]
+	}
+	return matchList
+}
+
+// scoreCouples scores the songs of the couples m found at the addresses of
+// a sample fingerprint, leaving out those scoring less than opts.MinScore.
+func scoreCouples(ctx context.Context, dbClient matchSource, sampleFingerprint map[uint32]uint32, m map[uint32][]models.Couple, opts MatchOptions) ([]Match, error) {
+	logger := utils.GetLogger()
+
+	var addressWeights map[uint32]float64
+	if opts.IDFWeighting {
+		totalSongs, err := dbClient.TotalSongs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		addressWeights = idfWeights(m, totalSongs)
 	}
 
 	matches := map[uint32][][2]uint32{}        // songID -> [(sampleTime, dbTime)]
-	timestamps := map[uint32]uint32{}          // songID -> earliest timestamp
+	weights := map[uint32][]float64{}          // songID -> weight of every match, if weighted
 	targetZones := map[uint32]map[uint32]int{} // songID -> timestamp -> count
+	hashesHit := map[uint32]int{}              // songID -> sample addresses found
 
 	for address, couples := range m {
+		hitSongs := map[uint32]bool{}
 		for _, couple := range couples {
+			if !hitSongs[couple.SongID] {
+				hitSongs[couple.SongID] = true
+				hashesHit[couple.SongID]++
+			}
+
 			matches[couple.SongID] = append(
 				matches[couple.SongID],
 				[2]uint32{sampleFingerprint[address], couple.AnchorTimeMs},
 			)
-
-			if existingTime, ok := timestamps[couple.SongID]; !ok || couple.AnchorTimeMs < existingTime {
-				timestamps[couple.SongID] = couple.AnchorTimeMs
+			if addressWeights != nil {
+				weights[couple.SongID] = append(weights[couple.SongID], addressWeights[address])
 			}
 
 			if _, ok := targetZones[couple.SongID]; !ok {
@@ -88,12 +320,16 @@ func FindMatchesFGP(sampleFingerprint map[uint32]uint32) ([]Match, time.Duration
 
 	// matches = filterMatches(10, matches, targetZones)
 
-	scores := analyzeRelativeTiming(matches)
+	scores := analyzeRelativeTiming(matches, weights)
 
 	var matchList []Match
 
 	for songID, points := range scores {
-		song, songExists, err := db.GetSongByID(songID)
+		if points < opts.MinScore {
+			continue
+		}
+
+		song, songExists, err := dbClient.GetSongByID(ctx, songID)
 		if !songExists {
 			logger.Info(fmt.Sprintf("song with ID (%v) doesn't exist", songID))
 			continue
@@ -103,15 +339,29 @@ func FindMatchesFGP(sampleFingerprint map[uint32]uint32) ([]Match, time.Duration
 			continue
 		}
 
-		match := Match{songID, song.Title, song.Artist, song.YouTubeID, timestamps[songID], points}
+		offset, aligned := alignOffset(matches[songID])
+		match := Match{songID, song.Title, song.Artist, song.YouTubeID, song.PreviewKey, offset, points, hashesHit[songID], nil}
+		if opts.Explain {
+			match.Diagnostics = explain(matches[songID], hashesHit[songID], len(sampleFingerprint), aligned)
+		}
 		matchList = append(matchList, match)
 	}
 
-	sort.Slice(matchList, func(i, j int) bool {
-		return matchList[i].Score > matchList[j].Score
-	})
+	return matchList, nil
+}
 
-	return matchList, time.Since(startTime), nil
+// ranksBefore reports whether candidate a ranks before b: by score, then
+// by matched hashes.
+func ranksBefore(a, b Match) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.MatchedHashes > b.MatchedHashes
+}
+
+// sortMatches sorts candidates best first.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool { return ranksBefore(matches[i], matches[j]) })
 }
 
 // filterMatches filters out matches that don't have enough
@@ -122,10 +372,11 @@ func filterMatches(
 	targetZones map[uint32]map[uint32]int) map[uint32][][2]uint32 {
 
 	// Filter out non target zones.
-	// When a target zone has less than `targetZoneSize` anchor times, it is not considered a target zone.
+	// When a target zone has less anchor times than the fan-out, it is not considered a target zone.
+	fanOut := currentFingerprintConfig().FanOut
 	for songID, anchorTimes := range targetZones {
 		for anchorTime, count := range anchorTimes {
-			if count < targetZoneSize {
+			if count < fanOut {
 				delete(targetZones[songID], anchorTime)
 			}
 		}
@@ -141,22 +392,108 @@ func filterMatches(
 	return filteredMatches
 }
 
+// offsetToleranceMs is how far apart the offsets of hashes that agree on
+// an alignment may be. It absorbs the jitter of the spectrogram's frame
+// grid, which falls differently on a clip and on the song it came from.
+const offsetToleranceMs = 200
+
+// alignOffset returns where in a song a sample starts, given its matching
+// (sampleTime, dbTime) pairs, and how many of the pairs agree with it.
+// Hashes of the true match all sit at about the same offset dbTime -
+// sampleTime, so the densest run of offsets wins.
+func alignOffset(times [][2]uint32) (uint32, int) {
+	if len(times) == 0 {
+		return 0, 0
+	}
+
+	offsets := make([]int64, len(times))
+	for i, t := range times {
+		offsets[i] = int64(t[1]) - int64(t[0])
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	// Slide a window of offsetToleranceMs over the sorted offsets
+	bestStart, bestEnd := 0, 1
+	for start, end := 0, 0; start < len(offsets); start++ {
+		for end < len(offsets) && offsets[end]-offsets[start] <= offsetToleranceMs {
+			end++
+		}
+		if end-start > bestEnd-bestStart {
+			bestStart, bestEnd = start, end
+		}
+	}
+
+	var sum int64
+	for _, offset := range offsets[bestStart:bestEnd] {
+		sum += offset
+	}
+	aligned := bestEnd - bestStart
+	mean := sum / int64(aligned)
+	if mean < 0 {
+		return 0, aligned // the sample starts before the song, e.g. with a lead-in
+	}
+	return uint32(mean), aligned
+}
+
+// FormatOffset formats a song offset in milliseconds as m:ss, or h:mm:ss
+// for offsets of an hour or more.
+func FormatOffset(offsetMs uint32) string {
+	seconds := offsetMs / 1000
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
 // analyzeRelativeTiming calculates a score for each song based on the
-// relative timing between the song and the sample's anchor times.
-func analyzeRelativeTiming(matches map[uint32][][2]uint32) map[uint32]float64 {
+// relative timing between the song and the sample's anchor times. Every
+// pair of matches that agrees adds the product of their weights, or 1 for
+// songs without weights.
+func analyzeRelativeTiming(matches map[uint32][][2]uint32, weights map[uint32][]float64) map[uint32]float64 {
 	scores := make(map[uint32]float64)
 	for songID, times := range matches {
-		count := 0
+		songWeights := weights[songID]
+		var score float64
 		for i := 0; i < len(times); i++ {
 			for j := i + 1; j < len(times); j++ {
 				sampleDiff := math.Abs(float64(times[i][0] - times[j][0]))
 				dbDiff := math.Abs(float64(times[i][1] - times[j][1]))
 				if math.Abs(sampleDiff-dbDiff) < 100 { // Allow some tolerance
-					count++
+					if songWeights != nil {
+						score += songWeights[i] * songWeights[j]
+					} else {
+						score++
+					}
 				}
 			}
 		}
-		scores[songID] = float64(count)
+		scores[songID] = score
 	}
 	return scores
 }
+
+// idfWeights returns the weight of every address of couples: the inverse
+// document frequency log((n+1)/df) of the address among the n songs
+// stored, divided by that of an address of a single song, so weights run
+// from 1 for hashes no other song has down towards 0 for those all songs
+// have.
+func idfWeights(couples map[uint32][]models.Couple, totalSongs int) map[uint32]float64 {
+	weights := make(map[uint32]float64, len(couples))
+	maxIDF := math.Log(float64(totalSongs) + 1)
+
+	for address, stored := range couples {
+		songs := map[uint32]bool{}
+		for _, couple := range stored {
+			songs[couple.SongID] = true
+		}
+
+		// Songs saved since they were counted can make df exceed n
+		df := min(len(songs), totalSongs)
+		if df <= 1 || maxIDF == 0 {
+			weights[address] = 1
+			continue
+		}
+		weights[address] = math.Log((float64(totalSongs)+1)/float64(df)) / maxIDF
+	}
+	return weights
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"math/cmplx"
+	"song-recognition/config"
 )
 
 const (
@@ -30,9 +31,10 @@ func Spectrogram(sample []float64, sampleRate int) ([][]complex128, error) {
 		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/(float64(freqBinSize)-1))
 	}
 
-	// Perform STFT
+	// Perform STFT. Consecutive windows overlap by hopSize samples, so the
+	// windows cover the whole sample and bin i starts at i*(freqBinSize-hopSize).
 	for i := 0; i < numOfWindows; i++ {
-		start := i * hopSize
+		start := i * (freqBinSize - hopSize)
 		end := start + freqBinSize
 		if end > len(downsampledSample) {
 			end = len(downsampledSample)
@@ -117,10 +119,31 @@ func Downsample(input []float64, originalSampleRate, targetSampleRate int) ([]fl
 type Peak struct {
 	Time float64
 	Freq complex128
+
+	magnitude float64
+	// bin is the frequency bin of the peak in the spectrogram.
+	bin int
 }
 
 // ExtractPeaks analyzes a spectrogram and extracts significant peaks in the frequency domain over time.
+// Peaks are picked with the method set in the config file, see
+// ExtractBandPeaks, and thinned to its peak density.
 func ExtractPeaks(spectrogram [][]complex128, audioDuration float64) []Peak {
+	cfg := currentFingerprintConfig()
+
+	var peaks []Peak
+	if cfg.PeakPicking == config.PeakPickingLogBands {
+		peaks = ExtractBandPeaks(spectrogram, audioDuration)
+	} else {
+		peaks = extractAveragePeaks(spectrogram, audioDuration)
+	}
+
+	return thinPeaks(peaks, cfg.MaxPeaksPerSecond)
+}
+
+// extractAveragePeaks keeps, in every window, the loudest bins of a few
+// fixed bands that are louder than the average of them.
+func extractAveragePeaks(spectrogram [][]complex128, audioDuration float64) []Peak {
 	if len(spectrogram) < 1 {
 		return []Peak{}
 	}
@@ -177,7 +200,7 @@ func ExtractPeaks(spectrogram [][]complex128, audioDuration float64) []Peak {
 				// Calculate the absolute time of the peak
 				peakTime := float64(binIdx)*binDuration + peakTimeInBin
 
-				peaks = append(peaks, Peak{Time: peakTime, Freq: maxFreqs[i]})
+				peaks = append(peaks, Peak{Time: peakTime, Freq: maxFreqs[i], magnitude: value, bin: int(freqIndices[i])})
 			}
 		}
 	}
@@ -0,0 +1,209 @@
+package shazam
+
+import (
+	"fmt"
+	"io"
+	"song-recognition/models"
+)
+
+// streamChunkSeconds is the amount of audio held in memory at a time when
+// fingerprinting a stream.
+const streamChunkSeconds = 10
+
+// SampleReader is implemented by sources that yield mono samples
+// incrementally, such as wav.Reader.
+type SampleReader interface {
+	ReadSamples(dst []float64) (int, error)
+}
+
+// StreamPeaks reads r in fixed-size chunks, computes the spectrogram of each
+// chunk and passes its peaks to onPeaks. Peak times are relative to the start
+// of the stream. Only one chunk of samples is held in memory at a time.
+// It returns the total duration of the stream in seconds.
+func StreamPeaks(r SampleReader, sampleRate int, onPeaks func([]Peak) error) (float64, error) {
+	return streamSpectrograms(r, sampleRate, func(spectrogram [][]complex128, start, duration float64) error {
+		return onPeaks(chunkPeaks(spectrogram, start, duration))
+	})
+}
+
+// streamSpectrograms reads r in fixed-size chunks and passes the
+// spectrogram of each chunk, with its start and duration in seconds, to
+// onChunk. It returns the total duration of the stream in seconds.
+func streamSpectrograms(r SampleReader, sampleRate int, onChunk func(spectrogram [][]complex128, start, duration float64) error) (float64, error) {
+	if sampleRate <= 0 {
+		return 0, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	chunk := make([]float64, streamChunkSeconds*sampleRate)
+	var totalSamples int
+
+	for {
+		n, err := readFull(r, chunk)
+		if n > 0 {
+			chunkStart := float64(totalSamples) / float64(sampleRate)
+			chunkDuration := float64(n) / float64(sampleRate)
+			totalSamples += n
+
+			spectrogram, specErr := Spectrogram(chunk[:n], sampleRate)
+			if specErr != nil {
+				return 0, fmt.Errorf("failed to get spectrogram of chunk: %v", specErr)
+			}
+
+			if err := onChunk(spectrogram, chunkStart, chunkDuration); err != nil {
+				return 0, err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return float64(totalSamples) / float64(sampleRate), nil
+}
+
+// chunkPeaks extracts the peaks of the spectrogram of a chunk starting at
+// start seconds into the stream, with times relative to the stream.
+func chunkPeaks(spectrogram [][]complex128, start, duration float64) []Peak {
+	peaks := ExtractPeaks(spectrogram, duration)
+	for i := range peaks {
+		peaks[i].Time += start
+	}
+	return peaks
+}
+
+// FingerprintStream fingerprints a stream chunk by chunk, passing the
+// fingerprints of each chunk to onFingerprints. The last peaks of each chunk
+// are carried over so that anchor/target pairs spanning a chunk boundary are
+// not lost.
+func FingerprintStream(r SampleReader, sampleRate int, songID uint32, onFingerprints func(map[uint32]models.Couple) error) (float64, error) {
+	return streamSpectrograms(r, sampleRate, chunkFingerprinter(songID, onFingerprints))
+}
+
+// chunkFingerprinter returns the streamSpectrograms callback of
+// FingerprintStream.
+func chunkFingerprinter(songID uint32, onFingerprints func(map[uint32]models.Couple) error) func([][]complex128, float64, float64) error {
+	var carry []Peak
+	fanOut := currentFingerprintConfig().FanOut
+
+	return func(spectrogram [][]complex128, start, duration float64) error {
+		peaks := append(carry, chunkPeaks(spectrogram, start, duration)...)
+
+		if len(peaks) > fanOut {
+			carry = append([]Peak(nil), peaks[len(peaks)-fanOut:]...)
+		} else {
+			carry = append([]Peak(nil), peaks...)
+		}
+
+		return onFingerprints(Fingerprint(peaks, songID))
+	}
+}
+
+// SongFeatures is what FingerprintSong finds out about a song besides its
+// fingerprints.
+type SongFeatures struct {
+	Duration float64 // in seconds
+	Tempo    float64 // as estimated by Tempo
+	Key      string  // as estimated by Key
+}
+
+// FingerprintSong fingerprints a song like FingerprintStream and estimates
+// its tempo and key along the way, so that registering a song never holds
+// the spectrogram of all of it in memory.
+func FingerprintSong(r SampleReader, sampleRate int, songID uint32, onFingerprints func(map[uint32]models.Couple) error) (SongFeatures, error) {
+	fingerprint := chunkFingerprinter(songID, onFingerprints)
+
+	var flux []float64
+	var chroma [12]float64
+	var last []complex128
+
+	duration, err := streamSpectrograms(r, sampleRate, func(spectrogram [][]complex128, start, duration float64) error {
+		if len(spectrogram) == 0 {
+			return fingerprint(spectrogram, start, duration)
+		}
+
+		// The flux of the first window of a chunk is measured from the last
+		// window of the one before
+		if last != nil {
+			flux = append(flux, spectralFlux([][]complex128{last, spectrogram[0]})...)
+		}
+		flux = append(flux, spectralFlux(spectrogram)...)
+		last = spectrogram[len(spectrogram)-1]
+
+		chunkChroma := chromagram(spectrogram, sampleRate)
+		for i := range chroma {
+			chroma[i] += chunkChroma[i]
+		}
+
+		return fingerprint(spectrogram, start, duration)
+	})
+	if err != nil {
+		return SongFeatures{}, err
+	}
+
+	return SongFeatures{
+		Duration: duration,
+		Tempo:    onsetTempo(removeMean(flux), sampleRate),
+		Key:      chromaKey(chroma),
+	}, nil
+}
+
+// SliceReader is a SampleReader over samples held in memory.
+type SliceReader struct {
+	samples []float64
+}
+
+// NewSliceReader returns a SampleReader that yields samples.
+func NewSliceReader(samples []float64) *SliceReader {
+	return &SliceReader{samples: samples}
+}
+
+// ReadSamples copies the next samples to dst, returning io.EOF once all
+// have been read.
+func (r *SliceReader) ReadSamples(dst []float64) (int, error) {
+	if len(r.samples) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(dst, r.samples)
+	r.samples = r.samples[n:]
+	return n, nil
+}
+
+// readFull reads from r until dst is full or the stream ends.
+func readFull(r SampleReader, dst []float64) (int, error) {
+	total := 0
+	for total < len(dst) {
+		n, err := r.ReadSamples(dst[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			return total, io.EOF
+		}
+	}
+	return total, nil
+}
+
+// readAll reads r until the stream ends.
+func readAll(r SampleReader, sampleRate int) ([]float64, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	var samples []float64
+	chunk := make([]float64, streamChunkSeconds*sampleRate)
+	for {
+		n, err := readFull(r, chunk)
+		samples = append(samples, chunk[:n]...)
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
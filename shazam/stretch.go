@@ -0,0 +1,29 @@
+package shazam
+
+import (
+	"math"
+	"song-recognition/wav"
+)
+
+// stretchStep is the spacing of the speeds tried when matching with
+// MatchOptions.MaxStretch. Coarser steps leave the peaks of a clip too far
+// from those of the song, in both time and frequency, for hashes to match.
+const stretchStep = 0.005
+
+// stretchSpeeds returns the speeds, relative to the song, a clip may play
+// at when it is sped up or slowed down by up to maxStretch, starting with
+// its own.
+func stretchSpeeds(maxStretch float64) []float64 {
+	speeds := []float64{1}
+	for k := 1; float64(k)*stretchStep <= maxStretch+1e-9; k++ {
+		speeds = append(speeds, 1+float64(k)*stretchStep, 1-float64(k)*stretchStep)
+	}
+	return speeds
+}
+
+// unstretch undoes playing samples speed times as fast as the song, which
+// also undoes the pitch shift that comes with it. Tracks that were only
+// sped up, keeping their pitch, still match within a few percent.
+func unstretch(samples []float64, sampleRate int, speed float64) ([]float64, error) {
+	return wav.Resample(samples, sampleRate, int(math.Round(float64(sampleRate)*speed)))
+}
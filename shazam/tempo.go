@@ -0,0 +1,136 @@
+package shazam
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Tempos Tempo considers, in beats per minute. Tempos outside the range are
+// found at half or twice their rate.
+const (
+	minTempo = 60.0
+	maxTempo = 180.0
+	// Tempos far from this one are less likely to be picked, which settles
+	// whether a song is at a tempo or at half or twice of it.
+	preferredTempo = 120.0
+	// Multiples of the beat period whose periodicity counts towards a tempo.
+	tempoBeats = 8
+	// Songs whose onsets correlate less than this at the best tempo, on
+	// average over tempoBeats beats, have no steady beat.
+	minBeatStrength = 0.1
+)
+
+// Tempo estimates the tempo of a song in beats per minute from its
+// spectrogram, as returned by Spectrogram for audio at sampleRate, to a
+// tenth of a beat. It returns zero if the song is too short or has no
+// steady beat.
+func Tempo(spectrogram [][]complex128, sampleRate int) float64 {
+	return onsetTempo(onsetStrength(spectrogram), sampleRate)
+}
+
+// onsetTempo estimates the tempo from the onset strength of each window of
+// a spectrogram of audio at sampleRate.
+func onsetTempo(onsets []float64, sampleRate int) float64 {
+	framesPerSecond := float64(sampleRate/dspRatio) / float64(freqBinSize-hopSize)
+
+	maxLag := int(math.Ceil(tempoBeats*60*framesPerSecond/minTempo)) + 1
+	if len(onsets) < 2*maxLag {
+		return 0
+	}
+
+	acf := autocorrelation(onsets, maxLag)
+	if acf[0] == 0 {
+		return 0
+	}
+
+	best, bestScore := 0.0, 0.0
+	for tenths := int(minTempo * 10); tenths <= int(maxTempo*10); tenths++ {
+		bpm := float64(tenths) / 10
+		period := 60 * framesPerSecond / bpm
+
+		// Beat periods are fractions of a frame, so their multiples pin the
+		// tempo down more precisely than the period alone
+		score := 0.0
+		for k := 1; k <= tempoBeats; k++ {
+			score += interpolate(acf, period*float64(k))
+		}
+		score *= math.Exp(-0.5 * math.Pow(math.Log2(bpm/preferredTempo), 2))
+
+		if score > bestScore {
+			best, bestScore = bpm, score
+		}
+	}
+
+	if bestScore < minBeatStrength*tempoBeats*acf[0] {
+		return 0
+	}
+	return best
+}
+
+// onsetStrength returns how much the spectrum below about 1.4 kHz grows
+// from each window to the next, which peaks where notes and beats start.
+// Higher bands are left out: hi-hats and other fast percussion repeat more
+// often than the windows can follow.
+func onsetStrength(spectrogram [][]complex128) []float64 {
+	return removeMean(spectralFlux(spectrogram))
+}
+
+// spectralFlux returns how much the spectrum below about 1.4 kHz grows from
+// each window of spectrogram to the next.
+func spectralFlux(spectrogram [][]complex128) []float64 {
+	if len(spectrogram) < 2 {
+		return nil
+	}
+
+	flux := make([]float64, len(spectrogram)-1)
+	for i := 1; i < len(spectrogram); i++ {
+		for k := 0; k < freqBinSize/8; k++ {
+			diff := math.Log1p(cmplx.Abs(spectrogram[i][k])) - math.Log1p(cmplx.Abs(spectrogram[i-1][k]))
+			if diff > 0 {
+				flux[i-1] += diff
+			}
+		}
+	}
+
+	return flux
+}
+
+// removeMean subtracts the average of onsets from each of them, so steady
+// flux doesn't correlate at every lag.
+func removeMean(onsets []float64) []float64 {
+	if len(onsets) == 0 {
+		return onsets
+	}
+
+	mean := 0.0
+	for _, onset := range onsets {
+		mean += onset
+	}
+	mean /= float64(len(onsets))
+	for i := range onsets {
+		onsets[i] -= mean
+	}
+
+	return onsets
+}
+
+// autocorrelation returns the autocorrelation of x at lags 0 to maxLag-1.
+func autocorrelation(x []float64, maxLag int) []float64 {
+	acf := make([]float64, maxLag)
+	for lag := range acf {
+		for i := lag; i < len(x); i++ {
+			acf[lag] += x[i] * x[i-lag]
+		}
+	}
+	return acf
+}
+
+// interpolate returns x at a fractional index, interpolating linearly.
+func interpolate(x []float64, index float64) float64 {
+	i := int(index)
+	if i+1 >= len(x) {
+		return 0
+	}
+	frac := index - float64(i)
+	return x[i]*(1-frac) + x[i+1]*frac
+}
@@ -0,0 +1,151 @@
+//go:build !js && !wasm
+// +build !js,!wasm
+
+package shazam
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Default window and hop of Tracklist, in seconds.
+const (
+	DefaultTracklistWindow = 12
+	DefaultTracklistHop    = 6
+)
+
+// TracklistOptions tunes how Tracklist windows a recording.
+type TracklistOptions struct {
+	Window float64 // seconds of audio recognized at a time
+	Hop    float64 // seconds between the starts of consecutive windows
+	// Match applies to the recognition of each window. Only the best
+	// candidate of a window is used.
+	Match MatchOptions
+}
+
+// TrackEntry is a song identified in a recording.
+type TrackEntry struct {
+	SongID     uint32
+	SongTitle  string
+	SongArtist string
+	YouTubeID  string
+	Start      float64 // seconds into the recording the song was first heard
+	End        float64 // seconds into the recording the song was last heard
+	Score      float64 // best score among the windows that matched the song
+	Timestamp  uint32  // where in the song the recording's Start falls, in milliseconds
+}
+
+// Tracklist recognizes a long recording, such as a DJ mix or a live set, in
+// overlapping windows and returns the songs heard in it in order. Windows
+// in a row that match the same song are merged into a single entry. Only
+// one window of samples is held in memory at a time.
+func Tracklist(ctx context.Context, r SampleReader, sampleRate int, opts TracklistOptions) ([]TrackEntry, error) {
+	var entries []TrackEntry
+	err := RecognizeWindows(ctx, r, sampleRate, opts, func(match Match, start, end float64) error {
+		entries = addTrack(entries, match, start, end, opts.Hop)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RecognizeWindows reads r until it ends, recognizing every window of
+// opts.Window seconds, opts.Hop seconds apart. onMatch is called with the
+// best match of each window that matched anything, along with where the
+// window starts and ends in seconds. Streams that never end, such as
+// internet radio, are read until ctx is done.
+func RecognizeWindows(ctx context.Context, r SampleReader, sampleRate int, opts TracklistOptions, onMatch func(match Match, start, end float64) error) error {
+	if sampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	if opts.Window <= 0 || opts.Hop <= 0 || opts.Hop > opts.Window {
+		return fmt.Errorf("invalid window %vs and hop %vs", opts.Window, opts.Hop)
+	}
+	opts.Match.TopN = 1
+
+	windowSamples := int(opts.Window * float64(sampleRate))
+	hopSamples := int(opts.Hop * float64(sampleRate))
+
+	window := make([]float64, 0, windowSamples)
+	hop := make([]float64, hopSamples)
+	var (
+		windowStart float64 // seconds into the stream window[0] is
+		fresh       int     // samples read since the last recognized window
+	)
+
+	recognize := func() error {
+		duration := float64(len(window)) / float64(sampleRate)
+		matches, _, err := FindMatches(ctx, window, duration, sampleRate, opts.Match)
+		if err != nil {
+			return err
+		}
+		fresh = 0
+		if len(matches) == 0 {
+			return nil
+		}
+		return onMatch(matches[0], windowStart, windowStart+duration)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := readFull(r, hop)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// Slide the window forward by the samples just read
+		if overflow := len(window) + n - windowSamples; overflow > 0 {
+			window = window[:copy(window, window[overflow:])]
+			windowStart += float64(overflow) / float64(sampleRate)
+		}
+		window = append(window, hop[:n]...)
+		fresh += n
+
+		if err == io.EOF {
+			// Recognize the tail, unless it's too short to fingerprint
+			if fresh > 0 && len(window) >= sampleRate {
+				return recognize()
+			}
+			return nil
+		}
+
+		if len(window) == windowSamples && fresh >= hopSamples {
+			if err := recognize(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addTrack adds the best match of the window from start to end to
+// entries, extending the last entry instead if it is the same song and no
+// more than a hop of silence or unrecognized audio separates them.
+func addTrack(entries []TrackEntry, match Match, start, end, hop float64) []TrackEntry {
+	if n := len(entries); n > 0 {
+		last := &entries[n-1]
+		if last.SongID == match.SongID && start <= last.End+hop {
+			last.End = end
+			if match.Score > last.Score {
+				last.Score = match.Score
+			}
+			return entries
+		}
+	}
+
+	return append(entries, TrackEntry{
+		SongID:     match.SongID,
+		SongTitle:  match.SongTitle,
+		SongArtist: match.SongArtist,
+		YouTubeID:  match.YouTubeID,
+		Start:      start,
+		End:        end,
+		Score:      match.Score,
+		Timestamp:  match.Timestamp,
+	})
+}
@@ -0,0 +1,40 @@
+package shazam
+
+import "math"
+
+// WaveformPoints is the number of points of the waveforms stored with
+// songs, enough to draw one across a screen.
+const WaveformPoints = 1000
+
+// Waveform reduces audio to an amplitude envelope of at most points values,
+// each the peak amplitude of its share of the samples, scaled so 255 is
+// full scale. Audio with fewer samples than points gets one value per
+// sample.
+func Waveform(samples []float64, points int) []byte {
+	if len(samples) < points {
+		points = len(samples)
+	}
+
+	waveform := make([]byte, points)
+	for i := range waveform {
+		start := i * len(samples) / points
+		end := (i + 1) * len(samples) / points
+
+		var peak float64
+		for _, sample := range samples[start:end] {
+			peak = math.Max(peak, math.Abs(sample))
+		}
+		waveform[i] = byte(math.Round(math.Min(peak, 1) * 255))
+	}
+	return waveform
+}
+
+// Amplitudes turns a waveform into peak amplitudes from 0 to 1, rounded to
+// three decimals.
+func Amplitudes(waveform []byte) []float64 {
+	amplitudes := make([]float64, len(waveform))
+	for i, peak := range waveform {
+		amplitudes[i] = math.Round(float64(peak)/255*1000) / 1000
+	}
+	return amplitudes
+}
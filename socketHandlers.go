@@ -42,7 +42,7 @@ func handleTotalSongs(socket socketio.Conn) {
 	}
 	defer db.Close()
 
-	totalSongs, err := db.TotalSongs()
+	totalSongs, err := db.TotalSongs(ctx)
 	if err != nil {
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "Log error getting total songs", slog.Any("error", err))
@@ -74,7 +74,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		statusMsg := fmt.Sprintf("%v songs found in album.", len(tracksInAlbum))
 		socket.Emit("downloadStatus", downloadStatus("info", statusMsg))
 
-		totalTracksDownloaded, err := spotify.DlAlbum(spotifyURL, SONGS_DIR)
+		totalTracksDownloaded, err := spotify.DlAlbum(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			socket.Emit("downloadStatus", downloadStatus("error", "Couldn't to download album."))
 
@@ -104,7 +104,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		statusMsg := fmt.Sprintf("%v songs found in playlist.", len(tracksInPL))
 		socket.Emit("downloadStatus", downloadStatus("info", statusMsg))
 
-		totalTracksDownloaded, err := spotify.DlPlaylist(spotifyURL, SONGS_DIR)
+		totalTracksDownloaded, err := spotify.DlPlaylist(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			socket.Emit("downloadStatus", downloadStatus("error", "Couldn't download playlist."))
 
@@ -134,11 +134,13 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 		// check if track already exist
 		db, err := db.NewDBClient()
 		if err != nil {
-			fmt.Errorf("Log - error connecting to DB: %d", err)
+			err := xerrors.New(err)
+			logger.ErrorContext(ctx, "error connecting to DB", slog.Any("error", err))
+			return
 		}
 		defer db.Close()
 
-		song, songExists, err := db.GetSongByKey(utils.GenerateSongKey(trackInfo.Title, trackInfo.Artist))
+		song, songExists, err := db.GetSongByKey(ctx, utils.GenerateSongKey(trackInfo.Title, trackInfo.Artist))
 		if err == nil {
 			if songExists {
 				statusMsg := fmt.Sprintf(
@@ -153,7 +155,7 @@ func handleSongDownload(socket socketio.Conn, spotifyURL string) {
 			logger.ErrorContext(ctx, "failed to get song by key.", slog.Any("error", err))
 		}
 
-		totalDownloads, err := spotify.DlSingleTrack(spotifyURL, SONGS_DIR)
+		totalDownloads, err := spotify.DlSingleTrack(ctx, spotifyURL, SONGS_DIR)
 		if err != nil {
 			if len(err.Error()) <= 25 {
 				socket.Emit("downloadStatus", downloadStatus("error", err.Error()))
@@ -194,17 +196,15 @@ func handleNewRecording(socket socketio.Conn, recordData string) {
 		return
 	}
 
-	matches, _, err := shazam.FindMatches(samples, recData.Duration, recData.SampleRate)
+	matches, _, err := shazam.FindMatches(ctx, samples, recData.Duration, wav.StandardSampleRate, shazam.DefaultMatchOptions())
 	if err != nil {
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "failed to get matches.", slog.Any("error", err))
+	} else {
+		recordRecognition(ctx, matches)
 	}
 
 	jsonData, err := json.Marshal(matches)
-	if len(matches) > 10 {
-		jsonData, _ = json.Marshal(matches[:10])
-	}
-
 	if err != nil {
 		err := xerrors.New(err)
 		logger.ErrorContext(ctx, "failed to marshal matches.", slog.Any("error", err))
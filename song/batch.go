@@ -0,0 +1,116 @@
+package song
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// BatchResult is the outcome of processing one entry of a batch request.
+type BatchResult struct {
+	Index    int              `json:"index"`
+	Title    string           `json:"title"`
+	Artist   string           `json:"artist"`
+	Response *ProcessResponse `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// DefaultBatchConcurrency is the number of songs processed at once when a
+// batch doesn't specify its own limit.
+var DefaultBatchConcurrency = runtime.NumCPU()
+
+// ProcessSongsBatch processes inputs with at most concurrency songs in
+// flight and returns one result per input, in input order. A failing entry
+// doesn't stop the rest of the batch. The batch shares one database client.
+func ProcessSongsBatch(ctx context.Context, inputs []SongInput, concurrency int) []BatchResult {
+	pool, err := NewPool(concurrency)
+	if err != nil {
+		return failedBatch(len(inputs), err, func(i int, result *BatchResult) {
+			result.Title, result.Artist = inputs[i].Title, inputs[i].Artist
+		})
+	}
+	defer pool.Close()
+
+	return pool.ProcessBatch(ctx, inputs)
+}
+
+// failedBatch returns n results that all failed with err. describe fills
+// in the descriptive fields of each result.
+func failedBatch(n int, err error, describe func(i int, result *BatchResult)) []BatchResult {
+	results := make([]BatchResult, n)
+	for i := range results {
+		results[i].Index = i
+		results[i].Error = err.Error()
+		describe(i, &results[i])
+	}
+	return results
+}
+
+// runBatch calls process for indices 0..n-1 with at most concurrency calls
+// in flight and collects the results in index order. process may fill in
+// the descriptive fields of the result it is given.
+func runBatch(ctx context.Context, n, concurrency int, process func(i int, result *BatchResult) (*ProcessResponse, error)) []BatchResult {
+	if concurrency < 1 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	results := make([]BatchResult, n)
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		results[i].Index = i
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			response, err := process(i, &results[i])
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Response = response
+		}(i)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// ProcessSongsJSON accepts either a single SongInput object or an array of
+// them and processes every entry with DefaultBatchConcurrency.
+func ProcessSongsJSON(ctx context.Context, jsonInput []byte) ([]BatchResult, error) {
+	var inputs []SongInput
+
+	trimmed := bytes.TrimSpace(jsonInput)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &inputs); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON input: %v", err)
+		}
+	} else {
+		var input SongInput
+		if err := json.Unmarshal(trimmed, &input); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON input: %v", err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no songs in JSON input")
+	}
+
+	return ProcessSongsBatch(ctx, inputs, DefaultBatchConcurrency), nil
+}
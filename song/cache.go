@@ -0,0 +1,204 @@
+package song
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// errNotModified is returned by fetchAudio when the server answers a
+// conditional request with 304 Not Modified, so the cached copy is current.
+var errNotModified = errors.New("song URL not modified since it was cached")
+
+// cacheMu keeps evictions from removing files being stored or restored.
+var cacheMu sync.Mutex
+
+// cachePaths returns the paths of the cached copy of the download with key
+// and of what it was served as, or "" when the cache is disabled.
+func cachePaths(key string) (string, string) {
+	dir := config.Get().Download.CacheDir
+	if dir == "" {
+		return "", ""
+	}
+	return filepath.Join(dir, key+".audio"), filepath.Join(dir, key+".json")
+}
+
+// cachedValidators returns header with the conditional headers that ask
+// the server to answer 304 Not Modified if the cached copy of the download
+// with key is current. header is returned as is if there is no usable copy.
+func cachedValidators(key string, header http.Header) http.Header {
+	audioPath, metaPath := cachePaths(key)
+	if audioPath == "" {
+		return header
+	}
+	if _, err := os.Stat(audioPath); err != nil {
+		return header
+	}
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return header
+	}
+	var cached partialDownload
+	if err := json.Unmarshal(data, &cached); err != nil || (cached.ETag == "" && cached.LastModified == "") {
+		return header
+	}
+
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if cached.ETag != "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		header.Set("If-Modified-Since", cached.LastModified)
+	}
+	return header
+}
+
+// restoreCached copies the cached copy of the download with key to dst,
+// returning the Content-Type it was served as.
+func restoreCached(key, dst string) (string, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	audioPath, metaPath := cachePaths(key)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cached download: %v", err)
+	}
+	var cached partialDownload
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return "", fmt.Errorf("invalid cached download: %v", err)
+	}
+
+	if err := linkOrCopy(audioPath, dst); err != nil {
+		return "", fmt.Errorf("failed to restore cached download: %v", err)
+	}
+	// Keep the copies in use longest
+	now := time.Now()
+	os.Chtimes(audioPath, now, now)
+
+	return cached.ContentType, nil
+}
+
+// storeCached keeps a copy of the download at src, served as state, under
+// key, then evicts the least recently used copies over the size of the
+// cache. Downloads the server can't tell apart are useless to cache, since
+// they can't be asked for conditionally. Failures are logged, as the
+// download itself succeeded.
+func storeCached(ctx context.Context, key, src string, state partialDownload) {
+	audioPath, metaPath := cachePaths(key)
+	if audioPath == "" || (state.ETag == "" && state.LastModified == "") {
+		return
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	err := func() error {
+		if err := utils.CreateFolder(filepath.Dir(audioPath)); err != nil {
+			return err
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		os.Remove(audioPath)
+		if err := linkOrCopy(src, audioPath); err != nil {
+			return err
+		}
+		return os.WriteFile(metaPath, data, 0644)
+	}()
+	if err != nil {
+		os.Remove(audioPath)
+		os.Remove(metaPath)
+		utils.GetLogger().WarnContext(ctx, "Failed to cache download", slog.Any("error", xerrors.New(err)))
+		return
+	}
+
+	evictCache(ctx)
+}
+
+// evictCache removes the least recently used copies until the cache fits
+// in its maximum size. cacheMu must be held.
+func evictCache(ctx context.Context) {
+	cfg := config.Get().Download
+	if cfg.CacheMaxBytes <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(cfg.CacheDir)
+	if err != nil {
+		return
+	}
+
+	var copies []os.FileInfo
+	var size int64
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".audio") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		copies = append(copies, info)
+		size += info.Size()
+	}
+
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].ModTime().Before(copies[j].ModTime())
+	})
+	for _, info := range copies {
+		if size <= cfg.CacheMaxBytes {
+			break
+		}
+		audioPath := filepath.Join(cfg.CacheDir, info.Name())
+		if err := os.Remove(audioPath); err != nil {
+			utils.GetLogger().WarnContext(ctx, "Failed to evict cached download", slog.String("path", audioPath), slog.Any("error", xerrors.New(err)))
+			continue
+		}
+		os.Remove(strings.TrimSuffix(audioPath, ".audio") + ".json")
+		size -= info.Size()
+	}
+}
+
+// linkOrCopy hard links src to dst, or copies it where links aren't
+// supported, such as across file systems.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,55 @@
+package song
+
+import (
+	"context"
+	"log/slog"
+	"song-recognition/utils"
+	"song-recognition/webhook"
+	"time"
+)
+
+// Webhook event names.
+const (
+	EventSongProcessed = "song.processed"
+	EventSongFailed    = "song.failed"
+)
+
+// WebhookEvent is the body POSTed to a SongInput's CallbackURL.
+type WebhookEvent struct {
+	Event     string           `json:"event"`
+	JobID     string           `json:"job_id,omitempty"`
+	Title     string           `json:"title"`
+	Artist    string           `json:"artist"`
+	Response  *ProcessResponse `json:"response,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// notifyCallback delivers the outcome of processing input to its
+// CallbackURL. Delivery failures are logged, never returned: the song's
+// outcome doesn't depend on the receiver.
+func notifyCallback(ctx context.Context, input *SongInput, jobID string, response *ProcessResponse, err error) {
+	if input.CallbackURL == "" {
+		return
+	}
+
+	event := WebhookEvent{
+		Event:     EventSongProcessed,
+		JobID:     jobID,
+		Title:     input.Title,
+		Artist:    input.Artist,
+		Response:  response,
+		Timestamp: time.Now().UTC(),
+	}
+	if err != nil {
+		event.Event = EventSongFailed
+		event.Error = err.Error()
+	}
+
+	// Deliver even if the request that triggered processing has gone away
+	sendErr := webhook.Send(context.WithoutCancel(ctx), input.CallbackURL, event)
+	if sendErr != nil {
+		logger := utils.GetLogger()
+		logger.ErrorContext(ctx, "Error delivering webhook", slog.String("url", input.CallbackURL), slog.Any("error", sendErr))
+	}
+}
@@ -0,0 +1,348 @@
+package song
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/models"
+	"song-recognition/shazam"
+	"song-recognition/utils"
+	"time"
+)
+
+// CatalogFormat and CatalogVersion identify catalog files. The version is
+// raised when a change to the format would make older builds misread it.
+const (
+	CatalogFormat  = "seek-tune-catalog"
+	CatalogVersion = 1
+)
+
+// catalogPageSize is the number of songs read from the database at a time
+// while exporting.
+const catalogPageSize = 500
+
+// CatalogHeader is the first line of a catalog file.
+type CatalogHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Songs      int       `json:"songs"` // number of songs that follow, as counted when the export started
+}
+
+// CatalogSong is a song of a catalog file with everything needed to
+// recognize it. Stored audio files aren't part of catalogs, so the keys
+// they are stored under aren't either.
+type CatalogSong struct {
+	ID                   uint32            `json:"id"` // in the exporting database, which importing doesn't keep
+	Title                string            `json:"title"`
+	Artist               string            `json:"artist"`
+	YouTubeID            string            `json:"youtube_id,omitempty"`
+	Checksum             string            `json:"checksum,omitempty"`
+	Tempo                float64           `json:"tempo,omitempty"`
+	MusicalKey           string            `json:"musical_key,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	Album                string            `json:"album,omitempty"`
+	ReleaseYear          int               `json:"release_year,omitempty"`
+	MusicBrainzID        string            `json:"musicbrainz_id,omitempty"`
+	MusicBrainzReleaseID string            `json:"musicbrainz_release_id,omitempty"`
+	StoreURL             string            `json:"store_url,omitempty"`
+	Codec                string            `json:"codec,omitempty"`
+	Duration             float64           `json:"duration,omitempty"`
+	Bitrate              int               `json:"bitrate,omitempty"`
+	// Fingerprints holds the song's fingerprints by version, each packed as
+	// big-endian 32-bit address and anchor time pairs.
+	Fingerprints map[string][]byte `json:"fingerprints"`
+	Melody       []byte            `json:"melody,omitempty"`
+	Waveform     []byte            `json:"waveform,omitempty"`
+}
+
+// ExportCatalog writes every stored song to w with its fingerprints, melody
+// and waveform, as a CatalogHeader line followed by one CatalogSong per
+// line. It returns the number of songs exported.
+func ExportCatalog(ctx context.Context, w io.Writer) (int, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	total, err := dbClient.TotalSongs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error counting songs: %v", err)
+	}
+
+	melodies, err := dbClient.ListMelodies(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error listing melodies: %v", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(CatalogHeader{
+		Format:     CatalogFormat,
+		Version:    CatalogVersion,
+		ExportedAt: time.Now().UTC(),
+		Songs:      total,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	for offset := 0; ; offset += catalogPageSize {
+		songs, err := dbClient.ListSongs(ctx, offset, catalogPageSize)
+		if err != nil {
+			return exported, fmt.Errorf("error listing songs: %v", err)
+		}
+
+		for _, song := range songs {
+			if err := ctx.Err(); err != nil {
+				return exported, err
+			}
+
+			entry, err := catalogSong(ctx, dbClient, song)
+			if err != nil {
+				return exported, fmt.Errorf("error exporting song %d: %v", song.ID, err)
+			}
+			entry.Melody = melodies[song.ID]
+
+			if err := encoder.Encode(entry); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+
+		if len(songs) < catalogPageSize {
+			return exported, nil
+		}
+	}
+}
+
+// catalogSong reads what a catalog holds of song but its melody, which is
+// listed for all songs at once.
+func catalogSong(ctx context.Context, dbClient db.DBClient, song db.Song) (CatalogSong, error) {
+	fingerprints, err := dbClient.ListFingerprints(ctx, song.ID)
+	if err != nil {
+		return CatalogSong{}, err
+	}
+
+	packed := map[string][]byte{}
+	for _, fingerprint := range fingerprints {
+		packed[fingerprint.Version] = binary.BigEndian.AppendUint32(packed[fingerprint.Version], fingerprint.Address)
+		packed[fingerprint.Version] = binary.BigEndian.AppendUint32(packed[fingerprint.Version], fingerprint.AnchorTimeMs)
+	}
+
+	waveform, _, err := dbClient.GetWaveform(ctx, song.ID)
+	if err != nil {
+		return CatalogSong{}, err
+	}
+
+	return CatalogSong{
+		ID:                   song.ID,
+		Title:                song.Title,
+		Artist:               song.Artist,
+		YouTubeID:            song.YouTubeID,
+		Checksum:             song.Checksum,
+		Tempo:                song.Tempo,
+		MusicalKey:           song.MusicalKey,
+		Tags:                 song.Tags,
+		Album:                song.Album,
+		ReleaseYear:          song.ReleaseYear,
+		MusicBrainzID:        song.MusicBrainzID,
+		MusicBrainzReleaseID: song.MusicBrainzReleaseID,
+		StoreURL:             song.StoreURL,
+		Codec:                song.Codec,
+		Duration:             song.Duration,
+		Bitrate:              song.Bitrate,
+		Fingerprints:         packed,
+		Waveform:             waveform,
+	}, nil
+}
+
+// CatalogImport is what importing a catalog did.
+type CatalogImport struct {
+	Imported int
+	Skipped  int // already stored, by checksum or title and artist
+}
+
+// ImportCatalog stores the songs of a catalog written by ExportCatalog.
+// Songs get new IDs, and those already stored are skipped. A song that
+// fails to import is rolled back before the error is returned, leaving the
+// songs imported before it in place.
+func ImportCatalog(ctx context.Context, r io.Reader) (CatalogImport, error) {
+	logger := utils.GetLogger()
+
+	var result CatalogImport
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return result, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	var header CatalogHeader
+	if err := decoder.Decode(&header); err != nil {
+		return result, fmt.Errorf("error reading catalog header: %v", err)
+	}
+	if header.Format != CatalogFormat {
+		return result, fmt.Errorf("not a catalog file")
+	}
+	if header.Version > CatalogVersion {
+		return result, fmt.Errorf("catalog version %d is newer than the supported version %d", header.Version, CatalogVersion)
+	}
+
+	for {
+		var entry CatalogSong
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading catalog: %v", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		imported, err := importCatalogSong(ctx, dbClient, entry)
+		if err != nil {
+			return result, fmt.Errorf("error importing %q by %s: %v", entry.Title, entry.Artist, err)
+		}
+		if !imported {
+			logger.InfoContext(ctx, "Skipping stored song", slog.String("title", entry.Title), slog.String("artist", entry.Artist))
+			result.Skipped++
+			continue
+		}
+		result.Imported++
+	}
+}
+
+// importCatalogSong stores entry under a new ID, unless a song with its
+// checksum, or title and artist, is already stored.
+func importCatalogSong(ctx context.Context, dbClient db.DBClient, entry CatalogSong) (imported bool, err error) {
+	if entry.Checksum != "" {
+		_, exists, err := dbClient.GetSongByChecksum(ctx, entry.Checksum)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+	_, exists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(entry.Title, entry.Artist))
+	if err != nil || exists {
+		return false, err
+	}
+
+	var undo compensation
+	defer func() {
+		if err != nil {
+			undo.rollback(ctx)
+		}
+	}()
+
+	songID, err := dbClient.RegisterSong(ctx, entry.Title, entry.Artist, entry.YouTubeID, entry.Checksum)
+	if err != nil {
+		return false, fmt.Errorf("error registering song: %v", err)
+	}
+	undo.add("song registration", func(ctx context.Context) error {
+		return dbClient.DeleteSongByID(ctx, songID)
+	})
+
+	err = dbClient.SetSongAudio(ctx, songID, db.SongAudio{Codec: entry.Codec, Duration: entry.Duration, Bitrate: entry.Bitrate})
+	if err != nil {
+		return false, fmt.Errorf("error storing song audio: %v", err)
+	}
+	if entry.Tempo > 0 {
+		if err = dbClient.SetSongTempo(ctx, songID, entry.Tempo); err != nil {
+			return false, fmt.Errorf("error storing song tempo: %v", err)
+		}
+	}
+	if entry.MusicalKey != "" {
+		if err = dbClient.SetSongMusicalKey(ctx, songID, entry.MusicalKey); err != nil {
+			return false, fmt.Errorf("error storing song key: %v", err)
+		}
+	}
+	if len(entry.Tags) > 0 {
+		if err = dbClient.SetSongTags(ctx, songID, entry.Tags); err != nil {
+			return false, fmt.Errorf("error storing song tags: %v", err)
+		}
+	}
+	err = dbClient.SetSongMetadata(ctx, songID, db.SongMetadata{
+		Album:                entry.Album,
+		ReleaseYear:          entry.ReleaseYear,
+		MusicBrainzID:        entry.MusicBrainzID,
+		MusicBrainzReleaseID: entry.MusicBrainzReleaseID,
+		StoreURL:             entry.StoreURL,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error storing song metadata: %v", err)
+	}
+
+	undo.add("fingerprints", func(ctx context.Context) error {
+		return dbClient.DeleteFingerprintsBySongID(ctx, songID)
+	})
+	for version, packed := range entry.Fingerprints {
+		if len(packed)%8 != 0 {
+			err = fmt.Errorf("fingerprints of version %s are truncated", version)
+			return false, err
+		}
+		if err = storeCatalogFingerprints(ctx, dbClient, songID, version, packed); err != nil {
+			return false, fmt.Errorf("error storing fingerprints: %v", err)
+		}
+	}
+
+	if config.Get().LSH.Enabled {
+		undo.add("lsh bands", func(ctx context.Context) error {
+			return dbClient.DeleteLSHBands(ctx, songID)
+		})
+		if err = shazam.IndexLSH(ctx, dbClient, songID); err != nil {
+			return false, fmt.Errorf("error indexing song: %v", err)
+		}
+	}
+
+	if len(entry.Melody) > 0 {
+		undo.add("melody", func(ctx context.Context) error {
+			return dbClient.DeleteMelody(ctx, songID)
+		})
+		if err = dbClient.StoreMelody(ctx, songID, entry.Melody); err != nil {
+			return false, fmt.Errorf("error storing melody: %v", err)
+		}
+	}
+
+	if len(entry.Waveform) > 0 {
+		undo.add("waveform", func(ctx context.Context) error {
+			return dbClient.DeleteWaveform(ctx, songID)
+		})
+		if err = dbClient.StoreWaveform(ctx, songID, entry.Waveform); err != nil {
+			return false, fmt.Errorf("error storing waveform: %v", err)
+		}
+	}
+
+	return true, nil
+}
+
+// storeCatalogFingerprints stores packed fingerprints of a version for
+// songID. StoreFingerprints takes one couple per address, so fingerprints
+// sharing an address are stored in batches of their own.
+func storeCatalogFingerprints(ctx context.Context, dbClient db.DBClient, songID uint32, version string, packed []byte) error {
+	batch := map[uint32]models.Couple{}
+	for i := 0; i < len(packed); i += 8 {
+		address := binary.BigEndian.Uint32(packed[i:])
+		if _, taken := batch[address]; taken {
+			if err := dbClient.StoreFingerprints(ctx, batch, version); err != nil {
+				return err
+			}
+			batch = map[uint32]models.Couple{}
+		}
+		batch[address] = models.Couple{AnchorTimeMs: binary.BigEndian.Uint32(packed[i+4:]), SongID: songID}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return dbClient.StoreFingerprints(ctx, batch, version)
+}
@@ -0,0 +1,110 @@
+package song
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"song-recognition/chromaprint"
+	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/utils"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// ChromaprintExport is the Chromaprint fingerprint of a stored song.
+type ChromaprintExport struct {
+	SongID      uint32  `json:"song_id"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	YouTubeID   string  `json:"youtube_id,omitempty"`
+	Duration    float64 `json:"duration"`    // of the whole song, in seconds
+	Fingerprint string  `json:"fingerprint"` // compressed, as printed by fpcalc
+}
+
+// ExportChromaprints writes the Chromaprint fingerprints of the songs whose
+// audio files are in dir to w, one JSON object per line. Like fpcalc, only
+// the first length seconds of every song are fingerprinted, or all of it
+// when length is zero. Files are matched to their songs like ReindexDir
+// does; files of unregistered songs are logged and skipped. It returns the
+// number of songs exported.
+func ExportChromaprints(ctx context.Context, dir string, length float64, w io.Writer) (int, error) {
+	logger := utils.GetLogger()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	encoder := json.NewEncoder(w)
+	exported := 0
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || decode.FormatFromExt(filepath.Ext(path)) == decode.FormatUnknown {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		export, err := exportChromaprint(ctx, dbClient, path, length)
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to export song", slog.String("path", path), slog.Any("error", xerrors.New(err)))
+			return nil
+		}
+
+		if err := encoder.Encode(export); err != nil {
+			return err
+		}
+		exported++
+		return nil
+	})
+	if err != nil {
+		return exported, fmt.Errorf("failed to export %s: %v", dir, err)
+	}
+
+	return exported, nil
+}
+
+// exportChromaprint fingerprints the song stored at filePath.
+func exportChromaprint(ctx context.Context, dbClient db.DBClient, filePath string, length float64) (ChromaprintExport, error) {
+	audio, err := decode.DecodeFile(ctx, filePath)
+	if err != nil {
+		return ChromaprintExport{}, fmt.Errorf("error decoding audio: %v", err)
+	}
+
+	song, exists, err := findStoredSong(ctx, dbClient, filePath, audio)
+	if err != nil {
+		return ChromaprintExport{}, err
+	}
+	if !exists {
+		return ChromaprintExport{}, fmt.Errorf("no registered song matches %s", filePath)
+	}
+
+	samples := audio.Samples
+	if limit := int(length * float64(audio.SampleRate)); length > 0 && limit < len(samples) {
+		samples = samples[:limit]
+	}
+
+	fingerprint, err := chromaprint.Fingerprint(samples, audio.SampleRate)
+	if err != nil {
+		return ChromaprintExport{}, fmt.Errorf("error computing fingerprint: %v", err)
+	}
+
+	return ChromaprintExport{
+		SongID:      song.ID,
+		Title:       song.Title,
+		Artist:      song.Artist,
+		YouTubeID:   song.YouTubeID,
+		Duration:    audio.Duration,
+		Fingerprint: chromaprint.Encode(fingerprint),
+	}, nil
+}
@@ -0,0 +1,158 @@
+package song
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ImportResult is the outcome of importing one row of a CSV catalog.
+type ImportResult struct {
+	Row           int
+	SongURL       string
+	Title         string
+	Artist        string
+	Status        string // "ok", "failed" or "invalid"
+	FingerprintID string
+	Error         string
+}
+
+const (
+	ImportStatusOK      = "ok"
+	ImportStatusFailed  = "failed"
+	ImportStatusInvalid = "invalid"
+)
+
+// csvColumns maps the accepted header names to SongInput fields.
+var csvColumns = map[string]string{
+	"url":            "song_url",
+	"song_url":       "song_url",
+	"title":          "title",
+	"artist":         "artist",
+	"youtube_id":     "youtube_id",
+	"musicbrainz_id": "musicbrainz_id",
+	"credentials":    "credentials",
+}
+
+// ImportCSV reads a catalog with url, title and artist columns (and optional
+// youtube_id, musicbrainz_id and credentials columns), validates every row
+// and processes the valid ones with a pool of workers. The first row must be
+// a header. One result is returned per data row, in file order.
+func ImportCSV(ctx context.Context, r io.Reader, workers int) ([]ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		if field, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[field] = i
+		}
+	}
+	for _, required := range []string{"song_url", "title", "artist"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var results []ImportResult
+	var inputs []SongInput
+	var inputRows []int // index into results for each input
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %v", row, err)
+		}
+
+		input := SongInput{
+			SongURL:       field(record, "song_url"),
+			Title:         field(record, "title"),
+			Artist:        field(record, "artist"),
+			YoutubeID:     field(record, "youtube_id"),
+			MusicBrainzID: field(record, "musicbrainz_id"),
+			Credentials:   field(record, "credentials"),
+		}
+
+		result := ImportResult{Row: row, SongURL: input.SongURL, Title: input.Title, Artist: input.Artist}
+		if err := validateImportRow(&input); err != nil {
+			result.Status = ImportStatusInvalid
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		results = append(results, result)
+		inputs = append(inputs, input)
+		inputRows = append(inputRows, len(results)-1)
+	}
+
+	for i, batchResult := range ProcessSongsBatch(ctx, inputs, workers) {
+		result := &results[inputRows[i]]
+		if batchResult.Error != "" {
+			result.Status = ImportStatusFailed
+			result.Error = batchResult.Error
+			continue
+		}
+		result.Status = ImportStatusOK
+		result.FingerprintID = batchResult.Response.FingerprintID
+	}
+
+	return results, nil
+}
+
+func validateImportRow(input *SongInput) error {
+	if err := validateInput(input); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(input.SongURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid song URL: %q", input.SongURL)
+	}
+
+	return nil
+}
+
+// WriteImportReport writes import results as CSV, one row per catalog entry.
+func WriteImportReport(w io.Writer, results []ImportResult) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"row", "url", "title", "artist", "status", "fingerprint_id", "error"})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		err := writer.Write([]string{
+			strconv.Itoa(result.Row), result.SongURL, result.Title, result.Artist,
+			result.Status, result.FingerprintID, result.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
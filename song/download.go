@@ -0,0 +1,531 @@
+package song
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/decode"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// partialDir keeps the downloads cut short, so a later attempt resumes them
+// instead of starting over.
+const partialDir = "tmp/partial"
+
+// partialMaxAge is how long a download cut short is kept for.
+const partialMaxAge = 24 * time.Hour
+
+// partialDownload is what a download was served as. A later attempt only
+// resumes a download cut short, and a cached download is only reused, if
+// the file at the URL is the same, as told by its ETag or Last-Modified
+// date.
+type partialDownload struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+// validator returns the If-Range value resuming the download, or "" if it
+// can't be resumed. Weak ETags can't be used with ranges.
+func (p partialDownload) validator() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// downloadLock is the lock of the downloads of one URL, counting the
+// downloads holding or waiting for it.
+type downloadLock struct {
+	sync.Mutex
+	holders int
+}
+
+var (
+	downloadLocksMu sync.Mutex
+	downloadLocks   = make(map[string]*downloadLock)
+)
+
+// lockDownload keeps two downloads of the same URL from writing to the same
+// partial file, returning the function that unlocks it. The lock of a URL
+// is dropped once no download holds or waits for it.
+func lockDownload(key string) func() {
+	downloadLocksMu.Lock()
+	lock, ok := downloadLocks[key]
+	if !ok {
+		lock = &downloadLock{}
+		downloadLocks[key] = lock
+	}
+	lock.holders++
+	downloadLocksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		downloadLocksMu.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(downloadLocks, key)
+		}
+		downloadLocksMu.Unlock()
+	}
+}
+
+// ErrTooLarge is returned for downloads larger than the max_bytes of the
+// download config.
+var ErrTooLarge = errors.New("download too large")
+
+// tooLarge returns the ErrTooLarge of a download over maxBytes.
+func tooLarge(maxBytes int64) error {
+	return fmt.Errorf("%w: the song is larger than the limit of %d bytes", ErrTooLarge, maxBytes)
+}
+
+var (
+	downloadClientOnce sync.Once
+	downloadHTTPClient *http.Client
+	downloadClientErr  error
+)
+
+// downloadClient returns the HTTP client audio is downloaded with, going
+// through the proxy and trusting the certificates of the download config.
+// It only connects to the hosts and addresses the config allows.
+func downloadClient() (*http.Client, error) {
+	downloadClientOnce.Do(func() {
+		cfg := config.Get().Download
+
+		tlsConfig := &tls.Config{
+			MinVersion:         config.TLSVersions[cfg.MinTLSVersion],
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+		}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				downloadClientErr = fmt.Errorf("failed to read download.ca_file: %v", err)
+				return
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				downloadClientErr = fmt.Errorf("download.ca_file %s holds no PEM certificates", cfg.CAFile)
+				return
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		dialer := newGuardedDialer()
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		transport.DialContext = dialer.DialContext
+		if cfg.ProxyURL != "" {
+			proxy, err := url.Parse(cfg.ProxyURL)
+			if err != nil {
+				downloadClientErr = fmt.Errorf("invalid download.proxy_url: %v", err)
+				return
+			}
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+		transport.Proxy = dialer.Proxy(transport.Proxy)
+
+		downloadHTTPClient = &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+	})
+	return downloadHTTPClient, downloadClientErr
+}
+
+// downloadAudio downloads the audio file at audioURL, sending header along,
+// and each of hostHeaders to its hosts, to a temporary file under tmp, returning its path and the Content-Type it
+// was served as. The caller removes the file. Transient failures are
+// retried with exponential backoff, as set in the download config. Every
+// attempt waits for a free download slot, is bounded by the download
+// timeout and resumes where the last one stopped when the server supports
+// ranges. A download that is canceled or still fails transiently is kept
+// for the next call to resume. With a download cache, files the server
+// reports unchanged since they were cached aren't downloaded again.
+func downloadAudio(ctx context.Context, audioURL string, header http.Header, hostHeaders []hostHeader) (string, string, error) {
+	logger := utils.GetLogger()
+	cfg := config.Get().Download
+	timeouts := config.Get().Timeouts
+
+	sum := sha256.Sum256([]byte(audioURL))
+	key := hex.EncodeToString(sum[:])
+	partPath := filepath.Join(partialDir, key+".part")
+	statePath := filepath.Join(partialDir, key+".json")
+
+	d, err := newDownloader(hostHeaders)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer lockDownload(key)()
+	header = cachedValidators(key, header)
+
+	if err := utils.CreateFolder(partialDir); err != nil {
+		return "", "", fmt.Errorf("failed to create partial downloads directory: %v", err)
+	}
+	removeStalePartials(ctx)
+
+	out, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer out.Close()
+
+	discard := func() {
+		out.Close()
+		os.Remove(partPath)
+		os.Remove(statePath)
+	}
+
+	var state partialDownload
+	if data, err := os.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	for attempt := 1; ; attempt++ {
+		release, err := acquireDownload(ctx)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to download song: %w", err)
+		}
+		attemptCtx, cancel := withTimeout(ctx, timeouts.DownloadSeconds)
+		err = fetchAudio(attemptCtx, d, audioURL, header, out, &state, statePath)
+		cancel()
+		release()
+		err = timedOut(ctx, attemptCtx, "download", timeouts.DownloadSeconds, err)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errNotModified) {
+			discard()
+			return restoreDownload(key)
+		}
+		if ctx.Err() != nil || (IsTransient(err) && attempt >= cfg.Attempts) {
+			if info, statErr := out.Stat(); statErr == nil && info.Size() == 0 {
+				discard()
+			}
+			return "", "", err
+		}
+		if !IsTransient(err) {
+			discard()
+			return "", "", err
+		}
+
+		delay := backoff(attempt, time.Duration(cfg.RetryDelayMs)*time.Millisecond, time.Duration(cfg.MaxRetryDelayMs)*time.Millisecond)
+		logger.WarnContext(ctx, "Download failed, retrying", slog.String("url", audioURL),
+			slog.Int("attempt", attempt), slog.Duration("delay", delay), slog.Any("error", xerrors.New(err)))
+
+		select {
+		case <-ctx.Done():
+			return "", "", fmt.Errorf("failed to download song: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	// Hand the finished download over under a name of its own, so the
+	// next download of the URL starts afresh
+	done, err := os.CreateTemp("tmp", "*.download")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	done.Close()
+	out.Close()
+	if err := os.Rename(partPath, done.Name()); err != nil {
+		os.Remove(done.Name())
+		return "", "", fmt.Errorf("failed to move downloaded file: %v", err)
+	}
+	os.Remove(statePath)
+	storeCached(ctx, key, done.Name(), state)
+
+	return done.Name(), state.ContentType, nil
+}
+
+// restoreDownload copies the cached download with key to a temporary file
+// under tmp, returning its path and the Content-Type it was served as.
+func restoreDownload(key string) (string, string, error) {
+	done, err := os.CreateTemp("tmp", "*.download")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	done.Close()
+	os.Remove(done.Name())
+
+	contentType, err := restoreCached(key, done.Name())
+	if err != nil {
+		return "", "", err
+	}
+	return done.Name(), contentType, nil
+}
+
+// fetchAudio makes one attempt with d at downloading the audio file at
+// audioURL into out. What out already holds is resumed with a Range request when
+// state tells the file apart; otherwise, or if the server ignores the range,
+// out is started over. state is updated, and saved to statePath, from the
+// response.
+func fetchAudio(ctx context.Context, d *downloader, audioURL string, header http.Header, out *os.File, state *partialDownload, statePath string) error {
+	info, err := out.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to inspect temporary file: %v", err)
+	}
+	offset := info.Size()
+
+	reportProgress(ctx, StageDownload, 0)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, audioURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid song URL: %v", err)
+	}
+	if err := checkHost(req.URL); err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	d.setHeaders(req)
+	if offset > 0 && state.validator() != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", state.validator())
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download song: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header.Get("Content-Range")) == offset:
+		// Resumed where the last attempt stopped
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && contentRangeTotal(resp.Header.Get("Content-Range")) == offset:
+		// The last attempt got the whole file
+		return nil
+	case resp.StatusCode == http.StatusNotModified:
+		return errNotModified
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+		*state = partialDownload{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+		}
+		if decode.IsNonAudioContentType(state.ContentType) {
+			return fmt.Errorf("song URL returned %q content, not audio", state.ContentType)
+		}
+		if data, err := json.Marshal(state); err == nil {
+			os.WriteFile(statePath, data, 0644)
+		}
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// A range other than the one asked for, so start over
+		resp.Body.Close()
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("failed to reset temporary file: %v", err)
+		}
+		*state = partialDownload{}
+		return fetchAudio(ctx, d, audioURL, header, out, state, statePath)
+	default:
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	if err := out.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to reset temporary file: %v", err)
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset temporary file: %v", err)
+	}
+
+	// Refuse files known to be too large before downloading them, and
+	// stop the others once they are
+	maxBytes := config.Get().Download.MaxBytes
+	if maxBytes > 0 && resp.ContentLength > 0 && offset+resp.ContentLength > maxBytes {
+		return tooLarge(maxBytes)
+	}
+	if err := reserveTmp(ctx, max(resp.ContentLength, 0)); err != nil {
+		return err
+	}
+	body := throttle(ctx, resp.Body)
+	if maxBytes > 0 {
+		body = io.LimitReader(body, maxBytes-offset+1)
+	}
+
+	progress := &progressWriter{ctx: ctx, written: offset}
+	if resp.ContentLength > 0 {
+		progress.total = offset + resp.ContentLength
+	}
+	written, err := io.Copy(io.MultiWriter(out, progress), body)
+	if err != nil {
+		return fmt.Errorf("failed to save downloaded file: %w", err)
+	}
+	if maxBytes > 0 && offset+written > maxBytes {
+		return tooLarge(maxBytes)
+	}
+	return nil
+}
+
+// contentRangeStart returns the first byte of a "bytes first-last/total"
+// Content-Range, or -1 if it has none.
+func contentRangeStart(contentRange string) int64 {
+	byteRange, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return -1
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// contentRangeTotal returns the size of the file of a Content-Range, or -1
+// if it isn't known.
+func contentRangeTotal(contentRange string) int64 {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// removeStalePartials removes the downloads cut short longer than
+// partialMaxAge ago, whose URLs may never be downloaded again.
+func removeStalePartials(ctx context.Context) {
+	entries, err := os.ReadDir(partialDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < partialMaxAge {
+			continue
+		}
+		path := filepath.Join(partialDir, entry.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			utils.GetLogger().WarnContext(ctx, "Failed to remove stale partial download", slog.String("path", path), slog.Any("error", xerrors.New(err)))
+		}
+	}
+}
+
+// hostHeader is a header of downloads only sent to some hosts and their
+// subdomains.
+type hostHeader struct {
+	hosts  []string
+	header http.Header
+}
+
+// downloadHeaders returns the headers of the downloads of input, each with
+// the hosts it is sent to: those of its credentials profile go to the
+// hosts of the profile, or that of its song URL if it names none, and its
+// own go to the host of its song URL, overriding them.
+func downloadHeaders(input *SongInput) ([]hostHeader, error) {
+	var songHost string
+	if u, err := url.Parse(input.SongURL); err == nil {
+		songHost = u.Hostname()
+	}
+
+	var headers []hostHeader
+	if input.Credentials != "" {
+		credential, ok := config.Get().Download.Credentials[input.Credentials]
+		if !ok {
+			return nil, fmt.Errorf("unknown credentials %q", input.Credentials)
+		}
+		header := http.Header{}
+		for name, value := range credential.Headers {
+			header.Set(name, os.ExpandEnv(value))
+		}
+		if credential.BearerToken != "" {
+			header.Set("Authorization", "Bearer "+os.ExpandEnv(credential.BearerToken))
+		}
+		if credential.Username != "" {
+			auth := os.ExpandEnv(credential.Username) + ":" + os.ExpandEnv(credential.Password)
+			header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+		}
+
+		hosts := credential.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{songHost}
+		}
+		headers = append(headers, hostHeader{hosts: hosts, header: header})
+	}
+
+	if len(input.Headers) > 0 {
+		header := http.Header{}
+		for name, value := range input.Headers {
+			header.Set(name, value)
+		}
+		headers = append(headers, hostHeader{hosts: []string{songHost}, header: header})
+	}
+	return headers, nil
+}
+
+// downloader downloads the audio of one song, sending each of its headers
+// only to the hosts the header is meant for, redirects included.
+type downloader struct {
+	client  *http.Client
+	headers []hostHeader
+}
+
+// newDownloader returns a downloader sending headers, using a copy of the
+// download client whose redirects carry them.
+func newDownloader(headers []hostHeader) (*downloader, error) {
+	shared, err := downloadClient()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &downloader{headers: headers}
+	client := *shared
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := checkRedirect(req, via); err != nil {
+			return err
+		}
+		d.setHeaders(req)
+		return nil
+	}
+	d.client = &client
+	return d, nil
+}
+
+// setHeaders sets the headers of d that are sent to the host of req, and
+// removes the others, so a redirect to another host doesn't take them
+// along.
+func (d *downloader) setHeaders(req *http.Request) {
+	for _, h := range d.headers {
+		for name := range h.header {
+			req.Header.Del(name)
+		}
+	}
+	for _, h := range d.headers {
+		if !matchesHost(req.URL.Hostname(), h.hosts) {
+			continue
+		}
+		for name, values := range h.header {
+			req.Header[name] = values
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package song
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLockDownloadReleasesLocks(t *testing.T) {
+	var wg sync.WaitGroup
+	running := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockDownload("key")
+			running++
+			if running != 1 {
+				t.Errorf("%d downloads of the same key at once", running)
+			}
+			running--
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	downloadLocksMu.Lock()
+	defer downloadLocksMu.Unlock()
+	if len(downloadLocks) != 0 {
+		t.Errorf("%d download locks left after every download finished", len(downloadLocks))
+	}
+}
@@ -0,0 +1,72 @@
+package song
+
+import (
+	"sync"
+)
+
+// JobEvent is a progress update or status change of an asynchronous job.
+type JobEvent struct {
+	JobID   string  `json:"job_id"`
+	Status  string  `json:"status"`
+	Stage   Stage   `json:"stage,omitempty"`
+	Percent float64 `json:"percent"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// jobEventBuffer is how many events a slow subscriber may fall behind
+// before further progress events are dropped for it.
+const jobEventBuffer = 32
+
+// jobEventBroker fans out the events of running jobs to subscribers in
+// this process.
+type jobEventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan JobEvent]struct{}
+}
+
+var jobEvents = &jobEventBroker{subscribers: make(map[string]map[chan JobEvent]struct{})}
+
+// SubscribeJob returns a channel receiving the events of jobID as the job
+// worker in this process runs it. Call the returned function to
+// unsubscribe; it closes the channel.
+func SubscribeJob(jobID string) (<-chan JobEvent, func()) {
+	return jobEvents.subscribe(jobID)
+}
+
+func (b *jobEventBroker) subscribe(jobID string) (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, jobEventBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[jobID] == nil {
+		b.subscribers[jobID] = make(map[chan JobEvent]struct{})
+	}
+	b.subscribers[jobID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[jobID], ch)
+			if len(b.subscribers[jobID]) == 0 {
+				delete(b.subscribers, jobID)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish delivers event without blocking the pipeline: subscribers whose
+// buffer is full miss it.
+func (b *jobEventBroker) publish(event JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
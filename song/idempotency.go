@@ -0,0 +1,96 @@
+package song
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"time"
+)
+
+// ErrRequestInProgress is returned when a request is retried with an
+// idempotency key whose original request hasn't finished yet.
+var ErrRequestInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// idempotencyPendingTimeout is how long a pending key is honored before the
+// request is assumed to have died with its process and may be claimed again.
+const idempotencyPendingTimeout = time.Hour
+
+// ProcessIdempotent runs process at most once per idempotency key. When key
+// was already used successfully the stored response of the first request is
+// returned instead. A failed request releases its key so it can be retried.
+// An empty key runs process unconditionally.
+func ProcessIdempotent(ctx context.Context, key string, process func() (*ProcessResponse, error)) (*ProcessResponse, error) {
+	if key == "" {
+		return process()
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return processIdempotent(ctx, dbClient, key, process)
+}
+
+// processIdempotent is ProcessIdempotent keeping the keys in dbClient.
+func processIdempotent(ctx context.Context, dbClient db.DBClient, key string, process func() (*ProcessResponse, error)) (*ProcessResponse, error) {
+	if key == "" {
+		return process()
+	}
+
+	logger := utils.GetLogger()
+
+	record, claimed, err := dbClient.ClaimIdempotencyKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claimed {
+		switch {
+		case record.Status == db.IdempotencyDone:
+			var response ProcessResponse
+			if err := json.Unmarshal(record.Response, &response); err != nil {
+				return nil, fmt.Errorf("failed to decode stored response: %v", err)
+			}
+			return &response, nil
+		case time.Since(record.CreatedAt) < idempotencyPendingTimeout:
+			return nil, ErrRequestInProgress
+		}
+
+		// The original request was abandoned; take the key over
+		if err := dbClient.ReleaseIdempotencyKey(ctx, key); err != nil {
+			return nil, err
+		}
+		if _, claimed, err = dbClient.ClaimIdempotencyKey(ctx, key); err != nil {
+			return nil, err
+		}
+		if !claimed {
+			return nil, ErrRequestInProgress
+		}
+	}
+
+	response, err := process()
+	if err != nil {
+		// Use a fresh context: ctx may be the reason the request failed
+		if releaseErr := dbClient.ReleaseIdempotencyKey(context.Background(), key); releaseErr != nil {
+			logger.ErrorContext(ctx, "Error releasing idempotency key", slog.Any("error", releaseErr))
+		}
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %v", err)
+	}
+
+	if err := dbClient.CompleteIdempotencyKey(context.Background(), key, encoded); err != nil {
+		logger.ErrorContext(ctx, "Error storing idempotent response", slog.Any("error", err))
+	}
+
+	return response, nil
+}
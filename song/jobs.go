@@ -0,0 +1,326 @@
+package song
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"time"
+)
+
+// JobPollInterval is how often an idle job worker checks the database for
+// jobs enqueued by other processes.
+var JobPollInterval = 2 * time.Second
+
+// JobStatus is the client-facing state of an asynchronous processing job.
+type JobStatus struct {
+	ID       string           `json:"id"`
+	Status   string           `json:"status"`
+	Response *ProcessResponse `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Attempts int              `json:"attempts"`
+	// NextAttemptAt is set while a failed job waits to be retried.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// jobWake nudges a worker in this process when a job is enqueued.
+var jobWake = make(chan struct{}, 1)
+
+// wakeJobWorker tells an idle worker that there may be work to claim.
+func wakeJobWorker() {
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// EnqueueSong validates input and persists it as a queued job, returning
+// the job's ID. The song is processed later by RunJobWorker.
+func EnqueueSong(ctx context.Context, input *SongInput) (string, error) {
+	if err := validateInput(input); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job: %v", err)
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return "", fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	now := time.Now()
+	err = dbClient.EnqueueJob(ctx, db.Job{
+		ID:        jobID,
+		Status:    db.JobQueued,
+		Payload:   payload,
+		NextRunAt: now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	wakeJobWorker()
+
+	return jobID, nil
+}
+
+// GetJobStatus looks up a job enqueued with EnqueueSong.
+func GetJobStatus(ctx context.Context, jobID string) (*JobStatus, bool, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	job, exists, err := dbClient.GetJob(ctx, jobID)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	status, err := jobStatus(job)
+	return status, err == nil, err
+}
+
+// jobStatus converts a stored job to its client-facing form.
+func jobStatus(job db.Job) (*JobStatus, error) {
+	status := &JobStatus{
+		ID:        job.ID,
+		Status:    job.Status,
+		Error:     job.Error,
+		Attempts:  job.Attempts,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+
+	if job.Status == db.JobQueued && job.Attempts > 0 && job.NextRunAt.After(time.Now()) {
+		nextAttempt := job.NextRunAt
+		status.NextAttemptAt = &nextAttempt
+	}
+
+	if len(job.Result) > 0 {
+		var response ProcessResponse
+		if err := json.Unmarshal(job.Result, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode job result: %v", err)
+		}
+		status.Response = &response
+	}
+
+	return status, nil
+}
+
+// RunJobWorker drains the job queue on pool until ctx is canceled, running
+// up to pool.Workers() jobs at once. Jobs left processing by a previous run
+// are requeued first, so queued work survives restarts.
+func RunJobWorker(ctx context.Context, pool *Pool) error {
+	logger := utils.GetLogger()
+
+	dbClient, ok := pool.db.(db.DBClient)
+	if !ok {
+		return errors.New("the job queue needs a pool whose store is a db.DBClient")
+	}
+
+	requeued, err := dbClient.RequeueProcessingJobs(ctx)
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		logger.InfoContext(ctx, "Requeued interrupted jobs", slog.Int("count", requeued))
+	}
+
+	slots := make(chan struct{}, pool.Workers())
+	ticker := time.NewTicker(JobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		job, claimed, err := dbClient.ClaimNextJob(ctx)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error claiming job", slog.Any("error", err))
+		}
+		if !claimed {
+			<-slots
+
+			select {
+			case <-jobWake:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		go func() {
+			defer func() {
+				<-slots
+			}()
+			runJob(ctx, pool, dbClient, job)
+		}()
+	}
+}
+
+// runJob processes a claimed job and records its outcome. A job cut short
+// by ctx being canceled goes back in the queue for the next run. Transient
+// failures are retried with exponential backoff; other failures, and jobs
+// out of attempts, are dead-lettered with the reason in Error. The job's
+// callback URL, if any, is notified of the final outcome only. The outcome
+// is recorded in dbClient.
+func runJob(ctx context.Context, pool *Pool, dbClient db.DBClient, job db.Job) {
+	logger := utils.GetLogger()
+
+	jobEvents.publish(JobEvent{JobID: job.ID, Status: db.JobProcessing})
+	progressCtx := WithProgressReporter(ctx, ProgressFunc(func(stage Stage, percent float64) {
+		jobEvents.publish(JobEvent{JobID: job.ID, Status: db.JobProcessing, Stage: stage, Percent: percent})
+	}))
+
+	var input SongInput
+	var response *ProcessResponse
+	err := json.Unmarshal(job.Payload, &input)
+	if err != nil {
+		err = fmt.Errorf("failed to decode job: %v", err)
+	} else {
+		response, err = pool.process(progressCtx, &input)
+	}
+
+	job.UpdatedAt = time.Now()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		job.Status = db.JobQueued
+	case err != nil:
+		job.Attempts++
+		job.Error = err.Error()
+		if IsTransient(err) && job.Attempts < JobMaxAttempts {
+			job.Status = db.JobQueued
+			job.NextRunAt = job.UpdatedAt.Add(retryDelay(job.Attempts))
+			logger.WarnContext(ctx, "Job failed, retrying", slog.String("job", job.ID),
+				slog.Int("attempt", job.Attempts), slog.Time("next_run_at", job.NextRunAt), slog.Any("error", err))
+		} else {
+			job.Status = db.JobFailed
+			logger.ErrorContext(ctx, "Job dead-lettered", slog.String("job", job.ID),
+				slog.Int("attempts", job.Attempts), slog.Any("error", err))
+		}
+	default:
+		job.Attempts++
+		job.Status = db.JobDone
+		job.Error = ""
+		job.Result, err = json.Marshal(response)
+		if err != nil {
+			job.Status = db.JobFailed
+			job.Error = fmt.Sprintf("failed to encode job result: %v", err)
+		}
+	}
+
+	// Record the outcome even when the worker is shutting down
+	if err := dbClient.UpdateJob(context.WithoutCancel(ctx), job); err != nil {
+		logger.ErrorContext(ctx, "Error updating job", slog.String("job", job.ID), slog.Any("error", err))
+	}
+
+	final := JobEvent{JobID: job.ID, Status: job.Status, Error: job.Error}
+	if job.Status == db.JobDone {
+		final.Stage, final.Percent = StageDone, 100
+	}
+	jobEvents.publish(final)
+
+	if job.Status == db.JobDone || job.Status == db.JobFailed {
+		notifyCallback(ctx, &input, job.ID, response, err)
+	}
+}
+
+// newJobID returns a random, URL-safe job identifier.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeadLetterLimit caps the number of jobs returned by ListDeadLetters.
+const DeadLetterLimit = 1000
+
+// ListDeadLetters returns the jobs that failed for good, oldest first.
+func ListDeadLetters(ctx context.Context) ([]JobStatus, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	jobs, err := dbClient.ListJobs(ctx, db.JobFailed, DeadLetterLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		status, err := jobStatus(job)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+
+	return statuses, nil
+}
+
+// RequeueDeadLetter puts a dead-lettered job back in the queue with a fresh
+// set of attempts. It reports false if no such dead-lettered job exists.
+func RequeueDeadLetter(ctx context.Context, jobID string) (bool, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return false, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	job, exists, err := dbClient.GetJob(ctx, jobID)
+	if err != nil || !exists || job.Status != db.JobFailed {
+		return false, err
+	}
+
+	now := time.Now()
+	job.Status = db.JobQueued
+	job.Attempts = 0
+	job.NextRunAt = now
+	job.UpdatedAt = now
+	if err := dbClient.UpdateJob(ctx, job); err != nil {
+		return false, err
+	}
+
+	wakeJobWorker()
+
+	return true, nil
+}
+
+// PurgeDeadLetters deletes every dead-lettered job and returns how many
+// were removed.
+func PurgeDeadLetters(ctx context.Context) (int, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return dbClient.DeleteJobs(ctx, db.JobFailed)
+}
@@ -0,0 +1,113 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/wav"
+	"strings"
+)
+
+// ProcessSongFromFile fingerprints and registers an audio file that is
+// already on the server's disk in the database DB_TYPE selects. The source
+// file is left in place. A title or artist input lacks is read from the
+// file's tags.
+func ProcessSongFromFile(ctx context.Context, filePath string, input *SongInput) (*ProcessResponse, error) {
+	return withDBClient(func(dbClient db.DBClient) (*ProcessResponse, error) {
+		return processSongFile(ctx, dbClient, filePath, input)
+	})
+}
+
+// processSongFile is ProcessSongFromFile registering the song in dbClient.
+func processSongFile(ctx context.Context, dbClient db.Store, filePath string, input *SongInput) (*ProcessResponse, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, fmt.Errorf("failed to stat song file: %v", err)
+	}
+
+	if err := createWorkDirs(); err != nil {
+		return nil, err
+	}
+
+	timeout := config.Get().Timeouts.TotalSeconds
+	processCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := processAudioFile(processCtx, dbClient, filePath, input)
+	return response, timedOut(ctx, processCtx, "processing the song", timeout, err)
+}
+
+// ProcessSongsFromDir walks dir and fingerprints every audio file in it with
+// at most concurrency files in flight, sharing one database client. Title
+// and artist are read from the file's tags, with FFprobe when it is
+// available, otherwise from a "Title - Artist.ext" file name.
+func ProcessSongsFromDir(ctx context.Context, dir string, concurrency int) ([]BatchResult, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && decode.FormatFromExt(filepath.Ext(path)) != decode.FormatUnknown {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %v", dir, err)
+	}
+
+	pool, err := NewPool(concurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	return runBatch(ctx, len(files), pool.Workers(), func(i int, result *BatchResult) (*ProcessResponse, error) {
+		input := MetadataFromFile(files[i])
+		result.Title, result.Artist = input.Title, input.Artist
+
+		if input.Artist == "" {
+			return nil, fmt.Errorf("couldn't determine the artist of %s", files[i])
+		}
+		return pool.ProcessFile(ctx, files[i], &input)
+	}), nil
+}
+
+// MetadataFromFile guesses the title and artist of a local audio file.
+func MetadataFromFile(filePath string) SongInput {
+	var input SongInput
+
+	if metadata, err := wav.GetMetadata(filePath); err == nil {
+		input.Title = metadata.Format.Tags["title"]
+		input.Artist = metadata.Format.Tags["artist"]
+	} else if tags, err := decode.ReadFileTags(filePath); err == nil {
+		input.Title, input.Artist = tags.Title, tags.Artist
+	}
+	if info, err := wav.ReadInfo(filePath); err == nil {
+		if input.Title == "" {
+			input.Title = info.Title
+		}
+		if input.Artist == "" {
+			input.Artist = info.Artist
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	title, artist, found := strings.Cut(name, " - ")
+	if !found {
+		title = name
+	}
+
+	if input.Title == "" {
+		input.Title = strings.TrimSpace(title)
+	}
+	if input.Artist == "" {
+		input.Artist = strings.TrimSpace(artist)
+	}
+
+	return input
+}
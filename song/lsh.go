@@ -0,0 +1,46 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/db"
+	"song-recognition/shazam"
+)
+
+// lshPageSize is the number of songs read from the database at a time
+// while indexing.
+const lshPageSize = 500
+
+// BuildLSHIndex adds every stored song to the LSH index, replacing the
+// bands songs already had, such as songs saved before the index was
+// enabled or with other fingerprint settings. It returns the number of
+// songs indexed.
+func BuildLSHIndex(ctx context.Context) (int, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	indexed := 0
+	for offset := 0; ; offset += lshPageSize {
+		songs, err := dbClient.ListSongs(ctx, offset, lshPageSize)
+		if err != nil {
+			return indexed, fmt.Errorf("error listing songs: %v", err)
+		}
+
+		for _, song := range songs {
+			if err := ctx.Err(); err != nil {
+				return indexed, err
+			}
+			if err := shazam.IndexLSH(ctx, dbClient, song.ID); err != nil {
+				return indexed, fmt.Errorf("error indexing song %d: %v", song.ID, err)
+			}
+			indexed++
+		}
+
+		if len(songs) < lshPageSize {
+			return indexed, nil
+		}
+	}
+}
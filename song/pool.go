@@ -0,0 +1,119 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"song-recognition/db"
+)
+
+// Pool processes songs with bounded parallelism over a single shared
+// database client, so bulk ingestion doesn't pay for a new connection per
+// song. A Pool is safe for concurrent use; the worker limit applies across
+// all callers.
+type Pool struct {
+	db    db.Store
+	close func() error
+	slots chan struct{}
+}
+
+// NewPool connects to the configured database and returns a pool that runs
+// at most workers songs at once. Values below 1 mean DefaultBatchConcurrency.
+func NewPool(workers int) (*Pool, error) {
+	if workers < 1 {
+		workers = DefaultBatchConcurrency
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+
+	return &Pool{db: dbClient, close: dbClient.Close, slots: make(chan struct{}, workers)}, nil
+}
+
+// NewStorePool returns a pool that registers songs in store, running at
+// most workers songs at once, so programs embedding the pipeline can keep
+// songs in a database of their own. The caller keeps ownership of store
+// and closes it after the pool. Songs are only given their details, and
+// jobs only run, if store is a whole db.DBClient.
+func NewStorePool(store db.Store, workers int) *Pool {
+	if workers < 1 {
+		workers = DefaultBatchConcurrency
+	}
+
+	return &Pool{db: store, close: func() error { return nil }, slots: make(chan struct{}, workers)}
+}
+
+// Workers returns the maximum number of songs the pool processes at once.
+func (p *Pool) Workers() int {
+	return cap(p.slots)
+}
+
+// Close closes the database client NewPool connected. Songs still being
+// processed will fail.
+func (p *Pool) Close() error {
+	return p.close()
+}
+
+// Process validates and processes one song, waiting for a free worker first.
+func (p *Pool) Process(ctx context.Context, input *SongInput) (*ProcessResponse, error) {
+	response, err := p.process(ctx, input)
+	notifyCallback(ctx, input, "", response, err)
+	return response, err
+}
+
+// process is Process without the callback notification, for callers that
+// decide themselves when an outcome is final.
+func (p *Pool) process(ctx context.Context, input *SongInput) (*ProcessResponse, error) {
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+
+	return p.run(ctx, func(ctx context.Context) (*ProcessResponse, error) {
+		return processSong(ctx, p.db, input)
+	})
+}
+
+// ProcessFile processes a local audio file, waiting for a free worker first.
+func (p *Pool) ProcessFile(ctx context.Context, filePath string, input *SongInput) (*ProcessResponse, error) {
+	return p.run(ctx, func(ctx context.Context) (*ProcessResponse, error) {
+		return processSongFile(ctx, p.db, filePath, input)
+	})
+}
+
+// ProcessBatch processes inputs on the pool and returns one result per
+// input, in input order.
+func (p *Pool) ProcessBatch(ctx context.Context, inputs []SongInput) []BatchResult {
+	return runBatch(ctx, len(inputs), p.Workers(), func(i int, result *BatchResult) (*ProcessResponse, error) {
+		input := inputs[i]
+		result.Title, result.Artist = input.Title, input.Artist
+
+		return p.Process(ctx, &input)
+	})
+}
+
+// run calls process once a worker slot is free.
+func (p *Pool) run(ctx context.Context, process func(ctx context.Context) (*ProcessResponse, error)) (*ProcessResponse, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-p.slots
+	}()
+
+	return process(ctx)
+}
+
+// withDBClient connects a client of the database DB_TYPE selects, calls
+// process with it and closes it again.
+func withDBClient(process func(dbClient db.DBClient) (*ProcessResponse, error)) (*ProcessResponse, error) {
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return process(dbClient)
+}
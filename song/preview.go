@@ -0,0 +1,146 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"song-recognition/config"
+	"song-recognition/decode"
+	"song-recognition/storage"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// previewFormat is how FFmpeg encodes preview clips of a format.
+type previewFormat struct {
+	encoder   string
+	extension string
+}
+
+// previewFormats maps the formats of config.Preview to their encoding.
+var previewFormats = map[string]previewFormat{
+	config.PreviewMP3:  {encoder: "libmp3lame", extension: ".mp3"},
+	config.PreviewOpus: {encoder: "libopus", extension: ".ogg"},
+	config.PreviewAAC:  {encoder: "aac", extension: ".m4a"},
+}
+
+// contentTypes maps the extensions of stored song files to their content
+// type.
+var contentTypes = map[string]string{
+	".wav": "audio/wav",
+	".mp3": "audio/mpeg",
+	".ogg": "audio/ogg",
+	".m4a": "audio/mp4",
+}
+
+// ContentType returns the content type of the stored song file of key.
+func ContentType(key string) string {
+	if contentType, ok := contentTypes[path.Ext(key)]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// previewKey returns the key the preview clip of the song with checksum is
+// stored under, next to its WAV file.
+func previewKey(checksum, extension string) string {
+	return strings.TrimSuffix(songFileKey(checksum), ".wav") + ".preview" + extension
+}
+
+// previewSpan returns where the preview clip of a song of duration seconds
+// starts and how long it lasts, moving it earlier when the song ends before
+// the configured clip would.
+func previewSpan(cfg config.Preview, duration float64) (offset, length float64) {
+	offset, length = cfg.OffsetSeconds, cfg.LengthSeconds
+	if duration > 0 && offset+length > duration {
+		offset = max(0, duration-length)
+		length = min(length, duration)
+	}
+	return offset, length
+}
+
+// storePreview cuts the preview clip of the song with checksum out of its
+// WAV file at wavPath, encodes it and stores it, returning its key. It
+// returns "" when previews are disabled or FFmpeg isn't installed. Failures
+// are logged and return "" too, so a song is saved without a preview rather
+// than not at all.
+func storePreview(ctx context.Context, store storage.Storage, wavPath, checksum string, duration float64, undo *compensation) string {
+	logger := utils.GetLogger()
+
+	cfg := config.Get().Preview
+	if !cfg.Enabled {
+		return ""
+	}
+	if !decode.FFmpegAvailable() {
+		logger.WarnContext(ctx, "Skipping preview clip, ffmpeg isn't installed")
+		return ""
+	}
+
+	key, err := encodePreview(ctx, store, cfg, wavPath, checksum, duration)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error storing preview clip", slog.Any("error", xerrors.New(err)))
+		return ""
+	}
+	undo.add("preview clip", func(ctx context.Context) error {
+		return store.Delete(ctx, key)
+	})
+	return key
+}
+
+func encodePreview(ctx context.Context, store storage.Storage, cfg config.Preview, wavPath, checksum string, duration float64) (string, error) {
+	format := previewFormats[cfg.Format]
+	offset, length := previewSpan(cfg, duration)
+
+	if err := reserveTmp(ctx, int64(length*float64(cfg.BitrateKbps)*1000/8)); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp("tmp", "preview-*"+format.extension)
+	if err != nil {
+		return "", fmt.Errorf("error creating preview file: %v", err)
+	}
+	tmpPath := file.Name()
+	file.Close()
+	defer os.Remove(tmpPath)
+
+	timeout := config.Get().Timeouts.ConvertSeconds
+	convertCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(convertCtx,
+		"ffmpeg",
+		"-y",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+		"-i", wavPath,
+		"-vn",
+		"-c:a", format.encoder,
+		"-b:a", strconv.Itoa(cfg.BitrateKbps)+"k",
+		tmpPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		err = timedOut(ctx, convertCtx, "encoding preview clip", timeout, err)
+		return "", fmt.Errorf("error encoding preview clip: %v, output %v", err, string(output))
+	}
+
+	file, err = os.Open(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading preview file: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("error reading preview file: %v", err)
+	}
+
+	key := previewKey(checksum, format.extension)
+	if err := store.Put(ctx, key, file, info.Size()); err != nil {
+		return "", fmt.Errorf("error storing preview clip at %s: %v", store.Location(key), err)
+	}
+	return key, nil
+}
@@ -1,172 +1,799 @@
 package song
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"song-recognition/artwork"
+	"song-recognition/classify"
+	"song-recognition/config"
 	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/itunes"
+	"song-recognition/models"
+	"song-recognition/musicbrainz"
+	"song-recognition/quota"
 	"song-recognition/shazam"
+	"song-recognition/soundcloud"
+	"song-recognition/storage"
 	"song-recognition/utils"
 	"song-recognition/wav"
+	"song-recognition/webhook"
 	"strconv"
 	"strings"
+
+	"github.com/mdobak/go-xerrors"
 )
 
 type SongInput struct {
-	SongURL   string `json:"song_url"`
-	Title     string `json:"title"`
-	Artist    string `json:"artist"`
+	SongURL string `json:"song_url"`
+	// Title and Artist default to the ID3 tags of the audio, if it has
+	// them.
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	// YoutubeID is the video the song was taken from. Without a SongURL
+	// or AudioData, the audio is downloaded from it.
 	YoutubeID string `json:"youtube_id,omitempty"`
-	Duration  string `json:"duration,omitempty"`
+	// Duration is ignored. The duration stored with a song is measured
+	// from its audio.
+	Duration string `json:"duration,omitempty"`
+	// AudioData holds a base64 encoded audio file sent inline instead of
+	// a SongURL. A "data:audio/...;base64," prefix is accepted.
+	AudioData string `json:"audio_data,omitempty"`
+	// IdempotencyKey makes retries of the same request return the
+	// original response instead of processing the song again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// CallbackURL receives a signed POST with the outcome once processing
+	// finishes or fails.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// MusicBrainzID is the MusicBrainz recording ID of the song, if known,
+	// so its metadata is looked up by it instead of by title and artist.
+	MusicBrainzID string `json:"musicbrainz_id,omitempty"`
+	// Headers are sent with the download of SongURL, such as a Cookie or
+	// Authorization header, to its host only. Queued jobs keep them in the
+	// database, so secrets are better kept in a profile of the config file.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Credentials names the profile of the download config that
+	// authenticates the download of SongURL, sent to the hosts of the
+	// profile, or that of SongURL if it names none.
+	Credentials string `json:"credentials,omitempty"`
+	// MirrorURLs are other sources of the song's audio, tried in order
+	// when SongURL, or the mirror before, can't be downloaded. The headers
+	// and credentials are only sent to those on their hosts.
+	MirrorURLs []string `json:"mirror_urls,omitempty"`
 }
 
+// MaxInlineAudioSize is the largest decoded AudioData payload accepted.
+const MaxInlineAudioSize = 10 << 20
+
 type ProcessResponse struct {
 	Success       bool   `json:"success"`
 	Message       string `json:"message"`
 	FilePath      string `json:"file_path,omitempty"`
 	FingerprintID string `json:"fingerprint_id,omitempty"`
+	// AlreadyRegistered is set when the audio matched the checksum of a
+	// registered song and was not fingerprinted again.
+	AlreadyRegistered bool `json:"already_registered,omitempty"`
+	// StoreURL is the song's page in the iTunes store, when store lookups
+	// are enabled and found it.
+	StoreURL string `json:"store_url,omitempty"`
 }
 
-func convertToWav(inputPath string) (string, error) {
-	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".wav"
-	cmd := exec.Command("ffmpeg", "-i", inputPath, "-acodec", "pcm_s16le", "-ar", "44100", "-ac", "2", outputPath)
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to convert to WAV: %v", err)
-	}
-	return outputPath, nil
+// StatusError reports a song URL that answered with a non-200 status.
+type StatusError struct {
+	StatusCode int
 }
 
-func ProcessSongFromURL(input *SongInput) (*ProcessResponse, error) {
-	logger := utils.GetLogger()
-	ctx := context.Background()
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received non-200 status code: %d", e.StatusCode)
+}
+
+// DownloadError reports a song URL whose audio couldn't be downloaded, as
+// opposed to audio that failed to be processed once it was.
+type DownloadError struct {
+	URL string
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
 
-	// Create necessary directories
+// reserveTmp makes room for size more bytes in the temporary directory,
+// as its quota allows.
+func reserveTmp(ctx context.Context, size int64) error {
+	return quota.MakeRoom(ctx, quota.TmpDir, config.Get().Quotas.Tmp, size)
+}
+
+// createWorkDirs creates the temporary directory.
+func createWorkDirs() error {
 	err := utils.CreateFolder("tmp")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create tmp directory: %v", err)
+		return fmt.Errorf("failed to create tmp directory: %v", err)
 	}
 
-	err = utils.CreateFolder("songs")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create songs directory: %v", err)
-	}
+	return nil
+}
 
-	// Download the file
-	resp, err := http.Get(input.SongURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download song: %v", err)
+// ProcessSongFromURL downloads, fingerprints and registers a song in the
+// database DB_TYPE selects. The download, decoding and database work all
+// honor ctx cancellation. URLs of SoundCloud tracks are resolved with the
+// SoundCloud API, and URLs of YouTube and the other sites of the yt_dlp
+// config are pages, whose audio is downloaded with yt-dlp. The headers and
+// credentials of input are only sent with the downloads of other song URLs,
+// and only to their hosts, see SongInput. Song URLs of hosts or private
+// networks that the download config doesn't allow fail with
+// ErrURLNotAllowed. When the audio of the song URL can't be downloaded, the
+// MirrorURLs of input are tried in turn.
+func ProcessSongFromURL(ctx context.Context, input *SongInput) (*ProcessResponse, error) {
+	return withDBClient(func(dbClient db.DBClient) (*ProcessResponse, error) {
+		return processSongFromURL(ctx, dbClient, input)
+	})
+}
+
+// processSongFromURL is ProcessSongFromURL registering the song in
+// dbClient.
+func processSongFromURL(ctx context.Context, dbClient db.Store, input *SongInput) (*ProcessResponse, error) {
+	logger := utils.GetLogger()
+	songURLs := append([]string{input.SongURL}, input.MirrorURLs...)
+
+	var err error
+	for i, songURL := range songURLs {
+		var response *ProcessResponse
+		response, err = processSongURL(ctx, dbClient, songURL, input)
+
+		var downloadErr *DownloadError
+		if err == nil || !errors.As(err, &downloadErr) || ctx.Err() != nil {
+			return response, err
+		}
+		if i+1 < len(songURLs) {
+			logger.WarnContext(ctx, "Song URL failed, trying the next mirror", slog.String("url", songURL),
+				slog.Any("error", xerrors.New(err)))
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	if len(songURLs) > 1 {
+		return nil, fmt.Errorf("all %d song URLs failed, the last with: %w", len(songURLs), err)
 	}
+	return nil, err
+}
 
-	// Create a temporary file with the downloaded content (MP3)
-	tmpMP3File := filepath.Join("tmp", fmt.Sprintf("%s_%s.mp3", input.Title, input.Artist))
-	out, err := os.Create(tmpMP3File)
+// processSongURL downloads, fingerprints and registers the song at songURL
+// as input in dbClient.
+func processSongURL(ctx context.Context, dbClient db.Store, songURL string, input *SongInput) (*ProcessResponse, error) {
+	if err := checkSongURL(ctx, songURL); err != nil {
+		return nil, &DownloadError{URL: songURL, Err: err}
+	}
+	if soundcloud.IsTrackURL(songURL) {
+		return processSoundCloud(ctx, dbClient, songURL, input)
+	}
+	if IsSiteURL(songURL) {
+		return processSiteURL(ctx, dbClient, songURL, input)
+	}
+	headers, err := downloadHeaders(input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+		return nil, err
 	}
-	defer out.Close()
+	return processDownload(ctx, dbClient, songURL, headers, input)
+}
 
-	_, err = io.Copy(out, resp.Body)
+// processDownload downloads the audio file at audioURL, sending each of
+// headers to its hosts, then fingerprints and registers it as input in
+// dbClient.
+func processDownload(ctx context.Context, dbClient db.Store, audioURL string, headers []hostHeader, input *SongInput) (*ProcessResponse, error) {
+	err := createWorkDirs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to save downloaded file: %v", err)
+		return nil, err
 	}
 
-	// Convert MP3 to WAV
-	tmpWavFile, err := convertToWav(tmpMP3File)
+	// Download to a temporary file first; its real format is only known
+	// once the content has been sniffed.
+	// The title and artist may only be known from the file's tags.
+	tmpDownload, contentType, err := downloadAudio(ctx, audioURL, nil, headers)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error converting to WAV", slog.Any("error", err))
-		return nil, fmt.Errorf("error converting to WAV: %v", err)
+		return nil, &DownloadError{URL: audioURL, Err: err}
 	}
-	defer os.Remove(tmpMP3File) // Clean up the MP3 file
+	defer os.Remove(tmpDownload)
 
-	// Process the WAV file
-	wavInfo, err := wav.ReadWavInfo(tmpWavFile)
+	format, err := decode.SniffFile(tmpDownload)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error reading wave info", slog.Any("error", err))
-		return nil, fmt.Errorf("error reading wave info: %v", err)
+		return nil, fmt.Errorf("failed to inspect downloaded file: %v", err)
+	}
+	if format == decode.FormatUnknown {
+		format = decode.FormatFromContentType(contentType)
+	}
+	if format == decode.FormatUnknown {
+		return nil, fmt.Errorf("%w: downloaded content (Content-Type %q) is not WAV, MP3, FLAC, OGG, AAC or a video with audio",
+			decode.ErrUnknownFormat, contentType)
 	}
 
-	samples, err := wav.WavBytesToSamples(wavInfo.Data)
+	tmpAudioFile := strings.TrimSuffix(tmpDownload, filepath.Ext(tmpDownload)) + format.Ext()
+	err = os.Rename(tmpDownload, tmpAudioFile)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error converting to samples", slog.Any("error", err))
-		return nil, fmt.Errorf("error converting to samples: %v", err)
+		return nil, fmt.Errorf("failed to rename downloaded file: %v", err)
 	}
 
-	// Generate spectrogram and extract peaks
-	spectrogram, err := shazam.Spectrogram(samples, wavInfo.SampleRate)
-	if err != nil {
-		logger.ErrorContext(ctx, "Error generating spectrogram", slog.Any("error", err))
-		return nil, fmt.Errorf("error generating spectrogram: %v", err)
+	defer os.Remove(tmpAudioFile) // Clean up the downloaded file
+
+	return processAudioFile(ctx, dbClient, tmpAudioFile, input)
+}
+
+// processAudioFile decodes, fingerprints and registers the audio file at
+// audioPath in dbClient, then stores a mono WAV copy under the songs
+// directory.
+func processAudioFile(ctx context.Context, dbClient db.Store, audioPath string, input *SongInput) (*ProcessResponse, error) {
+	logger := utils.GetLogger()
+
+	reportProgress(ctx, StageConvert, 0)
+
+	if needsTags(input) {
+		tags, err := decode.ReadFileTags(audioPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tags: %v", err)
+		}
+		fillFromTags(input, tags)
+	}
+	if err := requireTitleAndArtist(input); err != nil {
+		return nil, err
 	}
 
-	peaks := shazam.ExtractPeaks(spectrogram, wavInfo.Duration)
-	songID := utils.GenerateUniqueID()
-	fingerprints := shazam.Fingerprint(peaks, songID)
+	timeout := config.Get().Timeouts.ConvertSeconds
+	convertCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
 
-	// Save fingerprints to database
-	dbClient, err := db.NewDBClient()
+	// Reject files that aren't audio before they are decoded
+	probe, err := decode.ProbeFile(convertCtx, audioPath)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error creating DB client", slog.Any("error", err))
-		return nil, fmt.Errorf("error creating DB client: %v", err)
+		return nil, fmt.Errorf("error probing audio: %w", timedOut(ctx, convertCtx, "probing audio", timeout, err))
 	}
-	defer dbClient.Close()
 
-	// Register the song first
-	registeredSongID, err := dbClient.RegisterSong(input.Title, input.Artist, input.YoutubeID)
+	// Decode the audio natively (FFmpeg is only used as a fallback)
+	audio, err := decode.DecodeFile(convertCtx, audioPath)
+	err = timedOut(ctx, convertCtx, "decoding audio", timeout, err)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error registering song", slog.Any("error", err))
-		return nil, fmt.Errorf("error registering song: %v", err)
+		logger.ErrorContext(ctx, "Error decoding audio", slog.Any("error", err))
+		return nil, fmt.Errorf("error decoding audio: %v", err)
+	}
+	if err := probe.Measure(audio); err != nil {
+		return nil, fmt.Errorf("error probing audio: %w", err)
 	}
 
-	// Store fingerprints
-	err = dbClient.StoreFingerprints(fingerprints)
-	if err != nil {
-		logger.ErrorContext(ctx, "Error storing fingerprints", slog.Any("error", err))
-		return nil, fmt.Errorf("error storing fingerprints: %v", err)
+	return processDecodedAudio(ctx, dbClient, audio, probe, input)
+}
+
+// needsTags reports whether input lacks a title or artist that the tags of
+// its audio may hold.
+func needsTags(input *SongInput) bool {
+	return input.Title == "" || input.Artist == ""
+}
+
+// fillFromTags sets the title and artist input lacks from tags.
+func fillFromTags(input *SongInput, tags decode.Tags) {
+	if input.Title == "" {
+		input.Title = tags.Title
+	}
+	if input.Artist == "" {
+		input.Artist = tags.Artist
 	}
+}
+
+// requireTitleAndArtist checks that input has a title and artist, given or
+// read from tags.
+func requireTitleAndArtist(input *SongInput) error {
+	if input.Title == "" {
+		return fmt.Errorf("title is required: it was not given and the audio has no title tag")
+	}
+	if input.Artist == "" {
+		return fmt.Errorf("artist is required: it was not given and the audio has no artist tag")
+	}
+	return nil
+}
 
-	// Move file to songs directory
-	finalPath := filepath.Join("songs", fmt.Sprintf("%s_%s.wav", input.Title, input.Artist))
-	err = os.Rename(tmpWavFile, finalPath)
+// processDecodedAudio fingerprints and registers decoded audio in dbClient,
+// then stores it as a mono WAV file in the storage of the config file, under
+// a key named after its checksum, with its preview clip next to it.
+func processDecodedAudio(ctx context.Context, dbClient db.Store, audio *decode.Audio, probe decode.Probe, input *SongInput) (*ProcessResponse, error) {
+	store := storage.Default()
+	var key string
+
+	// Write the decoded audio as a mono WAV file in tmp, then store it.
+	persist := func(songID uint32, checksum string, undo *compensation) (string, string, error) {
+		key = songFileKey(checksum)
+		// 16-bit samples and the header
+		if err := reserveTmp(ctx, int64(2*len(audio.Samples)+44)); err != nil {
+			return "", "", err
+		}
+		file, err := os.CreateTemp("tmp", "song-*.wav")
+		if err != nil {
+			return "", "", fmt.Errorf("error creating WAV file: %v", err)
+		}
+		tmpPath := file.Name()
+		file.Close()
+		defer os.Remove(tmpPath)
+
+		if err := saveWav(tmpPath, audio); err != nil {
+			return "", "", fmt.Errorf("error saving WAV file: %v", err)
+		}
+		if err := wav.WriteInfo(tmpPath, songInfo(input, songID)); err != nil {
+			return "", "", fmt.Errorf("error writing song metadata to WAV file: %v", err)
+		}
+
+		file, err = os.Open(tmpPath)
+		if err != nil {
+			return "", "", fmt.Errorf("error reading WAV file: %v", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return "", "", fmt.Errorf("error reading WAV file: %v", err)
+		}
+		if err := store.Put(ctx, key, file, info.Size()); err != nil {
+			return "", "", fmt.Errorf("error storing WAV file at %s: %v", store.Location(key), err)
+		}
+		undo.add("song file", func(ctx context.Context) error {
+			return store.Delete(ctx, key)
+		})
+
+		return key, storePreview(ctx, store, tmpPath, checksum, audio.Duration, undo), nil
+	}
+
+	registeredSongID, duplicate, err := fingerprintAndStore(ctx, dbClient, audio, probe, input, persist)
 	if err != nil {
-		logger.ErrorContext(ctx, "Error moving file to songs directory", slog.Any("error", err))
-		// Don't return error here as fingerprints are already saved
+		return nil, err
+	}
+	if duplicate {
+		return alreadyRegisteredResponse(ctx, dbClient, registeredSongID), nil
 	}
 
+	reportProgress(ctx, StageDone, 1)
+
 	return &ProcessResponse{
 		Success:       true,
 		Message:       "Song processed successfully",
-		FilePath:      finalPath,
+		FilePath:      store.Location(key),
 		FingerprintID: strconv.FormatUint(uint64(registeredSongID), 10),
+		StoreURL:      storeURL(ctx, dbClient, registeredSongID),
 	}, nil
 }
 
-func ProcessSongJSON(jsonInput []byte) (*ProcessResponse, error) {
+// songFileKey returns the key the WAV file of the song with checksum is
+// stored under. Files are spread over directories by the first two digits
+// of their checksum, so none grows too large.
+func songFileKey(checksum string) string {
+	return checksum[:2] + "/" + checksum + ".wav"
+}
+
+// fingerprintAndStore fingerprints decoded audio and registers it in
+// dbClient, returning the ID of the registered song. If a song with the same
+// audio checksum is already registered its ID is returned with duplicate set
+// and nothing is stored.
+//
+// persist, if not nil, is called once the fingerprints are stored to save
+// the song's file, given its checksum, and returns the keys it and its
+// preview clip, if any, are stored under; it may add its own undo steps. If
+// any step fails, everything done so far is rolled back.
+func fingerprintAndStore(ctx context.Context, dbClient db.Store, audio *decode.Audio, probe decode.Probe, input *SongInput, persist func(songID uint32, checksum string, undo *compensation) (string, string, error)) (songID uint32, duplicate bool, err error) {
+	logger := utils.GetLogger()
+
+	checksum, err := utils.AudioChecksum(audio.Samples)
+	if err != nil {
+		return 0, false, fmt.Errorf("error computing audio checksum: %v", err)
+	}
+
+	// Skip audio that has been registered before
+	existing, exists, err := dbClient.GetSongByChecksum(ctx, checksum)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error looking up song checksum", slog.Any("error", err))
+		return 0, false, fmt.Errorf("error looking up song checksum: %v", err)
+	}
+	if exists {
+		return existing.ID, true, nil
+	}
+
+	var undo compensation
+	defer func() {
+		if err != nil {
+			undo.rollback(ctx)
+		}
+	}()
+
+	// Register the song first so the fingerprints carry its ID
+	registeredSongID, err := dbClient.RegisterSong(ctx, input.Title, input.Artist, input.YoutubeID, checksum)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error registering song", slog.Any("error", err))
+		return 0, false, fmt.Errorf("error registering song: %v", err)
+	}
+	undo.add("song registration", func(ctx context.Context) error {
+		return dbClient.DeleteSongByID(ctx, registeredSongID)
+	})
+
+	// Fingerprint the song a chunk at a time and store the fingerprints of
+	// each chunk as they come, so the spectrogram of the whole song is never
+	// held in memory. A failed store may have written some of them.
+	reportProgress(ctx, StageSpectrogram, 0)
+	undo.add("fingerprints", func(ctx context.Context) error {
+		return dbClient.DeleteFingerprintsBySongID(ctx, registeredSongID)
+	})
+	samples := shazam.NormalizeSong(audio.Samples, audio.SampleRate)
+	features, err := shazam.FingerprintSong(shazam.NewSliceReader(samples), audio.SampleRate, registeredSongID, func(fingerprints map[uint32]models.Couple) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := dbClient.StoreFingerprints(ctx, fingerprints, shazam.FingerprintVersion()); err != nil {
+			return fmt.Errorf("error storing fingerprints: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "Error fingerprinting song", slog.Any("error", err))
+		return 0, false, err
+	}
+
+	reportProgress(ctx, StagePeaks, 0)
+	tempo := features.Tempo
+	musicalKey := features.Key
+	tags := classify.Classify(ctx, audio.Samples, audio.SampleRate)
+
+	reportProgress(ctx, StageStore, 0)
+
+	// Stores that are a whole DBClient also keep the song's details, melody
+	// and waveform; narrower ones only its fingerprints
+	client, full := dbClient.(db.DBClient)
+
+	var metadata db.SongMetadata
+	if full {
+		err = client.SetSongAudio(ctx, registeredSongID, db.SongAudio{Codec: probe.Codec, Duration: probe.Duration, Bitrate: probe.Bitrate})
+		if err != nil {
+			logger.ErrorContext(ctx, "Error storing song audio", slog.Any("error", err))
+			return 0, false, fmt.Errorf("error storing song audio: %v", err)
+		}
+
+		if tempo > 0 {
+			err = client.SetSongTempo(ctx, registeredSongID, tempo)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song tempo", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song tempo: %v", err)
+			}
+		}
+
+		if musicalKey != "" {
+			err = client.SetSongMusicalKey(ctx, registeredSongID, musicalKey)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song key", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song key: %v", err)
+			}
+		}
+
+		if len(tags) > 0 {
+			err = client.SetSongTags(ctx, registeredSongID, tags)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song tags", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song tags: %v", err)
+			}
+		}
+
+		var found bool
+		metadata, found = musicbrainz.Metadata(ctx, input.Title, input.Artist, input.MusicBrainzID)
+		if found {
+			err = client.SetSongMetadata(ctx, registeredSongID, metadata)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song metadata", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song metadata: %v", err)
+			}
+		}
+
+		catalog, found := itunes.Metadata(ctx, input.Title, input.Artist)
+		if found {
+			// MusicBrainz's canonical artist name wins over the store's casing
+			if metadata.Artist != "" {
+				catalog.Artist = ""
+			}
+			err = client.SetSongMetadata(ctx, registeredSongID, catalog)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song store metadata", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song store metadata: %v", err)
+			}
+		}
+	}
+
+	if artwork.Store(ctx, registeredSongID, input.Title, input.Artist, metadata.MusicBrainzReleaseID) != "" {
+		undo.add("cover", func(context.Context) error {
+			return artwork.Remove(registeredSongID)
+		})
+	}
+
+	if full && config.Get().LSH.Enabled {
+		undo.add("lsh bands", func(ctx context.Context) error {
+			return client.DeleteLSHBands(ctx, registeredSongID)
+		})
+		err = shazam.IndexLSH(ctx, client, registeredSongID)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error indexing song", slog.Any("error", err))
+			return 0, false, fmt.Errorf("error indexing song: %v", err)
+		}
+	}
+
+	if full {
+		// Store the melody for query by humming
+		var contour []byte
+		contour, err = shazam.MelodyContour(audio.Samples, audio.SampleRate)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error extracting melody", slog.Any("error", err))
+			return 0, false, fmt.Errorf("error extracting melody: %v", err)
+		}
+		undo.add("melody", func(ctx context.Context) error {
+			return client.DeleteMelody(ctx, registeredSongID)
+		})
+		err = client.StoreMelody(ctx, registeredSongID, contour)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error storing melody", slog.Any("error", err))
+			return 0, false, fmt.Errorf("error storing melody: %v", err)
+		}
+
+		// Store the waveform for drawing
+		undo.add("waveform", func(ctx context.Context) error {
+			return client.DeleteWaveform(ctx, registeredSongID)
+		})
+		err = client.StoreWaveform(ctx, registeredSongID, shazam.Waveform(audio.Samples, shazam.WaveformPoints))
+		if err != nil {
+			logger.ErrorContext(ctx, "Error storing waveform", slog.Any("error", err))
+			return 0, false, fmt.Errorf("error storing waveform: %v", err)
+		}
+	}
+
+	if persist != nil {
+		var fileKey, previewKey string
+		fileKey, previewKey, err = persist(registeredSongID, checksum, &undo)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error persisting song files", slog.Any("error", err))
+			return 0, false, err
+		}
+		if full {
+			err = client.SetSongFile(ctx, registeredSongID, fileKey)
+			if err != nil {
+				logger.ErrorContext(ctx, "Error storing song file key", slog.Any("error", err))
+				return 0, false, fmt.Errorf("error storing song file key: %v", err)
+			}
+			if previewKey != "" {
+				err = client.SetSongPreview(ctx, registeredSongID, previewKey)
+				if err != nil {
+					logger.ErrorContext(ctx, "Error storing song preview key", slog.Any("error", err))
+					return 0, false, fmt.Errorf("error storing song preview key: %v", err)
+				}
+			}
+		}
+	}
+
+	return registeredSongID, false, nil
+}
+
+// SetAlbum stores the album and release year a catalog has for the song
+// registered as response, unless MusicBrainz already gave it an album.
+// Failures are logged.
+func SetAlbum(ctx context.Context, dbClient db.DBClient, response *ProcessResponse, album string, releaseYear int) {
+	logger := utils.GetLogger()
+
+	songID, err := strconv.ParseUint(response.FingerprintID, 10, 32)
+	if err != nil || album == "" {
+		return
+	}
+
+	registered, exists, err := dbClient.GetSongByID(ctx, uint32(songID))
+	if err != nil || !exists || registered.Album != "" {
+		return
+	}
+
+	metadata := db.SongMetadata{Album: album, ReleaseYear: releaseYear}
+	if err := dbClient.SetSongMetadata(ctx, uint32(songID), metadata); err != nil {
+		logger.ErrorContext(ctx, "Error storing song album", slog.Any("error", err))
+	}
+}
+
+// alreadyRegisteredResponse reports that the submitted audio is songID.
+func alreadyRegisteredResponse(ctx context.Context, dbClient db.Store, songID uint32) *ProcessResponse {
+	reportProgress(ctx, StageDone, 1)
+
+	return &ProcessResponse{
+		Success:           true,
+		Message:           "Song already registered",
+		FingerprintID:     strconv.FormatUint(uint64(songID), 10),
+		AlreadyRegistered: true,
+		StoreURL:          storeURL(ctx, dbClient, songID),
+	}
+}
+
+// storeURL returns the store page of the song songID, looking it up if it
+// has none yet, or "" when store lookups are disabled or dbClient keeps no
+// store pages.
+func storeURL(ctx context.Context, dbClient db.Store, songID uint32) string {
+	client, ok := dbClient.(db.DBClient)
+	if itunes.Default() == nil || !ok {
+		return ""
+	}
+
+	registered, exists, err := client.GetSongByID(ctx, songID)
+	if err != nil || !exists {
+		return ""
+	}
+	return itunes.Link(ctx, client, registered).StoreURL
+}
+
+// saveWav writes decoded audio to path as a 16-bit mono WAV file.
+func saveWav(path string, audio *decode.Audio) error {
+	pcm, err := utils.FloatsToBytes(audio.Samples, 16)
+	if err != nil {
+		return fmt.Errorf("error converting samples to PCM: %v", err)
+	}
+
+	return wav.WriteWavFile(path, pcm, audio.SampleRate, 1, 16)
+}
+
+// songInfo returns the metadata stored in the song file of input, so the
+// songs directory describes itself without the database.
+func songInfo(input *SongInput, songID uint32) wav.Info {
+	source := input.SongURL
+	if source == "" && input.YoutubeID != "" {
+		source = "https://www.youtube.com/watch?v=" + input.YoutubeID
+	}
+	return wav.Info{Title: input.Title, Artist: input.Artist, Source: source, SongID: songID}
+}
+
+func ProcessSongJSON(ctx context.Context, jsonInput []byte) (*ProcessResponse, error) {
 	var input SongInput
 	err := json.Unmarshal(jsonInput, &input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JSON input: %v", err)
 	}
 
+	if err := validateInput(&input); err != nil {
+		return nil, err
+	}
+
+	return ProcessSong(ctx, &input)
+}
+
+// ProcessSong processes a validated SongInput into the database DB_TYPE
+// selects, taking the audio from AudioData when it is set, downloading
+// SongURL otherwise and the YouTube video YoutubeID when neither is. The
+// input's CallbackURL, if set, is notified of the outcome.
+func ProcessSong(ctx context.Context, input *SongInput) (*ProcessResponse, error) {
+	response, err := withDBClient(func(dbClient db.DBClient) (*ProcessResponse, error) {
+		return processSong(ctx, dbClient, input)
+	})
+	notifyCallback(ctx, input, "", response, err)
+	return response, err
+}
+
+// processSong processes input into dbClient. Its idempotency key is kept
+// in dbClient too, or in the database DB_TYPE selects if dbClient is only
+// a db.Store.
+func processSong(ctx context.Context, dbClient db.Store, input *SongInput) (*ProcessResponse, error) {
+	process := func() (*ProcessResponse, error) {
+		timeout := config.Get().Timeouts.TotalSeconds
+		processCtx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
+		response, err := processSongSource(processCtx, dbClient, input)
+		return response, timedOut(ctx, processCtx, "processing the song", timeout, err)
+	}
+
+	if client, ok := dbClient.(db.DBClient); ok {
+		return processIdempotent(ctx, client, input.IdempotencyKey, process)
+	}
+	return ProcessIdempotent(ctx, input.IdempotencyKey, process)
+}
+
+// processSongSource processes input from its inline audio, its song URL or
+// else its YouTube video.
+func processSongSource(ctx context.Context, dbClient db.Store, input *SongInput) (*ProcessResponse, error) {
+	if input.AudioData != "" {
+		return processInlineAudio(ctx, dbClient, input)
+	}
 	if input.SongURL == "" {
-		return nil, fmt.Errorf("song_url is required")
+		return processYouTube(ctx, dbClient, input)
 	}
-	if input.Title == "" {
-		return nil, fmt.Errorf("title is required")
+	return processSongFromURL(ctx, dbClient, input)
+}
+
+// processInlineAudio decodes the base64 AudioData of input and runs it
+// through the processing pipeline into dbClient.
+func processInlineAudio(ctx context.Context, dbClient db.Store, input *SongInput) (*ProcessResponse, error) {
+	logger := utils.GetLogger()
+
+	if err := createWorkDirs(); err != nil {
+		return nil, err
 	}
-	if input.Artist == "" {
-		return nil, fmt.Errorf("artist is required")
+
+	data := input.AudioData
+	if strings.HasPrefix(data, "data:") {
+		if i := strings.Index(data, ","); i >= 0 {
+			data = data[i+1:]
+		}
 	}
 
-	return ProcessSongFromURL(&input)
+	if base64.StdEncoding.DecodedLen(len(data)) > MaxInlineAudioSize {
+		return nil, fmt.Errorf("audio_data exceeds the %d byte limit", MaxInlineAudioSize)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("audio_data is not valid base64: %v", err)
+	}
+
+	if needsTags(input) {
+		tags, err := decode.ReadTags(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("error reading tags: %v", err)
+		}
+		fillFromTags(input, tags)
+	}
+	if err := requireTitleAndArtist(input); err != nil {
+		return nil, err
+	}
+
+	reportProgress(ctx, StageConvert, 0)
+	timeout := config.Get().Timeouts.ConvertSeconds
+	convertCtx, cancel := withTimeout(ctx, timeout)
+	audio, err := decode.Decode(convertCtx, bytes.NewReader(raw))
+	cancel()
+	err = timedOut(ctx, convertCtx, "decoding audio", timeout, err)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error decoding audio", slog.Any("error", err))
+		return nil, fmt.Errorf("error decoding audio: %v", err)
+	}
+
+	probe := decode.ProbeData(raw)
+	if err := probe.Measure(audio); err != nil {
+		return nil, fmt.Errorf("error probing audio: %w", err)
+	}
+
+	return processDecodedAudio(ctx, dbClient, audio, probe, input)
+}
+
+// validateInput checks that the required fields of a SongInput are set.
+// The title and artist are checked once the audio's tags have been read.
+func validateInput(input *SongInput) error {
+	if input.SongURL == "" && input.AudioData == "" && input.YoutubeID == "" {
+		return fmt.Errorf("song_url, audio_data or youtube_id is required")
+	}
+	if input.SongURL != "" && input.AudioData != "" {
+		return fmt.Errorf("song_url and audio_data are mutually exclusive")
+	}
+	if len(input.MirrorURLs) > 0 && input.SongURL == "" {
+		return fmt.Errorf("mirror_urls need a song_url")
+	}
+	for _, songURL := range append([]string{input.SongURL}, input.MirrorURLs...) {
+		if songURL == "" {
+			continue
+		}
+		u, err := url.Parse(songURL)
+		if err != nil {
+			return fmt.Errorf("invalid song URL: %v", err)
+		}
+		if err := checkHost(u); err != nil {
+			return err
+		}
+	}
+	if input.Credentials != "" {
+		if _, ok := config.Get().Download.Credentials[input.Credentials]; !ok {
+			return fmt.Errorf("unknown credentials %q", input.Credentials)
+		}
+	}
+	if input.CallbackURL != "" {
+		if err := webhook.ValidateURL(input.CallbackURL); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,84 @@
+package song
+
+import "context"
+
+// Stage identifies a step of the song processing pipeline.
+type Stage string
+
+const (
+	StageDownload    Stage = "download"
+	StageConvert     Stage = "convert"
+	StageSpectrogram Stage = "spectrogram"
+	StagePeaks       Stage = "peaks"
+	StageStore       Stage = "store"
+	StageDone        Stage = "done"
+)
+
+// stageRanges maps each stage to the share of overall progress it covers.
+var stageRanges = map[Stage][2]float64{
+	StageDownload:    {0, 30},
+	StageConvert:     {30, 50},
+	StageSpectrogram: {50, 70},
+	StagePeaks:       {70, 80},
+	StageStore:       {80, 100},
+	StageDone:        {100, 100},
+}
+
+// ProgressReporter receives progress updates while a song is processed.
+// Percent is the overall completion of the pipeline, from 0 to 100.
+type ProgressReporter interface {
+	ReportProgress(stage Stage, percent float64)
+}
+
+// ProgressFunc adapts a plain function to the ProgressReporter interface.
+type ProgressFunc func(stage Stage, percent float64)
+
+func (f ProgressFunc) ReportProgress(stage Stage, percent float64) {
+	f(stage, percent)
+}
+
+type progressKey struct{}
+
+// WithProgressReporter returns a copy of ctx that carries reporter. The
+// processing functions of this package report their progress to it.
+func WithProgressReporter(ctx context.Context, reporter ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, reporter)
+}
+
+// reportProgress notifies the reporter in ctx, if any. stageFraction is the
+// completion of the current stage, from 0 to 1.
+func reportProgress(ctx context.Context, stage Stage, stageFraction float64) {
+	reporter, ok := ctx.Value(progressKey{}).(ProgressReporter)
+	if !ok || reporter == nil {
+		return
+	}
+
+	if stageFraction < 0 {
+		stageFraction = 0
+	} else if stageFraction > 1 {
+		stageFraction = 1
+	}
+
+	r := stageRanges[stage]
+	reporter.ReportProgress(stage, r[0]+(r[1]-r[0])*stageFraction)
+}
+
+// progressWriter reports download progress as bytes are written through it.
+type progressWriter struct {
+	ctx         context.Context
+	total       int64
+	written     int64
+	lastPercent int
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.total > 0 {
+		percent := int(w.written * 100 / w.total)
+		if percent > w.lastPercent {
+			w.lastPercent = percent
+			reportProgress(w.ctx, StageDownload, float64(w.written)/float64(w.total))
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,82 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/utils"
+	"strconv"
+)
+
+// SongMetadata describes a song fed to ProcessSongFromReader.
+type SongMetadata struct {
+	Title     string `json:"title"`
+	Artist    string `json:"artist"`
+	YoutubeID string `json:"youtube_id,omitempty"`
+}
+
+// ProcessSongFromReader fingerprints and registers audio read from r, for
+// programs embedding this package that already hold the audio in memory or
+// as a stream. The format is sniffed from the content. Nothing is written to
+// disk, so the response has no FilePath. The song is registered in the
+// database DB_TYPE selects.
+func ProcessSongFromReader(ctx context.Context, r io.Reader, meta SongMetadata) (*ProcessResponse, error) {
+	return withDBClient(func(dbClient db.DBClient) (*ProcessResponse, error) {
+		return processReader(ctx, dbClient, r, meta)
+	})
+}
+
+// processReader is ProcessSongFromReader registering the song in dbClient.
+func processReader(ctx context.Context, dbClient db.Store, r io.Reader, meta SongMetadata) (*ProcessResponse, error) {
+	logger := utils.GetLogger()
+
+	if meta.Title == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+	if meta.Artist == "" {
+		return nil, fmt.Errorf("artist is required")
+	}
+
+	timeouts := config.Get().Timeouts
+	ctx, cancel := withTimeout(ctx, timeouts.TotalSeconds)
+	defer cancel()
+
+	reportProgress(ctx, StageConvert, 0)
+	convertCtx, cancelConvert := withTimeout(ctx, timeouts.ConvertSeconds)
+	audio, err := decode.Decode(convertCtx, r)
+	cancelConvert()
+	err = timedOut(ctx, convertCtx, "decoding audio", timeouts.ConvertSeconds, err)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error decoding audio", slog.Any("error", err))
+		return nil, fmt.Errorf("error decoding audio: %v", err)
+	}
+
+	// The size and format of a stream are unknown, so only its duration is
+	// measured
+	var probe decode.Probe
+	if err := probe.Measure(audio); err != nil {
+		return nil, fmt.Errorf("error probing audio: %w", err)
+	}
+
+	input := &SongInput{Title: meta.Title, Artist: meta.Artist, YoutubeID: meta.YoutubeID}
+	registeredSongID, duplicate, err := fingerprintAndStore(ctx, dbClient, audio, probe, input, nil)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate {
+		return alreadyRegisteredResponse(ctx, dbClient, registeredSongID), nil
+	}
+
+	reportProgress(ctx, StageDone, 1)
+
+	return &ProcessResponse{
+		Success:       true,
+		Message:       "Song processed successfully",
+		FingerprintID: strconv.FormatUint(uint64(registeredSongID), 10),
+		StoreURL:      storeURL(ctx, dbClient, registeredSongID),
+	}, nil
+}
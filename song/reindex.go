@@ -0,0 +1,138 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/shazam"
+	"song-recognition/utils"
+	"song-recognition/wav"
+	"strconv"
+	"strings"
+)
+
+// ReindexDir re-fingerprints the songs whose audio files are in dir, such
+// as the songs directory, with the current fingerprint settings and at
+// most concurrency files in flight. Each file is matched to its registered
+// song by its audio checksum, or by the "Title_Artist.wav" name songs are
+// stored under for songs registered before checksums were. The
+// fingerprints of every song are swapped for the new ones at once, so it
+// keeps matching throughout.
+func ReindexDir(ctx context.Context, dir string, concurrency int) ([]BatchResult, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && decode.FormatFromExt(filepath.Ext(path)) != decode.FormatUnknown {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %v", dir, err)
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	return runBatch(ctx, len(files), concurrency, func(i int, result *BatchResult) (*ProcessResponse, error) {
+		return reindexFile(ctx, dbClient, files[i], result)
+	}), nil
+}
+
+// reindexFile re-fingerprints the song stored at filePath.
+func reindexFile(ctx context.Context, dbClient db.DBClient, filePath string, result *BatchResult) (*ProcessResponse, error) {
+	result.Title = filepath.Base(filePath)
+
+	audio, err := decode.DecodeFile(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding audio: %v", err)
+	}
+
+	song, exists, err := findStoredSong(ctx, dbClient, filePath, audio)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no registered song matches %s", filePath)
+	}
+	result.Title, result.Artist = song.Title, song.Artist
+
+	spectrogram, err := shazam.Spectrogram(shazam.NormalizeSong(audio.Samples, audio.SampleRate), audio.SampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("error generating spectrogram: %v", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	peaks := shazam.ExtractPeaks(spectrogram, audio.Duration)
+	fingerprints := shazam.Fingerprint(peaks, song.ID)
+
+	err = dbClient.ReplaceFingerprints(ctx, song.ID, fingerprints, shazam.FingerprintVersion())
+	if err != nil {
+		return nil, fmt.Errorf("error replacing fingerprints: %v", err)
+	}
+
+	if config.Get().LSH.Enabled {
+		if err := shazam.IndexLSH(ctx, dbClient, song.ID); err != nil {
+			return nil, fmt.Errorf("error indexing song: %v", err)
+		}
+	}
+
+	return &ProcessResponse{
+		Success:       true,
+		Message:       "Song reindexed successfully",
+		FilePath:      filePath,
+		FingerprintID: strconv.FormatUint(uint64(song.ID), 10),
+	}, nil
+}
+
+// findStoredSong returns the registered song whose audio is stored at
+// filePath.
+func findStoredSong(ctx context.Context, dbClient db.DBClient, filePath string, audio *decode.Audio) (db.Song, bool, error) {
+	checksum, err := utils.AudioChecksum(audio.Samples)
+	if err != nil {
+		return db.Song{}, false, fmt.Errorf("error computing audio checksum: %v", err)
+	}
+
+	song, exists, err := dbClient.GetSongByChecksum(ctx, checksum)
+	if err != nil || exists {
+		return song, exists, err
+	}
+
+	// Song files carry the ID they were registered under
+	if info, err := wav.ReadInfo(filePath); err == nil && info.SongID != 0 {
+		song, exists, err := dbClient.GetSongByID(ctx, info.SongID)
+		if err != nil || exists {
+			return song, exists, err
+		}
+	}
+
+	// Titles and artists may hold underscores too, so try every split
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	for i := strings.Index(name, "_"); i >= 0; {
+		song, exists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(name[:i], name[i+1:]))
+		if err != nil || exists {
+			return song, exists, err
+		}
+
+		next := strings.Index(name[i+1:], "_")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return db.Song{}, false, nil
+}
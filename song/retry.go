@@ -0,0 +1,93 @@
+package song
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Retry policy for failed jobs. A transient failure is retried after
+// JobRetryBaseDelay, doubling on every further attempt up to
+// JobRetryMaxDelay; after JobMaxAttempts runs the job is dead-lettered.
+var (
+	JobMaxAttempts    = 5
+	JobRetryBaseDelay = 5 * time.Second
+	JobRetryMaxDelay  = 5 * time.Minute
+)
+
+// transientErrorHints are fragments of error messages from the database
+// drivers that indicate a temporary condition. Errors from those layers are
+// flattened to strings, so they can't be matched by type.
+var transientErrorHints = []string{
+	"database is locked",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"server selection error",
+	"no reachable servers",
+}
+
+// IsTransient reports whether err is likely to go away on retry: network
+// timeouts, refused or reset connections, bodies cut short, 5xx and 429
+// responses and busy databases. Other network errors, such as unknown
+// hosts, bad certificates or unsupported schemes, are permanent.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrURLNotAllowed) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrRequestInProgress) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// Every error of http.Client.Do is a net.Error, so only timeouts are
+	// taken as transient
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, hint := range transientErrorHints {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryDelay returns how long to wait before running a job again after
+// attempts failed runs.
+func retryDelay(attempts int) time.Duration {
+	return backoff(attempts, JobRetryBaseDelay, JobRetryMaxDelay)
+}
+
+// backoff returns base doubled for every failed attempt after the first, up
+// to max, with up to 20% jitter so retries don't line up.
+func backoff(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
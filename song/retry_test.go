@@ -0,0 +1,55 @@
+package song
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/song.mp3", Err: err}
+	}
+	dialErr := func(errno syscall.Errno) error {
+		return urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"timeout", urlErr(context.DeadlineExceeded), true},
+		{"dial timeout", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: &timeoutError{}}), true},
+		{"connection refused", dialErr(syscall.ECONNREFUSED), true},
+		{"connection reset", dialErr(syscall.ECONNRESET), true},
+		{"cut short", fmt.Errorf("failed to read body: %w", io.ErrUnexpectedEOF), true},
+		{"server error", &StatusError{StatusCode: 503}, true},
+		{"rate limited", &StatusError{StatusCode: 429}, true},
+		{"not found", &StatusError{StatusCode: 404}, false},
+		{"unknown host", urlErr(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), false},
+		{"bad certificate", urlErr(x509.UnknownAuthorityError{}), false},
+		{"bad scheme", urlErr(errors.New(`unsupported protocol scheme "gopher"`)), false},
+		{"not allowed", urlErr(ErrURLNotAllowed), false},
+		{"busy database", errors.New("database is locked"), true},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%s: %v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
@@ -0,0 +1,39 @@
+package song
+
+import (
+	"context"
+	"log/slog"
+	"song-recognition/utils"
+)
+
+// compensation collects undo steps for the side effects of a multi-step
+// registration so that a failure part way through leaves no partial state.
+type compensation struct {
+	steps []compensationStep
+}
+
+type compensationStep struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// add registers undo to be run if the operation is rolled back.
+func (c *compensation) add(name string, undo func(ctx context.Context) error) {
+	c.steps = append(c.steps, compensationStep{name: name, undo: undo})
+}
+
+// rollback runs the undo steps in reverse order. It keeps going when a step
+// fails so as much state as possible is cleaned up, and runs even if ctx
+// has been canceled, since cancellation is a common reason to roll back.
+func (c *compensation) rollback(ctx context.Context) {
+	logger := utils.GetLogger()
+	ctx = context.WithoutCancel(ctx)
+
+	for i := len(c.steps) - 1; i >= 0; i-- {
+		step := c.steps[i]
+		if err := step.undo(ctx); err != nil {
+			logger.ErrorContext(ctx, "Error rolling back "+step.name, slog.Any("error", err))
+		}
+	}
+	c.steps = nil
+}
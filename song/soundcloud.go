@@ -0,0 +1,35 @@
+package song
+
+import (
+	"context"
+	"song-recognition/db"
+	"song-recognition/soundcloud"
+)
+
+// processSoundCloud registers the SoundCloud track at trackURL as input in
+// dbClient.
+// Its title and artist default to those SoundCloud has, and its audio is
+// the track's MP3 stream, or else downloaded with yt-dlp.
+func processSoundCloud(ctx context.Context, dbClient db.Store, trackURL string, input *SongInput) (*ProcessResponse, error) {
+	track, err := soundcloud.Default().Resolve(ctx, trackURL)
+	if err != nil {
+		return nil, &DownloadError{URL: trackURL, Err: err}
+	}
+
+	title, artist := track.Title, track.Artist
+	if artist == "" {
+		// Uploads without publisher metadata are titled like videos
+		title, artist = TitleArtistFromVideo(track.Title, track.Uploader)
+	}
+	if input.Title == "" {
+		input.Title = title
+	}
+	if input.Artist == "" {
+		input.Artist = artist
+	}
+
+	if track.StreamURL == "" {
+		return processSiteURL(ctx, dbClient, trackURL, input)
+	}
+	return processDownload(ctx, dbClient, track.StreamURL, nil, input)
+}
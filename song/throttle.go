@@ -0,0 +1,113 @@
+package song
+
+import (
+	"context"
+	"io"
+	"song-recognition/config"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most bytes read at once from a throttled download,
+// so the bandwidth is shared out fairly between downloads.
+const throttleChunk = 32 << 10
+
+var (
+	throttleOnce  sync.Once
+	downloadSlots chan struct{}
+	bandwidth     *bandwidthLimiter
+)
+
+// initThrottle sets up the download slots and bandwidth limit of the
+// download config, shared by every download of the server.
+func initThrottle() {
+	throttleOnce.Do(func() {
+		cfg := config.Get().Download
+		if cfg.MaxConcurrent > 0 {
+			downloadSlots = make(chan struct{}, cfg.MaxConcurrent)
+		}
+		if cfg.MaxBytesPerSecond > 0 {
+			bandwidth = &bandwidthLimiter{rate: float64(cfg.MaxBytesPerSecond), last: time.Now()}
+		}
+	})
+}
+
+// acquireDownload waits for one of the download slots to free up and
+// returns the function that frees it again.
+func acquireDownload(ctx context.Context) (func(), error) {
+	initThrottle()
+	if downloadSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case downloadSlots <- struct{}{}:
+		return func() { <-downloadSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// throttle returns r read no faster than the bandwidth limit allows, which
+// is shared with every other throttled download.
+func throttle(ctx context.Context, r io.Reader) io.Reader {
+	initThrottle()
+	if bandwidth == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: bandwidth}
+}
+
+// bandwidthLimiter is a token bucket of bytes, refilled at rate bytes a
+// second and holding up to a second's worth.
+type bandwidthLimiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// wait takes n bytes from the bucket, blocking until they have been
+// refilled if it runs short.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,26 @@
+package song
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// withTimeout returns a copy of ctx that is canceled after seconds, or
+// only when ctx is if seconds is zero.
+func withTimeout(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+}
+
+// timedOut rewords err, from a step run with stepCtx under ctx, when the
+// step ran out of its seconds rather than ctx being done.
+func timedOut(ctx, stepCtx context.Context, step string, seconds int, err error) error {
+	if err == nil || ctx.Err() != nil || !errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%s timed out after %ds: %w", step, seconds, context.DeadlineExceeded)
+}
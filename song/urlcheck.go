@@ -0,0 +1,97 @@
+package song
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"song-recognition/config"
+	"song-recognition/utils"
+	"strings"
+)
+
+// ErrURLNotAllowed is returned for song URLs that the download config
+// doesn't allow downloading from, such as those of private networks.
+var ErrURLNotAllowed = errors.New("song URL not allowed")
+
+// matchesHost reports whether host is one of sites or a subdomain of one.
+func matchesHost(host string, sites []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, site := range sites {
+		site = strings.ToLower(site)
+		if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost checks the scheme of u and its host against the allowed and
+// denied hosts of the download config.
+func checkHost(u *url.URL) error {
+	cfg := config.Get().Download
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %q isn't an http or https URL", ErrURLNotAllowed, u.Redacted())
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: %q has no host", ErrURLNotAllowed, u.Redacted())
+	}
+	if matchesHost(u.Hostname(), cfg.DeniedHosts) {
+		return fmt.Errorf("%w: host %s is denied", ErrURLNotAllowed, u.Hostname())
+	}
+	if len(cfg.AllowedHosts) > 0 && !matchesHost(u.Hostname(), cfg.AllowedHosts) {
+		return fmt.Errorf("%w: host %s isn't in download.allowed_hosts", ErrURLNotAllowed, u.Hostname())
+	}
+	return nil
+}
+
+// checkSongURL checks that songURL may be downloaded from: that its host
+// is allowed and, unless private networks are, only resolves to public
+// addresses. Song URLs handed to yt-dlp are only checked here, before yt-dlp
+// resolves them again; the downloads of this package check every address
+// they connect to as well, which rebinding DNS can't get around.
+func checkSongURL(ctx context.Context, songURL string) error {
+	u, err := url.Parse(songURL)
+	if err != nil {
+		return fmt.Errorf("invalid song URL: %v", err)
+	}
+	if err := checkHost(u); err != nil {
+		return err
+	}
+	if config.Get().Download.AllowPrivateNetworks {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve song URL host: %w", err)
+	}
+	for _, addr := range addrs {
+		if utils.BlockedIP(addr.IP) {
+			return fmt.Errorf("%w: host %s resolves to the private address %s", ErrURLNotAllowed, u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+// checkRedirect applies the host checks to every redirect of a download.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return checkHost(req.URL)
+}
+
+// newGuardedDialer returns a dialer of downloads, refusing private
+// addresses unless the download config allows them.
+func newGuardedDialer() *utils.GuardedDialer {
+	if config.Get().Download.AllowPrivateNetworks {
+		return utils.NewGuardedDialer(nil)
+	}
+	return utils.NewGuardedDialer(func(host string) error {
+		return fmt.Errorf("%w: connecting to the private address %s", ErrURLNotAllowed, host)
+	})
+}
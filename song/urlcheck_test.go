@@ -0,0 +1,50 @@
+package song
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckSongURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://8.8.8.8/song.mp3", true},
+		{"http://127.0.0.1/song.mp3", false},
+		{"http://[::1]:8080/song.mp3", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://10.0.0.1/song.mp3", false},
+		{"http://[::ffff:192.168.0.1]/song.mp3", false},
+		{"http://0.0.0.0/song.mp3", false},
+	}
+	for _, tt := range tests {
+		err := checkSongURL(context.Background(), tt.url)
+		if tt.allowed && err != nil {
+			t.Errorf("checkSongURL(%s) = %v, want nil", tt.url, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrURLNotAllowed) {
+			t.Errorf("checkSongURL(%s) = %v, want ErrURLNotAllowed", tt.url, err)
+		}
+	}
+}
+
+func TestDownloadClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := downloadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrURLNotAllowed) {
+		t.Errorf("download from %s: %v, want ErrURLNotAllowed", server.URL, err)
+	}
+}
@@ -0,0 +1,97 @@
+package song
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"song-recognition/config"
+	"song-recognition/db"
+	"strings"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// ProcessSongFromYouTube downloads the audio of the YouTube video
+// input.YoutubeID, then fingerprints and registers it like any other song
+// in the database DB_TYPE selects.
+func ProcessSongFromYouTube(ctx context.Context, input *SongInput) (*ProcessResponse, error) {
+	return withDBClient(func(dbClient db.DBClient) (*ProcessResponse, error) {
+		return processYouTube(ctx, dbClient, input)
+	})
+}
+
+// processYouTube is ProcessSongFromYouTube registering the song in
+// dbClient.
+func processYouTube(ctx context.Context, dbClient db.Store, input *SongInput) (*ProcessResponse, error) {
+	return processSiteURL(ctx, dbClient, "https://www.youtube.com/watch?v="+input.YoutubeID, input)
+}
+
+// DownloadYouTubeAudio saves the 128 kbit/s m4a audio of the YouTube video
+// id to filePath.
+func DownloadYouTubeAudio(ctx context.Context, id, filePath string) error {
+	httpClient, err := downloadClient()
+	if err != nil {
+		return err
+	}
+	client := youtube.Client{HTTPClient: httpClient}
+	video, err := client.GetVideoContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	/*
+		itag code: 140, container: m4a, content: audio, bitrate: 128k
+		change the FindByItag parameter to 139 if you want smaller files (but with a bitrate of 48k)
+		https://gist.github.com/sidneys/7095afe4da4ae58694d128b1034e01e2
+	*/
+	formats := video.Formats.Itag(140)
+	if len(formats) == 0 {
+		return fmt.Errorf("video %s has no m4a audio format", id)
+	}
+
+	if maxBytes := config.Get().Download.MaxBytes; maxBytes > 0 && formats[0].ContentLength > maxBytes {
+		return tooLarge(maxBytes)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	/* in some cases, when attempting to download the audio
+	using the library github.com/kkdai/youtube,
+	the download fails (and shows the file size as 0 bytes)
+	until the second or third attempt. */
+	for written := int64(0); written == 0; {
+		stream, _, err := client.GetStreamContext(ctx, video, &formats[0])
+		if err != nil {
+			return err
+		}
+
+		written, err = io.Copy(file, throttle(ctx, stream))
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// videoLabel matches the "(Official Video)" kind of labels of video titles.
+var videoLabel = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*(official|video|audio|lyric|visuali[sz]er)[^)\]]*[)\]]`)
+
+// TitleArtistFromVideo guesses the title and artist of a song from the
+// title of its video and the channel that uploaded it: music videos are
+// mostly titled "Artist - Title", and otherwise the channel is the
+// artist's, such as the "Artist - Topic" channels YouTube makes for them.
+func TitleArtistFromVideo(videoTitle, channel string) (string, string) {
+	name := videoLabel.ReplaceAllString(videoTitle, "")
+	if artist, title, found := strings.Cut(name, " - "); found {
+		return strings.TrimSpace(title), strings.TrimSpace(artist)
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(strings.TrimSuffix(channel, " - Topic"))
+}
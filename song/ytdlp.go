@@ -0,0 +1,185 @@
+package song
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/utils"
+	"strconv"
+	"strings"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// IsSiteURL reports whether songURL is a page of one of the video and
+// streaming sites of the yt_dlp config, rather than an audio file.
+func IsSiteURL(songURL string) bool {
+	parsed, err := url.Parse(songURL)
+	if err != nil {
+		return false
+	}
+
+	return matchesHost(parsed.Hostname(), config.Get().YtDlp.Hosts)
+}
+
+var youtubeIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{11}$`)
+
+// YouTubeVideoID extracts the ID of a YouTube video from the URL of its
+// page, in any of the youtube.com/watch?v=, youtu.be, /shorts/ and /embed/
+// forms. It returns "" for other URLs.
+func YouTubeVideoID(pageURL string) string {
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	path := strings.Trim(parsed.Path, "/")
+
+	var id string
+	switch {
+	case host == "youtu.be":
+		id = path
+	case host == "youtube.com" || strings.HasSuffix(host, ".youtube.com"):
+		if path == "watch" {
+			id = parsed.Query().Get("v")
+		} else if kind, rest, ok := strings.Cut(path, "/"); ok && (kind == "shorts" || kind == "embed" || kind == "live") {
+			id = rest
+		}
+	}
+
+	if !youtubeIDPattern.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// processSiteURL downloads the audio of the page at pageURL with yt-dlp,
+// then fingerprints and registers it in dbClient. YouTube videos are downloaded
+// without yt-dlp when it isn't installed or fails, and their ID is stored
+// with the song.
+func processSiteURL(ctx context.Context, dbClient db.Store, pageURL string, input *SongInput) (*ProcessResponse, error) {
+	logger := utils.GetLogger()
+
+	if err := createWorkDirs(); err != nil {
+		return nil, err
+	}
+
+	videoID := YouTubeVideoID(pageURL)
+	if input.YoutubeID == "" {
+		input.YoutubeID = videoID
+	}
+
+	dir, err := os.MkdirTemp("tmp", "yt-dlp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	reportProgress(ctx, StageDownload, 0)
+	if err := reserveTmp(ctx, 0); err != nil {
+		return nil, err
+	}
+	timeout := config.Get().Timeouts.DownloadSeconds
+	release, err := acquireDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	downloadCtx, cancel := withTimeout(ctx, timeout)
+	audioPath, err := downloadWithYtDlp(downloadCtx, pageURL, dir)
+	cancel()
+	release()
+	err = timedOut(ctx, downloadCtx, "yt-dlp download", timeout, err)
+	if err != nil && videoID != "" && ctx.Err() == nil {
+		if !ytDlpMissing(err) {
+			logger.WarnContext(ctx, "yt-dlp failed, downloading the video without it", slog.Any("error", xerrors.New(err)))
+		}
+		audioPath = filepath.Join(dir, "audio.m4a")
+		err = downloadYouTubeFallback(ctx, videoID, audioPath, timeout)
+	}
+	if err != nil {
+		return nil, &DownloadError{URL: pageURL, Err: fmt.Errorf("error downloading audio of %s: %w", pageURL, err)}
+	}
+
+	return processAudioFile(ctx, dbClient, audioPath, input)
+}
+
+// downloadYouTubeFallback downloads the audio of the YouTube video id to
+// audioPath without yt-dlp, once a download slot is free, in at most
+// timeout seconds.
+func downloadYouTubeFallback(ctx context.Context, id, audioPath string, timeout int) error {
+	release, err := acquireDownload(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	downloadCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	return timedOut(ctx, downloadCtx, "YouTube download", timeout, DownloadYouTubeAudio(downloadCtx, id, audioPath))
+}
+
+// ytDlpMissing reports whether err is from a yt-dlp binary that isn't
+// installed, on PATH or at the configured path.
+func ytDlpMissing(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// downloadWithYtDlp saves the best audio yt-dlp finds on the page at
+// pageURL to dir and returns the path of the file. m4a audio is preferred,
+// since it decodes without FFmpeg. yt-dlp goes through the proxy of the
+// download config, but trusts its own certificates.
+func downloadWithYtDlp(ctx context.Context, pageURL, dir string) (string, error) {
+	args := []string{"--no-playlist", "--no-progress", "--quiet",
+		"-f", "bestaudio[ext=m4a]/bestaudio/best",
+		"-o", filepath.Join(dir, "audio.%(ext)s")}
+	download := config.Get().Download
+	if download.ProxyURL != "" {
+		args = append(args, "--proxy", download.ProxyURL)
+	}
+	if download.InsecureSkipVerify {
+		args = append(args, "--no-check-certificates")
+	}
+	if download.MaxBytes > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(download.MaxBytes, 10))
+	}
+	if download.MaxBytesPerSecond > 0 {
+		args = append(args, "--limit-rate", strconv.FormatInt(download.MaxBytesPerSecond, 10))
+	}
+	cmd := exec.CommandContext(ctx, config.Get().YtDlp.Path, append(args, "--", pageURL)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("yt-dlp failed: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "audio.*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		// yt-dlp skips files over --max-filesize without failing
+		if download.MaxBytes > 0 {
+			return "", fmt.Errorf("yt-dlp saved no audio, which may be larger than the limit of %d bytes", download.MaxBytes)
+		}
+		return "", errors.New("yt-dlp saved no audio")
+	}
+	return matches[0], nil
+}
@@ -0,0 +1,219 @@
+// Package soundcloud resolves the URLs of SoundCloud tracks to their title,
+// artist and audio stream.
+//
+// With the client ID of a SoundCloud app, tracks are resolved with the
+// SoundCloud API, which also gives the URL of their MP3 stream. Without
+// one, only their title and uploader are read from SoundCloud's oEmbed
+// endpoint, and the audio is left to yt-dlp.
+package soundcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"song-recognition/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Base URLs of the SoundCloud API and oEmbed endpoint.
+const (
+	DefaultAPIURL    = "https://api-v2.soundcloud.com"
+	DefaultOEmbedURL = "https://soundcloud.com/oembed"
+)
+
+// httpTimeout bounds a single request.
+const httpTimeout = 15 * time.Second
+
+// Track is a SoundCloud track.
+type Track struct {
+	ID    int64
+	Title string
+	// Artist is the artist the track's publisher credits, if any, and
+	// Uploader the name of the account that uploaded it.
+	Artist   string
+	Uploader string
+	// Duration is in seconds, zero if unknown.
+	Duration float64
+	// StreamURL is a progressive MP3 stream of the track, valid for a
+	// few minutes. It is empty if the track was resolved without the API
+	// or can only be streamed in segments.
+	StreamURL string
+}
+
+// Client resolves tracks with the SoundCloud API, or its oEmbed endpoint
+// when ClientID is empty.
+type Client struct {
+	ClientID string
+	// APIURL and OEmbedURL are those of SoundCloud, and overridable for
+	// tests.
+	APIURL    string
+	OEmbedURL string
+	Client    *http.Client
+}
+
+// NewClient returns a client signed in with the client ID of a SoundCloud
+// app, or one that uses the oEmbed endpoint if clientID is empty.
+func NewClient(clientID string) *Client {
+	return &Client{
+		ClientID:  clientID,
+		APIURL:    DefaultAPIURL,
+		OEmbedURL: DefaultOEmbedURL,
+		Client:    &http.Client{Timeout: httpTimeout},
+	}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns the client configured in the config file.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		defaultClient = NewClient(config.Get().SoundCloud.ClientID)
+	})
+	return defaultClient
+}
+
+// IsTrackURL reports whether trackURL is the page of a SoundCloud track,
+// soundcloud.com/<user>/<track>, or one of its on.soundcloud.com short
+// links. Playlists and user pages aren't tracks.
+func IsTrackURL(trackURL string) bool {
+	parsed, err := url.Parse(trackURL)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	switch strings.ToLower(parsed.Hostname()) {
+	case "on.soundcloud.com":
+		return len(parts) == 1 && parts[0] != ""
+	case "soundcloud.com", "www.soundcloud.com", "m.soundcloud.com":
+		return len(parts) == 2 && parts[0] != "" && !userPages[parts[1]]
+	}
+	return false
+}
+
+// userPages are the pages of a SoundCloud user other than their tracks.
+var userPages = map[string]bool{
+	"sets": true, "tracks": true, "albums": true, "popular-tracks": true,
+	"likes": true, "reposts": true, "followers": true, "following": true, "comments": true,
+}
+
+// apiTrack is a track as the SoundCloud API describes it.
+type apiTrack struct {
+	Kind       string `json:"kind"`
+	ID         int64  `json:"id"`
+	Title      string `json:"title"`
+	DurationMs int64  `json:"duration"`
+	User       struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	PublisherMetadata *struct {
+		Artist string `json:"artist"`
+	} `json:"publisher_metadata"`
+	Media struct {
+		Transcodings []struct {
+			URL    string `json:"url"`
+			Format struct {
+				Protocol string `json:"protocol"`
+				MimeType string `json:"mime_type"`
+			} `json:"format"`
+		} `json:"transcodings"`
+	} `json:"media"`
+	TrackAuthorization string `json:"track_authorization"`
+}
+
+// Resolve returns the track at trackURL.
+func (c *Client) Resolve(ctx context.Context, trackURL string) (Track, error) {
+	if c.ClientID == "" {
+		return c.resolveOEmbed(ctx, trackURL)
+	}
+
+	var response apiTrack
+	params := url.Values{"url": {trackURL}, "client_id": {c.ClientID}}
+	if err := c.get(ctx, c.APIURL+"/resolve?"+params.Encode(), &response); err != nil {
+		return Track{}, fmt.Errorf("failed to resolve SoundCloud URL: %v", err)
+	}
+	if response.Kind != "track" {
+		return Track{}, fmt.Errorf("%s is a SoundCloud %s, not a track", trackURL, response.Kind)
+	}
+
+	track := Track{
+		ID:       response.ID,
+		Title:    response.Title,
+		Uploader: response.User.Username,
+		Duration: float64(response.DurationMs) / 1000,
+	}
+	if response.PublisherMetadata != nil {
+		track.Artist = response.PublisherMetadata.Artist
+	}
+
+	for _, transcoding := range response.Media.Transcodings {
+		if transcoding.Format.Protocol != "progressive" {
+			continue
+		}
+
+		params := url.Values{"client_id": {c.ClientID}}
+		if response.TrackAuthorization != "" {
+			params.Set("track_authorization", response.TrackAuthorization)
+		}
+		var stream struct {
+			URL string `json:"url"`
+		}
+		if err := c.get(ctx, transcoding.URL+"?"+params.Encode(), &stream); err != nil {
+			return Track{}, fmt.Errorf("failed to get SoundCloud stream: %v", err)
+		}
+		track.StreamURL = stream.URL
+		break
+	}
+
+	return track, nil
+}
+
+// resolveOEmbed returns the title and uploader of the track at trackURL.
+func (c *Client) resolveOEmbed(ctx context.Context, trackURL string) (Track, error) {
+	var response struct {
+		Title      string `json:"title"`
+		AuthorName string `json:"author_name"`
+	}
+	params := url.Values{"format": {"json"}, "url": {trackURL}}
+	if err := c.get(ctx, c.OEmbedURL+"?"+params.Encode(), &response); err != nil {
+		return Track{}, fmt.Errorf("failed to resolve SoundCloud URL: %v", err)
+	}
+
+	// oEmbed titles the track "<title> by <uploader>"
+	title := strings.TrimSuffix(response.Title, " by "+response.AuthorName)
+	return Track{Title: title, Uploader: response.AuthorName}, nil
+}
+
+// get decodes the JSON response to a GET of endpoint into v.
+func (c *Client) get(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("SoundCloud responded with status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid SoundCloud response: %v", err)
+	}
+	return nil
+}
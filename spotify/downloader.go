@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"song-recognition/artwork"
+	"song-recognition/classify"
+	"song-recognition/config"
 	"song-recognition/db"
+	"song-recognition/decode"
+	"song-recognition/musicbrainz"
 	"song-recognition/shazam"
+	"song-recognition/song"
 	"song-recognition/utils"
 	"song-recognition/wav"
 	"strings"
@@ -19,7 +24,6 @@ import (
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/kkdai/youtube/v2"
 	"github.com/mdobak/go-xerrors"
 )
 
@@ -27,7 +31,7 @@ const DELETE_SONG_FILE = false
 
 var yellow = color.New(color.FgYellow)
 
-func DlSingleTrack(url, savePath string) (int, error) {
+func DlSingleTrack(ctx context.Context, url, savePath string) (int, error) {
 	trackInfo, err := TrackInfo(url)
 	if err != nil {
 		return 0, err
@@ -38,7 +42,7 @@ func DlSingleTrack(url, savePath string) (int, error) {
 	track := []Track{*trackInfo}
 
 	fmt.Println("Now, downloading track...")
-	totalTracksDownloaded, err := dlTrack(track, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, track, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -46,7 +50,7 @@ func DlSingleTrack(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func DlPlaylist(url, savePath string) (int, error) {
+func DlPlaylist(ctx context.Context, url, savePath string) (int, error) {
 	tracks, err := PlaylistInfo(url)
 	if err != nil {
 		return 0, err
@@ -54,7 +58,7 @@ func DlPlaylist(url, savePath string) (int, error) {
 
 	time.Sleep(1 * time.Second)
 	fmt.Println("Now, downloading playlist...")
-	totalTracksDownloaded, err := dlTrack(tracks, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, tracks, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -62,7 +66,7 @@ func DlPlaylist(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func DlAlbum(url, savePath string) (int, error) {
+func DlAlbum(ctx context.Context, url, savePath string) (int, error) {
 	tracks, err := AlbumInfo(url)
 	if err != nil {
 		return 0, err
@@ -70,7 +74,7 @@ func DlAlbum(url, savePath string) (int, error) {
 
 	time.Sleep(1 * time.Second)
 	fmt.Println("Now, downloading album...")
-	totalTracksDownloaded, err := dlTrack(tracks, savePath)
+	totalTracksDownloaded, err := dlTrack(ctx, tracks, savePath)
 	if err != nil {
 		return 0, err
 	}
@@ -78,7 +82,7 @@ func DlAlbum(url, savePath string) (int, error) {
 	return totalTracksDownloaded, nil
 }
 
-func dlTrack(tracks []Track, path string) (int, error) {
+func dlTrack(ctx context.Context, tracks []Track, path string) (int, error) {
 	var wg sync.WaitGroup
 	var downloadedTracks []string
 	var totalTracks int
@@ -87,8 +91,6 @@ func dlTrack(tracks []Track, path string) (int, error) {
 	numCPUs := runtime.NumCPU()
 	semaphore := make(chan struct{}, numCPUs)
 
-	ctx := context.Background()
-
 	db, err := db.NewDBClient()
 	if err != nil {
 		return 0, err
@@ -104,6 +106,10 @@ func dlTrack(tracks []Track, path string) (int, error) {
 				<-semaphore
 			}()
 
+			if ctx.Err() != nil {
+				return
+			}
+
 			trackCopy := &Track{
 				Album:    track.Album,
 				Artist:   track.Artist,
@@ -113,7 +119,7 @@ func dlTrack(tracks []Track, path string) (int, error) {
 			}
 
 			// check if song exists
-			keyExists, err := SongKeyExists(utils.GenerateSongKey(trackCopy.Title, trackCopy.Artist))
+			keyExists, err := SongKeyExists(ctx, utils.GenerateSongKey(trackCopy.Title, trackCopy.Artist))
 			if err != nil {
 				err := xerrors.New(err)
 				logger.ErrorContext(ctx, "error checking song existence", slog.Any("error", err))
@@ -124,25 +130,24 @@ func dlTrack(tracks []Track, path string) (int, error) {
 				return
 			}
 
-			ytID, err := getYTID(trackCopy)
+			ytID, err := getYTID(ctx, trackCopy)
 			if ytID == "" || err != nil {
 				logMessage := fmt.Sprintf("'%s' by '%s' could not be downloaded", trackCopy.Title, trackCopy.Artist)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
 				return
 			}
 
-			trackCopy.Title, trackCopy.Artist = correctFilename(trackCopy.Title, trackCopy.Artist)
-			fileName := fmt.Sprintf("%s - %s", trackCopy.Title, trackCopy.Artist)
+			fileName := utils.SafeFilename(fmt.Sprintf("%s - %s", trackCopy.Title, trackCopy.Artist))
 			filePath := filepath.Join(path, fileName+".m4a")
 
-			err = downloadYTaudio(ytID, path, filePath)
+			err = downloadYTaudio(ctx, ytID, path, filePath)
 			if err != nil {
 				logMessage := fmt.Sprintf("'%s' by '%s' could not be downloaded", trackCopy.Title, trackCopy.Artist)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
 				return
 			}
 
-			err = ProcessAndSaveSong(filePath, trackCopy.Title, trackCopy.Artist, ytID)
+			err = ProcessAndSaveSong(ctx, filePath, trackCopy.Title, trackCopy.Artist, ytID)
 			if err != nil {
 				logMessage := fmt.Sprintf("Failed to process song ('%s' by '%s')", trackCopy.Title, trackCopy.Artist)
 				logger.ErrorContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
@@ -185,7 +190,7 @@ func dlTrack(tracks []Track, path string) (int, error) {
 }
 
 /* github.com/kkdai/youtube */
-func downloadYTaudio(id, path, filePath string) error {
+func downloadYTaudio(ctx context.Context, id, path, filePath string) error {
 	dir, err := os.Stat(path)
 	if err != nil {
 		panic(err)
@@ -195,44 +200,7 @@ func downloadYTaudio(id, path, filePath string) error {
 		return errors.New("the path is not valid (not a dir)")
 	}
 
-	client := youtube.Client{}
-	video, err := client.GetVideo(id)
-	if err != nil {
-		return err
-	}
-
-	/*
-		itag code: 140, container: m4a, content: audio, bitrate: 128k
-		change the FindByItag parameter to 139 if you want smaller files (but with a bitrate of 48k)
-		https://gist.github.com/sidneys/7095afe4da4ae58694d128b1034e01e2
-	*/
-	formats := video.Formats.Itag(140)
-
-	/* in some cases, when attempting to download the audio
-	using the library github.com/kkdai/youtube,
-	the download fails (and shows the file size as 0 bytes)
-	until the second or third attempt. */
-	var fileSize int64
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-
-	for fileSize == 0 {
-		stream, _, err := client.GetStream(video, &formats[0])
-		if err != nil {
-			return err
-		}
-
-		if _, err = io.Copy(file, stream); err != nil {
-			return err
-		}
-
-		fileSize, _ = GetFileSize(filePath)
-	}
-	defer file.Close()
-
-	return nil
+	return song.DownloadYouTubeAudio(ctx, id, filePath)
 }
 
 func addTags(file string, track Track) error {
@@ -269,59 +237,166 @@ func addTags(file string, track Track) error {
 	return nil
 }
 
-func ProcessAndSaveSong(songFilePath, songTitle, songArtist, ytID string) error {
+func ProcessAndSaveSong(ctx context.Context, songFilePath, songTitle, songArtist, ytID string) error {
 	dbclient, err := db.NewDBClient()
 	if err != nil {
 		return err
 	}
 	defer dbclient.Close()
 
-	wavFilePath, err := wav.ConvertToWAV(songFilePath, 1)
+	probe, err := decode.ProbeFile(ctx, songFilePath)
+	if err != nil {
+		return fmt.Errorf("error probing audio: %w", err)
+	}
+
+	audio, err := decode.DecodeFile(ctx, songFilePath)
 	if err != nil {
 		return err
 	}
+	if err := probe.Measure(audio); err != nil {
+		return fmt.Errorf("error probing audio: %w", err)
+	}
+
+	pcm, err := utils.FloatsToBytes(audio.Samples, 16)
+	if err != nil {
+		return fmt.Errorf("error converting samples to PCM: %v", err)
+	}
 
-	wavInfo, err := wav.ReadWavInfo(wavFilePath)
+	// Keep a mono WAV copy next to the source file
+	wavFilePath := strings.TrimSuffix(songFilePath, filepath.Ext(songFilePath)) + ".wav"
+	err = wav.WriteWavFile(wavFilePath, pcm, audio.SampleRate, 1, 16)
 	if err != nil {
 		return err
 	}
 
-	samples, err := wav.WavBytesToSamples(wavInfo.Data)
+	checksum, err := utils.AudioChecksum(audio.Samples)
 	if err != nil {
-		return fmt.Errorf("error converting wav bytes to float64: %v", err)
+		return fmt.Errorf("error computing audio checksum: %v", err)
+	}
+
+	existing, exists, err := dbclient.GetSongByChecksum(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("'%v' by '%v' is already registered as '%v' by '%v'\n", songTitle, songArtist, existing.Title, existing.Artist)
+		return nil
 	}
 
-	spectro, err := shazam.Spectrogram(samples, wavInfo.SampleRate)
+	spectro, err := shazam.Spectrogram(shazam.NormalizeSong(audio.Samples, audio.SampleRate), audio.SampleRate)
 	if err != nil {
 		return fmt.Errorf("error creating spectrogram: %v", err)
 	}
 
-	songID, err := dbclient.RegisterSong(songTitle, songArtist, ytID)
+	songID, err := dbclient.RegisterSong(ctx, songTitle, songArtist, ytID, checksum)
 	if err != nil {
 		return err
 	}
 
-	peaks := shazam.ExtractPeaks(spectro, wavInfo.Duration)
+	songAudio := db.SongAudio{Codec: probe.Codec, Duration: probe.Duration, Bitrate: probe.Bitrate}
+	if err := dbclient.SetSongAudio(ctx, songID, songAudio); err != nil {
+		dbclient.DeleteSongByID(ctx, songID)
+		return err
+	}
+
+	if tempo := shazam.Tempo(spectro, audio.SampleRate); tempo > 0 {
+		if err := dbclient.SetSongTempo(ctx, songID, tempo); err != nil {
+			dbclient.DeleteSongByID(ctx, songID)
+			return err
+		}
+	}
+
+	if musicalKey := shazam.Key(spectro, audio.SampleRate); musicalKey != "" {
+		if err := dbclient.SetSongMusicalKey(ctx, songID, musicalKey); err != nil {
+			dbclient.DeleteSongByID(ctx, songID)
+			return err
+		}
+	}
+
+	if tags := classify.Classify(ctx, audio.Samples, audio.SampleRate); len(tags) > 0 {
+		if err := dbclient.SetSongTags(ctx, songID, tags); err != nil {
+			dbclient.DeleteSongByID(ctx, songID)
+			return err
+		}
+	}
+
+	metadata, found := musicbrainz.Metadata(ctx, songTitle, songArtist, "")
+	if found {
+		if err := dbclient.SetSongMetadata(ctx, songID, metadata); err != nil {
+			dbclient.DeleteSongByID(ctx, songID)
+			return err
+		}
+	}
+
+	artwork.Store(ctx, songID, songTitle, songArtist, metadata.MusicBrainzReleaseID)
+
+	peaks := shazam.ExtractPeaks(spectro, audio.Duration)
 	fingerprints := shazam.Fingerprint(peaks, songID)
 
-	err = dbclient.StoreFingerprints(fingerprints)
+	err = dbclient.StoreFingerprints(ctx, fingerprints, shazam.FingerprintVersion())
 	if err != nil {
-		dbclient.DeleteSongByID(songID)
+		dbclient.DeleteFingerprintsBySongID(ctx, songID)
+		dbclient.DeleteSongByID(ctx, songID)
+		artwork.Remove(songID)
 		return fmt.Errorf("error to storing fingerprint: %v", err)
 	}
 
+	if config.Get().LSH.Enabled {
+		if err := shazam.IndexLSH(ctx, dbclient, songID); err != nil {
+			dbclient.DeleteLSHBands(ctx, songID)
+			dbclient.DeleteFingerprintsBySongID(ctx, songID)
+			dbclient.DeleteSongByID(ctx, songID)
+			artwork.Remove(songID)
+			return fmt.Errorf("error indexing song: %v", err)
+		}
+	}
+
+	contour, err := shazam.MelodyContour(audio.Samples, audio.SampleRate)
+	if err == nil {
+		err = dbclient.StoreMelody(ctx, songID, contour)
+	}
+	if err != nil {
+		dbclient.DeleteMelody(ctx, songID)
+		dbclient.DeleteLSHBands(ctx, songID)
+		dbclient.DeleteFingerprintsBySongID(ctx, songID)
+		dbclient.DeleteSongByID(ctx, songID)
+		artwork.Remove(songID)
+		return fmt.Errorf("error storing melody: %v", err)
+	}
+
+	err = dbclient.StoreWaveform(ctx, songID, shazam.Waveform(audio.Samples, shazam.WaveformPoints))
+	if err != nil {
+		dbclient.DeleteWaveform(ctx, songID)
+		dbclient.DeleteMelody(ctx, songID)
+		dbclient.DeleteLSHBands(ctx, songID)
+		dbclient.DeleteFingerprintsBySongID(ctx, songID)
+		dbclient.DeleteSongByID(ctx, songID)
+		artwork.Remove(songID)
+		return fmt.Errorf("error storing waveform: %v", err)
+	}
+
+	info := wav.Info{
+		Title:  songTitle,
+		Artist: songArtist,
+		Source: "https://www.youtube.com/watch?v=" + ytID,
+		SongID: songID,
+	}
+	if err := wav.WriteInfo(wavFilePath, info); err != nil {
+		utils.GetLogger().WarnContext(ctx, "Failed to write song metadata to WAV file", slog.Any("error", xerrors.New(err)))
+	}
+
 	fmt.Printf("Fingerprint for %v by %v saved in DB successfully\n", songTitle, songArtist)
 	return nil
 }
 
-func getYTID(trackCopy *Track) (string, error) {
+func getYTID(ctx context.Context, trackCopy *Track) (string, error) {
 	ytID, err := GetYoutubeId(*trackCopy)
 	if ytID == "" || err != nil {
 		return "", err
 	}
 
 	// Check if YouTube ID exists
-	ytidExists, err := YtIDExists(ytID)
+	ytidExists, err := YtIDExists(ctx, ytID)
 	if err != nil {
 		return "", fmt.Errorf("error checking YT ID existence: %v", err)
 	}
@@ -336,7 +411,7 @@ func getYTID(trackCopy *Track) (string, error) {
 			return "", err
 		}
 
-		ytidExists, err = YtIDExists(ytID)
+		ytidExists, err = YtIDExists(ctx, ytID)
 		if err != nil {
 			return "", fmt.Errorf("error checking YT ID existence: %v", err)
 		}
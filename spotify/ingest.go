@@ -0,0 +1,153 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"song-recognition/db"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"strconv"
+	"sync"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// IngestOptions tune how IngestPlaylist finds the audio of tracks.
+type IngestOptions struct {
+	// Workers is the number of tracks processed at once.
+	Workers int
+	// Previews registers the 30 second previews Spotify has of tracks
+	// instead of their full audio matched on YouTube. Either source is
+	// tried when the other is missing.
+	Previews bool
+}
+
+// IngestPlaylist registers every track of a Spotify playlist, listed with
+// api. The audio of each is found on YouTube, or is its Spotify preview,
+// and the album and release year Spotify has fill in what MusicBrainz
+// doesn't. One result is returned per track, in playlist order; tracks
+// registered before are reported as such without downloading them again.
+func IngestPlaylist(ctx context.Context, api *WebAPI, playlist string, opts IngestOptions) ([]song.BatchResult, error) {
+	tracks, err := api.PlaylistTracks(ctx, playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.CreateFolder("tmp"); err != nil {
+		return nil, fmt.Errorf("failed to create tmp directory: %v", err)
+	}
+
+	pool, err := song.NewPool(opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	results := make([]song.BatchResult, len(tracks))
+	semaphore := make(chan struct{}, pool.Workers())
+	var wg sync.WaitGroup
+
+	for i := range tracks {
+		results[i] = song.BatchResult{Index: i, Title: tracks[i].Title, Artist: tracks[i].Artist}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			response, err := ingestTrack(ctx, pool, dbClient, tracks[i], opts.Previews)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Response = response
+		}(i)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// ingestTrack registers one track of a playlist.
+func ingestTrack(ctx context.Context, pool *song.Pool, dbClient db.DBClient, track PlaylistTrack, previews bool) (*song.ProcessResponse, error) {
+	existing, exists, err := dbClient.GetSongByKey(ctx, utils.GenerateSongKey(track.Title, track.Artist))
+	if err != nil {
+		return nil, fmt.Errorf("error checking song existence: %v", err)
+	}
+	if exists {
+		return &song.ProcessResponse{
+			Success:           true,
+			Message:           "Song already registered",
+			FingerprintID:     strconv.FormatUint(uint64(existing.ID), 10),
+			AlreadyRegistered: true,
+		}, nil
+	}
+
+	var response *song.ProcessResponse
+	if previews && track.PreviewURL != "" {
+		response, err = ingestPreview(ctx, pool, track)
+	} else {
+		response, err = ingestFromYouTube(ctx, pool, track)
+		if err != nil && track.PreviewURL != "" {
+			logger := utils.GetLogger()
+			logMessage := fmt.Sprintf("'%s' by '%s' could not be found on YouTube, using its preview", track.Title, track.Artist)
+			logger.WarnContext(ctx, logMessage, slog.Any("error", xerrors.New(err)))
+			response, err = ingestPreview(ctx, pool, track)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.AlreadyRegistered {
+		song.SetAlbum(ctx, dbClient, response, track.Album, track.ReleaseYear)
+	}
+
+	return response, nil
+}
+
+// ingestPreview registers the Spotify preview of track.
+func ingestPreview(ctx context.Context, pool *song.Pool, track PlaylistTrack) (*song.ProcessResponse, error) {
+	return pool.Process(ctx, &song.SongInput{SongURL: track.PreviewURL, Title: track.Title, Artist: track.Artist})
+}
+
+// ingestFromYouTube registers the audio of the YouTube video that best
+// matches track.
+func ingestFromYouTube(ctx context.Context, pool *song.Pool, track PlaylistTrack) (*song.ProcessResponse, error) {
+	ytID, err := GetYoutubeId(track.Track)
+	if err != nil {
+		return nil, fmt.Errorf("error searching YouTube: %v", err)
+	}
+	if ytID == "" {
+		return nil, fmt.Errorf("no YouTube video matches '%s' by '%s'", track.Title, track.Artist)
+	}
+
+	file, err := os.CreateTemp("tmp", "*.m4a")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := downloadYTaudio(ctx, ytID, "tmp", file.Name()); err != nil {
+		return nil, fmt.Errorf("error downloading YouTube audio: %v", err)
+	}
+
+	return pool.ProcessFile(ctx, file.Name(), &song.SongInput{Title: track.Title, Artist: track.Artist, YoutubeID: ytID})
+}
@@ -1,13 +1,13 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"song-recognition/db"
 	"strings"
 )
@@ -39,14 +39,14 @@ func GetFileSize(file string) (int64, error) {
 	return size, nil
 }
 
-func SongKeyExists(key string) (bool, error) {
+func SongKeyExists(ctx context.Context, key string) (bool, error) {
 	db, err := db.NewDBClient()
 	if err != nil {
 		return false, err
 	}
 	defer db.Close()
 
-	_, songExists, err := db.GetSongByKey(key)
+	_, songExists, err := db.GetSongByKey(ctx, key)
 	if err != nil {
 		return false, err
 	}
@@ -54,14 +54,14 @@ func SongKeyExists(key string) (bool, error) {
 	return songExists, nil
 }
 
-func YtIDExists(ytID string) (bool, error) {
+func YtIDExists(ctx context.Context, ytID string) (bool, error) {
 	db, err := db.NewDBClient()
 	if err != nil {
 		return false, err
 	}
 	defer db.Close()
 
-	_, songExits, err := db.GetSongByYTID(ytID)
+	_, songExits, err := db.GetSongByYTID(ctx, ytID)
 	if err != nil {
 		return false, err
 	}
@@ -69,22 +69,6 @@ func YtIDExists(ytID string) (bool, error) {
 	return songExits, nil
 }
 
-/* fixes some invalid file names (windows is the capricious one) */
-func correctFilename(title, artist string) (string, string) {
-	if runtime.GOOS == "windows" {
-		invalidChars := []byte{'<', '>', '<', ':', '"', '\\', '/', '|', '?', '*'}
-		for _, invalidChar := range invalidChars {
-			title = strings.ReplaceAll(title, string(invalidChar), "")
-			artist = strings.ReplaceAll(artist, string(invalidChar), "")
-		}
-	} else {
-		title = strings.ReplaceAll(title, "/", "\\")
-		artist = strings.ReplaceAll(artist, "/", "\\")
-	}
-
-	return title, artist
-}
-
 func convertStereoToMono(stereoFilePath string) ([]byte, error) {
 	fileExt := filepath.Ext(stereoFilePath)
 	monoFilePath := strings.TrimSuffix(stereoFilePath, fileExt) + "_mono" + fileExt
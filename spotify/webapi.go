@@ -0,0 +1,243 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebAPI reads playlists with the official Spotify Web API, signed in as an
+// app with the client credentials of developer.spotify.com.
+type WebAPI struct {
+	ClientID     string
+	ClientSecret string
+	// APIURL and TokenURL are those of Spotify, and overridable for tests.
+	APIURL   string
+	TokenURL string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewWebAPI returns a client of the Spotify Web API signed in with the
+// given app credentials.
+func NewWebAPI(clientID, clientSecret string) *WebAPI {
+	return &WebAPI{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		APIURL:       "https://api.spotify.com/v1",
+		TokenURL:     "https://accounts.spotify.com/api/token",
+		Client:       &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// PlaylistTrack is a track of a playlist as the Web API describes it.
+type PlaylistTrack struct {
+	Track
+	SpotifyID string
+	// PreviewURL is a 30 second MP3 of the track, if Spotify has one.
+	PreviewURL  string
+	ReleaseYear int
+}
+
+// maxRateLimitRetries is how many times a rate limited request is retried,
+// after the wait Spotify asks for.
+const maxRateLimitRetries = 3
+
+var playlistIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]{22}$`)
+
+// PlaylistID extracts the ID of a playlist from its open.spotify.com URL,
+// its spotify:playlist: URI or the ID itself.
+func PlaylistID(playlist string) (string, error) {
+	id := playlist
+	if rest, ok := strings.CutPrefix(playlist, "spotify:playlist:"); ok {
+		id = rest
+	} else if u, err := url.Parse(playlist); err == nil && u.Host != "" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) < 2 || parts[len(parts)-2] != "playlist" {
+			return "", fmt.Errorf("not a Spotify playlist URL: %q", playlist)
+		}
+		id = parts[len(parts)-1]
+	}
+
+	if !playlistIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid Spotify playlist ID: %q", id)
+	}
+	return id, nil
+}
+
+// playlistPage is a page of the tracks of a playlist.
+type playlistPage struct {
+	Next  string `json:"next"`
+	Items []struct {
+		IsLocal bool `json:"is_local"`
+		Track   *struct {
+			Type       string `json:"type"`
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			DurationMs int    `json:"duration_ms"`
+			PreviewURL string `json:"preview_url"`
+			Artists    []struct {
+				Name string `json:"name"`
+			} `json:"artists"`
+			Album struct {
+				Name        string `json:"name"`
+				ReleaseDate string `json:"release_date"`
+			} `json:"album"`
+		} `json:"track"`
+	} `json:"items"`
+}
+
+// PlaylistTracks lists the tracks of a playlist, given as PlaylistID
+// accepts it. Local files and podcast episodes are left out.
+func (api *WebAPI) PlaylistTracks(ctx context.Context, playlist string) ([]PlaylistTrack, error) {
+	id, err := PlaylistID(playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"limit":  {"100"},
+		"fields": {"next,items(is_local,track(type,id,name,duration_ms,preview_url,artists(name),album(name,release_date)))"},
+	}
+	next := fmt.Sprintf("%s/playlists/%s/tracks?%s", api.APIURL, id, query.Encode())
+
+	var tracks []PlaylistTrack
+	for next != "" {
+		var page playlistPage
+		if err := api.get(ctx, next, &page); err != nil {
+			return nil, fmt.Errorf("error listing playlist tracks: %v", err)
+		}
+
+		for _, item := range page.Items {
+			if item.IsLocal || item.Track == nil || item.Track.Type != "track" {
+				continue
+			}
+
+			track := PlaylistTrack{
+				Track: Track{
+					Title:    item.Track.Name,
+					Album:    item.Track.Album.Name,
+					Duration: item.Track.DurationMs / 1000,
+				},
+				SpotifyID:  item.Track.ID,
+				PreviewURL: item.Track.PreviewURL,
+			}
+			for _, artist := range item.Track.Artists {
+				track.Artists = append(track.Artists, artist.Name)
+			}
+			if len(track.Artists) > 0 {
+				track.Artist = track.Artists[0]
+			}
+			// Release dates are a year, a month or a day
+			if len(item.Track.Album.ReleaseDate) >= 4 {
+				track.ReleaseYear, _ = strconv.Atoi(item.Track.Album.ReleaseDate[:4])
+			}
+
+			tracks = append(tracks, track)
+		}
+
+		next = page.Next
+	}
+
+	return tracks, nil
+}
+
+// get decodes the JSON response of the Web API to a GET of endpoint into v.
+func (api *WebAPI) get(ctx context.Context, endpoint string, v any) error {
+	for attempt := 0; ; attempt++ {
+		token, err := api.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := api.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitRetries:
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0:
+			// The token was revoked or expired early
+			api.mu.Lock()
+			api.token = ""
+			api.mu.Unlock()
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		}
+
+		return json.Unmarshal(body, v)
+	}
+}
+
+// accessToken returns a token of the app, asking for a new one when the
+// last has expired.
+func (api *WebAPI) accessToken(ctx context.Context) (string, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.token != "" && time.Now().Before(api.expires) {
+		return api.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(api.ClientID, api.ClientSecret)
+
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get access token: received status code %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %v", err)
+	}
+
+	// Renew a minute early so no request carries an expiring token
+	api.token = token.AccessToken
+	api.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return api.token, nil
+}
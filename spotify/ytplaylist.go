@@ -0,0 +1,223 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"song-recognition/db"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"strconv"
+	"sync"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/mdobak/go-xerrors"
+)
+
+// YouTubeIngestOptions tune IngestYouTubePlaylist.
+type YouTubeIngestOptions struct {
+	// Workers is the number of videos processed at once.
+	Workers int
+	// StatePath is the file the progress of the ingestion is saved to,
+	// tmp/youtube-<playlist ID>.json by default.
+	StatePath string
+}
+
+// IngestYouTubePlaylist downloads the audio of every video of a YouTube
+// playlist and registers it under the video's ID. Its progress is saved
+// after every video, so running it again after a crash or an interrupt
+// resumes with the videos not yet registered, retrying those that failed.
+// Videos whose ID is already registered are skipped too. One result is
+// returned per video, in playlist order.
+func IngestYouTubePlaylist(ctx context.Context, playlistURL string, opts YouTubeIngestOptions) ([]song.BatchResult, error) {
+	client := youtube.Client{}
+	playlist, err := client.GetPlaylistContext(ctx, playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting playlist: %v", err)
+	}
+
+	if err := utils.CreateFolder("tmp"); err != nil {
+		return nil, fmt.Errorf("failed to create tmp directory: %v", err)
+	}
+
+	statePath := opts.StatePath
+	if statePath == "" {
+		statePath = filepath.Join("tmp", "youtube-"+playlist.ID+".json")
+	}
+	state, err := loadIngestState(statePath, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := song.NewPool(opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	results := make([]song.BatchResult, len(playlist.Videos))
+	semaphore := make(chan struct{}, pool.Workers())
+	var wg sync.WaitGroup
+
+	for i, video := range playlist.Videos {
+		title, artist := song.TitleArtistFromVideo(video.Title, video.Author)
+		results[i] = song.BatchResult{Index: i, Title: title, Artist: artist}
+
+		if fingerprintID, ok := state.done(video.ID); ok {
+			results[i].Response = &song.ProcessResponse{
+				Success:           true,
+				Message:           "Song already registered",
+				FingerprintID:     fingerprintID,
+				AlreadyRegistered: true,
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, video *youtube.PlaylistEntry) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+			}()
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			response, err := ingestVideo(ctx, pool, dbClient, video.ID, results[i].Title, results[i].Artist)
+			// An interrupted video is neither done nor failed
+			if ctx.Err() == nil {
+				if saveErr := state.record(video.ID, response, err); saveErr != nil {
+					logger := utils.GetLogger()
+					logger.ErrorContext(ctx, "Error saving playlist progress", slog.Any("error", xerrors.New(saveErr)))
+				}
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Response = response
+		}(i, video)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// ingestVideo registers the audio of the YouTube video ytID.
+func ingestVideo(ctx context.Context, pool *song.Pool, dbClient db.DBClient, ytID, title, artist string) (*song.ProcessResponse, error) {
+	existing, exists, err := dbClient.GetSongByYTID(ctx, ytID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking YT ID existence: %v", err)
+	}
+	if exists {
+		return &song.ProcessResponse{
+			Success:           true,
+			Message:           "Song already registered",
+			FingerprintID:     strconv.FormatUint(uint64(existing.ID), 10),
+			AlreadyRegistered: true,
+		}, nil
+	}
+
+	file, err := os.CreateTemp("tmp", "*.m4a")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := downloadYTaudio(ctx, ytID, "tmp", file.Name()); err != nil {
+		return nil, fmt.Errorf("error downloading YouTube audio: %v", err)
+	}
+
+	return pool.ProcessFile(ctx, file.Name(), &song.SongInput{Title: title, Artist: artist, YoutubeID: ytID})
+}
+
+// ingestState is the progress of a playlist ingestion, kept in a file.
+type ingestState struct {
+	PlaylistID string `json:"playlist_id"`
+	// Done maps the videos registered to their fingerprint IDs, and
+	// Failed those that failed to their last error.
+	Done   map[string]string `json:"done"`
+	Failed map[string]string `json:"failed"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// loadIngestState reads the progress saved at path, or starts anew if
+// there is none.
+func loadIngestState(path, playlistID string) (*ingestState, error) {
+	state := &ingestState{PlaylistID: playlistID, Done: map[string]string{}, Failed: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		state.path = path
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist progress: %v", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist progress %s: %v", path, err)
+	}
+	if state.PlaylistID != playlistID {
+		return nil, fmt.Errorf("%s is the progress of playlist %s, not %s", path, state.PlaylistID, playlistID)
+	}
+	if state.Done == nil {
+		state.Done = map[string]string{}
+	}
+	if state.Failed == nil {
+		state.Failed = map[string]string{}
+	}
+	state.path = path
+
+	return state, nil
+}
+
+// done returns the fingerprint ID of videoID if it was registered.
+func (s *ingestState) done(videoID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fingerprintID, ok := s.Done[videoID]
+	return fingerprintID, ok
+}
+
+// record saves the outcome of ingesting videoID.
+func (s *ingestState) record(videoID string, response *song.ProcessResponse, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.Failed[videoID] = err.Error()
+	} else {
+		delete(s.Failed, videoID)
+		s.Done[videoID] = response.FingerprintID
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Written next to the file first so a crash never leaves half of it
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"song-recognition/db"
+	"song-recognition/monitor"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"strings"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// sseHeartbeat keeps idle event streams from being closed by proxies.
+const sseHeartbeat = 15 * time.Second
+
+// handleJobEvents serves GET /songs/{jobID}/events as a server-sent events
+// stream of the job's stage transitions and percent complete. The stream
+// starts with the job's current status and ends once the job is done or
+// has failed for good.
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	logger := utils.GetLogger()
+	ctx := r.Context()
+
+	jobID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/songs/"), "/events")
+	if !ok || jobID == "" || strings.Contains(jobID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	// Subscribe before reading the status so no transition is missed
+	events, unsubscribe := song.SubscribeJob(jobID)
+	defer unsubscribe()
+
+	status, exists, err := song.GetJobStatus(ctx, jobID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get job", slog.Any("error", xerrors.New(err)))
+		writeJSONError(w, http.StatusInternalServerError, "failed to get job")
+		return
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	current := song.JobEvent{JobID: status.ID, Status: status.Status, Error: status.Error}
+	if status.Status == db.JobDone {
+		current.Stage, current.Percent = song.StageDone, 100
+	}
+	if !writeJobEvent(w, flusher, current) || isFinalJobStatus(current.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			if !writeJobEvent(w, flusher, event) || isFinalJobStatus(event.Status) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeJobEvent writes event as an SSE message named after its kind:
+// "progress" while a stage is running and "status" otherwise.
+func writeJobEvent(w http.ResponseWriter, flusher http.Flusher, event song.JobEvent) bool {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+
+	name := "status"
+	if event.Status == db.JobProcessing && event.Stage != "" {
+		name = "progress"
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return false
+	}
+	flusher.Flush()
+
+	return true
+}
+
+func isFinalJobStatus(status string) bool {
+	return status == db.JobDone || status == db.JobFailed
+}
+
+// handleMonitorEvents serves GET /monitor/events as a server-sent events
+// stream of "detection" events, one for every song heard on the monitored
+// streams from the time of the request on. The "stream" parameter narrows
+// them to one stream.
+func handleMonitorEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+
+	events, unsubscribe := monitor.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			if stream != "" && event.Stream != stream {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: detection\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob Storage API requests and shared
+// access signatures are made with.
+const azureVersion = "2021-08-06"
+
+// AzureOptions locate a container of Azure Blob Storage and hold the key of
+// its storage account.
+type AzureOptions struct {
+	// Endpoint is the URL of the account's blob service,
+	// https://<account>.blob.core.windows.net if empty.
+	Endpoint string
+	Account  string
+	// AccountKey is the base64 key of the account.
+	AccountKey string
+	Container  string
+	// Prefix is prepended to every key.
+	Prefix string
+}
+
+// Azure stores files as the block blobs of a container, with requests
+// authorized with the account key.
+type Azure struct {
+	AzureOptions
+	Client *http.Client
+}
+
+// NewAzure returns the storage of the container of opts.
+func NewAzure(opts AzureOptions) *Azure {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://" + opts.Account + ".blob.core.windows.net"
+	}
+	return &Azure{AzureOptions: opts, Client: &http.Client{}}
+}
+
+func (a *Azure) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := a.newRequest(ctx, http.MethodPut, key, body, size, map[string]string{"x-ms-blob-type": "BlockBlob"})
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(a.Client, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (a *Azure) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := a.newRequest(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(a.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (a *Azure) Delete(ctx context.Context, key string) error {
+	req, err := a.newRequest(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(a.Client, req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns a URL of the blob of key with a read-only service
+// shared access signature, see
+// https://learn.microsoft.com/rest/api/storageservices/create-service-sas.
+func (a *Azure) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := checkExpiry(expiry); err != nil {
+		return "", err
+	}
+	u, err := a.blobURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().UTC().Add(expiry).Format(time.RFC3339)
+	resource := "/blob/" + a.Account + "/" + a.Container + "/" + a.Prefix + key
+	// The fields of the signature, of which only the permissions, expiry,
+	// resource, version and resource type are set
+	stringToSign := strings.Join([]string{
+		"r", "", expires, resource, "", "", "", azureVersion, "b", "", "", "", "", "", "", "",
+	}, "\n")
+	signature, err := a.signature(stringToSign)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("sp", "r")
+	query.Set("se", expires)
+	query.Set("sv", azureVersion)
+	query.Set("sr", "b")
+	query.Set("sig", signature)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Location returns the URL of the blob of key.
+func (a *Azure) Location(key string) string {
+	u, err := a.blobURL(key)
+	if err != nil {
+		return a.Container + "/" + a.Prefix + key
+	}
+	return u.String()
+}
+
+// blobURL returns the URL of the blob of key.
+func (a *Azure) blobURL(key string) (*url.URL, error) {
+	u, err := url.Parse(a.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure endpoint: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + a.Container + "/" + a.Prefix + key
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (a *Azure) newRequest(ctx context.Context, method, key string, body io.Reader, size int64, headers map[string]string) (*http.Request, error) {
+	u, err := a.blobURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if body != nil && size == 0 {
+		req.Body = http.NoBody
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if err := a.sign(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// sign adds the Authorization header of the Shared Key scheme to req, see
+// https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key.
+func (a *Azure) sign(req *http.Request) error {
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+
+	var msHeaders []string
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	resource := "/" + a.Account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	h := req.Header
+	stringToSign := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date, sent as x-ms-date instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+	signature, err := a.signature(stringToSign)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "SharedKey "+a.Account+":"+signature)
+	return nil
+}
+
+// signature signs stringToSign with the account key.
+func (a *Azure) signature(stringToSign string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(a.AccountKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid Azure account key: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
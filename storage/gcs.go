@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGCSEndpoint is the URL of the XML API of Cloud Storage.
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+// gcsScope is the OAuth scope of the access tokens of GCS.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSOptions locate a bucket of Cloud Storage and the key of the service
+// account it is reached as.
+type GCSOptions struct {
+	// Endpoint is the URL of Cloud Storage, DefaultGCSEndpoint if empty.
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to every key.
+	Prefix string
+	// CredentialsFile is the JSON key of the service account, the file in
+	// GOOGLE_APPLICATION_CREDENTIALS if empty.
+	CredentialsFile string
+}
+
+// GCS stores files as the objects of a bucket of Cloud Storage, through
+// its XML API, with the access tokens of a service account.
+type GCS struct {
+	GCSOptions
+	Client *http.Client
+
+	keyOnce sync.Once
+	key     *serviceAccountKey
+	keyErr  error
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// serviceAccountKey is the JSON key of a service account.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	signer *rsa.PrivateKey
+}
+
+// NewGCS returns the storage of the bucket of opts. The service account
+// key is read on first use.
+func NewGCS(opts GCSOptions) *GCS {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultGCSEndpoint
+	}
+	if opts.CredentialsFile == "" {
+		opts.CredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	return &GCS{GCSOptions: opts, Client: &http.Client{}}
+}
+
+func (g *GCS) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := g.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	resp, err := send(g.Client, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *GCS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := g.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(g.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	req, err := g.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(g.Client, req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns a URL of the object of key signed with the service
+// account key, see
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually.
+func (g *GCS) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := checkExpiry(expiry); err != nil {
+		return "", err
+	}
+	account, err := g.serviceAccount()
+	if err != nil {
+		return "", err
+	}
+	u, err := g.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", account.ClientEmail+"/"+scope)
+	query.Set("X-Goog-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Goog-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Goog-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "GOOG4-RSA-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signature, err := account.sign([]byte(stringToSign))
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery += "&X-Goog-Signature=" + hex.EncodeToString(signature)
+	return u.String(), nil
+}
+
+// Location returns the gs:// URL of the object of key.
+func (g *GCS) Location(key string) string {
+	return "gs://" + g.Bucket + "/" + g.Prefix + key
+}
+
+// objectURL returns the URL of the object of key.
+func (g *GCS) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(g.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cloud Storage endpoint: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + g.Bucket + "/" + g.Prefix + key
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (g *GCS) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := g.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// serviceAccount reads the service account key, once.
+func (g *GCS) serviceAccount() (*serviceAccountKey, error) {
+	g.keyOnce.Do(func() {
+		if g.CredentialsFile == "" {
+			g.keyErr = errors.New("no Cloud Storage credentials file given, nor GOOGLE_APPLICATION_CREDENTIALS set")
+			return
+		}
+		data, err := os.ReadFile(g.CredentialsFile)
+		if err != nil {
+			g.keyErr = fmt.Errorf("failed to read Cloud Storage credentials: %v", err)
+			return
+		}
+
+		var key serviceAccountKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			g.keyErr = fmt.Errorf("invalid Cloud Storage credentials: %v", err)
+			return
+		}
+		if key.TokenURI == "" {
+			key.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		block, _ := pem.Decode([]byte(key.PrivateKey))
+		if block == nil {
+			g.keyErr = errors.New("invalid Cloud Storage credentials: no PEM private key")
+			return
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+		signer, ok := parsed.(*rsa.PrivateKey)
+		if err != nil || !ok {
+			g.keyErr = errors.New("invalid Cloud Storage credentials: the private key isn't an RSA key")
+			return
+		}
+		key.signer = signer
+		g.key = &key
+	})
+	return g.key, g.keyErr
+}
+
+func (k *serviceAccountKey) sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(nil, k.signer, crypto.SHA256, hash[:])
+}
+
+// accessToken returns an OAuth access token of the service account, asked
+// for with a signed JWT and reused until shortly before it expires.
+func (g *GCS) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+
+	account, err := g.serviceAccount()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature, err := account.sign([]byte(unsigned))
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", unsigned+"."+base64.RawURLEncoding.EncodeToString(signature))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Cloud Storage access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return "", fmt.Errorf("failed to get Cloud Storage access token: status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid Cloud Storage access token: %v", err)
+	}
+	g.token = token.AccessToken
+	g.expires = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/quota"
+	"song-recognition/utils"
+	"time"
+)
+
+// Local stores files in a directory. Keys that would reach outside of it,
+// such as "../x", are refused with utils.ErrUnsafePath.
+type Local struct {
+	Dir string
+	// Quota caps the size of Dir.
+	Quota config.Quota
+}
+
+// NewLocal returns the storage of the directory dir, which is created on
+// the first Put.
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+// Put writes body next to the file of key first, so a failed write never
+// leaves a truncated file in place of a good one.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	path, err := utils.SafeJoin(l.Dir, key)
+	if err != nil {
+		return err
+	}
+	if err := quota.MakeRoom(ctx, l.Dir, l.Quota, size); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := utils.SafeJoin(l.Dir, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := utils.SafeJoin(l.Dir, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: local storage has no signed URLs", errors.ErrUnsupported)
+}
+
+// Location returns the path of the file of key.
+func (l *Local) Location(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(key))
+}
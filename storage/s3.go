@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options locate a bucket of S3 or an S3-compatible store and hold the
+// access key it is reached with.
+type S3Options struct {
+	// Endpoint is the URL of the store, that of the AWS region by default.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every key, such as "songs/".
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken goes with temporary access keys.
+	SessionToken string
+	// PathStyle addresses the bucket in the path of URLs, as MinIO and
+	// most other S3-compatible stores expect, rather than in their host.
+	PathStyle bool
+}
+
+// S3 stores files as the objects of a bucket, with requests signed with
+// AWS Signature Version 4.
+type S3 struct {
+	S3Options
+	Client *http.Client
+}
+
+// NewS3 returns the storage of the bucket of opts.
+func NewS3(opts S3Options) *S3 {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	return &S3{S3Options: opts, Client: &http.Client{}}
+}
+
+// Put uploads body without hashing it, which S3 allows, so it is streamed
+// rather than read twice.
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	resp, err := send(s.Client, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(s.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := send(s.Client, req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns a presigned URL of the object of key, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html.
+func (s *S3) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return s.signedURL(key, expiry, time.Now().UTC())
+}
+
+// signedURL is SignedURL signing at now.
+func (s *S3) signedURL(key string, expiry time.Duration, now time.Time) (string, error) {
+	if err := checkExpiry(expiry); err != nil {
+		return "", err
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	signature := s.signature(now, http.MethodGet, u, "host:"+u.Host+"\n", "host")
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// Location returns the s3:// URL of the object of key.
+func (s *S3) Location(key string) string {
+	return "s3://" + s.Bucket + "/" + s.Prefix + key
+}
+
+// objectURL returns the URL of the object of key.
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+
+	path := "/" + s.Prefix + key
+	if s.PathStyle {
+		path = "/" + s.Bucket + path
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (s *S3) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds the Authorization header of Signature Version 4 to req, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	signature := s.signature(now, req.Method, req.URL, canonicalHeaders.String(), signedHeaders)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signature signs the request of method to u, whose signed headers are
+// canonicalized in canonicalHeaders and named in signedHeaders, at now.
+func (s *S3) signature(now time.Time, method string, u *url.URL, canonicalHeaders, signedHeaders string) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(u.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope is the credential scope of the signatures made at now.
+func (s *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,159 @@
+// Package storage keeps the audio files of songs, in a local directory or
+// in a bucket several servers can share: of S3 or an S3-compatible object
+// store such as MinIO, of Google Cloud Storage or of Azure Blob Storage.
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"song-recognition/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get for keys nothing is stored under.
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores files under keys, such as "Title_Artist.wav".
+type Storage interface {
+	// Put stores the size bytes of body under key, replacing the file
+	// stored under it, if any.
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	// Get returns the file stored under key, which the caller closes.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the file stored under key. Keys nothing is stored
+	// under are not an error.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL the file under key can be downloaded from
+	// without credentials until expiry has passed, at most
+	// MaxSignedURLExpiry. Local storage has none, and returns an error
+	// wrapping errors.ErrUnsupported.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// Location describes where the file under key is stored, such as its
+	// path or s3:// URL.
+	Location(key string) string
+}
+
+// MaxSignedURLExpiry is the longest S3 and Cloud Storage accept signed URLs
+// for.
+const MaxSignedURLExpiry = 7 * 24 * time.Hour
+
+var (
+	defaultOnce    sync.Once
+	defaultStorage Storage
+)
+
+// Default returns the storage of the config file.
+func Default() Storage {
+	defaultOnce.Do(func() {
+		cfg := config.Get().Storage
+		switch cfg.Backend {
+		case config.StorageS3:
+			defaultStorage = NewS3(S3Options{
+				Endpoint:        cfg.S3.Endpoint,
+				Region:          cfg.S3.Region,
+				Bucket:          cfg.S3.Bucket,
+				Prefix:          cfg.S3.Prefix,
+				AccessKeyID:     os.ExpandEnv(cfg.S3.AccessKeyID),
+				SecretAccessKey: os.ExpandEnv(cfg.S3.SecretAccessKey),
+				SessionToken:    os.ExpandEnv(cfg.S3.SessionToken),
+				PathStyle:       cfg.S3.PathStyle,
+			})
+		case config.StorageGCS:
+			defaultStorage = NewGCS(GCSOptions{
+				Endpoint:        cfg.GCS.Endpoint,
+				Bucket:          cfg.GCS.Bucket,
+				Prefix:          cfg.GCS.Prefix,
+				CredentialsFile: cfg.GCS.CredentialsFile,
+			})
+		case config.StorageAzure:
+			defaultStorage = NewAzure(AzureOptions{
+				Endpoint:   cfg.Azure.Endpoint,
+				Account:    cfg.Azure.Account,
+				AccountKey: os.ExpandEnv(cfg.Azure.AccountKey),
+				Container:  cfg.Azure.Container,
+				Prefix:     cfg.Azure.Prefix,
+			})
+		default:
+			local := NewLocal(cfg.Dir)
+			local.Quota = config.Get().Quotas.Songs
+			defaultStorage = local
+		}
+	})
+	return defaultStorage
+}
+
+// xmlError is the body of the error responses of S3, Cloud Storage and
+// Azure Blob Storage.
+type xmlError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// send sends req with client, turning error responses into errors and
+// those of missing files into ErrNotFound.
+func send(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body xmlError
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if resp.StatusCode == http.StatusNotFound && (body.Code == "" || body.Code == "NoSuchKey" || body.Code == "BlobNotFound") {
+		return nil, ErrNotFound
+	}
+	if body.Code != "" {
+		return nil, fmt.Errorf("%s %s failed with status %d: %s: %s", req.Method, req.URL.Redacted(), resp.StatusCode, body.Code, body.Message)
+	}
+	return nil, fmt.Errorf("%s %s failed with status %d", req.Method, req.URL.Redacted(), resp.StatusCode)
+}
+
+// uriEncode percent-encodes every byte of s but the unreserved characters
+// of RFC 3986, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns query sorted by name, then value, and encoded the
+// way the signatures of S3 and Cloud Storage expect.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// checkExpiry checks that signed URLs may be valid for expiry.
+func checkExpiry(expiry time.Duration) error {
+	if expiry <= 0 || expiry > MaxSignedURLExpiry {
+		return fmt.Errorf("signed URLs must expire within %v", MaxSignedURLExpiry)
+	}
+	return nil
+}
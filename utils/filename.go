@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnsafePath is returned by SafeJoin for names that would escape their
+// directory.
+var ErrUnsafePath = errors.New("path escapes its directory")
+
+// maxFilenameBytes leaves room for an extension and a temporary suffix
+// within the 255 bytes most file systems allow a name.
+const maxFilenameBytes = 200
+
+// reservedFilenames are the device names Windows won't create files under,
+// with any extension.
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SafeFilename turns name, such as a song's title and artist, into a single
+// file name that is valid on every platform. Path separators and the other
+// characters Windows forbids become underscores, control and invisible
+// formatting characters such as right-to-left overrides are dropped, and
+// leading and trailing dots and spaces are trimmed, so the result can't be
+// "." or "..". Long names are cut short. An empty result becomes "_".
+func SafeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case strings.ContainsRune(`/\<>:"|?*`, r):
+			b.WriteRune('_')
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = strings.Trim(b.String(), ". ")
+
+	if len(name) > maxFilenameBytes {
+		cut := maxFilenameBytes
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = strings.TrimRight(name[:cut], ". ")
+	}
+
+	base, _, _ := strings.Cut(name, ".")
+	if reservedFilenames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// SafeJoin joins name, a slash-separated relative path, to dir. It fails
+// with ErrUnsafePath if the result would be outside dir, as with names
+// such as "../x" or "/etc/x".
+func SafeJoin(dir, name string) (string, error) {
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return filepath.Join(dir, local), nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSafeFilename(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Song - Artist", "Song - Artist"},
+		{"AC/DC", "AC_DC"},
+		{`a\b:c*d?e"f<g>h|i`, "a_b_c_d_e_f_g_h_i"},
+		{"..", "_"},
+		{".", "_"},
+		{"", "_"},
+		{" . hidden . ", "hidden"},
+		{"../../etc/passwd", "_.._etc_passwd"},
+		{"evil\u202egnp.exe", "evilgnp.exe"},
+		{"tab\there\x00", "tabhere"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"CONSOLE", "CONSOLE"},
+		{"bad\xffutf8", "bad_utf8"},
+	}
+	for _, tt := range tests {
+		if got := SafeFilename(tt.name); got != tt.want {
+			t.Errorf("SafeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSafeFilenameCutsLongNames(t *testing.T) {
+	for _, name := range []string{strings.Repeat("a", 300), strings.Repeat("é", 150), strings.Repeat("a", 199) + "é"} {
+		got := SafeFilename(name)
+		if len(got) > maxFilenameBytes || !utf8.ValidString(got) {
+			t.Errorf("SafeFilename of %d bytes = %d bytes, valid UTF-8 %v", len(name), len(got), utf8.ValidString(got))
+		}
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := filepath.FromSlash("/srv/songs")
+	tests := []struct {
+		name string
+		want string // empty if unsafe
+	}{
+		{"a.wav", "/srv/songs/a.wav"},
+		{"ab/cd.wav", "/srv/songs/ab/cd.wav"},
+		{"ab/../cd.wav", "/srv/songs/cd.wav"},
+		{"../x", ""},
+		{"ab/../../x", ""},
+		{"/etc/passwd", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := SafeJoin(dir, tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("SafeJoin(%q) = %q, %v, want ErrUnsafePath", tt.name, got, err)
+			}
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); err != nil || got != want {
+			t.Errorf("SafeJoin(%q) = %q, %v, want %q", tt.name, got, err, want)
+		}
+	}
+}
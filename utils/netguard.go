@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// blockedPrefixes are the networks BlockedIP blocks besides the loopback,
+// private, link-local and multicast ones net.IP knows of: the cloud
+// metadata services live in these.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// BlockedIP reports whether ip isn't a public address, so requests made on
+// behalf of users mustn't reach it.
+func BlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// GuardedDialer connects to the addresses requests resolve to, refusing
+// those BlockedIP blocks. It checks the address it connects to, after
+// resolving, so rebinding DNS can't get around it. Proxies are exempt,
+// since they are set by the operator and reached for every request.
+type GuardedDialer struct {
+	dialer  *net.Dialer
+	proxies sync.Map // host:port of the proxies used
+}
+
+// NewGuardedDialer returns a dialer failing with the error refused returns
+// for the hosts of blocked addresses, or refusing none if refused is nil.
+func NewGuardedDialer(refused func(host string) error) *GuardedDialer {
+	d := &GuardedDialer{dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}}
+	if refused != nil {
+		d.dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || BlockedIP(ip) {
+				return refused(host)
+			}
+			return nil
+		}
+	}
+	return d
+}
+
+// Proxy wraps the proxy function of a transport to record the proxies it
+// picks, so they can be dialed.
+func (d *GuardedDialer) Proxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxy, err := next(req)
+		if proxy != nil {
+			port := proxy.Port()
+			if port == "" {
+				port = map[string]string{"http": "80", "https": "443", "socks5": "1080"}[proxy.Scheme]
+			}
+			d.proxies.Store(net.JoinHostPort(proxy.Hostname(), port), true)
+		}
+		return proxy, err
+	}
+}
+
+func (d *GuardedDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if _, ok := d.proxies.Load(address); ok {
+		return (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext(ctx, network, address)
+	}
+	return d.dialer.DialContext(ctx, network, address)
+}
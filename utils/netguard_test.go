@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"8.8.8.8", false},
+		{"2606:4700:4700::1111", false},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"224.0.0.1", true},
+		{"100.64.0.1", true}, // carrier-grade NAT
+		{"198.18.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:8.8.8.8", false},
+		{"64:ff9b::a9fe:a9fe", true}, // NAT64 of 169.254.169.254
+	}
+	for _, tt := range tests {
+		if got := BlockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("BlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
+
+var errRefused = errors.New("refused")
+
+func TestGuardedDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	address := listener.Addr().String()
+	refuse := func(string) error { return errRefused }
+
+	tests := []struct {
+		name    string
+		dialer  *GuardedDialer
+		proxy   bool
+		refused bool
+	}{
+		{"refusing", NewGuardedDialer(refuse), false, true},
+		{"refusing nothing", NewGuardedDialer(nil), false, false},
+		{"to a proxy", NewGuardedDialer(refuse), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.proxy {
+				proxy := tt.dialer.Proxy(func(*http.Request) (*url.URL, error) {
+					return &url.URL{Scheme: "http", Host: address}, nil
+				})
+				if _, err := proxy(&http.Request{URL: &url.URL{Scheme: "http", Host: "example.com"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			conn, err := tt.dialer.DialContext(context.Background(), "tcp", address)
+			if conn != nil {
+				conn.Close()
+			}
+			if refused := errors.Is(err, errRefused); refused != tt.refused {
+				t.Errorf("dialing %s: %v, want refused %v", address, err, tt.refused)
+			}
+			if !tt.refused && err != nil {
+				t.Errorf("dialing %s: %v", address, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"math/rand"
 	"os"
 	"time"
@@ -17,6 +19,18 @@ func GenerateSongKey(songTitle, songArtist string) string {
 	return songTitle + "---" + songArtist
 }
 
+// AudioChecksum returns the hex SHA-256 of samples quantized to 16-bit
+// PCM, so identical audio hashes the same however it was encoded on disk.
+func AudioChecksum(samples []float64) (string, error) {
+	pcm, err := FloatsToBytes(samples, 16)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(pcm)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func GetEnv(key string, fallback ...string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
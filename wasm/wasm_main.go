@@ -32,7 +32,7 @@ func generateFingerprint(this js.Value, args []js.Value) interface{} {
 		audioData[i] = inputArray.Index(i).Float()
 	}
 
-	spectrogram, err := shazam.Spectrogram(audioData, sampleRate)
+	spectrogram, err := shazam.Spectrogram(shazam.NormalizeClip(audioData, sampleRate), sampleRate)
 	if err != nil {
 		return js.ValueOf(map[string]interface{}{
 			"error": 3,
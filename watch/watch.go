@@ -0,0 +1,258 @@
+// Package watch polls YouTube channels, such as those of record labels, for
+// new uploads and queues each as a song to be fingerprinted, so the library
+// keeps up with them without anyone submitting the songs.
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"song-recognition/config"
+	"song-recognition/db"
+	"song-recognition/song"
+	"song-recognition/utils"
+	"time"
+
+	"github.com/mdobak/go-xerrors"
+)
+
+// FeedURL is the Atom feed of the latest uploads of a channel, given its ID
+// as the channel_id parameter.
+const FeedURL = "https://www.youtube.com/feeds/videos.xml"
+
+// Watcher polls channels for uploads it hasn't seen.
+type Watcher struct {
+	Channels []config.Channel
+	Interval time.Duration
+	// StatePath is the file the uploads seen are saved to, so a restart
+	// doesn't queue them again.
+	StatePath string
+	// FeedURL is that of YouTube, and overridable for tests.
+	FeedURL string
+	Client  *http.Client
+}
+
+// New returns a watcher of the channels of cfg.
+func New(cfg config.Watch) *Watcher {
+	return &Watcher{
+		Channels:  cfg.Channels,
+		Interval:  time.Duration(cfg.IntervalMinutes) * time.Minute,
+		StatePath: cfg.StatePath,
+		FeedURL:   FeedURL,
+		Client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// RunConfigured watches the channels of the config file until ctx is done.
+// It returns at once if there are none.
+func RunConfigured(ctx context.Context) {
+	cfg := config.Get().Watch
+	if len(cfg.Channels) == 0 {
+		return
+	}
+	New(cfg).Run(ctx)
+}
+
+// Run polls every channel once per interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	logger := utils.GetLogger()
+
+	for {
+		queued, err := w.Poll(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "Error polling YouTube channels", slog.Any("error", xerrors.New(err)))
+		} else if queued > 0 {
+			logger.InfoContext(ctx, "Queued new YouTube uploads", slog.Int("count", queued))
+		}
+
+		select {
+		case <-time.After(w.Interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Poll checks every channel for uploads once, queues those not seen before
+// and returns how many were queued. A channel polled for the first time
+// only has its uploads marked as seen, unless it is set to backfill. A
+// channel that fails is logged and retried on the next poll.
+func (w *Watcher) Poll(ctx context.Context) (int, error) {
+	logger := utils.GetLogger()
+
+	state, err := loadState(w.StatePath)
+	if err != nil {
+		return 0, err
+	}
+
+	dbClient, err := db.NewDBClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating DB client: %v", err)
+	}
+	defer dbClient.Close()
+
+	queued := 0
+	for _, channel := range w.Channels {
+		n, err := w.pollChannel(ctx, dbClient, state, channel)
+		queued += n
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "Error polling YouTube channel", slog.String("channel", channelName(channel)), slog.Any("error", xerrors.New(err)))
+		}
+	}
+
+	if err := state.save(w.StatePath); err != nil {
+		return queued, fmt.Errorf("failed to save watched uploads: %v", err)
+	}
+	return queued, nil
+}
+
+// pollChannel queues the uploads of channel not in state.
+func (w *Watcher) pollChannel(ctx context.Context, dbClient db.DBClient, state *state, channel config.Channel) (int, error) {
+	entries, err := w.uploads(ctx, channel.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	seen, known := state.Seen[channel.ID]
+	if !known {
+		seen = map[string]string{}
+		state.Seen[channel.ID] = seen
+	}
+
+	queued := 0
+	for _, entry := range entries {
+		if _, ok := seen[entry.VideoID]; ok {
+			continue
+		}
+		if !known && !channel.Backfill {
+			seen[entry.VideoID] = ""
+			continue
+		}
+
+		_, exists, err := dbClient.GetSongByYTID(ctx, entry.VideoID)
+		if err != nil {
+			return queued, fmt.Errorf("error checking YT ID existence: %v", err)
+		}
+		if exists {
+			seen[entry.VideoID] = ""
+			continue
+		}
+
+		title, artist := song.TitleArtistFromVideo(entry.Title, entry.Author)
+		jobID, err := song.EnqueueSong(ctx, &song.SongInput{Title: title, Artist: artist, YoutubeID: entry.VideoID})
+		if err != nil {
+			return queued, fmt.Errorf("error queueing video %s: %v", entry.VideoID, err)
+		}
+		seen[entry.VideoID] = jobID
+		queued++
+	}
+
+	return queued, nil
+}
+
+// feed is the Atom feed of a channel's uploads.
+type feed struct {
+	Entries []entry `xml:"entry"`
+}
+
+type entry struct {
+	VideoID string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	Title   string `xml:"title"`
+	Author  string `xml:"author>name"`
+}
+
+// uploads returns the latest uploads of the channel channelID, newest
+// first.
+func (w *Watcher) uploads(ctx context.Context, channelID string) ([]entry, error) {
+	feedURL := w.FeedURL + "?" + url.Values{"channel_id": {channelID}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch channel feed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("channel feed responded with %s", resp.Status)
+	}
+
+	var uploads feed
+	if err := xml.NewDecoder(resp.Body).Decode(&uploads); err != nil {
+		return nil, fmt.Errorf("failed to parse channel feed: %v", err)
+	}
+	return uploads.Entries, nil
+}
+
+// channelName names channel in logs.
+func channelName(channel config.Channel) string {
+	if channel.Name != "" {
+		return channel.Name
+	}
+	return channel.ID
+}
+
+// state is the uploads seen of every channel, kept in a file.
+type state struct {
+	// Seen maps channel IDs to their uploads seen, each with the ID of the
+	// job it was queued as, or empty if it wasn't.
+	Seen map[string]map[string]string `json:"seen"`
+}
+
+// loadState reads the uploads seen saved at path, or starts anew if there
+// is none.
+func loadState(path string) (*state, error) {
+	s := &state{Seen: map[string]map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watched uploads: %v", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse watched uploads %s: %v", path, err)
+	}
+	if s.Seen == nil {
+		s.Seen = map[string]map[string]string{}
+	}
+	return s, nil
+}
+
+// save writes s to path.
+func (s *state) save(path string) error {
+	if err := utils.CreateFolder(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Written next to the file first so a crash never leaves half of it
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
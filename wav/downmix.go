@@ -0,0 +1,105 @@
+package wav
+
+import "math"
+
+// Speaker position bits used in the channel mask of WAVE_FORMAT_EXTENSIBLE.
+const (
+	speakerFrontLeft    = 0x1
+	speakerFrontRight   = 0x2
+	speakerFrontCenter  = 0x4
+	speakerLowFrequency = 0x8
+)
+
+// Default channel masks for files that don't declare one, following the
+// WAVE_FORMAT_EXTENSIBLE ordering.
+var defaultChannelMasks = map[int]uint32{
+	1: 0x4,   // mono (front center)
+	2: 0x3,   // stereo
+	3: 0x7,   // L R C
+	4: 0x33,  // quad: FL FR BL BR
+	5: 0x37,  // FL FR FC BL BR
+	6: 0x3F,  // 5.1: FL FR FC LFE BL BR
+	7: 0x70F, // 6.1: FL FR FC LFE BC SL SR
+	8: 0x63F, // 7.1: FL FR FC LFE BL BR SL SR
+}
+
+// ChannelWeights returns the mono downmix coefficient of each channel.
+// Front left/right are kept at full level, the LFE channel is dropped and
+// every other speaker is mixed at -3 dB, as in ITU-R BS.775. The weights
+// are normalized to sum to one so that the mono signal stays in [-1, 1].
+// A zero channelMask selects the default layout for the channel count.
+func ChannelWeights(channels int, channelMask uint32) []float64 {
+	weights := make([]float64, channels)
+	if channels <= 0 {
+		return weights
+	}
+
+	if channelMask == 0 {
+		channelMask = defaultChannelMasks[channels]
+	}
+
+	// Walk the mask bits in order; each set bit is the next channel
+	ch := 0
+	for bit := uint32(1); bit != 0 && ch < channels; bit <<= 1 {
+		if channelMask&bit == 0 {
+			continue
+		}
+		switch bit {
+		case speakerFrontLeft, speakerFrontRight:
+			weights[ch] = 1
+		case speakerLowFrequency:
+			weights[ch] = 0
+		default:
+			weights[ch] = math.Sqrt2 / 2
+		}
+		ch++
+	}
+
+	// Channels beyond the mask (or an unknown layout) are mixed equally
+	for ; ch < channels; ch++ {
+		weights[ch] = 1
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 {
+		for i := range weights {
+			weights[i] = 1 / float64(channels)
+		}
+		return weights
+	}
+
+	for i := range weights {
+		weights[i] /= sum
+	}
+	return weights
+}
+
+// Downmix collapses interleaved samples with the given channel count to
+// mono using the default speaker layout for that count.
+func Downmix(interleaved []float64, channels int) []float64 {
+	return DownmixWeighted(interleaved, ChannelWeights(channels, 0))
+}
+
+// DownmixWeighted collapses interleaved samples to mono, weighting each
+// channel with the matching entry of weights.
+func DownmixWeighted(interleaved []float64, weights []float64) []float64 {
+	channels := len(weights)
+	if channels <= 1 {
+		return interleaved
+	}
+
+	mono := make([]float64, len(interleaved)/channels)
+	for i := range mono {
+		frame := interleaved[i*channels : (i+1)*channels]
+		var sum float64
+		for c, w := range weights {
+			sum += frame[c] * w
+		}
+		mono[i] = sum
+	}
+
+	return mono
+}
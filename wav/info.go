@@ -0,0 +1,205 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Info is the RIFF INFO metadata of a WAV file, so a song file still says
+// what it is without the database.
+type Info struct {
+	Title  string // INAM
+	Artist string // IART
+	// Source is where the song came from, such as the URL it was
+	// downloaded from. It is stored as ISRC, the RIFF "source" field.
+	Source string
+	// SongID is the ID the song is registered under, stored in the ICMT
+	// comment as "seek-tune song <id>". Zero if unknown.
+	SongID uint32
+}
+
+// songIDComment prefixes the song ID in the ICMT field.
+const songIDComment = "seek-tune song "
+
+// fields returns the INFO subchunks of info that are set, in order.
+func (info Info) fields() [][2]string {
+	var fields [][2]string
+	add := func(id, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{id, value})
+		}
+	}
+	add("INAM", info.Title)
+	add("IART", info.Artist)
+	add("ISRC", info.Source)
+	if info.SongID != 0 {
+		add("ICMT", songIDComment+strconv.FormatUint(uint64(info.SongID), 10))
+	}
+	return fields
+}
+
+// encodeInfo returns the LIST chunk holding info.
+func encodeInfo(info Info) []byte {
+	var body bytes.Buffer
+	body.WriteString("INFO")
+	for _, field := range info.fields() {
+		value := append([]byte(field[1]), 0)
+		body.WriteString(field[0])
+		binary.Write(&body, binary.LittleEndian, uint32(len(value)))
+		body.Write(value)
+		if len(value)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var chunk bytes.Buffer
+	chunk.WriteString("LIST")
+	binary.Write(&chunk, binary.LittleEndian, uint32(body.Len()))
+	chunk.Write(body.Bytes())
+	return chunk.Bytes()
+}
+
+// riffChunk is the position of a chunk in a RIFF file.
+type riffChunk struct {
+	id     string
+	offset int64 // of the chunk's header
+	size   int64 // of the chunk's data, without padding
+}
+
+// readChunks lists the top-level chunks of the WAV file f.
+func readChunks(f io.ReadSeeker) ([]riffChunk, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil || string(riff[:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("invalid WAV header format")
+	}
+
+	var chunks []riffChunk
+	offset := int64(len(riff))
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			break
+		}
+		chunk := riffChunk{string(header[:4]), offset, int64(binary.LittleEndian.Uint32(header[4:]))}
+		chunks = append(chunks, chunk)
+
+		offset += 8 + chunk.size + chunk.size%2
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// WriteInfo stores info in the WAV file at path, after its audio data.
+// Anything that followed the audio data before is replaced.
+func WriteInfo(path string, info Info) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := readChunks(f)
+	if err != nil {
+		return err
+	}
+
+	end := int64(-1)
+	for _, chunk := range chunks {
+		if chunk.id == "data" {
+			end = chunk.offset + 8 + chunk.size + chunk.size%2
+		}
+	}
+	if end < 0 {
+		return errors.New("WAV file has no data chunk")
+	}
+
+	// A data chunk of odd size may lack its padding byte
+	if err := f.Truncate(end); err != nil {
+		return fmt.Errorf("failed to truncate WAV file: %v", err)
+	}
+	if _, err := f.WriteAt(encodeInfo(info), end); err != nil {
+		return fmt.Errorf("failed to write WAV info: %v", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(stat.Size()-8))
+	if _, err := f.WriteAt(size[:], 4); err != nil {
+		return fmt.Errorf("failed to update WAV size: %v", err)
+	}
+
+	return nil
+}
+
+// ReadInfo returns the RIFF INFO metadata of the WAV file at path, empty if
+// it has none.
+func ReadInfo(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	chunks, err := readChunks(f)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	for _, chunk := range chunks {
+		if chunk.id != "LIST" || chunk.size < 4 {
+			continue
+		}
+		body := make([]byte, chunk.size)
+		if _, err := f.ReadAt(body, chunk.offset+8); err != nil {
+			return Info{}, fmt.Errorf("failed to read WAV LIST chunk: %v", err)
+		}
+		if string(body[:4]) == "INFO" {
+			parseInfo(body[4:], &info)
+		}
+	}
+	return info, nil
+}
+
+// parseInfo reads the subchunks of a LIST INFO chunk into info.
+func parseInfo(body []byte, info *Info) {
+	for len(body) >= 8 {
+		id := string(body[:4])
+		size := int(binary.LittleEndian.Uint32(body[4:8]))
+		if size > len(body)-8 {
+			return
+		}
+		value := strings.TrimRight(string(body[8:8+size]), "\x00")
+		body = body[min(8+size+size%2, len(body)):]
+
+		switch id {
+		case "INAM":
+			info.Title = value
+		case "IART":
+			info.Artist = value
+		case "ISRC":
+			info.Source = value
+		case "ICMT":
+			if id, ok := strings.CutPrefix(value, songIDComment); ok {
+				if songID, err := strconv.ParseUint(id, 10, 32); err == nil {
+					info.SongID = uint32(songID)
+				}
+			}
+		}
+	}
+}
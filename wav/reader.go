@@ -0,0 +1,233 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WAV audio format codes found in the fmt chunk.
+const (
+	WaveFormatPCM        uint16 = 0x0001
+	WaveFormatIEEEFloat  uint16 = 0x0003
+	WaveFormatExtensible uint16 = 0xFFFE
+)
+
+// maxFmtChunkSize is the size of the fmt chunk of WAVE_FORMAT_EXTENSIBLE,
+// the largest one read.
+const maxFmtChunkSize = 40
+
+// Reader decodes PCM samples from a WAV stream incrementally, so that long
+// recordings can be processed without loading the whole file into memory.
+type Reader struct {
+	r io.Reader
+
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	DataSize      int64 // size of the data chunk in bytes
+	DataOffset    int64 // offset of the data chunk from the start of the stream
+	ChannelMask   uint32
+
+	remaining int64
+	buf       []byte
+	weights   []float64
+}
+
+// NewReader parses the RIFF header of r and positions it at the start of
+// the sample data. Chunks other than "fmt " and "data" are skipped.
+func NewReader(r io.Reader) (*Reader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, errors.New("invalid WAV file size (too small)")
+	}
+
+	if string(riff[:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("invalid WAV header format")
+	}
+
+	reader := &Reader{r: r}
+	offset := int64(len(riff))
+	sawFmt := false
+
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, errors.New("WAV file has no data chunk")
+		}
+		offset += int64(len(chunkHeader))
+
+		chunkID := string(chunkHeader[:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+
+		switch chunkID {
+		case "fmt ":
+			if chunkSize < 16 {
+				return nil, errors.New("invalid WAV fmt chunk")
+			}
+			// The size comes from the file, so only the fields used here
+			// are read and any extension bytes are skipped
+			fmtChunk := make([]byte, min(chunkSize, maxFmtChunkSize))
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, fmt.Errorf("failed to read WAV fmt chunk: %v", err)
+			}
+			if _, err := io.CopyN(io.Discard, r, chunkSize-int64(len(fmtChunk))); err != nil {
+				return nil, fmt.Errorf("failed to skip WAV fmt chunk: %v", err)
+			}
+			reader.AudioFormat = binary.LittleEndian.Uint16(fmtChunk[0:2])
+			reader.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			reader.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			reader.BitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+
+			// WAVE_FORMAT_EXTENSIBLE stores the real format code at the
+			// start of the SubFormat GUID, and the speaker layout in the mask
+			if reader.AudioFormat == WaveFormatExtensible && chunkSize >= 26 {
+				reader.ChannelMask = binary.LittleEndian.Uint32(fmtChunk[20:24])
+				reader.AudioFormat = binary.LittleEndian.Uint16(fmtChunk[24:26])
+			}
+			sawFmt = true
+
+		case "data":
+			if !sawFmt {
+				return nil, errors.New("WAV data chunk precedes fmt chunk")
+			}
+			if err := reader.validate(); err != nil {
+				return nil, err
+			}
+			reader.weights = ChannelWeights(reader.Channels, reader.ChannelMask)
+			reader.DataSize = chunkSize
+			reader.DataOffset = offset
+			reader.remaining = chunkSize
+			return reader, nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, chunkSize); err != nil {
+				return nil, fmt.Errorf("failed to skip WAV %q chunk: %v", chunkID, err)
+			}
+		}
+
+		offset += chunkSize
+
+		// Chunks are word aligned
+		if chunkSize%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return nil, errors.New("WAV file has no data chunk")
+			}
+			offset++
+		}
+	}
+}
+
+func (r *Reader) validate() error {
+	if r.Channels < 1 || r.SampleRate < 1 {
+		return errors.New("invalid WAV channel count or sample rate")
+	}
+	return validateSampleFormat(r.BitsPerSample, r.AudioFormat)
+}
+
+// validateSampleFormat checks that samples of the given width and format
+// code can be converted to floats.
+func validateSampleFormat(bitsPerSample int, audioFormat uint16) error {
+	switch audioFormat {
+	case WaveFormatPCM:
+		switch bitsPerSample {
+		case 8, 16, 24, 32:
+			return nil
+		}
+	case WaveFormatIEEEFloat:
+		switch bitsPerSample {
+		case 32, 64:
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported WAV audio format: %#04x", audioFormat)
+	}
+
+	return fmt.Errorf("unsupported bits per sample format: %d", bitsPerSample)
+}
+
+// decodeSample converts one little-endian sample to a float in [-1, 1].
+func decodeSample(b []byte, bitsPerSample int, audioFormat uint16) float64 {
+	if audioFormat == WaveFormatIEEEFloat {
+		if bitsPerSample == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+
+	switch bitsPerSample {
+	case 8:
+		// 8-bit PCM is unsigned
+		return (float64(b[0]) - 128) / 128.0
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768.0
+	case 24:
+		// Sign-extend the 24-bit value through the top byte of an int32
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float64(v) / 8388608.0
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648.0
+	}
+}
+
+// Duration returns the length of the audio in seconds.
+func (r *Reader) Duration() float64 {
+	bytesPerFrame := r.Channels * r.BitsPerSample / 8
+	return float64(r.DataSize) / float64(bytesPerFrame*r.SampleRate)
+}
+
+// ReadSamples fills dst with mono samples scaled to [-1, 1], downmixing the
+// channels of each frame according to the speaker layout. It returns the
+// number of samples read and io.EOF once the data chunk is exhausted.
+func (r *Reader) ReadSamples(dst []float64) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	bytesPerSample := r.BitsPerSample / 8
+	bytesPerFrame := r.Channels * bytesPerSample
+
+	want := int64(len(dst) * bytesPerFrame)
+	if want > r.remaining {
+		want = r.remaining - r.remaining%int64(bytesPerFrame)
+	}
+	if want == 0 {
+		r.remaining = 0
+		return 0, io.EOF
+	}
+
+	if int64(cap(r.buf)) < want {
+		r.buf = make([]byte, want)
+	}
+	buf := r.buf[:want]
+
+	n, err := io.ReadFull(r.r, buf)
+	r.remaining -= int64(n)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// Truncated file: use what we got and stop
+		r.remaining = 0
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	frames := n / bytesPerFrame
+	for i := 0; i < frames; i++ {
+		var sum float64
+		for c := 0; c < r.Channels; c++ {
+			offset := i*bytesPerFrame + c*bytesPerSample
+			sum += r.weights[c] * decodeSample(buf[offset:offset+bytesPerSample], r.BitsPerSample, r.AudioFormat)
+		}
+		dst[i] = sum
+	}
+
+	if frames == 0 {
+		return 0, io.EOF
+	}
+
+	return frames, nil
+}
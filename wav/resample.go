@@ -0,0 +1,171 @@
+package wav
+
+import (
+	"errors"
+	"math"
+)
+
+// StandardSampleRate is the sample rate the fingerprinting pipeline works at.
+// Audio at any other rate is resampled to it.
+const StandardSampleRate = 44100
+
+const (
+	// resampleZeroCrossings is the number of sinc zero crossings on each
+	// side of the interpolation point.
+	resampleZeroCrossings = 16
+	// resampleTableDensity is the number of kernel values stored per zero
+	// crossing; values in between are linearly interpolated.
+	resampleTableDensity = 512
+)
+
+// resampleKernel holds one side of a Blackman-windowed sinc, sampled at
+// resampleTableDensity points per zero crossing.
+var resampleKernel = func() []float64 {
+	n := resampleZeroCrossings * resampleTableDensity
+	kernel := make([]float64, n+1)
+	for i := range kernel {
+		x := float64(i) / resampleTableDensity // distance in zero crossings
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.42 + 0.5*math.Cos(math.Pi*x/resampleZeroCrossings) +
+			0.08*math.Cos(2*math.Pi*x/resampleZeroCrossings)
+		kernel[i] = sinc * window
+	}
+	return kernel
+}()
+
+// Resample converts mono samples from fromRate to toRate using band-limited
+// windowed-sinc interpolation. When downsampling, the kernel is widened so
+// that it also acts as an anti-aliasing low-pass filter.
+func Resample(samples []float64, fromRate, toRate int) ([]float64, error) {
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, errors.New("sample rates must be positive")
+	}
+	if fromRate == toRate {
+		return samples, nil
+	}
+
+	ratio := float64(toRate) / float64(fromRate)
+	cutoff := math.Min(1, ratio) // relative to the input Nyquist frequency
+
+	// Half-width of the kernel in input samples
+	halfWidth := float64(resampleZeroCrossings) / cutoff
+
+	outLen := int(math.Floor(float64(len(samples)) * ratio))
+	output := make([]float64, outLen)
+
+	for n := range output {
+		t := float64(n) / ratio // position in input samples
+		output[n] = interpolate(samples, 0, len(samples), t, halfWidth, cutoff)
+	}
+
+	return output, nil
+}
+
+// interpolate returns the output sample at position t of the input, in
+// input samples. samples holds the input from index offset on, and the
+// kernel is cut off at the start of the input and at index end.
+func interpolate(samples []float64, offset, end int, t, halfWidth, cutoff float64) float64 {
+	first := int(math.Ceil(t - halfWidth))
+	last := int(math.Floor(t + halfWidth))
+	if first < 0 {
+		first = 0
+	}
+	if last >= end {
+		last = end - 1
+	}
+
+	var sum float64
+	for k := first; k <= last; k++ {
+		sum += samples[k-offset] * kernelAt(math.Abs(t-float64(k))*cutoff)
+	}
+	return sum * cutoff
+}
+
+// Resampler converts a stream of mono samples from one rate to another a
+// block at a time, with the same result as Resample on the whole stream:
+// the samples the kernel reaches across a block boundary are kept for the
+// next block, and output positions are counted from the start of the
+// stream, so no fraction of a sample is lost at block ends.
+type Resampler struct {
+	ratio     float64
+	cutoff    float64
+	halfWidth float64
+
+	history []float64 // input still in reach of the kernel
+	offset  int       // index in the stream of history[0]
+	next    int       // index of the next output sample
+}
+
+// NewResampler returns a Resampler from fromRate to toRate.
+func NewResampler(fromRate, toRate int) (*Resampler, error) {
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, errors.New("sample rates must be positive")
+	}
+
+	ratio := float64(toRate) / float64(fromRate)
+	cutoff := math.Min(1, ratio)
+	return &Resampler{
+		ratio:     ratio,
+		cutoff:    cutoff,
+		halfWidth: float64(resampleZeroCrossings) / cutoff,
+	}, nil
+}
+
+// Resample adds block to the input and returns the output the kernel can
+// be computed for so far. The rest is returned by later calls or by Flush.
+func (r *Resampler) Resample(block []float64) []float64 {
+	r.history = append(r.history, block...)
+	end := r.offset + len(r.history)
+
+	var output []float64
+	for {
+		t := float64(r.next) / r.ratio
+		if int(math.Floor(t+r.halfWidth)) >= end {
+			break
+		}
+		output = append(output, interpolate(r.history, r.offset, end, t, r.halfWidth, r.cutoff))
+		r.next++
+	}
+
+	r.trim()
+	return output
+}
+
+// Flush returns the output left at the end of the stream, with the kernel
+// cut off at its last sample.
+func (r *Resampler) Flush() []float64 {
+	end := r.offset + len(r.history)
+	outLen := int(math.Floor(float64(end) * r.ratio))
+
+	var output []float64
+	for ; r.next < outLen; r.next++ {
+		t := float64(r.next) / r.ratio
+		output = append(output, interpolate(r.history, r.offset, end, t, r.halfWidth, r.cutoff))
+	}
+
+	r.trim()
+	return output
+}
+
+// trim drops the input the kernel of the next output no longer reaches.
+func (r *Resampler) trim() {
+	first := int(math.Ceil(float64(r.next)/r.ratio - r.halfWidth))
+	if drop := min(first-r.offset, len(r.history)); drop > 0 {
+		r.history = append(r.history[:0], r.history[drop:]...)
+		r.offset += drop
+	}
+}
+
+// kernelAt returns the windowed sinc at x zero crossings from its centre.
+func kernelAt(x float64) float64 {
+	pos := x * resampleTableDensity
+	i := int(pos)
+	if i >= len(resampleKernel)-1 {
+		return 0
+	}
+	frac := pos - float64(i)
+	return resampleKernel[i] + frac*(resampleKernel[i+1]-resampleKernel[i])
+}
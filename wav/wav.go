@@ -8,10 +8,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -38,7 +39,7 @@ type WavHeader struct {
 	Subchunk2Size uint32
 }
 
-func writeWavHeader(f *os.File, data []byte, sampleRate int, channels int, bitsPerSample int) error {
+func writeWavHeader(w io.Writer, data []byte, sampleRate int, channels int, bitsPerSample int) error {
 	// Validate input
 	if len(data)%channels != 0 {
 		return errors.New("data size not divisible by channels")
@@ -68,7 +69,7 @@ func writeWavHeader(f *os.File, data []byte, sampleRate int, channels int, bitsP
 	}
 
 	// Write header to file
-	err := binary.Write(f, binary.LittleEndian, header)
+	err := binary.Write(w, binary.LittleEndian, header)
 	return err
 }
 
@@ -79,6 +80,11 @@ func WriteWavFile(filename string, data []byte, sampleRate int, channels int, bi
 	}
 	defer f.Close()
 
+	return WriteWav(f, data, sampleRate, channels, bitsPerSample)
+}
+
+// WriteWav writes PCM data to w as a WAV file.
+func WriteWav(w io.Writer, data []byte, sampleRate int, channels int, bitsPerSample int) error {
 	if sampleRate <= 0 || channels <= 0 || bitsPerSample <= 0 {
 		return fmt.Errorf(
 			"values must be greater than zero (sampleRate: %d, channels: %d, bitsPerSample: %d)",
@@ -86,57 +92,59 @@ func WriteWavFile(filename string, data []byte, sampleRate int, channels int, bi
 		)
 	}
 
-	err = writeWavHeader(f, data, sampleRate, channels, bitsPerSample)
+	err := writeWavHeader(w, data, sampleRate, channels, bitsPerSample)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write(data)
+	_, err = w.Write(data)
 	return err
 }
 
 // WavInfo defines a struct containing information extracted from the WAV header
 type WavInfo struct {
-	Channels   int
-	SampleRate int
-	Data       []byte
-	Duration   float64
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	AudioFormat   uint16
+	ChannelMask   uint32
+	Data          []byte
+	Duration      float64
 }
 
+// ReadWavInfo reads the header and sample data of the WAV file at
+// filename, leaving out its other chunks.
 func ReadWavInfo(filename string) (*WavInfo, error) {
-	data, err := ioutil.ReadFile(filename)
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	if len(data) < 44 {
-		return nil, errors.New("invalid WAV file size (too small)")
-	}
-
-	// Read header chunks
-	var header WavHeader
-	err = binary.Read(bytes.NewReader(data[:44]), binary.LittleEndian, &header)
+	reader, err := NewReader(file)
 	if err != nil {
 		return nil, err
 	}
 
-	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" || header.AudioFormat != 1 {
-		return nil, errors.New("invalid WAV header format")
+	// The data chunk of a truncated file ends with the file
+	data, err := io.ReadAll(io.LimitReader(file, reader.DataSize))
+	if err != nil {
+		return nil, err
 	}
 
 	// Extract information
 	info := &WavInfo{
-		Channels:   int(header.NumChannels),
-		SampleRate: int(header.SampleRate),
-		Data:       data[44:],
+		Channels:      reader.Channels,
+		SampleRate:    reader.SampleRate,
+		BitsPerSample: reader.BitsPerSample,
+		AudioFormat:   reader.AudioFormat,
+		ChannelMask:   reader.ChannelMask,
+		Data:          data,
 	}
 
-	// Calculate audio duration (assuming data contains PCM data)
-	if header.BitsPerSample == 16 {
-		info.Duration = float64(len(info.Data)) / float64(int(header.NumChannels)*2*int(header.SampleRate))
-	} else {
-		return nil, errors.New("unsupported bits per sample format")
-	}
+	// Calculate audio duration
+	bytesPerFrame := reader.Channels * reader.BitsPerSample / 8
+	info.Duration = float64(len(info.Data)) / float64(bytesPerFrame*reader.SampleRate)
 
 	return info, nil
 }
@@ -161,6 +169,43 @@ func WavBytesToSamples(input []byte) ([]float64, error) {
 	return output, nil
 }
 
+// BytesToSamples converts WAV sample data of any supported width (8, 16, 24
+// or 32-bit PCM, or 32/64-bit float) to a slice of float64 samples in [-1, 1].
+func BytesToSamples(input []byte, bitsPerSample int, audioFormat uint16) ([]float64, error) {
+	if err := validateSampleFormat(bitsPerSample, audioFormat); err != nil {
+		return nil, err
+	}
+
+	bytesPerSample := bitsPerSample / 8
+	if len(input)%bytesPerSample != 0 {
+		return nil, errors.New("invalid input length")
+	}
+
+	output := make([]float64, len(input)/bytesPerSample)
+	for i := range output {
+		offset := i * bytesPerSample
+		output[i] = decodeSample(input[offset:offset+bytesPerSample], bitsPerSample, audioFormat)
+	}
+
+	return output, nil
+}
+
+// Samples converts the WAV data to float64 samples using its header format.
+func (info *WavInfo) Samples() ([]float64, error) {
+	return BytesToSamples(info.Data, info.BitsPerSample, info.AudioFormat)
+}
+
+// MonoSamples converts the WAV data to float64 samples and downmixes all
+// channels to a single mono stream.
+func (info *WavInfo) MonoSamples() ([]float64, error) {
+	samples, err := info.Samples()
+	if err != nil {
+		return nil, err
+	}
+
+	return DownmixWeighted(samples, ChannelWeights(info.Channels, info.ChannelMask)), nil
+}
+
 // FFmpegMetadata represents the metadata structure returned by ffprobe.
 type FFmpegMetadata struct {
 	Streams []struct {
@@ -219,32 +264,30 @@ func GetMetadata(filePath string) (FFmpegMetadata, error) {
 	return metadata, nil
 }
 
+// ProcessRecording converts a base64 PCM recording from a client to mono
+// samples at StandardSampleRate, whatever the rate and channel count the
+// client's hardware recorded at.
 func ProcessRecording(recData *models.RecordData, saveRecording bool) ([]float64, error) {
 	decodedAudioData, err := base64.StdEncoding.DecodeString(recData.Audio)
 	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
-	fileName := fmt.Sprintf("%04d_%02d_%02d_%02d_%02d_%02d.wav",
-		now.Second(), now.Minute(), now.Hour(),
-		now.Day(), now.Month(), now.Year(),
-	)
-	filePath := "tmp/" + fileName
+	if recData.Channels < 1 || recData.SampleRate <= 0 {
+		return nil, fmt.Errorf("invalid recording format (channels: %d, sampleRate: %d)",
+			recData.Channels, recData.SampleRate)
+	}
 
-	err = WriteWavFile(filePath, decodedAudioData, recData.SampleRate, recData.Channels, recData.SampleSize)
+	samples, err := BytesToSamples(decodedAudioData, recData.SampleSize, WaveFormatPCM)
 	if err != nil {
 		return nil, err
 	}
 
-	reformatedWavFile, err := ReformatWAV(filePath, 1)
+	samples, err = Resample(Downmix(samples, recData.Channels), recData.SampleRate, StandardSampleRate)
 	if err != nil {
 		return nil, err
 	}
 
-	wavInfo, _ := ReadWavInfo(reformatedWavFile)
-	samples, _ := WavBytesToSamples(wavInfo.Data)
-
 	if saveRecording {
 		logger := utils.GetLogger()
 		ctx := context.Background()
@@ -255,15 +298,20 @@ func ProcessRecording(recData *models.RecordData, saveRecording bool) ([]float64
 			logger.ErrorContext(ctx, "Failed create folder.", slog.Any("error", err))
 		}
 
-		newFilePath := strings.Replace(reformatedWavFile, "tmp/", "recordings/", 1)
-		err = os.Rename(reformatedWavFile, newFilePath)
+		now := time.Now()
+		fileName := fmt.Sprintf("%04d_%02d_%02d_%02d_%02d_%02d.wav",
+			now.Second(), now.Minute(), now.Hour(),
+			now.Day(), now.Month(), now.Year(),
+		)
+
+		pcm, err := utils.FloatsToBytes(samples, 16)
+		if err == nil {
+			err = WriteWavFile(filepath.Join("recordings", fileName), pcm, StandardSampleRate, 1, 16)
+		}
 		if err != nil {
-			logger.ErrorContext(ctx, "Failed to move file.", slog.Any("error", err))
+			logger.ErrorContext(ctx, "Failed to save recording.", slog.Any("error", err))
 		}
 	}
 
-	utils.DeleteFile(fileName)
-	utils.DeleteFile(reformatedWavFile)
-
 	return samples, nil
 }
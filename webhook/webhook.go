@@ -0,0 +1,152 @@
+// Package webhook delivers signed JSON notifications to caller-supplied
+// callback URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"song-recognition/utils"
+	"strconv"
+	"time"
+)
+
+// Headers set on every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with WEBHOOK_SECRET, so receivers can verify
+// both the sender and the freshness of the request.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// Delivery settings. Failed deliveries are retried MaxAttempts times in
+// total, waiting RetryDelay, then twice that, and so on.
+var (
+	MaxAttempts = 3
+	RetryDelay  = time.Second
+	Timeout     = 10 * time.Second
+)
+
+// AllowPrivateNetworks lets callback URLs reach loopback, private and
+// link-local addresses, such as those of cloud metadata services, which
+// are refused unless WEBHOOK_ALLOW_PRIVATE_NETWORKS is true.
+var AllowPrivateNetworks = utils.GetEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+
+// maxResponseBytes is the most of a receiver's response read before the
+// connection is dropped instead of reused.
+const maxResponseBytes = 64 << 10
+
+var client = newClient()
+
+// newClient returns the client deliveries are POSTed with, which refuses
+// to connect to private addresses unless AllowPrivateNetworks is set, even
+// after redirects or when the host resolves differently than when checked.
+func newClient() *http.Client {
+	var dialer *utils.GuardedDialer
+	if AllowPrivateNetworks {
+		dialer = utils.NewGuardedDialer(nil)
+	} else {
+		dialer = utils.NewGuardedDialer(func(host string) error {
+			return fmt.Errorf("callback URL connects to the private address %s", host)
+		})
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = dialer.Proxy(transport.Proxy)
+	return &http.Client{Transport: transport, Timeout: Timeout}
+}
+
+// ValidateURL checks that callbackURL is an absolute http(s) URL and,
+// unless AllowPrivateNetworks is set, that its host only resolves to public
+// addresses.
+func ValidateURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL: %q", callbackURL)
+	}
+	if AllowPrivateNetworks {
+		return nil
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve callback URL host: %v", err)
+	}
+	for _, ip := range ips {
+		if utils.BlockedIP(ip) {
+			return fmt.Errorf("callback URL host %s resolves to the private address %s", u.Hostname(), ip)
+		}
+	}
+	return nil
+}
+
+// Sign returns the signature of body sent at timestamp (Unix seconds).
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send POSTs payload as JSON to callbackURL, retrying on network errors and
+// non-2xx responses. Deliveries are signed when WEBHOOK_SECRET is set.
+func Send(ctx context.Context, callbackURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %v", err)
+	}
+
+	secret := utils.GetEnv("WEBHOOK_SECRET")
+	delay := RetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err = post(ctx, callbackURL, body, secret)
+		if err == nil || attempt >= MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func post(ctx context.Context, callbackURL string, body []byte, secret string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(secret), timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook receiver returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
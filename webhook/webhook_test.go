@@ -0,0 +1,19 @@
+package webhook
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret, timestamp, body string
+		want                    string
+	}{
+		{"secret", "1700000000", `{"ok":true}`, "sha256=c1afc7c2df3db0690d7d75954610ed1a1d959ce96355ccb8c0a8bc09fd0cfc27"},
+		{"key", "1700000000", "", "sha256=0f1cc1f811f42fd12af9618acf321769899fa521fe07a642f70a61785e130770"},
+		{"", "0", "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, tt := range tests {
+		if got := Sign([]byte(tt.secret), tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}